@@ -80,6 +80,10 @@ type Descriptor struct {
 	// This should only be used when referring to a manifest.
 	Platform *v1.Platform `json:"platform,omitempty"`
 
+	// ArtifactType is the type of an artifact when the descriptor points to
+	// an artifact manifest, as listed by the referrers API.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// NOTE: Before adding a field here, please ensure that all
 	// other options have been exhausted. Much of the type relationships
 	// depend on the simplicity of this type.
@@ -113,6 +117,15 @@ type BlobEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(dgst digest.Digest) error) error
 }
 
+// DigestAlgorithmProvider is implemented by the blob stores and manifest
+// services which compute the digests of new content with an algorithm other
+// than digest.Canonical.
+type DigestAlgorithmProvider interface {
+	// DigestAlgorithm returns the algorithm of the digests computed for the
+	// content put.
+	DigestAlgorithm() digest.Algorithm
+}
+
 // BlobDescriptorService manages metadata about a blob by digest. Most
 // implementations will not expose such an interface explicitly. Such mappings
 // should be maintained by interacting with the BlobIngester. Hence, this is
@@ -23,10 +23,7 @@ type Configuration struct {
 	// subsystem.
 	Log struct {
 		// AccessLog configures access logging.
-		AccessLog struct {
-			// Disabled disables access logging.
-			Disabled bool `yaml:"disabled,omitempty"`
-		} `yaml:"accesslog,omitempty"`
+		AccessLog AccessLog `yaml:"accesslog,omitempty"`
 
 		// Level is the granularity at which registry operations are logged.
 		Level Loglevel `yaml:"level,omitempty"`
@@ -107,6 +104,11 @@ type Configuration struct {
 			// A file may contain multiple CA certificates encoded as PEM
 			ClientCAs []string `yaml:"clientcas,omitempty"`
 
+			// ClientAuth is whether clients must present a certificate
+			// signed by ClientCAs, "require" by default, or may connect
+			// without one, "optional", to authenticate otherwise
+			ClientAuth string `yaml:"clientauth,omitempty"`
+
 			// Specifies the lowest TLS version allowed
 			MinimumTLS string `yaml:"minimumtls,omitempty"`
 
@@ -128,6 +130,20 @@ type Configuration struct {
 				// Hosts specifies the hosts which are allowed to obtain Let's
 				// Encrypt certificates.
 				Hosts []string `yaml:"hosts,omitempty"`
+
+				// Storage stores the account and certificates in the storage
+				// driver, under /acme, rather than in CacheFile, so that they
+				// are shared by the registries using the same storage.
+				Storage bool `yaml:"storage,omitempty"`
+
+				// HTTPAddr is the address answering the HTTP-01 challenges,
+				// such as :80, and redirecting the other requests to HTTPS.
+				// Only the TLS-ALPN-01 challenges are answered when unset.
+				HTTPAddr string `yaml:"httpaddr,omitempty"`
+
+				// DirectoryURL is the directory of the ACME certificate
+				// authority, the one of Let's Encrypt by default.
+				DirectoryURL string `yaml:"directoryurl,omitempty"`
 			} `yaml:"letsencrypt,omitempty"`
 		} `yaml:"tls,omitempty"`
 
@@ -148,6 +164,11 @@ type Configuration struct {
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			// Pprof serves the runtime profiles and goroutine dumps of the
+			// registry under /debug/pprof/.
+			Pprof struct {
+				Enabled bool `yaml:"enabled,omitempty"`
+			} `yaml:"pprof,omitempty"`
 		} `yaml:"debug,omitempty"`
 
 		// HTTP2 configuration options
@@ -155,7 +176,33 @@ type Configuration struct {
 			// Specifies whether the registry should disallow clients attempting
 			// to connect via http2. If set to true, only http/1.1 is supported.
 			Disabled bool `yaml:"disabled,omitempty"`
+
+			// H2C serves HTTP/2 without TLS to the clients sending the
+			// HTTP/2 connection preface, such as trusted load balancers
+			// terminating TLS
+			H2C bool `yaml:"h2c,omitempty"`
+
+			// MaxConcurrentStreams is the number of requests a client may
+			// make at once on a connection, 250 by default
+			MaxConcurrentStreams uint32 `yaml:"maxconcurrentstreams,omitempty"`
+
+			// MaxReadFrameSize is the largest frame a client may send, 1MB
+			// by default
+			MaxReadFrameSize uint32 `yaml:"maxreadframesize,omitempty"`
+
+			// IdleTimeout closes the connections without any stream for
+			// that long, never by default
+			IdleTimeout time.Duration `yaml:"idletimeout,omitempty"`
 		} `yaml:"http2,omitempty"`
+
+		// Listeners configures listeners serving the Prometheus metrics and
+		// the admin endpoints apart from the API, such as to bind them to
+		// localhost or to a port only reachable from the mesh
+		Listeners Listeners `yaml:"listeners,omitempty"`
+
+		// Compression configures the compression of the blobs served to
+		// the clients accepting it
+		Compression Compression `yaml:"compression,omitempty"`
 	} `yaml:"http,omitempty"`
 
 	// Notifications specifies configuration about various endpoint to which
@@ -167,6 +214,10 @@ type Configuration struct {
 		// Addr specifies the the redis instance available to the application.
 		Addr string `yaml:"addr,omitempty"`
 
+		// Username is the ACL user to authenticate as, along with the
+		// password. The default user is used when it is empty.
+		Username string `yaml:"username,omitempty"`
+
 		// Password string to use when making a connection.
 		Password string `yaml:"password,omitempty"`
 
@@ -176,8 +227,51 @@ type Configuration struct {
 		// TLS configures settings for redis in-transit encryption
 		TLS struct {
 			Enabled bool `yaml:"enabled,omitempty"`
+
+			// CA is the path of a PEM file of the certificate authorities
+			// to verify the server with, instead of those of the system.
+			CA string `yaml:"ca,omitempty"`
+
+			// Certificate and Key are the paths of the PEM client
+			// certificate and key to present to the server.
+			Certificate string `yaml:"certificate,omitempty"`
+			Key         string `yaml:"key,omitempty"`
+
+			// ServerName overrides the name the server certificate is
+			// verified against.
+			ServerName string `yaml:"servername,omitempty"`
+
+			// InsecureSkipVerify disables the verification of the server
+			// certificate.
+			InsecureSkipVerify bool `yaml:"insecureskipverify,omitempty"`
 		} `yaml:"tls,omitempty"`
 
+		// Sentinel configures the discovery of the master of a Redis
+		// deployment monitored by sentinels, in place of Addr.
+		Sentinel struct {
+			// MasterName is the name the sentinels monitor the master as.
+			MasterName string `yaml:"mastername,omitempty"`
+
+			// Addrs are the addresses of the sentinels.
+			Addrs []string `yaml:"addrs,omitempty"`
+
+			// Username and Password authenticate to the sentinels, which
+			// may not share the credentials of the master.
+			Username string `yaml:"username,omitempty"`
+			Password string `yaml:"password,omitempty"`
+		} `yaml:"sentinel,omitempty"`
+
+		// Cluster configures a Redis Cluster, in place of Addr.
+		Cluster struct {
+			// Addrs are the addresses of nodes of the cluster, from which
+			// the other nodes are discovered.
+			Addrs []string `yaml:"addrs,omitempty"`
+
+			// MaxRedirects is the number of times a command is redirected
+			// to another node before failing, 3 if not set.
+			MaxRedirects int `yaml:"maxredirects,omitempty"`
+		} `yaml:"cluster,omitempty"`
+
 		DialTimeout  time.Duration `yaml:"dialtimeout,omitempty"`  // timeout for connect
 		ReadTimeout  time.Duration `yaml:"readtimeout,omitempty"`  // timeout for reads of data
 		WriteTimeout time.Duration `yaml:"writetimeout,omitempty"` // timeout for writes of data
@@ -194,14 +288,72 @@ type Configuration struct {
 			// IdleTimeout sets the amount time to wait before closing
 			// inactive connections.
 			IdleTimeout time.Duration `yaml:"idletimeout,omitempty"`
+
+			// MaxConnLifetime closes connections older than it, so that
+			// they are spread over the servers again, when set.
+			MaxConnLifetime time.Duration `yaml:"maxconnlifetime,omitempty"`
+
+			// Wait makes connection requests wait for a connection when
+			// MaxActive connections are open, instead of proceeding
+			// without the cache.
+			Wait bool `yaml:"wait,omitempty"`
 		} `yaml:"pool,omitempty"`
 	} `yaml:"redis,omitempty"`
 
 	Health  Health  `yaml:"health,omitempty"`
 	Catalog Catalog `yaml:"catalog,omitempty"`
 
+	// Search configures the index of repositories served at /v2/_search
+	Search Search `yaml:"search,omitempty"`
+
 	Proxy Proxy `yaml:"proxy,omitempty"`
 
+	// Retention configures policies deleting old tags and untagged
+	// manifests
+	Retention Retention `yaml:"retention,omitempty"`
+
+	// Integrity configures the periodic re-verification of the content
+	// stored against its digests
+	Integrity Integrity `yaml:"integrity,omitempty"`
+
+	// Replication configures the pushing of manifests to downstream
+	// registries
+	Replication Replication `yaml:"replication,omitempty"`
+
+	// Scan configures the scanning of the images pushed or cached for
+	// vulnerabilities
+	Scan Scan `yaml:"scan,omitempty"`
+
+	// Metadata configures a database holding the metadata of the
+	// repositories, in addition to the storage
+	Metadata Metadata `yaml:"metadata,omitempty"`
+
+	// ACL restricts the repositories each user can pull from, push to and
+	// delete from, and the registry-wide endpoints they can use
+	ACL ACL `yaml:"acl,omitempty"`
+
+	// PullTokens configures the short-lived, pull-only tokens minted with
+	// the admin API
+	PullTokens PullTokens `yaml:"pulltokens,omitempty"`
+
+	// Public exposes the repositories matching patterns to anonymous pulls,
+	// while the others require authentication
+	Public Public `yaml:"public,omitempty"`
+
+	// Audit configures the audit log recording each request to the API
+	Audit Audit `yaml:"audit,omitempty"`
+
+	// IPFilter restricts the networks the registry, and repositories, can
+	// be reached from
+	IPFilter IPFilter `yaml:"ipfilter,omitempty"`
+
+	// RateLimit limits the rate of the requests, and the concurrent
+	// uploads, of each client
+	RateLimit RateLimit `yaml:"ratelimit,omitempty"`
+
+	// Tracing exports traces of the requests with OpenTelemetry
+	Tracing Tracing `yaml:"tracing,omitempty"`
+
 	// Compatibility is used for configurations of working with older or deprecated features.
 	Compatibility struct {
 		// Schema1 configures how schema1 manifests will be handled.
@@ -254,8 +406,9 @@ type Configuration struct {
 			// Classes is a list of repository classes which the
 			// registry allows content for. This class is matched
 			// against the configuration media type inside uploaded
-			// manifests. When non-empty, the registry will enforce
-			// the class in authorized resources.
+			// manifests: OCI manifests which are not images are of
+			// the "artifact" class. When non-empty, the registry will
+			// enforce the class in authorized resources.
 			Classes []string `yaml:"classes"`
 		} `yaml:"repository,omitempty"`
 	} `yaml:"policy,omitempty"`
@@ -269,6 +422,232 @@ type Catalog struct {
 	// to the catalog endpoint will return at most MaxEntries entries.
 	// An empty or a negative value will set a default of 1000 maximum entries by default.
 	MaxEntries int `yaml:"maxentries,omitempty"`
+
+	// Index serves the catalog from an in-memory index of the repositories,
+	// listed in lexical order, instead of walking the storage.
+	Index CatalogIndex `yaml:"index,omitempty"`
+}
+
+// CatalogIndex configures the in-memory index of the repositories serving
+// the catalog endpoint.
+type CatalogIndex struct {
+	// Enabled builds the index when the registry starts, and keeps it up to
+	// date with the pushes, cache fills and deletions of the registry. The
+	// storage is walked until the index is built.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// RefreshInterval is how often the index is rebuilt from the storage,
+	// picking up the changes made by other registry instances. The index is
+	// only built when the registry starts if it is not set.
+	RefreshInterval time.Duration `yaml:"refreshinterval,omitempty"`
+}
+
+// Search configures an in-memory index of the names, tags and annotations of
+// the repositories, which the search endpoint (/v2/_search) queries. The
+// number of results returned at once is limited by Catalog.MaxEntries.
+type Search struct {
+	// Enabled builds the index when the registry starts, and keeps it up to
+	// date with the pushes, cache fills and deletions of the registry.
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// RefreshInterval is how often the index is rebuilt from the storage,
+	// picking up the changes made by other registry instances. The index is
+	// only built when the registry starts if it is not set.
+	RefreshInterval time.Duration `yaml:"refreshinterval,omitempty"`
+}
+
+// Metadata configures a database recording the manifests, tags and
+// repositories, which serves the listings of tags and repositories instead
+// of walking the storage. Blobs stay in the storage.
+type Metadata struct {
+	// Database is the type of the database. Only postgres is supported.
+	Database string `yaml:"database,omitempty"`
+
+	// Driver is the name of the database/sql driver connecting to the
+	// database, which must be linked into the binary. Defaults to postgres.
+	Driver string `yaml:"driver,omitempty"`
+
+	// DSN is the data source name of the database, such as
+	// postgres://registry:secret@db:5432/registry?sslmode=verify-full
+	DSN string `yaml:"dsn,omitempty"`
+
+	// MaxOpenConns is the maximum number of connections open to the
+	// database, unlimited if not set.
+	MaxOpenConns int `yaml:"maxopenconns,omitempty"`
+
+	// MaxIdleConns is the maximum number of idle connections kept open.
+	MaxIdleConns int `yaml:"maxidleconns,omitempty"`
+
+	// ConnMaxLifetime closes connections older than it, when set.
+	ConnMaxLifetime time.Duration `yaml:"connmaxlifetime,omitempty"`
+}
+
+// ACL grants users access to repositories and registry-wide endpoints, in
+// addition to the authentication of the access controller. A request is
+// allowed when each access it requires is granted by a rule. ACLs are
+// disabled when no rule is configured.
+type ACL struct {
+	// Groups are named lists of users, which rules can grant access to
+	Groups map[string][]string `yaml:"groups,omitempty"`
+
+	// Rules grant access to the users and groups they list
+	Rules []ACLRule `yaml:"rules,omitempty"`
+}
+
+// PullTokens configures the minting of tokens granting pull access to the
+// repositories matching patterns until they expire, for clients which
+// should not hold the credentials of a user.
+type PullTokens struct {
+	// Enabled allows minting pull tokens with the admin API, and accepting
+	// them
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Secret is the key signing the tokens. Defaults to the http secret.
+	Secret string `yaml:"secret,omitempty"`
+
+	// MaxExpiration caps the lifetime of the tokens. Defaults to 24h.
+	MaxExpiration time.Duration `yaml:"maxexpiration,omitempty"`
+}
+
+// Audit configures the audit log, which records the user, repository,
+// action, reference, size, status and duration of each request to the API.
+type Audit struct {
+	// Enabled records the requests
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Path is the file the records are appended to, one JSON object per
+	// line. Defaults to the standard output.
+	Path string `yaml:"path,omitempty"`
+
+	// Events sends the records to the notification endpoints too, as
+	// events with the audit action
+	Events bool `yaml:"events,omitempty"`
+}
+
+// IPFilter restricts the networks clients can reach the registry from, and
+// the repositories matching patterns, whatever their credentials.
+type IPFilter struct {
+	// TrustedProxies are the networks of the proxies whose forwarding header
+	// is honored to find the address of the client
+	TrustedProxies []string `yaml:"trustedproxies,omitempty"`
+
+	// Header is the header trusted proxies set to the address of the
+	// client. Defaults to X-Forwarded-For.
+	Header string `yaml:"header,omitempty"`
+
+	// Allow are the networks allowed to reach the registry, in CIDR
+	// notation. Every network is allowed if empty.
+	Allow []string `yaml:"allow,omitempty"`
+
+	// Rules restrict the networks repositories can be reached from. The
+	// first rule matching a repository applies.
+	Rules []IPFilterRule `yaml:"rules,omitempty"`
+}
+
+// RateLimit limits the requests of each client, so that runaway clients
+// can't overload the registry and its upstreams.
+type RateLimit struct {
+	// Requests is the number of requests per second each client can send
+	// on average. The rate is not limited if zero.
+	Requests float64 `yaml:"requests,omitempty"`
+
+	// Burst is the number of requests each client can send at once.
+	// Defaults to Requests, and at least 1.
+	Burst int `yaml:"burst,omitempty"`
+
+	// Uploads is the number of blob upload requests each client can send
+	// at the same time. The uploads are not limited if zero.
+	Uploads int `yaml:"uploads,omitempty"`
+
+	// Key identifies the clients: identity, the name of the authenticated
+	// user or the address of anonymous clients, or ip, the address of the
+	// client. Defaults to identity.
+	Key string `yaml:"key,omitempty"`
+}
+
+// Tracing exports traces of the requests, the storage driver operations and
+// the upstream calls of pull through caches, to an OpenTelemetry collector.
+// The exporter is configured with the standard OTEL_ environment variables.
+type Tracing struct {
+	// Enabled enables tracing.
+	Enabled bool `yaml:"enabled,omitempty"`
+}
+
+// IPFilterRule restricts the networks the matching repositories can be
+// reached from.
+type IPFilterRule struct {
+	// Repositories are patterns of names of repositories, in the syntax of
+	// path.Match
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Allow are the networks allowed to reach the repositories, in CIDR
+	// notation
+	Allow []string `yaml:"allow,omitempty"`
+}
+
+// Public configures the repositories anonymous users can pull from, in
+// addition to the access granted by the access controller. Public access is
+// disabled when no repository is configured.
+type Public struct {
+	// Repositories are patterns of the names of the public repositories,
+	// in the syntax of path.Match
+	Repositories []string `yaml:"repositories,omitempty"`
+}
+
+// ACLRule grants the actions to the users and groups on the repositories and
+// registry-wide endpoints it lists.
+type ACLRule struct {
+	// Repositories are patterns of names of repositories, in the syntax of
+	// path.Match
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Registry lists the registry-wide endpoints the rule grants access to:
+	// catalog, proxy, replication or admin, or * for all of them
+	Registry []string `yaml:"registry,omitempty"`
+
+	// Users are the names of the users granted access, or * for any user,
+	// including anonymous users when no access controller is configured
+	Users []string `yaml:"users,omitempty"`
+
+	// Groups are the names of the groups whose users are granted access
+	Groups []string `yaml:"groups,omitempty"`
+
+	// Actions are the actions granted on the repositories: pull, push,
+	// delete, or * for all of them
+	Actions []string `yaml:"actions,omitempty"`
+}
+
+// AccessLog configures access logging.
+type AccessLog struct {
+	// Disabled disables access logging.
+	Disabled bool `yaml:"disabled,omitempty"`
+
+	// Format is the format of the access log: "combined", the default, for
+	// the Combined Log Format, or "json".
+	Format string `yaml:"format,omitempty"`
+
+	// Fields selects the fields of the json access log, all of them by
+	// default.
+	Fields []string `yaml:"fields,omitempty"`
+
+	// Path is the output of the access log: "stdout", the default,
+	// "stderr" or the path of a file.
+	Path string `yaml:"path,omitempty"`
+
+	// ErrorPath is the output of the requests which failed, with a status
+	// of 400 or more. Defaults to Path.
+	ErrorPath string `yaml:"errorpath,omitempty"`
+
+	// Sampling samples the successful requests.
+	Sampling AccessLogSampling `yaml:"sampling,omitempty"`
+}
+
+// AccessLogSampling samples the successful requests of the access log, to
+// control its volume. The failed requests are always logged.
+type AccessLogSampling struct {
+	// Rate is the fraction of the successful requests logged, between 0
+	// and 1. All of them are logged if it is not set.
+	Rate float64 `yaml:"rate,omitempty"`
 }
 
 // LogHook is composed of hook Level and Type.
@@ -353,6 +732,20 @@ type TCPChecker struct {
 	Threshold int `yaml:"threshold,omitempty"`
 }
 
+// UpstreamChecker is the entry in the health section for checking the
+// upstreams of a pull through cache.
+type UpstreamChecker struct {
+	// Enabled turns on the health checks of the upstreams
+	Enabled bool `yaml:"enabled,omitempty"`
+	// Timeout is the duration to wait before timing out the HTTP request
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+	// Interval is the duration in between checks
+	Interval time.Duration `yaml:"interval,omitempty"`
+	// Threshold is the number of times a check must fail to report an
+	// upstream as unreachable
+	Threshold int `yaml:"threshold,omitempty"`
+}
+
 // Health provides the configuration section for health checks.
 type Health struct {
 	// FileCheckers is a list of paths to check
@@ -361,6 +754,9 @@ type Health struct {
 	HTTPCheckers []HTTPChecker `yaml:"http,omitempty"`
 	// TCPCheckers is a list of URIs to check
 	TCPCheckers []TCPChecker `yaml:"tcp,omitempty"`
+	// Upstreams configures health checks on the upstreams of a pull
+	// through cache
+	Upstreams UpstreamChecker `yaml:"upstreams,omitempty"`
 	// StorageDriver configures a health check on the configured storage
 	// driver
 	StorageDriver struct {
@@ -371,6 +767,13 @@ type Health struct {
 		// Threshold is the number of times a check must fail to trigger an
 		// unhealthy state
 		Threshold int `yaml:"threshold,omitempty"`
+		// Deep makes the health check write, read back and delete a
+		// canary object, rather than only query the storage driver
+		Deep bool `yaml:"deep,omitempty"`
+		// ReadOnlyOnFailure switches the registry to read-only mode while
+		// the deep health check fails to write, rather than failing the
+		// health check
+		ReadOnlyOnFailure bool `yaml:"readonlyonfailure,omitempty"`
 	} `yaml:"storagedriver,omitempty"`
 }
 
@@ -449,6 +852,10 @@ func (storage Storage) Type() string {
 			// allow configuration of delete
 		case "redirect":
 			// allow configuration of redirect
+		case "digest":
+			// allow configuration of the digest algorithm
+		case "layout":
+			// allow configuration of the layout of the links
 		default:
 			storageType = append(storageType, k)
 		}
@@ -490,6 +897,10 @@ func (storage *Storage) UnmarshalYAML(unmarshal func(interface{}) error) error {
 					// allow configuration of delete
 				case "redirect":
 					// allow configuration of redirect
+				case "digest":
+					// allow configuration of the digest algorithm
+				case "layout":
+					// allow configuration of the layout of the links
 				default:
 					types = append(types, k)
 				}
@@ -590,10 +1001,23 @@ type Notifications struct {
 	// respond to webhook notifications. In the future, we may allow other
 	// kinds of endpoints, such as external queues.
 	Endpoints []Endpoint `yaml:"endpoints,omitempty"`
+	// History records the recent events, to download them or replay them
+	// to the endpoints with the admin API.
+	History EventHistory `yaml:"history,omitempty"`
 }
 
-// Endpoint describes the configuration of an http webhook notification
-// endpoint.
+// EventHistory configures the history of the recent events.
+type EventHistory struct {
+	// Path is the directory of the history, which is disabled if it is
+	// not set.
+	Path string `yaml:"path,omitempty"`
+
+	// Window is how long the events are kept. Defaults to 24h.
+	Window time.Duration `yaml:"window,omitempty"`
+}
+
+// Endpoint describes the configuration of a notification endpoint: an http
+// webhook, or a Kafka or NATS sink.
 type Endpoint struct {
 	Name              string        `yaml:"name"`              // identifies the endpoint in the registry instance.
 	Disabled          bool          `yaml:"disabled"`          // disables the endpoint
@@ -604,6 +1028,162 @@ type Endpoint struct {
 	Backoff           time.Duration `yaml:"backoff"`           // backoff duration
 	IgnoredMediaTypes []string      `yaml:"ignoredmediatypes"` // target media types to ignore
 	Ignore            Ignore        `yaml:"ignore"`            // ignore event types
+
+	// Type is the kind of the endpoint: http, the default, kafka or nats
+	Type string `yaml:"type,omitempty"`
+
+	// BatchSize is the maximum number of events published at once to a
+	// kafka or nats endpoint. Defaults to 100.
+	BatchSize int `yaml:"batchsize,omitempty"`
+
+	// Kafka configures an endpoint of type kafka
+	Kafka KafkaEndpoint `yaml:"kafka,omitempty"`
+
+	// NATS configures an endpoint of type nats
+	NATS NATSEndpoint `yaml:"nats,omitempty"`
+
+	// Format is the format of the events: envelope, the default, or
+	// cloudevents
+	Format string `yaml:"format,omitempty"`
+
+	// CloudEvents configures the cloudevents format
+	CloudEvents CloudEvents `yaml:"cloudevents,omitempty"`
+
+	// Filter selects the events sent to the endpoint, all of them by
+	// default
+	Filter EventFilter `yaml:"filter,omitempty"`
+
+	// Queue persists the events queued for the endpoint on disk, instead
+	// of keeping them in memory
+	Queue EndpointQueue `yaml:"queue,omitempty"`
+
+	// Signing signs the requests to an http endpoint
+	Signing EndpointSigning `yaml:"signing,omitempty"`
+
+	// TLS configures the connections to an http endpoint, such as the
+	// client certificate of mutual TLS
+	TLS EndpointTLS `yaml:"tls,omitempty"`
+}
+
+// EndpointSigning configures the signature of the requests to an http
+// endpoint, for the endpoint to authenticate the events.
+type EndpointSigning struct {
+	// Secret is the key of the HMAC-SHA256 signatures of the bodies of the
+	// requests
+	Secret string `yaml:"secret,omitempty"`
+
+	// Header is the header of the signatures, sha256= followed by the
+	// signature in hexadecimal. Defaults to X-Registry-Signature-256.
+	Header string `yaml:"header,omitempty"`
+}
+
+// EndpointQueue configures the persistent queue of the events of an
+// endpoint, delivered at least once, even across the restarts of the
+// registry.
+type EndpointQueue struct {
+	// Path is the directory of the queue. Each endpoint of each registry
+	// instance must have a directory of its own.
+	Path string `yaml:"path,omitempty"`
+
+	// MaxSize is the maximum size of the queue in bytes, from which the
+	// events are dropped. Defaults to 1GiB.
+	MaxSize int64 `yaml:"maxsize,omitempty"`
+
+	// Retention is the maximum age of the events delivered, the older
+	// events being dropped. By default, the events are kept until
+	// delivered.
+	Retention time.Duration `yaml:"retention,omitempty"`
+}
+
+// EventFilter selects the events sent to an endpoint: those matching the
+// include criteria, and none of the exclude criteria.
+type EventFilter struct {
+	// Include selects the events matching all its non-empty lists
+	Include EventMatch `yaml:"include,omitempty"`
+
+	// Exclude discards the events matching any entry of its lists
+	Exclude EventMatch `yaml:"exclude,omitempty"`
+}
+
+// EventMatch lists patterns, in the syntax of path.Match, of the
+// repositories, actions and target media types of events.
+type EventMatch struct {
+	Repositories []string `yaml:"repositories,omitempty"`
+	Actions      []string `yaml:"actions,omitempty"`
+	MediaTypes   []string `yaml:"mediatypes,omitempty"`
+}
+
+// CloudEvents configures the events sent in the format of CloudEvents 1.0.
+type CloudEvents struct {
+	// Mode is structured, the default, to send the events with their
+	// context attributes, or binary to send the context attributes as
+	// headers
+	Mode string `yaml:"mode,omitempty"`
+
+	// Source is the source of the events. Defaults to the address of the
+	// registry instance.
+	Source string `yaml:"source,omitempty"`
+}
+
+// KafkaEndpoint configures the publishing of events to a Kafka topic.
+type KafkaEndpoint struct {
+	// Brokers are the addresses of the brokers the cluster is discovered
+	// from
+	Brokers []string `yaml:"brokers,omitempty"`
+
+	// Topic is the topic the events are published to
+	Topic string `yaml:"topic,omitempty"`
+
+	// ClientID identifies the registry to the brokers. Defaults to
+	// registry.
+	ClientID string `yaml:"clientid,omitempty"`
+
+	// SASL authenticates to the brokers
+	SASL struct {
+		// Mechanism is PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512
+		Mechanism string `yaml:"mechanism,omitempty"`
+		Username  string `yaml:"username,omitempty"`
+		Password  string `yaml:"password,omitempty"`
+	} `yaml:"sasl,omitempty"`
+
+	// TLS configures the connections to the brokers
+	TLS EndpointTLS `yaml:"tls,omitempty"`
+}
+
+// NATSEndpoint configures the publishing of events to a NATS subject.
+type NATSEndpoint struct {
+	// Servers are the addresses of the servers, tried in order
+	Servers []string `yaml:"servers,omitempty"`
+
+	// Subject is the subject the events are published to
+	Subject string `yaml:"subject,omitempty"`
+
+	// Username and Password, or Token, authenticate to the servers
+	Username string `yaml:"username,omitempty"`
+	Password string `yaml:"password,omitempty"`
+	Token    string `yaml:"token,omitempty"`
+
+	// TLS configures the connections to the servers
+	TLS EndpointTLS `yaml:"tls,omitempty"`
+}
+
+// EndpointTLS configures the TLS connections to the servers of an
+// endpoint.
+type EndpointTLS struct {
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// CA is the path of a PEM file of the certificate authorities to
+	// verify the servers with, instead of those of the system.
+	CA string `yaml:"ca,omitempty"`
+
+	// Certificate and Key are the paths of the PEM client certificate and
+	// key to present to the servers.
+	Certificate string `yaml:"certificate,omitempty"`
+	Key         string `yaml:"key,omitempty"`
+
+	// InsecureSkipVerify disables the verification of the servers
+	// certificates.
+	InsecureSkipVerify bool `yaml:"insecureskipverify,omitempty"`
 }
 
 // Events configures notification events.
@@ -656,6 +1236,170 @@ type Middleware struct {
 	Options Parameters `yaml:"options"`
 }
 
+// Retention configures the retention policies of the repositories, which
+// are applied by the registry in the background and by the prune command.
+type Retention struct {
+	// Interval is the time between applications of the policies by the
+	// registry. The policies are only applied by the prune command when
+	// unset.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// DryRun logs the tags and manifests the policies select instead of
+	// deleting them
+	DryRun bool `yaml:"dryrun,omitempty"`
+
+	// Policies lists the retention policies. The first policy matching a
+	// repository applies to it.
+	Policies []RetentionPolicy `yaml:"policies,omitempty"`
+}
+
+// RetentionPolicy selects the tags and untagged manifests deleted from the
+// matching repositories.
+type RetentionPolicy struct {
+	// Repository is a pattern of the names of the repositories the policy
+	// applies to, such as library/*
+	Repository string `yaml:"repository"`
+
+	// Tags lists patterns of the tags subject to KeepLast and
+	// KeepNewerThan, such as v*. All tags are subject to them when empty.
+	Tags []string `yaml:"tags,omitempty"`
+
+	// KeepLast is the number of most recently updated tags kept
+	KeepLast int `yaml:"keeplast,omitempty"`
+
+	// KeepNewerThan keeps the tags updated within this duration. Tags are
+	// only deleted when KeepLast or KeepNewerThan is set.
+	KeepNewerThan time.Duration `yaml:"keepnewerthan,omitempty"`
+
+	// UntaggedOlderThan deletes the untagged manifests pushed before this
+	// duration. Untagged manifests are kept when unset.
+	UntaggedOlderThan time.Duration `yaml:"untaggedolderthan,omitempty"`
+}
+
+// Integrity configures the checks re-hashing the blobs stored against their
+// digests, and the links of the manifests to their blobs, which are run by
+// the registry in the background and by the verify command.
+type Integrity struct {
+	// Interval is the time between checks by the registry. The checks are
+	// only run by the verify command when unset.
+	Interval time.Duration `yaml:"interval,omitempty"`
+
+	// Sample is the fraction of the blobs re-hashed by each check, chosen
+	// at random. All blobs are re-hashed when unset.
+	Sample float64 `yaml:"sample,omitempty"`
+
+	// Quarantine moves the corrupt blobs out of the blob store, so they are
+	// no longer served, instead of only reporting them
+	Quarantine bool `yaml:"quarantine,omitempty"`
+
+	// Concurrency is the number of blobs re-hashed at the same time
+	Concurrency int `yaml:"concurrency,omitempty"`
+}
+
+// Replication configures the pushing of the manifests pushed to the
+// registry, or cached by a pull through cache, to downstream registries.
+type Replication struct {
+	// Workers is the number of manifests replicated at the same time.
+	// Defaults to 4.
+	Workers int `yaml:"workers,omitempty"`
+
+	// Retry configures retries of failed replications
+	Retry ReplicationRetry `yaml:"retry,omitempty"`
+
+	// Rules lists the downstream registries and the repositories
+	// replicated to them
+	Rules []ReplicationRule `yaml:"rules,omitempty"`
+}
+
+// ReplicationRetry configures retries of failed replications.
+type ReplicationRetry struct {
+	// Attempts is the maximum number of attempts made to replicate a
+	// manifest, including the first one. Defaults to 5.
+	Attempts int `yaml:"attempts,omitempty"`
+
+	// InitialBackoff is the delay before the first retry, which doubles
+	// with each further retry. Defaults to 1s.
+	InitialBackoff time.Duration `yaml:"initialbackoff,omitempty"`
+
+	// MaxBackoff caps the delay between retries. Defaults to 1m.
+	MaxBackoff time.Duration `yaml:"maxbackoff,omitempty"`
+}
+
+// ReplicationRule replicates the matching repositories to a downstream
+// registry.
+type ReplicationRule struct {
+	// Name identifies the rule in the replication status
+	Name string `yaml:"name"`
+
+	// URL is the base URL of the downstream registry, such as
+	// https://registry.example.com
+	URL string `yaml:"url"`
+
+	// Username of the registry user for URL
+	Username string `yaml:"username,omitempty"`
+
+	// Password of the registry user for URL
+	Password string `yaml:"password,omitempty"`
+
+	// Repositories lists patterns of the names of the repositories
+	// replicated, such as library/*. All repositories are replicated when
+	// empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Prefix is prepended to the names of the repositories in the
+	// downstream registry
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+// Scan configures the scanning of the images pushed to the registry, or
+// cached by a pull through cache, by a vulnerability scanner. The summary of
+// each scan is stored as a referrer of the image.
+type Scan struct {
+	// URL is the base URL of a scanner implementing the pluggable scanner
+	// API of Harbor, such as the adapters of Trivy and Clair. Scanning is
+	// disabled when empty.
+	URL string `yaml:"url,omitempty"`
+
+	// Authorization is the value of the Authorization header of the
+	// requests to the scanner, such as Bearer <token>
+	Authorization string `yaml:"authorization,omitempty"`
+
+	// RegistryURL is the base URL the scanner pulls the images to scan
+	// from, such as https://registry.example.com
+	RegistryURL string `yaml:"registryurl,omitempty"`
+
+	// RegistryAuthorization is the value of the Authorization header of the
+	// pulls of the scanner, such as Basic <credentials>
+	RegistryAuthorization string `yaml:"registryauthorization,omitempty"`
+
+	// Workers is the number of images scanned at the same time. Defaults
+	// to 2.
+	Workers int `yaml:"workers,omitempty"`
+
+	// Timeout bounds the duration of the scan of an image. Defaults to
+	// 10m.
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+
+	// Repositories lists patterns of the names of the repositories scanned,
+	// such as library/*. All repositories are scanned when empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// Policies block the pulls of the images with vulnerabilities
+	Policies []ScanPolicy `yaml:"policies,omitempty"`
+}
+
+// ScanPolicy blocks the pulls of the images of the matching repositories
+// whose last scan found vulnerabilities of a severity or higher.
+type ScanPolicy struct {
+	// Repositories lists patterns of the names of the repositories the
+	// policy applies to. The first policy matching a repository applies.
+	Repositories []string `yaml:"repositories"`
+
+	// Severity is the lowest severity of the vulnerabilities blocking
+	// pulls: low, medium, high or critical
+	Severity string `yaml:"severity"`
+}
+
 // Proxy configures the registry as a pull through cache
 type Proxy struct {
 	// EnableNamespaces enables support for the `ns` query parameter and disables use of RemoteURL
@@ -673,6 +1417,374 @@ type Proxy struct {
 
 	// Password of the registry user for RemoteURL
 	Password string `yaml:"password"`
+
+	// Platforms lists the platforms, in os/arch[/variant] form, whose child
+	// manifests are prefetched when an image index is cached. Other
+	// children are only fetched on demand.
+	Platforms []string `yaml:"platforms,omitempty"`
+
+	// LayerPrefetch configures the background fetches of the layers of the
+	// manifests cached on a miss, so that the requests for the layers which
+	// follow are served from the cache
+	LayerPrefetch ProxyLayerPrefetch `yaml:"layerprefetch,omitempty"`
+
+	// TagListTTL is how long tag listings merged from the remote and the
+	// local cache are kept before the remote is asked again. Listings are
+	// not cached when unset.
+	TagListTTL time.Duration `yaml:"taglistttl,omitempty"`
+
+	// TrustPolicies maps upstream hosts to the signatures required of
+	// manifests pulled from them before they are cached. The host of
+	// RemoteURL is used when EnableNamespaces is false.
+	TrustPolicies map[string]ProxyTrustPolicy `yaml:"trustpolicies,omitempty"`
+
+	// Transports maps upstream hosts to the outbound HTTP proxy settings
+	// used to reach them. The host of RemoteURL is used when
+	// EnableNamespaces is false. Hosts without an entry use the proxy
+	// settings of the environment.
+	Transports map[string]ProxyTransport `yaml:"transports,omitempty"`
+
+	// Pool tunes the pools of connections kept open to each upstream host,
+	// which the transports may override. Hosts without a transport share
+	// the default transport of the process, unless it is set.
+	Pool ProxyConnectionPool `yaml:"pool,omitempty"`
+
+	// Retry configures retries of upstream blob and manifest fetches which
+	// fail with a transient error
+	Retry ProxyRetry `yaml:"retry,omitempty"`
+
+	// AuditLog configures the audit log of requests made to upstream
+	// registries
+	AuditLog ProxyAuditLog `yaml:"auditlog,omitempty"`
+
+	// FetchOnRange caches the whole blob in the background when a Range
+	// request for a blob which is not cached is forwarded to the upstream.
+	// Only the requested range is fetched when unset.
+	FetchOnRange bool `yaml:"fetchonrange,omitempty"`
+
+	// Conversion configures the conversion of cached images to a layer
+	// format supporting lazy pulls
+	Conversion ProxyConversion `yaml:"conversion,omitempty"`
+
+	// Remotes maps upstream hosts which do not serve the registry API at
+	// the root of the host to the location of their /v2/ endpoint. The
+	// host of RemoteURL is used when EnableNamespaces is false.
+	Remotes map[string]ProxyRemote `yaml:"remotes,omitempty"`
+
+	// GroupCatalog makes the catalog API group the cached repositories by
+	// upstream host, and accept an ns parameter listing the repositories of
+	// a single upstream by their upstream names. Only used when
+	// EnableNamespaces is true.
+	GroupCatalog bool `yaml:"groupcatalog,omitempty"`
+
+	// PinnedRepositories lists patterns of repositories, in the form
+	// repository[:tag], whose cached content never expires. Repositories
+	// are matched by their local name, which is prefixed with the upstream
+	// host when EnableNamespaces is true. With a tag pattern, only the
+	// images of the matching tags are pinned.
+	PinnedRepositories []string `yaml:"pinnedrepositories,omitempty"`
+
+	// MirrorJobs lists upstream repositories which are kept in sync with
+	// the cache in the background, rather than cached on demand
+	MirrorJobs []ProxyMirrorJob `yaml:"mirrorjobs,omitempty"`
+
+	// AnonymousFallback makes requests to an upstream anonymous when its
+	// configured credentials are rejected, rather than failing them, so
+	// public content can still be pulled until the credentials are rotated
+	AnonymousFallback bool `yaml:"anonymousfallback,omitempty"`
+
+	// Challenges configures how long the auth challenges of the upstreams
+	// are kept, and where
+	Challenges ProxyChallenges `yaml:"challenges,omitempty"`
+
+	// Auth maps upstream hosts to how the requests to them are authorized.
+	// The host of RemoteURL is used when EnableNamespaces is false. Hosts
+	// without an entry use the token authentication they challenge with.
+	Auth map[string]ProxyAuth `yaml:"auth,omitempty"`
+
+	// ArtifactPolicies apply to the cached artifacts of matching media
+	// types, such as Helm charts, in place of the defaults for container
+	// images. The first matching policy applies.
+	ArtifactPolicies []ProxyArtifactPolicy `yaml:"artifactpolicies,omitempty"`
+
+	// Archive moves the blobs expiring from the cache to a cold storage,
+	// from which they are restored when they are pulled again, rather than
+	// deleting them
+	Archive ProxyArchive `yaml:"archive,omitempty"`
+
+	// LocalReferrers accepts pushes of referrer artifacts, such as SBOMs
+	// and signatures, to the proxied repositories. They are stored in the
+	// cache, never in the upstream, and listed with the referrers of the
+	// upstream.
+	LocalReferrers ProxyLocalReferrers `yaml:"localreferrers,omitempty"`
+
+	// ObjectTags tags the objects written to the storage when caching
+	// content, so that the storage lifecycle rules and cost reports can
+	// tell them apart per upstream. Only the s3 storage driver tags objects.
+	ObjectTags ProxyObjectTags `yaml:"objecttags,omitempty"`
+}
+
+// ProxyObjectTags configures the tags of the objects cached.
+type ProxyObjectTags struct {
+	// Enabled tags the objects cached with origin=proxy and
+	// namespace=<upstream host>
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Tags are additional tags of the objects cached
+	Tags map[string]string `yaml:"tags,omitempty"`
+}
+
+// ProxyLocalReferrers configures the referrer artifacts pushed to the cache.
+type ProxyLocalReferrers struct {
+	// Enabled accepts the pushes of referrers
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Repositories lists patterns of the local names of the repositories
+	// accepting referrers. All repositories accept them when unset.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// ArtifactTypes lists the artifact types accepted. All artifact types
+	// are accepted when unset.
+	ArtifactTypes []string `yaml:"artifacttypes,omitempty"`
+}
+
+// ProxyArchive configures the cold storage of the blobs expiring from the
+// cache.
+type ProxyArchive struct {
+	// Storage is the storage driver the blobs are archived to, configured
+	// as the storage of the registry. Expiring blobs are deleted when it is
+	// unset.
+	Storage Storage `yaml:"storage,omitempty"`
+
+	// Repositories lists path.Match patterns of the repositories whose
+	// blobs are archived, matched against their local names. The blobs of
+	// all repositories are archived when empty.
+	Repositories []string `yaml:"repositories,omitempty"`
+
+	// MinSize is the size of the smallest blobs archived. Smaller blobs are
+	// deleted, as they are cheap to fetch again.
+	MinSize int64 `yaml:"minsize,omitempty"`
+}
+
+// ProxyArtifactPolicy configures how the cached artifacts of some media
+// types expire and how much of the cache they may use.
+type ProxyArtifactPolicy struct {
+	// Name names the policy, and the quota bucket of the artifacts it
+	// applies to
+	Name string `yaml:"name"`
+
+	// MediaTypes are path.Match patterns, such as
+	// application/vnd.cncf.helm.*, matched against the media type of the
+	// manifests and the media types of the content they reference, such as
+	// their config. The policy applies to the manifests and the content
+	// they reference.
+	MediaTypes []string `yaml:"mediatypes"`
+
+	// TTL is how long the artifacts are cached, one week if unset
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	// PinnedRepositories are pinned as PinnedRepositories, for the
+	// artifacts of the policy only
+	PinnedRepositories []string `yaml:"pinnedrepositories,omitempty"`
+
+	// Quota bounds the bytes of the artifacts cached, past which the oldest
+	// expire early. It is unbounded when zero.
+	Quota int64 `yaml:"quota,omitempty"`
+}
+
+// ProxyAuth configures how the requests to an upstream are authorized.
+type ProxyAuth struct {
+	// Mode is "token", the default, to exchange the credentials for a
+	// token from the token server the upstream challenges with, "basic" to
+	// send the credentials with basic auth in every request, for upstreams
+	// without a token server, or "anonymous" to send no credentials.
+	Mode string `yaml:"mode,omitempty"`
+
+	// Scopes are the scopes requested from the token server in place of
+	// repository:{repository}:pull, where {repository} is replaced with the
+	// name of the repository on the upstream. Only used in token mode.
+	Scopes []string `yaml:"scopes,omitempty"`
+}
+
+// ProxyChallenges configures the auth challenges of the upstreams, which
+// tell the token servers of the upstreams and are probed before the first
+// request to an upstream.
+type ProxyChallenges struct {
+	// TTL is how long the challenges of an upstream are kept before the
+	// upstream is probed again, so that changes of its authentication are
+	// followed. They are kept until the registry restarts when unset.
+	TTL time.Duration `yaml:"ttl,omitempty"`
+
+	// Store persists the challenges, so they survive restarts, either in
+	// a "file" or in "redis". They are kept in memory when unset.
+	Store string `yaml:"store,omitempty"`
+
+	// Path is the file storing the challenges with the file store
+	Path string `yaml:"path,omitempty"`
+}
+
+// ProxyMirrorJob configures the periodic synchronization of an upstream
+// repository into the cache.
+type ProxyMirrorJob struct {
+	// Namespace is the upstream host of the repository. It is required
+	// when EnableNamespaces is true, and not allowed otherwise.
+	Namespace string `yaml:"namespace,omitempty"`
+
+	// Repository is the name of the repository on the upstream
+	Repository string `yaml:"repository"`
+
+	// Tags lists patterns of the tags to keep in sync, such as 3.*. All
+	// tags are synchronized when empty.
+	Tags []string `yaml:"tags,omitempty"`
+
+	// Interval is the time between synchronizations. Defaults to 1h.
+	Interval time.Duration `yaml:"interval,omitempty"`
+}
+
+// ProxyRemote configures where an upstream registry serves its API.
+type ProxyRemote struct {
+	// PathPrefix is the path under which the upstream serves /v2/, such as
+	// /artifactory/api/docker/docker-remote
+	PathPrefix string `yaml:"pathprefix,omitempty"`
+
+	// Resolver names a registered resolver computing the location of the
+	// /v2/ endpoint. It cannot be combined with PathPrefix.
+	Resolver string `yaml:"resolver,omitempty"`
+
+	// Options are passed to the resolver's initialization function
+	Options Parameters `yaml:"options,omitempty"`
+}
+
+// ProxyConversion configures the conversion of images pulled through the
+// cache.
+type ProxyConversion struct {
+	// Format is the layer format images are converted to, either estargz
+	// or zstdchunked. Images are not converted when empty.
+	Format string `yaml:"format,omitempty"`
+
+	// Schema1 is the manifest format schema1 manifests pulled by tag are
+	// converted to, either schema2 or oci. Schema1 manifests are served as
+	// is when empty.
+	Schema1 string `yaml:"schema1,omitempty"`
+}
+
+// ProxyLayerPrefetch configures the prefetch of the layers of the manifests
+// cached on a miss.
+type ProxyLayerPrefetch struct {
+	// Enabled starts fetching the blobs referenced by an image manifest in
+	// the background as soon as the manifest is cached
+	Enabled bool `yaml:"enabled,omitempty"`
+
+	// Concurrency bounds the blobs prefetched at once, across all
+	// repositories. Defaults to 4.
+	Concurrency int `yaml:"concurrency,omitempty"`
+
+	// MaxBytes bounds the bytes of the blobs being prefetched at once.
+	// Blobs larger than it are left to be fetched on demand. It is
+	// unbounded when zero.
+	MaxBytes int64 `yaml:"maxbytes,omitempty"`
+}
+
+// ProxyAuditLog configures the audit log of upstream requests.
+type ProxyAuditLog struct {
+	// Path is the file the JSON records are appended to, or stdout or
+	// stderr. Audit logging is disabled when empty.
+	Path string `yaml:"path,omitempty"`
+}
+
+// ProxyRetry configures how failed upstream requests are retried.
+type ProxyRetry struct {
+	// Attempts is the maximum number of attempts made for a request,
+	// including the first one. Requests are not retried when less than 2.
+	Attempts int `yaml:"attempts,omitempty"`
+
+	// InitialBackoff is the delay before the first retry, which doubles
+	// with each further retry. Defaults to 100ms.
+	InitialBackoff time.Duration `yaml:"initialbackoff,omitempty"`
+
+	// MaxBackoff caps the delay between retries. Defaults to 5s.
+	MaxBackoff time.Duration `yaml:"maxbackoff,omitempty"`
+
+	// StatusCodes lists the upstream response codes which are retried, in
+	// addition to connection failures. Defaults to 429, 500, 502, 503 and
+	// 504.
+	StatusCodes []int `yaml:"statuscodes,omitempty"`
+}
+
+// ProxyTransport configures the outbound HTTP proxy used to reach an
+// upstream registry.
+type ProxyTransport struct {
+	// HTTPProxy is the URL of the proxy used for plain HTTP requests
+	HTTPProxy string `yaml:"httpproxy,omitempty"`
+
+	// HTTPSProxy is the URL of the proxy used for HTTPS requests, which are
+	// tunneled with CONNECT
+	HTTPSProxy string `yaml:"httpsproxy,omitempty"`
+
+	// NoProxy is a comma separated list of hosts and domains which are
+	// reached directly, such as the token servers of the upstream
+	NoProxy string `yaml:"noproxy,omitempty"`
+
+	// DNSServers lists the addresses of the DNS servers, in ip[:port]
+	// form, used to resolve the hosts reached through the transport in
+	// place of the system resolver. They are tried in order.
+	DNSServers []string `yaml:"dnsservers,omitempty"`
+
+	// Hosts maps host names to the IP addresses they are reached at,
+	// bypassing DNS
+	Hosts map[string]string `yaml:"hosts,omitempty"`
+
+	// DialTimeout is the maximum time to wait for a connection to be
+	// established. Defaults to 30s.
+	DialTimeout time.Duration `yaml:"dialtimeout,omitempty"`
+
+	// IPFamily restricts connections to ipv4 or ipv6 addresses. Both are
+	// used when unset.
+	IPFamily string `yaml:"ipfamily,omitempty"`
+
+	// FallbackDelay is how long a connection to the preferred address
+	// family is given before a connection to the other family is raced
+	// against it, as in Happy Eyeballs. Defaults to 300ms, and a negative
+	// value disables the fallback.
+	FallbackDelay time.Duration `yaml:"fallbackdelay,omitempty"`
+
+	// Pool overrides the settings of the pool of connections of the
+	// transport which are set
+	Pool ProxyConnectionPool `yaml:"pool,omitempty"`
+}
+
+// ProxyConnectionPool tunes the connections kept open to an upstream
+// registry, and reused across pulls.
+type ProxyConnectionPool struct {
+	// MaxIdleConnsPerHost is the number of idle connections kept open to
+	// each host. Defaults to 2.
+	MaxIdleConnsPerHost int `yaml:"maxidleconnsperhost,omitempty"`
+
+	// MaxConnsPerHost bounds the connections to each host, including
+	// those in use. Unbounded by default.
+	MaxConnsPerHost int `yaml:"maxconnsperhost,omitempty"`
+
+	// IdleConnTimeout is how long an idle connection is kept open.
+	// Defaults to 90s.
+	IdleConnTimeout time.Duration `yaml:"idleconntimeout,omitempty"`
+
+	// TLSSessionCacheSize is the number of TLS sessions kept to be
+	// resumed, sparing full handshakes to new connections. Sessions are
+	// not resumed when unset.
+	TLSSessionCacheSize int `yaml:"tlssessioncachesize,omitempty"`
+}
+
+// ProxyTrustPolicy configures the cosign signature verification of manifests
+// pulled from an upstream registry.
+type ProxyTrustPolicy struct {
+	// PublicKeys lists paths to PEM encoded public keys. A manifest must
+	// carry a signature made by one of them.
+	PublicKeys []string `yaml:"publickeys"`
+
+	// Repositories restricts the policy to repositories matching one of the
+	// given patterns, such as library/*. The policy applies to every
+	// repository when empty.
+	Repositories []string `yaml:"repositories,omitempty"`
 }
 
 type ProxyCredential struct {
@@ -683,6 +1795,75 @@ type ProxyCredential struct {
 	Password string `yaml:"password"`
 }
 
+// Compression configures the compression of the blobs the registry serves
+// itself, rather than redirecting to the storage, negotiated with the
+// clients through Accept-Encoding independently of the media type of the
+// blobs. Blobs which are already compressed are served as they are.
+type Compression struct {
+	// Encodings are the encodings offered, zstd and gzip, in order of
+	// preference. Compression is disabled when empty.
+	Encodings []string `yaml:"encodings,omitempty"`
+
+	// Level is the compression level: fastest, default, better or best
+	Level string `yaml:"level,omitempty"`
+
+	// MinSize is the size of the smallest blob compressed, 1KB by default
+	MinSize int64 `yaml:"minsize,omitempty"`
+
+	// Concurrency is the number of blobs compressed at once, beyond which
+	// blobs are served uncompressed, the number of CPUs by default
+	Concurrency int `yaml:"concurrency,omitempty"`
+}
+
+// Listeners configures the listeners serving a part of the registry apart
+// from the API.
+type Listeners struct {
+	// Metrics serves the Prometheus metrics, on the path of
+	// http.debug.prometheus, /metrics by default
+	Metrics Listener `yaml:"metrics,omitempty"`
+
+	// Admin serves the endpoints under /v2/_admin/, /v2/_proxy/ and
+	// /v2/_replication/, which are no longer served by the API listener
+	Admin Listener `yaml:"admin,omitempty"`
+}
+
+// Listener configures a listener. It is disabled when Addr is empty.
+type Listener struct {
+	// Addr specifies the bind address of the listener
+	Addr string `yaml:"addr,omitempty"`
+
+	// Net specifies the net portion of the bind address. A default empty
+	// value means tcp.
+	Net string `yaml:"net,omitempty"`
+
+	// TLS serves the listener with TLS
+	TLS ListenerTLS `yaml:"tls,omitempty"`
+
+	// Auth configures the authentication of the clients of the listener,
+	// on top of the authentication of the registry
+	Auth ListenerAuth `yaml:"auth,omitempty"`
+}
+
+// ListenerTLS configures the TLS of a listener.
+type ListenerTLS struct {
+	// Certificate and Key are the paths of the PEM certificate and key of
+	// the listener
+	Certificate string `yaml:"certificate,omitempty"`
+	Key         string `yaml:"key,omitempty"`
+
+	// ClientCAs are the paths of the PEM certificate authorities which the
+	// clients must present a certificate signed by
+	ClientCAs []string `yaml:"clientcas,omitempty"`
+}
+
+// ListenerAuth configures the authentication of the clients of a listener.
+type ListenerAuth struct {
+	// Token is a bearer token the clients must present. It can't be set on
+	// the admin listener when the registry authenticates its clients, as
+	// both use the Authorization header.
+	Token string `yaml:"token,omitempty"`
+}
+
 // Parse parses an input configuration yaml document into a Configuration struct
 // This should generally be capable of handling old configuration format versions
 //
@@ -690,11 +1871,19 @@ type ProxyCredential struct {
 // following the scheme below:
 // Configuration.Abc may be replaced by the value of REGISTRY_ABC,
 // Configuration.Abc.Xyz may be replaced by the value of REGISTRY_ABC_XYZ, and so forth
+//
+// Secrets may be kept out of the document: any key suffixed with _file, such as
+// password_file, sets the key without the suffix to the content of the file, and
+// any value of the form env:NAME or vault:path#key is replaced by the environment
+// variable or by the key of the secret stored in Vault.
 func Parse(rd io.Reader) (*Configuration, error) {
 	in, err := io.ReadAll(rd)
 	if err != nil {
 		return nil, err
 	}
+	if in, err = resolveSecretFiles(in); err != nil {
+		return nil, err
+	}
 
 	p := NewParser("registry", []VersionedParseInfo{
 		{
@@ -732,6 +1921,9 @@ func Parse(rd io.Reader) (*Configuration, error) {
 	if err != nil {
 		return nil, err
 	}
+	if err := resolveSecretReferences(config); err != nil {
+		return nil, err
+	}
 
 	return config, nil
 }
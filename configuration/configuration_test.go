@@ -20,9 +20,7 @@ func Test(t *testing.T) { check.TestingT(t) }
 var configStruct = Configuration{
 	Version: "0.1",
 	Log: struct {
-		AccessLog struct {
-			Disabled bool `yaml:"disabled,omitempty"`
-		} `yaml:"accesslog,omitempty"`
+		AccessLog AccessLog              `yaml:"accesslog,omitempty"`
 		Level     Loglevel               `yaml:"level,omitempty"`
 		Formatter string                 `yaml:"formatter,omitempty"`
 		Fields    map[string]interface{} `yaml:"fields,omitempty"`
@@ -85,12 +83,16 @@ var configStruct = Configuration{
 			Certificate  string   `yaml:"certificate,omitempty"`
 			Key          string   `yaml:"key,omitempty"`
 			ClientCAs    []string `yaml:"clientcas,omitempty"`
+			ClientAuth   string   `yaml:"clientauth,omitempty"`
 			MinimumTLS   string   `yaml:"minimumtls,omitempty"`
 			CipherSuites []string `yaml:"ciphersuites,omitempty"`
 			LetsEncrypt  struct {
-				CacheFile string   `yaml:"cachefile,omitempty"`
-				Email     string   `yaml:"email,omitempty"`
-				Hosts     []string `yaml:"hosts,omitempty"`
+				CacheFile    string   `yaml:"cachefile,omitempty"`
+				Email        string   `yaml:"email,omitempty"`
+				Hosts        []string `yaml:"hosts,omitempty"`
+				Storage      bool     `yaml:"storage,omitempty"`
+				HTTPAddr     string   `yaml:"httpaddr,omitempty"`
+				DirectoryURL string   `yaml:"directoryurl,omitempty"`
 			} `yaml:"letsencrypt,omitempty"`
 		} `yaml:"tls,omitempty"`
 		Headers http.Header `yaml:"headers,omitempty"`
@@ -100,21 +102,34 @@ var configStruct = Configuration{
 				Enabled bool   `yaml:"enabled,omitempty"`
 				Path    string `yaml:"path,omitempty"`
 			} `yaml:"prometheus,omitempty"`
+			Pprof struct {
+				Enabled bool `yaml:"enabled,omitempty"`
+			} `yaml:"pprof,omitempty"`
 		} `yaml:"debug,omitempty"`
 		HTTP2 struct {
-			Disabled bool `yaml:"disabled,omitempty"`
+			Disabled             bool          `yaml:"disabled,omitempty"`
+			H2C                  bool          `yaml:"h2c,omitempty"`
+			MaxConcurrentStreams uint32        `yaml:"maxconcurrentstreams,omitempty"`
+			MaxReadFrameSize     uint32        `yaml:"maxreadframesize,omitempty"`
+			IdleTimeout          time.Duration `yaml:"idletimeout,omitempty"`
 		} `yaml:"http2,omitempty"`
+		Listeners   Listeners   `yaml:"listeners,omitempty"`
+		Compression Compression `yaml:"compression,omitempty"`
 	}{
 		TLS: struct {
 			Certificate  string   `yaml:"certificate,omitempty"`
 			Key          string   `yaml:"key,omitempty"`
 			ClientCAs    []string `yaml:"clientcas,omitempty"`
+			ClientAuth   string   `yaml:"clientauth,omitempty"`
 			MinimumTLS   string   `yaml:"minimumtls,omitempty"`
 			CipherSuites []string `yaml:"ciphersuites,omitempty"`
 			LetsEncrypt  struct {
-				CacheFile string   `yaml:"cachefile,omitempty"`
-				Email     string   `yaml:"email,omitempty"`
-				Hosts     []string `yaml:"hosts,omitempty"`
+				CacheFile    string   `yaml:"cachefile,omitempty"`
+				Email        string   `yaml:"email,omitempty"`
+				Hosts        []string `yaml:"hosts,omitempty"`
+				Storage      bool     `yaml:"storage,omitempty"`
+				HTTPAddr     string   `yaml:"httpaddr,omitempty"`
+				DirectoryURL string   `yaml:"directoryurl,omitempty"`
 			} `yaml:"letsencrypt,omitempty"`
 		}{
 			ClientCAs: []string{"/path/to/ca.pem"},
@@ -123,7 +138,11 @@ var configStruct = Configuration{
 			"X-Content-Type-Options": []string{"nosniff"},
 		},
 		HTTP2: struct {
-			Disabled bool `yaml:"disabled,omitempty"`
+			Disabled             bool          `yaml:"disabled,omitempty"`
+			H2C                  bool          `yaml:"h2c,omitempty"`
+			MaxConcurrentStreams uint32        `yaml:"maxconcurrentstreams,omitempty"`
+			MaxReadFrameSize     uint32        `yaml:"maxreadframesize,omitempty"`
+			IdleTimeout          time.Duration `yaml:"idletimeout,omitempty"`
 		}{
 			Disabled: false,
 		},
@@ -477,6 +496,47 @@ func (suite *ConfigSuite) TestParseEnvMany(c *check.C) {
 	c.Assert(err, check.IsNil)
 }
 
+// TestParseEnvList validates that the elements of lists, and their fields, can
+// be overridden and added by index
+func (suite *ConfigSuite) TestParseEnvList(c *check.C) {
+	suite.expectedConfig.Notifications.Endpoints[0].URL = "http://notifications.example.com"
+	suite.expectedConfig.Notifications.Endpoints = append(suite.expectedConfig.Notifications.Endpoints, Endpoint{
+		Name:    "endpoint-2",
+		URL:     "http://audit.example.com",
+		Timeout: 5 * time.Second,
+	})
+
+	os.Setenv("REGISTRY_NOTIFICATIONS_ENDPOINTS_0_URL", "http://notifications.example.com")
+	os.Setenv("REGISTRY_NOTIFICATIONS_ENDPOINTS_1", "{name: endpoint-2, url: http://audit.example.com}")
+	os.Setenv("REGISTRY_NOTIFICATIONS_ENDPOINTS_1_TIMEOUT", "5s")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	c.Assert(err, check.IsNil)
+	c.Assert(config, check.DeepEquals, suite.expectedConfig)
+}
+
+// TestParseEnvMapAlias validates that the entries of maps of structs can be
+// named by an alias, whose key is set separately
+func (suite *ConfigSuite) TestParseEnvMapAlias(c *check.C) {
+	suite.expectedConfig.Proxy.NamespaceCredentials = map[string]ProxyCredential{
+		"https://ghcr.io":              {Username: "bob", Password: "secret"},
+		"https://registry-1.docker.io": {Username: "alice", Password: "hunter2"},
+		"quay":                         {Username: "carol"},
+	}
+
+	os.Setenv("REGISTRY_PROXY_CREDENTIALS", `{"https://registry-1.docker.io": {username: alice, password: changeme}}`)
+	os.Setenv("REGISTRY_PROXY_CREDENTIALS_GHCR_KEY", "https://ghcr.io")
+	os.Setenv("REGISTRY_PROXY_CREDENTIALS_GHCR_USERNAME", "bob")
+	os.Setenv("REGISTRY_PROXY_CREDENTIALS_GHCR_PASSWORD", "secret")
+	os.Setenv("REGISTRY_PROXY_CREDENTIALS_HUB_KEY", "https://registry-1.docker.io")
+	os.Setenv("REGISTRY_PROXY_CREDENTIALS_HUB_PASSWORD", "hunter2")
+	os.Setenv("REGISTRY_PROXY_CREDENTIALS_QUAY_USERNAME", "carol")
+
+	config, err := Parse(bytes.NewReader([]byte(configYamlV0_1)))
+	c.Assert(err, check.IsNil)
+	c.Assert(config, check.DeepEquals, suite.expectedConfig)
+}
+
 func checkStructs(c *check.C, t reflect.Type, structsChecked map[string]struct{}) {
 	for t.Kind() == reflect.Ptr || t.Kind() == reflect.Map || t.Kind() == reflect.Slice {
 		t = t.Elem()
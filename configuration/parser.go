@@ -111,6 +111,11 @@ func NewParser(prefix string, parseInfos []VersionedParseInfo) *Parser {
 // than version, following the scheme below:
 // v.Abc may be replaced by the value of PREFIX_ABC,
 // v.Abc.Xyz may be replaced by the value of PREFIX_ABC_XYZ, and so forth
+//
+// The elements of lists are indexed, v.Abc[0].Xyz may be replaced by the value
+// of PREFIX_ABC_0_XYZ, and the entries of maps of structs may be named by an
+// alias whose key is set by PREFIX_ABC_ALIAS_KEY, for keys which can't be part
+// of a name, such as URLs.
 func (p *Parser) Parse(in []byte, v interface{}) error {
 	var versionedStruct struct {
 		Version Version
@@ -166,6 +171,8 @@ func (p *Parser) overwriteFields(v reflect.Value, fullpath string, path []string
 		return p.overwriteStruct(v, fullpath, path, payload)
 	case reflect.Map:
 		return p.overwriteMap(v, fullpath, path, payload)
+	case reflect.Slice:
+		return p.overwriteSlice(v, fullpath, path, payload)
 	case reflect.Interface:
 		if v.NumMethod() == 0 {
 			if !v.IsNil() {
@@ -192,6 +199,15 @@ func (p *Parser) overwriteStruct(v reflect.Value, fullpath string, path []string
 		}
 		byUpperCase[upper] = i
 	}
+	// Fields may also be named as in the configuration file, such as
+	// CREDENTIALS for NamespaceCredentials
+	for i := 0; i < v.NumField(); i++ {
+		tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+		upper := strings.ToUpper(tag)
+		if _, present := byUpperCase[upper]; tag != "" && !present && !strings.Contains(tag, "_") {
+			byUpperCase[upper] = i
+		}
+	}
 
 	fieldIndex, present := byUpperCase[path[0]]
 	if !present {
@@ -238,6 +254,9 @@ func (p *Parser) overwriteMap(m reflect.Value, fullpath string, path []string, p
 		logrus.Warnf("Ignoring environment variable %s involving map with non-string keys", fullpath)
 		return nil
 	}
+	if len(path) > 1 && m.Type().Elem().Kind() == reflect.Struct {
+		return p.overwriteStructEntry(m, fullpath, path, payload)
+	}
 
 	if len(path) > 1 {
 		// If a matching key exists, get its value and continue the
@@ -281,3 +300,105 @@ func (p *Parser) overwriteMap(m reflect.Value, fullpath string, path []string, p
 
 	return nil
 }
+
+// overwriteStructEntry overwrites a field of an entry of a map of structs. The
+// entries are named by their key or, as keys such as the URLs of upstreams
+// can't be part of a name, by an alias whose key is set by the variable
+// suffixed with _KEY, such as PREFIX_CREDENTIALS_GHCR_KEY=https://ghcr.io for
+// PREFIX_CREDENTIALS_GHCR_PASSWORD.
+func (p *Parser) overwriteStructEntry(m reflect.Value, fullpath string, path []string, payload string) error {
+	var key string
+	aliased := false
+	if !hasField(m.Type().Elem(), "KEY") {
+		parts := strings.Split(fullpath, "_")
+		key, aliased = p.lookupEnv(strings.Join(parts[:len(parts)-len(path)+1], "_") + "_KEY")
+	}
+
+	// the entries of a map can't be set in place
+	entry := reflect.New(m.Type().Elem()).Elem()
+	found := false
+	for _, k := range m.MapKeys() {
+		if (aliased && k.String() == key) || (!aliased && strings.ToUpper(k.String()) == path[0]) {
+			entry.Set(m.MapIndex(k))
+			key = k.String()
+			found = true
+			break
+		}
+	}
+	if !found && !aliased {
+		key = strings.ToLower(path[0])
+	}
+
+	if !aliased || len(path) != 2 || path[1] != "KEY" {
+		if err := p.overwriteFields(entry, fullpath, path[1:], payload); err != nil {
+			return err
+		}
+	}
+	m.SetMapIndex(reflect.ValueOf(key).Convert(m.Type().Key()), entry)
+	return nil
+}
+
+// overwriteSlice overwrites an element of a list, or a field of an element,
+// by index. The list grows to the index, as PREFIX_ABC_10 is applied before
+// PREFIX_ABC_2.
+func (p *Parser) overwriteSlice(v reflect.Value, fullpath string, path []string, payload string) error {
+	index, err := strconv.Atoi(path[0])
+	if err != nil || index < 0 {
+		logrus.Warnf("Ignoring environment variable %s with invalid index %s", fullpath, path[0])
+		return nil
+	}
+	if !v.CanSet() {
+		logrus.Warnf("Ignoring environment variable %s involving list which can't be set", fullpath)
+		return nil
+	}
+	if index >= v.Len() {
+		grown := reflect.MakeSlice(v.Type(), index+1, index+1)
+		reflect.Copy(grown, v)
+		v.Set(grown)
+	}
+
+	elem := v.Index(index)
+	if len(path) == 1 {
+		elemVal := reflect.New(elem.Type())
+		if err := yaml.Unmarshal([]byte(payload), elemVal.Interface()); err != nil {
+			return err
+		}
+		elem.Set(reflect.Indirect(elemVal))
+		return nil
+	}
+
+	// If the element is nil, must create an object
+	switch elem.Kind() {
+	case reflect.Map:
+		if elem.IsNil() {
+			elem.Set(reflect.MakeMap(elem.Type()))
+		}
+	case reflect.Ptr:
+		if elem.IsNil() {
+			elem.Set(reflect.New(elem.Type().Elem()))
+		}
+	}
+	return p.overwriteFields(elem, fullpath, path[1:], payload)
+}
+
+// lookupEnv returns the value of the environment variable name.
+func (p *Parser) lookupEnv(name string) (string, bool) {
+	for _, env := range p.env {
+		if env.name == name {
+			return env.value, true
+		}
+	}
+	return "", false
+}
+
+// hasField reports whether the struct t has a field named name, in upper case,
+// either by its name or by its name in the configuration file.
+func hasField(t reflect.Type, name string) bool {
+	for i := 0; i < t.NumField(); i++ {
+		tag, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if strings.ToUpper(t.Field(i).Name) == name || strings.ToUpper(tag) == name {
+			return true
+		}
+	}
+	return false
+}
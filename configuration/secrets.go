@@ -0,0 +1,259 @@
+package configuration
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+// secretFileSuffix suffixes the keys whose value is read from a file, such
+// as password_file for password.
+const secretFileSuffix = "_file"
+
+// Prefixes of the values referencing a secret.
+const (
+	envReferencePrefix   = "env:"
+	vaultReferencePrefix = "vault:"
+)
+
+// vaultTimeout bounds the requests to Vault.
+const vaultTimeout = 10 * time.Second
+
+// resolveSecretFiles replaces the keys of the document suffixed with _file,
+// such as password_file, by the key without the suffix set to the content
+// of the file, so that secrets mounted as files, such as by Kubernetes,
+// don't require templating the configuration.
+func resolveSecretFiles(in []byte) ([]byte, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(in, &doc); err != nil {
+		// reported by the parser
+		return in, nil
+	}
+	changed, err := resolveSecretFilesIn(doc, "")
+	if err != nil || !changed {
+		return in, err
+	}
+	return yaml.Marshal(doc)
+}
+
+func resolveSecretFilesIn(value interface{}, path string) (bool, error) {
+	changed := false
+	switch value := value.(type) {
+	case yaml.MapSlice:
+		for i, item := range value {
+			key, _ := item.Key.(string)
+			field := strings.TrimPrefix(path+"."+key, ".")
+			if name := strings.TrimSuffix(key, secretFileSuffix); name != key && name != "" {
+				filename, ok := item.Value.(string)
+				if !ok {
+					return false, fmt.Errorf("%s: expected the path of a file", field)
+				}
+				for _, other := range value {
+					if other.Key == name {
+						return false, fmt.Errorf("%s: both %s and %s are set", field, name, key)
+					}
+				}
+				p, err := os.ReadFile(filename)
+				if err != nil {
+					return false, fmt.Errorf("%s: %v", field, err)
+				}
+				value[i] = yaml.MapItem{Key: name, Value: strings.TrimRight(string(p), "\r\n")}
+				changed = true
+				continue
+			}
+			c, err := resolveSecretFilesIn(item.Value, field)
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+	case []interface{}:
+		for i, item := range value {
+			c, err := resolveSecretFilesIn(item, fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return false, err
+			}
+			changed = changed || c
+		}
+	}
+	return changed, nil
+}
+
+// secretResolver resolves the values of the configuration referencing a
+// secret, env:NAME for an environment variable and vault:path#key for a key
+// of a secret stored in Vault.
+type secretResolver struct {
+	vault *vaultClient
+}
+
+// resolveSecretReferences replaces the references to secrets of any string
+// of the configuration by the secrets.
+func resolveSecretReferences(config *Configuration) error {
+	r := &secretResolver{}
+	return r.resolve(reflect.ValueOf(config).Elem(), "")
+}
+
+func (r *secretResolver) resolve(v reflect.Value, path string) error {
+	switch v.Kind() {
+	case reflect.String:
+		if !v.CanSet() {
+			return nil
+		}
+		s, err := r.resolveString(v.String())
+		if err != nil {
+			return fmt.Errorf("%s: %v", path, err)
+		}
+		v.SetString(s)
+	case reflect.Ptr:
+		if !v.IsNil() {
+			return r.resolve(v.Elem(), path)
+		}
+	case reflect.Interface:
+		if v.IsNil() || !v.CanSet() {
+			return nil
+		}
+		// the value of an interface can't be set in place
+		elem := reflect.New(v.Elem().Type()).Elem()
+		elem.Set(v.Elem())
+		if err := r.resolve(elem, path); err != nil {
+			return err
+		}
+		v.Set(elem)
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < v.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+			name := strings.ToLower(t.Field(i).Name)
+			if tag := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]; tag != "" {
+				name = tag
+			}
+			if err := r.resolve(v.Field(i), strings.TrimPrefix(path+"."+name, ".")); err != nil {
+				return err
+			}
+		}
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			if err := r.resolve(v.Index(i), fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+	case reflect.Map:
+		iter := v.MapRange()
+		for iter.Next() {
+			// the values of a map can't be set in place
+			elem := reflect.New(iter.Value().Type()).Elem()
+			elem.Set(iter.Value())
+			if err := r.resolve(elem, fmt.Sprintf("%s.%v", path, iter.Key())); err != nil {
+				return err
+			}
+			v.SetMapIndex(iter.Key(), elem)
+		}
+	}
+	return nil
+}
+
+func (r *secretResolver) resolveString(s string) (string, error) {
+	switch {
+	case strings.HasPrefix(s, envReferencePrefix):
+		name := strings.TrimPrefix(s, envReferencePrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", name)
+		}
+		return value, nil
+	case strings.HasPrefix(s, vaultReferencePrefix):
+		if r.vault == nil {
+			r.vault = newVaultClient()
+		}
+		return r.vault.secret(strings.TrimPrefix(s, vaultReferencePrefix))
+	}
+	return s, nil
+}
+
+// vaultClient reads secrets from the Vault server at VAULT_ADDR, with the
+// token of VAULT_TOKEN, from either version of the KV secrets engine.
+type vaultClient struct {
+	addr      string
+	token     string
+	namespace string
+	client    *http.Client
+	secrets   map[string]map[string]interface{} // by path
+}
+
+func newVaultClient() *vaultClient {
+	return &vaultClient{
+		addr:      strings.TrimSuffix(os.Getenv("VAULT_ADDR"), "/"),
+		token:     os.Getenv("VAULT_TOKEN"),
+		namespace: os.Getenv("VAULT_NAMESPACE"),
+		client:    &http.Client{Timeout: vaultTimeout},
+		secrets:   make(map[string]map[string]interface{}),
+	}
+}
+
+// secret returns the key of the secret of a reference of the form
+// path#key, such as secret/data/registry#password.
+func (c *vaultClient) secret(ref string) (string, error) {
+	path, key, ok := strings.Cut(ref, "#")
+	if !ok || path == "" || key == "" {
+		return "", fmt.Errorf("vault reference %q is not of the form path#key", ref)
+	}
+	if c.addr == "" {
+		return "", fmt.Errorf("VAULT_ADDR is not set to resolve %q", ref)
+	}
+
+	data, ok := c.secrets[path]
+	if !ok {
+		var err error
+		if data, err = c.read(path); err != nil {
+			return "", fmt.Errorf("reading %s from vault: %v", path, err)
+		}
+		c.secrets[path] = data
+	}
+	value, ok := data[key].(string)
+	if !ok {
+		return "", fmt.Errorf("vault secret %s has no key %s", path, key)
+	}
+	return value, nil
+}
+
+func (c *vaultClient) read(path string) (map[string]interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, c.addr+"/v1/"+strings.TrimPrefix(path, "/"), nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", c.token)
+	if c.namespace != "" {
+		req.Header.Set("X-Vault-Namespace", c.namespace)
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+
+	var secret struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secret); err != nil {
+		return nil, err
+	}
+	// the secrets of the version 2 of the KV engine are nested with their
+	// metadata
+	if nested, ok := secret.Data["data"].(map[string]interface{}); ok {
+		if _, ok := secret.Data["metadata"]; ok {
+			return nested, nil
+		}
+	}
+	return secret.Data, nil
+}
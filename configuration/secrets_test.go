@@ -0,0 +1,82 @@
+package configuration
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/check.v1"
+)
+
+type SecretsSuite struct{}
+
+var _ = check.Suite(new(SecretsSuite))
+
+const secretsYamlConfig = `
+version: 0.1
+storage:
+  s3:
+    region: us-east-1
+    bucket: registry
+    accesskey: env:TEST_REGISTRY_ACCESS_KEY
+    secretkey_file: %s
+proxy:
+  enablenamespaces: true
+  credentials:
+    https://registry-1.docker.io:
+      username: user
+      password: vault:secret/data/registry#password
+`
+
+func (suite *SecretsSuite) TestResolveSecrets(c *check.C) {
+	dir := c.MkDir()
+	secretKey := filepath.Join(dir, "secretkey")
+	c.Assert(os.WriteFile(secretKey, []byte("s3cr3t\n"), 0o600), check.IsNil)
+
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/secret/data/registry" || r.Header.Get("X-Vault-Token") != "token" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		w.Write([]byte(`{"data": {"data": {"password": "hunter2"}, "metadata": {"version": 1}}}`))
+	}))
+	defer vault.Close()
+
+	os.Setenv("TEST_REGISTRY_ACCESS_KEY", "AKIA")
+	defer os.Unsetenv("TEST_REGISTRY_ACCESS_KEY")
+	os.Setenv("VAULT_ADDR", vault.URL)
+	defer os.Unsetenv("VAULT_ADDR")
+	os.Setenv("VAULT_TOKEN", "token")
+	defer os.Unsetenv("VAULT_TOKEN")
+
+	config, err := Parse(bytes.NewReader([]byte(fmt.Sprintf(secretsYamlConfig, secretKey))))
+	c.Assert(err, check.IsNil)
+	params := config.Storage.Parameters()
+	c.Assert(params["accesskey"], check.Equals, "AKIA")
+	c.Assert(params["secretkey"], check.Equals, "s3cr3t")
+	c.Assert(params["secretkey_file"], check.IsNil)
+	c.Assert(config.Proxy.NamespaceCredentials["https://registry-1.docker.io"].Password, check.Equals, "hunter2")
+}
+
+func (suite *SecretsSuite) TestResolveSecretsErrors(c *check.C) {
+	dir := c.MkDir()
+	secretKey := filepath.Join(dir, "secretkey")
+	c.Assert(os.WriteFile(secretKey, []byte("s3cr3t"), 0o600), check.IsNil)
+
+	for _, doc := range []string{
+		// unset environment variable
+		"version: 0.1\nstorage:\n  s3:\n    accesskey: env:TEST_REGISTRY_UNSET\n",
+		// missing file
+		"version: 0.1\nstorage:\n  s3:\n    secretkey_file: " + filepath.Join(dir, "missing") + "\n",
+		// both the key and the file
+		"version: 0.1\nstorage:\n  s3:\n    secretkey: s3cr3t\n    secretkey_file: " + secretKey + "\n",
+		// malformed vault reference
+		"version: 0.1\nstorage:\n  s3:\n    secretkey: vault:secret/registry\n",
+	} {
+		_, err := Parse(bytes.NewReader([]byte(doc)))
+		c.Assert(err, check.NotNil, check.Commentf("%s", doc))
+	}
+}
@@ -17,6 +17,7 @@ import (
 type Registry struct {
 	mu               sync.RWMutex
 	registeredChecks map[string]Checker
+	nonCritical      map[string]bool
 }
 
 // NewRegistry creates a new registry. This isn't necessary for normal use of
@@ -25,6 +26,7 @@ type Registry struct {
 func NewRegistry() *Registry {
 	return &Registry{
 		registeredChecks: make(map[string]Checker),
+		nonCritical:      make(map[string]bool),
 	}
 }
 
@@ -161,12 +163,26 @@ func PeriodicThresholdChecker(check Checker, period time.Duration, threshold int
 	return tu
 }
 
-// CheckStatus returns a map with all the current health check errors
+// CheckStatus returns a map with all the current health check errors, but
+// for those of the non-critical checks.
 func (registry *Registry) CheckStatus() map[string]string { // TODO(stevvooe) this needs a proper type
+	return registry.checkStatus(false)
+}
+
+// NonCriticalStatus returns a map with the current errors of the
+// non-critical checks.
+func (registry *Registry) NonCriticalStatus() map[string]string {
+	return registry.checkStatus(true)
+}
+
+func (registry *Registry) checkStatus(nonCritical bool) map[string]string {
 	registry.mu.RLock()
 	defer registry.mu.RUnlock()
 	statusKeys := make(map[string]string)
 	for k, v := range registry.registeredChecks {
+		if registry.nonCritical[k] != nonCritical {
+			continue
+		}
 		err := v.Check()
 		if err != nil {
 			statusKeys[k] = err.Error()
@@ -184,6 +200,10 @@ func CheckStatus() map[string]string {
 
 // Register associates the checker with the provided name.
 func (registry *Registry) Register(name string, check Checker) {
+	registry.register(name, check, false)
+}
+
+func (registry *Registry) register(name string, check Checker, nonCritical bool) {
 	if registry == nil {
 		registry = DefaultRegistry
 	}
@@ -194,6 +214,7 @@ func (registry *Registry) Register(name string, check Checker) {
 		panic("Check already exists: " + name)
 	}
 	registry.registeredChecks[name] = check
+	registry.nonCritical[name] = nonCritical
 }
 
 // Register associates the checker with the provided name in the default
@@ -202,6 +223,14 @@ func Register(name string, check Checker) {
 	DefaultRegistry.Register(name, check)
 }
 
+// RegisterNonCritical associates the checker with the provided name. The
+// errors of a non-critical check are reported by the status handler, but do
+// not make the service unhealthy, such as those of the external services the
+// service can do without for a while.
+func (registry *Registry) RegisterNonCritical(name string, check Checker) {
+	registry.register(name, check, true)
+}
+
 // RegisterFunc allows the convenience of registering a checker directly from
 // an arbitrary func() error.
 func (registry *Registry) RegisterFunc(name string, check func() error) {
@@ -240,7 +269,8 @@ func RegisterPeriodicThresholdFunc(name string, period time.Duration, threshold
 
 // StatusHandler returns a JSON blob with all the currently registered Health Checks
 // and their corresponding status.
-// Returns 503 if any Error status exists, 200 otherwise
+// Returns 503 if any Error status exists, but for those of the non-critical
+// checks, 200 otherwise
 func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	if r.Method == http.MethodGet {
 		checks := CheckStatus()
@@ -251,6 +281,10 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 			status = http.StatusServiceUnavailable
 		}
 
+		for k, v := range DefaultRegistry.NonCriticalStatus() {
+			checks[k] = v
+		}
+
 		statusResponse(w, r, status, checks)
 	} else {
 		http.NotFound(w, r)
@@ -105,3 +105,34 @@ func TestHealthHandler(t *testing.T) {
 	updater.Update(nil)
 	checkUp(t, "when server is back up") // now we should be back up.
 }
+
+// TestNonCriticalChecks ensures that the errors of non-critical checks are
+// reported without making the service unhealthy.
+func TestNonCriticalChecks(t *testing.T) {
+	DefaultRegistry = NewRegistry()
+
+	DefaultRegistry.RegisterNonCritical("upstream", CheckFunc(func() error {
+		return errors.New("upstream unreachable")
+	}))
+
+	recorder := httptest.NewRecorder()
+	StatusHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/health", nil))
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("unexpected status with a failed non-critical check: %d", recorder.Code)
+	}
+	if body := recorder.Body.String(); body != `{"upstream":"upstream unreachable"}` {
+		t.Fatalf("unexpected body: %s", body)
+	}
+	if checks := CheckStatus(); len(checks) != 0 {
+		t.Fatalf("unexpected failed checks: %v", checks)
+	}
+
+	Register("storage", CheckFunc(func() error {
+		return errors.New("storage unavailable")
+	}))
+	recorder = httptest.NewRecorder()
+	StatusHandler(recorder, httptest.NewRequest(http.MethodGet, "/debug/health", nil))
+	if recorder.Code != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected status with a failed check: %d", recorder.Code)
+	}
+}
@@ -119,8 +119,18 @@ type ManifestDescriptor struct {
 type ManifestList struct {
 	manifest.Versioned
 
+	// ArtifactType is the type of the artifact described by an OCI image
+	// index, when it is not a multi-platform image.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Manifests references a list of manifests
 	Manifests []ManifestDescriptor `json:"manifests"`
+
+	// Subject is the manifest an OCI image index refers to.
+	Subject *distribution.Descriptor `json:"subject,omitempty"`
+
+	// Annotations contains arbitrary metadata for an OCI image index.
+	Annotations map[string]string `json:"annotations,omitempty"`
 }
 
 // References returns the distribution descriptors for the referenced image
@@ -25,6 +25,14 @@ type Builder struct {
 	// Annotations contains arbitrary metadata relating to the targeted content.
 	annotations map[string]string
 
+	// configMediaType is the media type of the config, which is the one of
+	// an image config unless the manifest is an artifact.
+	configMediaType string
+
+	// artifactType and subject are set for artifacts.
+	artifactType string
+	subject      *distribution.Descriptor
+
 	// For testing purposes
 	mediaType string
 }
@@ -34,10 +42,11 @@ type Builder struct {
 // as part of the Build process, and annotations.
 func NewManifestBuilder(bs distribution.BlobService, configJSON []byte, annotations map[string]string) distribution.ManifestBuilder {
 	mb := &Builder{
-		bs:          bs,
-		configJSON:  make([]byte, len(configJSON)),
-		annotations: annotations,
-		mediaType:   v1.MediaTypeImageManifest,
+		bs:              bs,
+		configJSON:      make([]byte, len(configJSON)),
+		annotations:     annotations,
+		configMediaType: v1.MediaTypeImageConfig,
+		mediaType:       v1.MediaTypeImageManifest,
 	}
 	copy(mb.configJSON, configJSON)
 
@@ -55,6 +64,29 @@ func (mb *Builder) SetMediaType(mediaType string) error {
 	return nil
 }
 
+// SetConfigMediaType assigns the media type of the config, for artifacts
+// whose config is not an image config, such as the empty config
+// "application/vnd.oci.empty.v1+json".
+func (mb *Builder) SetConfigMediaType(mediaType string) error {
+	if mediaType == "" {
+		return errors.New("invalid media type for OCI manifest config")
+	}
+
+	mb.configMediaType = mediaType
+	return nil
+}
+
+// SetArtifactType assigns the artifact type of the manifest, for artifacts
+// which are not typed by the media type of their config.
+func (mb *Builder) SetArtifactType(artifactType string) {
+	mb.artifactType = artifactType
+}
+
+// SetSubject assigns the manifest the built manifest refers to.
+func (mb *Builder) SetSubject(subject distribution.Descriptor) {
+	mb.subject = &subject
+}
+
 // Build produces a final manifest from the given references.
 func (mb *Builder) Build(ctx context.Context) (distribution.Manifest, error) {
 	m := Manifest{
@@ -62,8 +94,10 @@ func (mb *Builder) Build(ctx context.Context) (distribution.Manifest, error) {
 			SchemaVersion: 2,
 			MediaType:     mb.mediaType,
 		},
-		Layers:      make([]distribution.Descriptor, len(mb.layers)),
-		Annotations: mb.annotations,
+		ArtifactType: mb.artifactType,
+		Layers:       make([]distribution.Descriptor, len(mb.layers)),
+		Subject:      mb.subject,
+		Annotations:  mb.annotations,
 	}
 	copy(m.Layers, mb.layers)
 
@@ -75,7 +109,7 @@ func (mb *Builder) Build(ctx context.Context) (distribution.Manifest, error) {
 	case nil:
 		// Override MediaType, since Put always replaces the specified media
 		// type with application/octet-stream in the descriptor it returns.
-		m.Config.MediaType = v1.MediaTypeImageConfig
+		m.Config.MediaType = mb.configMediaType
 		return FromStruct(m)
 	case distribution.ErrBlobUnknown:
 		// nop
@@ -84,10 +118,10 @@ func (mb *Builder) Build(ctx context.Context) (distribution.Manifest, error) {
 	}
 
 	// Add config to the blob store
-	m.Config, err = mb.bs.Put(ctx, v1.MediaTypeImageConfig, mb.configJSON)
+	m.Config, err = mb.bs.Put(ctx, mb.configMediaType, mb.configJSON)
 	// Override MediaType, since Put always replaces the specified media
 	// type with application/octet-stream in the descriptor it returns.
-	m.Config.MediaType = v1.MediaTypeImageConfig
+	m.Config.MediaType = mb.configMediaType
 	if err != nil {
 		return nil, err
 	}
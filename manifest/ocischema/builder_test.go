@@ -156,3 +156,63 @@ func TestBuilder(t *testing.T) {
 		t.Fatal("References() does not match the descriptors added")
 	}
 }
+
+func TestArtifactBuilder(t *testing.T) {
+	const (
+		emptyMediaType = "application/vnd.oci.empty.v1+json"
+		artifactType   = "application/vnd.example.sbom.v1+json"
+	)
+	emptyJSON := []byte("{}")
+	subject := distribution.Descriptor{
+		MediaType: v1.MediaTypeImageManifest,
+		Digest:    digest.FromString("subject"),
+		Size:      7,
+	}
+	layer := distribution.Descriptor{
+		MediaType: "application/spdx+json",
+		Digest:    digest.FromString("sbom"),
+		Size:      4,
+	}
+
+	bs := &mockBlobService{descriptors: make(map[digest.Digest]distribution.Descriptor)}
+	builder := NewManifestBuilder(bs, emptyJSON, nil).(*Builder)
+	if err := builder.SetConfigMediaType(""); err == nil {
+		t.Fatal("expected an error setting an empty config media type")
+	}
+	if err := builder.SetConfigMediaType(emptyMediaType); err != nil {
+		t.Fatal(err)
+	}
+	builder.SetArtifactType(artifactType)
+	builder.SetSubject(subject)
+	if err := builder.AppendReference(layer); err != nil {
+		t.Fatalf("AppendReference returned error: %v", err)
+	}
+
+	built, err := builder.Build(context.Background())
+	if err != nil {
+		t.Fatalf("Build returned error: %v", err)
+	}
+	manifest := built.(*DeserializedManifest).Manifest
+	if manifest.ArtifactType != artifactType {
+		t.Fatalf("unexpected artifact type: %s", manifest.ArtifactType)
+	}
+	if manifest.Config.MediaType != emptyMediaType || manifest.Config.Digest != digest.FromBytes(emptyJSON) {
+		t.Fatalf("unexpected config: %v", manifest.Config)
+	}
+	if manifest.Subject == nil || !reflect.DeepEqual(*manifest.Subject, subject) {
+		t.Fatalf("unexpected subject: %v", manifest.Subject)
+	}
+
+	// The artifact fields survive a round trip through the payload
+	_, payload, err := built.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var unmarshalled DeserializedManifest
+	if err := unmarshalled.UnmarshalJSON(payload); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(unmarshalled.Manifest, manifest) {
+		t.Fatalf("unexpected manifest after a round trip: %v", unmarshalled.Manifest)
+	}
+}
@@ -42,6 +42,11 @@ func init() {
 type Manifest struct {
 	manifest.Versioned
 
+	// ArtifactType is the type of the artifact described by the manifest,
+	// when the manifest is not an image. Artifacts without an artifact type
+	// are typed by the media type of their config.
+	ArtifactType string `json:"artifactType,omitempty"`
+
 	// Config references the image configuration as a blob.
 	Config distribution.Descriptor `json:"config"`
 
@@ -49,6 +54,10 @@ type Manifest struct {
 	// configuration.
 	Layers []distribution.Descriptor `json:"layers"`
 
+	// Subject is the manifest this manifest refers to, such as the image
+	// signed by a signature.
+	Subject *distribution.Descriptor `json:"subject,omitempty"`
+
 	// Annotations contains arbitrary metadata for the image manifest.
 	Annotations map[string]string `json:"annotations,omitempty"`
 }
@@ -69,6 +69,15 @@ type ManifestEnumerator interface {
 	Enumerate(ctx context.Context, ingester func(digest.Digest) error) error
 }
 
+// ReferrerService lists the manifests which declare another manifest as their
+// subject, such as signatures, SBOMs and attestations.
+type ReferrerService interface {
+	// Referrers returns descriptors for the manifests referring to the
+	// subject manifest. If artifactType is not empty, only referrers of
+	// that artifact type are returned.
+	Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]Descriptor, error)
+}
+
 // Describable is an interface for descriptors
 type Describable interface {
 	Descriptor() Descriptor
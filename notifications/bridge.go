@@ -44,6 +44,15 @@ func NewBridge(ub URLBuilder, source SourceRecord, actor ActorRecord, request Re
 	}
 }
 
+// NewIntegrityBridge returns an integrity listener that writes records of
+// the corrupt action to sink, using the source.
+func NewIntegrityBridge(source SourceRecord, sink events.Sink) IntegrityListener {
+	return &bridge{
+		source: source,
+		sink:   sink,
+	}
+}
+
 // NewRequestRecord builds a RequestRecord for use in NewBridge from an
 // http.Request, associating it with a request id.
 func NewRequestRecord(id string, r *http.Request) RequestRecord {
@@ -111,6 +120,17 @@ func (b *bridge) BlobDeleted(repo reference.Named, dgst digest.Digest) error {
 	return b.createBlobDeleteEventAndWrite(EventActionDelete, repo, dgst)
 }
 
+// BlobCorrupt writes an event for a blob of the repository which is corrupt.
+// The event has no URL, as the content may no longer be served.
+func (b *bridge) BlobCorrupt(repo reference.Named, desc distribution.Descriptor) error {
+	event := b.createEvent(EventActionCorrupt)
+	event.Target.Descriptor = desc
+	event.Target.Length = desc.Size
+	event.Target.Repository = repo.Name()
+
+	return b.sink.Write(*event)
+}
+
 func (b *bridge) TagDeleted(repo reference.Named, tag string) error {
 	event := b.createEvent(EventActionDelete)
 	event.Target.Repository = repo.Name()
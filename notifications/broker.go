@@ -0,0 +1,257 @@
+package notifications
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultBatchSize is the maximum number of events published at once to a
+// message broker when none is configured.
+const defaultBatchSize = 100
+
+// maxBackoffFactor caps the backoff between the retries of a batch, as a
+// factor of the configured backoff.
+const maxBackoffFactor = 32
+
+// message is an event encoded for a message broker.
+type message struct {
+	// key is the repository of the event, so that the events of a
+	// repository are kept in order by brokers partitioning by key
+	key   []byte
+	value []byte
+
+	contentType string
+	attributes  []attribute
+}
+
+// publisher publishes messages to a message broker.
+type publisher interface {
+	// publish publishes the messages, all of them or, if it returns an
+	// error, possibly some of them
+	publish(messages []message) error
+	close() error
+}
+
+// batchSink queues the events written to it, and publishes them in batches
+// with a publisher, retrying the failed batches with a backoff until the
+// sink is closed.
+type batchSink struct {
+	publisher publisher
+	encoder   encoder
+	store     eventStore
+	name      string
+	size      int
+	backoff   time.Duration
+	queue     eventQueueListener
+	status    publishListener
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	closed bool
+	done   chan struct{}
+}
+
+// publishListener is called on the outcomes of publishing events.
+type publishListener interface {
+	published(event events.Event)
+	err(err error, event events.Event)
+}
+
+func newBatchSink(p publisher, enc encoder, store eventStore, name string, size int, backoff time.Duration, queue eventQueueListener, status publishListener) *batchSink {
+	if size <= 0 {
+		size = defaultBatchSize
+	}
+	bs := &batchSink{
+		publisher: p,
+		encoder:   enc,
+		store:     store,
+		name:      name,
+		size:      size,
+		backoff:   backoff,
+		queue:     queue,
+		status:    status,
+		done:      make(chan struct{}),
+	}
+	bs.cond = sync.NewCond(&bs.mu)
+	go bs.run()
+	return bs
+}
+
+// Write queues the event, only failing if the sink is closed.
+func (bs *batchSink) Write(event events.Event) error {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	if bs.closed {
+		return ErrSinkClosed
+	}
+	bs.queue.ingress(event)
+	bs.store.push(event)
+	bs.cond.Signal()
+	return nil
+}
+
+// Close publishes the queued events, without retrying them, unless the queue
+// is persistent, and closes the publisher.
+func (bs *batchSink) Close() error {
+	bs.mu.Lock()
+	if bs.closed {
+		bs.mu.Unlock()
+		return fmt.Errorf("%s: already closed", bs.name)
+	}
+	bs.closed = true
+	bs.cond.Signal()
+	bs.mu.Unlock()
+
+	<-bs.done
+	if err := bs.store.close(); err != nil {
+		logrus.Errorf("%s: error closing the queue: %v", bs.name, err)
+	}
+	return bs.publisher.close()
+}
+
+func (bs *batchSink) run() {
+	defer close(bs.done)
+	for {
+		batch, closed := bs.next()
+		if len(batch) == 0 {
+			return
+		}
+
+		var encoded []events.Event
+		messages := make([]message, 0, len(batch))
+		for _, event := range batch {
+			msg, err := bs.encodeMessage(event)
+			if err != nil {
+				logrus.Errorf("%s: dropping event: %v", bs.name, err)
+				bs.status.err(err, event)
+				continue
+			}
+			encoded = append(encoded, event)
+			messages = append(messages, msg)
+		}
+
+		published := len(messages) > 0 && bs.publish(encoded, messages, closed)
+		if !published && len(messages) > 0 && bs.store.persistent() {
+			// kept in the queue until the next start
+			return
+		}
+		if published {
+			for _, event := range encoded {
+				bs.status.published(event)
+			}
+		}
+
+		bs.mu.Lock()
+		bs.store.pop(len(batch))
+		bs.mu.Unlock()
+		for _, event := range batch {
+			bs.queue.egress(event)
+		}
+	}
+}
+
+// publish publishes the messages of the events, retrying with a backoff
+// until they are published or the sink is closed. It reports whether they
+// were published.
+func (bs *batchSink) publish(batch []events.Event, messages []message, closed bool) bool {
+	backoff := bs.backoff
+	for {
+		err := bs.publisher.publish(messages)
+		if err == nil {
+			return true
+		}
+		for _, event := range batch {
+			bs.status.err(err, event)
+		}
+		if closed || bs.isClosed() {
+			logrus.Errorf("%s: dropping %d events on close: %v", bs.name, len(messages), err)
+			return false
+		}
+		logrus.Errorf("%s: error publishing %d events, retrying in %v: %v", bs.name, len(messages), backoff, err)
+		time.Sleep(backoff)
+		if backoff < maxBackoffFactor*bs.backoff {
+			backoff *= 2
+		}
+	}
+}
+
+// next waits for events, and returns up to a batch of them, and whether the
+// sink is closed. It returns no event once the sink is closed and empty, or
+// closed if the queue is persistent.
+func (bs *batchSink) next() ([]events.Event, bool) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	for {
+		if bs.closed && (bs.store.len() == 0 || bs.store.persistent()) {
+			return nil, true
+		}
+		if batch := bs.store.peek(bs.size); len(batch) > 0 {
+			return batch, bs.closed
+		}
+		bs.cond.Wait()
+	}
+}
+
+func (bs *batchSink) isClosed() bool {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.closed
+}
+
+// encodeMessage encodes the event as sent to http endpoints, in an envelope
+// of its own or as a CloudEvent.
+func (bs *batchSink) encodeMessage(event events.Event) (message, error) {
+	p, err := bs.encoder.encode(event)
+	if err != nil {
+		return message{}, err
+	}
+	msg := message{
+		value:       p.body,
+		contentType: p.contentType,
+		attributes:  p.attributes,
+	}
+	if e, ok := event.(Event); ok && e.Target.Repository != "" {
+		msg.key = []byte(e.Target.Repository)
+	}
+	return msg, nil
+}
+
+// endpointTLSConfig returns the TLS configuration of the connections to the
+// servers of an endpoint, or nil if TLS is disabled.
+func endpointTLSConfig(config configuration.EndpointTLS) (*tls.Config, error) {
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CA != "" {
+		pem, err := os.ReadFile(config.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CA)
+		}
+	}
+	if config.Certificate != "" || config.Key != "" {
+		certificate, err := tls.LoadX509KeyPair(config.Certificate, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
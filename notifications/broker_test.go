@@ -0,0 +1,95 @@
+package notifications
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testPublisher fails the first publications, then records the messages.
+type testPublisher struct {
+	mu       sync.Mutex
+	failures int
+	messages []message
+	closed   bool
+}
+
+func (tp *testPublisher) publish(messages []message) error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	if tp.failures > 0 {
+		tp.failures--
+		return errors.New("broker unavailable")
+	}
+	tp.messages = append(tp.messages, messages...)
+	return nil
+}
+
+func (tp *testPublisher) close() error {
+	tp.mu.Lock()
+	defer tp.mu.Unlock()
+	tp.closed = true
+	return nil
+}
+
+func TestBatchSink(t *testing.T) {
+	const nevents = 5
+	tp := &testPublisher{failures: 2}
+	metrics := newSafeMetrics("")
+	bs := newBatchSink(tp, encoder{}, newMemoryStore(), "test", 2, time.Millisecond, metrics.eventQueueListener(), metrics.publishListener())
+
+	for i := 0; i < nevents; i++ {
+		if err := bs.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+
+	// the events are retried until published, before the close returns
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tp.mu.Lock()
+		published := len(tp.messages)
+		tp.mu.Unlock()
+		if published == nevents {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d events to be published, got %d", nevents, published)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	checkClose(t, bs)
+
+	if !tp.closed {
+		t.Fatalf("expected the publisher to be closed")
+	}
+	for _, msg := range tp.messages {
+		if string(msg.key) != "library/test" {
+			t.Fatalf("unexpected message key %q", msg.key)
+		}
+	}
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.Successes != nevents || metrics.Pending != 0 || metrics.Errors == 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics.EndpointMetrics)
+	}
+}
+
+func TestBatchSinkCloseDropsFailures(t *testing.T) {
+	tp := &testPublisher{failures: 1000}
+	metrics := newSafeMetrics("")
+	bs := newBatchSink(tp, encoder{}, newMemoryStore(), "test", 10, time.Millisecond, metrics.eventQueueListener(), metrics.publishListener())
+
+	if err := bs.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+	checkClose(t, bs)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.Successes != 0 || metrics.Pending != 0 {
+		t.Fatalf("unexpected metrics: %+v", metrics.EndpointMetrics)
+	}
+}
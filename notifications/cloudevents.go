@@ -0,0 +1,182 @@
+package notifications
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+)
+
+// Formats of the events sent to the endpoints.
+const (
+	FormatEnvelope    = "envelope"
+	FormatCloudEvents = "cloudevents"
+)
+
+// Modes of the CloudEvents format.
+const (
+	CloudEventsStructured = "structured"
+	CloudEventsBinary     = "binary"
+)
+
+const (
+	// CloudEventsMediaType is the media type of an event in the structured
+	// mode of CloudEvents.
+	CloudEventsMediaType = "application/cloudevents+json"
+
+	// CloudEventsTypePrefix prefixes the actions of the events to make the
+	// types of the CloudEvents.
+	CloudEventsTypePrefix = "io.distribution.registry."
+
+	cloudEventsSpecVersion = "1.0"
+)
+
+// CloudEvent is an event in the JSON format of CloudEvents 1.0, its data
+// being the event in the format of the envelopes.
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject,omitempty"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	Data            Event     `json:"data"`
+}
+
+// attribute is a context attribute of a CloudEvent, sent as a header in the
+// binary mode.
+type attribute struct {
+	name, value string
+}
+
+// attributes returns the context attributes of the event, other than its
+// content type.
+func (ce *CloudEvent) attributes() []attribute {
+	attrs := []attribute{
+		{"specversion", ce.SpecVersion},
+		{"id", ce.ID},
+		{"source", ce.Source},
+		{"type", ce.Type},
+		{"time", ce.Time.Format(time.RFC3339Nano)},
+	}
+	if ce.Subject != "" {
+		attrs = append(attrs, attribute{"subject", ce.Subject})
+	}
+	return attrs
+}
+
+// encoder encodes the events sent to an endpoint.
+type encoder struct {
+	format string
+	mode   string
+	source string
+	indent bool
+}
+
+// encoded is an event encoded by an encoder.
+type encoded struct {
+	contentType string
+	body        []byte
+
+	// attributes are the context attributes to send as headers, in the
+	// binary mode of CloudEvents
+	attributes []attribute
+}
+
+func newEncoder(config EndpointConfig) encoder {
+	enc := encoder{
+		format: config.Format,
+		mode:   config.CloudEvents.Mode,
+		source: config.CloudEvents.Source,
+	}
+	if enc.format == "" {
+		enc.format = FormatEnvelope
+	}
+	if enc.mode == "" {
+		enc.mode = CloudEventsStructured
+	}
+	return enc
+}
+
+// validateFormat returns an error if the format of the events is unknown.
+func validateFormat(format string, cloudEvents configuration.CloudEvents) error {
+	switch format {
+	case "", FormatEnvelope:
+	case FormatCloudEvents:
+		switch cloudEvents.Mode {
+		case "", CloudEventsStructured, CloudEventsBinary:
+		default:
+			return fmt.Errorf("unknown cloudevents mode %q", cloudEvents.Mode)
+		}
+	default:
+		return fmt.Errorf("unknown event format %q", format)
+	}
+	return nil
+}
+
+func (enc encoder) encode(event events.Event) (encoded, error) {
+	marshal := json.Marshal
+	if enc.indent {
+		marshal = func(v interface{}) ([]byte, error) {
+			return json.MarshalIndent(v, "", "   ")
+		}
+	}
+
+	if enc.format != FormatCloudEvents {
+		body, err := marshal(Envelope{Events: []events.Event{event}})
+		if err != nil {
+			return encoded{}, fmt.Errorf("error marshaling event envelope: %v", err)
+		}
+		return encoded{contentType: EventsMediaType, body: body}, nil
+	}
+
+	e, ok := event.(Event)
+	if !ok {
+		return encoded{}, fmt.Errorf("unexpected event type %T", event)
+	}
+	ce := newCloudEvent(e, enc.source)
+	if enc.mode == CloudEventsBinary {
+		body, err := marshal(ce.Data)
+		if err != nil {
+			return encoded{}, fmt.Errorf("error marshaling event: %v", err)
+		}
+		return encoded{contentType: ce.DataContentType, body: body, attributes: ce.attributes()}, nil
+	}
+	body, err := marshal(ce)
+	if err != nil {
+		return encoded{}, fmt.Errorf("error marshaling cloudevent: %v", err)
+	}
+	return encoded{contentType: CloudEventsMediaType, body: body}, nil
+}
+
+// newCloudEvent returns the CloudEvent of the event. Its source defaults to
+// the address of the registry instance which emitted it, and its subject is
+// the reference of its target.
+func newCloudEvent(event Event, source string) CloudEvent {
+	if source == "" && event.Source.Addr != "" {
+		source = "//" + event.Source.Addr
+	} else if source == "" {
+		source = "/registry"
+	}
+	subject := event.Target.Repository
+	if subject != "" {
+		if event.Target.Tag != "" {
+			subject += ":" + event.Target.Tag
+		} else if event.Target.Digest != "" {
+			subject += "@" + event.Target.Digest.String()
+		}
+	}
+	return CloudEvent{
+		SpecVersion:     cloudEventsSpecVersion,
+		ID:              event.ID,
+		Source:          source,
+		Type:            CloudEventsTypePrefix + event.Action,
+		Subject:         subject,
+		Time:            event.Timestamp,
+		DataContentType: "application/json",
+		Data:            event,
+	}
+}
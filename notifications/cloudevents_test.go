@@ -0,0 +1,131 @@
+package notifications
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/opencontainers/go-digest"
+)
+
+func createCloudEventsTestEvent() Event {
+	event := createTestEvent("push", "library/test", "application/vnd.oci.image.manifest.v1+json")
+	event.ID = "asdf-asdf-asdf-asdf-0"
+	event.Timestamp = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	event.Target.Digest = digest.FromString("manifest")
+	event.Target.Tag = "latest"
+	event.Source.Addr = "registry.example.com:5000"
+	return event
+}
+
+func TestCloudEventsStructured(t *testing.T) {
+	event := createCloudEventsTestEvent()
+	enc := newEncoder(EndpointConfig{Format: FormatCloudEvents})
+	p, err := enc.encode(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.contentType != CloudEventsMediaType || len(p.attributes) != 0 {
+		t.Fatalf("unexpected content type %q and attributes %v", p.contentType, p.attributes)
+	}
+
+	var ce map[string]interface{}
+	if err := json.Unmarshal(p.body, &ce); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]string{
+		"specversion":     "1.0",
+		"id":              "asdf-asdf-asdf-asdf-0",
+		"source":          "//registry.example.com:5000",
+		"type":            "io.distribution.registry.push",
+		"subject":         "library/test:latest",
+		"time":            "2024-01-02T03:04:05Z",
+		"datacontenttype": "application/json",
+	} {
+		if ce[name] != expected {
+			t.Errorf("unexpected %s: %v != %v", name, ce[name], expected)
+		}
+	}
+	data, ok := ce["data"].(map[string]interface{})
+	if !ok || data["action"] != "push" {
+		t.Fatalf("unexpected data: %v", ce["data"])
+	}
+
+	// the source can be configured, and the subject of untagged targets is
+	// their digest
+	event.Target.Tag = ""
+	enc = newEncoder(EndpointConfig{Format: FormatCloudEvents, CloudEvents: configuration.CloudEvents{Source: "https://registry.example.com"}})
+	p, err = enc.encode(event)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cloudEvent CloudEvent
+	if err := json.Unmarshal(p.body, &cloudEvent); err != nil {
+		t.Fatal(err)
+	}
+	if cloudEvent.Source != "https://registry.example.com" || cloudEvent.Subject != "library/test@"+event.Target.Digest.String() {
+		t.Fatalf("unexpected source %q and subject %q", cloudEvent.Source, cloudEvent.Subject)
+	}
+}
+
+func TestCloudEventsBinaryHTTPSink(t *testing.T) {
+	var received *http.Request
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r
+		body, _ = io.ReadAll(r.Body)
+	}))
+	defer server.Close()
+
+	event := createCloudEventsTestEvent()
+	sink := newHTTPSink(server.URL, 0, nil, nil)
+	sink.encoder = newEncoder(EndpointConfig{Format: FormatCloudEvents, CloudEvents: configuration.CloudEvents{Mode: CloudEventsBinary}})
+	if err := sink.Write(event); err != nil {
+		t.Fatalf("unexpected error writing event: %v", err)
+	}
+
+	for name, expected := range map[string]string{
+		"Content-Type":   "application/json",
+		"Ce-Specversion": "1.0",
+		"Ce-Id":          "asdf-asdf-asdf-asdf-0",
+		"Ce-Source":      "//registry.example.com:5000",
+		"Ce-Type":        "io.distribution.registry.push",
+		"Ce-Subject":     "library/test:latest",
+		"Ce-Time":        "2024-01-02T03:04:05Z",
+	} {
+		if value := received.Header.Get(name); value != expected {
+			t.Errorf("unexpected %s header: %q != %q", name, value, expected)
+		}
+	}
+	var data Event
+	if err := json.Unmarshal(body, &data); err != nil {
+		t.Fatal(err)
+	}
+	if data.ID != event.ID || data.Target.Repository != "library/test" {
+		t.Fatalf("unexpected data: %+v", data)
+	}
+}
+
+func TestValidateFormat(t *testing.T) {
+	for _, tc := range []struct {
+		format string
+		mode   string
+		valid  bool
+	}{
+		{"", "", true},
+		{FormatEnvelope, "", true},
+		{FormatCloudEvents, "", true},
+		{FormatCloudEvents, CloudEventsBinary, true},
+		{FormatCloudEvents, "batched", false},
+		{"xml", "", false},
+	} {
+		err := validateFormat(tc.format, configuration.CloudEvents{Mode: tc.mode})
+		if (err == nil) != tc.valid {
+			t.Errorf("unexpected validation of %q %q: %v", tc.format, tc.mode, err)
+		}
+	}
+}
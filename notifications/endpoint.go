@@ -1,6 +1,7 @@
 package notifications
 
 import (
+	"fmt"
 	"net/http"
 	"time"
 
@@ -18,6 +19,12 @@ type EndpointConfig struct {
 	IgnoredMediaTypes []string
 	Transport         *http.Transport `json:"-"`
 	Ignore            configuration.Ignore
+	BatchSize         int
+	Format            string
+	CloudEvents       configuration.CloudEvents
+	Filter            configuration.EventFilter
+	Queue             configuration.EndpointQueue
+	Signing           configuration.EndpointSigning `json:"-"`
 }
 
 // defaults set any zero-valued fields to a reasonable default.
@@ -52,28 +59,166 @@ type Endpoint struct {
 	metrics *safeMetrics
 }
 
-// NewEndpoint returns a running endpoint, ready to receive events.
+// NewConfiguredEndpoint returns a running endpoint of the configured type,
+// ready to receive events.
+func NewConfiguredEndpoint(endpoint configuration.Endpoint) (*Endpoint, error) {
+	config := EndpointConfig{
+		Timeout:           endpoint.Timeout,
+		Threshold:         endpoint.Threshold,
+		Backoff:           endpoint.Backoff,
+		Headers:           endpoint.Headers,
+		IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
+		Ignore:            endpoint.Ignore,
+		BatchSize:         endpoint.BatchSize,
+		Format:            endpoint.Format,
+		CloudEvents:       endpoint.CloudEvents,
+		Filter:            endpoint.Filter,
+		Queue:             endpoint.Queue,
+		Signing:           endpoint.Signing,
+	}
+	if err := validateFilter(config.Filter); err != nil {
+		return nil, err
+	}
+
+	switch endpoint.Type {
+	case "", "http":
+		if err := validateFormat(config.Format, config.CloudEvents); err != nil {
+			return nil, err
+		}
+		tlsConfig, err := endpointTLSConfig(endpoint.TLS)
+		if err != nil {
+			return nil, fmt.Errorf("endpoint tls: %v", err)
+		}
+		if tlsConfig != nil {
+			config.Transport = http.DefaultTransport.(*http.Transport).Clone()
+			config.Transport.TLSClientConfig = tlsConfig
+		}
+		metrics := newSafeMetrics(endpoint.Name)
+		store, err := openStore(config, metrics)
+		if err != nil {
+			return nil, err
+		}
+		return newHTTPEndpoint(endpoint.Name, endpoint.URL, config, metrics, store), nil
+	case "kafka":
+		return NewKafkaEndpoint(endpoint.Name, endpoint.Kafka, config)
+	case "nats":
+		return NewNATSEndpoint(endpoint.Name, endpoint.NATS, config)
+	default:
+		return nil, fmt.Errorf("unknown endpoint type %q", endpoint.Type)
+	}
+}
+
+// NewEndpoint returns a running endpoint, ready to receive events. The
+// events are queued in memory, NewConfiguredEndpoint supporting persistent
+// queues.
 func NewEndpoint(name, url string, config EndpointConfig) *Endpoint {
+	config.Queue = configuration.EndpointQueue{}
+	return newHTTPEndpoint(name, url, config, newSafeMetrics(name), newMemoryStore())
+}
+
+func newHTTPEndpoint(name, url string, config EndpointConfig, metrics *safeMetrics, store eventStore) *Endpoint {
 	var endpoint Endpoint
 	endpoint.name = name
 	endpoint.url = url
 	endpoint.EndpointConfig = config
 	endpoint.defaults()
-	endpoint.metrics = newSafeMetrics(name)
+	endpoint.metrics = metrics
 
-	// Configures the inmemory queue, retry, http pipeline.
-	endpoint.Sink = newHTTPSink(
+	// Configures the queue, retry, http pipeline.
+	hs := newHTTPSink(
 		endpoint.url, endpoint.Timeout, endpoint.Headers,
 		endpoint.Transport, endpoint.metrics.httpStatusListener())
+	hs.encoder = newEncoder(config)
+	hs.encoder.indent = true
+	if config.Signing.Secret != "" {
+		hs.signing = config.Signing
+		if hs.signing.Header == "" {
+			hs.signing.Header = DefaultSignatureHeader
+		}
+	}
+	endpoint.Sink = hs
 	endpoint.Sink = events.NewRetryingSink(endpoint.Sink, events.NewBreaker(endpoint.Threshold, endpoint.Backoff))
-	endpoint.Sink = newEventQueue(endpoint.Sink, endpoint.metrics.eventQueueListener())
+	endpoint.Sink = newStoredEventQueue(endpoint.Sink, store, endpoint.metrics.eventQueueListener())
 	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
 	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
+	endpoint.Sink = newFilteredSink(endpoint.Sink, config.Filter)
 
 	register(&endpoint)
 	return &endpoint
 }
 
+// NewKafkaEndpoint returns a running endpoint publishing the events to a
+// Kafka topic.
+func NewKafkaEndpoint(name string, kafka configuration.KafkaEndpoint, config EndpointConfig) (*Endpoint, error) {
+	if err := validateFormat(config.Format, config.CloudEvents); err != nil {
+		return nil, err
+	}
+	config.defaults()
+	p, err := newKafkaPublisher(kafka, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	metrics := newSafeMetrics(name)
+	store, err := openStore(config, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return newBrokerEndpoint(name, p.String(), p, config, metrics, store), nil
+}
+
+// NewNATSEndpoint returns a running endpoint publishing the events to a NATS
+// subject.
+func NewNATSEndpoint(name string, nats configuration.NATSEndpoint, config EndpointConfig) (*Endpoint, error) {
+	if err := validateFormat(config.Format, config.CloudEvents); err != nil {
+		return nil, err
+	}
+	config.defaults()
+	p, err := newNATSPublisher(nats, config.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	metrics := newSafeMetrics(name)
+	store, err := openStore(config, metrics)
+	if err != nil {
+		return nil, err
+	}
+	return newBrokerEndpoint(name, p.String(), p, config, metrics, store), nil
+}
+
+func newBrokerEndpoint(name, url string, p publisher, config EndpointConfig, metrics *safeMetrics, store eventStore) *Endpoint {
+	var endpoint Endpoint
+	endpoint.name = name
+	endpoint.url = url
+	endpoint.EndpointConfig = config
+	endpoint.metrics = metrics
+
+	// Batches are queued and retried by the sink itself, as the brokers
+	// accept many events at once.
+	endpoint.Sink = newBatchSink(p, newEncoder(config), store, name, endpoint.BatchSize, endpoint.Backoff,
+		endpoint.metrics.eventQueueListener(), endpoint.metrics.publishListener())
+	mediaTypes := append(config.Ignore.MediaTypes, config.IgnoredMediaTypes...)
+	endpoint.Sink = newIgnoredSink(endpoint.Sink, mediaTypes, config.Ignore.Actions)
+	endpoint.Sink = newFilteredSink(endpoint.Sink, config.Filter)
+
+	register(&endpoint)
+	return &endpoint
+}
+
+// openStore opens the queue of the events of an endpoint: a persistent
+// queue if one is configured, whose events are restored as pending, or an
+// in-memory queue.
+func openStore(config EndpointConfig, metrics *safeMetrics) (eventStore, error) {
+	if config.Queue.Path == "" {
+		return newMemoryStore(), nil
+	}
+	ds, err := newDiskStore(config.Queue, metrics.dropped)
+	if err != nil {
+		return nil, err
+	}
+	metrics.restored(ds.len())
+	return ds, nil
+}
+
 // Name returns the name of the endpoint, generally used for debugging.
 func (e *Endpoint) Name() string {
 	return e.name
@@ -14,6 +14,19 @@ const (
 	EventActionPush   = "push"
 	EventActionMount  = "mount"
 	EventActionDelete = "delete"
+
+	// Actions of the events emitted by a pull through cache
+	EventActionCache       = "cache"
+	EventActionEvict       = "evict"
+	EventActionFetchFailed = "fetch_failed"
+
+	// EventActionCorrupt is the action of the events of the blobs found
+	// corrupt by the integrity checks of the storage
+	EventActionCorrupt = "corrupt"
+
+	// EventActionAudit is the action of the events recording each request
+	// to the API, when the audit log is enabled
+	EventActionAudit = "audit"
 )
 
 const (
@@ -88,6 +101,10 @@ type Event struct {
 	// differently, while the actor "initiates" the event, the source
 	// "generates" it.
 	Source SourceRecord `json:"source,omitempty"`
+
+	// Audit covers the request to the API an audit event records, and its
+	// response.
+	Audit *AuditRecord `json:"audit,omitempty"`
 }
 
 // ActorRecord specifies the agent that initiated the event. For most
@@ -129,6 +146,32 @@ type RequestRecord struct {
 	UserAgent string `json:"useragent"`
 }
 
+// AuditRecord covers a request to the API and its response, as recorded by
+// an audit event.
+type AuditRecord struct {
+	// Route is the name of the route of the API the request was sent to.
+	Route string `json:"route"`
+
+	// Action is pull, push or delete for the requests to repositories, and
+	// read or write for the requests to registry-wide endpoints.
+	Action string `json:"action"`
+
+	// Status is the status code of the response.
+	Status int `json:"status"`
+
+	// Error is the code of the first error of the response, if any.
+	Error string `json:"error,omitempty"`
+
+	// Received is the number of bytes of the request body read.
+	Received int64 `json:"received"`
+
+	// Written is the number of bytes of the response body.
+	Written int64 `json:"written"`
+
+	// Duration is the number of seconds the request was served in.
+	Duration float64 `json:"duration"`
+}
+
 // SourceRecord identifies the registry node that generated the event. Put
 // differently, while the actor "initiates" the event, the source "generates"
 // it.
@@ -141,6 +184,11 @@ type SourceRecord struct {
 	// InstanceID identifies a running instance of an application. Changes
 	// after each restart.
 	InstanceID string `json:"instanceID,omitempty"`
+
+	// Provider identifies the component of the registry that generated the
+	// event when it is not the registry API, such as "proxy" for the events
+	// of a pull through cache.
+	Provider string `json:"provider,omitempty"`
 }
 
 // ErrSinkClosed is returned if a write is issued to a sink that has been
@@ -0,0 +1,86 @@
+package notifications
+
+import (
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+)
+
+// eventFilter matches the events selected by the filter of an endpoint. It
+// is evaluated before the events are queued for the endpoint, so that the
+// discarded events cost nothing to the endpoint.
+type eventFilter struct {
+	include configuration.EventMatch
+	exclude configuration.EventMatch
+}
+
+// validateFilter returns an error if a pattern of the filter is invalid.
+func validateFilter(filter configuration.EventFilter) error {
+	for _, match := range []configuration.EventMatch{filter.Include, filter.Exclude} {
+		for _, patterns := range [][]string{match.Repositories, match.Actions, match.MediaTypes} {
+			for _, pattern := range patterns {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return fmt.Errorf("invalid filter pattern %q: %v", pattern, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// newFilteredSink returns a sink writing to the sink the events selected
+// by the filter, or the sink itself if the filter selects all the events.
+func newFilteredSink(sink events.Sink, filter configuration.EventFilter) events.Sink {
+	f := eventFilter{include: filter.Include, exclude: filter.Exclude}
+	if f.selectsAll() {
+		return sink
+	}
+	return events.NewFilter(sink, f)
+}
+
+func (f eventFilter) selectsAll() bool {
+	for _, match := range []configuration.EventMatch{f.include, f.exclude} {
+		if len(match.Repositories) > 0 || len(match.Actions) > 0 || len(match.MediaTypes) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Match reports whether the event matches the include criteria, and none
+// of the exclude criteria.
+func (f eventFilter) Match(event events.Event) bool {
+	e, ok := event.(Event)
+	if !ok {
+		return f.selectsAll()
+	}
+	fields := []struct {
+		value            string
+		include, exclude []string
+	}{
+		{e.Target.Repository, f.include.Repositories, f.exclude.Repositories},
+		{e.Action, f.include.Actions, f.exclude.Actions},
+		{e.Target.MediaType, f.include.MediaTypes, f.exclude.MediaTypes},
+	}
+	for _, field := range fields {
+		if len(field.include) > 0 && !matchAny(field.include, field.value) {
+			return false
+		}
+		if matchAny(field.exclude, field.value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchAny reports whether the value matches any of the patterns.
+func matchAny(patterns []string, value string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, value); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,59 @@
+package notifications
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestEventFilter(t *testing.T) {
+	filter := configuration.EventFilter{
+		Include: configuration.EventMatch{
+			Repositories: []string{"library/*", "team/*/*"},
+			Actions:      []string{"push", "delete"},
+		},
+		Exclude: configuration.EventMatch{
+			Repositories: []string{"library/mirror-*"},
+			MediaTypes:   []string{"application/octet-stream"},
+		},
+	}
+
+	var ts testSink
+	sink := newFilteredSink(&ts, filter)
+	for _, tc := range []struct {
+		action, repository, mediaType string
+		selected                      bool
+	}{
+		{"push", "library/ubuntu", layerMediaType, true},
+		{"delete", "team/app/api", layerMediaType, true},
+		{"pull", "library/ubuntu", layerMediaType, false},
+		{"push", "other/ubuntu", layerMediaType, false},
+		{"push", "team/app", layerMediaType, false},
+		{"push", "library/mirror-ubuntu", layerMediaType, false},
+		{"push", "library/ubuntu", "application/octet-stream", false},
+	} {
+		ts.count = 0
+		if err := sink.Write(createTestEvent(tc.action, tc.repository, tc.mediaType)); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+		if selected := ts.count == 1; selected != tc.selected {
+			t.Errorf("unexpected selection of %s %s %s: %v", tc.action, tc.repository, tc.mediaType, selected)
+		}
+	}
+
+	// without criteria, the sink itself receives all the events
+	if newFilteredSink(&ts, configuration.EventFilter{}) != &ts {
+		t.Fatalf("expected the sink not to be filtered")
+	}
+}
+
+func TestValidateFilter(t *testing.T) {
+	valid := configuration.EventFilter{Include: configuration.EventMatch{Repositories: []string{"library/*"}}}
+	if err := validateFilter(valid); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	invalid := configuration.EventFilter{Exclude: configuration.EventMatch{MediaTypes: []string{"application/["}}}
+	if err := validateFilter(invalid); err == nil {
+		t.Fatalf("expected the filter to be invalid")
+	}
+}
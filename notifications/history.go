@@ -0,0 +1,210 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultHistoryWindow is how long the events are kept in the history when
+// no window is configured.
+const defaultHistoryWindow = 24 * time.Hour
+
+// historyFileLayout names the files of the history after the hour of their
+// events, in UTC.
+const historyFileLayout = "20060102T15"
+
+const historyFileSuffix = ".ndjson"
+
+// History is a sink recording the events in a directory, for them to be
+// downloaded or replayed to the endpoints later. The events are written as
+// lines of JSON to a file per hour, the files older than the window being
+// removed.
+type History struct {
+	dir    string
+	window time.Duration
+	now    func() time.Time
+
+	mu     sync.Mutex
+	f      *os.File
+	hour   time.Time // hour of f
+	closed bool
+}
+
+// NewHistory opens the history in the directory, creating it if needed.
+func NewHistory(config configuration.EventHistory) (*History, error) {
+	if err := os.MkdirAll(config.Path, 0o700); err != nil {
+		return nil, err
+	}
+	h := &History{
+		dir:    config.Path,
+		window: config.Window,
+		now:    time.Now,
+	}
+	if h.window <= 0 {
+		h.window = defaultHistoryWindow
+	}
+	return h, nil
+}
+
+// Window returns how long the events are kept.
+func (h *History) Window() time.Duration {
+	return h.window
+}
+
+// Write records the event.
+func (h *History) Write(event events.Event) error {
+	p, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("history: error marshaling event: %v", err)
+	}
+	p = append(p, '\n')
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return ErrSinkClosed
+	}
+	hour := h.now().UTC().Truncate(time.Hour)
+	if h.f == nil || !hour.Equal(h.hour) {
+		if err := h.rotate(hour); err != nil {
+			return err
+		}
+	}
+	if _, err := h.f.Write(p); err != nil {
+		return fmt.Errorf("history: error writing event: %v", err)
+	}
+	return nil
+}
+
+// rotate opens the file of the hour, and removes the files older than the
+// window.
+func (h *History) rotate(hour time.Time) error {
+	if h.f != nil {
+		h.f.Close()
+		h.f = nil
+	}
+	f, err := os.OpenFile(filepath.Join(h.dir, hour.Format(historyFileLayout)+historyFileSuffix), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return fmt.Errorf("history: %v", err)
+	}
+	h.f, h.hour = f, hour
+
+	files, err := h.files()
+	if err != nil {
+		logrus.Errorf("history: error listing %s: %v", h.dir, err)
+		return nil
+	}
+	for _, file := range files {
+		if file.hour.Add(time.Hour).Before(hour.Add(-h.window)) {
+			if err := os.Remove(file.path); err != nil {
+				logrus.Errorf("history: error removing %s: %v", file.path, err)
+			}
+		}
+	}
+	return nil
+}
+
+type historyFile struct {
+	path string
+	hour time.Time
+}
+
+// files returns the files of the history, in order.
+func (h *History) files() ([]historyFile, error) {
+	entries, err := os.ReadDir(h.dir)
+	if err != nil {
+		return nil, err
+	}
+	var files []historyFile
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, historyFileSuffix) {
+			continue
+		}
+		hour, err := time.Parse(historyFileLayout, strings.TrimSuffix(name, historyFileSuffix))
+		if err != nil {
+			continue
+		}
+		files = append(files, historyFile{path: filepath.Join(h.dir, name), hour: hour})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].hour.Before(files[j].hour) })
+	return files, nil
+}
+
+// Events calls fn with the events recorded from since, included, until, not
+// included, in order, stopping at the first error of fn. The events older
+// than the window are not read.
+func (h *History) Events(since, until time.Time, fn func(Event) error) error {
+	if oldest := h.now().Add(-h.window); since.Before(oldest) {
+		since = oldest
+	}
+	files, err := h.files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if !file.hour.Add(time.Hour).After(since) {
+			continue
+		}
+		if err := h.read(file.path, since, until, fn); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (h *History) read(path string, since, until time.Time, fn func(Event) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			// removed by a rotation
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, maxRecordSize)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			// a line partially written by a crash
+			continue
+		}
+		if event.Timestamp.Before(since) || !event.Timestamp.Before(until) {
+			continue
+		}
+		if err := fn(event); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// Close closes the history.
+func (h *History) Close() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.closed {
+		return fmt.Errorf("history: already closed")
+	}
+	h.closed = true
+	if h.f != nil {
+		return h.f.Close()
+	}
+	return nil
+}
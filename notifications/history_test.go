@@ -0,0 +1,68 @@
+package notifications
+
+import (
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestHistory(t *testing.T) {
+	dir := t.TempDir()
+	h, err := NewHistory(configuration.EventHistory{Path: dir, Window: 2 * time.Hour})
+	if err != nil {
+		t.Fatalf("unexpected error opening history: %v", err)
+	}
+	defer h.Close()
+
+	start := time.Date(2024, 1, 1, 10, 30, 0, 0, time.UTC)
+	now := start
+	h.now = func() time.Time { return now }
+
+	write := func(id string) {
+		event := createTestEvent("push", "library/test", "manifest")
+		event.ID = id
+		event.Timestamp = now
+		if err := h.Write(event); err != nil {
+			t.Fatalf("unexpected error writing event: %v", err)
+		}
+	}
+	read := func(since, until time.Time) []string {
+		var ids []string
+		if err := h.Events(since, until, func(event Event) error {
+			ids = append(ids, event.ID)
+			return nil
+		}); err != nil {
+			t.Fatalf("unexpected error reading events: %v", err)
+		}
+		return ids
+	}
+
+	write("a")
+	now = now.Add(time.Hour)
+	write("b")
+	now = now.Add(time.Hour)
+	write("c")
+
+	if ids := read(time.Time{}, now.Add(time.Second)); len(ids) != 3 || ids[0] != "a" || ids[2] != "c" {
+		t.Fatalf("unexpected events: %v", ids)
+	}
+	if ids := read(start.Add(time.Minute), now); len(ids) != 1 || ids[0] != "b" {
+		t.Fatalf("unexpected events in window: %v", ids)
+	}
+
+	// The events older than the window are not read, and the file of the
+	// first event is removed
+	now = now.Add(2 * time.Hour)
+	write("d")
+	if ids := read(time.Time{}, now.Add(time.Second)); len(ids) != 2 || ids[0] != "c" || ids[1] != "d" {
+		t.Fatalf("unexpected events after rotation: %v", ids)
+	}
+	files, err := h.files()
+	if err != nil {
+		t.Fatalf("unexpected error listing files: %v", err)
+	}
+	if len(files) != 3 || !files[0].hour.Equal(start.Add(30*time.Minute)) {
+		t.Fatalf("unexpected files after rotation: %v", files)
+	}
+}
@@ -2,12 +2,15 @@ package notifications
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/distribution/distribution/v3/configuration"
 	events "github.com/docker/go-events"
 )
 
@@ -15,7 +18,9 @@ import (
 // very lightweight in that it only makes an attempt at an http request.
 // Reliability should be provided by the caller.
 type httpSink struct {
-	url string
+	url     string
+	encoder encoder
+	signing configuration.EndpointSigning
 
 	mu        sync.Mutex
 	closed    bool
@@ -34,6 +39,7 @@ func newHTTPSink(u string, timeout time.Duration, headers http.Header, transport
 	}
 	return &httpSink{
 		url:       u,
+		encoder:   encoder{format: FormatEnvelope, indent: true},
 		listeners: listeners,
 		client: &http.Client{
 			Transport: &headerRoundTripper{
@@ -64,24 +70,33 @@ func (hs *httpSink) Write(event events.Event) error {
 		return ErrSinkClosed
 	}
 
-	envelope := Envelope{
-		Events: []events.Event{event},
-	}
-
 	// TODO(stevvooe): It is not ideal to keep re-encoding the request body on
 	// retry but we are going to do it to keep the code simple. It is likely
 	// we could change the event struct to manage its own buffer.
 
-	p, err := json.MarshalIndent(envelope, "", "   ")
+	p, err := hs.encoder.encode(event)
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
 		}
-		return fmt.Errorf("%v: error marshaling event envelope: %v", hs, err)
+		return fmt.Errorf("%v: %v", hs, err)
 	}
 
-	body := bytes.NewReader(p)
-	resp, err := hs.client.Post(hs.url, EventsMediaType, body)
+	req, err := http.NewRequest(http.MethodPost, hs.url, bytes.NewReader(p.body))
+	if err != nil {
+		for _, listener := range hs.listeners {
+			listener.err(err, event)
+		}
+		return fmt.Errorf("%v: error posting: %v", hs, err)
+	}
+	req.Header.Set("Content-Type", p.contentType)
+	if hs.signing.Secret != "" {
+		req.Header.Set(hs.signing.Header, Sign([]byte(hs.signing.Secret), p.body))
+	}
+	for _, attr := range p.attributes {
+		req.Header.Set("ce-"+attr.name, attr.value)
+	}
+	resp, err := hs.client.Do(req)
 	if err != nil {
 		for _, listener := range hs.listeners {
 			listener.err(err, event)
@@ -112,6 +127,21 @@ func (hs *httpSink) Write(event events.Event) error {
 	}
 }
 
+// DefaultSignatureHeader is the header of the signatures of the requests to
+// the endpoints, when none is configured.
+const DefaultSignatureHeader = "X-Registry-Signature-256"
+
+// Sign returns the signature of the body of a request to an endpoint, as
+// sent in its signature header: sha256= followed by the HMAC-SHA256 of the
+// body with the secret, in hexadecimal. Endpoints authenticate the events by
+// comparing it, in constant time, to the signature of the body they
+// received.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
 // Close the endpoint
 func (hs *httpSink) Close() error {
 	hs.mu.Lock()
@@ -0,0 +1,109 @@
+package notifications
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+)
+
+// kafkaPublisher publishes messages to a Kafka topic with a franz-go client.
+// Messages are partitioned by key, so that the events of a repository keep
+// their order, and each batch is acknowledged by all the in-sync replicas.
+type kafkaPublisher struct {
+	brokers []string
+	topic   string
+	timeout time.Duration
+	client  *kgo.Client
+}
+
+func newKafkaPublisher(config configuration.KafkaEndpoint, timeout time.Duration) (*kafkaPublisher, error) {
+	if len(config.Brokers) == 0 {
+		return nil, errors.New("kafka endpoint requires brokers")
+	}
+	if config.Topic == "" {
+		return nil, errors.New("kafka endpoint requires a topic")
+	}
+	var mechanism sasl.Mechanism
+	switch config.SASL.Mechanism {
+	case "":
+	case "PLAIN":
+		mechanism = plain.Auth{User: config.SASL.Username, Pass: config.SASL.Password}.AsMechanism()
+	case "SCRAM-SHA-256":
+		mechanism = scram.Auth{User: config.SASL.Username, Pass: config.SASL.Password}.AsSha256Mechanism()
+	case "SCRAM-SHA-512":
+		mechanism = scram.Auth{User: config.SASL.Username, Pass: config.SASL.Password}.AsSha512Mechanism()
+	default:
+		return nil, fmt.Errorf("kafka endpoint: unsupported sasl mechanism %q", config.SASL.Mechanism)
+	}
+	tlsConfig, err := endpointTLSConfig(config.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("kafka endpoint tls: %v", err)
+	}
+	clientID := config.ClientID
+	if clientID == "" {
+		clientID = "registry"
+	}
+
+	options := []kgo.Opt{
+		kgo.SeedBrokers(config.Brokers...),
+		kgo.ClientID(clientID),
+		kgo.DefaultProduceTopic(config.Topic),
+		kgo.AllowAutoTopicCreation(),
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+		// idempotent writes require a permission on the cluster before
+		// Kafka 3.0, and the events are delivered at least once anyway
+		kgo.DisableIdempotentWrite(),
+		kgo.DialTimeout(timeout),
+		kgo.ProduceRequestTimeout(timeout),
+	}
+	if mechanism != nil {
+		options = append(options, kgo.SASL(mechanism))
+	}
+	if tlsConfig != nil {
+		options = append(options, kgo.DialTLSConfig(tlsConfig))
+	}
+	client, err := kgo.NewClient(options...)
+	if err != nil {
+		return nil, fmt.Errorf("kafka endpoint: %v", err)
+	}
+
+	return &kafkaPublisher{
+		brokers: config.Brokers,
+		topic:   config.Topic,
+		timeout: timeout,
+		client:  client,
+	}, nil
+}
+
+func (kp *kafkaPublisher) publish(messages []message) error {
+	records := make([]*kgo.Record, 0, len(messages))
+	for _, msg := range messages {
+		headers := make([]kgo.RecordHeader, 0, 1+len(msg.attributes))
+		headers = append(headers, kgo.RecordHeader{Key: "content-type", Value: []byte(msg.contentType)})
+		for _, attr := range msg.attributes {
+			headers = append(headers, kgo.RecordHeader{Key: "ce_" + attr.name, Value: []byte(attr.value)})
+		}
+		records = append(records, &kgo.Record{Key: msg.key, Value: msg.value, Headers: headers})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), kp.timeout)
+	defer cancel()
+	return kp.client.ProduceSync(ctx, records...).FirstErr()
+}
+
+func (kp *kafkaPublisher) close() error {
+	kp.client.Close()
+	return nil
+}
+
+func (kp *kafkaPublisher) String() string {
+	return fmt.Sprintf("kafka://%s/%s", strings.Join(kp.brokers, ","), kp.topic)
+}
@@ -0,0 +1,333 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/klauspost/compress/s2"
+	"github.com/twmb/franz-go/pkg/kmsg"
+)
+
+// kafkaTestBrokerVersions are the maximum versions of the requests the test
+// broker answers, those of Kafka 1.0.
+var kafkaTestBrokerVersions = map[int16]int16{
+	(&kmsg.ProduceRequest{}).Key():          3,
+	(&kmsg.MetadataRequest{}).Key():         4,
+	(&kmsg.SASLHandshakeRequest{}).Key():    1,
+	(&kmsg.SASLAuthenticateRequest{}).Key(): 0,
+	(&kmsg.ApiVersionsRequest{}).Key():      3,
+}
+
+// kafkaTestBroker is a single broker cluster, with a topic of two
+// partitions, recording the records produced to it.
+type kafkaTestBroker struct {
+	net.Listener
+	t     *testing.T
+	topic string
+
+	mu      sync.Mutex
+	auths   []string
+	records map[int32][]string
+	keys    map[int32][]string
+}
+
+func newKafkaTestBroker(t *testing.T, topic string) *kafkaTestBroker {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	kb := &kafkaTestBroker{
+		Listener: l,
+		t:        t,
+		topic:    topic,
+		records:  make(map[int32][]string),
+		keys:     make(map[int32][]string),
+	}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go kb.serve(conn)
+		}
+	}()
+	return kb
+}
+
+func (kb *kafkaTestBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	rd := bufio.NewReader(conn)
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(rd, size[:]); err != nil {
+			return
+		}
+		b := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(rd, b); err != nil {
+			return
+		}
+		req, correlationID, err := readKafkaTestRequest(b)
+		if err != nil {
+			kb.t.Errorf("invalid request: %v", err)
+			return
+		}
+		resp, err := kb.handle(req)
+		if err != nil {
+			kb.t.Errorf("unexpected request %d: %v", req.Key(), err)
+			return
+		}
+		resp.SetVersion(req.GetVersion())
+
+		out := make([]byte, 8)
+		binary.BigEndian.PutUint32(out[4:], uint32(correlationID))
+		// the header of the responses to ApiVersions is never flexible
+		if resp.IsFlexible() && req.Key() != (&kmsg.ApiVersionsRequest{}).Key() {
+			out = append(out, 0)
+		}
+		out = resp.AppendTo(out)
+		binary.BigEndian.PutUint32(out, uint32(len(out)-4))
+		if _, err := conn.Write(out); err != nil {
+			return
+		}
+	}
+}
+
+// readKafkaTestRequest reads the header and the body of a request.
+func readKafkaTestRequest(b []byte) (kmsg.Request, int32, error) {
+	if len(b) < 10 {
+		return nil, 0, errors.New("short header")
+	}
+	key := int16(binary.BigEndian.Uint16(b))
+	version := int16(binary.BigEndian.Uint16(b[2:]))
+	correlationID := int32(binary.BigEndian.Uint32(b[4:]))
+	req := kmsg.RequestForKey(key)
+	if req == nil || version > kafkaTestBrokerVersions[key] {
+		return nil, 0, fmt.Errorf("unsupported request %d v%d", key, version)
+	}
+	req.SetVersion(version)
+
+	b = b[8:]
+	clientID := int16(binary.BigEndian.Uint16(b))
+	b = b[2:]
+	if clientID > 0 {
+		b = b[clientID:]
+	}
+	if req.IsFlexible() {
+		// the tagged fields of the header
+		tags, n := binary.Uvarint(b)
+		b = b[n:]
+		for i := uint64(0); i < tags; i++ {
+			_, n := binary.Uvarint(b)
+			b = b[n:]
+			size, n := binary.Uvarint(b)
+			b = b[n+int(size):]
+		}
+	}
+	return req, correlationID, req.ReadFrom(b)
+}
+
+func (kb *kafkaTestBroker) handle(req kmsg.Request) (kmsg.Response, error) {
+	switch req := req.(type) {
+	case *kmsg.ApiVersionsRequest:
+		resp := kmsg.NewPtrApiVersionsResponse()
+		for key, version := range kafkaTestBrokerVersions {
+			resp.ApiKeys = append(resp.ApiKeys, kmsg.ApiVersionsResponseApiKey{ApiKey: key, MaxVersion: version})
+		}
+		return resp, nil
+	case *kmsg.SASLHandshakeRequest:
+		resp := kmsg.NewPtrSASLHandshakeResponse()
+		resp.SupportedMechanisms = []string{"PLAIN"}
+		return resp, nil
+	case *kmsg.SASLAuthenticateRequest:
+		kb.mu.Lock()
+		kb.auths = append(kb.auths, string(req.SASLAuthBytes))
+		kb.mu.Unlock()
+		return kmsg.NewPtrSASLAuthenticateResponse(), nil
+	case *kmsg.MetadataRequest:
+		host, port, _ := net.SplitHostPort(kb.Addr().String())
+		p, _ := strconv.Atoi(port)
+		resp := kmsg.NewPtrMetadataResponse()
+		resp.Brokers = []kmsg.MetadataResponseBroker{{NodeID: 1, Host: host, Port: int32(p)}}
+		resp.ControllerID = 1
+		topic := kmsg.NewMetadataResponseTopic()
+		topic.Topic = kmsg.StringPtr(kb.topic)
+		for i := int32(0); i < 2; i++ {
+			partition := kmsg.NewMetadataResponseTopicPartition()
+			partition.Partition = i
+			partition.Leader = 1
+			partition.Replicas = []int32{1}
+			partition.ISR = []int32{1}
+			topic.Partitions = append(topic.Partitions, partition)
+		}
+		resp.Topics = append(resp.Topics, topic)
+		return resp, nil
+	case *kmsg.ProduceRequest:
+		if req.Acks != -1 {
+			return nil, fmt.Errorf("unexpected acks %d", req.Acks)
+		}
+		resp := kmsg.NewPtrProduceResponse()
+		for _, t := range req.Topics {
+			if t.Topic != kb.topic {
+				return nil, fmt.Errorf("unexpected topic %s", t.Topic)
+			}
+			topic := kmsg.NewProduceResponseTopic()
+			topic.Topic = t.Topic
+			for _, p := range t.Partitions {
+				if err := kb.readBatch(p.Partition, p.Records); err != nil {
+					return nil, fmt.Errorf("invalid record batch: %v", err)
+				}
+				partition := kmsg.NewProduceResponseTopicPartition()
+				partition.Partition = p.Partition
+				topic.Partitions = append(topic.Partitions, partition)
+			}
+			resp.Topics = append(resp.Topics, topic)
+		}
+		return resp, nil
+	}
+	return nil, errors.New("not implemented")
+}
+
+// readBatch checks a record batch, and records its records.
+func (kb *kafkaTestBroker) readBatch(partition int32, b []byte) error {
+	var batch kmsg.RecordBatch
+	if err := batch.ReadFrom(b); err != nil {
+		return err
+	}
+	if batch.Magic != 2 {
+		return errors.New("invalid magic")
+	}
+	// the checksum covers the batch from its attributes
+	if len(b) < 21 || uint32(batch.CRC) != crc32.Checksum(b[21:], castagnoli) {
+		return errors.New("invalid checksum")
+	}
+	records := batch.Records
+	switch codec := batch.Attributes & 0x07; codec {
+	case 0:
+	case 2:
+		var err error
+		if records, err = s2.Decode(nil, records); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unexpected compression %d", codec)
+	}
+
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	for i := int32(0); i < batch.NumRecords; i++ {
+		length, n := binary.Varint(records)
+		if n <= 0 || n+int(length) > len(records) {
+			return errors.New("invalid record length")
+		}
+		var r kmsg.Record
+		if err := r.ReadFrom(records[:n+int(length)]); err != nil {
+			return err
+		}
+		records = records[n+int(length):]
+		if r.OffsetDelta != i {
+			return errors.New("invalid offset delta")
+		}
+		if len(r.Headers) != 1 || r.Headers[0].Key != "content-type" || string(r.Headers[0].Value) != EventsMediaType {
+			return errors.New("invalid content type header")
+		}
+		kb.keys[partition] = append(kb.keys[partition], string(r.Key))
+		kb.records[partition] = append(kb.records[partition], string(r.Value))
+	}
+	if len(records) != 0 {
+		return errors.New("invalid records")
+	}
+	return nil
+}
+
+func TestKafkaPublisher(t *testing.T) {
+	kb := newKafkaTestBroker(t, "registry-events")
+	defer kb.Close()
+
+	config := configuration.KafkaEndpoint{
+		// the first broker is unreachable
+		Brokers: []string{"127.0.0.1:1", kb.Addr().String()},
+		Topic:   "registry-events",
+	}
+	config.SASL.Mechanism = "PLAIN"
+	config.SASL.Username = "registry"
+	config.SASL.Password = "secret"
+	kp, err := newKafkaPublisher(config, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer kp.close()
+
+	var messages []message
+	for i := 0; i < 10; i++ {
+		repo := "library/repo" + strconv.Itoa(i%3)
+		messages = append(messages, message{key: []byte(repo), value: []byte(repo + " event " + strconv.Itoa(i)), contentType: EventsMediaType})
+	}
+	if err := kp.publish(messages[:5]); err != nil {
+		t.Fatalf("unexpected error publishing: %v", err)
+	}
+	if err := kp.publish(messages[5:]); err != nil {
+		t.Fatalf("unexpected error publishing: %v", err)
+	}
+
+	kb.mu.Lock()
+	defer kb.mu.Unlock()
+	if len(kb.auths) == 0 {
+		t.Fatal("expected the client to authenticate")
+	}
+	for _, auth := range kb.auths {
+		if auth != "\x00registry\x00secret" {
+			t.Fatalf("unexpected authentication %q", auth)
+		}
+	}
+	// the events of a repository are kept in order in a partition
+	var total int
+	partitions := make(map[string]int32)
+	for partition, keys := range kb.keys {
+		total += len(keys)
+		last := make(map[string]int)
+		for i, key := range keys {
+			value := kb.records[partition][i]
+			if !strings.HasPrefix(value, key+" event ") {
+				t.Fatalf("unexpected record %q with key %q", value, key)
+			}
+			n, _ := strconv.Atoi(strings.TrimPrefix(value, key+" event "))
+			if prev, ok := last[key]; ok && prev > n {
+				t.Fatalf("records of %s out of order", key)
+			}
+			last[key] = n
+			if other, ok := partitions[key]; ok && other != partition {
+				t.Fatalf("records of %s in partitions %d and %d", key, partition, other)
+			}
+			partitions[key] = partition
+		}
+	}
+	if total != len(messages) {
+		t.Fatalf("expected %d records, got %d", len(messages), total)
+	}
+}
+
+func TestKafkaPublisherInvalid(t *testing.T) {
+	invalid := configuration.KafkaEndpoint{Brokers: []string{"localhost:9092"}, Topic: "registry"}
+	invalid.SASL.Mechanism = "GSSAPI"
+	for _, config := range []configuration.KafkaEndpoint{
+		{Topic: "registry"},
+		{Brokers: []string{"localhost:9092"}},
+		invalid,
+	} {
+		if _, err := newKafkaPublisher(config, time.Second); err == nil {
+			t.Errorf("expected %+v to be invalid", config)
+		}
+	}
+}
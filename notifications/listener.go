@@ -33,6 +33,12 @@ type RepoListener interface {
 	RepoDeleted(repo reference.Named) error
 }
 
+// IntegrityListener is notified of the blobs of the repositories found
+// corrupt by the integrity checks of the storage.
+type IntegrityListener interface {
+	BlobCorrupt(repo reference.Named, desc distribution.Descriptor) error
+}
+
 // Listener combines all repository events into a single interface.
 type Listener interface {
 	ManifestListener
@@ -87,6 +93,16 @@ func (rl *repositoryListener) Blobs(ctx context.Context) distribution.BlobStore
 	}
 }
 
+// Referrers forwards to the underlying repository, if it supports the
+// referrers API.
+func (rl *repositoryListener) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	referrers, ok := rl.Repository.(distribution.ReferrerService)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return referrers.Referrers(ctx, subject, artifactType)
+}
+
 type manifestServiceListener struct {
 	distribution.ManifestService
 	parent *repositoryListener
@@ -252,6 +268,16 @@ func (rl *repositoryListener) Tags(ctx context.Context) distribution.TagService
 	}
 }
 
+// History returns the history of the tag, when the wrapped tag service
+// records it.
+func (tagSL *tagServiceListener) History(ctx context.Context, tag string) ([]distribution.TagHistoryEntry, error) {
+	history, ok := tagSL.TagService.(distribution.TagHistoryProvider)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return history.History(ctx, tag)
+}
+
 func (tagSL *tagServiceListener) Untag(ctx context.Context, tag string) error {
 	if err := tagSL.TagService.Untag(ctx, tag); err != nil {
 		return err
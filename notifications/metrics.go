@@ -29,6 +29,7 @@ type EndpointMetrics struct {
 	Successes int            // total events written successfully
 	Failures  int            // total events failed
 	Errors    int            // total events errored
+	Dropped   int            // total events dropped from the queue
 	Statuses  map[string]int // status code histogram, per call event
 }
 
@@ -99,6 +100,38 @@ func (emsl *endpointMetricsHTTPStatusListener) err(err error, event events.Event
 	eventsCounter.WithValues("Errors", emsl.EndpointName).Inc(1)
 }
 
+// publishListener returns the listener for the message broker sinks that
+// updates the relevant counters.
+func (sm *safeMetrics) publishListener() publishListener {
+	return &endpointMetricsPublishListener{
+		safeMetrics: sm,
+	}
+}
+
+// endpointMetricsPublishListener increments counters related to message
+// broker sinks for the relevant events.
+type endpointMetricsPublishListener struct {
+	*safeMetrics
+}
+
+var _ publishListener = &endpointMetricsPublishListener{}
+
+func (empl *endpointMetricsPublishListener) published(event events.Event) {
+	empl.safeMetrics.Lock()
+	defer empl.safeMetrics.Unlock()
+	empl.Successes++
+
+	eventsCounter.WithValues("Successes", empl.EndpointName).Inc(1)
+}
+
+func (empl *endpointMetricsPublishListener) err(err error, event events.Event) {
+	empl.safeMetrics.Lock()
+	defer empl.safeMetrics.Unlock()
+	empl.Errors++
+
+	eventsCounter.WithValues("Errors", empl.EndpointName).Inc(1)
+}
+
 // endpointMetricsEventQueueListener maintains the incoming events counter and
 // the queues pending count.
 type endpointMetricsEventQueueListener struct {
@@ -123,6 +156,26 @@ func (eqc *endpointMetricsEventQueueListener) egress(event events.Event) {
 	pendingGauge.WithValues(eqc.EndpointName).Dec(1)
 }
 
+// restored counts the events restored from a persistent queue as pending.
+func (sm *safeMetrics) restored(n int) {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.Pending += n
+
+	pendingGauge.WithValues(sm.EndpointName).Inc(float64(n))
+}
+
+// dropped counts the pending events dropped from a queue.
+func (sm *safeMetrics) dropped(n int) {
+	sm.Lock()
+	defer sm.Unlock()
+	sm.Pending -= n
+	sm.Dropped += n
+
+	eventsCounter.WithValues("Dropped", sm.EndpointName).Inc(float64(n))
+	pendingGauge.WithValues(sm.EndpointName).Dec(float64(n))
+}
+
 // endpoints is global registry of endpoints used to report metrics to expvar
 var endpoints struct {
 	registered []*Endpoint
@@ -0,0 +1,117 @@
+package notifications
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/nats-io/nats.go"
+)
+
+// natsPublisher publishes messages to a NATS subject with a nats.go client.
+// Each batch is flushed, which the server only confirms once it processed
+// the messages. The connection is established on the first publication, and
+// again on the next one once it failed.
+type natsPublisher struct {
+	servers []string
+	subject string
+	options []nats.Option
+	timeout time.Duration
+
+	conn *nats.Conn
+}
+
+func newNATSPublisher(config configuration.NATSEndpoint, timeout time.Duration) (*natsPublisher, error) {
+	if len(config.Servers) == 0 {
+		return nil, errors.New("nats endpoint requires servers")
+	}
+	if config.Subject == "" || strings.ContainsAny(config.Subject, " \t\r\n") {
+		return nil, fmt.Errorf("nats endpoint requires a valid subject: %q", config.Subject)
+	}
+	tlsConfig, err := endpointTLSConfig(config.TLS)
+	if err != nil {
+		return nil, fmt.Errorf("nats endpoint tls: %v", err)
+	}
+
+	options := []nats.Option{
+		nats.Name("registry"),
+		nats.Timeout(timeout),
+		// the servers are tried in order, and the failed publications
+		// are retried by the sink on a new connection
+		nats.DontRandomize(),
+		nats.NoReconnect(),
+	}
+	if config.Username != "" || config.Password != "" {
+		options = append(options, nats.UserInfo(config.Username, config.Password))
+	}
+	if config.Token != "" {
+		options = append(options, nats.Token(config.Token))
+	}
+	if tlsConfig != nil {
+		options = append(options, nats.Secure(tlsConfig))
+	}
+
+	return &natsPublisher{
+		servers: config.Servers,
+		subject: config.Subject,
+		options: options,
+		timeout: timeout,
+	}, nil
+}
+
+func (np *natsPublisher) publish(messages []message) error {
+	if np.conn == nil {
+		conn, err := nats.Connect(strings.Join(np.servers, ","), np.options...)
+		if err != nil {
+			return fmt.Errorf("error connecting to nats: %v", err)
+		}
+		np.conn = conn
+	}
+
+	err := np.send(messages)
+	if err != nil {
+		np.close()
+	}
+	return err
+}
+
+func (np *natsPublisher) send(messages []message) error {
+	for _, msg := range messages {
+		m := &nats.Msg{Subject: np.subject, Data: msg.value}
+		if len(msg.attributes) > 0 {
+			// the context attributes of the binary mode of CloudEvents
+			// are sent as headers
+			m.Header = nats.Header{}
+			m.Header.Set("Content-Type", msg.contentType)
+			for _, attr := range msg.attributes {
+				m.Header.Set("ce-"+attr.name, attr.value)
+			}
+		}
+		if err := np.conn.PublishMsg(m); err != nil {
+			return err
+		}
+	}
+	return np.conn.FlushTimeout(np.timeout)
+}
+
+func (np *natsPublisher) close() error {
+	if np.conn == nil {
+		return nil
+	}
+	np.conn.Close()
+	np.conn = nil
+	return nil
+}
+
+func (np *natsPublisher) String() string {
+	hosts := make([]string, 0, len(np.servers))
+	for _, server := range np.servers {
+		if _, host, ok := strings.Cut(server, "://"); ok {
+			server = host
+		}
+		hosts = append(hosts, server)
+	}
+	return fmt.Sprintf("nats://%s/%s", strings.Join(hosts, ","), np.subject)
+}
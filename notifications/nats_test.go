@@ -0,0 +1,204 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// natsTestServer speaks enough of the NATS protocol to record the
+// publications of its clients.
+type natsTestServer struct {
+	net.Listener
+
+	mu       sync.Mutex
+	connects []natsTestConnect
+	subjects []string
+	headers  []string
+	payloads []string
+}
+
+// natsTestConnect are the options of the CONNECT command recorded by the
+// server.
+type natsTestConnect struct {
+	User    string `json:"user"`
+	Pass    string `json:"pass"`
+	Headers bool   `json:"headers"`
+}
+
+func newNATSTestServer(t *testing.T) *natsTestServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ns := &natsTestServer{Listener: l}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go ns.serve(conn)
+		}
+	}()
+	return ns
+}
+
+func (ns *natsTestServer) serve(conn net.Conn) {
+	defer conn.Close()
+	fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"proto\":1,\"headers\":true,\"max_payload\":1048576}\r\n")
+	rd := bufio.NewReader(conn)
+	for {
+		line, err := readNATSLine(rd)
+		if err != nil {
+			return
+		}
+		op, args, _ := strings.Cut(line, " ")
+		switch op {
+		case "CONNECT":
+			var options natsTestConnect
+			if err := json.Unmarshal([]byte(args), &options); err != nil {
+				fmt.Fprintf(conn, "-ERR 'Invalid CONNECT'\r\n")
+				return
+			}
+			ns.mu.Lock()
+			ns.connects = append(ns.connects, options)
+			ns.mu.Unlock()
+		case "PING":
+			fmt.Fprintf(conn, "PONG\r\n")
+		case "PUB", "HPUB":
+			fields := strings.Fields(args)
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			var headerSize int
+			if op == "HPUB" {
+				headerSize, _ = strconv.Atoi(fields[len(fields)-2])
+			}
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(rd, payload); err != nil {
+				return
+			}
+			ns.mu.Lock()
+			ns.subjects = append(ns.subjects, fields[0])
+			ns.headers = append(ns.headers, string(payload[:headerSize]))
+			ns.payloads = append(ns.payloads, string(payload[headerSize:size]))
+			ns.mu.Unlock()
+		default:
+			fmt.Fprintf(conn, "-ERR 'Unknown Protocol Operation'\r\n")
+			return
+		}
+	}
+}
+
+func readNATSLine(rd *bufio.Reader) (string, error) {
+	line, err := rd.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func TestNATSPublisher(t *testing.T) {
+	ns := newNATSTestServer(t)
+	defer ns.Close()
+
+	np, err := newNATSPublisher(configuration.NATSEndpoint{
+		// the first server is unreachable
+		Servers:  []string{"nats://127.0.0.1:1", "nats://" + ns.Addr().String()},
+		Subject:  "registry.events",
+		Username: "registry",
+		Password: "secret",
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer np.close()
+
+	messages := []message{{value: []byte(`{"events":[1]}`)}, {value: []byte(`{"events":[2]}`)}}
+	if err := np.publish(messages); err != nil {
+		t.Fatalf("unexpected error publishing: %v", err)
+	}
+	// a lost connection is reestablished on the next publication
+	np.conn.Close()
+	if err := np.publish(messages[:1]); err == nil {
+		t.Fatalf("expected an error publishing on a closed connection")
+	}
+	if err := np.publish(messages[:1]); err != nil {
+		t.Fatalf("unexpected error publishing after reconnecting: %v", err)
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if len(ns.connects) != 2 || ns.connects[0].User != "registry" || ns.connects[0].Pass != "secret" {
+		t.Fatalf("unexpected connections: %+v", ns.connects)
+	}
+	expected := []string{`{"events":[1]}`, `{"events":[2]}`, `{"events":[1]}`}
+	if strings.Join(ns.payloads, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected payloads: %q", ns.payloads)
+	}
+	for _, subject := range ns.subjects {
+		if subject != "registry.events" {
+			t.Fatalf("unexpected subject %q", subject)
+		}
+	}
+}
+
+func TestNATSPublisherHeaders(t *testing.T) {
+	ns := newNATSTestServer(t)
+	defer ns.Close()
+
+	np, err := newNATSPublisher(configuration.NATSEndpoint{
+		Servers: []string{ns.Addr().String()},
+		Subject: "registry.events",
+	}, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer np.close()
+
+	msg := message{
+		value:       []byte(`{"id":"1"}`),
+		contentType: "application/json",
+		attributes:  []attribute{{"specversion", "1.0"}, {"id", "1"}},
+	}
+	if err := np.publish([]message{msg}); err != nil {
+		t.Fatalf("unexpected error publishing: %v", err)
+	}
+
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+	if len(ns.headers) != 1 || ns.payloads[0] != `{"id":"1"}` {
+		t.Fatalf("unexpected headers %q and payloads %q", ns.headers, ns.payloads)
+	}
+	// the order of the headers is not kept
+	headers := strings.Split(strings.TrimSuffix(ns.headers[0], "\r\n\r\n"), "\r\n")
+	sort.Strings(headers[1:])
+	expected := []string{"NATS/1.0", "Content-Type: application/json", "ce-id: 1", "ce-specversion: 1.0"}
+	if strings.Join(headers, "\n") != strings.Join(expected, "\n") {
+		t.Fatalf("unexpected headers %q", headers)
+	}
+	if !ns.connects[0].Headers {
+		t.Fatalf("expected the client to support headers")
+	}
+}
+
+func TestNATSPublisherInvalid(t *testing.T) {
+	for _, config := range []configuration.NATSEndpoint{
+		{Subject: "registry"},
+		{Servers: []string{"localhost"}},
+		{Servers: []string{"localhost"}, Subject: "registry events"},
+	} {
+		if _, err := newNATSPublisher(config, time.Second); err == nil {
+			t.Errorf("expected %+v to be invalid", config)
+		}
+	}
+}
@@ -1,7 +1,6 @@
 package notifications
 
 import (
-	"container/list"
 	"fmt"
 	"sync"
 
@@ -10,11 +9,12 @@ import (
 )
 
 // eventQueue accepts all messages into a queue for asynchronous consumption
-// by a sink. It is unbounded and thread safe but the sink must be reliable or
-// events will be dropped.
+// by a sink. It is thread safe but the sink must be reliable or events will
+// be dropped. The events are removed from the queue once written to the
+// sink, so that those of a persistent queue are written at least once.
 type eventQueue struct {
 	sink      events.Sink
-	events    *list.List
+	store     eventStore
 	listeners []eventQueueListener
 	cond      *sync.Cond
 	mu        sync.Mutex
@@ -30,9 +30,15 @@ type eventQueueListener interface {
 // newEventQueue returns a queue to the provided sink. If the updater is non-
 // nil, it will be called to update pending metrics on ingress and egress.
 func newEventQueue(sink events.Sink, listeners ...eventQueueListener) *eventQueue {
+	return newStoredEventQueue(sink, newMemoryStore(), listeners...)
+}
+
+// newStoredEventQueue returns a queue to the provided sink, of the events of
+// the store.
+func newStoredEventQueue(sink events.Sink, store eventStore, listeners ...eventQueueListener) *eventQueue {
 	eq := eventQueue{
 		sink:      sink,
-		events:    list.New(),
+		store:     store,
 		listeners: listeners,
 	}
 
@@ -54,13 +60,13 @@ func (eq *eventQueue) Write(event events.Event) error {
 	for _, listener := range eq.listeners {
 		listener.ingress(event)
 	}
-	eq.events.PushBack(event)
+	eq.store.push(event)
 	eq.cond.Signal() // signal waiters
 
 	return nil
 }
 
-// Close shuts down the event queue, flushing
+// Close shuts down the event queue, flushing it unless it is persistent
 func (eq *eventQueue) Close() error {
 	eq.mu.Lock()
 	defer eq.mu.Unlock()
@@ -74,6 +80,9 @@ func (eq *eventQueue) Close() error {
 	eq.cond.Signal() // signal flushes queue
 	eq.cond.Wait()   // wait for signal from last flush
 
+	if err := eq.store.close(); err != nil {
+		logrus.Errorf("eventqueue: error closing the queue: %v", err)
+	}
 	return eq.sink.Close()
 }
 
@@ -90,6 +99,10 @@ func (eq *eventQueue) run() {
 			logrus.Warnf("eventqueue: error writing events to %v, these events will be lost: %v", eq.sink, err)
 		}
 
+		eq.mu.Lock()
+		eq.store.pop(1)
+		eq.mu.Unlock()
+
 		for _, listener := range eq.listeners {
 			listener.egress(event)
 		}
@@ -98,25 +111,23 @@ func (eq *eventQueue) run() {
 
 // next encompasses the critical section of the run loop. When the queue is
 // empty, it will block on the condition. If new data arrives, it will wake
-// and return a block. When closed, a nil slice will be returned.
+// and return a block. When closed, a nil slice will be returned, leaving the
+// events of a persistent queue for the next start.
 func (eq *eventQueue) next() events.Event {
 	eq.mu.Lock()
 	defer eq.mu.Unlock()
 
-	for eq.events.Len() < 1 {
-		if eq.closed {
+	for {
+		if eq.closed && (eq.store.len() == 0 || eq.store.persistent()) {
 			eq.cond.Broadcast()
 			return nil
 		}
+		if front := eq.store.peek(1); len(front) > 0 {
+			return front[0]
+		}
 
 		eq.cond.Wait()
 	}
-
-	front := eq.events.Front()
-	block := front.Value.(events.Event)
-	eq.events.Remove(front)
-
-	return block
 }
 
 // ignoredSink discards events with ignored target media types and actions.
@@ -0,0 +1,495 @@
+package notifications
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	events "github.com/docker/go-events"
+	"github.com/sirupsen/logrus"
+)
+
+// eventStore stores the events queued for an endpoint, in order. The events
+// are only removed once delivered, so that the events of a persistent store
+// are delivered at least once. It is not thread safe.
+type eventStore interface {
+	// push stores the event at the back of the queue
+	push(event events.Event)
+
+	// peek returns up to n events from the front of the queue
+	peek(n int) []events.Event
+
+	// pop removes the n events at the front of the queue
+	pop(n int)
+
+	len() int
+
+	// persistent reports whether the events outlive the store
+	persistent() bool
+
+	close() error
+}
+
+// memoryStore is an unbounded store of the events in memory.
+type memoryStore struct {
+	events []events.Event
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{}
+}
+
+func (ms *memoryStore) push(event events.Event) {
+	ms.events = append(ms.events, event)
+}
+
+func (ms *memoryStore) peek(n int) []events.Event {
+	if n > len(ms.events) {
+		n = len(ms.events)
+	}
+	return append([]events.Event(nil), ms.events[:n]...)
+}
+
+func (ms *memoryStore) pop(n int) {
+	for i := 0; i < n; i++ {
+		ms.events[i] = nil
+	}
+	ms.events = ms.events[n:]
+}
+
+func (ms *memoryStore) len() int         { return len(ms.events) }
+func (ms *memoryStore) persistent() bool { return false }
+func (ms *memoryStore) close() error     { return nil }
+
+const (
+	// defaultQueueMaxSize is the maximum size of a persistent queue when
+	// none is configured.
+	defaultQueueMaxSize = 1 << 30
+
+	// maxSegmentSize is the size from which the segments of a persistent
+	// queue are rotated, so that the delivered events are removed. Queues
+	// smaller than four segments have smaller segments.
+	maxSegmentSize = 8 << 20
+
+	// maxRecordSize bounds the records read from the segments.
+	maxRecordSize = 64 << 20
+
+	// recordHeaderSize is the size of the length and checksum of the
+	// records of the segments.
+	recordHeaderSize = 8
+
+	segmentSuffix = ".wal"
+	headFile      = "head"
+)
+
+// castagnoli is the table of the checksums of the records of the segments.
+var castagnoli = crc32.MakeTable(crc32.Castagnoli)
+
+// segment is a file of the write-ahead log of a diskStore. Segments are
+// named after the index of their first record.
+type segment struct {
+	first uint64
+	count int
+	size  int64
+}
+
+func (s segment) path(dir string) string {
+	return filepath.Join(dir, fmt.Sprintf("%020d%s", s.first, segmentSuffix))
+}
+
+// diskStore is a store of the events in a write-ahead log, surviving the
+// restarts of the registry. The log is made of segments of records, each a
+// length, a checksum and the event in JSON, and the index of the first
+// event not delivered is kept in the head file. The log is bounded: the
+// events pushed while it is full are dropped, as are the events older than
+// the retention once they reach the front of the queue.
+type diskStore struct {
+	dir         string
+	maxSize     int64
+	segmentSize int64
+	retention   time.Duration
+	dropped     func(n int)
+	now         func() time.Time
+
+	segments []segment
+	head     uint64 // index of the first event not popped
+	size     int64
+	w        *os.File // last segment, appended to
+
+	// the events peeked and not yet popped, and the reader of the events
+	// following them
+	peeked []events.Event
+	r      *os.File
+	rd     *bufio.Reader
+	rNext  uint64 // index of the next event read
+}
+
+// newDiskStore opens the persistent queue in the directory, creating it if
+// needed. The events dropped from the queue are reported to dropped.
+func newDiskStore(config configuration.EndpointQueue, dropped func(n int)) (*diskStore, error) {
+	if err := os.MkdirAll(config.Path, 0o700); err != nil {
+		return nil, err
+	}
+	ds := &diskStore{
+		dir:       config.Path,
+		maxSize:   config.MaxSize,
+		retention: config.Retention,
+		dropped:   dropped,
+		now:       time.Now,
+	}
+	if ds.maxSize <= 0 {
+		ds.maxSize = defaultQueueMaxSize
+	}
+	ds.segmentSize = ds.maxSize / 4
+	if ds.segmentSize > maxSegmentSize {
+		ds.segmentSize = maxSegmentSize
+	}
+	if err := ds.open(); err != nil {
+		ds.close()
+		return nil, fmt.Errorf("error opening event queue %s: %v", config.Path, err)
+	}
+	return ds, nil
+}
+
+// open recovers the segments and the head of the queue, truncating the
+// record partially written by a crash, if any.
+func (ds *diskStore) open() error {
+	entries, err := os.ReadDir(ds.dir)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		first, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		ds.segments = append(ds.segments, segment{first: first})
+	}
+	sort.Slice(ds.segments, func(i, j int) bool { return ds.segments[i].first < ds.segments[j].first })
+
+	for i := range ds.segments {
+		if err := ds.scan(&ds.segments[i]); err != nil {
+			return err
+		}
+		ds.size += ds.segments[i].size
+	}
+
+	p, err := os.ReadFile(filepath.Join(ds.dir, headFile))
+	switch {
+	case err == nil:
+		if ds.head, err = strconv.ParseUint(strings.TrimSpace(string(p)), 10, 64); err != nil {
+			return fmt.Errorf("invalid head: %v", err)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+	if len(ds.segments) == 0 {
+		ds.segments = []segment{{first: ds.head}}
+	}
+	if first := ds.segments[0].first; ds.head < first {
+		ds.head = first
+	}
+	if tail := ds.tail(); ds.head > tail {
+		ds.head = tail
+	}
+
+	last := ds.segments[len(ds.segments)-1]
+	if ds.w, err = os.OpenFile(last.path(ds.dir), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600); err != nil {
+		return err
+	}
+	ds.rNext = ds.head
+	ds.removeDelivered()
+	return nil
+}
+
+// scan counts the records of the segment, and truncates the segment after
+// its last valid record.
+func (ds *diskStore) scan(s *segment) error {
+	f, err := os.OpenFile(s.path(ds.dir), os.O_RDWR, 0o600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	for {
+		payload, err := readRecord(rd)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			logrus.Warnf("eventqueue: truncating %s after %d events: %v", s.path(ds.dir), s.count, err)
+			return f.Truncate(s.size)
+		}
+		s.count++
+		s.size += int64(recordHeaderSize + len(payload))
+	}
+}
+
+// tail returns the index of the next event pushed.
+func (ds *diskStore) tail() uint64 {
+	last := ds.segments[len(ds.segments)-1]
+	return last.first + uint64(last.count)
+}
+
+func (ds *diskStore) push(event events.Event) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logrus.Errorf("eventqueue: dropping event: %v", err)
+		ds.dropped(1)
+		return
+	}
+	last := &ds.segments[len(ds.segments)-1]
+	if last.size >= ds.segmentSize {
+		if err := ds.rotate(); err != nil {
+			logrus.Errorf("eventqueue: dropping event: %v", err)
+			ds.dropped(1)
+			return
+		}
+		last = &ds.segments[len(ds.segments)-1]
+	}
+
+	size := int64(recordHeaderSize + len(payload))
+	if ds.size+size > ds.maxSize {
+		logrus.Errorf("eventqueue: %s is full, dropping event", ds.dir)
+		ds.dropped(1)
+		return
+	}
+
+	record := make([]byte, size)
+	binary.BigEndian.PutUint32(record, uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:], crc32.Checksum(payload, castagnoli))
+	copy(record[recordHeaderSize:], payload)
+	if _, err := ds.w.Write(record); err != nil {
+		// the partial record is truncated on the next start
+		logrus.Errorf("eventqueue: dropping event: %v", err)
+		ds.dropped(1)
+		return
+	}
+	last.count++
+	last.size += size
+	ds.size += size
+}
+
+// rotate starts a new segment.
+func (ds *diskStore) rotate() error {
+	next := segment{first: ds.tail()}
+	w, err := os.OpenFile(next.path(ds.dir), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := ds.w.Sync(); err != nil {
+		logrus.Warnf("eventqueue: error syncing %s: %v", ds.dir, err)
+	}
+	ds.w.Close()
+	ds.w = w
+	ds.segments = append(ds.segments, next)
+	ds.removeDelivered()
+	return nil
+}
+
+func (ds *diskStore) peek(n int) []events.Event {
+	for len(ds.peeked) < n && ds.rNext < ds.tail() {
+		event, err := ds.read()
+		if err != nil && len(ds.peeked) > 0 {
+			// deliver the events read first
+			ds.closeReader()
+			break
+		}
+		if err != nil {
+			// the rest of the segment can't be read: skip it
+			logrus.Errorf("eventqueue: skipping the events of %s from %d: %v", ds.dir, ds.rNext, err)
+			ds.skipSegment()
+			continue
+		}
+		if len(ds.peeked) == 0 && ds.expired(event) {
+			ds.head++
+			ds.dropped(1)
+			ds.commit()
+			continue
+		}
+		ds.peeked = append(ds.peeked, event)
+	}
+	if n > len(ds.peeked) {
+		n = len(ds.peeked)
+	}
+	return ds.peeked[:n:n]
+}
+
+// read reads the next event, moving to the next segment at the end of one.
+func (ds *diskStore) read() (events.Event, error) {
+	for {
+		if ds.r == nil {
+			if err := ds.openReader(); err != nil {
+				return nil, err
+			}
+		}
+		payload, err := readRecord(ds.rd)
+		if err == io.EOF {
+			// the next event is in the next segment
+			ds.closeReader()
+			continue
+		}
+		if err != nil {
+			ds.closeReader()
+			return nil, err
+		}
+
+		var event Event
+		if err := json.Unmarshal(payload, &event); err != nil {
+			ds.closeReader()
+			return nil, err
+		}
+		ds.rNext++
+		return event, nil
+	}
+}
+
+// openReader opens the segment of the next event read, positioned on the
+// event.
+func (ds *diskStore) openReader() error {
+	for _, s := range ds.segments {
+		if ds.rNext < s.first || ds.rNext >= s.first+uint64(s.count) {
+			continue
+		}
+		f, err := os.Open(s.path(ds.dir))
+		if err != nil {
+			return err
+		}
+		ds.r, ds.rd = f, bufio.NewReader(f)
+		for i := s.first; i < ds.rNext; i++ {
+			if _, err := readRecord(ds.rd); err != nil {
+				ds.closeReader()
+				return err
+			}
+		}
+		return nil
+	}
+	return fmt.Errorf("no segment has event %d", ds.rNext)
+}
+
+func (ds *diskStore) closeReader() {
+	if ds.r != nil {
+		ds.r.Close()
+		ds.r, ds.rd = nil, nil
+	}
+}
+
+// skipSegment drops the events of the segment the reader is on, from the
+// front of the queue.
+func (ds *diskStore) skipSegment() {
+	ds.closeReader()
+	end := ds.tail()
+	for _, s := range ds.segments {
+		if ds.rNext >= s.first && ds.rNext < s.first+uint64(s.count) {
+			end = s.first + uint64(s.count)
+			break
+		}
+	}
+	ds.dropped(int(end - ds.head))
+	ds.head, ds.rNext = end, end
+	ds.commit()
+}
+
+func (ds *diskStore) expired(event events.Event) bool {
+	e, ok := event.(Event)
+	return ok && ds.retention > 0 && !e.Timestamp.IsZero() && ds.now().Sub(e.Timestamp) > ds.retention
+}
+
+func (ds *diskStore) pop(n int) {
+	if n == 0 {
+		return
+	}
+	if n > len(ds.peeked) {
+		n = len(ds.peeked)
+	}
+	ds.peeked = ds.peeked[n:]
+	ds.head += uint64(n)
+	ds.commit()
+}
+
+// commit writes the head of the queue, and removes the segments delivered.
+func (ds *diskStore) commit() {
+	tmp := filepath.Join(ds.dir, headFile+".tmp")
+	err := os.WriteFile(tmp, []byte(strconv.FormatUint(ds.head, 10)), 0o600)
+	if err == nil {
+		err = os.Rename(tmp, filepath.Join(ds.dir, headFile))
+	}
+	if err != nil {
+		// the events are delivered again on the next start
+		logrus.Errorf("eventqueue: error writing the head of %s: %v", ds.dir, err)
+	}
+	ds.removeDelivered()
+}
+
+// removeDelivered removes the segments whose events are all delivered,
+// other than the last one.
+func (ds *diskStore) removeDelivered() {
+	for len(ds.segments) > 1 && ds.segments[0].first+uint64(ds.segments[0].count) <= ds.head {
+		if err := os.Remove(ds.segments[0].path(ds.dir)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Errorf("eventqueue: error removing %s: %v", ds.segments[0].path(ds.dir), err)
+			return
+		}
+		ds.size -= ds.segments[0].size
+		ds.segments = ds.segments[1:]
+	}
+}
+
+func (ds *diskStore) len() int {
+	return int(ds.tail() - ds.head)
+}
+
+func (ds *diskStore) persistent() bool { return true }
+
+func (ds *diskStore) close() error {
+	ds.closeReader()
+	if ds.w == nil {
+		return nil
+	}
+	err := ds.w.Sync()
+	if cerr := ds.w.Close(); err == nil {
+		err = cerr
+	}
+	ds.w = nil
+	return err
+}
+
+// readRecord reads the payload of the next record, returning io.EOF at the
+// end of the segment, and an error if the record is truncated or corrupt.
+func readRecord(rd *bufio.Reader) ([]byte, error) {
+	var header [recordHeaderSize]byte
+	n, err := io.ReadFull(rd, header[:])
+	if err == io.EOF {
+		return nil, io.EOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("truncated record header after %d bytes", n)
+	}
+	size := binary.BigEndian.Uint32(header[:4])
+	if size > maxRecordSize {
+		return nil, fmt.Errorf("invalid record size %d", size)
+	}
+	payload := make([]byte, size)
+	if _, err := io.ReadFull(rd, payload); err != nil {
+		return nil, errors.New("truncated record")
+	}
+	if crc32.Checksum(payload, castagnoli) != binary.BigEndian.Uint32(header[4:]) {
+		return nil, errors.New("invalid record checksum")
+	}
+	return payload, nil
+}
@@ -0,0 +1,189 @@
+package notifications
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func openTestDiskStore(t *testing.T, config configuration.EndpointQueue, dropped *int) *diskStore {
+	ds, err := newDiskStore(config, func(n int) { *dropped += n })
+	if err != nil {
+		t.Fatalf("unexpected error opening the store: %v", err)
+	}
+	return ds
+}
+
+func checkPeek(t *testing.T, store eventStore, n int, expected ...string) {
+	t.Helper()
+	var repositories []string
+	for _, event := range store.peek(n) {
+		repositories = append(repositories, event.(Event).Target.Repository)
+	}
+	if len(repositories) != len(expected) {
+		t.Fatalf("unexpected events %v, expected %v", repositories, expected)
+	}
+	for i := range expected {
+		if repositories[i] != expected[i] {
+			t.Fatalf("unexpected events %v, expected %v", repositories, expected)
+		}
+	}
+}
+
+func TestDiskStore(t *testing.T) {
+	config := configuration.EndpointQueue{Path: t.TempDir()}
+	var dropped int
+	ds := openTestDiskStore(t, config, &dropped)
+	for i := 0; i < 5; i++ {
+		ds.push(createTestEvent("push", "repo"+strconv.Itoa(i), "blob"))
+	}
+	checkPeek(t, ds, 2, "repo0", "repo1")
+	ds.pop(1)
+	if err := ds.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the events not popped are restored, including those peeked
+	ds = openTestDiskStore(t, config, &dropped)
+	if ds.len() != 4 {
+		t.Fatalf("expected 4 events to be restored, got %d", ds.len())
+	}
+	checkPeek(t, ds, 10, "repo1", "repo2", "repo3", "repo4")
+	ds.pop(3)
+	ds.push(createTestEvent("push", "repo5", "blob"))
+	checkPeek(t, ds, 10, "repo4", "repo5")
+	ds.close()
+
+	ds = openTestDiskStore(t, config, &dropped)
+	defer ds.close()
+	checkPeek(t, ds, 10, "repo4", "repo5")
+	if dropped != 0 {
+		t.Fatalf("unexpected dropped events: %d", dropped)
+	}
+}
+
+func TestDiskStoreBounds(t *testing.T) {
+	dir := t.TempDir()
+	config := configuration.EndpointQueue{Path: dir, MaxSize: 16 << 10}
+	var dropped int
+	ds := openTestDiskStore(t, config, &dropped)
+	defer ds.close()
+
+	// the events pushed once the queue is full are dropped
+	var pushed int
+	for dropped == 0 {
+		ds.push(createTestEvent("push", "library/test", "blob"))
+		pushed++
+	}
+	if ds.len() != pushed-1 || ds.size > config.MaxSize {
+		t.Fatalf("unexpected queue of %d events and %d bytes, after %d pushed", ds.len(), ds.size, pushed)
+	}
+	segments, _ := filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) < 4 {
+		t.Fatalf("expected the queue to be segmented, got %v", segments)
+	}
+
+	// the delivered segments are removed
+	for ds.len() > 0 {
+		ds.pop(len(ds.peek(10)))
+	}
+	ds.push(createTestEvent("push", "library/test", "blob"))
+	segments, _ = filepath.Glob(filepath.Join(dir, "*"+segmentSuffix))
+	if len(segments) > 2 || ds.len() != 1 {
+		t.Fatalf("expected the delivered segments to be removed, got %v", segments)
+	}
+}
+
+func TestDiskStoreRetention(t *testing.T) {
+	config := configuration.EndpointQueue{Path: t.TempDir(), Retention: time.Hour}
+	var dropped int
+	ds := openTestDiskStore(t, config, &dropped)
+	defer ds.close()
+
+	old := createTestEvent("push", "old", "blob")
+	old.Timestamp = time.Now().Add(-2 * time.Hour)
+	ds.push(old)
+	ds.push(createTestEvent("push", "recent", "blob"))
+	checkPeek(t, ds, 10, "recent")
+	if dropped != 1 || ds.len() != 1 {
+		t.Fatalf("expected the old event to be dropped, got %d dropped and %d queued", dropped, ds.len())
+	}
+}
+
+func TestDiskStoreTruncated(t *testing.T) {
+	config := configuration.EndpointQueue{Path: t.TempDir()}
+	var dropped int
+	ds := openTestDiskStore(t, config, &dropped)
+	ds.push(createTestEvent("push", "repo0", "blob"))
+	ds.push(createTestEvent("push", "repo1", "blob"))
+	ds.close()
+
+	// a record partially written by a crash is truncated
+	f, err := os.OpenFile(ds.segments[0].path(config.Path), os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0, 0, 1})
+	f.Close()
+
+	ds = openTestDiskStore(t, config, &dropped)
+	defer ds.close()
+	ds.push(createTestEvent("push", "repo2", "blob"))
+	checkPeek(t, ds, 10, "repo0", "repo1", "repo2")
+}
+
+func TestPersistentBatchSink(t *testing.T) {
+	config := configuration.EndpointQueue{Path: t.TempDir()}
+	metrics := newSafeMetrics("")
+	store, err := openStore(EndpointConfig{Queue: config}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the events which couldn't be published are kept on close
+	tp := &testPublisher{failures: 1000}
+	bs := newBatchSink(tp, encoder{}, store, "test", 10, time.Millisecond, metrics.eventQueueListener(), metrics.publishListener())
+	for i := 0; i < 3; i++ {
+		if err := bs.Write(createTestEvent("push", "library/test", "blob")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	checkClose(t, bs)
+
+	// and published on the next start
+	metrics = newSafeMetrics("")
+	store, err = openStore(EndpointConfig{Queue: config}, metrics)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if metrics.Pending != 3 {
+		t.Fatalf("expected 3 events to be restored as pending, got %d", metrics.Pending)
+	}
+	tp = &testPublisher{}
+	bs = newBatchSink(tp, encoder{}, store, "test", 10, time.Millisecond, metrics.eventQueueListener(), metrics.publishListener())
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		tp.mu.Lock()
+		published := len(tp.messages)
+		tp.mu.Unlock()
+		if published == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the restored events to be published, got %d", published)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	checkClose(t, bs)
+
+	metrics.Lock()
+	defer metrics.Unlock()
+	if metrics.Pending != 0 || metrics.Successes != 3 {
+		t.Fatalf("unexpected metrics: %+v", metrics.EndpointMetrics)
+	}
+}
@@ -0,0 +1,338 @@
+// Package accesslog logs the requests served by the registry, in the
+// Combined Log Format or as JSON objects whose fields are selected, to
+// separate outputs for the successful and failed requests. The successful
+// requests can be sampled to control the volume of the logs of busy
+// registries, such as mirrors.
+//
+// The handlers serving a request annotate its entry with the fields only
+// they know, such as the identity of the client or whether the content was
+// served from the cache of a pull through cache.
+package accesslog
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/gorilla/handlers"
+)
+
+// Formats of the access log.
+const (
+	FormatCombined = "combined"
+	FormatJSON     = "json"
+)
+
+// Fields of the json access log.
+const (
+	FieldTime       = "time"
+	FieldRemoteAddr = "remote_addr"
+	FieldMethod     = "method"
+	FieldURI        = "uri"
+	FieldProtocol   = "protocol"
+	FieldStatus     = "status"
+	FieldSize       = "size"
+	FieldDuration   = "duration_ms"
+	FieldUserAgent  = "user_agent"
+	FieldReferer    = "referer"
+	FieldRequestID  = "request_id"
+	FieldTraceID    = "trace_id"
+	FieldIdentity   = "identity"
+	FieldNamespace  = "namespace"
+	FieldCache      = "cache"
+)
+
+// Values of FieldCache.
+const (
+	CacheHit  = "hit"
+	CacheMiss = "miss"
+)
+
+var allFields = []string{
+	FieldTime, FieldRemoteAddr, FieldMethod, FieldURI, FieldProtocol,
+	FieldStatus, FieldSize, FieldDuration, FieldUserAgent, FieldReferer,
+	FieldRequestID, FieldTraceID, FieldIdentity, FieldNamespace, FieldCache,
+}
+
+// annotations are the fields of an entry set by the handlers.
+type annotations struct {
+	mu     sync.Mutex
+	fields map[string]string
+}
+
+type annotationsKey struct{}
+
+// Annotate sets a field of the entry of the request of ctx, which is
+// ignored if the request is not logged.
+func Annotate(ctx context.Context, field, value string) {
+	a, ok := ctx.Value(annotationsKey{}).(*annotations)
+	if !ok || value == "" {
+		return
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.fields[field] = value
+}
+
+func (a *annotations) get(field string) string {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	return a.fields[field]
+}
+
+// output is an output of the access log, safe for concurrent use.
+type output struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (o *output) write(p []byte) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.w.Write(p)
+}
+
+// logger writes the entries of the access log.
+type logger struct {
+	format string
+	fields []string
+	rate   float64
+	access *output
+	errors *output
+	random func() float64
+}
+
+// NewHandler returns a handler logging the requests served by h.
+func NewHandler(config configuration.AccessLog, h http.Handler) (http.Handler, error) {
+	l, err := newLogger(config)
+	if err != nil {
+		return nil, err
+	}
+	return l.handler(h), nil
+}
+
+func newLogger(config configuration.AccessLog) (*logger, error) {
+	l := &logger{
+		format: config.Format,
+		fields: config.Fields,
+		rate:   config.Sampling.Rate,
+		random: rand.Float64,
+	}
+	switch l.format {
+	case "":
+		l.format = FormatCombined
+	case FormatCombined, FormatJSON:
+	default:
+		return nil, fmt.Errorf("accesslog: unknown format %q", config.Format)
+	}
+	if len(l.fields) == 0 {
+		l.fields = allFields
+	}
+	for _, field := range l.fields {
+		if !knownField(field) {
+			return nil, fmt.Errorf("accesslog: unknown field %q", field)
+		}
+	}
+	if l.rate < 0 || l.rate > 1 {
+		return nil, fmt.Errorf("accesslog: sampling rate %v is not between 0 and 1", l.rate)
+	}
+
+	var err error
+	if l.access, err = openOutput(config.Path); err != nil {
+		return nil, err
+	}
+	l.errors = l.access
+	if config.ErrorPath != "" && config.ErrorPath != config.Path {
+		if l.errors, err = openOutput(config.ErrorPath); err != nil {
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// handler returns a handler logging the requests served by h, whose entries
+// can be annotated.
+func (l *logger) handler(h http.Handler) http.Handler {
+	logged := handlers.CustomLoggingHandler(nil, h, l.log)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a := &annotations{fields: make(map[string]string)}
+		logged.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), annotationsKey{}, a)))
+	})
+}
+
+func knownField(field string) bool {
+	for _, known := range allFields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+func openOutput(path string) (*output, error) {
+	switch path {
+	case "", "stdout":
+		return &output{w: os.Stdout}, nil
+	case "stderr":
+		return &output{w: os.Stderr}, nil
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("accesslog: %v", err)
+	}
+	return &output{w: f}, nil
+}
+
+// log writes the entry of a request, once it was served.
+func (l *logger) log(_ io.Writer, params handlers.LogFormatterParams) {
+	out := l.access
+	if params.StatusCode >= http.StatusBadRequest {
+		out = l.errors
+	} else if l.rate > 0 && l.random() >= l.rate {
+		return
+	}
+
+	if l.format == FormatJSON {
+		out.write(l.jsonEntry(params))
+	} else {
+		out.write(combinedEntry(params))
+	}
+}
+
+func (l *logger) jsonEntry(params handlers.LogFormatterParams) []byte {
+	r := params.Request
+	a, _ := r.Context().Value(annotationsKey{}).(*annotations)
+	// the fields are written in the configured order
+	buf := []byte{'{'}
+	for _, field := range l.fields {
+		var value interface{}
+		switch field {
+		case FieldTime:
+			value = params.TimeStamp.UTC().Format(time.RFC3339Nano)
+		case FieldRemoteAddr:
+			value = remoteHost(r)
+		case FieldMethod:
+			value = r.Method
+		case FieldURI:
+			value = requestURI(r, params)
+		case FieldProtocol:
+			value = r.Proto
+		case FieldStatus:
+			value = params.StatusCode
+		case FieldSize:
+			value = params.Size
+		case FieldDuration:
+			value = float64(time.Since(params.TimeStamp).Microseconds()) / 1000
+		case FieldUserAgent:
+			value = r.UserAgent()
+		case FieldReferer:
+			value = r.Referer()
+		case FieldTraceID:
+			// the trace of the request if it is traced, or else the trace of
+			// the client
+			value = traceID(r)
+			if a != nil && a.get(field) != "" {
+				value = a.get(field)
+			}
+		default:
+			if a != nil {
+				value = a.get(field)
+			}
+		}
+		if s, ok := value.(string); ok && s == "" {
+			continue
+		}
+		p, err := json.Marshal(value)
+		if err != nil {
+			continue
+		}
+		if len(buf) > 1 {
+			buf = append(buf, ',')
+		}
+		buf = strconv.AppendQuote(buf, field)
+		buf = append(buf, ':')
+		buf = append(buf, p...)
+	}
+	return append(buf, '}', '\n')
+}
+
+// combinedEntry formats the entry of a request in the Combined Log Format.
+func combinedEntry(params handlers.LogFormatterParams) []byte {
+	r := params.Request
+	username := "-"
+	if params.URL.User != nil {
+		if name := params.URL.User.Username(); name != "" {
+			username = name
+		}
+	}
+
+	buf := make([]byte, 0, 3*(len(r.Host)+len(r.URL.RequestURI())+len(r.UserAgent())+50)/2)
+	buf = append(buf, remoteHost(r)...)
+	buf = append(buf, " - "...)
+	buf = append(buf, username...)
+	buf = append(buf, " ["...)
+	buf = append(buf, params.TimeStamp.Format("02/Jan/2006:15:04:05 -0700")...)
+	buf = append(buf, `] "`...)
+	buf = append(buf, r.Method...)
+	buf = append(buf, " "...)
+	buf = appendQuoted(buf, requestURI(r, params))
+	buf = append(buf, " "...)
+	buf = append(buf, r.Proto...)
+	buf = append(buf, `" `...)
+	buf = append(buf, strconv.Itoa(params.StatusCode)...)
+	buf = append(buf, " "...)
+	buf = append(buf, strconv.Itoa(params.Size)...)
+	buf = append(buf, ` "`...)
+	buf = appendQuoted(buf, r.Referer())
+	buf = append(buf, `" "`...)
+	buf = appendQuoted(buf, r.UserAgent())
+	buf = append(buf, '"', '\n')
+	return buf
+}
+
+// appendQuoted appends s, escaped as in a Go string literal without its
+// quotes.
+func appendQuoted(buf []byte, s string) []byte {
+	quoted := strconv.Quote(s)
+	return append(buf, quoted[1:len(quoted)-1]...)
+}
+
+func remoteHost(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// requestURI returns the URI of the request as it was received, the
+// handlers being free to rewrite r.URL.
+func requestURI(r *http.Request, params handlers.LogFormatterParams) string {
+	if r.ProtoMajor == 2 && r.Method == http.MethodConnect {
+		return r.Host
+	}
+	if r.RequestURI != "" {
+		return r.RequestURI
+	}
+	return params.URL.RequestURI()
+}
+
+// traceID returns the trace ID of the W3C traceparent header of the
+// request, if any.
+func traceID(r *http.Request) string {
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	return parts[1]
+}
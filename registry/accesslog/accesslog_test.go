@@ -0,0 +1,114 @@
+package accesslog
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestJSONEntries(t *testing.T) {
+	dir := t.TempDir()
+	accessPath := filepath.Join(dir, "access.log")
+	errorPath := filepath.Join(dir, "error.log")
+	l, err := newLogger(configuration.AccessLog{
+		Format:    FormatJSON,
+		Fields:    []string{FieldStatus, FieldMethod, FieldURI, FieldIdentity, FieldCache, FieldTraceID},
+		Path:      accessPath,
+		ErrorPath: errorPath,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating logger: %v", err)
+	}
+	h := l.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		Annotate(r.Context(), FieldIdentity, "alice")
+		if r.URL.Path == "/missing" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		Annotate(r.Context(), FieldCache, CacheHit)
+		w.Write([]byte("ok"))
+	}))
+
+	r := httptest.NewRequest(http.MethodGet, "/v2/foo/manifests/latest", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	h.ServeHTTP(httptest.NewRecorder(), r)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/missing", nil))
+
+	access, err := os.ReadFile(accessPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading access log: %v", err)
+	}
+	expected := `{"status":200,"method":"GET","uri":"/v2/foo/manifests/latest","identity":"alice","cache":"hit","trace_id":"0af7651916cd43dd8448eb211c80319c"}` + "\n"
+	if string(access) != expected {
+		t.Fatalf("unexpected access log: %s", access)
+	}
+
+	errors, err := os.ReadFile(errorPath)
+	if err != nil {
+		t.Fatalf("unexpected error reading error log: %v", err)
+	}
+	var entry map[string]interface{}
+	if err := json.Unmarshal(errors, &entry); err != nil {
+		t.Fatalf("unexpected error decoding error log %q: %v", errors, err)
+	}
+	if entry[FieldStatus] != float64(http.StatusNotFound) || entry[FieldURI] != "/missing" {
+		t.Fatalf("unexpected error entry: %v", entry)
+	}
+	if _, ok := entry[FieldCache]; ok {
+		t.Fatalf("unexpected cache field without annotation: %v", entry)
+	}
+}
+
+func TestSampling(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	l, err := newLogger(configuration.AccessLog{
+		Path:     path,
+		Sampling: configuration.AccessLogSampling{Rate: 0.25},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error creating logger: %v", err)
+	}
+	samples := []float64{0.1, 0.5, 0.9, 0.2}
+	l.random = func() float64 {
+		sample := samples[0]
+		samples = samples[1:]
+		return sample
+	}
+	status := http.StatusOK
+	h := l.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	for i := 0; i < 4; i++ {
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v2/", nil))
+	}
+	// failed requests are always logged
+	status = http.StatusInternalServerError
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/v2/", nil))
+
+	p, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("unexpected error reading access log: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(p)), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"GET /v2/ HTTP/1.1" 200 0`) || !strings.Contains(lines[2], `" 500 0 "`) {
+		t.Fatalf("unexpected access log: %q", lines)
+	}
+}
+
+func TestInvalidConfiguration(t *testing.T) {
+	for _, config := range []configuration.AccessLog{
+		{Format: "xml"},
+		{Format: FormatJSON, Fields: []string{"status", "color"}},
+		{Sampling: configuration.AccessLogSampling{Rate: 1.5}},
+	} {
+		if _, err := newLogger(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
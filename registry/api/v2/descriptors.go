@@ -209,6 +209,127 @@ var (
 			errcode.ErrorCodeTooManyRequests,
 		},
 	}
+
+	trashManifestParameters = []ParameterDescriptor{
+		{
+			Name:        "repository",
+			Type:        "string",
+			Description: "Name of the repository the manifest was deleted from.",
+			Format:      "<name>",
+			Required:    true,
+		},
+		{
+			Name:        "digest",
+			Type:        "string",
+			Description: "Digest of the manifest.",
+			Format:      "<digest>",
+			Required:    true,
+		},
+	}
+
+	trashUnsupportedResponse = ResponseDescriptor{
+		Name:        "Trash Disabled",
+		Description: "Deleted manifests are not moved to the trash.",
+		StatusCode:  http.StatusMethodNotAllowed,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeUnsupported,
+		},
+	}
+
+	trashManifestFailures = []ResponseDescriptor{
+		{
+			Description: "The repository or digest parameter is missing or invalid.",
+			StatusCode:  http.StatusBadRequest,
+			Body: BodyDescriptor{
+				ContentType: "application/json",
+				Format:      errorsBody,
+			},
+			ErrorCodes: []errcode.ErrorCode{
+				ErrorCodeRequestInvalid,
+			},
+		},
+		{
+			Description: "The manifest is not in the trash.",
+			StatusCode:  http.StatusNotFound,
+			Body: BodyDescriptor{
+				ContentType: "application/json",
+				Format:      errorsBody,
+			},
+			ErrorCodes: []errcode.ErrorCode{
+				ErrorCodeManifestUnknown,
+			},
+		},
+		trashUnsupportedResponse,
+	}
+
+	usersUnsupportedResponse = ResponseDescriptor{
+		Name:        "Users Not Managed",
+		Description: "The authentication provider does not manage its users through the API.",
+		StatusCode:  http.StatusMethodNotAllowed,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeUnsupported,
+		},
+	}
+
+	eventsQueryParameters = []ParameterDescriptor{
+		{
+			Name:        "since",
+			Type:        "string",
+			Description: "Time of the oldest event, as a RFC 3339 time or a duration before now. Defaults to the oldest event of the history.",
+			Format:      "<time>",
+		},
+		{
+			Name:        "until",
+			Type:        "string",
+			Description: "Time the events are older than, as a RFC 3339 time or a duration before now. Defaults to now.",
+			Format:      "<time>",
+		},
+		{
+			Name:        "repository",
+			Type:        "string",
+			Description: "Pattern of the repositories of the events.",
+			Format:      "<pattern>",
+		},
+		{
+			Name:        "action",
+			Type:        "string",
+			Description: "Action of the events.",
+			Format:      "<action>",
+		},
+	}
+
+	eventsInvalidResponse = ResponseDescriptor{
+		Description: "A parameter is invalid, or the endpoint does not exist.",
+		StatusCode:  http.StatusBadRequest,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			ErrorCodeRequestInvalid,
+		},
+	}
+
+	eventsUnsupportedResponse = ResponseDescriptor{
+		Name:        "Event History Disabled",
+		Description: "The history of the events is not enabled.",
+		StatusCode:  http.StatusMethodNotAllowed,
+		Body: BodyDescriptor{
+			ContentType: "application/json",
+			Format:      errorsBody,
+		},
+		ErrorCodes: []errcode.ErrorCode{
+			errcode.ErrorCodeUnsupported,
+		},
+	}
 )
 
 const (
@@ -236,6 +357,20 @@ const (
         ...
     ]
 }`
+
+	readOnlyBody = `{
+	"enabled": <bool>,
+	"repositories": [<pattern>, ...],
+	"degraded": <bool>
+}`
+
+	trashedManifestBody = `{
+	"repository": <name>,
+	"digest": <digest>,
+	"tags": [<tag>, ...],
+	"deleted_at": <time>,
+	"expires_at": <time>
+}`
 )
 
 // APIDescriptor exports descriptions of the layout of the v2 registry API.
@@ -513,6 +648,184 @@ var routeDescriptors = []RouteDescriptor{
 			},
 		},
 	},
+	{
+		Name:        RouteNameTagHistory,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/_tags/{tag:" + reference.TagRegexp.String() + "}/history",
+		Entity:      "Tag History",
+		Description: "Retrieve the manifests a tag pointed to.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch the changes of the manifest the tag `tag` of the repository identified by `name` points to, from the most recent one. The history is kept when the tag is deleted.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							{
+								Name:        "tag",
+								Type:        "string",
+								Format:      "<tag>",
+								Required:    true,
+								Description: "Tag of the target manifest.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode:  http.StatusOK,
+								Description: "The history of the tag. `previous` is omitted when the tag did not exist before the change.",
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+    "name": <name>,
+    "tag": <tag>,
+    "history": [
+        {
+            "digest": <digest>,
+            "previous": <digest>,
+            "timestamp": <time>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The tag is unknown and has no history.",
+								StatusCode:  http.StatusNotFound,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeManifestUnknown,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "The repository does not record the history of its tags.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameReferrers,
+		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/referrers/{digest:" + digest.DigestRegexp.String() + "}",
+		Entity:      "Referrers",
+		Description: "Retrieve the manifests referring to a subject manifest.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Fetch an image index describing the manifests whose subject is the manifest identified by `name` and `digest`.",
+				Requests: []RequestDescriptor{
+					{
+						Headers: []ParameterDescriptor{
+							hostHeader,
+							authHeader,
+						},
+						PathParameters: []ParameterDescriptor{
+							nameParameterDescriptor,
+							digestPathParameter,
+						},
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "artifactType",
+								Type:        "string",
+								Format:      "<artifactType>",
+								Required:    false,
+								Description: "Only return referrers with the given artifact type.",
+							},
+							{
+								Name:        "annotation",
+								Type:        "string",
+								Format:      "<key>[=<value>]",
+								Required:    false,
+								Description: "Only return referrers with the given annotation, of the given value if any. Can be repeated, in which case the referrers must match all of them.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "An image index listing the referrers of the subject manifest. The list is empty if there are none.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "OCI-Filters-Applied",
+										Type:        "string",
+										Description: "The filters applied to the results, `artifactType` and `annotation`, separated by commas.",
+										Format:      "artifactType,annotation",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/vnd.oci.image.index.v1+json",
+									Format: `{
+    "schemaVersion": 2,
+    "mediaType": "application/vnd.oci.image.index.v1+json",
+    "manifests": [
+        {
+            "mediaType": <media type>,
+            "size": <size>,
+            "digest": <digest>,
+            "artifactType": <artifact type>,
+            "annotations": <annotations>
+        },
+        ...
+    ]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The repository does not support the referrers API.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							{
+								Description: "An annotation filter has no key.",
+								StatusCode:  http.StatusBadRequest,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeRequestInvalid,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
+							unauthorizedResponseDescriptor,
+							repositoryNotFoundResponseDescriptor,
+							deniedResponseDescriptor,
+							tooManyRequestsDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
 	{
 		Name:        RouteNameManifest,
 		Path:        "/v2/{name:" + reference.NameRegexp.String() + "}/manifests/{reference:" + reference.TagRegexp.String() + "|" + digest.DigestRegexp.String() + "}",
@@ -574,6 +887,12 @@ var routeDescriptors = []RouteDescriptor{
 						Headers: []ParameterDescriptor{
 							hostHeader,
 							authHeader,
+							{
+								Name:        "If-Match",
+								Type:        "string",
+								Format:      `"<digest>"`,
+								Description: "Only update the tag `reference` if it points to the manifest of the given ETag, as returned by a `GET` or `HEAD` of the tag, or exists when `*`. The header is ignored when `reference` is a digest.",
+							},
 						},
 						PathParameters: []ParameterDescriptor{
 							nameParameterDescriptor,
@@ -596,10 +915,28 @@ var routeDescriptors = []RouteDescriptor{
 									},
 									contentLengthZeroHeader,
 									digestHeader,
+									{
+										Name:        "ETag",
+										Type:        "string",
+										Description: "The ETag of the manifest, to make a later update of the tag conditional.",
+										Format:      `"<digest>"`,
+									},
 								},
 							},
 						},
 						Failures: []ResponseDescriptor{
+							{
+								Name:        "Precondition Failed",
+								Description: "The tag does not point to the manifest of the `If-Match` header, as it was changed since the client read it. The client should read the tag again before retrying.",
+								StatusCode:  http.StatusPreconditionFailed,
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodePreconditionFailed,
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+							},
 							{
 								Name:        "Invalid Manifest",
 								Description: "The received manifest was invalid in some way, as described by the error codes. The client should resolve the issue and retry the request.",
@@ -1595,6 +1932,780 @@ var routeDescriptors = []RouteDescriptor{
 							invalidPaginationResponseDescriptor,
 						},
 					},
+					{
+						Name:        "Catalog Fetch By Prefix",
+						Description: "Return the repositories whose name starts with a prefix. When the catalog is served from the index of the registry, the repositories are listed in lexical order and counted.",
+						QueryParameters: append([]ParameterDescriptor{
+							{
+								Name:        "prefix",
+								Type:        "string",
+								Description: "Prefix of the names of the repositories.",
+								Format:      "<prefix>",
+								Required:    false,
+							},
+						}, paginationParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"repositories": [
+		<name>,
+		...
+	],
+	"count": <count>
+}`,
+								},
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+						},
+					},
+					{
+						Name:        "Catalog Fetch By Namespace",
+						Description: "Return the repositories cached from a single upstream by a pull through cache with a grouped catalog, named as on the upstream. Without the `ns` parameter, such a cache returns the repositories grouped by upstream host instead.",
+						QueryParameters: append([]ParameterDescriptor{
+							{
+								Name:        "ns",
+								Type:        "string",
+								Description: "Host of the upstream registry.",
+								Format:      "<host>",
+								Required:    false,
+							},
+						}, paginationParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"repositories": [
+		<name>,
+		...
+	]
+}`,
+								},
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameSearch,
+		Path:        "/v2/_search",
+		Entity:      "Search",
+		Description: "Search the repositories of the registry, and those a pull through cache stored for its upstreams, by name, tag, annotation and upstream namespace. The repositories are searched in an index kept by the registry when search is enabled.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the repositories matching a query, sorted by name.",
+				Requests: []RequestDescriptor{
+					{
+						Name:        "Search",
+						Description: "Return the repositories matching all the terms of the query. A term is a text matched against the name, tags, annotations and namespace of the repositories, a `repository:`, `tag:` or `namespace:` prefixed text matched against that field only, or a `key=value` pair matched against the annotation of that key. Texts are matched as case insensitive substrings.",
+						QueryParameters: append([]ParameterDescriptor{
+							{
+								Name:        "q",
+								Type:        "string",
+								Description: "Terms of the query, separated by spaces. All the repositories match an empty query.",
+								Format:      "<query>",
+								Required:    false,
+							},
+						}, paginationParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								StatusCode: http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"results": [
+		{
+			"repository": <name>,
+			"namespace": <host>,
+			"tags": [
+				<tag>,
+				...
+			],
+			"annotations": {
+				<key>: <value>,
+				...
+			}
+		},
+		...
+	]
+}`,
+								},
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+									linkHeader,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							invalidPaginationResponseDescriptor,
+							{
+								Description: "Search is not enabled on the registry.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameProxyNamespaces,
+		Path:        "/v2/_proxy/namespaces",
+		Entity:      "ProxyNamespaces",
+		Description: "List the upstream registries configured for a registry acting as a pull through cache.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the configured upstreams, sorted by host.",
+				Requests: []RequestDescriptor{
+					{
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The upstreams are returned as a json response.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"namespaces": [
+		{
+			"name": <host>,
+			"url": <url>,
+			"credentials": <bool>
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The registry is not configured as a pull through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameProxyStats,
+		Path:        "/v2/_proxy/stats",
+		Entity:      "ProxyStats",
+		Description: "Report the statistics of a registry configured as a pull through cache. The statistics are kept in memory and cover the time since the registry started.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the cache statistics per upstream host.",
+				Requests: []RequestDescriptor{
+					{
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The cache statistics are returned as a json response.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"namespaces": {
+		<host>: {
+			"manifests": {
+				"entries": <count>,
+				"bytes": <size>,
+				"hits": <count>,
+				"misses": <count>,
+				"hit_ratio": <ratio>,
+				"oldest": <time>,
+				"newest": <time>
+			},
+			"blobs": { ... }
+		},
+		...
+	},
+	"scheduler_backlog": <count>
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The registry is not configured as a pull through cache.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameReplicationStatus,
+		Path:        "/v2/_replication/status",
+		Entity:      "ReplicationStatus",
+		Description: "Report the state of the replications of manifests to downstream registries. The state is kept in memory and covers the time since the registry started.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the replication state per replication rule.",
+				Requests: []RequestDescriptor{
+					{
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The replication state is returned as a json response.",
+								StatusCode:  http.StatusOK,
+								Headers: []ParameterDescriptor{
+									{
+										Name:        "Content-Length",
+										Type:        "integer",
+										Description: "Length of the JSON response body.",
+										Format:      "<length>",
+									},
+								},
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"rules": [
+		{
+			"name": <rule>,
+			"url": <url>,
+			"pending": <count>,
+			"succeeded": <count>,
+			"failed": <count>,
+			"last_success": <time>,
+			"last_error": <error>,
+			"last_failure": <time>,
+			"recent": [
+				{
+					"repository": <name>,
+					"digest": <digest>,
+					"tag": <tag>,
+					"succeeded": <bool>,
+					"attempts": <count>,
+					"error": <error>,
+					"finished": <time>
+				},
+				...
+			]
+		},
+		...
+	]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The registry is not configured to replicate manifests.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminReadOnly,
+		Path:        "/v2/_admin/readonly",
+		Entity:      "ReadOnly",
+		Description: "Get or switch the read-only maintenance mode of the registry, and of individual repositories, without restarting it. Pulls are served while read-only, and pushes and deletes are refused.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Retrieve the read-only mode of the registry.",
+				Requests: []RequestDescriptor{
+					{
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The read-only mode is returned as a json response. `degraded` is whether the registry is read-only because writes to the storage driver are failing.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      readOnlyBody,
+								},
+							},
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPut,
+				Description: "Replace the read-only mode of the registry, and the patterns of the names of the read-only repositories.",
+				Requests: []RequestDescriptor{
+					{
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+	"enabled": <bool>,
+	"repositories": [<pattern>, ...]
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The read-only mode was switched, and is returned as a json response.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      readOnlyBody,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The request body is malformed or has an invalid repository pattern.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeRequestInvalid,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminTrash,
+		Path:        "/v2/_admin/trash",
+		Entity:      "Trash",
+		Description: "List, restore or purge the manifests deleted while the trash is enabled. Deleted manifests are kept in the trash for the configured retention window, and their content is kept by garbage collection until they are purged.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "List the manifests in the trash, the oldest deletions first.",
+				Requests: []RequestDescriptor{
+					{
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The manifests in the trash are returned as a json response.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"manifests": [` + trashedManifestBody + `, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							trashUnsupportedResponse,
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Description: "Restore a manifest from the trash to its repository, along with the tags which pointed to it when it was deleted, unless they were pushed again since.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: trashManifestParameters,
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The manifest was restored, and is returned as a json response with the tags restored.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      trashedManifestBody,
+								},
+							},
+						},
+						Failures: trashManifestFailures,
+					},
+				},
+			},
+			{
+				Method:      http.MethodDelete,
+				Description: "Purge a manifest from the trash. Its content is deleted by the next garbage collection.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: trashManifestParameters,
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The manifest was purged.",
+								StatusCode:  http.StatusAccepted,
+							},
+						},
+						Failures: trashManifestFailures,
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminUsers,
+		Path:        "/v2/_admin/users",
+		Entity:      "Users",
+		Description: "List, add or remove the users of the registry, when its authentication provider manages them, so that credentials can be rotated without restarting the registry.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "List the names of the users.",
+				Requests: []RequestDescriptor{
+					{
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The names of the users are returned as a json response.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"users": [<name>, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							usersUnsupportedResponse,
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPut,
+				Description: "Add a user, or replace its password.",
+				Requests: []RequestDescriptor{
+					{
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+	"name": <name>,
+	"password": <password>
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The user was added, or its password replaced.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The request body is malformed, or the name or password is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeRequestInvalid,
+								},
+							},
+							usersUnsupportedResponse,
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodDelete,
+				Description: "Remove a user.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "name",
+								Type:        "string",
+								Description: "Name of the user.",
+								Format:      "<name>",
+								Required:    true,
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The user was removed.",
+								StatusCode:  http.StatusNoContent,
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The user does not exist.",
+								StatusCode:  http.StatusNotFound,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeUserUnknown,
+								},
+							},
+							usersUnsupportedResponse,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminTokens,
+		Path:        "/v2/_admin/tokens",
+		Entity:      "Pull Tokens",
+		Description: "Mint short-lived tokens granting pull access to the repositories matching patterns, for clients which should not hold the credentials of a user.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Mint a pull token.",
+				Requests: []RequestDescriptor{
+					{
+						Body: BodyDescriptor{
+							ContentType: "application/json",
+							Format: `{
+	"name": <name>,
+	"repositories": [<pattern>, ...],
+	"expiration": <duration>
+}`,
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The token is returned as a json response, with its grants and expiration.",
+								StatusCode:  http.StatusCreated,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"token": <token>,
+	"id": <id>,
+	"name": <name>,
+	"repositories": [<pattern>, ...],
+	"expires_at": "<time>"
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The request body is malformed, or a pattern or the expiration is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeRequestInvalid,
+								},
+							},
+							{
+								Description: "Pull tokens are not enabled.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminEvents,
+		Path:        "/v2/_admin/events",
+		Entity:      "Events",
+		Description: "Download the events recorded in the history of the notifications, or replay them to an endpoint, to recover the events it missed.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodGet,
+				Description: "Download the recorded events, one json object per line, in order.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: eventsQueryParameters,
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The events are returned as lines of json.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/x-ndjson",
+									Format:      `<event>`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							eventsInvalidResponse,
+							eventsUnsupportedResponse,
+						},
+					},
+				},
+			},
+			{
+				Method:      http.MethodPost,
+				Description: "Replay the recorded events to an endpoint. The events are queued by the endpoint like new events.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: append([]ParameterDescriptor{
+							{
+								Name:        "endpoint",
+								Type:        "string",
+								Format:      "<name>",
+								Required:    true,
+								Description: "Name of the endpoint to replay the events to.",
+							},
+						}, eventsQueryParameters...),
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The events were queued by the endpoint.",
+								StatusCode:  http.StatusAccepted,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"endpoint": <name>,
+	"events": <count>
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							eventsInvalidResponse,
+							eventsUnsupportedResponse,
+						},
+					},
+				},
+			},
+		},
+	},
+	{
+		Name:        RouteNameAdminUploadPurging,
+		Path:        "/v2/_admin/uploadpurging",
+		Entity:      "UploadPurging",
+		Description: "Run a purge pass of the stale uploads on demand, rather than waiting for the next scheduled one.",
+		Methods: []MethodDescriptor{
+			{
+				Method:      http.MethodPost,
+				Description: "Delete the uploads older than the age configured for their repository, and report them. Refused while the registry is read-only.",
+				Requests: []RequestDescriptor{
+					{
+						QueryParameters: []ParameterDescriptor{
+							{
+								Name:        "dryrun",
+								Type:        "bool",
+								Format:      "<bool>",
+								Description: "Report the uploads which would be deleted without deleting them.",
+							},
+						},
+						Successes: []ResponseDescriptor{
+							{
+								Description: "The purge pass completed. `bytes` is the number of bytes received by the deleted uploads.",
+								StatusCode:  http.StatusOK,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format: `{
+	"deleted": [<path>, ...],
+	"bytes": <bytes>,
+	"dryrun": <bool>,
+	"errors": [<error>, ...]
+}`,
+								},
+							},
+						},
+						Failures: []ResponseDescriptor{
+							{
+								Description: "The dryrun parameter is invalid.",
+								StatusCode:  http.StatusBadRequest,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									ErrorCodeRequestInvalid,
+								},
+							},
+							{
+								Name:        "Upload Purging Disabled",
+								Description: "Upload purging is disabled in the configuration.",
+								StatusCode:  http.StatusMethodNotAllowed,
+								Body: BodyDescriptor{
+									ContentType: "application/json",
+									Format:      errorsBody,
+								},
+								ErrorCodes: []errcode.ErrorCode{
+									errcode.ErrorCodeUnsupported,
+								},
+							},
+						},
+					},
 				},
 			},
 		},
@@ -155,4 +155,35 @@ var (
 		the maximum allowed.`,
 		HTTPStatusCode: http.StatusBadRequest,
 	})
+
+	// ErrorCodeRequestInvalid is returned when the body of a request to an
+	// administration endpoint is malformed.
+	ErrorCodeRequestInvalid = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "REQUEST_INVALID",
+		Message: "invalid request",
+		Description: `Returned when the body of a request to an
+		administration endpoint is malformed or has invalid values.`,
+		HTTPStatusCode: http.StatusBadRequest,
+	})
+
+	// ErrorCodeUserUnknown is returned when an administration request
+	// removes a user which does not exist.
+	ErrorCodeUserUnknown = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "USER_UNKNOWN",
+		Message: "user not known to registry",
+		Description: `Returned when removing a user which is not
+		known to the authentication provider of the registry.`,
+		HTTPStatusCode: http.StatusNotFound,
+	})
+
+	// ErrorCodePreconditionFailed is returned when the precondition of a
+	// conditional request does not hold.
+	ErrorCodePreconditionFailed = errcode.Register(errGroup, errcode.ErrorDescriptor{
+		Value:   "PRECONDITION_FAILED",
+		Message: "precondition failed",
+		Description: `Returned when the If-Match header of a manifest
+		PUT by tag does not match the manifest the tag points to, as the tag
+		was changed since the client read it.`,
+		HTTPStatusCode: http.StatusPreconditionFailed,
+	})
 )
@@ -12,10 +12,24 @@ const (
 	RouteNameBase            = "base"
 	RouteNameManifest        = "manifest"
 	RouteNameTags            = "tags"
+	RouteNameTagHistory      = "tag-history"
 	RouteNameBlob            = "blob"
 	RouteNameBlobUpload      = "blob-upload"
 	RouteNameBlobUploadChunk = "blob-upload-chunk"
 	RouteNameCatalog         = "catalog"
+	RouteNameSearch          = "search"
+	RouteNameReferrers       = "referrers"
+	RouteNameProxyStats      = "proxy-stats"
+	RouteNameProxyNamespaces = "proxy-namespaces"
+
+	RouteNameReplicationStatus = "replication-status"
+	RouteNameAdminReadOnly     = "admin-readonly"
+	RouteNameAdminTrash        = "admin-trash"
+	RouteNameAdminUsers        = "admin-users"
+	RouteNameAdminTokens       = "admin-tokens"
+	RouteNameAdminEvents       = "admin-events"
+
+	RouteNameAdminUploadPurging = "admin-uploadpurging"
 )
 
 var (
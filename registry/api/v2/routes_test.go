@@ -88,6 +88,72 @@ func TestRouter(t *testing.T) {
 				"name": "docker.com/foo/bar/baz",
 			},
 		},
+		{
+			RouteName:  RouteNameTagHistory,
+			RequestURI: "/v2/foo/bar/_tags/prod/history",
+			Vars: map[string]string{
+				"name": "foo/bar",
+				"tag":  "prod",
+			},
+		},
+		{
+			RouteName:  RouteNameReferrers,
+			RequestURI: "/v2/foo/bar/referrers/sha256:abcdef0919234",
+			Vars: map[string]string{
+				"name":   "foo/bar",
+				"digest": "sha256:abcdef0919234",
+			},
+		},
+		{
+			RouteName:  RouteNameSearch,
+			RequestURI: "/v2/_search",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameProxyStats,
+			RequestURI: "/v2/_proxy/stats",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameProxyNamespaces,
+			RequestURI: "/v2/_proxy/namespaces",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameReplicationStatus,
+			RequestURI: "/v2/_replication/status",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminReadOnly,
+			RequestURI: "/v2/_admin/readonly",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminTrash,
+			RequestURI: "/v2/_admin/trash",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminUsers,
+			RequestURI: "/v2/_admin/users",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminTokens,
+			RequestURI: "/v2/_admin/tokens",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminEvents,
+			RequestURI: "/v2/_admin/events",
+			Vars:       map[string]string{},
+		},
+		{
+			RouteName:  RouteNameAdminUploadPurging,
+			RequestURI: "/v2/_admin/uploadpurging",
+			Vars:       map[string]string{},
+		},
 		{
 			RouteName:  RouteNameBlob,
 			RequestURI: "/v2/foo/bar/blobs/sha256:abcdef0919234",
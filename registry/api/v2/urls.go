@@ -127,6 +127,135 @@ func (ub *URLBuilder) BuildCatalogURL(values ...url.Values) (string, error) {
 	return appendValuesURL(catalogURL, values...).String(), nil
 }
 
+// BuildSearchURL constructs a url to search the repositories
+func (ub *URLBuilder) BuildSearchURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameSearch)
+
+	searchURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(searchURL, values...).String(), nil
+}
+
+// BuildProxyStatsURL constructs a url to get the statistics of a pull
+// through cache
+func (ub *URLBuilder) BuildProxyStatsURL() (string, error) {
+	route := ub.cloneRoute(RouteNameProxyStats)
+
+	statsURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return statsURL.String(), nil
+}
+
+// BuildProxyNamespacesURL constructs a url to list the upstreams of a pull
+// through cache
+func (ub *URLBuilder) BuildProxyNamespacesURL() (string, error) {
+	route := ub.cloneRoute(RouteNameProxyNamespaces)
+
+	namespacesURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return namespacesURL.String(), nil
+}
+
+// BuildReplicationStatusURL constructs a url to get the state of the
+// replications to downstream registries
+func (ub *URLBuilder) BuildReplicationStatusURL() (string, error) {
+	route := ub.cloneRoute(RouteNameReplicationStatus)
+
+	statusURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return statusURL.String(), nil
+}
+
+// BuildAdminReadOnlyURL constructs a url to get or set the read-only mode of
+// the registry
+func (ub *URLBuilder) BuildAdminReadOnlyURL() (string, error) {
+	route := ub.cloneRoute(RouteNameAdminReadOnly)
+
+	readOnlyURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return readOnlyURL.String(), nil
+}
+
+// BuildAdminTrashURL constructs a url to list the manifests in the trash, or,
+// with the repository and digest query parameters, to restore or purge one
+// of them.
+func (ub *URLBuilder) BuildAdminTrashURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameAdminTrash)
+
+	trashURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(trashURL, values...).String(), nil
+}
+
+// BuildAdminUsersURL constructs a url to list or add the users of the
+// registry, or, with the name query parameter, to remove one of them.
+func (ub *URLBuilder) BuildAdminUsersURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameAdminUsers)
+
+	usersURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(usersURL, values...).String(), nil
+}
+
+// BuildAdminTokensURL constructs a url to mint pull tokens.
+func (ub *URLBuilder) BuildAdminTokensURL() (string, error) {
+	route := ub.cloneRoute(RouteNameAdminTokens)
+
+	tokensURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return tokensURL.String(), nil
+}
+
+// BuildAdminEventsURL constructs a url to download the recent events, or to
+// replay them to an endpoint.
+func (ub *URLBuilder) BuildAdminEventsURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameAdminEvents)
+
+	eventsURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(eventsURL, values...).String(), nil
+}
+
+// BuildAdminUploadPurgingURL constructs a url to run a purge pass of the
+// stale uploads.
+func (ub *URLBuilder) BuildAdminUploadPurgingURL(values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameAdminUploadPurging)
+
+	purgeURL, err := route.URL()
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(purgeURL, values...).String(), nil
+}
+
 // BuildTagsURL constructs a url to list the tags in the named repository.
 func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (string, error) {
 	route := ub.cloneRoute(RouteNameTags)
@@ -139,6 +268,32 @@ func (ub *URLBuilder) BuildTagsURL(name reference.Named, values ...url.Values) (
 	return appendValuesURL(tagsURL, values...).String(), nil
 }
 
+// BuildTagHistoryURL constructs a url to get the history of the manifests the
+// tag of the named repository pointed to.
+func (ub *URLBuilder) BuildTagHistoryURL(ref reference.NamedTagged) (string, error) {
+	route := ub.cloneRoute(RouteNameTagHistory)
+
+	historyURL, err := route.URL("name", ref.Name(), "tag", ref.Tag())
+	if err != nil {
+		return "", err
+	}
+
+	return historyURL.String(), nil
+}
+
+// BuildReferrersURL constructs a url to list the referrers of the manifest
+// identified by name and dgst.
+func (ub *URLBuilder) BuildReferrersURL(ref reference.Canonical, values ...url.Values) (string, error) {
+	route := ub.cloneRoute(RouteNameReferrers)
+
+	referrersURL, err := route.URL("name", ref.Name(), "digest", ref.Digest().String())
+	if err != nil {
+		return "", err
+	}
+
+	return appendValuesURL(referrersURL, values...).String(), nil
+}
+
 // BuildManifestURL constructs a url for the manifest identified by name and
 // reference. The argument reference may be either a tag or digest.
 func (ub *URLBuilder) BuildManifestURL(ref reference.Named) (string, error) {
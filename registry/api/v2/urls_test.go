@@ -26,6 +26,46 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 			expectedErr:  nil,
 			build:        urlBuilder.BuildBaseURL,
 		},
+		{
+			description:  "test proxy stats url",
+			expectedPath: "/v2/_proxy/stats",
+			expectedErr:  nil,
+			build:        urlBuilder.BuildProxyStatsURL,
+		},
+		{
+			description:  "test proxy namespaces url",
+			expectedPath: "/v2/_proxy/namespaces",
+			expectedErr:  nil,
+			build:        urlBuilder.BuildProxyNamespacesURL,
+		},
+		{
+			description:  "test replication status url",
+			expectedPath: "/v2/_replication/status",
+			expectedErr:  nil,
+			build:        urlBuilder.BuildReplicationStatusURL,
+		},
+		{
+			description:  "test admin read-only url",
+			expectedPath: "/v2/_admin/readonly",
+			expectedErr:  nil,
+			build:        urlBuilder.BuildAdminReadOnlyURL,
+		},
+		{
+			description:  "test admin trash url",
+			expectedPath: "/v2/_admin/trash",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildAdminTrashURL()
+			},
+		},
+		{
+			description:  "test admin trash url with a manifest",
+			expectedPath: "/v2/_admin/trash?digest=sha256%3Aabcdef&repository=foo%2Fbar",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				return urlBuilder.BuildAdminTrashURL(url.Values{"repository": []string{"foo/bar"}, "digest": []string{"sha256:abcdef"}})
+			},
+		},
 		{
 			description:  "test tags url",
 			expectedPath: "/v2/foo/bar/tags/list",
@@ -54,6 +94,15 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				})
 			},
 		},
+		{
+			description:  "test tag history url",
+			expectedPath: "/v2/foo/bar/_tags/prod/history",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithTag(fooBarRef, "prod")
+				return urlBuilder.BuildTagHistoryURL(ref)
+			},
+		},
 		{
 			description:  "test manifest url tagged ref",
 			expectedPath: "/v2/foo/bar/manifests/tag",
@@ -71,6 +120,17 @@ func makeURLBuilderTestCases(urlBuilder *URLBuilder) []urlBuilderTestCase {
 				return urlBuilder.BuildManifestURL(fooBarRef)
 			},
 		},
+		{
+			description:  "build referrers url",
+			expectedPath: "/v2/foo/bar/referrers/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5?artifactType=application%2Fexample",
+			expectedErr:  nil,
+			build: func() (string, error) {
+				ref, _ := reference.WithDigest(fooBarRef, "sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5")
+				return urlBuilder.BuildReferrersURL(ref, url.Values{
+					"artifactType": []string{"application/example"},
+				})
+			},
+		},
 		{
 			description:  "build blob url",
 			expectedPath: "/v2/foo/bar/blobs/sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5",
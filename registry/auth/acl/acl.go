@@ -0,0 +1,199 @@
+// Package acl restricts the access granted by an access controller to the
+// repositories and registry-wide endpoints each user is granted by the rules
+// of the configuration, so that teams can share a registry without an
+// external token server.
+package acl
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// registryResources are the names of the registry-wide resources rules can
+// grant access to.
+var registryResources = map[string]struct{}{
+	"catalog":     {},
+	"proxy":       {},
+	"replication": {},
+	"admin":       {},
+	"*":           {},
+}
+
+// repositoryActions are the actions rules can grant on repositories.
+var repositoryActions = map[string]struct{}{
+	"pull":   {},
+	"push":   {},
+	"delete": {},
+	"*":      {},
+}
+
+type rule struct {
+	repositories []string
+	registry     []string
+	users        []string
+	groups       []string
+	actions      []string
+}
+
+// ACL grants users, and the groups they belong to, access to repositories
+// and registry-wide resources.
+type ACL struct {
+	rules []rule
+	// groups are the groups of each user
+	groups map[string][]string
+}
+
+// New validates the rules of the configuration and returns the ACL they
+// define.
+func New(config configuration.ACL) (*ACL, error) {
+	acl := &ACL{groups: make(map[string][]string)}
+	for group, users := range config.Groups {
+		for _, user := range users {
+			acl.groups[user] = append(acl.groups[user], group)
+		}
+	}
+
+	for i, r := range config.Rules {
+		if len(r.Repositories) == 0 && len(r.Registry) == 0 {
+			return nil, fmt.Errorf("acl rule %d: repositories or registry must be set", i)
+		}
+		if len(r.Users) == 0 && len(r.Groups) == 0 {
+			return nil, fmt.Errorf("acl rule %d: users or groups must be set", i)
+		}
+		for _, pattern := range r.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("acl rule %d: invalid repository pattern %q: %v", i, pattern, err)
+			}
+		}
+		for _, resource := range r.Registry {
+			if _, ok := registryResources[resource]; !ok {
+				return nil, fmt.Errorf("acl rule %d: unknown registry resource %q", i, resource)
+			}
+		}
+		if len(r.Repositories) > 0 && len(r.Actions) == 0 {
+			return nil, fmt.Errorf("acl rule %d: actions must be set for repositories", i)
+		}
+		for _, action := range r.Actions {
+			if _, ok := repositoryActions[action]; !ok {
+				return nil, fmt.Errorf("acl rule %d: unknown action %q", i, action)
+			}
+		}
+
+		acl.rules = append(acl.rules, rule{
+			repositories: r.Repositories,
+			registry:     r.Registry,
+			users:        r.Users,
+			groups:       r.Groups,
+			actions:      r.Actions,
+		})
+	}
+	return acl, nil
+}
+
+// Allowed reports whether a rule grants the user the access. Anonymous users
+// have an empty name.
+func (acl *ACL) Allowed(user string, access auth.Access) bool {
+	return acl.allowed(auth.UserInfo{Name: user}, access)
+}
+
+// allowed reports whether a rule grants the user the access, as a member of
+// the groups of the configuration and of those the authentication provider
+// asserted.
+func (acl *ACL) allowed(user auth.UserInfo, access auth.Access) bool {
+	groups := append(append([]string{}, acl.groups[user.Name]...), user.Groups...)
+	for _, r := range acl.rules {
+		if !r.appliesTo(user.Name, groups) {
+			continue
+		}
+		switch access.Type {
+		case "repository":
+			if matchesAny(r.repositories, access.Name) && contains(r.actions, access.Action) {
+				return true
+			}
+		case "registry":
+			if contains(r.registry, access.Name) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type accessController struct {
+	auth.AccessController
+	acl *ACL
+}
+
+var _ auth.AccessController = &accessController{}
+
+// NewAccessController returns an access controller authenticating the
+// requests with the embedded access controller, if not nil, and allowing
+// them when the ACL grants the authenticated user each access they require.
+// Without an embedded access controller, requests are anonymous.
+func NewAccessController(embedded auth.AccessController, acl *ACL) auth.AccessController {
+	return &accessController{
+		AccessController: embedded,
+		acl:              acl,
+	}
+}
+
+// Authorized authenticates the request with the embedded access controller,
+// and returns an error wrapping auth.ErrAccessDenied if the ACL does not
+// grant the authenticated user an access.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	if ac.AccessController != nil {
+		var err error
+		ctx, err = ac.AccessController.Authorized(ctx, accessRecords...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	user, _ := ctx.Value(auth.UserKey).(auth.UserInfo)
+	for _, access := range accessRecords {
+		if !ac.acl.allowed(user, access) {
+			return nil, fmt.Errorf("%w: %s:%s:%s for user %q", auth.ErrAccessDenied, access.Type, access.Name, access.Action, user.Name)
+		}
+	}
+	return ctx, nil
+}
+
+// appliesTo reports whether the rule lists the user, or one of its groups.
+func (r rule) appliesTo(user string, groups []string) bool {
+	for _, u := range r.users {
+		if u == "*" || (u == user && user != "") {
+			return true
+		}
+	}
+	for _, group := range groups {
+		for _, g := range r.groups {
+			if g == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// contains reports whether the values hold value, or *.
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == "*" || v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
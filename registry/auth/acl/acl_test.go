@@ -0,0 +1,133 @@
+package acl
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// userAccessController authenticates every request as its user.
+type userAccessController string
+
+func (user userAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	return auth.WithUser(ctx, auth.UserInfo{Name: string(user)}), nil
+}
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestAccessController(t *testing.T) {
+	config := configuration.ACL{
+		Groups: map[string][]string{
+			"platform": {"carol"},
+		},
+		Rules: []configuration.ACLRule{
+			{Repositories: []string{"team-a/*"}, Users: []string{"alice"}, Actions: []string{"pull", "push"}},
+			{Repositories: []string{"team-a/*", "team-b/*"}, Groups: []string{"platform"}, Actions: []string{"*"}},
+			{Repositories: []string{"public/*"}, Users: []string{"*"}, Actions: []string{"pull"}},
+			{Registry: []string{"catalog"}, Users: []string{"carol"}},
+		},
+	}
+
+	acl, err := New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		user    string
+		access  []auth.Access
+		allowed bool
+	}{
+		{"alice", []auth.Access{repositoryAccess("team-a/app", "pull"), repositoryAccess("team-a/app", "push")}, true},
+		{"alice", []auth.Access{repositoryAccess("team-a/app", "delete")}, false},
+		{"alice", []auth.Access{repositoryAccess("team-b/app", "pull")}, false},
+		{"alice", []auth.Access{repositoryAccess("team-a/nested/app", "pull")}, false},
+		{"alice", []auth.Access{repositoryAccess("public/base", "pull")}, true},
+		{"alice", []auth.Access{repositoryAccess("public/base", "push")}, false},
+		// Mounting requires access to both repositories
+		{"alice", []auth.Access{repositoryAccess("team-a/app", "push"), repositoryAccess("team-b/app", "pull")}, false},
+		{"carol", []auth.Access{repositoryAccess("team-b/app", "delete")}, true},
+		{"alice", []auth.Access{{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}}, false},
+		{"carol", []auth.Access{{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"}}, true},
+		{"carol", []auth.Access{{Resource: auth.Resource{Type: "registry", Name: "admin"}, Action: "*"}}, false},
+		// The base route requires no access
+		{"bob", nil, true},
+	} {
+		ac := NewAccessController(userAccessController(tc.user), acl)
+		ctx, err := ac.Authorized(context.Background(), tc.access...)
+		if tc.allowed {
+			if err != nil {
+				t.Errorf("expected %s to be allowed %v, got %v", tc.user, tc.access, err)
+			} else if name, _ := ctx.Value(auth.UserNameKey).(string); name != tc.user {
+				t.Errorf("expected the context of %s, got %q", tc.user, name)
+			}
+		} else if !errors.Is(err, auth.ErrAccessDenied) {
+			t.Errorf("expected %s to be denied %v, got %v", tc.user, tc.access, err)
+		}
+	}
+}
+
+func TestAccessControllerAnonymous(t *testing.T) {
+	acl, err := New(configuration.ACL{
+		Rules: []configuration.ACLRule{
+			{Repositories: []string{"public/*"}, Users: []string{"*"}, Actions: []string{"pull"}},
+			{Repositories: []string{"private/*"}, Users: []string{""}, Actions: []string{"pull"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := NewAccessController(nil, acl)
+	if _, err := ac.Authorized(context.Background(), repositoryAccess("public/base", "pull")); err != nil {
+		t.Fatalf("expected anonymous pulls to be allowed, got %v", err)
+	}
+	if _, err := ac.Authorized(context.Background(), repositoryAccess("private/base", "pull")); !errors.Is(err, auth.ErrAccessDenied) {
+		t.Fatalf("expected anonymous pulls not to match a user, got %v", err)
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, r := range []configuration.ACLRule{
+		{Users: []string{"alice"}, Actions: []string{"pull"}},
+		{Repositories: []string{"a/*"}, Actions: []string{"pull"}},
+		{Repositories: []string{"a/["}, Users: []string{"alice"}, Actions: []string{"pull"}},
+		{Repositories: []string{"a/*"}, Users: []string{"alice"}},
+		{Repositories: []string{"a/*"}, Users: []string{"alice"}, Actions: []string{"write"}},
+		{Registry: []string{"everything"}, Users: []string{"alice"}},
+	} {
+		if _, err := New(configuration.ACL{Rules: []configuration.ACLRule{r}}); err == nil {
+			t.Errorf("expected rule %+v to be invalid", r)
+		}
+	}
+}
+
+// groupsAccessController authenticates every request as dave, a member of
+// the groups asserted by an identity provider.
+type groupsAccessController []string
+
+func (groups groupsAccessController) Authorized(ctx context.Context, access ...auth.Access) (context.Context, error) {
+	return auth.WithUser(ctx, auth.UserInfo{Name: "dave", Groups: groups}), nil
+}
+
+func TestAccessControllerProviderGroups(t *testing.T) {
+	acl, err := New(configuration.ACL{
+		Rules: []configuration.ACLRule{
+			{Repositories: []string{"team-a/*"}, Groups: []string{"team-a"}, Actions: []string{"pull", "push"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	push := repositoryAccess("team-a/app", "push")
+	if _, err := NewAccessController(groupsAccessController{"team-a"}, acl).Authorized(context.Background(), push); err != nil {
+		t.Fatalf("expected members of the group to be allowed, got %v", err)
+	}
+	if _, err := NewAccessController(groupsAccessController{"team-b"}, acl).Authorized(context.Background(), push); !errors.Is(err, auth.ErrAccessDenied) {
+		t.Fatalf("expected other users to be denied, got %v", err)
+	}
+}
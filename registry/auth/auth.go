@@ -54,12 +54,26 @@ var (
 
 	// ErrAuthenticationFailure returned when authentication fails.
 	ErrAuthenticationFailure = errors.New("authentication failure")
+
+	// ErrAccessDenied is returned when an authenticated user is not granted
+	// the requested access.
+	ErrAccessDenied = errors.New("access denied")
+
+	// ErrUserUnknown is returned when removing a user which does not exist.
+	ErrUserUnknown = errors.New("unknown user")
+
+	// ErrInvalidUser is returned when adding a user with an invalid name or
+	// password.
+	ErrInvalidUser = errors.New("invalid user")
 )
 
 // UserInfo carries information about
 // an autenticated/authorized client.
 type UserInfo struct {
 	Name string
+	// Groups are the groups the authentication provider asserts the user
+	// belongs to, if it knows any
+	Groups []string
 }
 
 // Resource describes a resource by type and name.
@@ -110,6 +124,20 @@ type CredentialAuthenticator interface {
 	AuthenticateUser(username, password string) error
 }
 
+// UserManager is implemented by access controllers whose users can be
+// listed, added and removed while the registry runs.
+type UserManager interface {
+	// Users returns the names of the users.
+	Users() ([]string, error)
+
+	// SetPassword adds the user, or replaces its password. It returns
+	// ErrInvalidUser when the name or password is not accepted.
+	SetPassword(username, password string) error
+
+	// RemoveUser removes the user, or returns ErrUserUnknown.
+	RemoveUser(username string) error
+}
+
 // WithUser returns a context with the authorized user info.
 func WithUser(ctx context.Context, user UserInfo) context.Context {
 	return userInfoContext{
@@ -0,0 +1,156 @@
+// Package clientcert provides an access controller authenticating clients
+// with the TLS client certificates verified by the listener of the registry,
+// for machine-to-machine pulls where tokens are inconvenient.
+//
+// The name of the certificate, its subject common name or one of its subject
+// alternative names, becomes the name of the user, which the acl grants
+// access to repositories. Without an acl, every authenticated user is granted
+// every access.
+package clientcert
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// ErrCertificateRequired is returned for requests without a verified client
+// certificate.
+var ErrCertificateRequired = errors.New("client certificate required")
+
+// identities extract the names of a certificate a user can be identified by.
+var identities = map[string]func(cert *x509.Certificate) []string{
+	"commonname": func(cert *x509.Certificate) []string {
+		if cert.Subject.CommonName == "" {
+			return nil
+		}
+		return []string{cert.Subject.CommonName}
+	},
+	"dns": func(cert *x509.Certificate) []string {
+		return cert.DNSNames
+	},
+	"email": func(cert *x509.Certificate) []string {
+		return cert.EmailAddresses
+	},
+	"uri": func(cert *x509.Certificate) []string {
+		names := make([]string, 0, len(cert.URIs))
+		for _, uri := range cert.URIs {
+			names = append(names, uri.String())
+		}
+		return names
+	},
+}
+
+// challenge is returned for requests without an accepted certificate. The
+// client can only retry with another certificate, so it sets no header.
+type challenge struct {
+	err error
+}
+
+var _ auth.Challenge = challenge{}
+
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("client certificate authentication: %s", ch.err)
+}
+
+type accessController struct {
+	identity string
+	names    func(cert *x509.Certificate) []string
+	// users maps the names of certificates to user names. When set, the
+	// certificates without a mapped name are rejected.
+	users map[string]string
+}
+
+var _ auth.AccessController = &accessController{}
+
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	ac := &accessController{identity: "commonname"}
+	if val, ok := options["identity"]; ok {
+		identity, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("clientcert auth requires a valid option string: %q", "identity")
+		}
+		ac.identity = identity
+	}
+	ac.names = identities[ac.identity]
+	if ac.names == nil {
+		return nil, fmt.Errorf("clientcert auth: unknown identity %q", ac.identity)
+	}
+
+	if val, ok := options["users"]; ok {
+		ac.users = make(map[string]string)
+		switch m := val.(type) {
+		case map[interface{}]interface{}:
+			for k, v := range m {
+				name, ok1 := k.(string)
+				user, ok2 := v.(string)
+				if !ok1 || !ok2 {
+					return nil, fmt.Errorf("clientcert auth requires a valid option map: %q", "users")
+				}
+				ac.users[name] = user
+			}
+		case map[string]interface{}:
+			for name, v := range m {
+				user, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("clientcert auth requires a valid option map: %q", "users")
+				}
+				ac.users[name] = user
+			}
+		default:
+			return nil, fmt.Errorf("clientcert auth requires a valid option map: %q", "users")
+		}
+	}
+	return ac, nil
+}
+
+// Authorized authenticates the user of the verified client certificate of
+// the request. The access is left to the acl to decide.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// The certificates are only verified when the listener was configured
+	// with client certificate authorities
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.PeerCertificates) == 0 {
+		return nil, challenge{err: ErrCertificateRequired}
+	}
+
+	cert := req.TLS.PeerCertificates[0]
+	user, err := ac.user(cert)
+	if err != nil {
+		dcontext.GetLogger(ctx).Infof("clientcert: rejecting certificate of %q: %v", cert.Subject, err)
+		return nil, challenge{err: auth.ErrInvalidCredential}
+	}
+	return auth.WithUser(ctx, auth.UserInfo{Name: user}), nil
+}
+
+// user returns the user the certificate identifies.
+func (ac *accessController) user(cert *x509.Certificate) (string, error) {
+	names := ac.names(cert)
+	if len(names) == 0 {
+		return "", fmt.Errorf("no %s name", ac.identity)
+	}
+	if ac.users == nil {
+		return names[0], nil
+	}
+	for _, name := range names {
+		if user, ok := ac.users[name]; ok {
+			return user, nil
+		}
+	}
+	return "", fmt.Errorf("no mapped %s name", ac.identity)
+}
+
+// init registers the clientcert auth backend.
+func init() {
+	auth.Register("clientcert", auth.InitFunc(newAccessController))
+}
@@ -0,0 +1,95 @@
+package clientcert
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/url"
+	"testing"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+// authorize returns the user authorized with the TLS state of a request.
+func authorize(ac auth.AccessController, state *tls.ConnectionState) (string, error) {
+	ctx, err := testutil.Authorize(ac, func(r *http.Request) { r.TLS = state })
+	if err != nil {
+		return "", err
+	}
+	return dcontext.GetStringValue(ctx, auth.UserNameKey), nil
+}
+
+// verified returns the state of a connection with a verified certificate.
+func verified(cert *x509.Certificate) *tls.ConnectionState {
+	return &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{cert},
+		VerifiedChains:   [][]*x509.Certificate{{cert}},
+	}
+}
+
+func TestAccessController(t *testing.T) {
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/ci/sa/builder")
+	cert := &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "builder"},
+		DNSNames: []string{"builder.ci.svc", "builder.ci.svc.cluster.local"},
+		URIs:     []*url.URL{spiffe},
+	}
+
+	for _, tc := range []struct {
+		options  map[string]interface{}
+		expected string
+	}{
+		{map[string]interface{}{}, "builder"},
+		{map[string]interface{}{"identity": "dns"}, "builder.ci.svc"},
+		{map[string]interface{}{"identity": "uri"}, "spiffe://cluster.local/ns/ci/sa/builder"},
+		{map[string]interface{}{
+			"identity": "dns",
+			"users":    map[interface{}]interface{}{"builder.ci.svc.cluster.local": "ci"},
+		}, "ci"},
+		{map[string]interface{}{"identity": "email"}, ""},
+		{map[string]interface{}{"users": map[interface{}]interface{}{"other": "ci"}}, ""},
+	} {
+		ac, err := newAccessController(tc.options)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := authorize(ac, verified(cert))
+		if tc.expected == "" {
+			if _, ok := err.(auth.Challenge); !ok {
+				t.Errorf("%v: expected the certificate to be rejected, got %v", tc.options, err)
+			}
+		} else if err != nil || user != tc.expected {
+			t.Errorf("%v: expected user %q, got %q (%v)", tc.options, tc.expected, user, err)
+		}
+	}
+
+	ac, err := newAccessController(map[string]interface{}{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, state := range []*tls.ConnectionState{
+		nil,
+		{},
+		// Certificates are only trusted when the listener verified them
+		{PeerCertificates: []*x509.Certificate{cert}},
+	} {
+		if _, err := authorize(ac, state); err == nil {
+			t.Errorf("expected a connection without a verified certificate to be rejected")
+		}
+	}
+}
+
+func TestNewAccessControllerInvalid(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{"identity": "serial"},
+		{"identity": 1},
+		{"users": []interface{}{"builder"}},
+	} {
+		if _, err := newAccessController(options); err == nil {
+			t.Errorf("expected options %v to be invalid", options)
+		}
+	}
+}
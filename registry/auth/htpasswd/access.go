@@ -23,9 +23,15 @@ import (
 )
 
 type accessController struct {
-	realm    string
-	path     string
+	realm string
+	path  string
+	// minCost is the minimum bcrypt cost of the entries of the file, those
+	// of lower costs are ignored
+	minCost int
+	// cost is the bcrypt cost of the passwords set through the admin API
+	cost     int
 	modtime  time.Time
+	size     int64
 	mu       sync.Mutex
 	htpasswd *htpasswd
 }
@@ -43,10 +49,45 @@ func newAccessController(options map[string]interface{}) (auth.AccessController,
 	if !present || !ok {
 		return nil, fmt.Errorf(`"path" must be set for htpasswd access controller`)
 	}
-	if err := createHtpasswdFile(path); err != nil {
+
+	costOption := func(name string, defaultCost int) (int, error) {
+		val, present := options[name]
+		if !present {
+			return defaultCost, nil
+		}
+		cost, ok := val.(int)
+		if !ok || cost < bcrypt.MinCost || cost > bcrypt.MaxCost {
+			return 0, fmt.Errorf("%q must be a bcrypt cost between %d and %d for htpasswd access controller", name, bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return cost, nil
+	}
+	minCost, err := costOption("mincost", bcrypt.MinCost)
+	if err != nil {
+		return nil, err
+	}
+	cost, err := costOption("cost", bcrypt.DefaultCost)
+	if err != nil {
+		return nil, err
+	}
+	if cost < minCost {
+		cost = minCost
+	}
+
+	var usersAPI bool
+	if val, present := options["usersapi"]; present {
+		if usersAPI, ok = val.(bool); !ok {
+			return nil, fmt.Errorf(`"usersapi" must be a boolean for htpasswd access controller`)
+		}
+	}
+
+	if err := createHtpasswdFile(path, cost); err != nil {
 		return nil, err
 	}
-	return &accessController{realm: realm.(string), path: path}, nil
+	ac := &accessController{realm: realm.(string), path: path, minCost: minCost, cost: cost}
+	if usersAPI {
+		return &managedAccessController{accessController: ac}, nil
+	}
+	return ac, nil
 }
 
 func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
@@ -63,43 +104,48 @@ func (ac *accessController) Authorized(ctx context.Context, accessRecords ...aut
 		}
 	}
 
-	// Dynamically parsing the latest account list
+	localHTPasswd, err := ac.load()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := localHTPasswd.authenticateUser(username, password); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error authenticating user %q: %v", username, err)
+		return nil, &challenge{
+			realm: ac.realm,
+			err:   auth.ErrAuthenticationFailure,
+		}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
+}
+
+// load returns the entries of the file, parsed again whenever the file
+// changed, so that credentials can be rotated without a restart.
+func (ac *accessController) load() (*htpasswd, error) {
 	fstat, err := os.Stat(ac.path)
 	if err != nil {
 		return nil, err
 	}
 
-	lastModified := fstat.ModTime()
 	ac.mu.Lock()
-	if ac.htpasswd == nil || !ac.modtime.Equal(lastModified) {
-		ac.modtime = lastModified
-
+	defer ac.mu.Unlock()
+	if ac.htpasswd == nil || !ac.modtime.Equal(fstat.ModTime()) || ac.size != fstat.Size() {
 		f, err := os.Open(ac.path)
 		if err != nil {
-			ac.mu.Unlock()
 			return nil, err
 		}
 		defer f.Close()
 
-		h, err := newHTPasswd(f)
+		h, err := newHTPasswd(f, ac.minCost)
 		if err != nil {
-			ac.mu.Unlock()
 			return nil, err
 		}
+		ac.modtime = fstat.ModTime()
+		ac.size = fstat.Size()
 		ac.htpasswd = h
 	}
-	localHTPasswd := ac.htpasswd
-	ac.mu.Unlock()
-
-	if err := localHTPasswd.authenticateUser(username, password); err != nil {
-		dcontext.GetLogger(ctx).Errorf("error authenticating user %q: %v", username, err)
-		return nil, &challenge{
-			realm: ac.realm,
-			err:   auth.ErrAuthenticationFailure,
-		}
-	}
-
-	return auth.WithUser(ctx, auth.UserInfo{Name: username}), nil
+	return ac.htpasswd, nil
 }
 
 // challenge implements the auth.Challenge interface.
@@ -120,7 +166,7 @@ func (ch challenge) Error() string {
 }
 
 // createHtpasswdFile creates and populates htpasswd file with a new user in case the file is missing
-func createHtpasswdFile(path string) error {
+func createHtpasswdFile(path string, cost int) error {
 	if f, err := os.Open(path); err == nil {
 		f.Close()
 		return nil
@@ -141,7 +187,7 @@ func createHtpasswdFile(path string) error {
 		return err
 	}
 	pass := base64.RawURLEncoding.EncodeToString(secretBytes[:])
-	encryptedPass, err := bcrypt.GenerateFromPassword([]byte(pass), bcrypt.DefaultCost)
+	encryptedPass, err := bcrypt.GenerateFromPassword([]byte(pass), cost)
 	if err != nil {
 		return err
 	}
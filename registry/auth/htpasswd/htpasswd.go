@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"fmt"
 	"io"
+	"sort"
 	"strings"
 
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/sirupsen/logrus"
 
 	"golang.org/x/crypto/bcrypt"
 )
@@ -17,16 +19,34 @@ type htpasswd struct {
 	entries map[string][]byte // maps username to password byte slice.
 }
 
-// newHTPasswd parses the reader and returns an htpasswd or an error.
-func newHTPasswd(rd io.Reader) (*htpasswd, error) {
+// newHTPasswd parses the reader and returns an htpasswd or an error. The
+// bcrypt entries of a cost below minCost are ignored.
+func newHTPasswd(rd io.Reader, minCost int) (*htpasswd, error) {
 	entries, err := parseHTPasswd(rd)
 	if err != nil {
 		return nil, err
 	}
 
+	for username, credentials := range entries {
+		if cost, err := bcrypt.Cost(credentials); err == nil && cost < minCost {
+			logrus.Warnf("htpasswd: ignoring the entry of user %q, of bcrypt cost %d below %d", username, cost, minCost)
+			delete(entries, username)
+		}
+	}
+
 	return &htpasswd{entries: entries}, nil
 }
 
+// users returns the names of the users, sorted.
+func (htpasswd *htpasswd) users() []string {
+	users := make([]string, 0, len(htpasswd.entries))
+	for username := range htpasswd.entries {
+		users = append(users, username)
+	}
+	sort.Strings(users)
+	return users
+}
+
 // AuthenticateUser checks a given user:password credential against the
 // receiving HTPasswd's file. If the check passes, nil is returned.
 func (htpasswd *htpasswd) authenticateUser(username string, password string) error {
@@ -0,0 +1,129 @@
+package htpasswd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// managedAccessController is an htpasswd access controller whose users can
+// be managed through the admin API, which rewrites the htpasswd file.
+type managedAccessController struct {
+	*accessController
+}
+
+var _ auth.UserManager = &managedAccessController{}
+
+// Users returns the names of the users of the file.
+func (ac *managedAccessController) Users() ([]string, error) {
+	h, err := ac.load()
+	if err != nil {
+		return nil, err
+	}
+	return h.users(), nil
+}
+
+// SetPassword adds the user to the file, or replaces its password, hashed
+// with the configured bcrypt cost.
+func (ac *managedAccessController) SetPassword(username, password string) error {
+	if username == "" || strings.ContainsAny(username, ": \t\r\n") || strings.HasPrefix(username, "#") {
+		return fmt.Errorf("%w: name %q", auth.ErrInvalidUser, username)
+	}
+	if password == "" {
+		return fmt.Errorf("%w: empty password", auth.ErrInvalidUser)
+	}
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), ac.cost)
+	if err != nil {
+		return err
+	}
+
+	return ac.rewrite(func(lines []string) ([]string, error) {
+		entry := username + ":" + string(hash)
+		for i, line := range lines {
+			if entryUser(line) == username {
+				lines[i] = entry
+				return lines, nil
+			}
+		}
+		return append(lines, entry), nil
+	})
+}
+
+// RemoveUser removes the user from the file.
+func (ac *managedAccessController) RemoveUser(username string) error {
+	return ac.rewrite(func(lines []string) ([]string, error) {
+		for i, line := range lines {
+			if entryUser(line) == username {
+				return append(lines[:i], lines[i+1:]...), nil
+			}
+		}
+		return nil, auth.ErrUserUnknown
+	})
+}
+
+// entryUser returns the user of a line of the file, or "" for comments and
+// blank lines.
+func entryUser(line string) string {
+	t := strings.TrimSpace(line)
+	if t == "" || t[0] == '#' {
+		return ""
+	}
+	username, _, _ := strings.Cut(t, ":")
+	return username
+}
+
+// rewrite replaces the lines of the file with those returned by update. The
+// file is replaced atomically, so that concurrent reads see the previous or
+// the new users.
+func (ac *managedAccessController) rewrite(update func(lines []string) ([]string, error)) error {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	fstat, err := os.Stat(ac.path)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(ac.path)
+	if err != nil {
+		return err
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if lines, err = update(lines); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(ac.path), filepath.Base(ac.path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err = f.Chmod(fstat.Mode()); err == nil {
+		_, err = f.WriteString(strings.Join(lines, "\n") + "\n")
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(f.Name(), ac.path); err != nil {
+		return err
+	}
+
+	// parse the file again on the next request
+	ac.htpasswd = nil
+	return nil
+}
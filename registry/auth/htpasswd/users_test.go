@@ -0,0 +1,117 @@
+package htpasswd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestManagedUsers(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# managed by the registry\nfrodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":    "The-Shire",
+		"path":     path,
+		"cost":     bcrypt.MinCost,
+		"usersapi": true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	users, ok := ac.(auth.UserManager)
+	if !ok {
+		t.Fatal("expected the access controller to manage its users")
+	}
+
+	if err := users.SetPassword("sam", "gamgee"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetPassword("frodo", "ring"); err != nil {
+		t.Fatal(err)
+	}
+	names, err := users.Users()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"frodo", "sam"}; !reflect.DeepEqual(names, expected) {
+		t.Fatalf("expected users %v, got %v", expected, names)
+	}
+	h, err := ac.(*managedAccessController).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.authenticateUser("frodo", "ring"); err != nil {
+		t.Fatalf("expected the password of frodo to be replaced: %v", err)
+	}
+	if cost, _ := bcrypt.Cost(h.entries["sam"]); cost != bcrypt.MinCost {
+		t.Fatalf("expected the configured cost, got %d", cost)
+	}
+
+	if err := users.RemoveUser("frodo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.RemoveUser("frodo"); err != auth.ErrUserUnknown {
+		t.Fatalf("expected removing an unknown user to fail, got %v", err)
+	}
+	if err := users.SetPassword("bad:name", "password"); err == nil {
+		t.Fatal("expected an invalid user name to be rejected")
+	}
+
+	written, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err = newHTPasswd(bytes.NewReader(written), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if names := h.users(); !reflect.DeepEqual(names, []string{"sam"}) {
+		t.Fatalf("expected the file to only have sam, got %v", names)
+	}
+	if written[0] != '#' {
+		t.Fatalf("expected comments to be kept, got %q", written)
+	}
+}
+
+func TestMinCost(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	// frodo's password is hashed with a cost of 5
+	content := "frodo:$2y$05$926C3y10Quzn/LnqQH86VOEVh/18T6RnLaS.khre96jLNL/7e.K5W\n"
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ac, err := newAccessController(map[string]interface{}{
+		"realm":   "The-Shire",
+		"path":    path,
+		"mincost": 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := ac.(*accessController).load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(h.users()) != 0 {
+		t.Fatalf("expected entries below the minimum cost to be ignored, got %v", h.users())
+	}
+
+	for _, options := range []map[string]interface{}{
+		{"realm": "The-Shire", "path": path, "mincost": 1},
+		{"realm": "The-Shire", "path": path, "cost": "10"},
+		{"realm": "The-Shire", "path": path, "usersapi": "yes"},
+	} {
+		if _, err := newAccessController(options); err == nil {
+			t.Errorf("expected options %v to be invalid", options)
+		}
+	}
+}
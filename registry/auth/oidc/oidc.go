@@ -0,0 +1,255 @@
+// Package oidc provides an access controller authenticating clients with the
+// tokens of an OpenID Connect issuer, so that workloads with an identity of
+// their platform and users signing in with SSO can push and pull without
+// static passwords.
+//
+// Tokens are accepted as bearer tokens, or as the password of basic
+// authentication for clients which only support credentials, such as
+// docker login. The access controller authenticates the user and its groups,
+// which the acl grants access to repositories. Without an acl, every
+// authenticated user is granted every access.
+package oidc
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/token"
+)
+
+// Errors returned by the access controller.
+var (
+	ErrTokenRequired = errors.New("authorization token required")
+	ErrInvalidToken  = errors.New("invalid token")
+)
+
+// challenge is returned for requests without a valid token.
+type challenge struct {
+	realm string
+	err   error
+}
+
+var _ auth.Challenge = challenge{}
+
+// SetHeaders sets a basic challenge, so that clients send the token as the
+// password of their credentials.
+func (ch challenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
+	w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", ch.realm))
+}
+
+func (ch challenge) Error() string {
+	return fmt.Sprintf("oidc authentication challenge for realm %q: %s", ch.realm, ch.err)
+}
+
+type accessController struct {
+	realm         string
+	issuer        string
+	audiences     []string
+	usernameClaim string
+	groupsClaim   string
+	keys          *keySet
+}
+
+var _ auth.AccessController = &accessController{}
+
+func newAccessController(options map[string]interface{}) (auth.AccessController, error) {
+	issuer, ok := options["issuer"].(string)
+	if !ok || issuer == "" {
+		return nil, fmt.Errorf("oidc auth requires a valid option string: %q", "issuer")
+	}
+
+	var audiences []string
+	switch audience := options["audience"].(type) {
+	case string:
+		audiences = []string{audience}
+	case []interface{}:
+		for _, v := range audience {
+			s, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("oidc auth requires a valid option string or list: %q", "audience")
+			}
+			audiences = append(audiences, s)
+		}
+	}
+	if len(audiences) == 0 {
+		return nil, fmt.Errorf("oidc auth requires a valid option string or list: %q", "audience")
+	}
+
+	stringOption := func(name, defaultValue string) (string, error) {
+		val, ok := options[name]
+		if !ok {
+			return defaultValue, nil
+		}
+		s, ok := val.(string)
+		if !ok || s == "" {
+			return "", fmt.Errorf("oidc auth requires a valid option string: %q", name)
+		}
+		return s, nil
+	}
+	realm, err := stringOption("realm", issuer)
+	if err != nil {
+		return nil, err
+	}
+	jwksURL, err := stringOption("jwksurl", "")
+	if err != nil {
+		return nil, err
+	}
+	usernameClaim, err := stringOption("usernameclaim", "sub")
+	if err != nil {
+		return nil, err
+	}
+	groupsClaim, err := stringOption("groupsclaim", "groups")
+	if err != nil {
+		return nil, err
+	}
+
+	return &accessController{
+		realm:         realm,
+		issuer:        issuer,
+		audiences:     audiences,
+		usernameClaim: usernameClaim,
+		groupsClaim:   groupsClaim,
+		keys: &keySet{
+			client: &http.Client{Timeout: 10 * time.Second},
+			issuer: issuer,
+			url:    jwksURL,
+		},
+	}, nil
+}
+
+// Authorized authenticates the user of the token of the request, and its
+// groups. The access is left to the acl to decide.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var rawToken string
+	if prefix, value, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok && strings.EqualFold(prefix, "bearer") {
+		rawToken = value
+	} else if _, password, ok := req.BasicAuth(); ok {
+		rawToken = password
+	}
+	if rawToken == "" {
+		return nil, challenge{realm: ac.realm, err: ErrTokenRequired}
+	}
+
+	claims, err := ac.verify(ctx, rawToken)
+	if err != nil {
+		dcontext.GetLogger(ctx).Infof("oidc: %v", err)
+		return nil, challenge{realm: ac.realm, err: ErrInvalidToken}
+	}
+	user, _ := claims[ac.usernameClaim].(string)
+	if user == "" {
+		dcontext.GetLogger(ctx).Infof("oidc: token has no %q claim", ac.usernameClaim)
+		return nil, challenge{realm: ac.realm, err: ErrInvalidToken}
+	}
+
+	return auth.WithUser(ctx, auth.UserInfo{Name: user, Groups: stringsClaim(claims[ac.groupsClaim])}), nil
+}
+
+// verify checks the signature, issuer, audience and lifetime of a token, and
+// returns its claims.
+func (ac *accessController) verify(ctx context.Context, rawToken string) (map[string]interface{}, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("malformed token")
+	}
+	var header struct {
+		Algorithm string `json:"alg"`
+		KeyID     string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed token header: %v", err)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed token signature: %v", err)
+	}
+	key, err := ac.keys.get(ctx, header.KeyID)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(key, header.Algorithm, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var registered struct {
+		Issuer     string             `json:"iss"`
+		Audience   token.AudienceList `json:"aud"`
+		Expiration *float64           `json:"exp"`
+		NotBefore  *float64           `json:"nbf"`
+	}
+	if err := decodeSegment(parts[1], &registered); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	if registered.Issuer != ac.issuer {
+		return nil, fmt.Errorf("token from untrusted issuer %q", registered.Issuer)
+	}
+	if !containsAny(ac.audiences, registered.Audience) {
+		return nil, fmt.Errorf("token intended for another audience: %v", registered.Audience)
+	}
+	now := time.Now()
+	if registered.Expiration == nil || now.After(time.Unix(int64(*registered.Expiration), 0).Add(token.Leeway)) {
+		return nil, errors.New("token expired")
+	}
+	if registered.NotBefore != nil && now.Before(time.Unix(int64(*registered.NotBefore), 0).Add(-token.Leeway)) {
+		return nil, errors.New("token not valid yet")
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed token claims: %v", err)
+	}
+	return claims, nil
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// stringsClaim returns the strings of a claim holding a string or a list.
+func stringsClaim(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			if s, ok := value.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+func containsAny(values, candidates []string) bool {
+	for _, v := range values {
+		for _, c := range candidates {
+			if v == c {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// init registers the oidc auth backend.
+func init() {
+	auth.Register("oidc", auth.InitFunc(newAccessController))
+}
@@ -0,0 +1,271 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+// issuer is a fake OpenID Connect issuer signing tokens with an RSA and an
+// EC key.
+type issuer struct {
+	*httptest.Server
+	rsaKey *rsa.PrivateKey
+	ecKey  *ecdsa.PrivateKey
+}
+
+func newIssuer(t *testing.T) *issuer {
+	t.Helper()
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	iss := &issuer{rsaKey: rsaKey, ecKey: ecKey}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   iss.URL,
+			"jwks_uri": iss.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		encode := func(i *big.Int) string { return base64.RawURLEncoding.EncodeToString(i.Bytes()) }
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{
+				{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+				{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X), "y": encode(ecKey.Y)},
+				{"kty": "RSA", "kid": "enc", "use": "enc", "n": encode(rsaKey.N), "e": encode(big.NewInt(int64(rsaKey.E)))},
+			},
+		})
+	})
+	iss.Server = httptest.NewServer(mux)
+	t.Cleanup(iss.Close)
+	return iss
+}
+
+// sign returns a token of the claims signed with the key identified by kid.
+func (iss *issuer) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	t.Helper()
+	alg := "RS256"
+	if kid == "ec" {
+		alg = "ES256"
+	}
+	header, _ := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var signature []byte
+	var err error
+	if kid == "ec" {
+		r, s, err := ecdsa.Sign(rand.Reader, iss.ecKey, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature = make([]byte, 64)
+		r.FillBytes(signature[:32])
+		s.FillBytes(signature[32:])
+	} else if signature, err = rsa.SignPKCS1v15(rand.Reader, iss.rsaKey, crypto.SHA256, digest[:]); err != nil {
+		t.Fatal(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func (iss *issuer) claims(overrides map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":    iss.URL,
+		"aud":    []string{"registry"},
+		"sub":    "system:serviceaccount:ci:builder",
+		"email":  "alice@example.com",
+		"groups": []string{"team-a", "team-b"},
+		"exp":    time.Now().Add(time.Hour).Unix(),
+		"iat":    time.Now().Unix(),
+	}
+	for k, v := range overrides {
+		if v == nil {
+			delete(claims, k)
+		} else {
+			claims[k] = v
+		}
+	}
+	return claims
+}
+
+func TestAccessController(t *testing.T) {
+	iss := newIssuer(t)
+	ac, err := newAccessController(map[string]interface{}{
+		"issuer":   iss.URL,
+		"audience": "registry",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Requests without a token are challenged for credentials
+	_, err = testutil.Authorize(ac, func(r *http.Request) {})
+	ch, ok := err.(auth.Challenge)
+	if !ok {
+		t.Fatalf("expected a challenge, got %v", err)
+	}
+	w := httptest.NewRecorder()
+	ch.SetHeaders(nil, w)
+	if header := w.Header().Get("WWW-Authenticate"); header != `Basic realm="`+iss.URL+`"` {
+		t.Fatalf("unexpected challenge %q", header)
+	}
+
+	for _, kid := range []string{"rsa", "ec"} {
+		token := iss.sign(t, kid, iss.claims(nil))
+		ctx, err := testutil.Authorize(ac, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) })
+		if err != nil {
+			t.Fatalf("%s: expected the token to be accepted, got %v", kid, err)
+		}
+		user, _ := ctx.Value(auth.UserKey).(auth.UserInfo)
+		if user.Name != "system:serviceaccount:ci:builder" || len(user.Groups) != 2 || user.Groups[0] != "team-a" {
+			t.Fatalf("%s: unexpected user %+v", kid, user)
+		}
+	}
+
+	// Tokens are accepted as passwords
+	token := iss.sign(t, "ec", iss.claims(nil))
+	if _, err := testutil.Authorize(ac, func(r *http.Request) { r.SetBasicAuth("oidc", token) }); err != nil {
+		t.Fatalf("expected the token to be accepted as a password, got %v", err)
+	}
+
+	// The claims of a token signed for another subject
+	signed := strings.Split(iss.sign(t, "rsa", iss.claims(nil)), ".")
+	forged := strings.Split(iss.sign(t, "rsa", iss.claims(map[string]interface{}{"sub": "admin"})), ".")[1]
+	forged = signed[0] + "." + forged + "." + signed[2]
+
+	for name, token := range map[string]string{
+		"other issuer":    iss.sign(t, "rsa", iss.claims(map[string]interface{}{"iss": "https://other.example.com"})),
+		"other audience":  iss.sign(t, "rsa", iss.claims(map[string]interface{}{"aud": "other"})),
+		"expired":         iss.sign(t, "rsa", iss.claims(map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()})),
+		"no expiration":   iss.sign(t, "rsa", iss.claims(map[string]interface{}{"exp": nil})),
+		"not valid yet":   iss.sign(t, "rsa", iss.claims(map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"encryption key":  iss.sign(t, "enc", iss.claims(nil)),
+		"unknown key":     iss.sign(t, "unknown", iss.claims(nil)),
+		"no subject":      iss.sign(t, "rsa", iss.claims(map[string]interface{}{"sub": nil})),
+		"wrong signature": forged,
+		"malformed":       "not.a-token",
+	} {
+		if _, err := testutil.Authorize(ac, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) }); err == nil {
+			t.Errorf("%s: expected the token to be rejected", name)
+		}
+	}
+}
+
+func TestAccessControllerClaims(t *testing.T) {
+	iss := newIssuer(t)
+	ac, err := newAccessController(map[string]interface{}{
+		"issuer":        iss.URL,
+		"audience":      []interface{}{"other", "registry"},
+		"jwksurl":       iss.URL + "/keys",
+		"usernameclaim": "email",
+		"groupsclaim":   "roles",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := iss.sign(t, "rsa", iss.claims(map[string]interface{}{"roles": "admins"}))
+	ctx, err := testutil.Authorize(ac, func(r *http.Request) { r.Header.Set("Authorization", "Bearer "+token) })
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, _ := ctx.Value(auth.UserKey).(auth.UserInfo)
+	if user.Name != "alice@example.com" || len(user.Groups) != 1 || user.Groups[0] != "admins" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+}
+
+func TestNewAccessControllerInvalid(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{"audience": "registry"},
+		{"issuer": "https://issuer.example.com"},
+		{"issuer": "https://issuer.example.com", "audience": []interface{}{1}},
+		{"issuer": "https://issuer.example.com", "audience": "registry", "usernameclaim": ""},
+	} {
+		if _, err := newAccessController(options); err == nil {
+			t.Errorf("expected options %v to be invalid", options)
+		}
+	}
+}
+
+func TestVerifySignatureAlgorithm(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256([]byte("signed"))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verifySignature(&key.PublicKey, "RS256", "signed", signature); err != nil {
+		t.Fatal(err)
+	}
+
+	// Malformed and unsupported algorithms are rejected rather than parsed
+	for _, alg := range []string{"", "RS", "256", "none", "HS256", "XS256", "RS257", "rs256", "RS256 ", "ES256"} {
+		if err := verifySignature(&key.PublicKey, alg, "signed", signature); err == nil {
+			t.Errorf("expected algorithm %q to be rejected", alg)
+		}
+	}
+}
+
+func TestVerifySignatureCurve(t *testing.T) {
+	algorithms := []struct {
+		alg   string
+		hash  crypto.Hash
+		curve elliptic.Curve
+	}{
+		{"ES256", crypto.SHA256, elliptic.P256()},
+		{"ES384", crypto.SHA384, elliptic.P384()},
+		{"ES512", crypto.SHA512, elliptic.P521()},
+	}
+	for _, key := range algorithms {
+		privateKey, err := ecdsa.GenerateKey(key.curve, rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		size := (key.curve.Params().BitSize + 7) / 8
+		for _, signer := range algorithms {
+			// Sign with the algorithm's hash, so that only the curve differs
+			h := signer.hash.New()
+			h.Write([]byte("signed"))
+			r, s, err := ecdsa.Sign(rand.Reader, privateKey, h.Sum(nil))
+			if err != nil {
+				t.Fatal(err)
+			}
+			signature := make([]byte, 2*size)
+			r.FillBytes(signature[:size])
+			s.FillBytes(signature[size:])
+
+			err = verifySignature(&privateKey.PublicKey, signer.alg, "signed", signature)
+			if signer.alg == key.alg && err != nil {
+				t.Errorf("%s with %s key: %v", signer.alg, key.curve.Params().Name, err)
+			}
+			if signer.alg != key.alg && err == nil {
+				t.Errorf("expected %s with %s key to be rejected", signer.alg, key.curve.Params().Name)
+			}
+		}
+	}
+}
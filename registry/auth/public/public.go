@@ -0,0 +1,102 @@
+// Package public allows anonymous pulls from the repositories matching
+// patterns in front of another access controller, so that a registry can
+// serve public images, such as base images, alongside private ones which
+// still require authentication.
+package public
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// Repositories are the repositories anonymous users can pull from.
+type Repositories struct {
+	patterns []string
+}
+
+// New validates the patterns of the configuration and returns the public
+// repositories they match.
+func New(config configuration.Public) (*Repositories, error) {
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return nil, fmt.Errorf("invalid public repository pattern %q", pattern)
+		}
+	}
+	return &Repositories{patterns: config.Repositories}, nil
+}
+
+// Allowed reports whether the access is a pull from a public repository.
+func (r *Repositories) Allowed(access auth.Access) bool {
+	if access.Type != "repository" || access.Action != "pull" {
+		return false
+	}
+	for _, pattern := range r.patterns {
+		if matched, _ := path.Match(pattern, access.Name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// allowedAll reports whether the accesses are all pulls from public
+// repositories. Requests without an access, to the base route, are not.
+func (r *Repositories) allowedAll(accessRecords []auth.Access) bool {
+	if len(accessRecords) == 0 {
+		return false
+	}
+	for _, access := range accessRecords {
+		if !r.Allowed(access) {
+			return false
+		}
+	}
+	return true
+}
+
+type accessController struct {
+	auth.AccessController
+	repositories *Repositories
+}
+
+var _ auth.AccessController = &accessController{}
+
+// NewAccessController returns an access controller allowing anonymous pulls
+// from the public repositories, and authorizing the other requests with the
+// embedded access controller.
+func NewAccessController(embedded auth.AccessController, repositories *Repositories) auth.AccessController {
+	return &accessController{
+		AccessController: embedded,
+		repositories:     repositories,
+	}
+}
+
+// Authorized allows the requests without credentials which only pull from
+// public repositories, as anonymous. The other requests are left to the
+// embedded access controller, so the base route still challenges clients
+// for their credentials. Authenticated users the embedded access controller
+// denies the access are allowed to pull from public repositories too, as
+// anonymous, while invalid credentials are still rejected.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	public := ac.repositories.allowedAll(accessRecords)
+	if public {
+		req, err := dcontext.GetRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+		hasCertificate := req.TLS != nil && len(req.TLS.PeerCertificates) > 0
+		if req.Header.Get("Authorization") == "" && !hasCertificate {
+			return ctx, nil
+		}
+	}
+
+	authorized, err := ac.AccessController.Authorized(ctx, accessRecords...)
+	if err != nil && public && errors.Is(err, auth.ErrAccessDenied) {
+		return ctx, nil
+	}
+	return authorized, err
+}
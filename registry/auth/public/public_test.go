@@ -0,0 +1,94 @@
+package public
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+var errChallenge = errors.New("challenge")
+
+// embedded challenges the requests without credentials, authenticates those
+// of alice, and grants her access to the team-a repositories only.
+type embedded struct{}
+
+func (embedded) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	user, password, ok := req.BasicAuth()
+	if !ok || user != "alice" || password != "secret" {
+		return nil, errChallenge
+	}
+	for _, access := range accessRecords {
+		if access.Type != "repository" || access.Name != "team-a/app" {
+			return nil, fmt.Errorf("%w: %s", auth.ErrAccessDenied, access.Name)
+		}
+	}
+	return auth.WithUser(ctx, auth.UserInfo{Name: user}), nil
+}
+
+func repositoryAccess(name, action string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: action}
+}
+
+func TestAccessController(t *testing.T) {
+	repositories, err := New(configuration.Public{Repositories: []string{"library/*", "public/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := NewAccessController(embedded{}, repositories)
+
+	for _, tc := range []struct {
+		user, password string
+		access         []auth.Access
+		expected       error  // nil when allowed
+		authenticated  string // the user the request is authenticated as
+	}{
+		{"", "", []auth.Access{repositoryAccess("library/alpine", "pull")}, nil, ""},
+		{"", "", []auth.Access{repositoryAccess("library/alpine", "push")}, errChallenge, ""},
+		{"", "", []auth.Access{repositoryAccess("library/nested/alpine", "pull")}, errChallenge, ""},
+		{"", "", []auth.Access{repositoryAccess("team-a/app", "pull")}, errChallenge, ""},
+		// Mounting from a public repository still requires push access
+		{"", "", []auth.Access{repositoryAccess("public/app", "pull"), repositoryAccess("library/alpine", "push")}, errChallenge, ""},
+		// The base route challenges the clients for their credentials
+		{"", "", nil, errChallenge, ""},
+		{"alice", "secret", []auth.Access{repositoryAccess("team-a/app", "pull")}, nil, "alice"},
+		{"alice", "secret", []auth.Access{repositoryAccess("library/alpine", "pull")}, nil, ""},
+		{"alice", "secret", []auth.Access{repositoryAccess("library/alpine", "push")}, auth.ErrAccessDenied, ""},
+		// Invalid credentials are not allowed as anonymous
+		{"alice", "wrong", []auth.Access{repositoryAccess("library/alpine", "pull")}, errChallenge, ""},
+	} {
+		ctx, err := testutil.Authorize(ac, func(r *http.Request) {
+			if tc.user != "" {
+				r.SetBasicAuth(tc.user, tc.password)
+			}
+		}, tc.access...)
+		if !errors.Is(err, tc.expected) {
+			t.Errorf("%q %v: expected %v, got %v", tc.user, tc.access, tc.expected, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if user, _ := ctx.Value(auth.UserKey).(auth.UserInfo); user.Name != tc.authenticated {
+			t.Errorf("%q %v: expected to be authenticated as %q, got %q", tc.user, tc.access, tc.authenticated, user.Name)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, pattern := range []string{"", "library/[alpine"} {
+		if _, err := New(configuration.Public{Repositories: []string{pattern}}); err == nil {
+			t.Errorf("expected pattern %q to be rejected", pattern)
+		}
+	}
+}
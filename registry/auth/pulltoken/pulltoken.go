@@ -0,0 +1,219 @@
+// Package pulltoken mints short-lived tokens granting pull access to the
+// repositories matching patterns, and accepts them in front of another
+// access controller, so that CI jobs and edge nodes can pull without holding
+// the credentials of a user.
+//
+// Tokens are signed with a secret of the registry and hold their own
+// grants, so any replica sharing the secret accepts them until they expire.
+// They are presented as bearer tokens, or as the password of basic
+// authentication, for clients which only support credentials, such as
+// docker login or the remote of a pull through cache.
+package pulltoken
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// prefix distinguishes pull tokens from the credentials of the embedded
+// access controller.
+const prefix = "rpt_"
+
+// DefaultMaxExpiration is the longest lifetime of tokens when none is
+// configured.
+const DefaultMaxExpiration = 24 * time.Hour
+
+// Errors returned when minting or verifying tokens.
+var (
+	ErrInvalidToken = errors.New("invalid pull token")
+	ErrTokenExpired = errors.New("pull token expired")
+)
+
+// Claims are the grants of a token.
+type Claims struct {
+	// ID identifies the token in logs
+	ID string `json:"jti"`
+	// Name is the name of the user the token authenticates
+	Name string `json:"sub"`
+	// Repositories are patterns of the names of the repositories the token
+	// grants pull access to, in the syntax of path.Match
+	Repositories []string `json:"repos"`
+	// Expiration is the unix time the token expires at
+	Expiration int64 `json:"exp"`
+}
+
+// Issuer mints and verifies tokens.
+type Issuer struct {
+	key           []byte
+	maxExpiration time.Duration
+}
+
+// NewIssuer returns an issuer signing tokens with a key derived from the
+// secret, which expire after maxExpiration at most, or DefaultMaxExpiration
+// if it is zero. The key is derived so that the secret can be shared with
+// other signatures, such as those of the upload state signed with the http
+// secret, without the signatures of one being valid for the other.
+func NewIssuer(secret []byte, maxExpiration time.Duration) (*Issuer, error) {
+	if len(secret) == 0 {
+		return nil, errors.New("pull tokens require a secret")
+	}
+	if maxExpiration < 0 {
+		return nil, fmt.Errorf("invalid maximum expiration of pull tokens: %v", maxExpiration)
+	}
+	if maxExpiration == 0 {
+		maxExpiration = DefaultMaxExpiration
+	}
+	return &Issuer{key: deriveKey(secret), maxExpiration: maxExpiration}, nil
+}
+
+// deriveKey returns the key of the tokens signed by the issuer of the
+// secret, HMAC-SHA256(secret, "pulltoken").
+func deriveKey(secret []byte) []byte {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("pulltoken"))
+	return mac.Sum(nil)
+}
+
+// Mint returns a token authenticating the named user and granting pull
+// access to the repositories matching the patterns, which expires after
+// expiration, capped by the maximum expiration.
+func (is *Issuer) Mint(name string, repositories []string, expiration time.Duration) (string, Claims, error) {
+	if name == "" {
+		name = "pulltoken"
+	}
+	if len(repositories) == 0 {
+		return "", Claims{}, errors.New("repositories must be set")
+	}
+	for _, pattern := range repositories {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			return "", Claims{}, fmt.Errorf("invalid repository pattern %q", pattern)
+		}
+	}
+	if expiration <= 0 || expiration > is.maxExpiration {
+		expiration = is.maxExpiration
+	}
+
+	var id [8]byte
+	if _, err := rand.Read(id[:]); err != nil {
+		return "", Claims{}, err
+	}
+	claims := Claims{
+		ID:           hex.EncodeToString(id[:]),
+		Name:         name,
+		Repositories: repositories,
+		Expiration:   time.Now().Add(expiration).Unix(),
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		return "", Claims{}, err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(payload)
+	return prefix + signed + "." + base64.RawURLEncoding.EncodeToString(is.sign(signed)), claims, nil
+}
+
+// Verify checks the signature and expiration of a token, and returns its
+// claims.
+func (is *Issuer) Verify(token string) (Claims, error) {
+	var claims Claims
+	payload, signature, ok := strings.Cut(strings.TrimPrefix(token, prefix), ".")
+	if !ok || !strings.HasPrefix(token, prefix) {
+		return claims, ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(sig, is.sign(payload)) {
+		return claims, ErrInvalidToken
+	}
+	p, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return claims, ErrInvalidToken
+	}
+	if err := json.Unmarshal(p, &claims); err != nil {
+		return claims, ErrInvalidToken
+	}
+	if time.Now().Unix() >= claims.Expiration {
+		return claims, ErrTokenExpired
+	}
+	return claims, nil
+}
+
+func (is *Issuer) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, is.key)
+	mac.Write([]byte(payload))
+	return mac.Sum(nil)
+}
+
+// allowed reports whether the claims grant the access.
+func (c Claims) allowed(access auth.Access) bool {
+	if access.Type != "repository" || access.Action != "pull" {
+		return false
+	}
+	for _, pattern := range c.Repositories {
+		if matched, _ := path.Match(pattern, access.Name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+type accessController struct {
+	auth.AccessController
+	issuer *Issuer
+}
+
+var _ auth.AccessController = &accessController{}
+
+// NewAccessController returns an access controller accepting the tokens of
+// the issuer, and authenticating the other requests with the embedded access
+// controller.
+func NewAccessController(embedded auth.AccessController, issuer *Issuer) auth.AccessController {
+	return &accessController{
+		AccessController: embedded,
+		issuer:           issuer,
+	}
+}
+
+// Authorized allows the requests with a valid token when it grants each
+// access they require, and returns an error wrapping auth.ErrAccessDenied
+// otherwise. Requests without a token, or with an invalid one, are left to
+// the embedded access controller, which challenges them for credentials.
+func (ac *accessController) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	req, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var token string
+	if scheme, value, ok := strings.Cut(req.Header.Get("Authorization"), " "); ok && strings.EqualFold(scheme, "bearer") {
+		token = value
+	} else if _, password, ok := req.BasicAuth(); ok {
+		token = password
+	}
+	if !strings.HasPrefix(token, prefix) {
+		return ac.AccessController.Authorized(ctx, accessRecords...)
+	}
+
+	claims, err := ac.issuer.Verify(token)
+	if err != nil {
+		dcontext.GetLogger(ctx).Infof("pulltoken: rejecting token: %v", err)
+		return ac.AccessController.Authorized(ctx, accessRecords...)
+	}
+	for _, access := range accessRecords {
+		if !claims.allowed(access) {
+			return nil, fmt.Errorf("%w: %s:%s:%s for pull token %s of %q", auth.ErrAccessDenied, access.Type, access.Name, access.Action, claims.ID, claims.Name)
+		}
+	}
+	return auth.WithUser(ctx, auth.UserInfo{Name: claims.Name}), nil
+}
@@ -0,0 +1,132 @@
+package pulltoken
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+// embedded challenges every request, as an access controller without the
+// credentials of the request would.
+type embedded struct{}
+
+var errChallenge = errors.New("challenge")
+
+func (embedded) Authorized(ctx context.Context, accessRecords ...auth.Access) (context.Context, error) {
+	return nil, errChallenge
+}
+
+func pull(name string) auth.Access {
+	return auth.Access{Resource: auth.Resource{Type: "repository", Name: name}, Action: "pull"}
+}
+
+// basicAuth sets the token as the password of the basic credentials of a
+// request.
+func basicAuth(token string) func(r *http.Request) {
+	return func(r *http.Request) { r.SetBasicAuth("ci", token) }
+}
+
+func TestAccessController(t *testing.T) {
+	issuer, err := NewIssuer([]byte("secret"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac := NewAccessController(embedded{}, issuer)
+
+	token, claims, err := issuer.Mint("ci-job", []string{"team-a/*", "base"}, 10*time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Name != "ci-job" || claims.Expiration > time.Now().Add(10*time.Minute).Unix() {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	ctx, err := testutil.Authorize(ac, basicAuth(token), pull("team-a/app"), pull("base"))
+	if err != nil {
+		t.Fatalf("expected the token to grant pull access, got %v", err)
+	}
+	if user, _ := ctx.Value(auth.UserKey).(auth.UserInfo); user.Name != "ci-job" {
+		t.Fatalf("unexpected user %+v", user)
+	}
+
+	for _, access := range []auth.Access{
+		pull("team-b/app"),
+		{Resource: auth.Resource{Type: "repository", Name: "team-a/app"}, Action: "push"},
+		{Resource: auth.Resource{Type: "registry", Name: "catalog"}, Action: "*"},
+	} {
+		if _, err := testutil.Authorize(ac, basicAuth(token), access); !errors.Is(err, auth.ErrAccessDenied) {
+			t.Errorf("expected %v to be denied, got %v", access, err)
+		}
+	}
+
+	// Other credentials, and invalid tokens, are left to the embedded access
+	// controller
+	other, err := NewIssuer([]byte("other"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged, _, err := other.Mint("ci-job", []string{"*"}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, _, _ := strings.Cut(strings.TrimPrefix(forged, prefix), ".")
+	_, signature, _ := strings.Cut(token, ".")
+	for name, credential := range map[string]string{
+		"password":        "secret",
+		"other secret":    forged,
+		"wrong signature": prefix + payload + "." + signature,
+		"malformed":       prefix + "token",
+	} {
+		if _, err := testutil.Authorize(ac, basicAuth(credential), pull("team-a/app")); err != errChallenge {
+			t.Errorf("%s: expected the embedded challenge, got %v", name, err)
+		}
+	}
+}
+
+func TestMint(t *testing.T) {
+	issuer, err := NewIssuer([]byte("secret"), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The expiration is capped by the maximum
+	_, claims, err := issuer.Mint("", []string{"*"}, 48*time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Name != "pulltoken" || claims.Expiration > time.Now().Add(time.Hour).Unix() {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	for _, repositories := range [][]string{nil, {""}, {"team-a/["}} {
+		if _, _, err := issuer.Mint("ci", repositories, time.Hour); err == nil {
+			t.Errorf("expected repositories %q to be invalid", repositories)
+		}
+	}
+
+	// the tokens are not signed with the secret itself, which signs other
+	// tokens such as the upload state
+	raw := &Issuer{key: []byte("secret"), maxExpiration: time.Hour}
+	token, _, err := raw.Mint("ci", []string{"*"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.Verify(token); err != ErrInvalidToken {
+		t.Fatalf("expected a token signed with the secret to be invalid, got %v", err)
+	}
+
+	expired := &Issuer{key: deriveKey([]byte("secret")), maxExpiration: -time.Second}
+	token, _, err = expired.Mint("ci", []string{"*"}, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := issuer.Verify(token); err != ErrTokenExpired {
+		t.Fatalf("expected the token to be expired, got %v", err)
+	}
+}
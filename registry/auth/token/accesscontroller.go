@@ -79,6 +79,7 @@ type authChallenge struct {
 	err          error
 	realm        string
 	autoRedirect bool
+	localRealm   bool
 	service      string
 	accessSet    accessSet
 }
@@ -100,9 +101,12 @@ func (ac authChallenge) Status() int {
 // See https://tools.ietf.org/html/rfc6750#section-3
 func (ac authChallenge) challengeParams(r *http.Request) string {
 	var realm string
-	if ac.autoRedirect {
+	switch {
+	case ac.autoRedirect:
 		realm = fmt.Sprintf("https://%s/auth/token", r.Host)
-	} else {
+	case ac.localRealm:
+		realm = localRealm(r)
+	default:
 		realm = ac.realm
 	}
 	str := fmt.Sprintf("Bearer realm=%q,service=%q", realm, ac.service)
@@ -129,6 +133,7 @@ func (ac authChallenge) SetHeaders(r *http.Request, w http.ResponseWriter) {
 type accessController struct {
 	realm        string
 	autoRedirect bool
+	localRealm   bool
 	issuer       string
 	service      string
 	rootCerts    *x509.CertPool
@@ -238,6 +243,7 @@ func (ac *accessController) Authorized(ctx context.Context, accessItems ...auth.
 	challenge := &authChallenge{
 		realm:        ac.realm,
 		autoRedirect: ac.autoRedirect,
+		localRealm:   ac.localRealm,
 		service:      ac.service,
 		accessSet:    newAccessSet(accessItems...),
 	}
@@ -0,0 +1,401 @@
+package token
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/docker/libtrust"
+	log "github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ServerPath is the path of the token endpoint of a Server, relative to the
+// prefix of the registry.
+const ServerPath = "/auth/token"
+
+// defaultExpiration is the lifetime of the tokens issued by a Server which
+// does not configure one.
+const defaultExpiration = 5 * time.Minute
+
+// Policy reports whether a user is granted an access. Anonymous users have
+// an empty name.
+type Policy func(user string, access auth.Access) bool
+
+// Server is an access controller verifying the tokens it issues from its
+// token endpoint, so that a registry can use token authentication without
+// an external token server. Its challenges point clients at the endpoint of
+// the registry serving the request, unless a realm is configured.
+//
+// The tokens are signed with the first of the signing keys, and those signed
+// with any of them are accepted, so that a key can be rotated by adding the
+// new key first and removing the previous one once its tokens expired.
+type Server struct {
+	*accessController
+	users      map[string][]byte
+	signingKey libtrust.PrivateKey
+	signingAlg string
+	expiration time.Duration
+	policy     Policy
+}
+
+var _ auth.AccessController = &Server{}
+var _ http.Handler = &Server{}
+
+// newServer creates a Server using the given options.
+func newServer(options map[string]interface{}) (auth.AccessController, error) {
+	service, ok := options["service"].(string)
+	if !ok || service == "" {
+		return nil, fmt.Errorf("token server requires a valid option string: %q", "service")
+	}
+	issuer := service
+	if val, ok := options["issuer"]; ok {
+		if issuer, ok = val.(string); !ok || issuer == "" {
+			return nil, fmt.Errorf("token server requires a valid option string: %q", "issuer")
+		}
+	}
+	realm, _ := options["realm"].(string)
+
+	expiration := defaultExpiration
+	if val, ok := options["expiration"]; ok {
+		s, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("token server requires a valid option duration: %q", "expiration")
+		}
+		var err error
+		if expiration, err = time.ParseDuration(s); err != nil || expiration <= 0 {
+			return nil, fmt.Errorf("token server requires a valid option duration: %q", "expiration")
+		}
+	}
+
+	users, err := serverUsers(options)
+	if err != nil {
+		return nil, err
+	}
+
+	var keyFiles []string
+	if val, ok := options["signingkeys"]; ok {
+		list, ok := val.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("token server requires a valid option list: %q", "signingkeys")
+		}
+		for _, v := range list {
+			file, ok := v.(string)
+			if !ok {
+				return nil, fmt.Errorf("token server requires a valid option list: %q", "signingkeys")
+			}
+			keyFiles = append(keyFiles, file)
+		}
+	}
+	var keys []libtrust.PrivateKey
+	for _, file := range keyFiles {
+		key, err := libtrust.LoadKeyFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load token server signing key %q: %v", file, err)
+		}
+		keys = append(keys, key)
+	}
+	if len(keys) == 0 {
+		key, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			return nil, fmt.Errorf("unable to generate token server signing key: %v", err)
+		}
+		log.Warn("No token server signing keys provided - generated a random key. Tokens will not be accepted by other instances or after a restart.")
+		keys = append(keys, key)
+	}
+
+	trustedKeys := make(map[string]libtrust.PublicKey, len(keys))
+	for _, key := range keys {
+		trustedKeys[key.KeyID()] = key.PublicKey()
+	}
+	_, signingAlg, err := keys[0].Sign(strings.NewReader(""), crypto.SHA256)
+	if err != nil {
+		return nil, fmt.Errorf("unable to sign with token server signing key: %v", err)
+	}
+
+	return &Server{
+		accessController: &accessController{
+			realm:       realm,
+			localRealm:  realm == "",
+			issuer:      issuer,
+			service:     service,
+			rootCerts:   x509.NewCertPool(),
+			trustedKeys: trustedKeys,
+		},
+		users:      users,
+		signingKey: keys[0],
+		signingAlg: signingAlg,
+		expiration: expiration,
+	}, nil
+}
+
+// serverUsers returns the bcrypt password hashes of the users of the users
+// and htpasswd options.
+func serverUsers(options map[string]interface{}) (map[string][]byte, error) {
+	users := make(map[string][]byte)
+	if val, ok := options["users"]; ok {
+		switch m := val.(type) {
+		case map[interface{}]interface{}:
+			for k, v := range m {
+				user, ok1 := k.(string)
+				hash, ok2 := v.(string)
+				if !ok1 || !ok2 {
+					return nil, fmt.Errorf("token server requires a valid option map: %q", "users")
+				}
+				users[user] = []byte(hash)
+			}
+		case map[string]interface{}:
+			for user, v := range m {
+				hash, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("token server requires a valid option map: %q", "users")
+				}
+				users[user] = []byte(hash)
+			}
+		default:
+			return nil, fmt.Errorf("token server requires a valid option map: %q", "users")
+		}
+	}
+
+	if val, ok := options["htpasswd"]; ok {
+		path, ok := val.(string)
+		if !ok {
+			return nil, fmt.Errorf("token server requires a valid option string: %q", "htpasswd")
+		}
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("unable to open token server htpasswd file %q: %v", path, err)
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		for line := 1; scanner.Scan(); line++ {
+			t := strings.TrimSpace(scanner.Text())
+			if t == "" || t[0] == '#' {
+				continue
+			}
+			user, hash, ok := strings.Cut(t, ":")
+			if !ok {
+				return nil, fmt.Errorf("token server htpasswd: invalid entry at line %d", line)
+			}
+			users[user] = []byte(hash)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return users, nil
+}
+
+// SetPolicy sets the policy deciding which of the requested accesses are
+// granted. Without one, authenticated users are granted every access, and
+// anonymous users none. It must be called before the server serves
+// requests.
+func (s *Server) SetPolicy(policy Policy) {
+	s.policy = policy
+}
+
+func (s *Server) allowed(user string, access auth.Access) bool {
+	if s.policy == nil {
+		return user != ""
+	}
+	return s.policy(user, access)
+}
+
+// authenticate checks the password of a user.
+func (s *Server) authenticate(user, password string) error {
+	hash, ok := s.users[user]
+	if !ok {
+		// timing attack paranoia
+		bcrypt.CompareHashAndPassword([]byte{}, []byte(password))
+		return auth.ErrAuthenticationFailure
+	}
+	if err := bcrypt.CompareHashAndPassword(hash, []byte(password)); err != nil {
+		return auth.ErrAuthenticationFailure
+	}
+	return nil
+}
+
+// tokenResponse is the body of a successful token request.
+type tokenResponse struct {
+	Token       string `json:"token"`
+	AccessToken string `json:"access_token"`
+	ExpiresIn   int    `json:"expires_in"`
+	IssuedAt    string `json:"issued_at"`
+}
+
+// ServeHTTP serves token requests, with the user authenticated by basic
+// authentication on GET requests, and by the password grant of OAuth2 on
+// POST requests. Requests without credentials are served anonymous tokens.
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var (
+		service, user, password string
+		hasCredentials          bool
+		scopes                  []string
+	)
+	switch r.Method {
+	case http.MethodGet:
+		service = r.URL.Query().Get("service")
+		scopes = r.URL.Query()["scope"]
+		user, password, hasCredentials = r.BasicAuth()
+	case http.MethodPost:
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if grantType := r.PostForm.Get("grant_type"); grantType != "password" {
+			writeOAuthError(w, http.StatusBadRequest, "unsupported_grant_type")
+			return
+		}
+		service = r.PostForm.Get("service")
+		scopes = strings.Fields(r.PostForm.Get("scope"))
+		user, password = r.PostForm.Get("username"), r.PostForm.Get("password")
+		hasCredentials = true
+	default:
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if service != s.service {
+		http.Error(w, fmt.Sprintf("unknown service %q", service), http.StatusBadRequest)
+		return
+	}
+	if hasCredentials {
+		if err := s.authenticate(user, password); err != nil {
+			log.Infof("token server: authentication failure for user %q", user)
+			w.Header().Set("WWW-Authenticate", fmt.Sprintf("Basic realm=%q", s.service))
+			http.Error(w, "invalid username or password", http.StatusUnauthorized)
+			return
+		}
+	} else {
+		user = ""
+	}
+
+	var granted []*ResourceActions
+	for _, scope := range scopes {
+		requested, err := parseScope(scope)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		var actions []string
+		for _, action := range requested.Actions {
+			access := auth.Access{Resource: auth.Resource{Type: requested.Type, Name: requested.Name}, Action: action}
+			if s.allowed(user, access) {
+				actions = append(actions, action)
+			}
+		}
+		if len(actions) > 0 {
+			requested.Actions = actions
+			granted = append(granted, requested)
+		}
+	}
+
+	now := time.Now()
+	token, err := s.issue(user, granted, now)
+	if err != nil {
+		log.Errorf("token server: unable to issue token: %v", err)
+		http.Error(w, "unable to issue token", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(tokenResponse{
+		Token:       token,
+		AccessToken: token,
+		ExpiresIn:   int(s.expiration / time.Second),
+		IssuedAt:    now.UTC().Format(time.RFC3339),
+	})
+}
+
+func writeOAuthError(w http.ResponseWriter, status int, code string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"error": code})
+}
+
+// parseScope parses a scope of the form type:name:actions. The name may
+// contain colons, as a registry host with a port does.
+func parseScope(scope string) (*ResourceActions, error) {
+	typ, rest, ok := strings.Cut(scope, ":")
+	i := strings.LastIndex(rest, ":")
+	if !ok || i <= 0 || typ == "" {
+		return nil, fmt.Errorf("invalid scope %q", scope)
+	}
+	resource := &ResourceActions{Type: typ, Name: rest[:i]}
+	if typ, class, ok := strings.Cut(typ, "("); ok && strings.HasSuffix(class, ")") {
+		resource.Type, resource.Class = typ, strings.TrimSuffix(class, ")")
+	}
+	for _, action := range strings.Split(rest[i+1:], ",") {
+		if action != "" {
+			resource.Actions = append(resource.Actions, action)
+		}
+	}
+	return resource, nil
+}
+
+// issue returns a token granting the user the accesses, signed with the
+// first signing key.
+func (s *Server) issue(user string, access []*ResourceActions, now time.Time) (string, error) {
+	jti := make([]byte, 15)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+	if access == nil {
+		access = []*ResourceActions{}
+	}
+
+	header, err := json.Marshal(Header{
+		Type:       "JWT",
+		SigningAlg: s.signingAlg,
+		KeyID:      s.signingKey.KeyID(),
+	})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(ClaimSet{
+		Issuer:     s.issuer,
+		Subject:    user,
+		Audience:   AudienceList{s.service},
+		Expiration: now.Add(s.expiration).Unix(),
+		NotBefore:  now.Unix(),
+		IssuedAt:   now.Unix(),
+		JWTID:      base64.RawURLEncoding.EncodeToString(jti),
+		Access:     access,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	payload := joseBase64UrlEncode(header) + TokenSeparator + joseBase64UrlEncode(claims)
+	signature, _, err := s.signingKey.Sign(strings.NewReader(payload), crypto.SHA256)
+	if err != nil {
+		return "", err
+	}
+	return payload + TokenSeparator + joseBase64UrlEncode(signature), nil
+}
+
+// localRealm returns the token endpoint of the registry serving the request.
+func localRealm(r *http.Request) string {
+	base, err := v2.NewURLBuilderFromRequest(r, false).BuildBaseURL()
+	if err != nil {
+		return ServerPath
+	}
+	return strings.TrimSuffix(base, "/v2/") + ServerPath
+}
+
+func init() {
+	auth.Register("tokenserver", auth.InitFunc(newServer))
+}
@@ -0,0 +1,179 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/docker/libtrust"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func newTestServer(t *testing.T, keyFiles ...string) *Server {
+	t.Helper()
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signingKeys := make([]interface{}, 0, len(keyFiles))
+	for _, file := range keyFiles {
+		signingKeys = append(signingKeys, file)
+	}
+	ac, err := newServer(map[string]interface{}{
+		"service":     "registry.example.com",
+		"users":       map[interface{}]interface{}{"alice": string(hash)},
+		"signingkeys": signingKeys,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ac.(*Server)
+}
+
+// requestToken returns the token issued by the server for the scopes.
+func requestToken(t *testing.T, server *Server, user, password string, scopes ...string) (string, int) {
+	t.Helper()
+	query := url.Values{"service": []string{"registry.example.com"}, "scope": scopes}
+	req := httptest.NewRequest(http.MethodGet, "http://registry.example.com/auth/token?"+query.Encode(), nil)
+	if user != "" {
+		req.SetBasicAuth(user, password)
+	}
+	w := httptest.NewRecorder()
+	server.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		return "", w.Code
+	}
+	var response tokenResponse
+	if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+		t.Fatal(err)
+	}
+	if response.Token == "" || response.Token != response.AccessToken || response.ExpiresIn != 300 {
+		t.Fatalf("unexpected token response: %+v", response)
+	}
+	return response.Token, w.Code
+}
+
+// authorize authorizes a registry request with the token.
+func authorize(server *Server, token string, access ...auth.Access) (context.Context, error) {
+	req := httptest.NewRequest(http.MethodGet, "http://registry.example.com/v2/foo/bar/tags/list", nil)
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return server.Authorized(dcontext.WithRequest(context.Background(), req), access...)
+}
+
+func TestServer(t *testing.T) {
+	server := newTestServer(t)
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+	push := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "push"}
+
+	// Requests without a token are challenged to get one from the server
+	_, err := authorize(server, "", pull)
+	challenge, ok := err.(auth.Challenge)
+	if !ok {
+		t.Fatalf("expected a challenge, got %v", err)
+	}
+	w := httptest.NewRecorder()
+	challenge.SetHeaders(httptest.NewRequest(http.MethodGet, "http://registry.example.com/v2/foo/bar/tags/list", nil), w)
+	expected := `Bearer realm="http://registry.example.com/auth/token",service="registry.example.com",scope="repository:foo/bar:pull"`
+	if header := w.Header().Get("WWW-Authenticate"); header != expected {
+		t.Fatalf("expected challenge %q, got %q", expected, header)
+	}
+
+	if _, code := requestToken(t, server, "alice", "wrong", "repository:foo/bar:pull"); code != http.StatusUnauthorized {
+		t.Fatalf("expected a wrong password to be unauthorized, got %d", code)
+	}
+
+	token, _ := requestToken(t, server, "alice", "secret", "repository:foo/bar:pull")
+	ctx, err := authorize(server, token, pull)
+	if err != nil {
+		t.Fatalf("expected the token to grant pulls, got %v", err)
+	}
+	if user, _ := ctx.Value(auth.UserNameKey).(string); user != "alice" {
+		t.Fatalf("expected the token of alice, got %q", user)
+	}
+	if _, err := authorize(server, token, push); err == nil {
+		t.Fatal("expected the token not to grant pushes")
+	}
+
+	// Anonymous users are granted nothing without a policy
+	token, _ = requestToken(t, server, "", "", "repository:foo/bar:pull")
+	if _, err := authorize(server, token, pull); err == nil {
+		t.Fatal("expected anonymous tokens not to grant pulls")
+	}
+
+	server.SetPolicy(func(user string, access auth.Access) bool {
+		return access.Action == "pull" || user == "alice"
+	})
+	token, _ = requestToken(t, server, "", "", "repository:foo/bar:pull,push")
+	if _, err := authorize(server, token, pull); err != nil {
+		t.Fatalf("expected the policy to grant anonymous pulls, got %v", err)
+	}
+	if _, err := authorize(server, token, push); err == nil {
+		t.Fatal("expected the policy not to grant anonymous pushes")
+	}
+}
+
+func TestServerKeyRotation(t *testing.T) {
+	dir := t.TempDir()
+	var files []string
+	for _, name := range []string{"old.pem", "new.pem"} {
+		key, err := libtrust.GenerateECP256PrivateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		file := filepath.Join(dir, name)
+		if err := libtrust.SaveKey(file, key); err != nil {
+			t.Fatal(err)
+		}
+		files = append(files, file)
+	}
+	pull := auth.Access{Resource: auth.Resource{Type: "repository", Name: "foo/bar"}, Action: "pull"}
+
+	old := newTestServer(t, files[0])
+	token, _ := requestToken(t, old, "alice", "secret", "repository:foo/bar:pull")
+
+	// The tokens of the previous key are accepted while it is listed
+	rotated := newTestServer(t, files[1], files[0])
+	if _, err := authorize(rotated, token, pull); err != nil {
+		t.Fatalf("expected tokens of the previous key to be accepted, got %v", err)
+	}
+	rotatedToken, _ := requestToken(t, rotated, "alice", "secret", "repository:foo/bar:pull")
+	if _, err := authorize(old, rotatedToken, pull); err == nil {
+		t.Fatal("expected tokens to be signed with the new key")
+	}
+
+	if _, err := authorize(newTestServer(t, files[1]), token, pull); err == nil {
+		t.Fatal("expected tokens of a removed key to be rejected")
+	}
+}
+
+func TestParseScope(t *testing.T) {
+	for scope, expected := range map[string]ResourceActions{
+		"repository:foo/bar:pull,push":       {Type: "repository", Name: "foo/bar", Actions: []string{"pull", "push"}},
+		"repository:localhost:5000/foo:pull": {Type: "repository", Name: "localhost:5000/foo", Actions: []string{"pull"}},
+		"repository(plugin):foo/bar:pull":    {Type: "repository", Class: "plugin", Name: "foo/bar", Actions: []string{"pull"}},
+		"registry:catalog:*":                 {Type: "registry", Name: "catalog", Actions: []string{"*"}},
+	} {
+		resource, err := parseScope(scope)
+		if err != nil {
+			t.Fatalf("%s: %v", scope, err)
+		}
+		if resource.Type != expected.Type || resource.Class != expected.Class || resource.Name != expected.Name ||
+			strings.Join(resource.Actions, ",") != strings.Join(expected.Actions, ",") {
+			t.Errorf("%s: expected %+v, got %+v", scope, expected, resource)
+		}
+	}
+	for _, scope := range []string{"repository", "repository:foo", ":foo:pull"} {
+		if _, err := parseScope(scope); err == nil {
+			t.Errorf("expected scope %q to be invalid", scope)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+// Package catalog keeps an in-memory index of the names of the repositories
+// of a registry, in lexical order, which serves the catalog API without
+// walking the storage.
+package catalog
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	events "github.com/docker/go-events"
+)
+
+// change is an addition or removal of a repository, recorded while the
+// index is rebuilt so that it is applied to the rebuilt index.
+type change struct {
+	name    string
+	removed bool
+}
+
+// Index is a sink of registry events which keeps the names of the
+// repositories up to date with the pushes, cache fills and deletions of the
+// registry. It is built from the storage by Rebuild, which must be called
+// again to pick up the changes made by other instances, and is not ready
+// until it was built once.
+type Index struct {
+	registry distribution.Namespace
+
+	mu         sync.RWMutex
+	names      []string
+	ready      bool
+	rebuilding bool
+	changes    []change
+}
+
+// NewIndex returns an index of the repositories of registry, which is not
+// ready until it is rebuilt.
+func NewIndex(registry distribution.Namespace) *Index {
+	return &Index{registry: registry}
+}
+
+// Write adds the repository of a manifest push or cache event to the index,
+// and removes the repository of a repository delete event.
+func (idx *Index) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok {
+		return nil
+	}
+	switch e.Action {
+	case notifications.EventActionPush, notifications.EventActionCache:
+		if isManifest(e.Target.MediaType) {
+			idx.apply(change{name: e.Target.Repository})
+		}
+	case notifications.EventActionDelete:
+		if e.Target.Tag == "" && e.Target.Digest == "" {
+			idx.apply(change{name: e.Target.Repository, removed: true})
+		}
+	}
+	return nil
+}
+
+// Close does nothing. It is part of the events.Sink interface.
+func (idx *Index) Close() error {
+	return nil
+}
+
+func (idx *Index) apply(c change) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if idx.rebuilding {
+		idx.changes = append(idx.changes, c)
+	}
+	idx.names = applyChange(idx.names, c)
+}
+
+// applyChange inserts or removes a name of the sorted names.
+func applyChange(names []string, c change) []string {
+	i := sort.SearchStrings(names, c.name)
+	found := i < len(names) && names[i] == c.name
+	switch {
+	case c.removed && found:
+		return append(names[:i], names[i+1:]...)
+	case !c.removed && !found:
+		names = append(names, "")
+		copy(names[i+1:], names[i:])
+		names[i] = c.name
+	}
+	return names
+}
+
+// Rebuild replaces the index with the repositories found in the storage.
+// The changes notified while the storage is walked are kept.
+func (idx *Index) Rebuild(ctx context.Context) error {
+	enumerator, ok := idx.registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil
+	}
+
+	idx.mu.Lock()
+	idx.rebuilding = true
+	idx.changes = nil
+	idx.mu.Unlock()
+
+	started := time.Now()
+	var names []string
+	err := enumerator.Enumerate(ctx, func(name string) error {
+		names = append(names, name)
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// No repository was pushed yet
+		err = nil
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.rebuilding = false
+	changes := idx.changes
+	idx.changes = nil
+	if err != nil {
+		return err
+	}
+
+	sort.Strings(names)
+	for _, c := range changes {
+		names = applyChange(names, c)
+	}
+	idx.names = names
+	idx.ready = true
+	dcontext.GetLogger(ctx).Infof("catalog index rebuilt with %d repositories in %s", len(names), time.Since(started))
+	return nil
+}
+
+// Ready reports whether the index was built.
+func (idx *Index) Ready() bool {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+	return idx.ready
+}
+
+// Repositories returns up to n names of repositories starting with prefix,
+// in lexical order after last, the number of repositories starting with
+// prefix, and whether more repositories follow the returned ones.
+func (idx *Index) Repositories(prefix, last string, n int) ([]string, int, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	start := sort.SearchStrings(idx.names, prefix)
+	end := start + sort.Search(len(idx.names)-start, func(i int) bool {
+		return !strings.HasPrefix(idx.names[start+i], prefix)
+	})
+	count := end - start
+
+	from := start
+	if last >= prefix {
+		from = start + sort.Search(end-start, func(i int) bool {
+			return idx.names[start+i] > last
+		})
+	}
+	to := from + n
+	if to > end {
+		to = end
+	}
+	return append([]string{}, idx.names[from:to]...), count, to < end
+}
+
+func isManifest(mediaType string) bool {
+	for _, manifestMediaType := range distribution.ManifestMediaTypes() {
+		if mediaType == manifestMediaType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,89 @@
+package catalog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestIndex(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The storage walks a/b before a-b, although a-b sorts first
+	for _, name := range []string{"a/b", "a-b", "team/app", "team/web", "teams"} {
+		testutil.PushImage(t, testutil.Repository(t, registry, name))
+	}
+
+	index := NewIndex(registry)
+	if index.Ready() {
+		t.Fatal("expected the index not to be ready before it is built")
+	}
+	if err := index.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if !index.Ready() {
+		t.Fatal("expected the index to be ready once built")
+	}
+
+	for _, tc := range []struct {
+		prefix   string
+		last     string
+		n        int
+		expected []string
+		count    int
+		more     bool
+	}{
+		{"", "", 10, []string{"a-b", "a/b", "team/app", "team/web", "teams"}, 5, false},
+		{"", "", 2, []string{"a-b", "a/b"}, 5, true},
+		{"", "a/b", 2, []string{"team/app", "team/web"}, 5, true},
+		{"team/", "", 10, []string{"team/app", "team/web"}, 2, false},
+		{"team/", "team/app", 10, []string{"team/web"}, 2, false},
+		{"team/", "a", 1, []string{"team/app"}, 2, true},
+		{"team/", "zzz", 1, []string{}, 2, false},
+		{"missing", "", 10, []string{}, 0, false},
+	} {
+		repos, count, more := index.Repositories(tc.prefix, tc.last, tc.n)
+		if !reflect.DeepEqual(repos, tc.expected) || count != tc.count || more != tc.more {
+			t.Errorf("prefix %q, last %q, n %d: expected %v (count %d, more %v), got %v (count %d, more %v)",
+				tc.prefix, tc.last, tc.n, tc.expected, tc.count, tc.more, repos, count, more)
+		}
+	}
+
+	push := notifications.Event{Action: notifications.EventActionPush}
+	push.Target.Repository = "team/db"
+	push.Target.MediaType = v1.MediaTypeImageManifest
+	if err := index.Write(push); err != nil {
+		t.Fatal(err)
+	}
+	// Blob pushes do not create repositories in the catalog
+	push.Target.Repository = "team/blobs"
+	push.Target.MediaType = "application/octet-stream"
+	if err := index.Write(push); err != nil {
+		t.Fatal(err)
+	}
+	remove := notifications.Event{Action: notifications.EventActionDelete}
+	remove.Target.Repository = "team/web"
+	if err := index.Write(remove); err != nil {
+		t.Fatal(err)
+	}
+	// Tag deletions do not remove repositories
+	remove.Target.Repository = "team/app"
+	remove.Target.Tag = "latest"
+	if err := index.Write(remove); err != nil {
+		t.Fatal(err)
+	}
+
+	repos, count, _ := index.Repositories("team/", "", 10)
+	if expected := []string{"team/app", "team/db"}; !reflect.DeepEqual(repos, expected) || count != 2 {
+		t.Fatalf("expected %v, got %v (count %d)", expected, repos, count)
+	}
+}
@@ -0,0 +1,162 @@
+package registry
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http"
+	"os"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/distribution/distribution/v3/configuration"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// certReloadInterval bounds how often the certificate files are checked for
+// changes.
+const certReloadInterval = 10 * time.Second
+
+// acmeCachePath is the directory of the storage driver storing the ACME
+// account and certificates.
+const acmeCachePath = "/acme"
+
+// certReloader serves the certificate of a pair of files, reloading it when
+// they change, such as when cert-manager renews it, without restarting.
+type certReloader struct {
+	certFile, keyFile string
+
+	mu      sync.Mutex
+	cert    *tls.Certificate
+	loaded  time.Time // modification time of the files of cert
+	checked time.Time
+}
+
+func newCertReloader(certFile, keyFile string) (*certReloader, error) {
+	r := &certReloader{certFile: certFile, keyFile: keyFile}
+	modTime, err := r.modTime()
+	if err != nil {
+		return nil, err
+	}
+	if err := r.load(modTime); err != nil {
+		return nil, err
+	}
+	r.checked = time.Now()
+	return r, nil
+}
+
+// GetCertificate returns the certificate, reloaded if the files changed,
+// as tls.Config expects.
+func (r *certReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(r.checked) >= certReloadInterval {
+		r.checked = time.Now()
+		modTime, err := r.modTime()
+		if err == nil && !modTime.Equal(r.loaded) {
+			err = r.load(modTime)
+			if err == nil {
+				logrus.Infof("reloaded the TLS certificate %s", r.certFile)
+			}
+		}
+		if err != nil {
+			// a rotation may be in progress, so the files are checked
+			// again later
+			logrus.Errorf("error reloading the TLS certificate %s, serving the previous one: %v", r.certFile, err)
+		}
+	}
+	return r.cert, nil
+}
+
+func (r *certReloader) load(modTime time.Time) error {
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return err
+	}
+	r.cert = &cert
+	r.loaded = modTime
+	return nil
+}
+
+// modTime returns the latest modification time of the files.
+func (r *certReloader) modTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		fi, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if fi.ModTime().After(latest) {
+			latest = fi.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// letsEncryptManager returns the manager obtaining and renewing the
+// certificates of http.tls.letsencrypt, and starts answering the HTTP-01
+// challenges if configured.
+func letsEncryptManager(config *configuration.Configuration, driver storagedriver.StorageDriver) *autocert.Manager {
+	letsEncrypt := config.HTTP.TLS.LetsEncrypt
+	m := &autocert.Manager{
+		HostPolicy: autocert.HostWhitelist(letsEncrypt.Hosts...),
+		Cache:      autocert.DirCache(letsEncrypt.CacheFile),
+		Email:      letsEncrypt.Email,
+		Prompt:     autocert.AcceptTOS,
+	}
+	if letsEncrypt.Storage {
+		m.Cache = driverCache{driver: driver}
+	}
+	if letsEncrypt.DirectoryURL != "" {
+		m.Client = &acme.Client{DirectoryURL: letsEncrypt.DirectoryURL}
+	}
+
+	if letsEncrypt.HTTPAddr != "" {
+		go func(addr string) {
+			logrus.Infof("answering ACME HTTP-01 challenges on %v", addr)
+			if err := http.ListenAndServe(addr, m.HTTPHandler(nil)); err != nil {
+				logrus.Fatalf("error answering ACME HTTP-01 challenges: %v", err)
+			}
+		}(letsEncrypt.HTTPAddr)
+	}
+	return m
+}
+
+// driverCache stores the ACME account and certificates in a storage driver,
+// as an autocert.Cache.
+type driverCache struct {
+	driver storagedriver.StorageDriver
+}
+
+var _ autocert.Cache = driverCache{}
+
+// path returns the path of key, encoded as keys such as "example.com+rsa" are
+// not valid paths.
+func (c driverCache) path(key string) string {
+	return path.Join(acmeCachePath, base64.RawURLEncoding.EncodeToString([]byte(key)))
+}
+
+func (c driverCache) Get(ctx context.Context, key string) ([]byte, error) {
+	data, err := c.driver.GetContent(ctx, c.path(key))
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil, autocert.ErrCacheMiss
+	}
+	return data, err
+}
+
+func (c driverCache) Put(ctx context.Context, key string, data []byte) error {
+	return c.driver.PutContent(ctx, c.path(key), data)
+}
+
+func (c driverCache) Delete(ctx context.Context, key string) error {
+	err := c.driver.Delete(ctx, c.path(key))
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,172 @@
+package challenge
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entry records the challenges of an endpoint.
+type Entry struct {
+	Challenges []Challenge `json:"challenges"`
+
+	// Added is when the challenges were received from the endpoint
+	Added time.Time `json:"added"`
+}
+
+// Store persists the challenges of a manager, keyed by the normalized URLs
+// of their endpoints.
+type Store interface {
+	// Get returns the entry of the endpoint, and whether there is one.
+	Get(endpoint string) (Entry, bool, error)
+
+	// Put stores the entry of the endpoint.
+	Put(endpoint string, entry Entry) error
+}
+
+// NewManager returns a Manager which forgets the challenges of an endpoint
+// ttl after they were added, so that they are requested again when the
+// endpoint changes its authentication, and keeps them in store so they
+// survive restarts. The challenges never expire when ttl is zero, and are
+// kept in memory only when store is nil.
+func NewManager(ttl time.Duration, store Store) Manager {
+	return &expiringManager{
+		ttl:     ttl,
+		store:   store,
+		entries: make(map[string]Entry),
+	}
+}
+
+type expiringManager struct {
+	ttl   time.Duration
+	store Store
+
+	mu      sync.Mutex
+	entries map[string]Entry
+}
+
+func (m *expiringManager) GetChallenges(endpoint url.URL) ([]Challenge, error) {
+	normalizeURL(&endpoint)
+	key := endpoint.String()
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	entry, ok := m.entries[key]
+	if (!ok || m.expired(entry)) && m.store != nil {
+		// the store may be shared with other managers having renewed them
+		stored, found, err := m.store.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if found {
+			entry, ok = stored, true
+			m.entries[key] = entry
+		}
+	}
+	if !ok || m.expired(entry) {
+		return nil, nil
+	}
+	return entry.Challenges, nil
+}
+
+func (m *expiringManager) AddResponse(resp *http.Response) error {
+	challenges := ResponseChallenges(resp)
+	if resp.Request == nil {
+		return fmt.Errorf("missing request reference")
+	}
+	urlCopy := url.URL{
+		Path:   resp.Request.URL.Path,
+		Host:   resp.Request.URL.Host,
+		Scheme: resp.Request.URL.Scheme,
+	}
+	normalizeURL(&urlCopy)
+	key := urlCopy.String()
+	entry := Entry{Challenges: challenges, Added: time.Now()}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.entries[key] = entry
+	if m.store != nil {
+		return m.store.Put(key, entry)
+	}
+	return nil
+}
+
+func (m *expiringManager) expired(entry Entry) bool {
+	return m.ttl > 0 && time.Since(entry.Added) >= m.ttl
+}
+
+// NewFileStore returns a Store keeping the challenges in a JSON file at path,
+// which is created when the first challenges are stored.
+func NewFileStore(path string) Store {
+	return &fileStore{path: path}
+}
+
+type fileStore struct {
+	path string
+
+	mu      sync.Mutex
+	entries map[string]Entry // nil until loaded from the file
+}
+
+func (s *fileStore) Get(endpoint string) (Entry, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return Entry{}, false, err
+	}
+	entry, ok := s.entries[endpoint]
+	return entry, ok, nil
+}
+
+func (s *fileStore) Put(endpoint string, entry Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err := s.load(); err != nil {
+		return err
+	}
+	s.entries[endpoint] = entry
+
+	data, err := json.Marshal(s.entries)
+	if err != nil {
+		return err
+	}
+	// the file is replaced, so that it is never read partially written
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+func (s *fileStore) load() error {
+	if s.entries != nil {
+		return nil
+	}
+	entries := make(map[string]Entry)
+	data, err := os.ReadFile(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &entries); err != nil {
+			return fmt.Errorf("invalid challenges file %s: %v", s.path, err)
+		}
+	}
+	s.entries = entries
+	return nil
+}
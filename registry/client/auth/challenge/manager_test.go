@@ -0,0 +1,83 @@
+package challenge
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func challengeResponse(t *testing.T, endpoint, realm string) *http.Response {
+	t.Helper()
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp := &http.Response{
+		Request:    &http.Request{URL: u},
+		Header:     make(http.Header),
+		StatusCode: http.StatusUnauthorized,
+	}
+	resp.Header.Add("WWW-Authenticate", `Bearer realm="`+realm+`",service="registry.example.com"`)
+	return resp
+}
+
+func TestManagerTTL(t *testing.T) {
+	m := NewManager(time.Hour, nil)
+	endpoint := url.URL{Scheme: "https", Host: "reg.example.com", Path: "/v2/"}
+	if err := m.AddResponse(challengeResponse(t, "https://REG.example.com/v2/", "https://auth.example.com/token")); err != nil {
+		t.Fatal(err)
+	}
+	c, err := m.GetChallenges(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 1 || c[0].Parameters["realm"] != "https://auth.example.com/token" {
+		t.Fatalf("unexpected challenges %v", c)
+	}
+
+	// the challenges expire with their ttl
+	em := m.(*expiringManager)
+	for key, entry := range em.entries {
+		entry.Added = entry.Added.Add(-time.Hour)
+		em.entries[key] = entry
+	}
+	c, err = m.GetChallenges(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 0 {
+		t.Fatalf("unexpected expired challenges %v", c)
+	}
+}
+
+func TestFileStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "challenges.json")
+	endpoint := url.URL{Scheme: "https", Host: "reg.example.com:443", Path: "/v2/"}
+
+	m := NewManager(time.Hour, NewFileStore(path))
+	if err := m.AddResponse(challengeResponse(t, "https://reg.example.com/v2/", "https://auth.example.com/token")); err != nil {
+		t.Fatal(err)
+	}
+
+	// the challenges survive restarts
+	m = NewManager(time.Hour, NewFileStore(path))
+	c, err := m.GetChallenges(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 1 || c[0].Parameters["realm"] != "https://auth.example.com/token" {
+		t.Fatalf("unexpected challenges %v", c)
+	}
+
+	// the persisted challenges expire too
+	m = NewManager(time.Nanosecond, NewFileStore(path))
+	c, err = m.GetChallenges(endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(c) != 0 {
+		t.Fatalf("unexpected expired challenges %v", c)
+	}
+}
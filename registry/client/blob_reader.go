@@ -0,0 +1,66 @@
+package client
+
+import (
+	"errors"
+	"io"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+// blobReader reads a blob, verifying its digest once read sequentially to the
+// end, and reporting the progress of the download.
+type blobReader struct {
+	io.ReadSeekCloser
+	dgst     digest.Digest
+	progress ProgressFunc
+
+	// verifier is nil once the blob is read out of sequence, as its digest
+	// can't be verified then.
+	verifier digest.Verifier
+	offset   int64
+}
+
+func newBlobReader(rsc io.ReadSeekCloser, dgst digest.Digest, progress ProgressFunc) *blobReader {
+	br := &blobReader{
+		ReadSeekCloser: rsc,
+		dgst:           dgst,
+		progress:       progress,
+	}
+	if dgst.Validate() == nil {
+		br.verifier = dgst.Verifier()
+	}
+	return br
+}
+
+func (br *blobReader) Read(p []byte) (int, error) {
+	n, err := br.ReadSeekCloser.Read(p)
+	if n > 0 {
+		if br.verifier != nil {
+			br.verifier.Write(p[:n])
+		}
+		br.offset += int64(n)
+		if br.progress != nil {
+			br.progress(br.dgst, br.offset)
+		}
+	}
+	if err == io.EOF && br.verifier != nil && !br.verifier.Verified() {
+		return n, distribution.ErrBlobInvalidDigest{
+			Digest: br.dgst,
+			Reason: errors.New("content does not match digest"),
+		}
+	}
+	return n, err
+}
+
+func (br *blobReader) Seek(offset int64, whence int) (int64, error) {
+	newOffset, err := br.ReadSeekCloser.Seek(offset, whence)
+	if err != nil {
+		return newOffset, err
+	}
+	if newOffset != br.offset {
+		br.verifier = nil
+	}
+	br.offset = newOffset
+	return newOffset, nil
+}
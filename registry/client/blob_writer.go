@@ -3,12 +3,15 @@ package client
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
 )
 
 type httpBlobUpload struct {
@@ -23,6 +26,13 @@ type httpBlobUpload struct {
 	location string // always the last value of the location header.
 	offset   int64
 	closed   bool
+
+	// chunkSize is the size of the chunks uploaded, the content being sent
+	// as written if zero, and readAhead the number of chunks ReadFrom reads
+	// while a chunk is sent.
+	chunkSize int64
+	readAhead int
+	retry     RetryPolicy
 }
 
 func (hbu *httpBlobUpload) Reader() (io.ReadCloser, error) {
@@ -37,6 +47,10 @@ func (hbu *httpBlobUpload) handleErrorResponse(resp *http.Response) error {
 }
 
 func (hbu *httpBlobUpload) ReadFrom(r io.Reader) (n int64, err error) {
+	if hbu.chunkSize > 0 {
+		return hbu.readChunksFrom(r)
+	}
+
 	req, err := http.NewRequestWithContext(hbu.ctx, http.MethodPatch, hbu.location, io.NopCloser(r))
 	if err != nil {
 		return 0, err
@@ -70,6 +84,20 @@ func (hbu *httpBlobUpload) ReadFrom(r io.Reader) (n int64, err error) {
 }
 
 func (hbu *httpBlobUpload) Write(p []byte) (n int, err error) {
+	if hbu.chunkSize > 0 {
+		for n < len(p) {
+			chunk := p[n:]
+			if int64(len(chunk)) > hbu.chunkSize {
+				chunk = chunk[:hbu.chunkSize]
+			}
+			if err := hbu.writeChunk(chunk); err != nil {
+				return n, err
+			}
+			n += len(chunk)
+		}
+		return n, nil
+	}
+
 	req, err := http.NewRequestWithContext(hbu.ctx, http.MethodPatch, hbu.location, bytes.NewReader(p))
 	if err != nil {
 		return 0, err
@@ -104,37 +132,248 @@ func (hbu *httpBlobUpload) Write(p []byte) (n int, err error) {
 	return (end - start + 1), nil
 }
 
-func (hbu *httpBlobUpload) Size() int64 {
-	return hbu.offset
-}
+// readChunksFrom uploads the content of r in chunks, reading the next chunks
+// while a chunk is sent.
+func (hbu *httpBlobUpload) readChunksFrom(r io.Reader) (int64, error) {
+	ctx, cancel := context.WithCancel(hbu.ctx)
+	chunks := make(chan []byte, hbu.readAhead)
+	errc := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		for {
+			chunk := make([]byte, hbu.chunkSize)
+			n, err := io.ReadFull(r, chunk)
+			if n > 0 {
+				select {
+				case chunks <- chunk[:n]:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return
+			}
+			if err != nil {
+				errc <- err
+				return
+			}
+		}
+	}()
+	defer func() {
+		// r is not read once ReadFrom returns
+		cancel()
+		for range chunks {
+		}
+	}()
 
-func (hbu *httpBlobUpload) ID() string {
-	return hbu.uuid
+	var written int64
+	for chunk := range chunks {
+		if err := hbu.writeChunk(chunk); err != nil {
+			return written, err
+		}
+		written += int64(len(chunk))
+	}
+	select {
+	case err := <-errc:
+		return written, err
+	default:
+		return written, nil
+	}
 }
 
-func (hbu *httpBlobUpload) StartedAt() time.Time {
-	return hbu.startedAt
+// writeChunk sends p at the offset of the upload, resuming from the offset
+// reached by the registry after a connection error.
+func (hbu *httpBlobUpload) writeChunk(p []byte) error {
+	start, end := hbu.offset, hbu.offset+int64(len(p))
+	return hbu.withRetry(func(attempt int) error {
+		if attempt > 0 {
+			if err := hbu.status(); err != nil {
+				return err
+			}
+			if hbu.offset < start || hbu.offset > end {
+				return fmt.Errorf("upload resumed at offset %d, out of the chunk %d-%d", hbu.offset, start, end-1)
+			}
+			if hbu.offset == end {
+				return nil
+			}
+		}
+		return hbu.patch(p[hbu.offset-start:])
+	})
 }
 
-func (hbu *httpBlobUpload) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
-	// TODO(dmcgowan): Check if already finished, if so just fetch
-	req, err := http.NewRequestWithContext(hbu.ctx, http.MethodPut, hbu.location, nil)
+// commitContent uploads p and commits the upload in a single request,
+// resuming from the offset reached by the registry after a connection error.
+func (hbu *httpBlobUpload) commitContent(ctx context.Context, desc distribution.Descriptor, p []byte) (distribution.Descriptor, error) {
+	err := hbu.withRetry(func(attempt int) error {
+		if attempt > 0 {
+			if err := hbu.status(); err != nil {
+				return err
+			}
+			if hbu.offset > int64(len(p)) {
+				return fmt.Errorf("upload resumed at offset %d, past the end of the content at %d", hbu.offset, len(p))
+			}
+		}
+		return hbu.put(desc.Digest, p[hbu.offset:])
+	})
+	if err == distribution.ErrBlobUploadUnknown {
+		// the upload may have been committed before the connection error
+		if desc, statErr := hbu.statter.Stat(ctx, desc.Digest); statErr == nil {
+			return desc, nil
+		}
+	}
 	if err != nil {
 		return distribution.Descriptor{}, err
 	}
+	return hbu.statter.Stat(ctx, desc.Digest)
+}
+
+// withRetry calls f with the number of the attempt until it succeeds or fails
+// with an error other than a connection error, as the retry policy allows.
+func (hbu *httpBlobUpload) withRetry(f func(attempt int) error) error {
+	for attempt := 0; ; attempt++ {
+		err := f(attempt)
+		var urlErr *url.Error
+		if err == nil || attempt >= hbu.retry.Attempts || !errors.As(err, &urlErr) || hbu.ctx.Err() != nil {
+			return err
+		}
+
+		backoff := hbu.retry.Backoff << attempt
+		if backoff <= 0 {
+			continue
+		}
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-hbu.ctx.Done():
+			timer.Stop()
+			return hbu.ctx.Err()
+		}
+	}
+}
+
+// patch sends p at the offset of the upload.
+func (hbu *httpBlobUpload) patch(p []byte) error {
+	req, err := http.NewRequestWithContext(hbu.ctx, http.MethodPatch, hbu.location, bytes.NewReader(p))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Range", fmt.Sprintf("%d-%d", hbu.offset, hbu.offset+int64(len(p)-1)))
+	req.Header.Set("Content-Length", fmt.Sprintf("%d", len(p)))
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := hbu.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !SuccessStatus(resp.StatusCode) {
+		return hbu.handleErrorResponse(resp)
+	}
+	end, err := hbu.updateState(resp)
+	if err != nil {
+		return err
+	}
+	hbu.offset = end + 1
+	return nil
+}
+
+// put sends p, if any, and commits the upload as dgst.
+func (hbu *httpBlobUpload) put(dgst digest.Digest, p []byte) error {
+	var body io.Reader
+	if len(p) > 0 {
+		body = bytes.NewReader(p)
+	}
+	req, err := http.NewRequestWithContext(hbu.ctx, http.MethodPut, hbu.location, body)
+	if err != nil {
+		return err
+	}
+	if len(p) > 0 {
+		req.Header.Set("Content-Length", fmt.Sprintf("%d", len(p)))
+		req.Header.Set("Content-Type", "application/octet-stream")
+	}
 
 	values := req.URL.Query()
-	values.Set("digest", desc.Digest.String())
+	values.Set("digest", dgst.String())
 	req.URL.RawQuery = values.Encode()
 
 	resp, err := hbu.client.Do(req)
 	if err != nil {
-		return distribution.Descriptor{}, err
+		return err
+	}
+	defer resp.Body.Close()
+
+	if !SuccessStatus(resp.StatusCode) {
+		return hbu.handleErrorResponse(resp)
+	}
+	return nil
+}
+
+// status updates the offset of the upload from the registry.
+func (hbu *httpBlobUpload) status() error {
+	req, err := http.NewRequestWithContext(hbu.ctx, http.MethodGet, hbu.location, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := hbu.client.Do(req)
+	if err != nil {
+		return err
 	}
 	defer resp.Body.Close()
 
 	if !SuccessStatus(resp.StatusCode) {
-		return distribution.Descriptor{}, hbu.handleErrorResponse(resp)
+		return hbu.handleErrorResponse(resp)
+	}
+	end, err := hbu.updateState(resp)
+	if err != nil {
+		return err
+	}
+	// 0-0 is the range of both an empty upload and an upload of one byte,
+	// assumed to be the former if nothing was known to be uploaded
+	if end > 0 || hbu.offset > 0 {
+		hbu.offset = end + 1
+	}
+	return nil
+}
+
+// updateState updates the location of the upload from the response of the
+// registry to a request on it, and returns the end of the range uploaded.
+func (hbu *httpBlobUpload) updateState(resp *http.Response) (int64, error) {
+	location, err := sanitizeLocation(resp.Header.Get("Location"), hbu.location)
+	if err != nil {
+		return 0, err
+	}
+	rng := resp.Header.Get("Range")
+	var start, end int64
+	if n, err := fmt.Sscanf(rng, "%d-%d", &start, &end); err != nil {
+		return 0, err
+	} else if n != 2 || end < start {
+		return 0, fmt.Errorf("bad range format: %s", rng)
+	}
+
+	if uuid := resp.Header.Get("Docker-Upload-UUID"); uuid != "" {
+		hbu.uuid = uuid
+	}
+	hbu.location = location
+	return end, nil
+}
+
+func (hbu *httpBlobUpload) Size() int64 {
+	return hbu.offset
+}
+
+func (hbu *httpBlobUpload) ID() string {
+	return hbu.uuid
+}
+
+func (hbu *httpBlobUpload) StartedAt() time.Time {
+	return hbu.startedAt
+}
+
+func (hbu *httpBlobUpload) Commit(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
+	// TODO(dmcgowan): Check if already finished, if so just fetch
+	if err := hbu.put(desc.Digest, nil); err != nil {
+		return distribution.Descriptor{}, err
 	}
 
 	return hbu.statter.Stat(ctx, desc.Digest)
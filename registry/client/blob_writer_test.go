@@ -4,13 +4,19 @@ import (
 	"bytes"
 	"context"
 	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
 )
 
 // Test implements distribution.BlobWriter
@@ -497,3 +503,145 @@ func TestUploadWrite(t *testing.T) {
 		t.Fatalf("Unexpected response status: %s, expected %s", uploadErr.Status, expected)
 	}
 }
+
+// flakyUploadServer serves an upload, cutting the connection halfway through
+// the body of the first failures PATCH and PUT requests.
+type flakyUploadServer struct {
+	mu       sync.Mutex
+	failures int
+	content  []byte
+	blobs    map[digest.Digest]int
+	requests []string
+}
+
+func (s *flakyUploadServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method)
+
+	const location = "/v2/test/blobs/uploads/id"
+	if r.Method == http.MethodHead {
+		size, ok := s.blobs[digest.Digest(strings.TrimPrefix(r.URL.Path, "/v2/test/blobs/"))]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", fmt.Sprint(size))
+		return
+	}
+	if r.Method == http.MethodPost {
+		w.Header().Set("Location", location)
+		w.Header().Set("Docker-Upload-UUID", "id")
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
+	if r.Method == http.MethodPatch {
+		var start, end int
+		if _, err := fmt.Sscanf(r.Header.Get("Content-Range"), "%d-%d", &start, &end); err != nil || start != len(s.content) {
+			w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+			return
+		}
+	}
+	if r.Method == http.MethodPatch || r.Method == http.MethodPut {
+		body, _ := io.ReadAll(r.Body)
+		if s.failures > 0 && len(body) > 1 {
+			s.failures--
+			s.content = append(s.content, body[:len(body)/2]...)
+			panic(http.ErrAbortHandler)
+		}
+		s.content = append(s.content, body...)
+	}
+	if r.Method == http.MethodPut {
+		dgst := digest.FromBytes(s.content)
+		if r.URL.Query().Get("digest") != dgst.String() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		s.blobs[dgst] = len(s.content)
+		w.WriteHeader(http.StatusCreated)
+		return
+	}
+
+	w.Header().Set("Location", location)
+	w.Header().Set("Range", fmt.Sprintf("0-%d", len(s.content)-1))
+	if r.Method == http.MethodGet {
+		w.WriteHeader(http.StatusNoContent)
+	} else {
+		w.WriteHeader(http.StatusAccepted)
+	}
+}
+
+func TestUploadChunked(t *testing.T) {
+	dgst, b := newRandomBlob(10 << 10)
+	s := &flakyUploadServer{failures: 2, blobs: make(map[digest.Digest]int)}
+	e := httptest.NewServer(s)
+	defer e.Close()
+
+	ctx := context.Background()
+	name, _ := reference.WithName("test")
+	r, err := NewRepository(name, e.URL, nil, WithChunkedUploads(1<<10, 2), WithRetryPolicy(RetryPolicy{Attempts: 2}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	upload, err := r.Blobs(ctx).Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the chunks are read ahead from a reader
+	n, err := io.Copy(upload, io.LimitReader(bytes.NewReader(b), int64(len(b))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(len(b)) {
+		t.Fatalf("unexpected size %d uploaded, expected %d", n, len(b))
+	}
+	if _, err := upload.Commit(ctx, distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(s.content, b) {
+		t.Fatal("unexpected content uploaded")
+	}
+	// the failed requests are resumed after a status request
+	expected := 1 + 10 + 2*2 + 1 + 1
+	if len(s.requests) != expected {
+		t.Fatalf("unexpected requests %v, expected %d", s.requests, expected)
+	}
+}
+
+func TestUploadMonolithic(t *testing.T) {
+	dgst, b := newRandomBlob(1 << 10)
+	s := &flakyUploadServer{failures: 1, blobs: make(map[digest.Digest]int)}
+	e := httptest.NewServer(s)
+	defer e.Close()
+
+	ctx := context.Background()
+	name, _ := reference.WithName("test")
+	r, err := NewRepository(name, e.URL, nil, WithChunkedUploads(1<<10, 1), WithRetryPolicy(RetryPolicy{Attempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc, err := r.Blobs(ctx).Put(ctx, "application/octet-stream", b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != dgst || desc.Size != int64(len(b)) {
+		t.Fatalf("unexpected descriptor %v", desc)
+	}
+	expected := []string{http.MethodPost, http.MethodPut, http.MethodGet, http.MethodPut, http.MethodHead}
+	if fmt.Sprint(s.requests) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected requests %v, expected %v", s.requests, expected)
+	}
+
+	// the retries are limited by the retry policy
+	s = &flakyUploadServer{failures: 2, blobs: make(map[digest.Digest]int)}
+	e2 := httptest.NewServer(s)
+	defer e2.Close()
+	r, err = NewRepository(name, e2.URL, nil, WithChunkedUploads(1<<10, 1), WithRetryPolicy(RetryPolicy{Attempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Blobs(ctx).Put(ctx, "application/octet-stream", b); err == nil {
+		t.Fatal("expected an error once the retries are exhausted")
+	}
+}
@@ -0,0 +1,162 @@
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/search"
+)
+
+// Extensions calls the endpoints the registry serves beyond the distribution
+// API.
+type Extensions interface {
+	// Search fills results with the repositories matching query, in the
+	// syntax of the search endpoint, in lexical order after last. Like
+	// Repositories, it returns io.EOF when there are no more results.
+	Search(ctx context.Context, query string, results []search.Result, last string) (int, error)
+
+	// ProxyStats returns the statistics of a pull through cache.
+	ProxyStats(ctx context.Context) (ProxyStats, error)
+
+	// ProxyNamespaces returns the upstreams of a pull through cache.
+	ProxyNamespaces(ctx context.Context) ([]ProxyNamespace, error)
+}
+
+// ProxyStats reports the state of a pull through cache.
+type ProxyStats struct {
+	// Namespaces holds the statistics of each upstream host
+	Namespaces map[string]ProxyNamespaceStats `json:"namespaces"`
+
+	// SchedulerBacklog is the number of cached entries waiting for their
+	// TTL to expire
+	SchedulerBacklog int `json:"scheduler_backlog"`
+
+	// Policies holds the content cached under each artifact policy
+	Policies map[string]ProxyPolicyStats `json:"policies,omitempty"`
+}
+
+// ProxyNamespaceStats reports the cache statistics of an upstream host.
+type ProxyNamespaceStats struct {
+	Manifests ProxyCacheStats `json:"manifests"`
+	Blobs     ProxyCacheStats `json:"blobs"`
+}
+
+// ProxyCacheStats reports the cache statistics of one kind of content.
+type ProxyCacheStats struct {
+	Entries  int        `json:"entries"`
+	Bytes    int64      `json:"bytes"`
+	Hits     uint64     `json:"hits"`
+	Misses   uint64     `json:"misses"`
+	HitRatio float64    `json:"hit_ratio"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+	Newest   *time.Time `json:"newest,omitempty"`
+}
+
+// ProxyPolicyStats reports the content cached under an artifact policy.
+type ProxyPolicyStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Quota   int64 `json:"quota,omitempty"`
+}
+
+// ProxyNamespace describes an upstream registry of a pull through cache.
+type ProxyNamespace struct {
+	// Name is the upstream host
+	Name string `json:"name"`
+
+	// URL is the base URL of the upstream registry API
+	URL string `json:"url"`
+
+	// Credentials reports whether the upstream is accessed with configured
+	// credentials rather than anonymously
+	Credentials bool `json:"credentials"`
+}
+
+// NewExtensions returns the Extensions of the registry at baseURL.
+func NewExtensions(baseURL string, transport http.RoundTripper) (Extensions, error) {
+	r, err := NewRegistry(baseURL, transport)
+	if err != nil {
+		return nil, err
+	}
+	return r.(*registry), nil
+}
+
+var _ Extensions = &registry{}
+
+func (r *registry) Search(ctx context.Context, query string, results []search.Result, last string) (int, error) {
+	values := buildCatalogValues(len(results), last)
+	values.Set("q", query)
+	u, err := r.ub.BuildSearchURL(values)
+	if err != nil {
+		return 0, err
+	}
+
+	resp, err := r.get(ctx, u)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	var page struct {
+		Results []search.Result `json:"results"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+		return 0, err
+	}
+
+	n := copy(results, page.Results)
+	if resp.Header.Get("Link") == "" {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (r *registry) ProxyStats(ctx context.Context) (ProxyStats, error) {
+	var stats ProxyStats
+	err := r.getJSON(ctx, r.ub.BuildProxyStatsURL, &stats)
+	return stats, err
+}
+
+func (r *registry) ProxyNamespaces(ctx context.Context) ([]ProxyNamespace, error) {
+	var namespaces struct {
+		Namespaces []ProxyNamespace `json:"namespaces"`
+	}
+	err := r.getJSON(ctx, r.ub.BuildProxyNamespacesURL, &namespaces)
+	return namespaces.Namespaces, err
+}
+
+// getJSON decodes the response to a GET request on the URL built by
+// buildURL into v.
+func (r *registry) getJSON(ctx context.Context, buildURL func() (string, error), v interface{}) error {
+	u, err := buildURL()
+	if err != nil {
+		return err
+	}
+	resp, err := r.get(ctx, u)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// get sends a GET request on u, returning an error for an unsuccessful
+// response.
+func (r *registry) get(ctx context.Context, u string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if !SuccessStatus(resp.StatusCode) {
+		defer resp.Body.Close()
+		return nil, HandleErrorResponse(resp)
+	}
+	return resp, nil
+}
@@ -0,0 +1,125 @@
+package client
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/search"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+func TestSearch(t *testing.T) {
+	pages := [][]search.Result{
+		{{Repository: "library/alpine", Tags: []string{"latest"}}},
+		{{Repository: "library/busybox", Tags: []string{"latest", "musl"}}},
+	}
+	var m testutil.RequestResponseMap
+	for i, page := range pages {
+		body, err := json.Marshal(map[string]interface{}{"results": page})
+		if err != nil {
+			t.Fatal(err)
+		}
+		values := url.Values{"q": {"tag:latest"}, "n": {"1"}}
+		headers := http.Header{"Content-Type": {"application/json"}}
+		if i > 0 {
+			values.Set("last", pages[i-1][0].Repository)
+		}
+		if i < len(pages)-1 {
+			headers.Set("Link", `</v2/_search?last=library%2Falpine&n=1&q=tag%3Alatest>; rel="next"`)
+		}
+		m = append(m, testutil.RequestResponseMapping{
+			Request: testutil.Request{
+				Method:      http.MethodGet,
+				Route:       "/v2/_search",
+				QueryParams: values,
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Body:       body,
+				Headers:    headers,
+			},
+		})
+	}
+
+	e, c := testServer(m)
+	defer c()
+
+	ctx := context.Background()
+	ext, err := NewExtensions(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results := make([]search.Result, 1)
+	var all []search.Result
+	last := ""
+	for {
+		n, err := ext.Search(ctx, "tag:latest", results, last)
+		all = append(all, results[:n]...)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		last = results[n-1].Repository
+	}
+	if expected := append(pages[0], pages[1]...); !reflect.DeepEqual(all, expected) {
+		t.Fatalf("unexpected results %v, expected %v", all, expected)
+	}
+}
+
+func TestProxyStats(t *testing.T) {
+	stats := ProxyStats{
+		Namespaces: map[string]ProxyNamespaceStats{
+			"docker.io": {Blobs: ProxyCacheStats{Entries: 2, Bytes: 1024, Hits: 3, Misses: 1, HitRatio: 0.75}},
+		},
+		SchedulerBacklog: 2,
+	}
+	statsBody, err := json.Marshal(stats)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := []ProxyNamespace{{Name: "docker.io", URL: "https://registry-1.docker.io", Credentials: true}}
+	namespacesBody, err := json.Marshal(map[string]interface{}{"namespaces": namespaces})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := testutil.RequestResponseMap{
+		{
+			Request:  testutil.Request{Method: http.MethodGet, Route: "/v2/_proxy/stats"},
+			Response: testutil.Response{StatusCode: http.StatusOK, Body: statsBody},
+		},
+		{
+			Request:  testutil.Request{Method: http.MethodGet, Route: "/v2/_proxy/namespaces"},
+			Response: testutil.Response{StatusCode: http.StatusOK, Body: namespacesBody},
+		},
+	}
+	e, c := testServer(m)
+	defer c()
+
+	ctx := context.Background()
+	ext, err := NewExtensions(e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := ext.ProxyStats(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s, stats) {
+		t.Fatalf("unexpected stats %v, expected %v", s, stats)
+	}
+	ns, err := ext.ProxyNamespaces(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(ns, namespaces) {
+		t.Fatalf("unexpected namespaces %v, expected %v", ns, namespaces)
+	}
+}
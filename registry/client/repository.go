@@ -133,38 +133,159 @@ func (r *registry) Repositories(ctx context.Context, entries []string, last stri
 }
 
 // NewRepository creates a new Repository for the given repository name and base URL.
-func NewRepository(name reference.Named, baseURL string, transport http.RoundTripper) (distribution.Repository, error) {
+func NewRepository(name reference.Named, baseURL string, transport http.RoundTripper, options ...RepositoryOption) (distribution.Repository, error) {
 	ub, err := v2.NewURLBuilderFromString(baseURL, false)
 	if err != nil {
 		return nil, err
 	}
 
-	return &repository{
+	r := &repository{
 		client: &http.Client{
 			Transport:     transport,
 			CheckRedirect: checkHTTPRedirect,
 			// TODO(dmcgowan): create cookie jar
 		},
-		ub:   ub,
-		name: name,
-	}, nil
+		ub:    ub,
+		name:  name,
+		retry: DefaultRetryPolicy,
+	}
+	for _, option := range options {
+		option(r)
+	}
+	return r, nil
+}
+
+// RepositoryOption configures a repository returned by NewRepository.
+type RepositoryOption func(*repository)
+
+// RetryPolicy configures the resumption of the blob downloads interrupted by
+// a connection error, with a Range request from the offset reached.
+type RetryPolicy struct {
+	// Attempts is the maximum number of resumptions of a download, zero
+	// disabling them.
+	Attempts int
+	// Backoff is the wait before the first resumption, doubled before each
+	// next one.
+	Backoff time.Duration
+}
+
+// DefaultRetryPolicy is the retry policy of the repositories configured
+// without WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	Attempts: 5,
+	Backoff:  500 * time.Millisecond,
+}
+
+// WithRetryPolicy sets the policy resuming the interrupted blob downloads.
+func WithRetryPolicy(policy RetryPolicy) RepositoryOption {
+	return func(r *repository) {
+		r.retry = policy
+	}
+}
+
+// WithChunkedUploads uploads the blobs in chunks of size bytes, each resumed
+// from the offset reached by the registry after a connection error as the
+// retry policy allows. When a blob is written with ReadFrom, such as with
+// io.Copy, the next readAhead chunks are read while a chunk is sent, the
+// chunks being sent in order as the registry API requires. Blobs put whole no
+// larger than size are uploaded monolithically, in a single request.
+func WithChunkedUploads(size int64, readAhead int) RepositoryOption {
+	return func(r *repository) {
+		r.chunkSize = size
+		r.readAhead = readAhead
+	}
+}
+
+// ProgressFunc is called as a blob is downloaded, with the number of bytes
+// read so far.
+type ProgressFunc func(dgst digest.Digest, read int64)
+
+// WithProgress sets the function called as blobs are downloaded.
+func WithProgress(progress ProgressFunc) RepositoryOption {
+	return func(r *repository) {
+		r.progress = progress
+	}
 }
 
 type repository struct {
-	client *http.Client
-	ub     *v2.URLBuilder
-	name   reference.Named
+	client   *http.Client
+	ub       *v2.URLBuilder
+	name     reference.Named
+	retry    RetryPolicy
+	progress ProgressFunc
+
+	chunkSize int64
+	readAhead int
 }
 
 func (r *repository) Named() reference.Named {
 	return r.name
 }
 
+// Referrers lists the manifests referring to the subject manifest using the
+// referrers API. If the registry does not apply the artifactType filter, the
+// results are filtered locally.
+func (r *repository) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	ref, err := reference.WithDigest(r.name, subject)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []url.Values
+	if artifactType != "" {
+		values = append(values, url.Values{"artifactType": []string{artifactType}})
+	}
+
+	u, err := r.ub.BuildReferrersURL(ref, values...)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !SuccessStatus(resp.StatusCode) {
+		return nil, HandleErrorResponse(resp)
+	}
+
+	var index struct {
+		Manifests []distribution.Descriptor `json:"manifests"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+		return nil, err
+	}
+
+	if artifactType == "" || resp.Header.Get("OCI-Filters-Applied") == "artifactType" {
+		return index.Manifests, nil
+	}
+
+	referrers := make([]distribution.Descriptor, 0, len(index.Manifests))
+	for _, desc := range index.Manifests {
+		if desc.ArtifactType == artifactType {
+			referrers = append(referrers, desc)
+		}
+	}
+	return referrers, nil
+}
+
 func (r *repository) Blobs(ctx context.Context) distribution.BlobStore {
 	return &blobs{
-		name:   r.name,
-		ub:     r.ub,
-		client: r.client,
+		name:     r.name,
+		ub:       r.ub,
+		client:   r.client,
+		retry:    r.retry,
+		progress: r.progress,
+
+		chunkSize: r.chunkSize,
+		readAhead: r.readAhead,
 		statter: cache.NewCachedBlobStatter(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize), &blobStatter{
 			name:   r.name,
 			ub:     r.ub,
@@ -384,6 +505,43 @@ func (t *tags) Untag(ctx context.Context, tag string) error {
 	return HandleErrorResponse(resp)
 }
 
+var _ distribution.TagHistoryProvider = &tags{}
+
+// History returns the changes of the manifest the tag points to, from the
+// most recent one, using the tag history endpoint of the registry.
+func (t *tags) History(ctx context.Context, tag string) ([]distribution.TagHistoryEntry, error) {
+	ref, err := reference.WithTag(t.name, tag)
+	if err != nil {
+		return nil, err
+	}
+	u, err := t.ub.BuildTagHistoryURL(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if !SuccessStatus(resp.StatusCode) {
+		return nil, HandleErrorResponse(resp)
+	}
+
+	var history struct {
+		History []distribution.TagHistoryEntry `json:"history"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		return nil, err
+	}
+	return history.History, nil
+}
+
 type manifests struct {
 	name   reference.Named
 	ub     *v2.URLBuilder
@@ -638,9 +796,14 @@ func (ms *manifests) Delete(ctx context.Context, dgst digest.Digest) error {
 }*/
 
 type blobs struct {
-	name   reference.Named
-	ub     *v2.URLBuilder
-	client *http.Client
+	name     reference.Named
+	ub       *v2.URLBuilder
+	client   *http.Client
+	retry    RetryPolicy
+	progress ProgressFunc
+
+	chunkSize int64
+	readAhead int
 
 	statter distribution.BlobDescriptorService
 	distribution.BlobDeleter
@@ -684,12 +847,13 @@ func (bs *blobs) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekClose
 		return nil, err
 	}
 
-	return transport.NewHTTPReadSeeker(ctx, bs.client, blobURL, func(resp *http.Response) error {
+	rsc := transport.NewHTTPReadSeeker(ctx, bs.client, blobURL, func(resp *http.Response) error {
 		if resp.StatusCode == http.StatusNotFound {
 			return distribution.ErrBlobUnknown
 		}
 		return HandleErrorResponse(resp)
-	}), nil
+	}, transport.WithResume(bs.retry.Attempts, bs.retry.Backoff))
+	return newBlobReader(rsc, dgst, bs.progress), nil
 }
 
 func (bs *blobs) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -722,6 +886,14 @@ func (bs *blobs) Put(ctx context.Context, mediaType string, p []byte) (distribut
 	if err != nil {
 		return distribution.Descriptor{}, err
 	}
+	if bs.chunkSize > 0 && int64(len(p)) <= bs.chunkSize {
+		desc := distribution.Descriptor{
+			MediaType: mediaType,
+			Size:      int64(len(p)),
+			Digest:    digest.FromBytes(p),
+		}
+		return writer.(*httpBlobUpload).commitContent(ctx, desc, p)
+	}
 	dgstr := digest.Canonical.Digester()
 	n, err := io.Copy(writer, io.TeeReader(bytes.NewReader(p), dgstr.Hash()))
 	if err != nil {
@@ -824,6 +996,9 @@ func (bs *blobs) Create(ctx context.Context, options ...distribution.BlobCreateO
 			uuid:      uuid,
 			startedAt: time.Now(),
 			location:  location,
+			chunkSize: bs.chunkSize,
+			readAhead: bs.readAhead,
+			retry:     bs.retry,
 		}, nil
 	default:
 		return nil, HandleErrorResponse(resp)
@@ -843,6 +1018,9 @@ func (bs *blobs) Resume(ctx context.Context, id string) (distribution.BlobWriter
 		uuid:      id,
 		startedAt: time.Now(),
 		location:  location,
+		chunkSize: bs.chunkSize,
+		readAhead: bs.readAhead,
+		retry:     bs.retry,
 	}, nil
 }
 
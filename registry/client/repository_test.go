@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"crypto/rand"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
@@ -347,6 +348,37 @@ func TestBlobFetch(t *testing.T) {
 	// TODO(dmcgowan): Test for unknown blob case
 }
 
+func TestBlobFetchVerify(t *testing.T) {
+	d1, b1 := newRandomBlob(1024)
+	_, b2 := newRandomBlob(1024)
+	var m testutil.RequestResponseMap
+	addTestFetch("test.example.com/repo1", d1, b2, &m)
+
+	e, c := testServer(m)
+	defer c()
+
+	ctx := context.Background()
+	repo, _ := reference.WithName("test.example.com/repo1")
+	var read int64
+	r, err := NewRepository(repo, e, nil, WithProgress(func(dgst digest.Digest, n int64) {
+		if dgst != d1 {
+			t.Errorf("unexpected digest %s in progress", dgst)
+		}
+		read = n
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = r.Blobs(ctx).Get(ctx, d1)
+	if !errors.As(err, &distribution.ErrBlobInvalidDigest{}) {
+		t.Fatalf("unexpected error fetching a blob not matching its digest: %v", err)
+	}
+	if read != int64(len(b1)) {
+		t.Fatalf("unexpected progress %d, expected %d", read, len(b1))
+	}
+}
+
 func TestBlobExistsNoContentLength(t *testing.T) {
 	var m testutil.RequestResponseMap
 
@@ -1421,6 +1453,77 @@ func TestManifestTags(t *testing.T) {
 	// TODO(dmcgowan): Check for error cases
 }
 
+func TestReferrers(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo")
+	subject := digest.FromString("subject")
+	index := []byte(strings.TrimSpace(`
+{
+	"schemaVersion": 2,
+	"mediaType": "application/vnd.oci.image.index.v1+json",
+	"manifests": [
+		{
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest": "sha256:3b3692957d439ac1928219a83fac91e7bf96c153725526874673ae1f2023f8d5",
+			"size": 42,
+			"artifactType": "application/vnd.example.signature"
+		},
+		{
+			"mediaType": "application/vnd.oci.image.manifest.v1+json",
+			"digest": "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855",
+			"size": 43,
+			"artifactType": "application/vnd.example.sbom"
+		}
+	]
+}
+	`))
+	var m testutil.RequestResponseMap
+	for _, query := range []map[string][]string{nil, {"artifactType": {"application/vnd.example.sbom"}}} {
+		m = append(m, testutil.RequestResponseMapping{
+			Request: testutil.Request{
+				Method:      http.MethodGet,
+				Route:       "/v2/" + repo.Name() + "/referrers/" + subject.String(),
+				QueryParams: query,
+			},
+			Response: testutil.Response{
+				StatusCode: http.StatusOK,
+				Body:       index,
+				Headers: http.Header(map[string][]string{
+					"Content-Length": {fmt.Sprint(len(index))},
+					"Content-Type":   {"application/vnd.oci.image.index.v1+json"},
+				}),
+			},
+		})
+	}
+	e, c := testServer(m)
+	defer c()
+
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	referrers, err := r.(distribution.ReferrerService).Referrers(ctx, subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 2 {
+		t.Fatalf("Wrong number of referrers returned: %d, expected 2", len(referrers))
+	}
+	if referrers[0].ArtifactType != "application/vnd.example.signature" || referrers[0].Size != 42 {
+		t.Fatalf("unexpected referrer: %#v", referrers[0])
+	}
+
+	// The server does not apply the filter, so it is applied by the client
+	referrers, err = r.(distribution.ReferrerService).Referrers(ctx, subject, "application/vnd.example.sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].ArtifactType != "application/vnd.example.sbom" {
+		t.Fatalf("unexpected filtered referrers: %#v", referrers)
+	}
+}
+
 func TestTagDelete(t *testing.T) {
 	tag := "latest"
 	repo, _ := reference.WithName("test.example.com/repo/delete")
@@ -1532,6 +1635,54 @@ func TestObtainsManifestForTagWithoutHeaders(t *testing.T) {
 	}
 }
 
+func TestTagHistory(t *testing.T) {
+	repo, _ := reference.WithName("test.example.com/repo")
+	d1, _ := newRandomBlob(64)
+	d2, _ := newRandomBlob(64)
+	history := []distribution.TagHistoryEntry{
+		{Digest: d2, Previous: d1, Timestamp: time.Date(2023, 2, 1, 0, 0, 0, 0, time.UTC)},
+		{Digest: d1, Timestamp: time.Date(2023, 1, 1, 0, 0, 0, 0, time.UTC)},
+	}
+	body, err := json.Marshal(map[string]interface{}{"name": repo.Name(), "tag": "latest", "history": history})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var m testutil.RequestResponseMap
+	m = append(m, testutil.RequestResponseMapping{
+		Request: testutil.Request{
+			Method: http.MethodGet,
+			Route:  "/v2/" + repo.Name() + "/_tags/latest/history",
+		},
+		Response: testutil.Response{
+			StatusCode: http.StatusOK,
+			Body:       body,
+			Headers: http.Header(map[string][]string{
+				"Content-Type": {"application/json"},
+			}),
+		},
+	})
+	e, c := testServer(m)
+	defer c()
+
+	ctx := context.Background()
+	r, err := NewRepository(repo, e, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := r.Tags(ctx).(distribution.TagHistoryProvider).History(ctx, "latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(h, history) {
+		t.Fatalf("unexpected history %v, expected %v", h, history)
+	}
+	if _, err := r.Tags(ctx).(distribution.TagHistoryProvider).History(ctx, "missing"); err == nil {
+		t.Fatal("expected an error for a missing tag")
+	}
+}
+
 func TestManifestTagsPaginated(t *testing.T) {
 	s := httptest.NewServer(http.NotFoundHandler())
 	defer s.Close()
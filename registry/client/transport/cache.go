@@ -0,0 +1,301 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// maxCachedBodySize bounds the size of the bodies of the responses
+	// cached.
+	maxCachedBodySize = 64 << 10
+
+	// maxCacheEntries bounds the number of responses cached.
+	maxCacheEntries = 1024
+)
+
+// ResponseCache stores the responses of the GET requests sent through cache
+// transports, for as long as their Cache-Control or Expires headers allow,
+// as a private cache following RFC 7234. It is meant for small responses
+// requested repeatedly, such as the pings of the API version and of the auth
+// challenges, rather than for content. Responses without explicit freshness
+// are not stored.
+type ResponseCache struct {
+	mu      sync.Mutex
+	entries map[string][]*cacheEntry // keyed by URL, with their variants
+	count   int
+}
+
+// NewResponseCache returns an empty response cache.
+func NewResponseCache() *ResponseCache {
+	return &ResponseCache{entries: make(map[string][]*cacheEntry)}
+}
+
+type cacheEntry struct {
+	status int
+	header http.Header
+	body   []byte
+	// vary holds the request headers selecting the variant, named by the
+	// Vary header along with Authorization, which a response may depend on
+	// without naming it
+	vary     http.Header
+	received time.Time
+	age      time.Duration // initial age of the response
+	expires  time.Time
+}
+
+// NewCacheTransport returns a transport serving the responses stored in
+// cache, and storing those of base, which may differ for transports sharing
+// a cache. It returns base if cache is nil.
+func NewCacheTransport(base http.RoundTripper, cache *ResponseCache) http.RoundTripper {
+	if cache == nil {
+		return base
+	}
+	return &cacheTransport{base: base, cache: cache}
+}
+
+type cacheTransport struct {
+	base  http.RoundTripper
+	cache *ResponseCache
+}
+
+func (t *cacheTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	key := req.URL.String()
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		// unsafe methods invalidate the responses of the URL
+		t.cache.invalidate(key)
+		return t.roundTripper().RoundTrip(req)
+	}
+	if req.Method == http.MethodHead {
+		return t.roundTripper().RoundTrip(req)
+	}
+
+	reqDirectives := cacheControl(req.Header)
+	_, noCache := reqDirectives["no-cache"]
+	if maxAge, ok := reqDirectives["max-age"]; ok && maxAge == "0" {
+		noCache = true
+	}
+	if strings.EqualFold(req.Header.Get("Pragma"), "no-cache") {
+		noCache = true
+	}
+	if !noCache {
+		if resp := t.cache.lookup(key, req); resp != nil {
+			return resp, nil
+		}
+	}
+
+	resp, err := t.roundTripper().RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	if _, noStore := reqDirectives["no-store"]; noStore {
+		return resp, nil
+	}
+	return t.cache.store(key, req, resp)
+}
+
+func (t *cacheTransport) roundTripper() http.RoundTripper {
+	if t.base != nil {
+		return t.base
+	}
+	return http.DefaultTransport
+}
+
+// lookup returns the fresh response stored for req, if any.
+func (c *ResponseCache) lookup(key string, req *http.Request) *http.Response {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	var found *cacheEntry
+	entries := c.entries[key][:0]
+	for _, entry := range c.entries[key] {
+		if !now.Before(entry.expires) {
+			c.count--
+			continue
+		}
+		entries = append(entries, entry)
+		if found == nil && entry.matches(req) {
+			found = entry
+		}
+	}
+	if len(entries) == 0 {
+		delete(c.entries, key)
+	} else {
+		c.entries[key] = entries
+	}
+	if found == nil {
+		return nil
+	}
+
+	header := found.header.Clone()
+	age := found.age + now.Sub(found.received)
+	header.Set("Age", strconv.FormatInt(int64(age/time.Second), 10))
+	return &http.Response{
+		Status:        strconv.Itoa(found.status) + " " + http.StatusText(found.status),
+		StatusCode:    found.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(bytes.NewReader(found.body)),
+		ContentLength: int64(len(found.body)),
+		Request:       req,
+	}
+}
+
+// store stores resp, if cacheable, and returns it to be read again.
+func (c *ResponseCache) store(key string, req *http.Request, resp *http.Response) (*http.Response, error) {
+	lifetime, ok := freshnessLifetime(resp)
+	if !ok {
+		return resp, nil
+	}
+	var age time.Duration
+	if seconds, err := strconv.ParseInt(resp.Header.Get("Age"), 10, 64); err == nil && seconds > 0 {
+		age = time.Duration(seconds) * time.Second
+	}
+	if age >= lifetime {
+		return resp, nil
+	}
+
+	// the body is read to be stored, and replaced for the caller
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCachedBodySize+1))
+	if err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	if len(body) > maxCachedBodySize {
+		resp.Body = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(body), resp.Body), resp.Body}
+		return resp, nil
+	}
+	resp.Body.Close()
+	resp.Body = io.NopCloser(bytes.NewReader(body))
+
+	now := time.Now()
+	entry := &cacheEntry{
+		status:   resp.StatusCode,
+		header:   resp.Header.Clone(),
+		body:     body,
+		vary:     make(http.Header),
+		received: now,
+		age:      age,
+		expires:  now.Add(lifetime - age),
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		entry.vary[name] = req.Header.Values(name)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries := c.entries[key][:0]
+	for _, existing := range c.entries[key] {
+		if existing.matches(req) {
+			c.count--
+			continue
+		}
+		entries = append(entries, existing)
+	}
+	if c.count >= maxCacheEntries {
+		c.entries[key] = entries
+		return resp, nil
+	}
+	c.entries[key] = append(entries, entry)
+	c.count++
+	return resp, nil
+}
+
+// invalidate removes the responses stored for key.
+func (c *ResponseCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.count -= len(c.entries[key])
+	delete(c.entries, key)
+}
+
+// matches reports whether the entry is the variant selected by req.
+func (e *cacheEntry) matches(req *http.Request) bool {
+	for name, values := range e.vary {
+		if strings.Join(req.Header.Values(name), ",") != strings.Join(values, ",") {
+			return false
+		}
+	}
+	return true
+}
+
+// freshnessLifetime returns how long resp may be served from a private
+// cache, if it may be stored at all.
+func freshnessLifetime(resp *http.Response) (time.Duration, bool) {
+	directives := cacheControl(resp.Header)
+	if _, ok := directives["no-store"]; ok {
+		return 0, false
+	}
+	if _, ok := directives["no-cache"]; ok {
+		return 0, false
+	}
+	for _, name := range varyHeaders(resp.Header) {
+		if name == "*" {
+			return 0, false
+		}
+	}
+
+	if maxAge, ok := directives["max-age"]; ok {
+		seconds, err := strconv.ParseInt(maxAge, 10, 64)
+		if err != nil || seconds <= 0 {
+			return 0, false
+		}
+		return time.Duration(seconds) * time.Second, true
+	}
+
+	if expiresHeader := resp.Header.Get("Expires"); expiresHeader != "" {
+		expires, err := http.ParseTime(expiresHeader)
+		if err != nil {
+			return 0, false
+		}
+		date, err := http.ParseTime(resp.Header.Get("Date"))
+		if err != nil {
+			date = time.Now()
+		}
+		if lifetime := expires.Sub(date); lifetime > 0 {
+			return lifetime, true
+		}
+	}
+	return 0, false
+}
+
+// cacheControl returns the directives of the Cache-Control header, with
+// their argument if any.
+func cacheControl(header http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name == "" {
+				continue
+			}
+			directives[strings.ToLower(name)] = strings.Trim(arg, `"`)
+		}
+	}
+	return directives
+}
+
+// varyHeaders returns the canonical names of the request headers selecting
+// the variant of a response.
+func varyHeaders(header http.Header) []string {
+	names := []string{"Authorization"}
+	for _, value := range header.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
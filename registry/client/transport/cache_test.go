@@ -0,0 +1,101 @@
+package transport
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCacheTransport(t *testing.T) {
+	t.Parallel()
+
+	var requests int
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		switch r.URL.Path {
+		case "/v2/":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("WWW-Authenticate", `Bearer realm="https://auth.example.com/token"`)
+			w.WriteHeader(http.StatusUnauthorized)
+		case "/token":
+			w.Header().Set("Cache-Control", "private, max-age=60")
+			w.Header().Set("Vary", "Accept")
+			_, _ = io.WriteString(w, r.Header.Get("Authorization")+r.Header.Get("Accept"))
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store, max-age=60")
+		default:
+			// without explicit freshness
+		}
+	}))
+	defer s.Close()
+
+	client := &http.Client{Transport: NewCacheTransport(nil, NewResponseCache())}
+	get := func(path string, header http.Header) (*http.Response, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, s.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for name, values := range header {
+			req.Header[name] = values
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, string(body)
+	}
+
+	for _, tc := range []struct {
+		path     string
+		header   http.Header
+		body     string
+		requests int
+	}{
+		{path: "/v2/", requests: 1},
+		{path: "/v2/", requests: 1},
+		{path: "/v2/", header: http.Header{"Cache-Control": {"no-cache"}}, requests: 2},
+		{path: "/token", header: http.Header{"Authorization": {"Basic a"}}, body: "Basic a", requests: 3},
+		{path: "/token", header: http.Header{"Authorization": {"Basic a"}}, body: "Basic a", requests: 3},
+		// responses vary with the credentials and the headers named by Vary
+		{path: "/token", header: http.Header{"Authorization": {"Basic b"}}, body: "Basic b", requests: 4},
+		{path: "/token", header: http.Header{"Authorization": {"Basic a"}, "Accept": {"text/plain"}}, body: "Basic atext/plain", requests: 5},
+		{path: "/token", header: http.Header{"Authorization": {"Basic a"}}, body: "Basic a", requests: 5},
+		{path: "/no-store", requests: 6},
+		{path: "/no-store", requests: 7},
+		{path: "/other", requests: 8},
+		{path: "/other", requests: 9},
+	} {
+		resp, body := get(tc.path, tc.header)
+		if body != tc.body {
+			t.Errorf("GET %s %v: unexpected body %q, expected %q", tc.path, tc.header, body, tc.body)
+		}
+		if requests != tc.requests {
+			t.Fatalf("GET %s %v: unexpected number of requests %d, expected %d", tc.path, tc.header, requests, tc.requests)
+		}
+		if tc.path == "/v2/" && (resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "") {
+			t.Fatalf("unexpected cached response %d %v", resp.StatusCode, resp.Header)
+		}
+	}
+
+	// unsafe methods invalidate the responses of the URL
+	req, err := http.NewRequest(http.MethodDelete, s.URL+"/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	get("/v2/", nil)
+	if requests != 11 {
+		t.Fatalf("unexpected number of requests %d after invalidation, expected 11", requests)
+	}
+}
@@ -8,9 +8,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/klauspost/compress/zstd"
@@ -35,13 +37,32 @@ type ReadSeekCloser = io.ReadSeekCloser
 // the a "Range" header will be added which sets the offset.
 //
 // TODO(dmcgowan): Move this into a separate utility package
-func NewHTTPReadSeeker(ctx context.Context, client *http.Client, url string, errorHandler func(*http.Response) error) *HTTPReadSeeker {
-	return &HTTPReadSeeker{
+func NewHTTPReadSeeker(ctx context.Context, client *http.Client, url string, errorHandler func(*http.Response) error, options ...ReadSeekerOption) *HTTPReadSeeker {
+	hrs := &HTTPReadSeeker{
 		ctx:          ctx,
 		client:       client,
 		url:          url,
 		errorHandler: errorHandler,
 	}
+	for _, option := range options {
+		option(hrs)
+	}
+	return hrs
+}
+
+// ReadSeekerOption configures an HTTPReadSeeker.
+type ReadSeekerOption func(*HTTPReadSeeker)
+
+// WithResume resumes the reads interrupted by a connection error, such as a
+// connection reset, with a Range request from the offset reached, up to
+// attempts times. The first attempt waits for backoff, each next one twice
+// as long as the previous one. Reads of content encoded by the server, such
+// as with gzip, are not resumed.
+func WithResume(attempts int, backoff time.Duration) ReadSeekerOption {
+	return func(hrs *HTTPReadSeeker) {
+		hrs.resumeAttempts = attempts
+		hrs.resumeBackoff = backoff
+	}
 }
 
 // HTTPReadSeeker implements an [io.ReadSeekCloser].
@@ -68,6 +89,15 @@ type HTTPReadSeeker struct {
 	// beginning).
 	seekOffset int64
 	err        error
+
+	// resumeAttempts and resumeBackoff configure the resumption of the
+	// interrupted reads, resumed is the number of resumptions so far.
+	resumeAttempts int
+	resumeBackoff  time.Duration
+	resumed        int
+	// encoded is whether the content is encoded by the server, so that
+	// its offsets can't be requested.
+	encoded bool
 }
 
 func (hrs *HTTPReadSeeker) Read(p []byte) (n int, err error) {
@@ -87,16 +117,55 @@ func (hrs *HTTPReadSeeker) Read(p []byte) (n int, err error) {
 
 	hrs.readerOffset = hrs.seekOffset
 
-	rd, err := hrs.reader()
-	if err != nil {
-		return 0, err
+	resuming := false
+	for {
+		rd, err := hrs.reader()
+		if err != nil {
+			var urlErr *url.Error
+			if !resuming || !errors.As(err, &urlErr) || !hrs.resumable() {
+				return 0, err
+			}
+		} else {
+			n, err = rd.Read(p)
+			hrs.seekOffset += int64(n)
+			hrs.readerOffset += int64(n)
+			if err == nil || err == io.EOF || !hrs.resumable() {
+				return n, err
+			}
+			// the next request resumes from the offset reached
+			hrs.reset()
+			if n > 0 {
+				return n, nil
+			}
+		}
+
+		resuming = true
+		if err := hrs.waitResume(); err != nil {
+			return 0, err
+		}
 	}
+}
 
-	n, err = rd.Read(p)
-	hrs.seekOffset += int64(n)
-	hrs.readerOffset += int64(n)
+// resumable reports whether an interrupted read can be resumed.
+func (hrs *HTTPReadSeeker) resumable() bool {
+	return hrs.resumed < hrs.resumeAttempts && !hrs.encoded && hrs.ctx.Err() == nil
+}
 
-	return n, err
+// waitResume waits for the backoff of the next resumption.
+func (hrs *HTTPReadSeeker) waitResume() error {
+	backoff := hrs.resumeBackoff << hrs.resumed
+	hrs.resumed++
+	if backoff <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(backoff)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-hrs.ctx.Done():
+		return hrs.ctx.Err()
+	}
 }
 
 func (hrs *HTTPReadSeeker) Seek(offset int64, whence int) (int64, error) {
@@ -252,6 +321,7 @@ func (hrs *HTTPReadSeeker) reader() (io.Reader, error) {
 		encoding := strings.FieldsFunc(resp.Header.Get("Content-Encoding"), func(r rune) bool {
 			return unicode.IsSpace(r) || r == ','
 		})
+		hrs.encoded = len(encoding) > 0
 		for i := len(encoding) - 1; i >= 0; i-- {
 			algorithm := strings.ToLower(encoding[i])
 			switch algorithm {
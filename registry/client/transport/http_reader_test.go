@@ -11,6 +11,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"net/url"
+	"strconv"
 	"testing"
 
 	"github.com/klauspost/compress/zstd"
@@ -146,3 +147,59 @@ func TestContentEncoding(t *testing.T) {
 		})
 	}
 }
+
+func TestResume(t *testing.T) {
+	t.Parallel()
+
+	content := make([]byte, 64<<10)
+	rand.New(rand.NewSource(1)).Read(content)
+
+	// the connection is cut halfway through the first two responses
+	var requests, ranges []string
+	s := httptest.NewServer(http.HandlerFunc(func(rw http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		ranges = append(ranges, r.Header.Get("Range"))
+
+		var start int
+		if rng := r.Header.Get("Range"); rng != "" {
+			var err error
+			start, err = strconv.Atoi(rng[len("bytes=") : len(rng)-1])
+			if err != nil {
+				t.Errorf("unexpected range %q", rng)
+			}
+			rw.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, len(content)-1, len(content)))
+			rw.Header().Set("Content-Length", strconv.Itoa(len(content)-start))
+			rw.WriteHeader(http.StatusPartialContent)
+		} else {
+			rw.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		}
+
+		remaining := content[start:]
+		if len(requests) <= 2 {
+			_, _ = rw.Write(remaining[:len(remaining)/2])
+			rw.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}
+		_, _ = rw.Write(remaining)
+	}))
+	defer s.Close()
+
+	rs := NewHTTPReadSeeker(context.Background(), s.Client(), s.URL, nil)
+	if _, err := io.ReadAll(rs); err == nil {
+		t.Fatal("expected an error reading without resumption")
+	}
+
+	requests, ranges = nil, nil
+	rs = NewHTTPReadSeeker(context.Background(), s.Client(), s.URL, nil, WithResume(2, 0))
+	b, err := io.ReadAll(rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(b, content) {
+		t.Fatal("unexpected content")
+	}
+	expected := []string{"", fmt.Sprintf("bytes=%d-", len(content)/2), fmt.Sprintf("bytes=%d-", len(content)*3/4)}
+	if fmt.Sprint(ranges) != fmt.Sprint(expected) {
+		t.Fatalf("unexpected ranges %q, expected %q", ranges, expected)
+	}
+}
@@ -0,0 +1,66 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+// drainReportInterval is the interval at which the progress of draining the
+// requests in flight is logged on shutdown.
+const drainReportInterval = 5 * time.Second
+
+// drainTracker counts the requests in flight, to report the progress of
+// draining them on shutdown.
+type drainTracker struct {
+	inflight int64
+}
+
+// handler counts the requests of handler while they are served.
+func (d *drainTracker) handler(handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&d.inflight, 1)
+		defer atomic.AddInt64(&d.inflight, -1)
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// inflightRequests returns the number of requests in flight.
+func (d *drainTracker) inflightRequests() int64 {
+	return atomic.LoadInt64(&d.inflight)
+}
+
+// drain gracefully shuts the servers of the registry down, waiting for the
+// requests in flight, such as uploads and downloads, until ctx is done, and
+// logging their number meanwhile. The requests still in flight then are
+// interrupted.
+func (registry *Registry) drain(ctx context.Context) error {
+	logger := dcontext.GetLogger(registry.app)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(drainReportInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				logger.Infof("draining: %d requests in flight", registry.drainTracker.inflightRequests())
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	err := registry.server.Shutdown(ctx)
+	registry.shutdownListeners(ctx)
+	if err != nil {
+		logger.Warnf("drain timed out, interrupting %d requests in flight", registry.drainTracker.inflightRequests())
+		registry.server.Close()
+		return err
+	}
+	logger.Info("drained all the requests")
+	return nil
+}
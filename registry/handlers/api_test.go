@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"compress/gzip"
 	"context"
 	"encoding/json"
 	"errors"
@@ -13,28 +14,39 @@ import (
 	"net/url"
 	"os"
 	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/configuration"
 	"github.com/distribution/distribution/v3/manifest"
 	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
 	"github.com/distribution/distribution/v3/manifest/schema1" //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
 	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth/token"
+	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/replication"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/distribution/distribution/v3/testutil"
 	"github.com/docker/libtrust"
 	"github.com/gorilla/handlers"
+	"github.com/klauspost/compress/zstd"
 	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var headerConfig = http.Header{
@@ -817,6 +829,109 @@ func TestBlobDeleteDisabled(t *testing.T) {
 	checkResponse(t, "status of disabled delete", resp, http.StatusMethodNotAllowed)
 }
 
+func TestBlobCompressionAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	config.HTTP.Compression.Encodings = []string{"zstd", "gzip"}
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	pushBlob := func(content []byte) string {
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+		ref, _ := reference.WithDigest(imageName, dgst)
+		blobURL, err := env.builder.BuildBlobURL(ref)
+		checkErr(t, err, "building blob url")
+		return blobURL
+	}
+	getBlob := func(blobURL string, header http.Header) (*http.Response, []byte) {
+		req, err := http.NewRequest(http.MethodGet, blobURL, nil)
+		checkErr(t, err, "building blob request")
+		req.Header = header
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "fetching blob")
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		checkErr(t, err, "reading blob")
+		return resp, body
+	}
+
+	content := bytes.Repeat([]byte("uncompressed layer content "), 1000)
+	blobURL := pushBlob(content)
+
+	for _, tc := range []struct {
+		acceptEncoding string
+		encoding       string
+	}{
+		{"zstd, gzip", "zstd"},
+		{"gzip", "gzip"},
+		{"gzip;q=1, zstd;q=0.5", "gzip"},
+		{"*", "zstd"},
+		{"zstd;q=0, br", ""},
+		{"", ""},
+	} {
+		header := http.Header{}
+		if tc.acceptEncoding != "" {
+			header.Set("Accept-Encoding", tc.acceptEncoding)
+		}
+		resp, body := getBlob(blobURL, header)
+		checkResponse(t, "fetching blob with Accept-Encoding "+tc.acceptEncoding, resp, http.StatusOK)
+		if encoding := resp.Header.Get("Content-Encoding"); encoding != tc.encoding {
+			t.Fatalf("unexpected encoding %q for Accept-Encoding %q", encoding, tc.acceptEncoding)
+		}
+
+		var r io.Reader = bytes.NewReader(body)
+		switch tc.encoding {
+		case "zstd":
+			zr, err := zstd.NewReader(r)
+			checkErr(t, err, "decompressing blob")
+			defer zr.Close()
+			r = zr
+		case "gzip":
+			gr, err := gzip.NewReader(r)
+			checkErr(t, err, "decompressing blob")
+			r = gr
+		}
+		decompressed, err := io.ReadAll(r)
+		checkErr(t, err, "decompressing blob")
+		if !bytes.Equal(decompressed, content) {
+			t.Fatalf("unexpected content for Accept-Encoding %q", tc.acceptEncoding)
+		}
+		if tc.encoding != "" && len(body) >= len(content) {
+			t.Fatalf("%s blob of %d bytes not smaller than %d bytes", tc.encoding, len(body), len(content))
+		}
+	}
+
+	// Ranges are served uncompressed
+	resp, body := getBlob(blobURL, http.Header{"Accept-Encoding": []string{"zstd"}, "Range": []string{"bytes=0-9"}})
+	checkResponse(t, "fetching blob range", resp, http.StatusPartialContent)
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, content[:10]) {
+		t.Fatalf("unexpected blob range %q encoded with %q", body, resp.Header.Get("Content-Encoding"))
+	}
+
+	// Compressed and small blobs are served as they are
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	gw.Write(content)
+	gw.Close()
+	for _, blob := range [][]byte{buf.Bytes(), []byte("small")} {
+		resp, body := getBlob(pushBlob(blob), http.Header{"Accept-Encoding": []string{"zstd"}})
+		checkResponse(t, "fetching blob", resp, http.StatusOK)
+		if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, blob) {
+			t.Fatalf("unexpected blob encoded with %q", resp.Header.Get("Content-Encoding"))
+		}
+	}
+}
+
 func testBlobAPI(t *testing.T, env *testEnv, args blobArgs) *testEnv {
 	// TODO(stevvooe): This test code is complete junk but it should cover the
 	// complete flow. This must be broken down and checked against the
@@ -1124,103 +1239,1450 @@ func testBlobDelete(t *testing.T, env *testEnv, args blobArgs) {
 	uploadURLBase, _ := startPushLayer(t, env, imageName)
 	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
 
-	layerFile.Seek(0, io.SeekStart)
-	canonicalDigester := digest.Canonical.Digester()
-	if _, err := io.Copy(canonicalDigester.Hash(), layerFile); err != nil {
-		t.Fatalf("error copying to digest: %v", err)
+	layerFile.Seek(0, io.SeekStart)
+	canonicalDigester := digest.Canonical.Digester()
+	if _, err := io.Copy(canonicalDigester.Hash(), layerFile); err != nil {
+		t.Fatalf("error copying to digest: %v", err)
+	}
+	canonicalDigest := canonicalDigester.Digest()
+
+	// ------------------------
+	// Use a head request to see if it exists
+	resp, err = http.Head(layerURL)
+	if err != nil {
+		t.Fatalf("unexpected error checking head on existing layer: %v", err)
+	}
+
+	layerLength, _ := layerFile.Seek(0, io.SeekEnd)
+	checkResponse(t, "checking head on reuploaded layer", resp, http.StatusOK)
+	checkHeaders(t, resp, http.Header{
+		"Content-Length":        []string{fmt.Sprint(layerLength)},
+		"Docker-Content-Digest": []string{canonicalDigest.String()},
+	})
+}
+
+func TestDeleteDisabled(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	// "build" our layer file
+	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer file: %v", err)
+	}
+
+	ref, _ := reference.WithDigest(imageName, layerDigest)
+	layerURL, err := env.builder.BuildBlobURL(ref)
+	if err != nil {
+		t.Fatalf("Error building blob URL")
+	}
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
+
+	resp, err := httpDelete(layerURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting layer: %v", err)
+	}
+
+	checkResponse(t, "deleting layer with delete disabled", resp, http.StatusMethodNotAllowed)
+}
+
+func TestDeleteReadOnly(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	// "build" our layer file
+	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
+	if err != nil {
+		t.Fatalf("error creating random layer file: %v", err)
+	}
+
+	ref, _ := reference.WithDigest(imageName, layerDigest)
+	layerURL, err := env.builder.BuildBlobURL(ref)
+	if err != nil {
+		t.Fatalf("Error building blob URL")
+	}
+	uploadURLBase, _ := startPushLayer(t, env, imageName)
+	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
+
+	env.app.SetReadOnly(true)
+
+	resp, err := httpDelete(layerURL)
+	if err != nil {
+		t.Fatalf("unexpected error deleting layer: %v", err)
+	}
+
+	checkResponse(t, "deleting layer in read-only mode", resp, http.StatusMethodNotAllowed)
+}
+
+func TestStartPushReadOnly(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+	env.app.SetReadOnly(true)
+
+	imageName, _ := reference.WithName("foo/bar")
+
+	layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	if err != nil {
+		t.Fatalf("unexpected error building layer upload url: %v", err)
+	}
+
+	resp, err := http.Post(layerUploadURL, "", nil)
+	if err != nil {
+		t.Fatalf("unexpected error starting layer push: %v", err)
+	}
+	defer resp.Body.Close()
+
+	checkResponse(t, "starting push in read-only mode", resp, http.StatusMethodNotAllowed)
+}
+
+func TestReadOnlyAPI(t *testing.T) {
+	env := newTestEnvWithConfig(t, withAdminListener(testEnvConfig(true)))
+	defer env.Shutdown()
+
+	readOnlyURL, err := env.builder.BuildAdminReadOnlyURL()
+	checkErr(t, err, "building read-only url")
+
+	putReadOnly := func(body string, expectedStatus int) readOnlyAPIResponse {
+		req, err := http.NewRequest(http.MethodPut, readOnlyURL, strings.NewReader(body))
+		checkErr(t, err, "creating read-only request")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "setting read-only mode")
+		defer resp.Body.Close()
+		checkResponse(t, "setting read-only mode", resp, expectedStatus)
+
+		var mode readOnlyAPIResponse
+		if expectedStatus == http.StatusOK {
+			if err := json.NewDecoder(resp.Body).Decode(&mode); err != nil {
+				t.Fatalf("error decoding read-only mode: %v", err)
+			}
+		}
+		return mode
+	}
+	startPush := func(name string, expectedStatus int) {
+		named, _ := reference.WithName(name)
+		uploadURL, err := env.builder.BuildBlobUploadURL(named)
+		checkErr(t, err, "building layer upload url")
+		resp, err := http.Post(uploadURL, "", nil)
+		checkErr(t, err, "starting layer push")
+		resp.Body.Close()
+		checkResponse(t, "starting push to "+name, resp, expectedStatus)
+	}
+
+	resp, err := http.Get(readOnlyURL)
+	checkErr(t, err, "fetching read-only mode")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching read-only mode", resp, http.StatusOK)
+	var mode readOnlyAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&mode); err != nil {
+		t.Fatalf("error decoding read-only mode: %v", err)
+	}
+	if mode.Enabled || len(mode.Repositories) != 0 || mode.Degraded {
+		t.Fatalf("unexpected read-only mode: %+v", mode)
+	}
+
+	// Only the matching repositories are read-only
+	mode = putReadOnly(`{"repositories": ["foo/*"]}`, http.StatusOK)
+	if mode.Enabled || !reflect.DeepEqual(mode.Repositories, []string{"foo/*"}) {
+		t.Fatalf("unexpected read-only mode: %+v", mode)
+	}
+	startPush("foo/bar", http.StatusMethodNotAllowed)
+	startPush("other/bar", http.StatusAccepted)
+
+	mode = putReadOnly(`{"enabled": true}`, http.StatusOK)
+	if !mode.Enabled || len(mode.Repositories) != 0 {
+		t.Fatalf("unexpected read-only mode: %+v", mode)
+	}
+	startPush("other/bar", http.StatusMethodNotAllowed)
+
+	putReadOnly(`{"repositories": ["["]}`, http.StatusBadRequest)
+	putReadOnly(`{"enabled": false}`, http.StatusOK)
+	startPush("foo/bar", http.StatusAccepted)
+}
+
+func TestAdminAPIWithoutAuth(t *testing.T) {
+	env := newTestEnv(t, true)
+	defer env.Shutdown()
+
+	// without authentication, the admin endpoints are only served by the
+	// admin listener
+	readOnlyURL, err := env.builder.BuildAdminReadOnlyURL()
+	checkErr(t, err, "building read-only url")
+	req, err := http.NewRequest(http.MethodPut, readOnlyURL, strings.NewReader(`{"enabled": true}`))
+	checkErr(t, err, "creating request")
+	resp, err := http.DefaultClient.Do(req)
+	checkErr(t, err, "switching to read-only mode")
+	resp.Body.Close()
+	checkResponse(t, "switching to read-only mode without authentication", resp, http.StatusNotFound)
+	if env.app.isReadOnly() {
+		t.Fatal("expected the registry not to be read-only")
+	}
+}
+
+func TestUploadPurgingAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled":  true,
+				"age":      "1ns",
+				"interval": "24h",
+				"dryrun":   false,
+				"repositories": []interface{}{
+					map[interface{}]interface{}{"pattern": "keep/*", "age": "168h"},
+				},
+			}},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, withAdminListener(&config))
+	defer env.Shutdown()
+
+	purgeURL, err := env.builder.BuildAdminUploadPurgingURL()
+	checkErr(t, err, "building upload purging url")
+
+	purge := func(dryRun bool) uploadPurgingAPIResponse {
+		u := purgeURL
+		if dryRun {
+			u += "?dryrun=true"
+		}
+		resp, err := http.Post(u, "", nil)
+		checkErr(t, err, "purging uploads")
+		defer resp.Body.Close()
+		checkResponse(t, "purging uploads", resp, http.StatusOK)
+
+		var result uploadPurgingAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+			t.Fatalf("error decoding purge result: %v", err)
+		}
+		if result.DryRun != dryRun || len(result.Errors) != 0 {
+			t.Fatalf("unexpected purge result: %+v", result)
+		}
+		return result
+	}
+
+	for _, name := range []string{"foo/bar", "keep/bar"} {
+		named, _ := reference.WithName(name)
+		startPushLayer(t, env, named)
+	}
+
+	// Only the upload of the repository without a rule is old enough
+	if result := purge(true); len(result.Deleted) != 1 || !strings.Contains(result.Deleted[0], "foo/bar") {
+		t.Fatalf("unexpected dry run result: %+v", result)
+	}
+	if result := purge(false); len(result.Deleted) != 1 {
+		t.Fatalf("unexpected purge result: %+v", result)
+	}
+	if result := purge(false); len(result.Deleted) != 0 {
+		t.Fatalf("unexpected purge result after purging: %+v", result)
+	}
+}
+
+func TestTrashAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"delete": configuration.Parameters{
+				"enabled": true,
+				"trash": map[interface{}]interface{}{
+					"enabled":   true,
+					"retention": "24h",
+				},
+			},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, withAdminListener(&config))
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/bar", "latest")
+	imageName, _ := reference.WithName("foo/bar")
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	tagURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building tag url")
+
+	resp, err := httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+	resp, err = http.Get(tagURL)
+	checkErr(t, err, "fetching deleted manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching deleted manifest", resp, http.StatusNotFound)
+
+	trashURL, err := env.builder.BuildAdminTrashURL()
+	checkErr(t, err, "building trash url")
+	resp, err = http.Get(trashURL)
+	checkErr(t, err, "listing the trash")
+	defer resp.Body.Close()
+	checkResponse(t, "listing the trash", resp, http.StatusOK)
+	var trash trashAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&trash); err != nil {
+		t.Fatalf("error decoding the trash: %v", err)
+	}
+	if len(trash.Manifests) != 1 {
+		t.Fatalf("unexpected trash: %+v", trash)
+	}
+	trashed := trash.Manifests[0]
+	if trashed.Repository != "foo/bar" || trashed.Digest != dgst || !reflect.DeepEqual(trashed.Tags, []string{"latest"}) {
+		t.Fatalf("unexpected trashed manifest: %+v", trashed)
+	}
+	if trashed.ExpiresAt.Sub(trashed.DeletedAt) != 24*time.Hour {
+		t.Fatalf("unexpected expiry of trashed manifest: %+v", trashed)
+	}
+
+	manifestTrashURL, err := env.builder.BuildAdminTrashURL(url.Values{
+		"repository": []string{"foo/bar"},
+		"digest":     []string{dgst.String()},
+	})
+	checkErr(t, err, "building trash url")
+	invalidTrashURL, err := env.builder.BuildAdminTrashURL(url.Values{"repository": []string{"foo/bar"}})
+	checkErr(t, err, "building trash url")
+
+	resp, err = http.Post(invalidTrashURL, "", nil)
+	checkErr(t, err, "restoring manifest")
+	resp.Body.Close()
+	checkResponse(t, "restoring manifest without a digest", resp, http.StatusBadRequest)
+
+	resp, err = http.Post(manifestTrashURL, "", nil)
+	checkErr(t, err, "restoring manifest")
+	resp.Body.Close()
+	checkResponse(t, "restoring manifest", resp, http.StatusOK)
+	resp, err = http.Get(tagURL)
+	checkErr(t, err, "fetching restored manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching restored manifest", resp, http.StatusOK)
+
+	resp, err = http.Post(manifestTrashURL, "", nil)
+	checkErr(t, err, "restoring manifest")
+	resp.Body.Close()
+	checkResponse(t, "restoring manifest twice", resp, http.StatusNotFound)
+
+	resp, err = httpDelete(manifestURL)
+	checkErr(t, err, "deleting manifest")
+	resp.Body.Close()
+	checkResponse(t, "deleting manifest", resp, http.StatusAccepted)
+	resp, err = httpDelete(manifestTrashURL)
+	checkErr(t, err, "purging manifest")
+	resp.Body.Close()
+	checkResponse(t, "purging manifest", resp, http.StatusAccepted)
+	resp, err = httpDelete(manifestTrashURL)
+	checkErr(t, err, "purging manifest")
+	resp.Body.Close()
+	checkResponse(t, "purging manifest twice", resp, http.StatusNotFound)
+}
+
+func TestCatalogAPIPrefix(t *testing.T) {
+	for _, indexed := range []bool{false, true} {
+		env := newTestEnv(t, false)
+		if indexed {
+			config := env.config
+			env.Shutdown()
+			config.Catalog.Index.Enabled = true
+			env = newTestEnvWithConfig(t, &config)
+		}
+
+		for _, image := range []string{"foo/aaaa", "foo/bbbb", "foo/cccc", "foo-bar", "other"} {
+			createRepository(env, t, image, "sometag")
+		}
+
+		catalogURL, err := env.builder.BuildCatalogURL(url.Values{"prefix": []string{"foo/"}, "n": []string{"2"}})
+		checkErr(t, err, "building catalog url")
+		resp, err := http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		checkResponse(t, "fetching catalog with prefix", resp, http.StatusOK)
+
+		var ctlg catalogAPIResponse
+		err = json.NewDecoder(resp.Body).Decode(&ctlg)
+		resp.Body.Close()
+		checkErr(t, err, "decoding catalog")
+		if expected := []string{"foo/aaaa", "foo/bbbb"}; !reflect.DeepEqual(ctlg.Repositories, expected) {
+			t.Fatalf("indexed %v: expected %v, got %v", indexed, expected, ctlg.Repositories)
+		}
+		if indexed != (ctlg.Count != nil) {
+			t.Fatalf("indexed %v: unexpected count %v", indexed, ctlg.Count)
+		}
+		if indexed && *ctlg.Count != 3 {
+			t.Fatalf("expected a count of 3 repositories, got %d", *ctlg.Count)
+		}
+
+		link := resp.Header.Get("Link")
+		if !strings.Contains(link, "prefix=foo%2F") || !strings.Contains(link, "last=foo%2Fbbbb") {
+			t.Fatalf("indexed %v: expected a link to the next page keeping the prefix, got %q", indexed, link)
+		}
+		catalogURL, err = env.builder.BuildCatalogURL(url.Values{"prefix": []string{"foo/"}, "n": []string{"2"}, "last": []string{"foo/bbbb"}})
+		checkErr(t, err, "building catalog url")
+		resp, err = http.Get(catalogURL)
+		checkErr(t, err, "fetching catalog")
+		err = json.NewDecoder(resp.Body).Decode(&ctlg)
+		resp.Body.Close()
+		checkErr(t, err, "decoding catalog")
+		if expected := []string{"foo/cccc"}; !reflect.DeepEqual(ctlg.Repositories, expected) || resp.Header.Get("Link") != "" {
+			t.Fatalf("indexed %v: expected the last page to be %v, got %v", indexed, expected, ctlg.Repositories)
+		}
+
+		env.Shutdown()
+	}
+}
+
+func TestSearchAPI(t *testing.T) {
+	env := newTestEnv(t, false)
+	defer env.Shutdown()
+
+	// Search is not available unless it is enabled
+	searchURL, err := env.builder.BuildSearchURL(url.Values{"q": []string{"foo"}})
+	checkErr(t, err, "building search url")
+	resp, err := http.Get(searchURL)
+	checkErr(t, err, "searching")
+	resp.Body.Close()
+	checkResponse(t, "searching a registry without search", resp, http.StatusMethodNotAllowed)
+
+	config := env.config
+	config.Search.Enabled = true
+	env = newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	createRepository(env, t, "foo/bar", "v1")
+	createRepository(env, t, "foo/baz", "latest")
+	createRepository(env, t, "other", "v1")
+
+	// The pushes are indexed in the background
+	searchURL, err = env.builder.BuildSearchURL(url.Values{"q": []string{"foo"}, "n": []string{"1"}})
+	checkErr(t, err, "building search url")
+	var results searchAPIResponse
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(searchURL)
+		checkErr(t, err, "searching")
+		checkResponse(t, "searching", resp, http.StatusOK)
+		err = json.NewDecoder(resp.Body).Decode(&results)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error decoding search results: %v", err)
+		}
+		if len(results.Results) > 0 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatal("pushed repositories were not indexed")
+		}
+	}
+	if len(results.Results) != 1 || results.Results[0].Repository != "foo/bar" || len(results.Results[0].Tags) != 1 || results.Results[0].Tags[0] != "v1" {
+		t.Fatalf("unexpected search results: %+v", results)
+	}
+
+	resp, err = http.Get(searchURL)
+	checkErr(t, err, "searching")
+	resp.Body.Close()
+	if link := resp.Header.Get("Link"); !strings.Contains(link, "q=foo") || !strings.Contains(link, "last=foo%2Fbar") {
+		t.Fatalf("expected a link to the next page keeping the query, got %q", link)
+	}
+}
+
+func TestACLAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		ACL: configuration.ACL{
+			Rules: []configuration.ACLRule{
+				{Repositories: []string{"public/*"}, Users: []string{"*"}, Actions: []string{"pull"}},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("public/base")
+	uploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+	resp, err := http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing to a pull only repository", resp, http.StatusForbidden)
+	checkBodyHasErrorCodes(t, "pushing to a pull only repository", resp, errcode.ErrorCodeDenied)
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+	resp, err = http.Get(tagsURL)
+	checkErr(t, err, "listing tags")
+	defer resp.Body.Close()
+	checkResponse(t, "listing the tags of a pull only repository", resp, http.StatusNotFound)
+
+	otherName, _ := reference.WithName("private/base")
+	tagsURL, err = env.builder.BuildTagsURL(otherName)
+	checkErr(t, err, "building tags url")
+	resp, err = http.Get(tagsURL)
+	checkErr(t, err, "listing tags")
+	defer resp.Body.Close()
+	checkResponse(t, "listing the tags of a repository without rules", resp, http.StatusForbidden)
+}
+
+func TestPublicRepositoriesAPI(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	checkErr(t, err, "hashing password")
+	htpasswdPath := filepath.Join(t.TempDir(), "htpasswd")
+	err = os.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\n"), 0o600)
+	checkErr(t, err, "writing htpasswd file")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"htpasswd": {
+				"realm": "registry-test",
+				"path":  htpasswdPath,
+			},
+		},
+		Public: configuration.Public{
+			Repositories: []string{"public/*"},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	baseURL, err := env.builder.BuildBaseURL()
+	checkErr(t, err, "building base url")
+	resp, err := http.Get(baseURL)
+	checkErr(t, err, "getting base url")
+	defer resp.Body.Close()
+	checkResponse(t, "getting the base url anonymously", resp, http.StatusUnauthorized)
+
+	imageName, _ := reference.WithName("public/base")
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+	resp, err = http.Get(tagsURL)
+	checkErr(t, err, "listing tags")
+	defer resp.Body.Close()
+	checkResponse(t, "listing the tags of a public repository anonymously", resp, http.StatusNotFound)
+
+	uploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+	resp, err = http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing to a public repository anonymously", resp, http.StatusUnauthorized)
+
+	req, err := http.NewRequest(http.MethodPost, uploadURL, nil)
+	checkErr(t, err, "creating upload request")
+	req.SetBasicAuth("alice", "secret")
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "starting upload")
+	defer resp.Body.Close()
+	checkResponse(t, "pushing to a public repository", resp, http.StatusAccepted)
+
+	otherName, _ := reference.WithName("private/base")
+	tagsURL, err = env.builder.BuildTagsURL(otherName)
+	checkErr(t, err, "building tags url")
+	resp, err = http.Get(tagsURL)
+	checkErr(t, err, "listing tags")
+	defer resp.Body.Close()
+	checkResponse(t, "listing the tags of a private repository anonymously", resp, http.StatusUnauthorized)
+}
+
+func TestTokenServerAPI(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	checkErr(t, err, "hashing password")
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"tokenserver": {
+				"service": "registry-test",
+				"users":   map[interface{}]interface{}{"alice": string(hash)},
+			},
+		},
+		ACL: configuration.ACL{
+			Rules: []configuration.ACLRule{
+				{Repositories: []string{"*/*"}, Users: []string{"alice"}, Actions: []string{"*"}},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	imageName, _ := reference.WithName("foo/bar")
+	uploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+	resp, err := http.Post(uploadURL, "", nil)
+	checkErr(t, err, "starting upload")
+	resp.Body.Close()
+	checkResponse(t, "starting upload without a token", resp, http.StatusUnauthorized)
+	realm := env.server.URL + "/auth/token"
+	if challenge := resp.Header.Get("WWW-Authenticate"); !strings.Contains(challenge, fmt.Sprintf("realm=%q", realm)) {
+		t.Fatalf("expected the challenge to point at %s, got %q", realm, challenge)
+	}
+
+	// The tokens grant what the acl grants their users, nothing for anonymous
+	// users
+	for user, expected := range map[string]int{"": http.StatusUnauthorized, "alice": http.StatusAccepted} {
+		req, err := http.NewRequest(http.MethodGet, realm+"?service=registry-test&scope=repository:foo/bar:pull,push", nil)
+		checkErr(t, err, "creating token request")
+		if user != "" {
+			req.SetBasicAuth(user, "secret")
+		}
+		resp, err = http.DefaultClient.Do(req)
+		checkErr(t, err, "requesting token")
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status requesting token: %d", resp.StatusCode)
+		}
+		var token struct {
+			Token string `json:"token"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&token)
+		resp.Body.Close()
+		checkErr(t, err, "decoding token")
+
+		req, err = http.NewRequest(http.MethodPost, uploadURL, nil)
+		checkErr(t, err, "creating upload request")
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		resp, err = http.DefaultClient.Do(req)
+		checkErr(t, err, "starting upload")
+		resp.Body.Close()
+		checkResponse(t, fmt.Sprintf("starting upload with the token of %q", user), resp, expected)
+	}
+}
+
+func TestAdminUsersAPI(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	checkErr(t, err, "hashing password")
+	htpasswdPath := filepath.Join(t.TempDir(), "htpasswd")
+	err = os.WriteFile(htpasswdPath, []byte("admin:"+string(hash)+"\n"), 0o600)
+	checkErr(t, err, "writing htpasswd file")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"htpasswd": {
+				"realm":    "registry-test",
+				"path":     htpasswdPath,
+				"cost":     bcrypt.MinCost,
+				"usersapi": true,
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	usersURL, err := env.builder.BuildAdminUsersURL()
+	checkErr(t, err, "building users url")
+	do := func(method, user, password, url string, body interface{}) *http.Response {
+		var rd io.Reader
+		if body != nil {
+			p, err := json.Marshal(body)
+			checkErr(t, err, "marshaling request")
+			rd = bytes.NewReader(p)
+		}
+		req, err := http.NewRequest(method, url, rd)
+		checkErr(t, err, "creating request")
+		req.SetBasicAuth(user, password)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "sending request")
+		return resp
+	}
+
+	resp := do(http.MethodPut, "admin", "secret", usersURL, userAPIRequest{Name: "alice", Password: "wonderland"})
+	resp.Body.Close()
+	checkResponse(t, "adding user", resp, http.StatusNoContent)
+
+	// The new user authenticates without restarting the registry
+	baseURL, err := env.builder.BuildBaseURL()
+	checkErr(t, err, "building base url")
+	resp = do(http.MethodGet, "alice", "wonderland", baseURL, nil)
+	resp.Body.Close()
+	checkResponse(t, "authenticating new user", resp, http.StatusOK)
+
+	resp = do(http.MethodGet, "admin", "secret", usersURL, nil)
+	defer resp.Body.Close()
+	checkResponse(t, "listing users", resp, http.StatusOK)
+	var users usersAPIResponse
+	err = json.NewDecoder(resp.Body).Decode(&users)
+	checkErr(t, err, "decoding users")
+	if !reflect.DeepEqual(users.Users, []string{"admin", "alice"}) {
+		t.Fatalf("unexpected users: %v", users.Users)
+	}
+
+	resp = do(http.MethodPut, "admin", "secret", usersURL, userAPIRequest{Name: "bad:name", Password: "x"})
+	resp.Body.Close()
+	checkResponse(t, "adding invalid user", resp, http.StatusBadRequest)
+
+	resp = do(http.MethodDelete, "admin", "secret", usersURL+"?name=alice", nil)
+	resp.Body.Close()
+	checkResponse(t, "removing user", resp, http.StatusNoContent)
+
+	resp = do(http.MethodDelete, "admin", "secret", usersURL+"?name=alice", nil)
+	resp.Body.Close()
+	checkResponse(t, "removing unknown user", resp, http.StatusNotFound)
+
+	resp = do(http.MethodGet, "alice", "wonderland", baseURL, nil)
+	resp.Body.Close()
+	checkResponse(t, "authenticating removed user", resp, http.StatusUnauthorized)
+}
+
+func TestPullTokensAPI(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	checkErr(t, err, "hashing password")
+	htpasswdPath := filepath.Join(t.TempDir(), "htpasswd")
+	err = os.WriteFile(htpasswdPath, []byte("admin:"+string(hash)+"\n"), 0o600)
+	checkErr(t, err, "writing htpasswd file")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"htpasswd": {
+				"realm": "registry-test",
+				"path":  htpasswdPath,
+			},
+		},
+		PullTokens: configuration.PullTokens{
+			Enabled: true,
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	tokensURL, err := env.builder.BuildAdminTokensURL()
+	checkErr(t, err, "building tokens url")
+	mint := func(body string) *http.Response {
+		req, err := http.NewRequest(http.MethodPost, tokensURL, strings.NewReader(body))
+		checkErr(t, err, "creating mint request")
+		req.SetBasicAuth("admin", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "minting token")
+		return resp
+	}
+
+	resp := mint(`{"repositories": ["foo/[b"]}`)
+	resp.Body.Close()
+	checkResponse(t, "minting token with an invalid pattern", resp, http.StatusBadRequest)
+
+	resp = mint(`{"name": "ci", "repositories": ["foo/*"], "expiration": "10m"}`)
+	defer resp.Body.Close()
+	checkResponse(t, "minting token", resp, http.StatusCreated)
+	var token pullTokenAPIResponse
+	err = json.NewDecoder(resp.Body).Decode(&token)
+	checkErr(t, err, "decoding token")
+	if token.Name != "ci" || time.Until(token.ExpiresAt) > 10*time.Minute {
+		t.Fatalf("unexpected token: %+v", token)
+	}
+
+	// The token grants pull access to the repositories it is scoped to,
+	// nothing else
+	for repo, expected := range map[string]int{"foo/bar": http.StatusNotFound, "baz/bar": http.StatusForbidden} {
+		imageName, _ := reference.WithName(repo)
+		tagsURL, err := env.builder.BuildTagsURL(imageName)
+		checkErr(t, err, "building tags url")
+		req, err := http.NewRequest(http.MethodGet, tagsURL, nil)
+		checkErr(t, err, "creating tags request")
+		req.Header.Set("Authorization", "Bearer "+token.Token)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "listing tags")
+		resp.Body.Close()
+		checkResponse(t, "listing tags of "+repo+" with a pull token", resp, expected)
+	}
+
+	imageName, _ := reference.WithName("foo/bar")
+	uploadURL, err := env.builder.BuildBlobUploadURL(imageName)
+	checkErr(t, err, "building upload url")
+	req, err := http.NewRequest(http.MethodPost, uploadURL, nil)
+	checkErr(t, err, "creating upload request")
+	req.SetBasicAuth("ci", token.Token)
+	resp, err = http.DefaultClient.Do(req)
+	checkErr(t, err, "starting upload")
+	resp.Body.Close()
+	checkResponse(t, "starting upload with a pull token", resp, http.StatusForbidden)
+}
+
+func TestEventsAPI(t *testing.T) {
+	received := make(chan notifications.Envelope, 100)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var envelope notifications.Envelope
+		if err := json.NewDecoder(r.Body).Decode(&envelope); err == nil {
+			received <- envelope
+		}
+	}))
+	defer server.Close()
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Notifications: configuration.Notifications{
+			Endpoints: []configuration.Endpoint{
+				{
+					Name: "sink",
+					URL:  server.URL,
+					Filter: configuration.EventFilter{
+						Include: configuration.EventMatch{Repositories: []string{"never/*"}},
+					},
+				},
+			},
+			History: configuration.EventHistory{
+				Path: t.TempDir(),
+			},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, withAdminListener(&config))
+	defer env.Shutdown()
+
+	createRepository(env, t, "foo/bar", "latest")
+
+	download := func(query url.Values) []notifications.Event {
+		eventsURL, err := env.builder.BuildAdminEventsURL(query)
+		checkErr(t, err, "building events url")
+		resp, err := http.Get(eventsURL)
+		checkErr(t, err, "downloading events")
+		defer resp.Body.Close()
+		checkResponse(t, "downloading events", resp, http.StatusOK)
+		var events []notifications.Event
+		dec := json.NewDecoder(resp.Body)
+		for dec.More() {
+			var event notifications.Event
+			checkErr(t, dec.Decode(&event), "decoding event")
+			events = append(events, event)
+		}
+		return events
+	}
+
+	// The events are written to the history asynchronously
+	var pushed []notifications.Event
+	for deadline := time.Now().Add(5 * time.Second); len(pushed) == 0 && time.Now().Before(deadline); {
+		pushed = download(url.Values{"action": {notifications.EventActionPush}, "repository": {"foo/*"}})
+		time.Sleep(10 * time.Millisecond)
+	}
+	if len(pushed) == 0 {
+		t.Fatal("no push event in the history")
+	}
+	for _, event := range pushed {
+		if event.Action != notifications.EventActionPush || event.Target.Repository != "foo/bar" {
+			t.Fatalf("unexpected event: %+v", event)
+		}
+	}
+	if events := download(url.Values{"since": {"1ns"}}); len(events) != 0 {
+		t.Fatalf("unexpected events since now: %v", events)
+	}
+
+	replay := func(query url.Values) *http.Response {
+		eventsURL, err := env.builder.BuildAdminEventsURL(query)
+		checkErr(t, err, "building events url")
+		resp, err := http.Post(eventsURL, "", nil)
+		checkErr(t, err, "replaying events")
+		return resp
+	}
+
+	resp := replay(url.Values{"endpoint": {"unknown"}})
+	resp.Body.Close()
+	checkResponse(t, "replaying events to an unknown endpoint", resp, http.StatusBadRequest)
+
+	resp = replay(url.Values{"endpoint": {"sink"}, "since": {"yesterday"}})
+	resp.Body.Close()
+	checkResponse(t, "replaying events with an invalid time", resp, http.StatusBadRequest)
+
+	// The replayed events go through the filter of the endpoint, which
+	// received none of them
+	resp = replay(url.Values{"endpoint": {"sink"}, "action": {notifications.EventActionPush}, "repository": {"foo/*"}})
+	defer resp.Body.Close()
+	checkResponse(t, "replaying events", resp, http.StatusAccepted)
+	var replayed replayEventsAPIResponse
+	err := json.NewDecoder(resp.Body).Decode(&replayed)
+	checkErr(t, err, "decoding replay response")
+	if replayed.Endpoint != "sink" || replayed.Events != len(pushed) {
+		t.Fatalf("unexpected replay response: %+v, %d events pushed", replayed, len(pushed))
+	}
+	select {
+	case envelope := <-received:
+		t.Fatalf("unexpected events received by a filtered endpoint: %v", envelope)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestAuditLog(t *testing.T) {
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Audit: configuration.Audit{
+			Enabled: true,
+			Path:    auditPath,
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	createRepository(env, t, "foo/bar", "latest")
+
+	imageName, _ := reference.WithName("foo/bar")
+	tagRef, _ := reference.WithTag(imageName, "latest")
+	manifestURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := http.Get(manifestURL)
+	checkErr(t, err, "fetching manifest")
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+
+	tagRef, _ = reference.WithTag(imageName, "missing")
+	missingURL, err := env.builder.BuildManifestURL(tagRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Get(missingURL)
+	checkErr(t, err, "fetching missing manifest")
+	resp.Body.Close()
+	checkResponse(t, "fetching missing manifest", resp, http.StatusNotFound)
+
+	f, err := os.Open(auditPath)
+	checkErr(t, err, "opening audit log")
+	defer f.Close()
+	var records []notifications.Event
+	dec := json.NewDecoder(f)
+	for dec.More() {
+		var event notifications.Event
+		err := dec.Decode(&event)
+		checkErr(t, err, "decoding audit record")
+		records = append(records, event)
+	}
+	if len(records) < 3 {
+		t.Fatalf("expected the requests to be recorded, got %d records", len(records))
+	}
+
+	push, pull, missing := records[len(records)-3], records[len(records)-2], records[len(records)-1]
+	if push.Action != notifications.EventActionAudit || push.Audit == nil ||
+		push.Audit.Action != "push" || push.Audit.Status != http.StatusCreated || push.Audit.Received == 0 ||
+		push.Target.Repository != "foo/bar" || push.Target.Tag != "latest" {
+		t.Fatalf("unexpected record of the manifest push: %+v %+v", push, push.Audit)
+	}
+	if pull.Audit == nil || pull.Audit.Route != v2.RouteNameManifest || pull.Audit.Action != "pull" ||
+		pull.Audit.Status != http.StatusOK || pull.Audit.Written == 0 || pull.Request.Method != http.MethodGet {
+		t.Fatalf("unexpected record of the manifest pull: %+v %+v", pull, pull.Audit)
+	}
+	if missing.Audit == nil || missing.Audit.Status != http.StatusNotFound || missing.Audit.Error != v2.ErrorCodeManifestUnknown.String() {
+		t.Fatalf("unexpected record of the failed pull: %+v %+v", missing, missing.Audit)
+	}
+}
+
+func TestIPFilter(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		IPFilter: configuration.IPFilter{
+			TrustedProxies: []string{"127.0.0.1"},
+			Allow:          []string{"127.0.0.0/8", "10.0.0.0/8", "203.0.113.0/24"},
+			Rules: []configuration.IPFilterRule{
+				{Repositories: []string{"internal/*"}, Allow: []string{"10.0.0.0/8"}},
+			},
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	get := func(u, forwardedFor string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, u, nil)
+		checkErr(t, err, "creating request")
+		if forwardedFor != "" {
+			req.Header.Set("X-Forwarded-For", forwardedFor)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "sending request")
+		resp.Body.Close()
+		return resp
+	}
+
+	baseURL, err := env.builder.BuildBaseURL()
+	checkErr(t, err, "building base url")
+	checkResponse(t, "checking api from an allowed network", get(baseURL, "203.0.113.7"), http.StatusOK)
+	checkResponse(t, "checking api from another network", get(baseURL, "198.51.100.1"), http.StatusForbidden)
+
+	for _, tc := range []struct {
+		repo         string
+		forwardedFor string
+		expected     int
+	}{
+		{"internal/app", "10.1.2.3", http.StatusNotFound},
+		{"internal/app", "203.0.113.7", http.StatusForbidden},
+		{"internal/app", "", http.StatusForbidden},
+		{"public/app", "203.0.113.7", http.StatusNotFound},
+	} {
+		imageName, _ := reference.WithName(tc.repo)
+		tagsURL, err := env.builder.BuildTagsURL(imageName)
+		checkErr(t, err, "building tags url")
+		checkResponse(t, fmt.Sprintf("listing tags of %s from %q", tc.repo, tc.forwardedFor), get(tagsURL, tc.forwardedFor), tc.expected)
+	}
+
+	// mounting a blob reads the source repository, restricted as well
+	imageName, _ := reference.WithName("public/app")
+	mountURL, err := env.builder.BuildBlobUploadURL(imageName, url.Values{
+		"mount": []string{digest.FromString("secret").String()},
+		"from":  []string{"internal/secret"},
+	})
+	checkErr(t, err, "building mount url")
+	for _, tc := range []struct {
+		forwardedFor string
+		expected     int
+	}{
+		{"203.0.113.7", http.StatusForbidden},
+		{"10.1.2.3", http.StatusAccepted},
+	} {
+		req, err := http.NewRequest(http.MethodPost, mountURL, nil)
+		checkErr(t, err, "creating request")
+		req.Header.Set("X-Forwarded-For", tc.forwardedFor)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "sending request")
+		resp.Body.Close()
+		checkResponse(t, fmt.Sprintf("mounting from internal/secret from %q", tc.forwardedFor), resp, tc.expected)
+	}
+}
+
+func TestRateLimit(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		RateLimit: configuration.RateLimit{
+			Requests: 0.1,
+			Burst:    2,
+			Key:      "ip",
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	baseURL, err := env.builder.BuildBaseURL()
+	checkErr(t, err, "building base url")
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(baseURL)
+		checkErr(t, err, "checking api")
+		resp.Body.Close()
+		checkResponse(t, "checking api within the burst", resp, http.StatusOK)
+	}
+
+	resp, err := http.Get(baseURL)
+	checkErr(t, err, "checking api")
+	defer resp.Body.Close()
+	checkResponse(t, "checking api beyond the burst", resp, http.StatusTooManyRequests)
+	checkBodyHasErrorCodes(t, "checking api beyond the burst", resp, errcode.ErrorCodeTooManyRequests)
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter != "10" {
+		t.Fatalf("expected to retry after 10s, got %q", retryAfter)
+	}
+}
+
+func TestRateLimitIdentity(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	checkErr(t, err, "hashing password")
+	htpasswdPath := filepath.Join(t.TempDir(), "htpasswd")
+	err = os.WriteFile(htpasswdPath, []byte("alice:"+string(hash)+"\n"), 0o600)
+	checkErr(t, err, "writing htpasswd file")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"htpasswd": {
+				"realm": "registry-test",
+				"path":  htpasswdPath,
+			},
+		},
+		RateLimit: configuration.RateLimit{
+			Requests: 0.1,
+			Burst:    2,
+		},
+	}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	baseURL, err := env.builder.BuildBaseURL()
+	checkErr(t, err, "building base url")
+	get := func(password string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, baseURL, nil)
+		checkErr(t, err, "creating request")
+		req.SetBasicAuth("alice", password)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "checking api")
+		resp.Body.Close()
+		return resp
+	}
+
+	// The requests of an authenticated user are charged to the user, rather
+	// than to its address
+	for _, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		checkResponse(t, "checking api as alice", get("secret"), expected)
+	}
+
+	// The requests with invalid credentials are limited by address, before
+	// the credentials are checked
+	for _, expected := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		checkResponse(t, "checking api with an invalid password", get("wrong"), expected)
+	}
+}
+
+func TestTokenServerLimits(t *testing.T) {
+	hash, err := bcrypt.GenerateFromPassword([]byte("secret"), bcrypt.MinCost)
+	checkErr(t, err, "hashing password")
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Auth: configuration.Auth{
+			"tokenserver": {
+				"service": "registry-test",
+				"users":   map[string]interface{}{"alice": string(hash)},
+			},
+		},
+		IPFilter: configuration.IPFilter{
+			TrustedProxies: []string{"127.0.0.1"},
+			Allow:          []string{"203.0.113.0/24"},
+		},
+		RateLimit: configuration.RateLimit{
+			Requests: 0.1,
+			Burst:    2,
+		},
 	}
-	canonicalDigest := canonicalDigester.Digest()
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
 
-	// ------------------------
-	// Use a head request to see if it exists
-	resp, err = http.Head(layerURL)
-	if err != nil {
-		t.Fatalf("unexpected error checking head on existing layer: %v", err)
+	get := func(forwardedFor string) *http.Response {
+		req, err := http.NewRequest(http.MethodGet, env.server.URL+token.ServerPath+"?service=registry-test", nil)
+		checkErr(t, err, "creating request")
+		req.Header.Set("X-Forwarded-For", forwardedFor)
+		req.SetBasicAuth("alice", "secret")
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "requesting token")
+		resp.Body.Close()
+		return resp
 	}
 
-	layerLength, _ := layerFile.Seek(0, io.SeekEnd)
-	checkResponse(t, "checking head on reuploaded layer", resp, http.StatusOK)
-	checkHeaders(t, resp, http.Header{
-		"Content-Length":        []string{fmt.Sprint(layerLength)},
-		"Docker-Content-Digest": []string{canonicalDigest.String()},
-	})
+	// The token server is restricted to the allowed networks, and limited by
+	// address, as the registry is
+	checkResponse(t, "requesting token from another network", get("198.51.100.1"), http.StatusForbidden)
+	for _, expected := range []int{http.StatusOK, http.StatusOK, http.StatusTooManyRequests} {
+		checkResponse(t, "requesting token from an allowed network", get("203.0.113.7"), expected)
+	}
 }
 
-func TestDeleteDisabled(t *testing.T) {
+func TestTagHistoryAPI(t *testing.T) {
 	env := newTestEnv(t, false)
 	defer env.Shutdown()
 
 	imageName, _ := reference.WithName("foo/bar")
-	// "build" our layer file
-	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
-	if err != nil {
-		t.Fatalf("error creating random layer file: %v", err)
-	}
+	tagRef, _ := reference.WithTag(imageName, "prod")
+	historyURL, err := env.builder.BuildTagHistoryURL(tagRef)
+	checkErr(t, err, "building tag history url")
 
-	ref, _ := reference.WithDigest(imageName, layerDigest)
-	layerURL, err := env.builder.BuildBlobURL(ref)
-	if err != nil {
-		t.Fatalf("Error building blob URL")
-	}
-	uploadURLBase, _ := startPushLayer(t, env, imageName)
-	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
+	resp, err := http.Get(historyURL)
+	checkErr(t, err, "fetching history of unknown tag")
+	resp.Body.Close()
+	checkResponse(t, "fetching history of unknown tag", resp, http.StatusNotFound)
 
-	resp, err := httpDelete(layerURL)
-	if err != nil {
-		t.Fatalf("unexpected error deleting layer: %v", err)
-	}
+	first := createRepository(env, t, "foo/bar", "prod")
+	second := createRepository(env, t, "foo/bar", "prod")
 
-	checkResponse(t, "deleting layer with delete disabled", resp, http.StatusMethodNotAllowed)
+	resp, err = http.Get(historyURL)
+	checkErr(t, err, "fetching tag history")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching tag history", resp, http.StatusOK)
+
+	var history tagHistoryAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatalf("error decoding the tag history: %v", err)
+	}
+	if history.Name != "foo/bar" || history.Tag != "prod" || len(history.History) != 2 {
+		t.Fatalf("unexpected tag history: %+v", history)
+	}
+	if history.History[0].Digest != second || history.History[0].Previous != first {
+		t.Fatalf("unexpected latest change: %+v", history.History[0])
+	}
+	if history.History[1].Digest != first || history.History[1].Previous != "" {
+		t.Fatalf("unexpected first change: %+v", history.History[1])
+	}
 }
 
-func TestDeleteReadOnly(t *testing.T) {
-	env := newTestEnv(t, true)
+func TestManifestPutIfMatch(t *testing.T) {
+	env := newTestEnv(t, false)
 	defer env.Shutdown()
 
+	first := createRepository(env, t, "foo/bar", "latest")
+	second := createRepository(env, t, "foo/bar", "other")
+
 	imageName, _ := reference.WithName("foo/bar")
-	// "build" our layer file
-	layerFile, layerDigest, err := testutil.CreateRandomTarFile()
-	if err != nil {
-		t.Fatalf("error creating random layer file: %v", err)
+	digestRef, _ := reference.WithDigest(imageName, second)
+	secondURL, err := env.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+	resp, err := http.Get(secondURL)
+	checkErr(t, err, "fetching manifest")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching manifest", resp, http.StatusOK)
+	contentType := resp.Header.Get("Content-Type")
+	payload, err := io.ReadAll(resp.Body)
+	checkErr(t, err, "reading manifest")
+
+	putTag := func(tag, ifMatch string) *http.Response {
+		tagRef, _ := reference.WithTag(imageName, tag)
+		tagURL, err := env.builder.BuildManifestURL(tagRef)
+		checkErr(t, err, "building tag url")
+		req, err := http.NewRequest(http.MethodPut, tagURL, bytes.NewReader(payload))
+		checkErr(t, err, "creating request")
+		req.Header.Set("Content-Type", contentType)
+		req.Header.Set("If-Match", ifMatch)
+		resp, err := http.DefaultClient.Do(req)
+		checkErr(t, err, "putting manifest")
+		resp.Body.Close()
+		return resp
+	}
+
+	for _, tc := range []struct {
+		description string
+		tag         string
+		ifMatch     string
+		status      int
+	}{
+		{"stale etag", "latest", `"` + second.String() + `"`, http.StatusPreconditionFailed},
+		{"unknown tag", "new", "*", http.StatusPreconditionFailed},
+		{"current etag", "latest", `"` + first.String() + `"`, http.StatusCreated},
+		{"previous etag", "latest", `"` + first.String() + `"`, http.StatusPreconditionFailed},
+		{"any of the etags", "latest", `"` + first.String() + `", "` + second.String() + `"`, http.StatusCreated},
+		{"existing tag", "other", "*", http.StatusCreated},
+	} {
+		resp := putTag(tc.tag, tc.ifMatch)
+		checkResponse(t, "putting manifest with "+tc.description, resp, tc.status)
+		if tc.status == http.StatusPreconditionFailed {
+			continue
+		}
+		checkHeaders(t, resp, http.Header{
+			"Etag": []string{`"` + second.String() + `"`},
+		})
 	}
+}
 
-	ref, _ := reference.WithDigest(imageName, layerDigest)
-	layerURL, err := env.builder.BuildBlobURL(ref)
-	if err != nil {
-		t.Fatalf("Error building blob URL")
-	}
-	uploadURLBase, _ := startPushLayer(t, env, imageName)
-	pushLayer(t, env.builder, imageName, layerDigest, uploadURLBase, layerFile)
+func TestDigestAlgorithmAPI(t *testing.T) {
+	for _, algorithm := range []digest.Algorithm{digest.SHA256, digest.SHA512} {
+		config := configuration.Configuration{
+			Storage: configuration.Storage{
+				"inmemory": configuration.Parameters{},
+				"digest":   configuration.Parameters{"algorithm": string(algorithm)},
+				"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+					"enabled": false,
+				}},
+			},
+		}
+		config.HTTP.Headers = headerConfig
+		env := newTestEnvWithConfig(t, &config)
+		defer env.Shutdown()
+
+		imageName, _ := reference.WithName("foo/bar")
+		content := []byte("layer content")
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		resp, err := doPushLayer(t, env.builder, imageName, digest.FromBytes(content), uploadURLBase, bytes.NewReader(content))
+		checkErr(t, err, "pushing layer")
+		resp.Body.Close()
+		checkResponse(t, "pushing layer", resp, http.StatusCreated)
+
+		deserialized, err := ocischema.FromStruct(ocischema.Manifest{
+			Versioned: ocischema.SchemaVersion,
+			Config:    distribution.Descriptor{MediaType: v1.MediaTypeImageConfig, Digest: digest.FromBytes(content), Size: int64(len(content))},
+		})
+		checkErr(t, err, "building manifest")
+		_, payload, err := deserialized.Payload()
+		checkErr(t, err, "building manifest")
+
+		putAndGet := func(ref reference.Named, expected digest.Digest) {
+			manifestURL, err := env.builder.BuildManifestURL(ref)
+			checkErr(t, err, "building manifest url")
+			resp := putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, deserialized)
+			defer resp.Body.Close()
+			checkResponse(t, "putting manifest", resp, http.StatusCreated)
+			checkHeaders(t, resp, http.Header{
+				"Docker-Content-Digest": []string{expected.String()},
+			})
+
+			digestRef, _ := reference.WithDigest(imageName, expected)
+			manifestURL, err = env.builder.BuildManifestURL(digestRef)
+			checkErr(t, err, "building manifest url")
+			req, err := http.NewRequest(http.MethodGet, manifestURL, nil)
+			checkErr(t, err, "building manifest request")
+			req.Header.Set("Accept", v1.MediaTypeImageManifest)
+			resp, err = http.DefaultClient.Do(req)
+			checkErr(t, err, "fetching manifest")
+			defer resp.Body.Close()
+			checkResponse(t, "fetching manifest by "+expected.String(), resp, http.StatusOK)
+			body, err := io.ReadAll(resp.Body)
+			checkErr(t, err, "reading manifest")
+			if !bytes.Equal(body, payload) {
+				t.Fatalf("unexpected manifest %s", body)
+			}
+		}
 
-	env.app.readOnly = true
+		// Pushed by tag, the manifest is addressed with the configured
+		// algorithm
+		tagRef, _ := reference.WithTag(imageName, "latest")
+		putAndGet(tagRef, algorithm.FromBytes(payload))
 
-	resp, err := httpDelete(layerURL)
-	if err != nil {
-		t.Fatalf("unexpected error deleting layer: %v", err)
+		// Pushed by digest, the manifest is addressed with the algorithm of
+		// the digest, which is verified
+		for _, other := range []digest.Algorithm{digest.SHA256, digest.SHA512} {
+			digestRef, _ := reference.WithDigest(imageName, other.FromBytes(payload))
+			putAndGet(digestRef, other.FromBytes(payload))
+		}
+		wrongRef, _ := reference.WithDigest(imageName, digest.SHA512.FromString("other manifest"))
+		manifestURL, err := env.builder.BuildManifestURL(wrongRef)
+		checkErr(t, err, "building manifest url")
+		resp = putManifest(t, "putting manifest", manifestURL, v1.MediaTypeImageManifest, deserialized)
+		resp.Body.Close()
+		checkResponse(t, "putting manifest with a mismatching digest", resp, http.StatusBadRequest)
 	}
-
-	checkResponse(t, "deleting layer in read-only mode", resp, http.StatusMethodNotAllowed)
 }
 
-func TestStartPushReadOnly(t *testing.T) {
-	env := newTestEnv(t, true)
+func TestArtifactReferrersAPI(t *testing.T) {
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	config.Policy.Repository.Classes = []string{"image", "artifact"}
+	config.HTTP.Headers = headerConfig
+	env := newTestEnvWithConfig(t, &config)
 	defer env.Shutdown()
-	env.app.readOnly = true
-
-	imageName, _ := reference.WithName("foo/bar")
 
-	layerUploadURL, err := env.builder.BuildBlobUploadURL(imageName)
-	if err != nil {
-		t.Fatalf("unexpected error building layer upload url: %v", err)
+	imageName, _ := reference.WithName("foo/chart")
+	pushBlob := func(mediaType string, content []byte) distribution.Descriptor {
+		dgst := digest.FromBytes(content)
+		uploadURLBase, _ := startPushLayer(t, env, imageName)
+		pushLayer(t, env.builder, imageName, dgst, uploadURLBase, bytes.NewReader(content))
+		return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(content))}
+	}
+	pushArtifact := func(m ocischema.Manifest, tag string) distribution.Descriptor {
+		m.Versioned = ocischema.SchemaVersion
+		deserialized, err := ocischema.FromStruct(m)
+		checkErr(t, err, "building manifest")
+		_, payload, err := deserialized.Payload()
+		checkErr(t, err, "building manifest")
+		dgst := digest.FromBytes(payload)
+
+		var ref reference.Named
+		if tag != "" {
+			ref, _ = reference.WithTag(imageName, tag)
+		} else {
+			ref, _ = reference.WithDigest(imageName, dgst)
+		}
+		manifestURL, err := env.builder.BuildManifestURL(ref)
+		checkErr(t, err, "building manifest url")
+		resp := putManifest(t, "putting artifact", manifestURL, v1.MediaTypeImageManifest, deserialized)
+		defer resp.Body.Close()
+		checkResponse(t, "putting artifact", resp, http.StatusCreated)
+		return distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))}
 	}
 
-	resp, err := http.Post(layerUploadURL, "", nil)
-	if err != nil {
-		t.Fatalf("unexpected error starting layer push: %v", err)
-	}
+	// A Helm chart, with a config media type of its own
+	chart := pushArtifact(ocischema.Manifest{
+		Config: pushBlob("application/vnd.cncf.helm.config.v1+json", []byte(`{"name":"chart","version":"1.0.0"}`)),
+		Layers: []distribution.Descriptor{
+			pushBlob("application/vnd.cncf.helm.chart.content.v1.tar+gzip", []byte("chart content")),
+		},
+	}, "1.0.0")
+
+	emptyConfig := pushBlob("application/vnd.oci.empty.v1+json", []byte("{}"))
+	signature := pushArtifact(ocischema.Manifest{
+		ArtifactType: "application/vnd.example.signature",
+		Config:       emptyConfig,
+		Layers:       []distribution.Descriptor{pushBlob("application/octet-stream", []byte("signature"))},
+		Subject:      &chart,
+		Annotations:  map[string]string{"org.example.signer": "ci"},
+	}, "")
+	sbom := pushArtifact(ocischema.Manifest{
+		ArtifactType: "application/spdx+json",
+		Config:       emptyConfig,
+		Layers:       []distribution.Descriptor{pushBlob("application/spdx+json", []byte("{\"spdxVersion\":\"SPDX-2.3\"}"))},
+		Subject:      &chart,
+		Annotations:  map[string]string{"org.example.signer": "release", "org.example.scanned": "true"},
+	}, "")
+
+	tagsURL, err := env.builder.BuildTagsURL(imageName)
+	checkErr(t, err, "building tags url")
+	resp, err := http.Get(tagsURL)
+	checkErr(t, err, "listing tags")
 	defer resp.Body.Close()
+	checkResponse(t, "listing tags", resp, http.StatusOK)
+	var tags tagsAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&tags); err != nil {
+		t.Fatalf("error decoding tags: %v", err)
+	}
+	if !reflect.DeepEqual(tags.Tags, []string{"1.0.0"}) {
+		t.Fatalf("unexpected tags: %v", tags.Tags)
+	}
+
+	chartRef, _ := reference.WithDigest(imageName, chart.Digest)
+	getReferrers := func(values url.Values, expectedFilters string, expected ...digest.Digest) {
+		referrersURL, err := env.builder.BuildReferrersURL(chartRef, values)
+		checkErr(t, err, "building referrers url")
+		resp, err := http.Get(referrersURL)
+		checkErr(t, err, "listing referrers")
+		defer resp.Body.Close()
+		checkResponse(t, "listing referrers", resp, http.StatusOK)
+		if filters := resp.Header.Get("OCI-Filters-Applied"); filters != expectedFilters {
+			t.Fatalf("unexpected filters applied %q for %v", filters, values)
+		}
 
-	checkResponse(t, "starting push in read-only mode", resp, http.StatusMethodNotAllowed)
+		var index referrersAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&index); err != nil {
+			t.Fatalf("error decoding referrers: %v", err)
+		}
+		var digests []digest.Digest
+		for _, desc := range index.Manifests {
+			digests = append(digests, desc.Digest)
+		}
+		sort.Slice(expected, func(i, j int) bool { return expected[i] < expected[j] })
+		if !reflect.DeepEqual(digests, expected) {
+			t.Fatalf("unexpected referrers for %v: %v", values, index.Manifests)
+		}
+	}
+
+	getReferrers(url.Values{}, "", signature.Digest, sbom.Digest)
+	getReferrers(url.Values{"artifactType": []string{"application/spdx+json"}}, "artifactType", sbom.Digest)
+	getReferrers(url.Values{"annotation": []string{"org.example.signer=ci"}}, "annotation", signature.Digest)
+	getReferrers(url.Values{"annotation": []string{"org.example.signer"}}, "annotation", signature.Digest, sbom.Digest)
+	getReferrers(url.Values{"annotation": []string{"org.example.signer", "org.example.scanned=true"}}, "annotation", sbom.Digest)
+	getReferrers(url.Values{
+		"artifactType": []string{"application/vnd.example.signature"},
+		"annotation":   []string{"org.example.signer=release"},
+	}, "artifactType,annotation")
+
+	referrersURL, err := env.builder.BuildReferrersURL(chartRef, url.Values{"annotation": []string{"=ci"}})
+	checkErr(t, err, "building referrers url")
+	resp, err = http.Get(referrersURL)
+	checkErr(t, err, "listing referrers")
+	defer resp.Body.Close()
+	checkResponse(t, "listing referrers with an invalid filter", resp, http.StatusBadRequest)
 }
 
 func httpDelete(url string) (*http.Response, error) {
@@ -1331,7 +2793,7 @@ func TestManifestAPI_DeleteTag_Unknown(t *testing.T) {
 func TestManifestAPI_DeleteTag_ReadOnly(t *testing.T) {
 	env := newTestEnv(t, false)
 	defer env.Shutdown()
-	env.app.readOnly = true
+	env.app.SetReadOnly(true)
 
 	imageName, err := reference.WithName("foo/bar")
 	checkErr(t, err, "building named object")
@@ -2562,6 +4024,11 @@ func newTestEnvMirror(t *testing.T, deleteEnabled bool) *testEnv {
 }
 
 func newTestEnv(t *testing.T, deleteEnabled bool) *testEnv {
+	return newTestEnvWithConfig(t, testEnvConfig(deleteEnabled))
+}
+
+// testEnvConfig returns the configuration of newTestEnv.
+func testEnvConfig(deleteEnabled bool) *configuration.Configuration {
 	config := configuration.Configuration{
 		Storage: configuration.Storage{
 			"inmemory": configuration.Parameters{},
@@ -2577,8 +4044,15 @@ func newTestEnv(t *testing.T, deleteEnabled bool) *testEnv {
 
 	config.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
 	config.HTTP.Headers = headerConfig
+	return &config
+}
 
-	return newTestEnvWithConfig(t, &config)
+// withAdminListener configures the admin listener, which serves the admin
+// endpoints without authentication. The test server serves them along with
+// the API.
+func withAdminListener(config *configuration.Configuration) *configuration.Configuration {
+	config.HTTP.Listeners.Admin.Addr = "localhost:5003"
+	return config
 }
 
 func newTestEnvWithConfig(t *testing.T, config *configuration.Configuration) *testEnv {
@@ -3154,3 +4628,279 @@ func TestProxyManifestGetByTag(t *testing.T) {
 		"Docker-Content-Digest": []string{newDigest.String()},
 	})
 }
+
+func TestProxyStatsAPI(t *testing.T) {
+	truthConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	truthConfig.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	truthConfig.HTTP.Headers = headerConfig
+
+	imageName, _ := reference.WithName("foo/bar")
+
+	truthEnv := newTestEnvWithConfig(t, &truthConfig)
+	defer truthEnv.Shutdown()
+	dgst := createRepository(truthEnv, t, imageName.Name(), "latest")
+
+	// The stats endpoint is not available on a registry which is not a cache
+	statsURL, err := truthEnv.builder.BuildProxyStatsURL()
+	checkErr(t, err, "building proxy stats url")
+	resp, err := http.Get(statsURL)
+	checkErr(t, err, "fetching proxy stats")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching proxy stats from a registry", resp, http.StatusMethodNotAllowed)
+
+	proxyConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Proxy: configuration.Proxy{
+			RemoteURL: truthEnv.server.URL,
+		},
+	}
+	proxyConfig.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	proxyConfig.HTTP.Headers = headerConfig
+
+	proxyEnv := newTestEnvWithConfig(t, &proxyConfig)
+	defer proxyEnv.Shutdown()
+
+	digestRef, _ := reference.WithDigest(imageName, dgst)
+	manifestDigestURL, err := proxyEnv.builder.BuildManifestURL(digestRef)
+	checkErr(t, err, "building manifest url")
+
+	// The first fetch misses the cache, the second is served from it
+	for i := 0; i < 2; i++ {
+		resp, err := http.Get(manifestDigestURL)
+		checkErr(t, err, "fetching manifest from proxy by digest")
+		resp.Body.Close()
+		checkResponse(t, "fetching manifest from proxy by digest", resp, http.StatusOK)
+	}
+
+	statsURL, err = proxyEnv.builder.BuildProxyStatsURL()
+	checkErr(t, err, "building proxy stats url")
+	resp, err = http.Get(statsURL)
+	checkErr(t, err, "fetching proxy stats")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching proxy stats", resp, http.StatusOK)
+
+	var stats proxy.Stats
+	if err := json.NewDecoder(resp.Body).Decode(&stats); err != nil {
+		t.Fatalf("error decoding proxy stats: %v", err)
+	}
+
+	truthURL, err := url.Parse(truthEnv.server.URL)
+	checkErr(t, err, "parsing upstream url")
+	manifestStats := stats.Namespaces[truthURL.Host].Manifests
+	if manifestStats.Entries != 1 || manifestStats.Hits != 1 || manifestStats.Misses != 1 || manifestStats.HitRatio != 0.5 {
+		t.Fatalf("unexpected manifest stats: %+v", manifestStats)
+	}
+	if manifestStats.Oldest == nil || manifestStats.Newest == nil {
+		t.Fatalf("expected cache entry times: %+v", manifestStats)
+	}
+	if stats.SchedulerBacklog != 1 {
+		t.Fatalf("unexpected scheduler backlog: %d", stats.SchedulerBacklog)
+	}
+}
+
+func TestProxyNamespacedCatalog(t *testing.T) {
+	proxyConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+		},
+		Proxy: configuration.Proxy{
+			EnableNamespaces: true,
+			GroupCatalog:     true,
+			Transports: map[string]configuration.ProxyTransport{
+				"quay.io": {},
+			},
+			Remotes: map[string]configuration.ProxyRemote{
+				"docker.io": {PathPrefix: "/mirror"},
+			},
+		},
+	}
+	proxyConfig.HTTP.Headers = headerConfig
+	proxyConfig.Catalog.MaxEntries = 5
+
+	env := newTestEnvWithConfig(t, &proxyConfig)
+	defer env.Shutdown()
+
+	// Cached repositories are those with manifests
+	for _, name := range []string{
+		"registry-1.docker.io/library/alpine",
+		"registry-1.docker.io/library/redis",
+		"quay.io/coreos/etcd",
+	} {
+		linkPath := "/docker/registry/v2/repositories/" + name + "/_manifests/tags/latest/current/link"
+		if err := env.app.driver.PutContent(env.ctx, linkPath, []byte(digest.FromString(name).String())); err != nil {
+			t.Fatalf("error creating repository %s: %v", name, err)
+		}
+	}
+
+	namespacesURL, err := env.builder.BuildProxyNamespacesURL()
+	checkErr(t, err, "building proxy namespaces url")
+	resp, err := http.Get(namespacesURL)
+	checkErr(t, err, "fetching proxy namespaces")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching proxy namespaces", resp, http.StatusOK)
+
+	var namespaces proxyNamespacesAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&namespaces); err != nil {
+		t.Fatalf("error decoding proxy namespaces: %v", err)
+	}
+	expectedNamespaces := []proxy.Namespace{
+		{Name: "quay.io", URL: "https://quay.io"},
+		{Name: "registry-1.docker.io", URL: "https://registry-1.docker.io/mirror"},
+	}
+	if !reflect.DeepEqual(namespaces.Namespaces, expectedNamespaces) {
+		t.Fatalf("unexpected proxy namespaces: %+v", namespaces.Namespaces)
+	}
+
+	catalogURL, err := env.builder.BuildCatalogURL()
+	checkErr(t, err, "building catalog url")
+	resp, err = http.Get(catalogURL)
+	checkErr(t, err, "fetching catalog")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching catalog", resp, http.StatusOK)
+
+	var grouped groupedCatalogAPIResponse
+	if err := json.NewDecoder(resp.Body).Decode(&grouped); err != nil {
+		t.Fatalf("error decoding catalog: %v", err)
+	}
+	expectedGroups := map[string][]string{
+		"quay.io":              {"coreos/etcd"},
+		"registry-1.docker.io": {"library/alpine", "library/redis"},
+	}
+	if !reflect.DeepEqual(grouped.Namespaces, expectedGroups) {
+		t.Fatalf("unexpected grouped catalog: %v", grouped.Namespaces)
+	}
+
+	// A single upstream is listed by upstream names, one page at a time
+	catalogURL, err = env.builder.BuildCatalogURL(url.Values{"ns": []string{"docker.io"}, "n": []string{"1"}})
+	checkErr(t, err, "building catalog url")
+	var listed []string
+	for catalogURL != "" {
+		resp, err = http.Get(catalogURL)
+		checkErr(t, err, "fetching namespace catalog")
+		defer resp.Body.Close()
+		checkResponse(t, "fetching namespace catalog", resp, http.StatusOK)
+
+		var ctlg catalogAPIResponse
+		if err := json.NewDecoder(resp.Body).Decode(&ctlg); err != nil {
+			t.Fatalf("error decoding catalog: %v", err)
+		}
+		listed = append(listed, ctlg.Repositories...)
+
+		catalogURL = ""
+		if link := resp.Header.Get("Link"); link != "" {
+			if !strings.Contains(link, "ns=docker.io") {
+				t.Fatalf("expected the ns parameter in the link: %s", link)
+			}
+			catalogURL = env.server.URL + strings.TrimSuffix(strings.TrimPrefix(link, "<"), `>; rel="next"`)
+		}
+	}
+	if !reflect.DeepEqual(listed, []string{"library/alpine", "library/redis"}) {
+		t.Fatalf("unexpected namespace catalog: %v", listed)
+	}
+}
+
+func TestReplicationAPI(t *testing.T) {
+	downstreamConfig := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+	downstreamConfig.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	downstreamConfig.HTTP.Headers = headerConfig
+
+	downstreamEnv := newTestEnvWithConfig(t, &downstreamConfig)
+	defer downstreamEnv.Shutdown()
+
+	// The status endpoint is not available on a registry which does not
+	// replicate
+	statusURL, err := downstreamEnv.builder.BuildReplicationStatusURL()
+	checkErr(t, err, "building replication status url")
+	resp, err := http.Get(statusURL)
+	checkErr(t, err, "fetching replication status")
+	defer resp.Body.Close()
+	checkResponse(t, "fetching replication status from a registry", resp, http.StatusMethodNotAllowed)
+
+	config := configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Replication: configuration.Replication{
+			Retry: configuration.ReplicationRetry{InitialBackoff: 10 * time.Millisecond},
+			Rules: []configuration.ReplicationRule{
+				{
+					Name:         "downstream",
+					URL:          downstreamEnv.server.URL,
+					Repositories: []string{"foo/*"},
+					Prefix:       "mirror",
+				},
+			},
+		},
+	}
+	config.Compatibility.Schema1.Enabled = true //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	config.HTTP.Headers = headerConfig
+
+	env := newTestEnvWithConfig(t, &config)
+	defer env.Shutdown()
+
+	dgst := createRepository(env, t, "foo/bar", "latest")
+	createRepository(env, t, "other/bar", "latest")
+
+	statusURL, err = env.builder.BuildReplicationStatusURL()
+	checkErr(t, err, "building replication status url")
+	var status replication.Status
+	for start := time.Now(); ; time.Sleep(10 * time.Millisecond) {
+		resp, err := http.Get(statusURL)
+		checkErr(t, err, "fetching replication status")
+		checkResponse(t, "fetching replication status", resp, http.StatusOK)
+		err = json.NewDecoder(resp.Body).Decode(&status)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("error decoding replication status: %v", err)
+		}
+		if len(status.Rules) != 1 {
+			t.Fatalf("unexpected replication status: %+v", status)
+		}
+		if status.Rules[0].Pending == 0 && len(status.Rules[0].Recent) > 0 {
+			break
+		}
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("replication did not finish: %+v", status)
+		}
+	}
+
+	rule := status.Rules[0]
+	if rule.Name != "downstream" || rule.Succeeded != 1 || rule.Failed != 0 || rule.LastSuccess == nil {
+		t.Fatalf("unexpected replication status: %+v", rule)
+	}
+	if job := rule.Recent[0]; job.Repository != "foo/bar" || job.Digest != dgst || job.Tag != "latest" || !job.Succeeded {
+		t.Fatalf("unexpected replication: %+v", job)
+	}
+
+	// The manifest is tagged under the prefix downstream
+	replicatedRef, _ := reference.WithName("mirror/foo/bar")
+	taggedRef, _ := reference.WithTag(replicatedRef, "latest")
+	manifestURL, err := downstreamEnv.builder.BuildManifestURL(taggedRef)
+	checkErr(t, err, "building manifest url")
+	resp, err = http.Head(manifestURL)
+	checkErr(t, err, "checking replicated manifest")
+	resp.Body.Close()
+	checkResponse(t, "checking replicated manifest", resp, http.StatusOK)
+	if resp.Header.Get("Docker-Content-Digest") != dgst.String() {
+		t.Fatalf("unexpected replicated manifest digest %q", resp.Header.Get("Docker-Content-Digest"))
+	}
+}
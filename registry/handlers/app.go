@@ -3,10 +3,11 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
 	"expvar"
 	"fmt"
-	"math"
-	"math/big"
 	"net"
 	"net/http"
 	"net/url"
@@ -25,24 +26,40 @@ import (
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/notifications"
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/accesslog"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/auth/acl"
+	"github.com/distribution/distribution/v3/registry/auth/public"
+	"github.com/distribution/distribution/v3/registry/auth/pulltoken"
+	"github.com/distribution/distribution/v3/registry/auth/token"
+	"github.com/distribution/distribution/v3/registry/catalog"
+	"github.com/distribution/distribution/v3/registry/ipfilter"
 	registrymiddleware "github.com/distribution/distribution/v3/registry/middleware/registry"
 	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
 	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
+	"github.com/distribution/distribution/v3/registry/replication"
+	"github.com/distribution/distribution/v3/registry/scan"
+	"github.com/distribution/distribution/v3/registry/search"
 	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	memorycache "github.com/distribution/distribution/v3/registry/storage/cache/memory"
 	rediscache "github.com/distribution/distribution/v3/registry/storage/cache/redis"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
 	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+	"github.com/distribution/distribution/v3/registry/storage/metadata"
+	"github.com/distribution/distribution/v3/registry/storage/metadata/postgres"
+	"github.com/distribution/distribution/v3/tracing"
 	"github.com/distribution/distribution/v3/version"
 	events "github.com/docker/go-events"
 	"github.com/docker/go-metrics"
 	"github.com/docker/libtrust"
 	"github.com/gomodule/redigo/redis"
 	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
 	"github.com/sirupsen/logrus"
 )
 
@@ -53,6 +70,10 @@ const randomSecretSize = 32
 // defaultCheckInterval is the default time in between health checks
 const defaultCheckInterval = 10 * time.Second
 
+// defaultUpstreamCheckTimeout is the default time to wait for an upstream of
+// a pull through cache to answer its health check
+const defaultUpstreamCheckTimeout = 5 * time.Second
+
 // App is a global registry application object. Shared resources can be placed
 // on this object that will be accessible from all requests. Any writable
 // fields should be protected.
@@ -66,6 +87,12 @@ type App struct {
 	registry         distribution.Namespace         // registry is the primary registry backend for the app instance.
 	repoRemover      distribution.RepositoryRemover // repoRemover provides ability to delete repos
 	accessController auth.AccessController          // main access controller for application
+	adminListener    bool                           // adminListener serves the admin endpoints apart from the API
+	users            auth.UserManager               // users manages the users of the access controller, when supported
+	pullTokens       *pulltoken.Issuer              // pullTokens mints pull tokens, when enabled
+	auditor          *auditor                       // auditor records the requests to the API, when enabled
+	ipFilter         *ipfilter.Filter               // ipFilter restricts the networks of the clients, when configured
+	rateLimiter      *ratelimit.Limiter             // rateLimiter limits the requests of each client, when configured
 
 	// httpHost is a parsed representation of the http.host parameter from
 	// the configuration. Only the Scheme and Host fields are used.
@@ -75,20 +102,74 @@ type App struct {
 	events struct {
 		sink   events.Sink
 		source notifications.SourceRecord
+
+		// endpoints are the configured endpoints, by name, to replay the
+		// events of the history to.
+		endpoints map[string]*notifications.Endpoint
+		history   *notifications.History
 	}
 
 	redis *redis.Pool
 
+	// metadata records the manifests, tags and repositories in a database,
+	// if one is configured
+	metadata metadata.Store
+
 	// trustKey is a deprecated key used to sign manifests converted to
 	// schema1 for backward compatibility. It should not be used for any
 	// other purposes.
 	trustKey libtrust.PrivateKey
 
+	// replicator replicates manifests to downstream registries, if
+	// replication rules are configured
+	replicator *replication.Replicator
+
+	// scanner scans the images pushed or cached for vulnerabilities, if a
+	// scanner is configured
+	scanner *scan.Scanner
+
+	// catalogIndex lists the repositories for the catalog endpoint, if the
+	// catalog index is enabled
+	catalogIndex *catalog.Index
+
+	// searchIndex indexes the repositories for the search endpoint, if
+	// search is enabled
+	searchIndex *search.Index
+
 	// isCache is true if this registry is configured as a pull through cache
 	isCache bool
 
-	// readOnly is true if the registry is in a read-only maintenance mode
-	readOnly bool
+	// readOnly is the read-only maintenance mode of the registry, and of
+	// its repositories
+	readOnly readOnlyMode
+
+	// readOnlyDegraded is set while the registry is in read-only mode
+	// because writes to the storage driver are failing
+	readOnlyDegraded int32
+
+	// trashEnabled is true if deleted manifests are moved to the trash,
+	// where they are kept for trashRetention
+	trashEnabled   bool
+	trashRetention time.Duration
+
+	// tagLocks serializes the conditional updates of each tag
+	tagLocks tagLocks
+
+	// uploadPurger purges the stale uploads, nil if upload purging is
+	// disabled
+	uploadPurger *uploadPurger
+
+	// digestAlgorithm is the algorithm of the digests computed for new
+	// content
+	digestAlgorithm digest.Algorithm
+
+	// layoutOptions are the registry options of the layout of the links,
+	// shared by the registries of the workers
+	layoutOptions []storage.RegistryOption
+
+	// blobCompression compresses the blobs served, nil if compression is
+	// disabled
+	blobCompression *blobCompression
 }
 
 // NewApp takes a configuration and returns a configured app, ready to serve
@@ -108,7 +189,19 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	})
 	app.register(v2.RouteNameManifest, manifestDispatcher)
 	app.register(v2.RouteNameCatalog, catalogDispatcher)
+	app.register(v2.RouteNameSearch, searchDispatcher)
 	app.register(v2.RouteNameTags, tagsDispatcher)
+	app.register(v2.RouteNameTagHistory, tagHistoryDispatcher)
+	app.register(v2.RouteNameReferrers, referrersDispatcher)
+	app.register(v2.RouteNameProxyStats, proxyStatsDispatcher)
+	app.register(v2.RouteNameProxyNamespaces, proxyNamespacesDispatcher)
+	app.register(v2.RouteNameReplicationStatus, replicationStatusDispatcher)
+	app.register(v2.RouteNameAdminReadOnly, readOnlyDispatcher)
+	app.register(v2.RouteNameAdminTrash, trashDispatcher)
+	app.register(v2.RouteNameAdminUsers, usersDispatcher)
+	app.register(v2.RouteNameAdminTokens, pullTokensDispatcher)
+	app.register(v2.RouteNameAdminEvents, eventsDispatcher)
+	app.register(v2.RouteNameAdminUploadPurging, uploadPurgingDispatcher)
 	app.register(v2.RouteNameBlob, blobDispatcher)
 	app.register(v2.RouteNameBlobUpload, blobUploadDispatcher)
 	app.register(v2.RouteNameBlobUploadChunk, blobUploadDispatcher)
@@ -130,6 +223,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	}
 
 	purgeConfig := uploadPurgeDefaultConfig()
+	var gcJournalEnabled bool
 	if mc, ok := config.Storage["maintenance"]; ok {
 		if v, ok := mc["uploadpurging"]; ok {
 			purgeConfig, ok = v.(map[interface{}]interface{})
@@ -143,15 +237,28 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				panic("readonly config key must contain additional keys")
 			}
 			if readOnlyEnabled, ok := readOnly["enabled"]; ok {
-				app.readOnly, ok = readOnlyEnabled.(bool)
+				app.readOnly.enabled, ok = readOnlyEnabled.(bool)
 				if !ok {
 					panic("readonly's enabled config key must have a boolean value")
 				}
 			}
 		}
+		if v, ok := mc["gcjournal"]; ok {
+			gcJournal, ok := v.(map[interface{}]interface{})
+			if !ok {
+				panic("gcjournal config key must contain additional keys")
+			}
+			if enabled, ok := gcJournal["enabled"]; ok {
+				gcJournalEnabled, ok = enabled.(bool)
+				if !ok {
+					panic("gcjournal's enabled config key must have a boolean value")
+				}
+			}
+		}
 	}
 
-	startUploadPurger(app, app.driver, dcontext.GetLogger(app), purgeConfig)
+	app.configureUploadPurger(purgeConfig)
+	app.startUploadPurger()
 
 	app.driver, err = applyStorageMiddleware(app.driver, config.Middleware["storage"])
 	if err != nil {
@@ -160,8 +267,10 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 
 	app.configureSecret(config)
 	app.configureEvents(config)
+	app.configureAudit(config)
 	app.configureRedis(config)
 	app.configureLogHook(config)
+	app.configureBlobCompression(config)
 
 	options := registrymiddleware.GetRegistryOptions()
 	if config.Compatibility.Schema1.TrustKey != "" {
@@ -196,6 +305,10 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.DisableDigestResumption)
 	}
 
+	if gcJournalEnabled {
+		options = append(options, storage.EnableGCJournal)
+	}
+
 	// configure deletion
 	if d, ok := config.Storage["delete"]; ok {
 		e, ok := d["enabled"]
@@ -204,6 +317,12 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 				options = append(options, storage.EnableDelete)
 			}
 		}
+		if v, ok := d["trash"]; ok {
+			app.configureTrash(v)
+			if app.trashEnabled {
+				options = append(options, storage.EnableManifestTrash)
+			}
+		}
 	}
 
 	// configure redirects
@@ -223,6 +342,33 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		options = append(options, storage.EnableRedirect)
 	}
 
+	// configure the digest algorithm
+	app.digestAlgorithm = digest.Canonical
+	if digestConfig, ok := config.Storage["digest"]; ok {
+		if v, ok := digestConfig["algorithm"]; ok {
+			algorithm, ok := v.(string)
+			if !ok {
+				panic(fmt.Sprintf("invalid type for digest algorithm: %#v", v))
+			}
+			app.digestAlgorithm = digest.Algorithm(algorithm)
+		}
+		if !app.digestAlgorithm.Available() {
+			panic(fmt.Sprintf("unsupported digest algorithm: %q", app.digestAlgorithm))
+		}
+		if app.isCache && app.digestAlgorithm != digest.Canonical {
+			// the cached content keeps the digests of the upstream
+			panic("the digest algorithm cannot be configured for a pull through cache")
+		}
+		options = append(options, storage.DigestAlgorithm(app.digestAlgorithm))
+	}
+
+	// configure the layout of the links
+	app.layoutOptions, err = LayoutOptions(config)
+	if err != nil {
+		panic(err)
+	}
+	options = append(options, app.layoutOptions...)
+
 	if !config.Validation.Enabled {
 		config.Validation.Enabled = !config.Validation.Disabled
 	}
@@ -273,10 +419,12 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			if app.redis == nil {
 				panic("redis configuration required to use for layerinfo cache")
 			}
-			if _, ok := cc["blobdescriptorsize"]; ok {
+			cacheProvider := rediscache.NewRedisBlobDescriptorCacheProvider(app.redis)
+			if l1, _ := cc["inmemoryl1"].(bool); l1 {
+				cacheProvider = cache.NewLayeredCacheProvider(newInMemoryCacheProvider(cc), cacheProvider)
+			} else if _, ok := cc["blobdescriptorsize"]; ok {
 				dcontext.GetLogger(app).Warnf("blobdescriptorsize parameter is not supported with redis cache")
 			}
-			cacheProvider := rediscache.NewRedisBlobDescriptorCacheProvider(app.redis)
 			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
 			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
 			if err != nil {
@@ -284,17 +432,7 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 			}
 			dcontext.GetLogger(app).Infof("using redis blob descriptor cache")
 		case "inmemory":
-			blobDescriptorSize := memorycache.DefaultSize
-			configuredSize, ok := cc["blobdescriptorsize"]
-			if ok {
-				// Since Parameters is not strongly typed, render to a string and convert back
-				blobDescriptorSize, err = strconv.Atoi(fmt.Sprint(configuredSize))
-				if err != nil {
-					panic(fmt.Sprintf("invalid blobdescriptorsize value %s: %s", configuredSize, err))
-				}
-			}
-
-			cacheProvider := memorycache.NewInMemoryBlobDescriptorCacheProvider(blobDescriptorSize)
+			cacheProvider := newInMemoryCacheProvider(cc)
 			localOptions := append(options, storage.BlobDescriptorCacheProvider(cacheProvider))
 			app.registry, err = storage.NewRegistry(app, app.driver, localOptions...)
 			if err != nil {
@@ -316,13 +454,28 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 	}
 
+	app.configureMetadata(config)
+
 	app.registry, err = applyRegistryMiddleware(app, app.registry, config.Middleware["registry"])
 	if err != nil {
 		panic(err)
 	}
 
+	app.configureCatalogIndex(config)
+	app.configureSearch(config)
+	app.startRetentionWorker(config)
+	app.startIntegrityWorker(config)
+	app.startLayoutWorker(config)
+	app.startTrashPurger()
+	app.configureReplication(config)
+	app.configureScan(config)
+
 	authType := config.Auth.Type()
 
+	app.adminListener = config.HTTP.Listeners.Admin.Addr != ""
+
+	var tokenServer *token.Server
+	var tokenPolicy token.Policy
 	if authType != "" && !strings.EqualFold(authType, "none") {
 		accessController, err := auth.GetAccessController(config.Auth.Type(), config.Auth.Parameters())
 		if err != nil {
@@ -330,11 +483,91 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 		}
 		app.accessController = accessController
 		dcontext.GetLogger(app).Debugf("configured %q access controller", authType)
+
+		// manage the users of the access controller through the admin api
+		if users, ok := accessController.(auth.UserManager); ok {
+			app.users = users
+		}
+
+		// serve the tokens of the built-in token server
+		if server, ok := accessController.(*token.Server); ok {
+			tokenServer = server
+			app.router.Path(strings.TrimSuffix(config.HTTP.Prefix, "/") + token.ServerPath).Handler(app.tokenServer(server))
+		}
+	}
+
+	if len(config.ACL.Rules) > 0 {
+		rules, err := acl.New(config.ACL)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure the acl: %v", err))
+		}
+		tokenPolicy = rules.Allowed
+		app.accessController = acl.NewAccessController(app.accessController, rules)
+		dcontext.GetLogger(app).Debugf("configured acl with %d rules", len(config.ACL.Rules))
+	}
+
+	if len(config.IPFilter.TrustedProxies) > 0 || len(config.IPFilter.Allow) > 0 || len(config.IPFilter.Rules) > 0 {
+		app.ipFilter, err = ipfilter.New(config.IPFilter)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure the ip filter: %v", err))
+		}
+	}
+
+	if config.RateLimit.Requests != 0 || config.RateLimit.Uploads != 0 {
+		app.rateLimiter, err = ratelimit.New(config.RateLimit)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure rate limiting: %v", err))
+		}
+	}
+
+	if config.PullTokens.Enabled {
+		if app.accessController == nil {
+			panic("pull tokens require an access controller or an acl")
+		}
+		secret := config.PullTokens.Secret
+		if secret == "" {
+			secret = config.HTTP.Secret
+		}
+		app.pullTokens, err = pulltoken.NewIssuer([]byte(secret), config.PullTokens.MaxExpiration)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure pull tokens: %v", err))
+		}
+		app.accessController = pulltoken.NewAccessController(app.accessController, app.pullTokens)
+	}
+
+	if len(config.Public.Repositories) > 0 {
+		if app.accessController == nil {
+			panic("public repositories require an access controller or an acl")
+		}
+		repositories, err := public.New(config.Public)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure the public repositories: %v", err))
+		}
+		// the token server issues anonymous tokens for the public
+		// repositories, to clients which request one before pulling
+		policy := tokenPolicy
+		tokenPolicy = func(user string, access auth.Access) bool {
+			if repositories.Allowed(access) {
+				return true
+			}
+			if policy == nil {
+				return user != ""
+			}
+			return policy(user, access)
+		}
+		app.accessController = public.NewAccessController(app.accessController, repositories)
+		dcontext.GetLogger(app).Debugf("configured %d public repository patterns", len(config.Public.Repositories))
+	}
+
+	if tokenServer != nil && tokenPolicy != nil {
+		tokenServer.SetPolicy(tokenPolicy)
 	}
 
 	// configure as a pull through cache
 	if app.isCache {
-		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy)
+		app.registry, err = proxy.NewRegistryPullThroughCache(ctx, app.registry, app.driver, config.Proxy,
+			proxy.WithEventSink(app.events.sink, app.events.source, config.Notifications.EventConfig.IncludeReferences),
+			proxy.WithRedis(app.redis))
 		if err != nil {
 			panic(err.Error())
 		}
@@ -354,6 +587,65 @@ func NewApp(ctx context.Context, config *configuration.Configuration) *App {
 	return app
 }
 
+// Shutdown finishes the background work of the registry, such as the blobs
+// a pull through cache is fetching from its upstream, until ctx is done.
+func (app *App) Shutdown(ctx context.Context) error {
+	if app.metadata != nil {
+		defer app.metadata.Close()
+	}
+	if drainer, ok := app.registry.(proxy.Drainer); ok {
+		return drainer.Drain(ctx)
+	}
+	return nil
+}
+
+// Driver returns the storage driver of the application, with the storage
+// middlewares applied.
+func (app *App) Driver() storagedriver.StorageDriver {
+	return app.driver
+}
+
+// Reload applies the rate limits of the configuration, and the credentials
+// and tag list TTL of a pull through cache, without interrupting the
+// requests in progress. The other sections of the configuration are ignored
+// until the registry restarts.
+func (app *App) Reload(config *configuration.Configuration) error {
+	if app.rateLimiter != nil {
+		if err := app.rateLimiter.Update(config.RateLimit); err != nil {
+			return err
+		}
+	} else if config.RateLimit.Requests != 0 || config.RateLimit.Uploads != 0 {
+		return fmt.Errorf("rate limiting can't be enabled without a restart")
+	}
+
+	if reloader, ok := app.registry.(proxy.Reloader); ok {
+		if err := reloader.Reload(config.Proxy); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// configureMetadata records the metadata of the registry in the configured
+// database, which then lists the tags and repositories.
+func (app *App) configureMetadata(config *configuration.Configuration) {
+	switch config.Metadata.Database {
+	case "":
+		return
+	case "postgres":
+		store, err := postgres.Open(app, config.Metadata)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure the metadata database: %v", err))
+		}
+		app.metadata = store
+	default:
+		panic(fmt.Sprintf("unsupported metadata database %q", config.Metadata.Database))
+	}
+
+	app.registry = metadata.NewRegistry(app.registry, app.metadata)
+	dcontext.GetLogger(app).Infof("using %s metadata database", config.Metadata.Database)
+}
+
 // RegisterHealthChecks is an awful hack to defer health check registration
 // control to callers. This should only ever be called once per registry
 // process, typically in a main function. The correct way would be register
@@ -383,6 +675,9 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 			}
 			return err
 		}
+		if app.Config.Health.StorageDriver.Deep {
+			storageDriverCheck = app.deepStorageDriverCheck(app.Config.Health.StorageDriver.ReadOnlyOnFailure, app.Config.Health.StorageDriver.Threshold)
+		}
 
 		if app.Config.Health.StorageDriver.Threshold != 0 {
 			healthRegistry.RegisterPeriodicThresholdFunc("storagedriver_"+app.Config.Storage.Type(), interval, app.Config.Health.StorageDriver.Threshold, storageDriverCheck)
@@ -438,6 +733,35 @@ func (app *App) RegisterHealthChecks(healthRegistries ...*health.Registry) {
 			healthRegistry.Register(tcpChecker.Addr, health.PeriodicChecker(checker, interval))
 		}
 	}
+
+	if app.Config.Health.Upstreams.Enabled {
+		upstreams, ok := app.registry.(proxy.UpstreamChecker)
+		if !ok {
+			panic("upstream health checks require a pull through cache")
+		}
+
+		config := app.Config.Health.Upstreams
+		interval := config.Interval
+		if interval == 0 {
+			interval = defaultCheckInterval
+		}
+		timeout := config.Timeout
+		if timeout == 0 {
+			timeout = defaultUpstreamCheckTimeout
+		}
+
+		// An unreachable upstream is reported without failing the health
+		// of the registry, which keeps serving the cached content.
+		for namespace, checker := range upstreams.UpstreamChecks(timeout) {
+			dcontext.GetLogger(app).Infof("configuring upstream health check namespace=%s, interval=%d", namespace, interval/time.Second)
+			if config.Threshold != 0 {
+				checker = health.PeriodicThresholdChecker(checker, interval, config.Threshold)
+			} else {
+				checker = health.PeriodicChecker(checker, interval)
+			}
+			healthRegistry.RegisterNonCritical("upstream_"+namespace, checker)
+		}
+	}
 }
 
 // register a handler with the application, by route name. The handler will be
@@ -447,7 +771,7 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 	handler := app.dispatcher(dispatch)
 
 	// Chain the handler with prometheus instrumented handler
-	if app.Config.HTTP.Debug.Prometheus.Enabled {
+	if app.Config.HTTP.Debug.Prometheus.Enabled || app.Config.HTTP.Listeners.Metrics.Addr != "" {
 		namespace := metrics.NewNamespace(prometheus.NamespacePrefix, "http", nil)
 		httpMetrics := namespace.NewDefaultHttpMetrics(strings.Replace(routeName, "-", "_", -1))
 		metrics.Register(namespace)
@@ -467,23 +791,38 @@ func (app *App) register(routeName string, dispatch dispatchFunc) {
 func (app *App) configureEvents(configuration *configuration.Configuration) {
 	// Configure all of the endpoint sinks.
 	var sinks []events.Sink
+	app.events.endpoints = make(map[string]*notifications.Endpoint)
 	for _, endpoint := range configuration.Notifications.Endpoints {
 		if endpoint.Disabled {
 			dcontext.GetLogger(app).Infof("endpoint %s disabled, skipping", endpoint.Name)
 			continue
 		}
 
-		dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
-		endpoint := notifications.NewEndpoint(endpoint.Name, endpoint.URL, notifications.EndpointConfig{
-			Timeout:           endpoint.Timeout,
-			Threshold:         endpoint.Threshold,
-			Backoff:           endpoint.Backoff,
-			Headers:           endpoint.Headers,
-			IgnoredMediaTypes: endpoint.IgnoredMediaTypes,
-			Ignore:            endpoint.Ignore,
-		})
+		switch endpoint.Type {
+		case "kafka":
+			dcontext.GetLogger(app).Infof("configuring kafka endpoint %v (%v, topic %v), timeout=%s", endpoint.Name, endpoint.Kafka.Brokers, endpoint.Kafka.Topic, endpoint.Timeout)
+		case "nats":
+			dcontext.GetLogger(app).Infof("configuring nats endpoint %v (%v, subject %v), timeout=%s", endpoint.Name, endpoint.NATS.Servers, endpoint.NATS.Subject, endpoint.Timeout)
+		default:
+			dcontext.GetLogger(app).Infof("configuring endpoint %v (%v), timeout=%s, headers=%v", endpoint.Name, endpoint.URL, endpoint.Timeout, endpoint.Headers)
+		}
+		sink, err := notifications.NewConfiguredEndpoint(endpoint)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure notification endpoint %s: %v", endpoint.Name, err))
+		}
 
-		sinks = append(sinks, endpoint)
+		sinks = append(sinks, sink)
+		app.events.endpoints[endpoint.Name] = sink
+	}
+
+	if history := configuration.Notifications.History; history.Path != "" {
+		dcontext.GetLogger(app).Infof("recording the history of the events in %s", history.Path)
+		h, err := notifications.NewHistory(history)
+		if err != nil {
+			panic(fmt.Sprintf("unable to configure event history: %v", err))
+		}
+		app.events.history = h
+		sinks = append(sinks, h)
 	}
 
 	// NOTE(stevvooe): Moving to a new queuing implementation is as easy as
@@ -510,76 +849,143 @@ func (app *App) configureEvents(configuration *configuration.Configuration) {
 	}
 }
 
+// newInMemoryCacheProvider returns the in-memory blob descriptor cache,
+// bounded by the blobdescriptorsize and blobdescriptorbytes parameters.
+func newInMemoryCacheProvider(parameters configuration.Parameters) cache.BlobDescriptorCacheProvider {
+	limit := func(name string, defaultValue int64) int64 {
+		value, ok := parameters[name]
+		if !ok {
+			return defaultValue
+		}
+		// Since Parameters is not strongly typed, render to a string and convert back
+		n, err := strconv.ParseInt(fmt.Sprint(value), 10, 64)
+		if err != nil {
+			panic(fmt.Sprintf("invalid %s value %s: %s", name, value, err))
+		}
+		return n
+	}
+
+	return memorycache.NewBoundedInMemoryBlobDescriptorCacheProvider(
+		int(limit("blobdescriptorsize", memorycache.DefaultSize)),
+		limit("blobdescriptorbytes", 0))
+}
+
 type redisStartAtKey struct{}
 
 func (app *App) configureRedis(configuration *configuration.Configuration) {
-	if configuration.Redis.Addr == "" {
+	config := configuration.Redis
+	if config.Addr == "" && len(config.Cluster.Addrs) == 0 && config.Sentinel.MasterName == "" {
 		dcontext.GetLogger(app).Infof("redis not configured")
 		return
 	}
 
-	pool := &redis.Pool{
-		Dial: func() (redis.Conn, error) {
-			// TODO(stevvooe): Yet another use case for contextual timing.
-			ctx := context.WithValue(app, redisStartAtKey{}, time.Now())
+	tlsConfig, err := redisTLSConfig(configuration)
+	if err != nil {
+		panic(fmt.Sprintf("could not configure redis tls: %v", err))
+	}
 
-			done := func(err error) {
-				logger := dcontext.GetLoggerWithField(ctx, "redis.connect.duration",
-					dcontext.Since(ctx, redisStartAtKey{}))
-				if err != nil {
-					logger.Errorf("redis: error connecting: %v", err)
-				} else {
-					logger.Infof("redis: connect %v", configuration.Redis.Addr)
-				}
-			}
+	dial := func(addr string, options ...redis.DialOption) (redis.Conn, error) {
+		// TODO(stevvooe): Yet another use case for contextual timing.
+		ctx := context.WithValue(app, redisStartAtKey{}, time.Now())
 
-			conn, err := redis.Dial("tcp",
-				configuration.Redis.Addr,
-				redis.DialConnectTimeout(configuration.Redis.DialTimeout),
-				redis.DialReadTimeout(configuration.Redis.ReadTimeout),
-				redis.DialWriteTimeout(configuration.Redis.WriteTimeout),
-				redis.DialUseTLS(configuration.Redis.TLS.Enabled))
+		done := func(err error) {
+			logger := dcontext.GetLoggerWithField(ctx, "redis.connect.duration",
+				dcontext.Since(ctx, redisStartAtKey{}))
 			if err != nil {
-				dcontext.GetLogger(app).Errorf("error connecting to redis instance %s: %v",
-					configuration.Redis.Addr, err)
-				done(err)
-				return nil, err
+				logger.Errorf("redis: error connecting: %v", err)
+			} else {
+				logger.Infof("redis: connect %v", addr)
 			}
+		}
 
-			// authorize the connection
-			if configuration.Redis.Password != "" {
-				if _, err = conn.Do("AUTH", configuration.Redis.Password); err != nil {
-					defer conn.Close()
-					done(err)
-					return nil, err
-				}
-			}
+		options = append([]redis.DialOption{
+			redis.DialConnectTimeout(config.DialTimeout),
+			redis.DialReadTimeout(config.ReadTimeout),
+			redis.DialWriteTimeout(config.WriteTimeout),
+			redis.DialUseTLS(config.TLS.Enabled),
+			redis.DialTLSConfig(tlsConfig),
+		}, options...)
+
+		// the connection is authorized and the database selected when
+		// dialing
+		conn, err := redis.Dial("tcp", addr, options...)
+		if err != nil {
+			dcontext.GetLogger(app).Errorf("error connecting to redis instance %s: %v",
+				addr, err)
+			done(err)
+			return nil, err
+		}
 
-			// select the database to use
-			if configuration.Redis.DB != 0 {
-				if _, err = conn.Do("SELECT", configuration.Redis.DB); err != nil {
-					defer conn.Close()
-					done(err)
-					return nil, err
-				}
-			}
+		done(nil)
+		return conn, nil
+	}
+	dialServer := func(addr string) (redis.Conn, error) {
+		return dial(addr,
+			redis.DialUsername(config.Username),
+			redis.DialPassword(config.Password),
+			redis.DialDatabase(config.DB))
+	}
 
-			done(nil)
-			return conn, nil
-		},
-		MaxIdle:     configuration.Redis.Pool.MaxIdle,
-		MaxActive:   configuration.Redis.Pool.MaxActive,
-		IdleTimeout: configuration.Redis.Pool.IdleTimeout,
-		TestOnBorrow: func(c redis.Conn, t time.Time) error {
-			// TODO(stevvooe): We can probably do something more interesting
-			// here with the health package.
-			_, err := c.Do("PING")
-			return err
-		},
-		Wait: false, // if a connection is not available, proceed without cache.
+	newPool := func(dial func() (redis.Conn, error)) *redis.Pool {
+		return &redis.Pool{
+			Dial:            dial,
+			MaxIdle:         config.Pool.MaxIdle,
+			MaxActive:       config.Pool.MaxActive,
+			IdleTimeout:     config.Pool.IdleTimeout,
+			MaxConnLifetime: config.Pool.MaxConnLifetime,
+			TestOnBorrow: func(c redis.Conn, t time.Time) error {
+				// TODO(stevvooe): We can probably do something more interesting
+				// here with the health package.
+				_, err := c.Do("PING")
+				return err
+			},
+			Wait: config.Pool.Wait, // unless set, if a connection is not available, proceed without cache.
+		}
 	}
 
-	app.redis = pool
+	var activeCount func() int
+	switch {
+	case len(config.Cluster.Addrs) > 0:
+		if config.DB != 0 {
+			panic("redis cluster only supports database 0")
+		}
+		cluster := rediscache.NewCluster(config.Cluster.Addrs, config.Cluster.MaxRedirects, func(addr string) *redis.Pool {
+			return newPool(func() (redis.Conn, error) {
+				return dialServer(addr)
+			})
+		})
+		// Commands are routed to the pools of the nodes, so the connections
+		// of this pool hold no state
+		app.redis = &redis.Pool{
+			Dial:    cluster.Dial,
+			MaxIdle: config.Pool.MaxIdle,
+		}
+		activeCount = cluster.ActiveCount
+	case config.Sentinel.MasterName != "":
+		dialSentinel := func(addr string) (redis.Conn, error) {
+			return dial(addr,
+				redis.DialUsername(config.Sentinel.Username),
+				redis.DialPassword(config.Sentinel.Password))
+		}
+		app.redis = newPool(func() (redis.Conn, error) {
+			master, err := rediscache.SentinelMaster(config.Sentinel.MasterName, config.Sentinel.Addrs, dialSentinel)
+			if err != nil {
+				dcontext.GetLogger(app).Errorf("error finding redis master: %v", err)
+				return nil, err
+			}
+			return dialServer(master)
+		})
+		// Connections to a former master are closed after a failover
+		app.redis.TestOnBorrow = func(c redis.Conn, t time.Time) error {
+			return rediscache.CheckMaster(c)
+		}
+		activeCount = app.redis.ActiveCount
+	default:
+		app.redis = newPool(func() (redis.Conn, error) {
+			return dialServer(config.Addr)
+		})
+		activeCount = app.redis.ActiveCount
+	}
 
 	// setup expvar
 	registry := expvar.Get("registry")
@@ -590,11 +996,43 @@ func (app *App) configureRedis(configuration *configuration.Configuration) {
 	registry.(*expvar.Map).Set("redis", expvar.Func(func() interface{} {
 		return map[string]interface{}{
 			"Config": configuration.Redis,
-			"Active": app.redis.ActiveCount(),
+			"Active": activeCount(),
 		}
 	}))
 }
 
+// redisTLSConfig returns the TLS configuration of the connections to redis,
+// or nil to use the defaults.
+func redisTLSConfig(configuration *configuration.Configuration) (*tls.Config, error) {
+	config := configuration.Redis.TLS
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	tlsConfig := &tls.Config{
+		ServerName:         config.ServerName,
+		InsecureSkipVerify: config.InsecureSkipVerify,
+	}
+	if config.CA != "" {
+		pem, err := os.ReadFile(config.CA)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", config.CA)
+		}
+	}
+	if config.Certificate != "" || config.Key != "" {
+		certificate, err := tls.LoadX509KeyPair(config.Certificate, config.Key)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{certificate}
+	}
+	return tlsConfig, nil
+}
+
 // configureLogHook prepares logging hook parameters.
 func (app *App) configureLogHook(configuration *configuration.Configuration) {
 	entry, ok := dcontext.GetLogger(app).(*logrus.Entry)
@@ -644,10 +1082,23 @@ func (app *App) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 
 	// Prepare the context with our own little decorations.
 	ctx := r.Context()
+	ctx, span := tracing.StartRequest(ctx, r)
 	ctx = dcontext.WithRequest(ctx, r)
+	accesslog.Annotate(ctx, accesslog.FieldRequestID, dcontext.GetRequestID(ctx))
+	accesslog.Annotate(ctx, accesslog.FieldTraceID, span.TraceID())
 	ctx, w = dcontext.WithResponseWriter(ctx, w)
 	ctx = dcontext.WithLogger(ctx, dcontext.GetRequestLogger(ctx))
 	r = r.WithContext(ctx)
+	if span != nil {
+		defer func() {
+			status, _ := ctx.Value("http.response.status").(int)
+			if status == 0 {
+				// nothing was written, which the server answers with 200
+				status = http.StatusOK
+			}
+			tracing.EndRequest(span, status)
+		}()
+	}
 
 	// Set a header with the Docker Distribution API Version for all responses.
 	w.Header().Add("Docker-Distribution-API-Version", "registry/2.0")
@@ -667,13 +1118,25 @@ type dispatchFunc func(ctx *Context, r *http.Request) http.Handler
 // handler, using the dispatch factory function.
 func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		for headerName, headerValues := range app.Config.HTTP.Headers {
-			for _, value := range headerValues {
-				w.Header().Add(headerName, value)
+		app.addHeaders(w)
+		context := app.context(w, r)
+
+		if span := tracing.SpanFromContext(context); span != nil {
+			if route := mux.CurrentRoute(r); route != nil {
+				span.SetName(r.Method + " " + route.GetName())
+				span.SetAttributes(tracing.String("registry.route", route.GetName()))
+			}
+			if app.nameRequired(r) {
+				span.SetAttributes(tracing.String("registry.repository", getName(context)))
 			}
 		}
 
-		context := app.context(w, r)
+		// record the request once the errors are served
+		if app.auditor != nil {
+			body := &auditBody{ReadCloser: r.Body}
+			r.Body = body
+			defer app.auditor.record(context, r, body)
+		}
 
 		defer func() {
 			// Automated error response handling here. Handlers may return their
@@ -687,6 +1150,30 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 			}
 		}()
 
+		// restrict the networks the registry and repositories can be reached
+		// from, whatever the credentials of the client
+		if app.ipFilter != nil {
+			repositories := []string{""}
+			if app.nameRequired(r) {
+				repositories = []string{getName(context)}
+				// mounting a blob reads it from the source repository
+				if fromRepo := r.FormValue("from"); fromRepo != "" {
+					repositories = append(repositories, fromRepo)
+				}
+			}
+			for _, repository := range repositories {
+				if ip, ok := app.ipFilter.Allowed(r, repository); !ok {
+					dcontext.GetLogger(context).Warnf("denying request from %s", ip)
+					context.Errors = append(context.Errors, errcode.ErrorCodeDenied.WithDetail("client network not allowed"))
+					return
+				}
+			}
+		}
+
+		if app.rateLimiter != nil && !app.rateLimitIP(w, r, context) {
+			return
+		}
+
 		if err := app.authorized(w, r, context); err != nil {
 			dcontext.GetLogger(context).Warnf("error authorizing context: %v", err)
 			return
@@ -694,6 +1181,15 @@ func (app *App) dispatcher(dispatch dispatchFunc) http.Handler {
 
 		// Add username to request logging
 		context.Context = dcontext.WithLogger(context.Context, dcontext.GetLogger(context.Context, auth.UserNameKey))
+		accesslog.Annotate(context, accesslog.FieldIdentity, dcontext.GetStringValue(context, auth.UserNameKey))
+
+		if app.rateLimiter != nil {
+			release, ok := app.rateLimit(w, r, context)
+			if !ok {
+				return
+			}
+			defer release()
+		}
 
 		// sync up context on the request.
 		r = r.WithContext(context)
@@ -791,6 +1287,42 @@ func (app *App) logError(ctx context.Context, errors errcode.Errors) {
 	}
 }
 
+// addHeaders adds the configured headers to the response.
+func (app *App) addHeaders(w http.ResponseWriter) {
+	for headerName, headerValues := range app.Config.HTTP.Headers {
+		for _, value := range headerValues {
+			w.Header().Add(headerName, value)
+		}
+	}
+}
+
+// tokenServer restricts the networks the built-in token server can be
+// reached from, and limits the rate of its requests by the address of the
+// client, as the dispatcher does for the requests of the registry, so that
+// the credentials cannot be guessed from outside of the allowed networks or
+// faster than the registry requests are limited to.
+func (app *App) tokenServer(server http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		app.addHeaders(w)
+		context := app.context(w, r)
+		if app.ipFilter != nil {
+			if ip, ok := app.ipFilter.Allowed(r, ""); !ok {
+				dcontext.GetLogger(context).Warnf("denying token request from %s", ip)
+				context.Errors = append(context.Errors, errcode.ErrorCodeDenied.WithDetail("client network not allowed"))
+			}
+		}
+		if context.Errors.Len() == 0 && app.rateLimiter != nil {
+			app.rateLimitIP(w, r, context)
+		}
+		if context.Errors.Len() > 0 {
+			_ = errcode.ServeJSON(w, context.Errors)
+			app.logError(context, context.Errors)
+			return
+		}
+		server.ServeHTTP(w, r)
+	})
+}
+
 // context constructs the context object for the application. This only be
 // called once per request.
 func (app *App) context(w http.ResponseWriter, r *http.Request) *Context {
@@ -827,6 +1359,13 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 	repo := getName(context)
 
 	if app.accessController == nil {
+		// Without authentication, the admin endpoints are only served by
+		// the admin listener, which the API listener does not serve them
+		// from.
+		if isAdminRoute(r) && !app.adminListener {
+			http.NotFound(w, r)
+			return fmt.Errorf("admin endpoints require authentication or the admin listener")
+		}
 		return nil // access controller is not enabled.
 	}
 
@@ -854,10 +1393,21 @@ func (app *App) authorized(w http.ResponseWriter, r *http.Request, context *Cont
 			return fmt.Errorf("forbidden: no repository name")
 		}
 		accessRecords = appendCatalogAccessRecord(accessRecords, r)
+		accessRecords = appendProxyAccessRecord(accessRecords, r)
+		accessRecords = appendReplicationAccessRecord(accessRecords, r)
+		accessRecords = appendAdminAccessRecord(accessRecords, r)
 	}
 
 	ctx, err := app.accessController.Authorized(context.Context, accessRecords...)
 	if err != nil {
+		if errors.Is(err, auth.ErrAccessDenied) {
+			dcontext.GetLogger(context).Infof("denied request: %v", err)
+			if err := errcode.ServeJSON(w, errcode.ErrorCodeDenied.WithDetail(accessRecords)); err != nil {
+				dcontext.GetLogger(context).Errorf("error serving error json: %v (from %v)", err, context.Errors)
+			}
+			return err
+		}
+
 		switch err := err.(type) {
 		case auth.Challenge:
 			// Add the appropriate WWW-Auth header
@@ -904,7 +1454,12 @@ func (app *App) nameRequired(r *http.Request) bool {
 		return true
 	}
 	routeName := route.GetName()
-	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog
+	return routeName != v2.RouteNameBase && routeName != v2.RouteNameCatalog && routeName != v2.RouteNameSearch &&
+		routeName != v2.RouteNameProxyStats && routeName != v2.RouteNameProxyNamespaces &&
+		routeName != v2.RouteNameReplicationStatus && routeName != v2.RouteNameAdminReadOnly &&
+		routeName != v2.RouteNameAdminTrash && routeName != v2.RouteNameAdminUsers &&
+		routeName != v2.RouteNameAdminTokens && routeName != v2.RouteNameAdminEvents &&
+		routeName != v2.RouteNameAdminUploadPurging
 }
 
 // apiBase implements a simple yes-man for doing overall checks against the
@@ -952,12 +1507,13 @@ func appendAccessRecords(records []auth.Access, method string, repo string) []au
 	return records
 }
 
-// Add the access record for the catalog if it's our current route
+// Add the access record for the catalog if it's our current route. Searching
+// the repositories requires the same access as listing them.
 func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
 	route := mux.CurrentRoute(r)
 	routeName := route.GetName()
 
-	if routeName == v2.RouteNameCatalog {
+	if routeName == v2.RouteNameCatalog || routeName == v2.RouteNameSearch {
 		resource := auth.Resource{
 			Type: "registry",
 			Name: "catalog",
@@ -972,6 +1528,80 @@ func appendCatalogAccessRecord(accessRecords []auth.Access, r *http.Request) []a
 	return accessRecords
 }
 
+// appendProxyAccessRecord adds the access record required to read the
+// statistics and upstreams of a pull through cache.
+func appendProxyAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	route := mux.CurrentRoute(r)
+	routeName := route.GetName()
+
+	if routeName == v2.RouteNameProxyStats || routeName == v2.RouteNameProxyNamespaces {
+		resource := auth.Resource{
+			Type: "registry",
+			Name: "proxy",
+		}
+
+		accessRecords = append(accessRecords,
+			auth.Access{
+				Resource: resource,
+				Action:   "*",
+			})
+	}
+	return accessRecords
+}
+
+// appendReplicationAccessRecord adds the access record required to read the
+// state of the replications to downstream registries.
+func appendReplicationAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	route := mux.CurrentRoute(r)
+	routeName := route.GetName()
+
+	if routeName == v2.RouteNameReplicationStatus {
+		resource := auth.Resource{
+			Type: "registry",
+			Name: "replication",
+		}
+
+		accessRecords = append(accessRecords,
+			auth.Access{
+				Resource: resource,
+				Action:   "*",
+			})
+	}
+	return accessRecords
+}
+
+// appendAdminAccessRecord adds the access record required to use the
+// administration endpoints.
+func appendAdminAccessRecord(accessRecords []auth.Access, r *http.Request) []auth.Access {
+	if isAdminRoute(r) {
+		resource := auth.Resource{
+			Type: "registry",
+			Name: "admin",
+		}
+
+		accessRecords = append(accessRecords,
+			auth.Access{
+				Resource: resource,
+				Action:   "*",
+			})
+	}
+	return accessRecords
+}
+
+// isAdminRoute reports whether the request is to one of the administration
+// endpoints.
+func isAdminRoute(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	switch route.GetName() {
+	case v2.RouteNameAdminReadOnly, v2.RouteNameAdminTrash, v2.RouteNameAdminUsers, v2.RouteNameAdminTokens, v2.RouteNameAdminEvents, v2.RouteNameAdminUploadPurging:
+		return true
+	}
+	return false
+}
+
 // applyRegistryMiddleware wraps a registry instance with the configured middlewares
 func applyRegistryMiddleware(ctx context.Context, registry distribution.Namespace, middlewares []configuration.Middleware) (distribution.Namespace, error) {
 	for _, mw := range middlewares {
@@ -1023,72 +1653,3 @@ func uploadPurgeDefaultConfig() map[interface{}]interface{} {
 func badPurgeUploadConfig(reason string) {
 	panic(fmt.Sprintf("Unable to parse upload purge configuration: %s", reason))
 }
-
-// startUploadPurger schedules a goroutine which will periodically
-// check upload directories for old files and delete them
-func startUploadPurger(ctx context.Context, storageDriver storagedriver.StorageDriver, log dcontext.Logger, config map[interface{}]interface{}) {
-	if config["enabled"] == false {
-		return
-	}
-
-	var purgeAgeDuration time.Duration
-	var err error
-	purgeAge, ok := config["age"]
-	if ok {
-		ageStr, ok := purgeAge.(string)
-		if !ok {
-			badPurgeUploadConfig("age is not a string")
-		}
-		purgeAgeDuration, err = time.ParseDuration(ageStr)
-		if err != nil {
-			badPurgeUploadConfig(fmt.Sprintf("Cannot parse duration: %s", err.Error()))
-		}
-	} else {
-		badPurgeUploadConfig("age missing")
-	}
-
-	var intervalDuration time.Duration
-	interval, ok := config["interval"]
-	if ok {
-		intervalStr, ok := interval.(string)
-		if !ok {
-			badPurgeUploadConfig("interval is not a string")
-		}
-
-		intervalDuration, err = time.ParseDuration(intervalStr)
-		if err != nil {
-			badPurgeUploadConfig(fmt.Sprintf("Cannot parse interval: %s", err.Error()))
-		}
-	} else {
-		badPurgeUploadConfig("interval missing")
-	}
-
-	var dryRunBool bool
-	dryRun, ok := config["dryrun"]
-	if ok {
-		dryRunBool, ok = dryRun.(bool)
-		if !ok {
-			badPurgeUploadConfig("cannot parse dryrun")
-		}
-	} else {
-		badPurgeUploadConfig("dryrun missing")
-	}
-
-	go func() {
-		randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
-		if err != nil {
-			log.Infof("Failed to generate random jitter: %v", err)
-			// sleep 30min for failure case
-			randInt = big.NewInt(30)
-		}
-		jitter := time.Duration(randInt.Int64()%60) * time.Minute
-		log.Infof("Starting upload purge in %s", jitter)
-		time.Sleep(jitter)
-
-		for {
-			storage.PurgeUploads(ctx, storageDriver, time.Now().Add(-purgeAgeDuration), !dryRunBool)
-			log.Infof("Starting upload purge in %s", intervalDuration)
-			time.Sleep(intervalDuration)
-		}
-	}()
-}
@@ -0,0 +1,141 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/uuid"
+	"github.com/gorilla/mux"
+	"github.com/opencontainers/go-digest"
+)
+
+// auditor records each request to the API as an audit event, written as a
+// JSON line and, if enabled, sent to the notification endpoints.
+type auditor struct {
+	mu     sync.Mutex
+	enc    *json.Encoder
+	events bool
+}
+
+// configureAudit opens the output of the audit log, if enabled.
+func (app *App) configureAudit(config *configuration.Configuration) {
+	if !config.Audit.Enabled {
+		return
+	}
+
+	var w io.Writer = os.Stdout
+	if config.Audit.Path != "" {
+		f, err := os.OpenFile(config.Audit.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			panic(fmt.Sprintf("unable to open the audit log: %v", err))
+		}
+		w = f
+	}
+	app.auditor = &auditor{
+		enc:    json.NewEncoder(w),
+		events: config.Audit.Events,
+	}
+}
+
+// auditBody counts the bytes read from the body of an audited request.
+type auditBody struct {
+	io.ReadCloser
+	read int64
+}
+
+func (b *auditBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	return n, err
+}
+
+// record records the request, once it was served.
+func (au *auditor) record(ctx *Context, r *http.Request, body *auditBody) {
+	startedAt, _ := ctx.Value("http.request.startedat").(time.Time)
+	event := &notifications.Event{
+		ID:        uuid.Generate().String(),
+		Timestamp: startedAt,
+		Action:    notifications.EventActionAudit,
+		Request:   notifications.NewRequestRecord(dcontext.GetRequestID(ctx), r),
+		Actor: notifications.ActorRecord{
+			Name: dcontext.GetStringValue(ctx, auth.UserNameKey),
+		},
+		Source: ctx.App.events.source,
+	}
+
+	record := &notifications.AuditRecord{
+		Received: body.read,
+		Duration: dcontext.Since(ctx, "http.request.startedat").Seconds(),
+	}
+	if route := mux.CurrentRoute(r); route != nil {
+		record.Route = route.GetName()
+	}
+	record.Status, _ = ctx.Value("http.response.status").(int)
+	if record.Status == 0 {
+		// nothing was written, which the server answers with 200
+		record.Status = http.StatusOK
+	}
+	record.Written, _ = ctx.Value("http.response.written").(int64)
+	if len(ctx.Errors) > 0 {
+		switch err := ctx.Errors[0].(type) {
+		case errcode.Error:
+			record.Error = err.Code.String()
+		case errcode.ErrorCode:
+			record.Error = err.String()
+		default:
+			record.Error = errcode.ErrorCodeUnknown.String()
+		}
+	}
+
+	write := r.Method != http.MethodGet && r.Method != http.MethodHead
+	if ctx.App.nameRequired(r) {
+		event.Target.Repository = getName(ctx)
+		switch {
+		case r.Method == http.MethodDelete:
+			record.Action = notifications.EventActionDelete
+		case write:
+			record.Action = notifications.EventActionPush
+		default:
+			record.Action = notifications.EventActionPull
+		}
+	} else if write {
+		record.Action = "write"
+	} else {
+		record.Action = "read"
+	}
+
+	if ref := getReference(ctx); ref != "" {
+		if dgst, err := digest.Parse(ref); err == nil {
+			event.Target.Digest = dgst
+		} else {
+			event.Target.Tag = ref
+		}
+	}
+	if dgst, err := getDigest(ctx); err == nil {
+		event.Target.Digest = dgst
+	}
+	event.Audit = record
+
+	au.mu.Lock()
+	err := au.enc.Encode(event)
+	au.mu.Unlock()
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error writing audit record: %v", err)
+	}
+
+	if au.events {
+		if err := ctx.App.events.sink.Write(*event); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error sending audit event: %v", err)
+		}
+	}
+}
@@ -37,7 +37,7 @@ func blobDispatcher(ctx *Context, r *http.Request) http.Handler {
 		http.MethodHead: http.HandlerFunc(blobHandler.GetBlob),
 	}
 
-	if !ctx.readOnly {
+	if !ctx.isReadOnly() {
 		mhandler[http.MethodDelete] = http.HandlerFunc(blobHandler.DeleteBlob)
 	}
 
@@ -66,7 +66,16 @@ func (bh *blobHandler) GetBlob(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if cw := bh.App.blobCompression.writer(w, r); cw != nil {
+		defer cw.Close()
+		w = cw
+	}
+
 	if err := blobs.ServeBlob(bh, w, r, desc.Digest); err != nil {
+		if e, ok := err.(errcode.Error); ok {
+			bh.Errors = append(bh.Errors, e)
+			return
+		}
 		context.GetLogger(bh).Debugf("unexpected error getting blob HTTP handler: %v", err)
 		bh.Errors = append(bh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
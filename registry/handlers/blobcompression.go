@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/http"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3/configuration"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/klauspost/compress/zstd"
+)
+
+const defaultCompressionMinSize = 1024
+
+// blobCompressionBytes counts the bytes of the blobs compressed, before and
+// after compression
+var blobCompressionBytes = prometheus.StorageNamespace.NewLabeledCounter("blob_compression_bytes", "The number of bytes of the blobs compressed for the clients, before and after compression", "encoding", "type")
+
+// compressedMagics start the content which is already compressed, and served
+// as it is: gzip, zstd, bzip2 and xz.
+var compressedMagics = [][]byte{
+	{0x1f, 0x8b},
+	{0x28, 0xb5, 0x2f, 0xfd},
+	{0x42, 0x5a, 0x68},
+	{0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00},
+}
+
+// encoder is a compressor which can be reused once closed.
+type encoder interface {
+	io.WriteCloser
+	Reset(w io.Writer)
+}
+
+// blobCompression compresses the blobs served to the clients accepting it.
+// A nil value compresses nothing.
+type blobCompression struct {
+	encodings []string // in order of preference
+	minSize   int64
+	slots     chan struct{} // bounds the blobs compressed at once
+	encoders  map[string]*sync.Pool
+}
+
+// configureBlobCompression configures the compression of the blobs served.
+func (app *App) configureBlobCompression(config *configuration.Configuration) {
+	bc, err := newBlobCompression(config.HTTP.Compression)
+	if err != nil {
+		panic(fmt.Sprintf("invalid http compression configuration: %v", err))
+	}
+	app.blobCompression = bc
+}
+
+// newBlobCompression returns the blob compression configured, nil if it is
+// disabled.
+func newBlobCompression(config configuration.Compression) (*blobCompression, error) {
+	if len(config.Encodings) == 0 {
+		return nil, nil
+	}
+
+	zstdLevel := zstd.SpeedDefault
+	gzipLevel := gzip.DefaultCompression
+	switch config.Level {
+	case "", "default":
+	case "fastest":
+		zstdLevel, gzipLevel = zstd.SpeedFastest, gzip.BestSpeed
+	case "better":
+		zstdLevel, gzipLevel = zstd.SpeedBetterCompression, 7
+	case "best":
+		zstdLevel, gzipLevel = zstd.SpeedBestCompression, gzip.BestCompression
+	default:
+		return nil, fmt.Errorf("unknown level %q", config.Level)
+	}
+	if config.MinSize < 0 {
+		return nil, fmt.Errorf("minsize must not be negative")
+	}
+	if config.Concurrency < 0 {
+		return nil, fmt.Errorf("concurrency must not be negative")
+	}
+
+	bc := &blobCompression{
+		minSize:  config.MinSize,
+		encoders: make(map[string]*sync.Pool),
+	}
+	if bc.minSize == 0 {
+		bc.minSize = defaultCompressionMinSize
+	}
+	concurrency := config.Concurrency
+	if concurrency == 0 {
+		concurrency = runtime.NumCPU()
+	}
+	bc.slots = make(chan struct{}, concurrency)
+
+	for _, encoding := range config.Encodings {
+		if _, ok := bc.encoders[encoding]; ok {
+			return nil, fmt.Errorf("duplicate encoding %q", encoding)
+		}
+		switch encoding {
+		case "zstd":
+			bc.encoders[encoding] = &sync.Pool{New: func() interface{} {
+				// the options are valid, so that there is no error
+				enc, _ := zstd.NewWriter(nil, zstd.WithEncoderLevel(zstdLevel), zstd.WithEncoderConcurrency(1))
+				return enc
+			}}
+		case "gzip":
+			bc.encoders[encoding] = &sync.Pool{New: func() interface{} {
+				enc, _ := gzip.NewWriterLevel(nil, gzipLevel)
+				return enc
+			}}
+		default:
+			return nil, fmt.Errorf("unsupported encoding %q", encoding)
+		}
+		bc.encodings = append(bc.encodings, encoding)
+	}
+	return bc, nil
+}
+
+// negotiate returns the encoding of the response to the request, the
+// encoding accepted with the highest quality, "" if none is.
+func (bc *blobCompression) negotiate(r *http.Request) string {
+	accepted := make(map[string]float64)
+	for _, value := range r.Header.Values("Accept-Encoding") {
+		for _, part := range strings.Split(value, ",") {
+			coding, params, _ := strings.Cut(part, ";")
+			q := 1.0
+			for _, param := range strings.Split(params, ";") {
+				name, value, _ := strings.Cut(strings.TrimSpace(param), "=")
+				if strings.EqualFold(name, "q") {
+					var err error
+					if q, err = strconv.ParseFloat(value, 64); err != nil {
+						q = 0
+					}
+				}
+			}
+			accepted[strings.ToLower(strings.TrimSpace(coding))] = q
+		}
+	}
+
+	var best string
+	var bestQ float64
+	for _, encoding := range bc.encodings {
+		q, ok := accepted[encoding]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = encoding, q
+		}
+	}
+	return best
+}
+
+// writer returns a response writer compressing the blob served in response
+// to the request, if it is worth it, nil if the blob is served as it is.
+// The writer must be closed once the blob is served.
+func (bc *blobCompression) writer(w http.ResponseWriter, r *http.Request) *compressingResponseWriter {
+	if bc == nil || r.Method != http.MethodGet {
+		return nil
+	}
+	w.Header().Add("Vary", "Accept-Encoding")
+	if r.Header.Get("Range") != "" {
+		return nil
+	}
+	encoding := bc.negotiate(r)
+	if encoding == "" {
+		return nil
+	}
+	return &compressingResponseWriter{
+		ResponseWriter: w,
+		bc:             bc,
+		encoding:       encoding,
+	}
+}
+
+// compressible reports whether the blob, whose content starts with p, is
+// worth compressing.
+func (bc *blobCompression) compressible(header http.Header, p []byte) bool {
+	if size, err := strconv.ParseInt(header.Get("Content-Length"), 10, 64); err == nil && size < bc.minSize {
+		return false
+	}
+	if header.Get("Content-Encoding") != "" {
+		return false
+	}
+	for _, magic := range compressedMagics {
+		if bytes.HasPrefix(p, magic) {
+			return false
+		}
+	}
+	return true
+}
+
+// compressingResponseWriter compresses the body of a successful response
+// with its encoding. The status of the response is held until the start of
+// the body, which decides whether it is compressed.
+type compressingResponseWriter struct {
+	http.ResponseWriter
+	bc       *blobCompression
+	encoding string
+
+	pending     bool // the status is held
+	wroteHeader bool
+	encoder     encoder // nil while the body is not compressed
+	out         countingWriter
+	in          int64
+}
+
+func (cw *compressingResponseWriter) WriteHeader(code int) {
+	if cw.wroteHeader || cw.pending {
+		return
+	}
+	if code != http.StatusOK {
+		cw.wroteHeader = true
+		cw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	cw.pending = true
+}
+
+func (cw *compressingResponseWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.start(p)
+	}
+	if cw.encoder == nil {
+		return cw.ResponseWriter.Write(p)
+	}
+	n, err := cw.encoder.Write(p)
+	cw.in += int64(n)
+	return n, err
+}
+
+// start writes the status held, and starts compressing the body, starting
+// with p, if it is worth it and the compression budget allows it.
+func (cw *compressingResponseWriter) start(p []byte) {
+	cw.wroteHeader = true
+	header := cw.Header()
+	if cw.bc.compressible(header, p) {
+		select {
+		case cw.bc.slots <- struct{}{}:
+			header.Del("Content-Length")
+			header.Del("Accept-Ranges")
+			header.Set("Content-Encoding", cw.encoding)
+			if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+				// the compressed body is not the content of the blob
+				header.Set("ETag", "W/"+etag)
+			}
+			cw.out.w = cw.ResponseWriter
+			cw.encoder = cw.bc.encoders[cw.encoding].Get().(encoder)
+			cw.encoder.Reset(&cw.out)
+		default:
+			// serve the blob as it is rather than exceed the budget
+		}
+	}
+	cw.ResponseWriter.WriteHeader(http.StatusOK)
+}
+
+// Close writes the status still held, and ends the compressed body.
+func (cw *compressingResponseWriter) Close() error {
+	if !cw.wroteHeader {
+		if cw.pending {
+			cw.wroteHeader = true
+			cw.ResponseWriter.WriteHeader(http.StatusOK)
+		}
+		return nil
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+
+	err := cw.encoder.Close()
+	cw.encoder.Reset(nil)
+	cw.bc.encoders[cw.encoding].Put(cw.encoder)
+	cw.encoder = nil
+	<-cw.bc.slots
+
+	blobCompressionBytes.WithValues(cw.encoding, "uncompressed").Inc(float64(cw.in))
+	blobCompressionBytes.WithValues(cw.encoding, "compressed").Inc(float64(cw.out.n))
+	return err
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -1,10 +1,12 @@
 package handlers
 
 import (
+	"expvar"
 	"fmt"
 	"net/http"
 	"net/url"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
@@ -29,7 +31,7 @@ func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
 		http.MethodHead: http.HandlerFunc(buh.GetUploadStatus),
 	}
 
-	if !ctx.readOnly {
+	if !ctx.isReadOnly() {
 		handler[http.MethodPost] = http.HandlerFunc(buh.StartBlobUpload)
 		handler[http.MethodPatch] = http.HandlerFunc(buh.PatchBlobData)
 		handler[http.MethodPut] = http.HandlerFunc(buh.PutBlobUploadComplete)
@@ -43,10 +45,34 @@ func blobUploadDispatcher(ctx *Context, r *http.Request) http.Handler {
 		if h := buh.ResumeBlobUpload(ctx, r); h != nil {
 			return h
 		}
-		return closeResources(handler, buh.Upload)
+		return trackUpload(closeResources(handler, buh.Upload))
 	}
 
-	return handler
+	return trackUpload(handler)
+}
+
+// activeUploads is the number of requests writing to upload sessions, made
+// available via expvar to diagnose stuck uploads.
+var activeUploads int64
+
+func init() {
+	registry := expvar.Get("registry")
+	if registry == nil {
+		registry = expvar.NewMap("registry")
+	}
+
+	registry.(*expvar.Map).Set("uploads", expvar.Func(func() interface{} {
+		return map[string]int64{"active": atomic.LoadInt64(&activeUploads)}
+	}))
+}
+
+// trackUpload counts the requests served by h in activeUploads.
+func trackUpload(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt64(&activeUploads, 1)
+		defer atomic.AddInt64(&activeUploads, -1)
+		h.ServeHTTP(w, r)
+	})
 }
 
 // blobUploadHandler handles the http blob upload process.
@@ -1,21 +1,65 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
 	"strconv"
+	"strings"
+	"time"
 
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/catalog"
+	"github.com/distribution/distribution/v3/registry/proxy"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	events "github.com/docker/go-events"
 	"github.com/gorilla/handlers"
 )
 
 const defaultReturnedEntries = 100
 
+// configureCatalogIndex builds the catalog index in the background, and
+// keeps it up to date with the events of the registry. Like the search
+// index, it must be called before the registry is configured as a pull
+// through cache.
+func (app *App) configureCatalogIndex(config *configuration.Configuration) {
+	if !config.Catalog.Index.Enabled {
+		return
+	}
+
+	index := catalog.NewIndex(app.registry)
+	app.catalogIndex = index
+	app.events.sink = events.NewBroadcaster(app.events.sink, index)
+
+	go func() {
+		log := dcontext.GetLogger(app)
+		for {
+			if err := index.Rebuild(app); err != nil {
+				log.Errorf("failed to build the catalog index: %v", err)
+			}
+			if config.Catalog.Index.RefreshInterval <= 0 && index.Ready() {
+				return
+			}
+			interval := config.Catalog.Index.RefreshInterval
+			if interval <= 0 {
+				// Retry until the index is built once
+				interval = time.Minute
+			}
+			select {
+			case <-app.Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+	}()
+}
+
 func catalogDispatcher(ctx *Context, r *http.Request) http.Handler {
 	catalogHandler := &catalogHandler{
 		Context: ctx,
@@ -32,13 +76,41 @@ type catalogHandler struct {
 
 type catalogAPIResponse struct {
 	Repositories []string `json:"repositories"`
+
+	// Count is the number of repositories matching the prefix, returned
+	// when the catalog is served from the index
+	Count *int `json:"count,omitempty"`
 }
 
-func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
-	moreEntries := true
+// groupedCatalogAPIResponse lists the repositories of a pull through cache
+// by upstream host.
+type groupedCatalogAPIResponse struct {
+	Namespaces map[string][]string `json:"namespaces"`
+}
 
+// namespaceLister returns the upstreams of the registry, when it is a pull
+// through cache configured to group its catalog by upstream.
+func (ch *catalogHandler) namespaceLister() (proxy.NamespaceLister, bool) {
+	if !ch.App.Config.Proxy.EnableNamespaces || !ch.App.Config.Proxy.GroupCatalog {
+		return nil, false
+	}
+	lister, ok := ch.App.registry.(proxy.NamespaceLister)
+	return lister, ok
+}
+
+func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 	q := r.URL.Query()
 	lastEntry := q.Get("last")
+	prefix := q.Get("prefix")
+
+	lister, grouped := ch.namespaceLister()
+	ns := q.Get("ns")
+	listRepositories := ch.App.registry.Repositories
+	if grouped && ns != "" {
+		listRepositories = func(ctx context.Context, repos []string, last string) (int, error) {
+			return lister.NamespaceRepositories(ctx, ns, repos, last)
+		}
+	}
 
 	entries := defaultReturnedEntries
 	maximumConfiguredEntries := ch.App.Config.Catalog.MaxEntries
@@ -65,31 +137,41 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 		entries = maximumConfiguredEntries
 	}
 
-	repos := make([]string, entries)
-	filled := 0
-
-	// entries is guaranteed to be >= 0 and < maximumConfiguredEntries
-	if entries == 0 {
-		moreEntries = false
+	var (
+		repos       []string
+		count       *int
+		moreEntries bool
+	)
+	if index := ch.App.catalogIndex; index != nil && index.Ready() {
+		// The index holds the names of cached repositories with their
+		// upstream host
+		var hostPrefix string
+		if grouped && ns != "" {
+			hostPrefix = ns + "/"
+			if lastEntry != "" {
+				lastEntry = hostPrefix + lastEntry
+			}
+		}
+		var total int
+		repos, total, moreEntries = index.Repositories(hostPrefix+prefix, lastEntry, entries)
+		for i := range repos {
+			repos[i] = strings.TrimPrefix(repos[i], hostPrefix)
+		}
+		count = &total
 	} else {
-		returnedRepositories, err := ch.App.registry.Repositories(ch.Context, repos, lastEntry)
+		var err error
+		repos, moreEntries, err = walkRepositories(ch.Context, listRepositories, prefix, lastEntry, entries)
 		if err != nil {
-			_, pathNotFound := err.(driver.PathNotFoundError)
-			if err != io.EOF && !pathNotFound {
-				ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
-				return
-			}
-			// err is either io.EOF or not PathNotFoundError
-			moreEntries = false
+			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
 		}
-		filled = returnedRepositories
 	}
 
 	w.Header().Set("Content-Type", "application/json")
 
 	// Add a link header if there are more entries to retrieve
-	if moreEntries {
-		lastEntry = repos[filled-1]
+	if moreEntries && len(repos) > 0 {
+		lastEntry = repos[len(repos)-1]
 		urlStr, err := createLinkEntry(r.URL.String(), entries, lastEntry)
 		if err != nil {
 			ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
@@ -98,15 +180,60 @@ func (ch *catalogHandler) GetCatalog(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Link", urlStr)
 	}
 
+	var response interface{} = catalogAPIResponse{
+		Repositories: repos,
+		Count:        count,
+	}
+	if grouped && ns == "" {
+		namespaces := make(map[string][]string)
+		for _, repo := range repos {
+			host, name, _ := strings.Cut(repo, "/")
+			namespaces[host] = append(namespaces[host], name)
+		}
+		response = groupedCatalogAPIResponse{Namespaces: namespaces}
+	}
+
 	enc := json.NewEncoder(w)
-	if err := enc.Encode(catalogAPIResponse{
-		Repositories: repos[0:filled],
-	}); err != nil {
+	if err := enc.Encode(response); err != nil {
 		ch.Errors = append(ch.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		return
 	}
 }
 
+// walkRepositories lists up to entries repositories starting with prefix
+// after last, walking the storage until enough are found, and reports
+// whether the walk may find more.
+func walkRepositories(ctx context.Context, list func(context.Context, []string, string) (int, error), prefix, last string, entries int) ([]string, bool, error) {
+	repos := make([]string, 0, entries)
+	if entries == 0 {
+		return repos, false, nil
+	}
+
+	page := make([]string, entries)
+	for {
+		n, err := list(ctx, page, last)
+		if err != nil {
+			_, pathNotFound := err.(driver.PathNotFoundError)
+			if err != io.EOF && !pathNotFound {
+				return nil, false, err
+			}
+		}
+		for _, repo := range page[:n] {
+			if strings.HasPrefix(repo, prefix) {
+				repos = append(repos, repo)
+			}
+		}
+		if len(repos) > entries || (len(repos) == entries && err == nil) {
+			return repos[:entries], true, nil
+		}
+		if err != nil || n == 0 {
+			// err is either io.EOF or PathNotFoundError
+			return repos, false, nil
+		}
+		last = page[n-1]
+	}
+}
+
 // Use the original URL from the request to create a new URL for
 // the link header
 func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string, error) {
@@ -115,9 +242,10 @@ func createLinkEntry(origURL string, maxEntries int, lastEntry string) (string,
 		return "", err
 	}
 
-	v := url.Values{}
-	v.Add("n", strconv.Itoa(maxEntries))
-	v.Add("last", lastEntry)
+	// Keep the other parameters, such as ns or q
+	v := calledURL.Query()
+	v.Set("n", strconv.Itoa(maxEntries))
+	v.Set("last", lastEntry)
 
 	calledURL.RawQuery = v.Encode()
 
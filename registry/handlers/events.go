@@ -0,0 +1,168 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/handlers"
+)
+
+// eventsDispatcher constructs the handler downloading and replaying the
+// events of the history.
+func eventsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	eventsHandler := &eventsHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet:  http.HandlerFunc(eventsHandler.GetEvents),
+		http.MethodPost: http.HandlerFunc(eventsHandler.ReplayEvents),
+	}
+}
+
+type eventsHandler struct {
+	*Context
+}
+
+type replayEventsAPIResponse struct {
+	Endpoint string `json:"endpoint"`
+	Events   int    `json:"events"`
+}
+
+// eventsQuery selects the events of the history.
+type eventsQuery struct {
+	since, until time.Time
+	repository   string
+	action       string
+}
+
+// parseEventsQuery parses the parameters selecting the events, the times
+// being either RFC 3339 times or durations before now.
+func parseEventsQuery(r *http.Request) (eventsQuery, error) {
+	now := time.Now()
+	q := eventsQuery{
+		until:      now,
+		repository: r.FormValue("repository"),
+		action:     r.FormValue("action"),
+	}
+	for _, param := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.since}, {"until", &q.until}} {
+		value := r.FormValue(param.name)
+		if value == "" {
+			continue
+		}
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			*param.t = t
+		} else if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+			*param.t = now.Add(-d)
+		} else {
+			return eventsQuery{}, fmt.Errorf("invalid %s %q", param.name, value)
+		}
+	}
+	if q.repository != "" {
+		if _, err := path.Match(q.repository, ""); err != nil {
+			return eventsQuery{}, fmt.Errorf("invalid repository pattern %q", q.repository)
+		}
+	}
+	return q, nil
+}
+
+func (q eventsQuery) match(event notifications.Event) bool {
+	if q.action != "" && event.Action != q.action {
+		return false
+	}
+	if q.repository != "" {
+		if ok, _ := path.Match(q.repository, event.Target.Repository); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// each calls fn with the events of the history matching the query.
+func (eh *eventsHandler) each(q eventsQuery, fn func(notifications.Event) error) error {
+	return eh.App.events.history.Events(q.since, q.until, func(event notifications.Event) error {
+		if !q.match(event) {
+			return nil
+		}
+		return fn(event)
+	})
+}
+
+// GetEvents downloads the events of the history, a json object per line.
+func (eh *eventsHandler) GetEvents(w http.ResponseWriter, r *http.Request) {
+	if eh.App.events.history == nil {
+		eh.Errors = append(eh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+	q, err := parseEventsQuery(r)
+	if err != nil {
+		eh.Errors = append(eh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.WriteHeader(http.StatusOK)
+
+	enc := json.NewEncoder(w)
+	if err := eh.each(q, func(event notifications.Event) error {
+		return enc.Encode(event)
+	}); err != nil {
+		// the response has started, the error can only be logged
+		dcontext.GetLogger(eh).Errorf("error downloading the events: %v", err)
+	}
+}
+
+// ReplayEvents writes the events of the history to an endpoint, which queues
+// them like new events.
+func (eh *eventsHandler) ReplayEvents(w http.ResponseWriter, r *http.Request) {
+	if eh.App.events.history == nil {
+		eh.Errors = append(eh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+	q, err := parseEventsQuery(r)
+	if err != nil {
+		eh.Errors = append(eh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+	name := r.FormValue("endpoint")
+	endpoint, ok := eh.App.events.endpoints[name]
+	if !ok {
+		eh.Errors = append(eh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(fmt.Sprintf("unknown endpoint %q", name)))
+		return
+	}
+
+	var n int
+	if err := eh.each(q, func(event notifications.Event) error {
+		if err := endpoint.Write(event); err != nil {
+			return err
+		}
+		n++
+		return nil
+	}); err != nil {
+		eh.Errors = append(eh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	dcontext.GetLogger(eh).Infof("Replayed %d events to endpoint %s", n, name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(replayEventsAPIResponse{
+		Endpoint: name,
+		Events:   n,
+	}); err != nil {
+		eh.Errors = append(eh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"sync/atomic"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/docker/go-metrics"
+)
+
+// healthCheckPrefix is the path under which the deep health checks of the
+// storage driver write their canary objects. It is outside of the paths of
+// the repositories and blobs, so that the canary objects are never seen by
+// the catalog or garbage collection.
+const healthCheckPrefix = "/docker/registry/v2/_health/"
+
+// readOnlyDegradedGauge is whether the registry switched to read-only mode
+// because writes to the storage driver are failing
+var readOnlyDegradedGauge = prometheus.StorageNamespace.NewGauge("readonly_degraded", "Whether the registry switched to read-only mode because writes to the storage driver are failing", metrics.Total)
+
+// storageWriteError is an error writing the canary object of the deep health
+// check of the storage driver.
+type storageWriteError struct {
+	err error
+}
+
+func (e storageWriteError) Error() string {
+	return fmt.Sprintf("error writing to the storage driver: %v", e.err)
+}
+
+// isReadOnly reports whether the registry is in read-only mode, either for
+// maintenance or because writes to the storage driver are failing.
+func (app *App) isReadOnly() bool {
+	return app.readOnly.isEnabled() || atomic.LoadInt32(&app.readOnlyDegraded) != 0
+}
+
+// setReadOnlyDegraded switches the read-only mode of the registry due to
+// failing writes on or off.
+func (app *App) setReadOnlyDegraded(degraded bool) {
+	var v int32
+	if degraded {
+		v = 1
+	}
+	if atomic.SwapInt32(&app.readOnlyDegraded, v) == v {
+		return
+	}
+	readOnlyDegradedGauge.Set(float64(v))
+	if degraded {
+		dcontext.GetLogger(app).Warn("writes to the storage driver are failing, switching to read-only mode")
+	} else {
+		dcontext.GetLogger(app).Info("writes to the storage driver recovered, leaving read-only mode")
+	}
+}
+
+// deepStorageDriverCheck returns a health check writing, reading back and
+// deleting a canary object with the storage driver. When readOnlyOnFailure
+// is set, the registry switches to read-only mode once writing fails
+// threshold times in a row, rather than failing the requests writing to the
+// storage, and the check keeps passing as long as reads succeed.
+func (app *App) deepStorageDriverCheck(readOnlyOnFailure bool, threshold int) func() error {
+	id := make([]byte, 16)
+	if _, err := rand.Read(id); err != nil {
+		panic(fmt.Sprintf("could not generate the health check id: %v", err))
+	}
+	canary := healthCheckPrefix + hex.EncodeToString(id)
+
+	if threshold < 1 {
+		threshold = 1
+	}
+	var failures int
+	return func() error {
+		err := app.checkStorageDriver(canary)
+		if _, ok := err.(storageWriteError); !ok || !readOnlyOnFailure {
+			if err == nil {
+				failures = 0
+				app.setReadOnlyDegraded(false)
+			}
+			return err
+		}
+
+		dcontext.GetLogger(app).Errorf("storage driver health check failed: %v", err)
+		failures++
+		if failures >= threshold {
+			app.setReadOnlyDegraded(true)
+		}
+
+		// Pulls are served as long as the storage can be read from
+		_, err = app.driver.Stat(app, "/")
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			err = nil
+		}
+		return err
+	}
+}
+
+// checkStorageDriver writes, reads back and deletes the canary object at
+// path.
+func (app *App) checkStorageDriver(path string) error {
+	content := make([]byte, 32)
+	if _, err := rand.Read(content); err != nil {
+		return err
+	}
+	if err := app.driver.PutContent(app, path, content); err != nil {
+		return storageWriteError{err: err}
+	}
+	read, err := app.driver.GetContent(app, path)
+	if err != nil {
+		return fmt.Errorf("error reading from the storage driver: %v", err)
+	}
+	if !bytes.Equal(read, content) {
+		return fmt.Errorf("content read from the storage driver does not match the content written")
+	}
+	if err := app.driver.Delete(app, path); err != nil {
+		return storageWriteError{err: err}
+	}
+	return nil
+}
@@ -1,16 +1,20 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/distribution/distribution/v3/configuration"
-	"github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/health"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 )
 
 func TestFileHealthCheck(t *testing.T) {
@@ -207,3 +211,117 @@ func TestHTTPHealthCheck(t *testing.T) {
 		t.Fatal("expected 0 items in health check results")
 	}
 }
+
+// failingWritesDriver fails the writes of the storage driver while fail is
+// set.
+type failingWritesDriver struct {
+	storagedriver.StorageDriver
+	fail bool
+}
+
+func (d *failingWritesDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	if d.fail {
+		return errors.New("disk full")
+	}
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func TestDeepStorageDriverHealthCheck(t *testing.T) {
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+	}
+
+	app := NewApp(context.Background(), config)
+	driver := &failingWritesDriver{StorageDriver: app.driver}
+	app.driver = driver
+
+	for _, readOnlyOnFailure := range []bool{false, true} {
+		check := app.deepStorageDriverCheck(readOnlyOnFailure, 2)
+		if err := check(); err != nil {
+			t.Fatalf("unexpected health check error: %v", err)
+		}
+		if children, _ := driver.List(app, healthCheckPrefix[:len(healthCheckPrefix)-1]); len(children) != 0 {
+			t.Errorf("expected the canary object to be deleted, got %v", children)
+		}
+
+		driver.fail = true
+		for i := 0; i < 2; i++ {
+			err := check()
+			if readOnlyOnFailure && err != nil {
+				t.Errorf("expected the health check to pass in read-only mode, got %v", err)
+			} else if !readOnlyOnFailure && err == nil {
+				t.Error("expected the health check to fail")
+			}
+			if degraded := app.isReadOnly(); degraded != (readOnlyOnFailure && i == 1) {
+				t.Errorf("unexpected read-only mode %t after %d failures", degraded, i+1)
+			}
+		}
+
+		driver.fail = false
+		if err := check(); err != nil {
+			t.Fatalf("unexpected health check error: %v", err)
+		}
+		if app.isReadOnly() {
+			t.Error("expected the registry to leave read-only mode once writes recover")
+		}
+	}
+}
+
+func TestUpstreamHealthCheck(t *testing.T) {
+	interval := time.Second
+
+	var status int32 = http.StatusUnauthorized
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v2/" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer upstream.Close()
+
+	config := &configuration.Configuration{
+		Storage: configuration.Storage{
+			"inmemory": configuration.Parameters{},
+			"maintenance": configuration.Parameters{"uploadpurging": map[interface{}]interface{}{
+				"enabled": false,
+			}},
+		},
+		Proxy: configuration.Proxy{
+			RemoteURL: upstream.URL,
+		},
+		Health: configuration.Health{
+			Upstreams: configuration.UpstreamChecker{
+				Enabled:  true,
+				Interval: interval,
+			},
+		},
+	}
+
+	app := NewApp(context.Background(), config)
+	healthRegistry := health.NewRegistry()
+	app.RegisterHealthChecks(healthRegistry)
+
+	<-time.After(2 * interval)
+	if status := healthRegistry.NonCriticalStatus(); len(status) != 0 {
+		t.Fatalf("unexpected upstream health check results: %v", status)
+	}
+
+	atomic.StoreInt32(&status, http.StatusBadGateway)
+	<-time.After(2 * interval)
+
+	// the upstream is reported as unreachable, but the registry is healthy
+	name := "upstream_" + strings.TrimPrefix(upstream.URL, "http://")
+	results := healthRegistry.NonCriticalStatus()
+	if results[name] != "upstream returned unexpected status: 502 Bad Gateway" {
+		t.Fatalf("unexpected upstream health check results: %v", results)
+	}
+	if len(healthRegistry.CheckStatus()) != 0 {
+		t.Fatal("expected 0 items in health check results")
+	}
+}
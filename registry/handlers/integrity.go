@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/storage"
+)
+
+// integrityEventProvider is the source provider of the events of the
+// corrupt blobs found by the integrity checks.
+const integrityEventProvider = "integrity"
+
+// IntegrityOpts returns the options of the integrity checks of the
+// configuration.
+func IntegrityOpts(config *configuration.Configuration) storage.IntegrityOpts {
+	return storage.IntegrityOpts{
+		Sample:       config.Integrity.Sample,
+		Quarantine:   config.Integrity.Quarantine,
+		AllowMissing: config.Proxy.RemoteURL != "" || config.Proxy.EnableNamespaces,
+		Concurrency:  config.Integrity.Concurrency,
+	}
+}
+
+// startIntegrityWorker runs the integrity checks of the configuration
+// periodically, notifying the corrupt blobs to the event sink of the app.
+// The checks are not run while the registry is read-only.
+func (app *App) startIntegrityWorker(config *configuration.Configuration) {
+	if config.Integrity.Interval <= 0 {
+		return
+	}
+	if config.Integrity.Sample < 0 || config.Integrity.Sample > 1 {
+		panic("integrity sample must be between 0 and 1")
+	}
+
+	registry, err := storage.NewRegistry(app, app.driver, app.layoutOptions...)
+	if err != nil {
+		panic("could not create registry: " + err.Error())
+	}
+
+	source := app.events.source
+	source.Provider = integrityEventProvider
+	opts := IntegrityOpts(config)
+	opts.Listener = notifications.NewIntegrityBridge(source, app.events.sink)
+
+	go func() {
+		log := dcontext.GetLogger(app)
+		ticker := time.NewTicker(config.Integrity.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if app.isReadOnly() {
+				log.Infof("Skipping the integrity checks while the registry is read-only")
+				continue
+			}
+			report, err := storage.VerifyIntegrity(app, app.driver, registry, opts)
+			if err != nil {
+				log.Errorf("Error checking the integrity of the storage: %v", err)
+			}
+			if report == nil {
+				continue
+			}
+			for _, blob := range report.Corrupt {
+				if blob.Quarantined {
+					log.Errorf("Quarantined corrupt blob %s, its content hashes to %s", blob.Digest, blob.Actual)
+				} else {
+					log.Errorf("Found corrupt blob %s, its content hashes to %s", blob.Digest, blob.Actual)
+				}
+			}
+			for _, manifest := range report.BrokenManifests {
+				log.Errorf("Manifest %s@%s references missing or corrupt content: %v", manifest.Repository, manifest.Digest, manifest.Blobs)
+			}
+			log.Infof("Checked the integrity of %d blobs, %d bytes: %d corrupt, %d broken manifests", report.Blobs, report.Bytes, len(report.Corrupt), len(report.BrokenManifests))
+		}
+	}()
+}
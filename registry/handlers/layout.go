@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage"
+)
+
+// storageLayout is the layout of the links of the storage configuration.
+type storageLayout struct {
+	version         int
+	compactInterval time.Duration
+}
+
+// parseStorageLayout parses the layout section of the storage configuration.
+func parseStorageLayout(config *configuration.Configuration) (storageLayout, error) {
+	layout := storageLayout{version: 2}
+	params, ok := config.Storage["layout"]
+	if !ok {
+		return layout, nil
+	}
+	if v, ok := params["version"]; ok {
+		version, ok := v.(int)
+		if !ok || (version != 2 && version != 3) {
+			return layout, fmt.Errorf("invalid storage layout version: %#v", v)
+		}
+		layout.version = version
+	}
+	if v, ok := params["compactinterval"]; ok {
+		interval, ok := v.(string)
+		if !ok {
+			return layout, fmt.Errorf("invalid type for storage layout compactinterval: %#v", v)
+		}
+		d, err := time.ParseDuration(interval)
+		if err != nil || d <= 0 {
+			return layout, fmt.Errorf("invalid storage layout compactinterval %q", interval)
+		}
+		if layout.version != 3 {
+			return layout, fmt.Errorf("storage layout compactinterval requires version 3")
+		}
+		layout.compactInterval = d
+	}
+	return layout, nil
+}
+
+// LayoutOptions returns the registry options of the layout of the links of
+// the storage configuration.
+func LayoutOptions(config *configuration.Configuration) ([]storage.RegistryOption, error) {
+	layout, err := parseStorageLayout(config)
+	if err != nil {
+		return nil, err
+	}
+	if layout.version == 3 {
+		return []storage.RegistryOption{storage.EnablePackedLinks}, nil
+	}
+	return nil, nil
+}
+
+// startLayoutWorker packs the links written since into the index of the
+// repositories periodically, with the packed layout. The links are not
+// packed while the registry is read-only.
+func (app *App) startLayoutWorker(config *configuration.Configuration) {
+	layout, err := parseStorageLayout(config)
+	if err != nil {
+		panic(err)
+	}
+	if layout.compactInterval <= 0 {
+		return
+	}
+
+	registry, err := storage.NewRegistry(app, app.driver, app.layoutOptions...)
+	if err != nil {
+		panic("could not create registry: " + err.Error())
+	}
+
+	go func() {
+		log := dcontext.GetLogger(app)
+		ticker := time.NewTicker(layout.compactInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if app.isReadOnly() {
+				log.Infof("Skipping the packing of the links while the registry is read-only")
+				continue
+			}
+			report, err := storage.MigrateLayout(app, app.driver, registry, storage.LayoutOpts{Version: 3})
+			if err != nil {
+				log.Errorf("Error packing the links: %v", err)
+			}
+			if report != nil {
+				log.Infof("Packed %d links of %d repositories", report.Links, report.Repositories)
+			}
+		}
+	}()
+}
@@ -6,6 +6,7 @@ import (
 	"mime"
 	"net/http"
 	"strings"
+	"sync"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
@@ -17,6 +18,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/api/errcode"
 	v2 "github.com/distribution/distribution/v3/registry/api/v2"
 	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/gorilla/handlers"
 	"github.com/opencontainers/go-digest"
@@ -30,6 +32,7 @@ const (
 	defaultOS           = "linux"
 	maxManifestBodySize = 4 << 20
 	imageClass          = "image"
+	artifactClass       = "artifact"
 )
 
 type storageType int
@@ -63,7 +66,7 @@ func manifestDispatcher(ctx *Context, r *http.Request) http.Handler {
 		http.MethodHead: http.HandlerFunc(manifestHandler.GetManifest),
 	}
 
-	if !ctx.readOnly {
+	if !ctx.isReadOnly() {
 		mhandler[http.MethodPut] = http.HandlerFunc(manifestHandler.PutManifest)
 		mhandler[http.MethodDelete] = http.HandlerFunc(manifestHandler.DeleteManifest)
 	}
@@ -143,13 +146,24 @@ func (imh *manifestHandler) GetManifest(w http.ResponseWriter, r *http.Request)
 	}
 	manifest, err := manifests.Get(imh, imh.Digest, options...)
 	if err != nil {
-		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+		switch err.(type) {
+		case distribution.ErrManifestUnknownRevision:
 			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
-		} else {
+		case distribution.ErrManifestVerification:
+			imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnverified.WithDetail(err))
+		case errcode.Error:
+			imh.Errors = append(imh.Errors, err)
+		default:
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
 		return
 	}
+	if imh.App.scanner != nil {
+		if err := imh.App.scanner.Check(imh, imh.Repository.Named().Name(), imh.Digest); err != nil {
+			imh.Errors = append(imh.Errors, err)
+			return
+		}
+	}
 	// determine the type of the returned manifest
 	manifestType := manifestSchema1
 	schema2Manifest, isSchema2 := manifest.(*schema2.DeserializedManifest)
@@ -286,10 +300,102 @@ func etagMatch(r *http.Request, etag string) bool {
 	return false
 }
 
+// ifMatch reports whether the If-Match header of the request matches the
+// digest of the manifest a tag points to, or "*" when the tag exists. The
+// digest is empty when the tag does not exist.
+func ifMatch(r *http.Request, dgst digest.Digest) bool {
+	if dgst == "" {
+		return false
+	}
+	for _, headerVal := range r.Header["If-Match"] {
+		for _, etag := range strings.Split(headerVal, ",") {
+			etag = strings.TrimSpace(etag)
+			if etag == "*" || etag == dgst.String() || etag == fmt.Sprintf(`"%s"`, dgst) { // allow quoted or unquoted
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkTagPrecondition checks that the tag of a manifest PUT still points
+// to the manifest the client expects, per the If-Match header.
+func (imh *manifestHandler) checkTagPrecondition(r *http.Request) bool {
+	var current digest.Digest
+	desc, err := imh.Repository.Tags(imh).Get(imh, imh.Tag)
+	if err == nil {
+		current = desc.Digest
+	} else if _, ok := err.(distribution.ErrTagUnknown); !ok {
+		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return false
+	}
+
+	if !ifMatch(r, current) {
+		detail := map[string]string{"tag": imh.Tag}
+		if current != "" {
+			detail["current"] = current.String()
+		}
+		imh.Errors = append(imh.Errors, v2.ErrorCodePreconditionFailed.WithDetail(detail))
+		return false
+	}
+	return true
+}
+
+// tagLocks holds a lock per tag, serializing the conditional updates of the
+// tag made through this registry instance.
+type tagLocks struct {
+	mu    sync.Mutex
+	locks map[string]*tagLock
+}
+
+type tagLock struct {
+	sync.Mutex
+	waiters int
+}
+
+// lock locks the tag with the given key, and returns the function unlocking
+// it.
+func (tl *tagLocks) lock(key string) func() {
+	tl.mu.Lock()
+	if tl.locks == nil {
+		tl.locks = make(map[string]*tagLock)
+	}
+	l, ok := tl.locks[key]
+	if !ok {
+		l = &tagLock{}
+		tl.locks[key] = l
+	}
+	l.waiters++
+	tl.mu.Unlock()
+
+	l.Lock()
+	return func() {
+		l.Unlock()
+
+		tl.mu.Lock()
+		defer tl.mu.Unlock()
+		l.waiters--
+		if l.waiters == 0 {
+			delete(tl.locks, key)
+		}
+	}
+}
+
 // PutManifest validates and stores a manifest in the registry.
 func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("PutImageManifest")
-	manifests, err := imh.Repository.Manifests(imh)
+
+	// The manifest is stored under a digest of the algorithm of the digest
+	// it is pushed by, if any
+	algorithm := imh.App.digestAlgorithm
+	if imh.Digest != "" {
+		algorithm = imh.Digest.Algorithm()
+	}
+	var manifestOptions []distribution.ManifestServiceOption
+	if algorithm != imh.App.digestAlgorithm {
+		manifestOptions = append(manifestOptions, storage.ManifestDigestAlgorithm(algorithm))
+	}
+	manifests, err := imh.Repository.Manifests(imh, manifestOptions...)
 	if err != nil {
 		imh.Errors = append(imh.Errors, err)
 		return
@@ -308,6 +414,9 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		imh.Errors = append(imh.Errors, v2.ErrorCodeManifestInvalid.WithDetail(err))
 		return
 	}
+	if desc.Digest.Algorithm() != algorithm {
+		desc.Digest = algorithm.FromBytes(manifestDigestPayload(manifest, jsonBuf.Bytes()))
+	}
 
 	if imh.Digest != "" {
 		if desc.Digest != imh.Digest {
@@ -340,6 +449,17 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
+	// A conditional update of a tag holds the lock of the tag until the tag
+	// is updated, so that concurrent updates see each other's result
+	if _, ok := r.Header["If-Match"]; ok && imh.Tag != "" {
+		unlock := imh.App.tagLocks.lock(imh.Repository.Named().Name() + ":" + imh.Tag)
+		defer unlock()
+
+		if !imh.checkTagPrecondition(r) {
+			return
+		}
+	}
+
 	_, err = manifests.Put(imh, manifest, options...)
 	if err != nil {
 		// TODO(stevvooe): These error handling switches really need to be
@@ -372,6 +492,8 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 			}
 		case errcode.Error:
 			imh.Errors = append(imh.Errors, err)
+		case errcode.Errors:
+			imh.Errors = append(imh.Errors, err...)
 		default:
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 		}
@@ -382,7 +504,10 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 	if imh.Tag != "" {
 		tags := imh.Repository.Tags(imh)
 		err = tags.Tag(imh, imh.Tag, desc)
-		if err != nil {
+		if e, ok := err.(errcode.Error); ok {
+			imh.Errors = append(imh.Errors, e)
+			return
+		} else if err != nil {
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			return
 		}
@@ -406,11 +531,21 @@ func (imh *manifestHandler) PutManifest(w http.ResponseWriter, r *http.Request)
 
 	w.Header().Set("Location", location)
 	w.Header().Set("Docker-Content-Digest", imh.Digest.String())
+	w.Header().Set("Etag", fmt.Sprintf(`"%s"`, imh.Digest))
 	w.WriteHeader(http.StatusCreated)
 
 	dcontext.GetLogger(imh).Debug("Succeeded in putting manifest!")
 }
 
+// manifestDigestPayload returns the bytes of the pushed payload the digest
+// of the manifest is computed from.
+func manifestDigestPayload(manifest distribution.Manifest, payload []byte) []byte {
+	if sm, ok := manifest.(*schema1.SignedManifest); ok { //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+		return sm.Canonical
+	}
+	return payload
+}
+
 // applyResourcePolicy checks whether the resource class matches what has
 // been authorized and allowed by the policy configuration.
 func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest) error {
@@ -433,11 +568,12 @@ func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest)
 			return errcode.ErrorCodeDenied.WithMessage("unknown manifest class for " + m.Config.MediaType)
 		}
 	case *ocischema.DeserializedManifest:
-		switch m.Config.MediaType {
-		case v1.MediaTypeImageConfig:
+		// OCI manifests which are not images, such as Helm charts or
+		// signatures, are artifacts of any config media type
+		if m.ArtifactType == "" && m.Config.MediaType == v1.MediaTypeImageConfig {
 			class = imageClass
-		default:
-			return errcode.ErrorCodeDenied.WithMessage("unknown manifest class for " + m.Config.MediaType)
+		} else {
+			class = artifactClass
 		}
 	}
 
@@ -485,7 +621,8 @@ func (imh *manifestHandler) applyResourcePolicy(manifest distribution.Manifest)
 func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Request) {
 	dcontext.GetLogger(imh).Debug("DeleteImageManifest")
 
-	if imh.App.isCache {
+	// A pull through cache only deletes the referrers pushed to it
+	if imh.App.isCache && imh.Tag != "" {
 		imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
 		return
 	}
@@ -497,6 +634,8 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 			switch err.(type) {
 			case distribution.ErrTagUnknown, driver.PathNotFoundError:
 				imh.Errors = append(imh.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+			case errcode.Error:
+				imh.Errors = append(imh.Errors, err)
 			default:
 				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
 			}
@@ -526,11 +665,21 @@ func (imh *manifestHandler) DeleteManifest(w http.ResponseWriter, r *http.Reques
 			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnsupported)
 			return
 		default:
-			imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown)
+			if e, ok := err.(errcode.Error); ok {
+				imh.Errors = append(imh.Errors, e)
+			} else {
+				imh.Errors = append(imh.Errors, errcode.ErrorCodeUnknown)
+			}
 			return
 		}
 	}
 
+	// the referrers pushed to a pull through cache are not tagged
+	if imh.App.isCache {
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+
 	tagService := imh.Repository.Tags(imh)
 	referencedTags, err := tagService.Lookup(imh, distribution.Descriptor{Digest: imh.Digest})
 	if err != nil {
@@ -0,0 +1,49 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/gorilla/handlers"
+)
+
+// proxyNamespacesDispatcher constructs the pull through cache upstreams
+// handler.
+func proxyNamespacesDispatcher(ctx *Context, r *http.Request) http.Handler {
+	proxyNamespacesHandler := &proxyNamespacesHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(proxyNamespacesHandler.GetProxyNamespaces),
+	}
+}
+
+type proxyNamespacesHandler struct {
+	*Context
+}
+
+type proxyNamespacesAPIResponse struct {
+	Namespaces []proxy.Namespace `json:"namespaces"`
+}
+
+// GetProxyNamespaces returns the upstreams of the pull through cache.
+func (ph *proxyNamespacesHandler) GetProxyNamespaces(w http.ResponseWriter, r *http.Request) {
+	lister, ok := ph.App.registry.(proxy.NamespaceLister)
+	if !ok {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(proxyNamespacesAPIResponse{
+		Namespaces: lister.ProxyNamespaces(),
+	}); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
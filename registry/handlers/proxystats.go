@@ -0,0 +1,42 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/gorilla/handlers"
+)
+
+// proxyStatsDispatcher constructs the pull through cache statistics handler.
+func proxyStatsDispatcher(ctx *Context, r *http.Request) http.Handler {
+	proxyStatsHandler := &proxyStatsHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(proxyStatsHandler.GetProxyStats),
+	}
+}
+
+type proxyStatsHandler struct {
+	*Context
+}
+
+// GetProxyStats returns the statistics of the pull through cache.
+func (ph *proxyStatsHandler) GetProxyStats(w http.ResponseWriter, r *http.Request) {
+	reporter, ok := ph.App.registry.(proxy.StatsReporter)
+	if !ok {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(reporter.ProxyStats()); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
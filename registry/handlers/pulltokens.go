@@ -0,0 +1,89 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/handlers"
+)
+
+// pullTokensDispatcher constructs the handler minting pull tokens.
+func pullTokensDispatcher(ctx *Context, r *http.Request) http.Handler {
+	pullTokensHandler := &pullTokensHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodPost: http.HandlerFunc(pullTokensHandler.MintToken),
+	}
+}
+
+type pullTokensHandler struct {
+	*Context
+}
+
+type pullTokenAPIRequest struct {
+	Name         string   `json:"name"`
+	Repositories []string `json:"repositories"`
+	// Expiration is a duration, the maximum expiration if empty
+	Expiration string `json:"expiration"`
+}
+
+type pullTokenAPIResponse struct {
+	Token        string    `json:"token"`
+	ID           string    `json:"id"`
+	Name         string    `json:"name"`
+	Repositories []string  `json:"repositories"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// MintToken mints a pull token with the grants of the request.
+func (ph *pullTokensHandler) MintToken(w http.ResponseWriter, r *http.Request) {
+	if ph.App.pullTokens == nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	var req pullTokenAPIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		ph.Errors = append(ph.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+	var expiration time.Duration
+	if req.Expiration != "" {
+		var err error
+		expiration, err = time.ParseDuration(req.Expiration)
+		if err != nil || expiration <= 0 {
+			ph.Errors = append(ph.Errors, v2.ErrorCodeRequestInvalid.WithDetail(fmt.Sprintf("invalid expiration %q", req.Expiration)))
+			return
+		}
+	}
+
+	token, claims, err := ph.App.pullTokens.Mint(req.Name, req.Repositories, expiration)
+	if err != nil {
+		ph.Errors = append(ph.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+	expiresAt := time.Unix(claims.Expiration, 0).UTC()
+	dcontext.GetLogger(ph).Infof("Minted pull token %s of %q for %v, expiring at %v", claims.ID, claims.Name, claims.Repositories, expiresAt)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(pullTokenAPIResponse{
+		Token:        token,
+		ID:           claims.ID,
+		Name:         claims.Name,
+		Repositories: claims.Repositories,
+		ExpiresAt:    expiresAt,
+	}); err != nil {
+		ph.Errors = append(ph.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
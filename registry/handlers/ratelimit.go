@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
+	"github.com/gorilla/mux"
+)
+
+// clientIP returns the address of the client of the request, read from the
+// header of the trusted proxies if any are configured.
+func (app *App) clientIP(r *http.Request) string {
+	if app.ipFilter != nil {
+		if ip := app.ipFilter.ClientIP(r); ip != nil {
+			return ip.String()
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimitIP takes a request of the address of the client from the rate
+// limiter, before the request is authorized, so that the clients flooding
+// the registry with anonymous requests or invalid credentials are limited
+// before their credentials are checked. It returns false if the client
+// exceeded its limit, in which case the error is appended to the context.
+func (app *App) rateLimitIP(w http.ResponseWriter, r *http.Request, ctx *Context) bool {
+	return app.allow(w, ctx, "ip:"+app.clientIP(r))
+}
+
+// rateLimit charges the authorized request to the account of the client,
+// when the clients are identified by their identity, in place of its
+// address, and takes an upload for the requests sending blob data. It
+// returns a function releasing the upload, or false if the client exceeded
+// its limits, in which case the error is appended to the context.
+func (app *App) rateLimit(w http.ResponseWriter, r *http.Request, ctx *Context) (func(), bool) {
+	key := "ip:" + app.clientIP(r)
+	if app.rateLimiter.Key == ratelimit.KeyIdentity {
+		if user := dcontext.GetStringValue(ctx, auth.UserNameKey); user != "" {
+			app.rateLimiter.Return(key)
+			key = "user:" + user
+			if !app.allow(w, ctx, key) {
+				return nil, false
+			}
+		}
+	}
+
+	if !isUpload(r) {
+		return func() {}, true
+	}
+	release, ok := app.rateLimiter.StartUpload(key)
+	if !ok {
+		dcontext.GetLogger(ctx).Warnf("limiting the concurrent uploads of %s", key)
+		w.Header().Set("Retry-After", "1")
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests.WithDetail("too many concurrent uploads"))
+		return nil, false
+	}
+	return release, true
+}
+
+// allow takes a request of the client from the rate limiter, appending the
+// error to the context if the client exceeded its limit.
+func (app *App) allow(w http.ResponseWriter, ctx *Context, key string) bool {
+	if ok, wait := app.rateLimiter.Allow(key); !ok {
+		dcontext.GetLogger(ctx).Warnf("rate limiting %s", key)
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		ctx.Errors = append(ctx.Errors, errcode.ErrorCodeTooManyRequests)
+		return false
+	}
+	return true
+}
+
+// isUpload reports whether the request starts an upload, or sends its data.
+func isUpload(r *http.Request) bool {
+	route := mux.CurrentRoute(r)
+	if route == nil {
+		return false
+	}
+	switch route.GetName() {
+	case v2.RouteNameBlobUpload, v2.RouteNameBlobUploadChunk:
+		return r.Method == http.MethodPost || r.Method == http.MethodPatch || r.Method == http.MethodPut
+	}
+	return false
+}
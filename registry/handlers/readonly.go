@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"path"
+	"sync"
+	"sync/atomic"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/handlers"
+)
+
+// readOnlyMode is the read-only maintenance mode of the registry, which can
+// be switched at runtime, for the whole registry or for the repositories
+// matching patterns.
+type readOnlyMode struct {
+	mu           sync.RWMutex
+	enabled      bool
+	repositories []string
+}
+
+// get returns whether the whole registry is read-only, and the patterns of
+// the read-only repositories.
+func (m *readOnlyMode) get() (bool, []string) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled, append([]string{}, m.repositories...)
+}
+
+func (m *readOnlyMode) set(enabled bool, repositories []string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.enabled = enabled
+	m.repositories = append([]string{}, repositories...)
+}
+
+// isEnabled reports whether the whole registry is read-only.
+func (m *readOnlyMode) isEnabled() bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.enabled
+}
+
+// matches reports whether the repository is read-only, on its own or with
+// the whole registry.
+func (m *readOnlyMode) matches(name string) bool {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if m.enabled {
+		return true
+	}
+	for _, pattern := range m.repositories {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// SetReadOnly switches the read-only maintenance mode of the whole registry
+// on or off at runtime. The repositories made read-only on their own are
+// left as they are.
+func (app *App) SetReadOnly(enabled bool) {
+	app.readOnly.mu.Lock()
+	changed := app.readOnly.enabled != enabled
+	app.readOnly.enabled = enabled
+	app.readOnly.mu.Unlock()
+
+	if !changed {
+		return
+	}
+	if enabled {
+		dcontext.GetLogger(app).Info("switching to read-only mode")
+	} else {
+		dcontext.GetLogger(app).Info("leaving read-only mode")
+	}
+}
+
+// isReadOnly reports whether the repository of the request is read-only,
+// either because the registry is, or on its own.
+func (ctx *Context) isReadOnly() bool {
+	if ctx.App.isReadOnly() {
+		return true
+	}
+	return ctx.Repository != nil && ctx.App.readOnly.matches(ctx.Repository.Named().Name())
+}
+
+// readOnlyDispatcher constructs the handler of the runtime read-only mode.
+func readOnlyDispatcher(ctx *Context, r *http.Request) http.Handler {
+	readOnlyHandler := &readOnlyHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(readOnlyHandler.GetReadOnly),
+		http.MethodPut: http.HandlerFunc(readOnlyHandler.PutReadOnly),
+	}
+}
+
+type readOnlyHandler struct {
+	*Context
+}
+
+type readOnlyAPIRequest struct {
+	Enabled      bool     `json:"enabled"`
+	Repositories []string `json:"repositories"`
+}
+
+type readOnlyAPIResponse struct {
+	Enabled      bool     `json:"enabled"`
+	Repositories []string `json:"repositories"`
+	// Degraded is whether the registry is read-only because writes to the
+	// storage driver are failing
+	Degraded bool `json:"degraded"`
+}
+
+// GetReadOnly returns the read-only mode of the registry.
+func (rh *readOnlyHandler) GetReadOnly(w http.ResponseWriter, r *http.Request) {
+	rh.writeReadOnly(w)
+}
+
+// PutReadOnly replaces the read-only mode of the registry, and of the
+// repositories matching the patterns of the request.
+func (rh *readOnlyHandler) PutReadOnly(w http.ResponseWriter, r *http.Request) {
+	var request readOnlyAPIRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+	for _, pattern := range request.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil || pattern == "" {
+			rh.Errors = append(rh.Errors, v2.ErrorCodeRequestInvalid.WithDetail("invalid repository pattern "+pattern))
+			return
+		}
+	}
+
+	rh.App.readOnly.set(request.Enabled, request.Repositories)
+	dcontext.GetLogger(rh).Infof("read-only mode set to %t, read-only repositories: %v", request.Enabled, request.Repositories)
+	rh.writeReadOnly(w)
+}
+
+func (rh *readOnlyHandler) writeReadOnly(w http.ResponseWriter) {
+	enabled, repositories := rh.App.readOnly.get()
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(readOnlyAPIResponse{
+		Enabled:      enabled,
+		Repositories: repositories,
+		Degraded:     atomic.LoadInt32(&rh.App.readOnlyDegraded) != 0,
+	}); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
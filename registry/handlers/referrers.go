@@ -0,0 +1,153 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// referrersDispatcher constructs the referrers handler api endpoint.
+func referrersDispatcher(ctx *Context, r *http.Request) http.Handler {
+	dgst, err := getDigest(ctx)
+	if err != nil {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx.Errors = append(ctx.Errors, v2.ErrorCodeDigestInvalid.WithDetail(err))
+		})
+	}
+
+	referrersHandler := &referrersHandler{
+		Context: ctx,
+		Digest:  dgst,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(referrersHandler.GetReferrers),
+	}
+}
+
+// referrersHandler handles requests for the referrers of a manifest.
+type referrersHandler struct {
+	*Context
+
+	Digest digest.Digest
+}
+
+type referrersAPIResponse struct {
+	manifest.Versioned
+	Manifests []distribution.Descriptor `json:"manifests"`
+}
+
+// GetReferrers returns an image index listing the manifests whose subject is
+// the requested manifest.
+func (rh *referrersHandler) GetReferrers(w http.ResponseWriter, r *http.Request) {
+	dcontext.GetLogger(rh).Debug("GetReferrers")
+
+	referrerService, ok := rh.Repository.(distribution.ReferrerService)
+	if !ok {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	query := r.URL.Query()
+	artifactType := query.Get("artifactType")
+	annotations, err := parseAnnotationFilters(query["annotation"])
+	if err != nil {
+		rh.Errors = append(rh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+
+	referrers, err := referrerService.Referrers(rh, rh.Digest, artifactType)
+	if err != nil {
+		if err == distribution.ErrUnsupported {
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+			return
+		}
+
+		switch err := err.(type) {
+		case distribution.ErrRepositoryUnknown:
+			rh.Errors = append(rh.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": rh.Repository.Named().Name()}))
+		case errcode.Error:
+			rh.Errors = append(rh.Errors, err)
+		default:
+			rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	var filters []string
+	if artifactType != "" {
+		filters = append(filters, "artifactType")
+	}
+	if len(annotations) > 0 {
+		referrers = filterReferrersByAnnotations(referrers, annotations)
+		filters = append(filters, "annotation")
+	}
+	if len(filters) > 0 {
+		w.Header().Set("OCI-Filters-Applied", strings.Join(filters, ","))
+	}
+	w.Header().Set("Content-Type", v1.MediaTypeImageIndex)
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(referrersAPIResponse{
+		Versioned: manifest.Versioned{
+			SchemaVersion: 2,
+			MediaType:     v1.MediaTypeImageIndex,
+		},
+		Manifests: referrers,
+	}); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// annotationFilter matches the referrers with an annotation, of the given
+// value unless anyValue is set.
+type annotationFilter struct {
+	key      string
+	value    string
+	anyValue bool
+}
+
+// parseAnnotationFilters parses the annotation query parameters of the
+// referrers API, of the form key=value, or key to match any value.
+func parseAnnotationFilters(params []string) ([]annotationFilter, error) {
+	filters := make([]annotationFilter, 0, len(params))
+	for _, param := range params {
+		key, value, hasValue := strings.Cut(param, "=")
+		if key == "" {
+			return nil, fmt.Errorf("invalid annotation filter %q", param)
+		}
+		filters = append(filters, annotationFilter{key: key, value: value, anyValue: !hasValue})
+	}
+	return filters, nil
+}
+
+// filterReferrersByAnnotations returns the referrers matching all the
+// annotation filters.
+func filterReferrersByAnnotations(referrers []distribution.Descriptor, filters []annotationFilter) []distribution.Descriptor {
+	filtered := make([]distribution.Descriptor, 0, len(referrers))
+	for _, desc := range referrers {
+		matches := true
+		for _, filter := range filters {
+			value, ok := desc.Annotations[filter.key]
+			if !ok || (!filter.anyValue && value != filter.value) {
+				matches = false
+				break
+			}
+		}
+		if matches {
+			filtered = append(filtered, desc)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,67 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/replication"
+	events "github.com/docker/go-events"
+	"github.com/gorilla/handlers"
+)
+
+// configureReplication starts the replication of the manifests pushed to the
+// registry, or cached by a pull through cache, to the downstream registries
+// of the replication rules. It must be called before the registry is
+// configured as a pull through cache, so that the events of the cache reach
+// the replicator.
+func (app *App) configureReplication(config *configuration.Configuration) {
+	if len(config.Replication.Rules) == 0 {
+		return
+	}
+
+	replicator, err := replication.NewReplicator(app, app.registry, config.Replication)
+	if err != nil {
+		panic(err)
+	}
+	app.replicator = replicator
+	app.events.sink = events.NewBroadcaster(app.events.sink, replicator)
+
+	for _, rule := range config.Replication.Rules {
+		dcontext.GetLogger(app).Infof("replicating %v to %s", rule.Repositories, rule.URL)
+	}
+}
+
+// replicationStatusDispatcher constructs the replication status handler.
+func replicationStatusDispatcher(ctx *Context, r *http.Request) http.Handler {
+	replicationStatusHandler := &replicationStatusHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(replicationStatusHandler.GetReplicationStatus),
+	}
+}
+
+type replicationStatusHandler struct {
+	*Context
+}
+
+// GetReplicationStatus returns the state of the replications to downstream
+// registries.
+func (rh *replicationStatusHandler) GetReplicationStatus(w http.ResponseWriter, r *http.Request) {
+	if rh.App.replicator == nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(rh.App.replicator.Status()); err != nil {
+		rh.Errors = append(rh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/storage"
+)
+
+// retentionEventProvider is the source provider of the events of the
+// deletions made by the retention policies.
+const retentionEventProvider = "retention"
+
+// RetentionPolicies returns the retention policies of the configuration.
+func RetentionPolicies(config *configuration.Configuration) []storage.RetentionPolicy {
+	policies := make([]storage.RetentionPolicy, 0, len(config.Retention.Policies))
+	for _, policy := range config.Retention.Policies {
+		policies = append(policies, storage.RetentionPolicy(policy))
+	}
+	return policies
+}
+
+// startRetentionWorker applies the retention policies of the configuration
+// periodically, notifying the deleted tags and manifests to the event sink
+// of the app. The policies are not applied while the registry is read-only.
+func (app *App) startRetentionWorker(config *configuration.Configuration) {
+	if config.Retention.Interval <= 0 || len(config.Retention.Policies) == 0 {
+		return
+	}
+
+	policies := RetentionPolicies(config)
+	if err := storage.ValidateRetentionPolicies(policies); err != nil {
+		panic(err)
+	}
+	registry, err := storage.NewRegistry(app, app.driver, app.layoutOptions...)
+	if err != nil {
+		panic("could not create registry: " + err.Error())
+	}
+
+	source := app.events.source
+	source.Provider = retentionEventProvider
+	opts := storage.RetentionOpts{
+		Policies: policies,
+		DryRun:   config.Retention.DryRun,
+		Listener: notifications.NewBridge(nil, source, notifications.ActorRecord{}, notifications.RequestRecord{}, app.events.sink, false),
+	}
+
+	go func() {
+		log := dcontext.GetLogger(app)
+		ticker := time.NewTicker(config.Retention.Interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			if app.isReadOnly() {
+				log.Infof("Skipping the retention policies while the registry is read-only")
+				continue
+			}
+			deleted, err := storage.ApplyRetention(app, app.driver, registry, opts)
+			for _, ref := range deleted {
+				if opts.DryRun {
+					log.Infof("Retention policies select %s", ref)
+				} else {
+					log.Infof("Retention policies deleted %s", ref)
+				}
+			}
+			if err != nil {
+				log.Errorf("Error applying the retention policies: %v", err)
+			}
+		}
+	}()
+}
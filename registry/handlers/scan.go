@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/scan"
+	events "github.com/docker/go-events"
+)
+
+// configureScan starts the scanning of the images pushed to the registry, or
+// cached by a pull through cache, if a scanner is configured. Like the
+// replication, it must be called before the registry is configured as a
+// pull through cache, so that the events of the cache reach the scanner and
+// the summaries are stored in the local storage.
+func (app *App) configureScan(config *configuration.Configuration) {
+	if config.Scan.URL == "" {
+		return
+	}
+
+	scanner, err := scan.NewScanner(app, app.registry, config.Scan)
+	if err != nil {
+		panic(err)
+	}
+	app.scanner = scanner
+	app.events.sink = events.NewBroadcaster(app.events.sink, scanner)
+	dcontext.GetLogger(app).Infof("scanning images with %s", config.Scan.URL)
+}
@@ -0,0 +1,112 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/search"
+	events "github.com/docker/go-events"
+	"github.com/gorilla/handlers"
+)
+
+// configureSearch builds the search index in the background, and keeps it
+// up to date with the events of the registry. It must be called before the
+// registry is configured as a pull through cache, so that the index reads
+// the cached manifests from the local storage, and receives the events of
+// the cache.
+func (app *App) configureSearch(config *configuration.Configuration) {
+	if !config.Search.Enabled {
+		return
+	}
+
+	index := search.NewIndex(app, app.registry, app.isCache && config.Proxy.EnableNamespaces)
+	app.searchIndex = index
+	app.events.sink = events.NewBroadcaster(app.events.sink, index)
+
+	go func() {
+		log := dcontext.GetLogger(app)
+		for {
+			if err := index.Rebuild(app); err != nil {
+				log.Errorf("failed to build the search index: %v", err)
+			}
+			if config.Search.RefreshInterval <= 0 {
+				return
+			}
+			select {
+			case <-app.Done():
+				return
+			case <-time.After(config.Search.RefreshInterval):
+			}
+		}
+	}()
+}
+
+// searchDispatcher constructs the search handler.
+func searchDispatcher(ctx *Context, r *http.Request) http.Handler {
+	searchHandler := &searchHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(searchHandler.GetSearch),
+	}
+}
+
+type searchHandler struct {
+	*Context
+}
+
+type searchAPIResponse struct {
+	Results []search.Result `json:"results"`
+}
+
+// GetSearch returns a page of the repositories matching the query.
+func (sh *searchHandler) GetSearch(w http.ResponseWriter, r *http.Request) {
+	if sh.App.searchIndex == nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	q := r.URL.Query()
+	entries := defaultReturnedEntries
+	maximumConfiguredEntries := sh.App.Config.Catalog.MaxEntries
+	if n := q.Get("n"); n != "" {
+		parsedMax, err := strconv.Atoi(n)
+		if err != nil || parsedMax < 0 {
+			sh.Errors = append(sh.Errors, v2.ErrorCodePaginationNumberInvalid.WithDetail(map[string]string{"n": n}))
+			return
+		}
+		if parsedMax > maximumConfiguredEntries {
+			sh.Errors = append(sh.Errors, v2.ErrorCodePaginationNumberInvalid.WithDetail(map[string]int{"n": parsedMax}))
+			return
+		}
+		entries = parsedMax
+	}
+	if entries > maximumConfiguredEntries {
+		entries = maximumConfiguredEntries
+	}
+
+	results, more := sh.App.searchIndex.Search(search.ParseQuery(q.Get("q")), q.Get("last"), entries)
+
+	w.Header().Set("Content-Type", "application/json")
+	if more && len(results) > 0 {
+		urlStr, err := createLinkEntry(r.URL.String(), entries, results[len(results)-1].Repository)
+		if err != nil {
+			sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+			return
+		}
+		w.Header().Set("Link", urlStr)
+	}
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(searchAPIResponse{Results: results}); err != nil {
+		sh.Errors = append(sh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
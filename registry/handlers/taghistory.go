@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/gorilla/handlers"
+)
+
+// tagHistoryDispatcher constructs the tag history handler api endpoint.
+func tagHistoryDispatcher(ctx *Context, r *http.Request) http.Handler {
+	tagHistoryHandler := &tagHistoryHandler{
+		Context: ctx,
+		Tag:     dcontext.GetStringValue(ctx, "vars.tag"),
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(tagHistoryHandler.GetTagHistory),
+	}
+}
+
+// tagHistoryHandler handles requests for the history of a tag.
+type tagHistoryHandler struct {
+	*Context
+
+	Tag string
+}
+
+type tagHistoryAPIResponse struct {
+	Name    string                         `json:"name"`
+	Tag     string                         `json:"tag"`
+	History []distribution.TagHistoryEntry `json:"history"`
+}
+
+// GetTagHistory returns the changes of the manifest the tag points to, from
+// the most recent one.
+func (th *tagHistoryHandler) GetTagHistory(w http.ResponseWriter, r *http.Request) {
+	defer r.Body.Close()
+
+	provider, ok := th.Repository.Tags(th).(distribution.TagHistoryProvider)
+	if !ok {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	history, err := provider.History(th, th.Tag)
+	if err == distribution.ErrUnsupported {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return
+	} else if err != nil {
+		switch err := err.(type) {
+		case distribution.ErrTagUnknown:
+			th.Errors = append(th.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+		case distribution.ErrRepositoryUnknown:
+			th.Errors = append(th.Errors, v2.ErrorCodeNameUnknown.WithDetail(map[string]string{"name": th.Repository.Named().Name()}))
+		case errcode.Error:
+			th.Errors = append(th.Errors, err)
+		default:
+			th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(tagHistoryAPIResponse{
+		Name:    th.Repository.Named().Name(),
+		Tag:     th.Tag,
+		History: history,
+	}); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,223 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/gorilla/handlers"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// defaultTrashRetention is how long deleted manifests are kept in the
+	// trash when no retention is configured.
+	defaultTrashRetention = 7 * 24 * time.Hour
+
+	// trashPurgeInterval is the interval between the purges of the
+	// manifests kept in the trash beyond the retention.
+	trashPurgeInterval = time.Hour
+)
+
+// configureTrash parses the trash config of the delete section of the
+// storage config.
+func (app *App) configureTrash(v interface{}) {
+	trash, ok := v.(map[interface{}]interface{})
+	if !ok {
+		panic("trash config key must contain additional keys")
+	}
+	if enabled, ok := trash["enabled"]; ok {
+		app.trashEnabled, ok = enabled.(bool)
+		if !ok {
+			panic("trash's enabled config key must have a boolean value")
+		}
+	}
+
+	app.trashRetention = defaultTrashRetention
+	if retention, ok := trash["retention"]; ok {
+		retentionStr, ok := retention.(string)
+		if !ok {
+			panic("trash's retention config key must be a duration")
+		}
+		var err error
+		app.trashRetention, err = time.ParseDuration(retentionStr)
+		if err != nil || app.trashRetention <= 0 {
+			panic(fmt.Sprintf("invalid trash retention %q", retentionStr))
+		}
+	}
+}
+
+// startTrashPurger purges the manifests kept in the trash beyond the
+// retention, at startup and then periodically.
+func (app *App) startTrashPurger() {
+	if !app.trashEnabled {
+		return
+	}
+
+	go func() {
+		log := dcontext.GetLogger(app)
+		ticker := time.NewTicker(trashPurgeInterval)
+		defer ticker.Stop()
+
+		for {
+			if app.isReadOnly() {
+				log.Infof("Skipping the purge of the trash while the registry is read-only")
+			} else {
+				expired, err := storage.ExpireTrash(app, app.driver, app.trashRetention)
+				for _, record := range expired {
+					log.Infof("Purged %s@%s from the trash", record.Repository, record.Digest)
+				}
+				if err != nil {
+					log.Errorf("Error purging the trash: %v", err)
+				}
+			}
+
+			select {
+			case <-app.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// trashDispatcher constructs the handler of the manifest trash.
+func trashDispatcher(ctx *Context, r *http.Request) http.Handler {
+	trashHandler := &trashHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{
+		http.MethodGet: http.HandlerFunc(trashHandler.GetTrash),
+	}
+	if !ctx.isReadOnly() {
+		mhandler[http.MethodPost] = http.HandlerFunc(trashHandler.RestoreManifest)
+		mhandler[http.MethodDelete] = http.HandlerFunc(trashHandler.PurgeManifest)
+	}
+	return mhandler
+}
+
+type trashHandler struct {
+	*Context
+}
+
+type trashedManifest struct {
+	storage.TrashedManifest
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+type trashAPIResponse struct {
+	Manifests []trashedManifest `json:"manifests"`
+}
+
+// GetTrash lists the manifests in the trash.
+func (th *trashHandler) GetTrash(w http.ResponseWriter, r *http.Request) {
+	if !th.App.trashEnabled {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	trashed, err := storage.ListTrash(th, th.App.driver)
+	if err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	response := trashAPIResponse{Manifests: make([]trashedManifest, 0, len(trashed))}
+	for _, record := range trashed {
+		response.Manifests = append(response.Manifests, th.withExpiry(record))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(response); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// RestoreManifest restores the manifest of the request from the trash.
+func (th *trashHandler) RestoreManifest(w http.ResponseWriter, r *http.Request) {
+	repoName, dgst, ok := th.trashedManifest(r)
+	if !ok {
+		return
+	}
+
+	restored, err := storage.RestoreManifest(th, th.App.driver, th.App.registry, repoName, dgst)
+	if err != nil {
+		th.appendTrashError(err)
+		return
+	}
+	dcontext.GetLogger(th).Infof("Restored %s@%s from the trash, with tags %v", repoName, dgst, restored.Tags)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(restored); err != nil {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// PurgeManifest purges the manifest of the request from the trash.
+func (th *trashHandler) PurgeManifest(w http.ResponseWriter, r *http.Request) {
+	repoName, dgst, ok := th.trashedManifest(r)
+	if !ok {
+		return
+	}
+
+	if err := storage.PurgeTrash(th, th.App.driver, repoName, dgst); err != nil {
+		th.appendTrashError(err)
+		return
+	}
+	dcontext.GetLogger(th).Infof("Purged %s@%s from the trash", repoName, dgst)
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// trashedManifest returns the repository and digest of the manifest of a
+// restore or purge request, once the request is validated.
+func (th *trashHandler) trashedManifest(r *http.Request) (string, digest.Digest, bool) {
+	if !th.App.trashEnabled {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported)
+		return "", "", false
+	}
+
+	q := r.URL.Query()
+	repoName := q.Get("repository")
+	if _, err := reference.WithName(repoName); err != nil {
+		th.Errors = append(th.Errors, v2.ErrorCodeRequestInvalid.WithDetail(fmt.Sprintf("invalid repository %q", repoName)))
+		return "", "", false
+	}
+	dgst, err := digest.Parse(q.Get("digest"))
+	if err != nil {
+		th.Errors = append(th.Errors, v2.ErrorCodeRequestInvalid.WithDetail(fmt.Sprintf("invalid digest %q", q.Get("digest"))))
+		return "", "", false
+	}
+	if th.App.readOnly.matches(repoName) {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnsupported.WithDetail("the repository is read-only"))
+		return "", "", false
+	}
+	return repoName, dgst, true
+}
+
+func (th *trashHandler) appendTrashError(err error) {
+	if err == storage.ErrManifestNotTrashed {
+		th.Errors = append(th.Errors, v2.ErrorCodeManifestUnknown.WithDetail(err))
+	} else {
+		th.Errors = append(th.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+	}
+}
+
+func (th *trashHandler) withExpiry(record storage.TrashedManifest) trashedManifest {
+	return trashedManifest{
+		TrashedManifest: record,
+		ExpiresAt:       record.DeletedAt.Add(th.App.trashRetention),
+	}
+}
@@ -0,0 +1,210 @@
+package handlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math"
+	"math/big"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/gorilla/handlers"
+)
+
+// uploadPurger deletes the stale uploads periodically, and on demand through
+// the admin endpoint.
+type uploadPurger struct {
+	driver   storagedriver.StorageDriver
+	opts     storage.UploadPurgeOpts
+	interval time.Duration
+
+	mu sync.Mutex // serializes the purges
+}
+
+// purge runs a purge pass, as a dry run if dryRun is set or configured.
+func (p *uploadPurger) purge(ctx context.Context, dryRun bool) (storage.UploadPurgeResult, []error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	opts := p.opts
+	opts.DryRun = opts.DryRun || dryRun
+	return storage.PurgeUploadsWithOpts(ctx, p.driver, opts)
+}
+
+// configureUploadPurger parses the uploadpurging config of the maintenance
+// section of the storage config.
+func (app *App) configureUploadPurger(config map[interface{}]interface{}) {
+	if config["enabled"] == false {
+		return
+	}
+
+	durationValue := func(key string) time.Duration {
+		value, ok := config[key]
+		if !ok {
+			badPurgeUploadConfig(key + " missing")
+		}
+		str, ok := value.(string)
+		if !ok {
+			badPurgeUploadConfig(key + " is not a string")
+		}
+		d, err := time.ParseDuration(str)
+		if err != nil {
+			badPurgeUploadConfig(fmt.Sprintf("Cannot parse %s: %s", key, err.Error()))
+		}
+		return d
+	}
+
+	p := &uploadPurger{
+		driver:   app.driver,
+		interval: durationValue("interval"),
+	}
+	p.opts.Age = durationValue("age")
+	if _, ok := config["resumableage"]; ok {
+		p.opts.ResumableAge = durationValue("resumableage")
+	}
+
+	dryRun, ok := config["dryrun"]
+	if !ok {
+		badPurgeUploadConfig("dryrun missing")
+	}
+	p.opts.DryRun, ok = dryRun.(bool)
+	if !ok {
+		badPurgeUploadConfig("cannot parse dryrun")
+	}
+
+	if repositories, ok := config["repositories"]; ok {
+		rules, ok := repositories.([]interface{})
+		if !ok {
+			badPurgeUploadConfig("repositories is not a list")
+		}
+		for _, r := range rules {
+			rule, ok := r.(map[interface{}]interface{})
+			if !ok {
+				badPurgeUploadConfig("repositories must contain pattern and age keys")
+			}
+			pattern, ok := rule["pattern"].(string)
+			if !ok {
+				badPurgeUploadConfig("repository pattern is not a string")
+			}
+			ageStr, ok := rule["age"].(string)
+			if !ok {
+				badPurgeUploadConfig(fmt.Sprintf("age of repositories %s is not a string", pattern))
+			}
+			age, err := time.ParseDuration(ageStr)
+			if err != nil {
+				badPurgeUploadConfig(fmt.Sprintf("Cannot parse age of repositories %s: %s", pattern, err.Error()))
+			}
+			p.opts.Repositories = append(p.opts.Repositories, storage.UploadPurgeRule{Pattern: pattern, Age: age})
+		}
+	}
+
+	if err := storage.ValidateUploadPurgeOpts(p.opts); err != nil {
+		badPurgeUploadConfig(err.Error())
+	}
+	app.uploadPurger = p
+}
+
+// startUploadPurger schedules a goroutine which will periodically
+// check upload directories for old files and delete them. The uploads are
+// not purged while the registry is read-only.
+func (app *App) startUploadPurger() {
+	p := app.uploadPurger
+	if p == nil {
+		return
+	}
+
+	go func() {
+		log := dcontext.GetLogger(app)
+		randInt, err := rand.Int(rand.Reader, new(big.Int).SetInt64(math.MaxInt64))
+		if err != nil {
+			log.Infof("Failed to generate random jitter: %v", err)
+			// sleep 30min for failure case
+			randInt = big.NewInt(30)
+		}
+		jitter := time.Duration(randInt.Int64()%60) * time.Minute
+		log.Infof("Starting upload purge in %s", jitter)
+		time.Sleep(jitter)
+
+		for {
+			if app.isReadOnly() {
+				log.Infof("Skipping the upload purge while the registry is read-only")
+			} else {
+				p.purge(app, false)
+			}
+			log.Infof("Starting upload purge in %s", p.interval)
+			time.Sleep(p.interval)
+		}
+	}()
+}
+
+// uploadPurgingDispatcher constructs the handler triggering upload purges.
+func uploadPurgingDispatcher(ctx *Context, r *http.Request) http.Handler {
+	uploadPurgingHandler := &uploadPurgingHandler{
+		Context: ctx,
+	}
+
+	mhandler := handlers.MethodHandler{}
+	if !ctx.isReadOnly() {
+		mhandler[http.MethodPost] = http.HandlerFunc(uploadPurgingHandler.PurgeUploads)
+	}
+	return mhandler
+}
+
+type uploadPurgingHandler struct {
+	*Context
+}
+
+type uploadPurgingAPIResponse struct {
+	Deleted []string `json:"deleted"`
+	Bytes   int64    `json:"bytes"`
+	DryRun  bool     `json:"dryrun"`
+	Errors  []string `json:"errors,omitempty"`
+}
+
+// PurgeUploads runs a purge pass of the stale uploads, as configured, and
+// reports the uploads deleted.
+func (uh *uploadPurgingHandler) PurgeUploads(w http.ResponseWriter, r *http.Request) {
+	p := uh.App.uploadPurger
+	if p == nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported.WithDetail("upload purging is disabled"))
+		return
+	}
+	var dryRun bool
+	if value := r.FormValue("dryrun"); value != "" {
+		var err error
+		if dryRun, err = strconv.ParseBool(value); err != nil {
+			uh.Errors = append(uh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(fmt.Sprintf("invalid dryrun %q", value)))
+			return
+		}
+	}
+
+	result, errs := p.purge(uh, dryRun)
+	response := uploadPurgingAPIResponse{
+		Deleted: result.Deleted,
+		Bytes:   result.Bytes,
+		DryRun:  p.opts.DryRun || dryRun,
+	}
+	if response.Deleted == nil {
+		response.Deleted = []string{}
+	}
+	for _, err := range errs {
+		response.Errors = append(response.Errors, err.Error())
+	}
+	dcontext.GetLogger(uh).Infof("Purged %d uploads, %d bytes, on demand", len(result.Deleted), result.Bytes)
+
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(response); err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/auth"
+	"github.com/gorilla/handlers"
+)
+
+// usersDispatcher constructs the handler of the users of the access
+// controller.
+func usersDispatcher(ctx *Context, r *http.Request) http.Handler {
+	usersHandler := &usersHandler{
+		Context: ctx,
+	}
+
+	return handlers.MethodHandler{
+		http.MethodGet:    http.HandlerFunc(usersHandler.GetUsers),
+		http.MethodPut:    http.HandlerFunc(usersHandler.PutUser),
+		http.MethodDelete: http.HandlerFunc(usersHandler.DeleteUser),
+	}
+}
+
+type usersHandler struct {
+	*Context
+}
+
+type usersAPIResponse struct {
+	Users []string `json:"users"`
+}
+
+type userAPIRequest struct {
+	Name     string `json:"name"`
+	Password string `json:"password"`
+}
+
+// GetUsers lists the names of the users.
+func (uh *usersHandler) GetUsers(w http.ResponseWriter, r *http.Request) {
+	if uh.App.users == nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	users, err := uh.App.users.Users()
+	if err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+	if users == nil {
+		users = []string{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	enc := json.NewEncoder(w)
+	if err := enc.Encode(usersAPIResponse{Users: users}); err != nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		return
+	}
+}
+
+// PutUser adds the user of the request, or replaces its password.
+func (uh *usersHandler) PutUser(w http.ResponseWriter, r *http.Request) {
+	if uh.App.users == nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	var req userAPIRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		uh.Errors = append(uh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		return
+	}
+	if err := uh.App.users.SetPassword(req.Name, req.Password); err != nil {
+		if errors.Is(err, auth.ErrInvalidUser) {
+			uh.Errors = append(uh.Errors, v2.ErrorCodeRequestInvalid.WithDetail(err.Error()))
+		} else {
+			uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+	dcontext.GetLogger(uh).Infof("Set the password of user %q", req.Name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// DeleteUser removes the user of the request.
+func (uh *usersHandler) DeleteUser(w http.ResponseWriter, r *http.Request) {
+	if uh.App.users == nil {
+		uh.Errors = append(uh.Errors, errcode.ErrorCodeUnsupported)
+		return
+	}
+
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		uh.Errors = append(uh.Errors, v2.ErrorCodeRequestInvalid.WithDetail("missing user name"))
+		return
+	}
+	if err := uh.App.users.RemoveUser(name); err != nil {
+		if errors.Is(err, auth.ErrUserUnknown) {
+			uh.Errors = append(uh.Errors, v2.ErrorCodeUserUnknown.WithDetail(name))
+		} else {
+			uh.Errors = append(uh.Errors, errcode.ErrorCodeUnknown.WithDetail(err))
+		}
+		return
+	}
+	dcontext.GetLogger(uh).Infof("Removed user %q", name)
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,80 @@
+package registry
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/http2"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// configureHTTP2 serves HTTP/2 on the TLS connections of server with the
+// limits of http.http2, unless it is disabled, and returns the handler of
+// server serving HTTP/2 without TLS if h2c is enabled.
+func configureHTTP2(server *http.Server, config *configuration.Configuration) (http.Handler, error) {
+	if config.HTTP.HTTP2.Disabled {
+		return server.Handler, nil
+	}
+	h2 := &http2.Server{
+		MaxConcurrentStreams: config.HTTP.HTTP2.MaxConcurrentStreams,
+		MaxReadFrameSize:     config.HTTP.HTTP2.MaxReadFrameSize,
+		IdleTimeout:          config.HTTP.HTTP2.IdleTimeout,
+	}
+	if err := http2.ConfigureServer(server, h2); err != nil {
+		return nil, err
+	}
+	if !config.HTTP.HTTP2.H2C {
+		return server.Handler, nil
+	}
+	return h2cHandler(server, h2, server.Handler), nil
+}
+
+// h2cHandler serves HTTP/2 without TLS to the clients starting their
+// connection with the HTTP/2 preface, the other requests being served by
+// handler. The upgrade of HTTP/1.1 connections is not supported.
+func h2cHandler(server *http.Server, h2 *http2.Server, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the server parses the first line of the preface as a request
+		if r.Method != "PRI" || r.URL.Path != "*" || r.Proto != "HTTP/2.0" || len(r.Header) != 0 {
+			handler.ServeHTTP(w, r)
+			return
+		}
+		hijacker, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, "h2c not supported", http.StatusInternalServerError)
+			return
+		}
+		conn, rw, err := hijacker.Hijack()
+		if err != nil {
+			return
+		}
+
+		// the rest of the preface follows the request
+		const remainder = "SM\r\n\r\n"
+		buf := make([]byte, len(remainder))
+		if _, err := io.ReadFull(rw, buf); err != nil || string(buf) != remainder {
+			conn.Close()
+			return
+		}
+		h2.ServeConn(&bufferedConn{Conn: conn, r: rw.Reader}, &http2.ServeConnOpts{
+			Context:          r.Context(),
+			BaseConfig:       server,
+			Handler:          handler,
+			SawClientPreface: true,
+		})
+	})
+}
+
+// bufferedConn reads a connection hijacked from the server through the
+// buffer of the server.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.r.Read(p)
+}
@@ -0,0 +1,168 @@
+// Package ipfilter restricts the networks clients can reach the registry
+// from, and the repositories matching patterns, so that internal-only
+// repositories can't be pulled from outside the network of the cluster even
+// with valid credentials.
+//
+// The address of the client is the address of the connection, or, when it
+// comes from a trusted proxy, the address the proxies set in their
+// forwarding header, skipping the trusted proxies which forwarded the
+// request.
+package ipfilter
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// DefaultHeader is the header trusted proxies set to the address of the
+// client when none is configured.
+const DefaultHeader = "X-Forwarded-For"
+
+type rule struct {
+	repositories []string
+	allow        []*net.IPNet
+}
+
+// Filter allows the clients of the configured networks.
+type Filter struct {
+	trustedProxies []*net.IPNet
+	header         string
+	allow          []*net.IPNet
+	rules          []rule
+}
+
+// New validates the networks and rules of the configuration and returns the
+// filter they define.
+func New(config configuration.IPFilter) (*Filter, error) {
+	f := &Filter{header: config.Header}
+	if f.header == "" {
+		f.header = DefaultHeader
+	}
+
+	var err error
+	if f.trustedProxies, err = parseNetworks(config.TrustedProxies); err != nil {
+		return nil, fmt.Errorf("ipfilter trustedproxies: %v", err)
+	}
+	if f.allow, err = parseNetworks(config.Allow); err != nil {
+		return nil, fmt.Errorf("ipfilter allow: %v", err)
+	}
+	for i, r := range config.Rules {
+		if len(r.Repositories) == 0 {
+			return nil, fmt.Errorf("ipfilter rule %d: repositories must be set", i)
+		}
+		for _, pattern := range r.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("ipfilter rule %d: invalid repository pattern %q: %v", i, pattern, err)
+			}
+		}
+		if len(r.Allow) == 0 {
+			return nil, fmt.Errorf("ipfilter rule %d: allow must be set", i)
+		}
+		allow, err := parseNetworks(r.Allow)
+		if err != nil {
+			return nil, fmt.Errorf("ipfilter rule %d: %v", i, err)
+		}
+		f.rules = append(f.rules, rule{repositories: r.Repositories, allow: allow})
+	}
+	return f, nil
+}
+
+// parseNetworks parses networks in CIDR notation, or single addresses.
+func parseNetworks(values []string) ([]*net.IPNet, error) {
+	networks := make([]*net.IPNet, 0, len(values))
+	for _, v := range values {
+		if !strings.Contains(v, "/") {
+			ip := net.ParseIP(v)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid address %q", v)
+			}
+			bits := 8 * net.IPv6len
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, bits = ip4, 8*net.IPv4len
+			}
+			networks = append(networks, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, network, err := net.ParseCIDR(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid network %q", v)
+		}
+		networks = append(networks, network)
+	}
+	return networks, nil
+}
+
+// ClientIP returns the address of the client of the request, or nil if it
+// is not known.
+func (f *Filter) ClientIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !contains(f.trustedProxies, ip) {
+		return ip
+	}
+
+	// Each proxy appends the address it received the request from, so the
+	// client is the last address which is not a trusted proxy
+	var forwarded []string
+	for _, value := range r.Header.Values(f.header) {
+		forwarded = append(forwarded, strings.Split(value, ",")...)
+	}
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		prior := net.ParseIP(strings.TrimSpace(forwarded[i]))
+		if prior == nil {
+			break
+		}
+		ip = prior
+		if !contains(f.trustedProxies, ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// Allowed reports whether the client of the request can reach the registry
+// and, if not empty, the repository, and returns the address of the client.
+func (f *Filter) Allowed(r *http.Request, repository string) (net.IP, bool) {
+	ip := f.ClientIP(r)
+	if ip == nil {
+		return nil, len(f.allow) == 0 && len(f.rules) == 0
+	}
+	if len(f.allow) > 0 && !contains(f.allow, ip) {
+		return ip, false
+	}
+	if repository == "" {
+		return ip, true
+	}
+	for _, rule := range f.rules {
+		if matchesAny(rule.repositories, repository) {
+			return ip, contains(rule.allow, ip)
+		}
+	}
+	return ip, true
+}
+
+func contains(networks []*net.IPNet, ip net.IP) bool {
+	for _, network := range networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,91 @@
+package ipfilter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func request(remoteAddr string, forwarded ...string) *http.Request {
+	r := httptest.NewRequest(http.MethodGet, "http://registry.example.com/v2/", nil)
+	r.RemoteAddr = remoteAddr
+	for _, v := range forwarded {
+		r.Header.Add("X-Forwarded-For", v)
+	}
+	return r
+}
+
+func TestClientIP(t *testing.T) {
+	f, err := New(configuration.IPFilter{TrustedProxies: []string{"10.0.0.0/8", "192.168.1.1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		r        *http.Request
+		expected string
+	}{
+		{request("203.0.113.7:1234"), "203.0.113.7"},
+		// The header of untrusted clients is ignored
+		{request("203.0.113.7:1234", "10.1.2.3"), "203.0.113.7"},
+		{request("10.0.0.1:1234", "203.0.113.7"), "203.0.113.7"},
+		// Addresses forged by the client before the proxies are skipped
+		{request("10.0.0.1:1234", "10.1.1.1, 203.0.113.7, 192.168.1.1"), "203.0.113.7"},
+		{request("10.0.0.1:1234", "198.51.100.1", "203.0.113.7"), "203.0.113.7"},
+		{request("10.0.0.1:1234", "garbage, 10.0.0.2"), "10.0.0.2"},
+		{request("10.0.0.1:1234"), "10.0.0.1"},
+	} {
+		ip := f.ClientIP(tc.r)
+		if ip.String() != tc.expected {
+			t.Errorf("%s %v: expected client %s, got %s", tc.r.RemoteAddr, tc.r.Header.Values("X-Forwarded-For"), tc.expected, ip)
+		}
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	f, err := New(configuration.IPFilter{
+		Allow: []string{"10.0.0.0/8", "203.0.113.0/24", "2001:db8::/32"},
+		Rules: []configuration.IPFilterRule{
+			{Repositories: []string{"internal/*"}, Allow: []string{"10.0.0.0/8", "2001:db8::1"}},
+			{Repositories: []string{"*/*"}, Allow: []string{"0.0.0.0/0", "::/0"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		remoteAddr string
+		repository string
+		expected   bool
+	}{
+		{"10.1.2.3:1234", "", true},
+		{"198.51.100.1:1234", "", false},
+		{"198.51.100.1:1234", "public/app", false},
+		{"203.0.113.7:1234", "public/app", true},
+		{"203.0.113.7:1234", "internal/app", false},
+		{"10.1.2.3:1234", "internal/app", true},
+		{"[2001:db8::1]:1234", "internal/app", true},
+		{"[2001:db8::2]:1234", "internal/app", false},
+	} {
+		if _, allowed := f.Allowed(request(tc.remoteAddr), tc.repository); allowed != tc.expected {
+			t.Errorf("%s to %q: expected allowed %v", tc.remoteAddr, tc.repository, tc.expected)
+		}
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, config := range []configuration.IPFilter{
+		{Allow: []string{"10.0.0.0/33"}},
+		{TrustedProxies: []string{"proxy"}},
+		{Rules: []configuration.IPFilterRule{{Allow: []string{"10.0.0.0/8"}}}},
+		{Rules: []configuration.IPFilterRule{{Repositories: []string{"internal/*"}}}},
+		{Rules: []configuration.IPFilterRule{{Repositories: []string{"internal/["}, Allow: []string{"10.0.0.0/8"}}}},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("expected %+v to be invalid", config)
+		}
+	}
+}
@@ -27,13 +27,16 @@ func (ln tcpKeepAliveListener) Accept() (c net.Conn, err error) {
 }
 
 // NewListener announces on laddr and net. Accepted values of the net are
-// 'unix' and 'tcp'
+// 'unix', 'tcp' and 'systemd', for which laddr is the name of a socket
+// passed by systemd, or empty for the first one
 func NewListener(net, laddr string) (net.Listener, error) {
 	switch net {
 	case "unix":
 		return newUnixListener(laddr)
 	case "tcp", "": // an empty net means tcp
 		return newTCPListener(laddr)
+	case "systemd":
+		return newSystemdListener(laddr)
 	default:
 		return nil, fmt.Errorf("unknown address type %s", net)
 	}
@@ -0,0 +1,89 @@
+package listener
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// listenFDsStart is the first file descriptor of the sockets passed by
+// systemd.
+const listenFDsStart = 3
+
+// activated holds the sockets passed by systemd to the process, by the
+// socket activation protocol.
+var activated struct {
+	once  sync.Once
+	mu    sync.Mutex
+	files []*os.File
+	names []string
+}
+
+// activatedSockets takes the sockets passed by systemd from the
+// environment, so that they are not inherited by child processes.
+func activatedSockets() {
+	defer os.Unsetenv("LISTEN_PID")
+	defer os.Unsetenv("LISTEN_FDS")
+	defer os.Unsetenv("LISTEN_FDNAMES")
+
+	if pid, err := strconv.Atoi(os.Getenv("LISTEN_PID")); err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv("LISTEN_FDS"))
+	if err != nil || n <= 0 {
+		return
+	}
+	names := strings.Split(os.Getenv("LISTEN_FDNAMES"), ":")
+	for i := 0; i < n; i++ {
+		name := ""
+		if i < len(names) {
+			name = names[i]
+		}
+		activated.files = append(activated.files, os.NewFile(uintptr(listenFDsStart+i), name))
+		activated.names = append(activated.names, name)
+	}
+}
+
+// newSystemdListener returns a listener of the socket passed by systemd
+// named name, as set by FileDescriptorName=, or of the first one if name is
+// empty. Each socket is listened on once.
+func newSystemdListener(name string) (net.Listener, error) {
+	activated.once.Do(activatedSockets)
+	activated.mu.Lock()
+	defer activated.mu.Unlock()
+
+	if len(activated.files) == 0 {
+		return nil, fmt.Errorf("no socket passed by systemd")
+	}
+	for i, f := range activated.files {
+		if name != "" && activated.names[i] != name {
+			continue
+		}
+		if f == nil {
+			if name == "" {
+				continue
+			}
+			return nil, fmt.Errorf("socket %s passed by systemd is already listened on", name)
+		}
+
+		ln, err := net.FileListener(f)
+		if err != nil {
+			return nil, fmt.Errorf("socket %q passed by systemd: %v", activated.names[i], err)
+		}
+		// the listener has its own copy of the file descriptor
+		f.Close()
+		activated.files[i] = nil
+
+		if tcp, ok := ln.(*net.TCPListener); ok {
+			return tcpKeepAliveListener{tcp}, nil
+		}
+		return ln, nil
+	}
+	if name == "" {
+		return nil, fmt.Errorf("all the sockets passed by systemd are already listened on")
+	}
+	return nil, fmt.Errorf("no socket named %s passed by systemd", name)
+}
@@ -0,0 +1,196 @@
+package registry
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/docker/go-metrics"
+	"github.com/sirupsen/logrus"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/listener"
+)
+
+// adminPathPrefixes are the prefixes of the endpoints served by the admin
+// listener, rather than by the API listener, when it is configured.
+var adminPathPrefixes = []string{"/v2/_admin/", "/v2/_proxy/", "/v2/_replication/"}
+
+// planeListener is a listener serving a part of the registry apart from the
+// API, such as the metrics.
+type planeListener struct {
+	name   string
+	config configuration.Listener
+	server *http.Server
+}
+
+// configureListeners returns the listeners of http.listeners, serving the
+// metrics and the endpoints of handler under adminPathPrefixes, and the
+// handler of the API without the endpoints served by the listeners.
+func configureListeners(config *configuration.Configuration, handler http.Handler) ([]*planeListener, http.Handler, error) {
+	var listeners []*planeListener
+
+	if metricsConfig := config.HTTP.Listeners.Metrics; metricsConfig.Addr != "" {
+		path := config.HTTP.Debug.Prometheus.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		mux := http.NewServeMux()
+		mux.Handle(path, metrics.Handler())
+		listeners = append(listeners, &planeListener{
+			name:   "metrics",
+			config: metricsConfig,
+			server: &http.Server{Handler: tokenHandler(metricsConfig.Auth.Token, mux)},
+		})
+	}
+
+	if adminConfig := config.HTTP.Listeners.Admin; adminConfig.Addr != "" {
+		if adminConfig.Auth.Token != "" && config.Auth.Type() != "" {
+			return nil, nil, errors.New("http.listeners.admin.auth.token can't be set along with auth, as both use the Authorization header")
+		}
+		prefix := config.HTTP.Prefix
+		listeners = append(listeners, &planeListener{
+			name:   "admin",
+			config: adminConfig,
+			server: &http.Server{Handler: tokenHandler(adminConfig.Auth.Token, planeHandler(prefix, true, handler))},
+		})
+		handler = planeHandler(prefix, false, handler)
+	}
+
+	return listeners, handler, nil
+}
+
+// planeHandler serves the requests of handler for the admin endpoints, with
+// admin, or for the other endpoints, without. The other requests are not
+// found.
+func planeHandler(prefix string, admin bool, handler http.Handler) http.Handler {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isAdminPath(strings.TrimPrefix(r.URL.Path, prefix)) != admin {
+			http.NotFound(w, r)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+func isAdminPath(path string) bool {
+	for _, prefix := range adminPathPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// tokenHandler requires the requests of handler to present token as a bearer
+// token, if set.
+func tokenHandler(token string, handler http.Handler) http.Handler {
+	if token == "" {
+		return handler
+	}
+	expected := []byte("Bearer " + token)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(r.Header.Get("Authorization")), expected) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// listen announces on the address of the listener, with TLS if configured.
+func (l *planeListener) listen() (net.Listener, error) {
+	ln, err := listener.NewListener(l.config.Net, l.config.Addr)
+	if err != nil {
+		return nil, fmt.Errorf("%s listener: %v", l.name, err)
+	}
+	tlsConf, err := listenerTLSConfig(l.config.TLS)
+	if err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("%s listener: %v", l.name, err)
+	}
+	if tlsConf != nil {
+		ln = tls.NewListener(ln, tlsConf)
+		logrus.Infof("%s listener listening on %v, tls", l.name, ln.Addr())
+	} else {
+		logrus.Infof("%s listener listening on %v", l.name, ln.Addr())
+	}
+	return ln, nil
+}
+
+// listenerTLSConfig returns the TLS configuration of a listener, nil if it
+// is served without TLS.
+func listenerTLSConfig(config configuration.ListenerTLS) (*tls.Config, error) {
+	if config.Certificate == "" {
+		if len(config.ClientCAs) != 0 {
+			return nil, errors.New("clientcas require a certificate")
+		}
+		return nil, nil
+	}
+
+	certs, err := newCertReloader(config.Certificate, config.Key)
+	if err != nil {
+		return nil, err
+	}
+	tlsConf := &tls.Config{
+		GetCertificate: certs.GetCertificate,
+		MinVersion:     tls.VersionTLS12,
+	}
+	if len(config.ClientCAs) != 0 {
+		pool := x509.NewCertPool()
+		for _, ca := range config.ClientCAs {
+			caPem, err := os.ReadFile(ca)
+			if err != nil {
+				return nil, err
+			}
+			if ok := pool.AppendCertsFromPEM(caPem); !ok {
+				return nil, fmt.Errorf("could not add CA %s to pool", ca)
+			}
+		}
+		tlsConf.ClientCAs = pool
+		tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return tlsConf, nil
+}
+
+// serveListeners starts serving the listeners of http.listeners. It returns
+// once they are all listening.
+func (registry *Registry) serveListeners() error {
+	lns := make([]net.Listener, 0, len(registry.listeners))
+	for _, l := range registry.listeners {
+		ln, err := l.listen()
+		if err != nil {
+			for _, ln := range lns {
+				ln.Close()
+			}
+			return err
+		}
+		lns = append(lns, ln)
+	}
+	for i, l := range registry.listeners {
+		go func(l *planeListener, ln net.Listener) {
+			if err := l.server.Serve(ln); err != nil && err != http.ErrServerClosed {
+				logrus.Fatalf("error serving the %s listener: %v", l.name, err)
+			}
+		}(l, lns[i])
+	}
+	return nil
+}
+
+// shutdownListeners gracefully shuts the listeners of http.listeners down.
+func (registry *Registry) shutdownListeners(ctx context.Context) {
+	for _, l := range registry.listeners {
+		if err := l.server.Shutdown(ctx); err != nil {
+			logrus.Errorf("error shutting the %s listener down: %v", l.name, err)
+		}
+	}
+}
@@ -0,0 +1,296 @@
+// Package plugin defines the gRPC protocol of the middleware plugins, the
+// processes running next to the registry which add custom logic to it, such
+// as policy checks on the content of repositories or the redirection of blob
+// reads to an exotic storage, without forking the registry.
+//
+// The messages are encoded in JSON, with the "json" content subtype of gRPC
+// (application/grpc+json), so that plugins can be written in any language
+// with a gRPC implementation supporting custom codecs, without generated
+// code. Go plugins implement Server and register it with RegisterServer.
+package plugin
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/status"
+)
+
+// ServiceName is the name of the gRPC service plugins implement.
+const ServiceName = "distribution.middleware.v1.Plugin"
+
+// codecName is the content subtype of the messages of the protocol.
+const codecName = "json"
+
+// The operations checked by repository middleware plugins
+const (
+	OperationManifestGet    = "manifest.get"
+	OperationManifestPut    = "manifest.put"
+	OperationManifestDelete = "manifest.delete"
+	OperationBlobGet        = "blob.get"
+	OperationBlobPut        = "blob.put"
+	OperationBlobDelete     = "blob.delete"
+	OperationTag            = "tag.tag"
+	OperationUntag          = "tag.untag"
+)
+
+// Operations are all the operations checked by repository middleware
+// plugins.
+var Operations = []string{
+	OperationManifestGet,
+	OperationManifestPut,
+	OperationManifestDelete,
+	OperationBlobGet,
+	OperationBlobPut,
+	OperationBlobDelete,
+	OperationTag,
+	OperationUntag,
+}
+
+// CheckRequest asks a plugin whether an operation on the content of a
+// repository is allowed.
+type CheckRequest struct {
+	Operation  string `json:"operation"`
+	Repository string `json:"repository"`
+	Digest     string `json:"digest,omitempty"`
+	MediaType  string `json:"mediaType,omitempty"`
+	Size       int64  `json:"size,omitempty"`
+	Tag        string `json:"tag,omitempty"`
+	// Payload is the content of the manifest put
+	Payload []byte `json:"payload,omitempty"`
+}
+
+// CheckResponse is the decision of a plugin on an operation.
+type CheckResponse struct {
+	Allow bool `json:"allow"`
+	// Reason is the message of the error returned to clients when the
+	// operation is denied
+	Reason string `json:"reason,omitempty"`
+}
+
+// URLForRequest asks a plugin for the URL clients are redirected to in
+// order to read the content at the path of the storage.
+type URLForRequest struct {
+	Path   string `json:"path"`
+	Method string `json:"method,omitempty"`
+}
+
+// URLForResponse is the URL returned by a plugin, empty when the content is
+// served as if the plugin was not configured.
+type URLForResponse struct {
+	URL string `json:"url,omitempty"`
+}
+
+// Server is the interface of the plugins. A plugin returning an error with
+// the Unimplemented code of gRPC from a method, as UnimplementedServer does,
+// is considered to have no opinion: the operation is allowed, or the URL of
+// the storage driver is used.
+type Server interface {
+	Check(ctx context.Context, req *CheckRequest) (*CheckResponse, error)
+	URLFor(ctx context.Context, req *URLForRequest) (*URLForResponse, error)
+}
+
+// UnimplementedServer can be embedded by plugins implementing only some of
+// the methods of Server.
+type UnimplementedServer struct{}
+
+// Check is not implemented.
+func (UnimplementedServer) Check(ctx context.Context, req *CheckRequest) (*CheckResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method Check not implemented")
+}
+
+// URLFor is not implemented.
+func (UnimplementedServer) URLFor(ctx context.Context, req *URLForRequest) (*URLForResponse, error) {
+	return nil, status.Error(codes.Unimplemented, "method URLFor not implemented")
+}
+
+// jsonCodec encodes the messages of the protocol in JSON.
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error {
+	return json.Unmarshal(data, v)
+}
+
+func (jsonCodec) Name() string {
+	return codecName
+}
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+func checkHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &CheckRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).Check(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/Check"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).Check(ctx, req.(*CheckRequest))
+	})
+}
+
+func urlForHandler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	req := &URLForRequest{}
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(Server).URLFor(ctx, req)
+	}
+	info := &grpc.UnaryServerInfo{Server: srv, FullMethod: "/" + ServiceName + "/URLFor"}
+	return interceptor(ctx, req, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(Server).URLFor(ctx, req.(*URLForRequest))
+	})
+}
+
+var serviceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*Server)(nil),
+	Methods: []grpc.MethodDesc{
+		{MethodName: "Check", Handler: checkHandler},
+		{MethodName: "URLFor", Handler: urlForHandler},
+	},
+	Metadata: "distribution/middleware/v1/plugin",
+}
+
+// RegisterServer registers the plugin with the gRPC server.
+func RegisterServer(s *grpc.Server, srv Server) {
+	s.RegisterService(&serviceDesc, srv)
+}
+
+// Client calls a plugin.
+type Client struct {
+	conn    *grpc.ClientConn
+	timeout time.Duration
+}
+
+// defaultTimeout is how long the registry waits for a plugin by default.
+const defaultTimeout = 5 * time.Second
+
+// Options are the options of the connection to a plugin, common to the
+// repository and storage middleware.
+type Options struct {
+	// Address is the gRPC target of the plugin, such as localhost:5050 or
+	// unix:///run/registry/plugin.sock
+	Address string
+	// TLS connects to the plugin with TLS, verified with the system roots
+	TLS bool
+	// Timeout bounds the calls to the plugin
+	Timeout time.Duration
+}
+
+// ParseOptions parses the options of the connection to a plugin.
+func ParseOptions(options map[string]interface{}) (Options, error) {
+	var o Options
+	address, ok := options["address"].(string)
+	if !ok || address == "" {
+		return o, fmt.Errorf("plugin: no address provided")
+	}
+	o.Address = address
+
+	switch v := options["tls"].(type) {
+	case nil:
+	case bool:
+		o.TLS = v
+	default:
+		return o, fmt.Errorf("plugin: tls must be a boolean")
+	}
+
+	o.Timeout = defaultTimeout
+	switch v := options["timeout"].(type) {
+	case nil:
+	case string:
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return o, fmt.Errorf("plugin: invalid timeout %q: %v", v, err)
+		}
+		o.Timeout = d
+	case time.Duration:
+		o.Timeout = v
+	default:
+		return o, fmt.Errorf("plugin: invalid timeout %#v", v)
+	}
+	if o.Timeout <= 0 {
+		return o, fmt.Errorf("plugin: timeout must be positive")
+	}
+	return o, nil
+}
+
+var (
+	clientsMu sync.Mutex
+	clients   = map[Options]*Client{}
+)
+
+// NewClient returns the client of the plugin, shared by the middleware
+// configured with the same options. The connection is established lazily,
+// and re-established whenever the plugin restarts.
+func NewClient(o Options) (*Client, error) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	if c, ok := clients[o]; ok {
+		return c, nil
+	}
+
+	creds := insecure.NewCredentials()
+	if o.TLS {
+		creds = credentials.NewClientTLSFromCert(nil, "")
+	}
+	conn, err := grpc.Dial(o.Address,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.CallContentSubtype(codecName)),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("plugin: %v", err)
+	}
+	c := &Client{conn: conn, timeout: o.Timeout}
+	clients[o] = c
+	return c, nil
+}
+
+// Check asks the plugin whether the operation is allowed. A plugin not
+// implementing Check allows every operation.
+func (c *Client) Check(ctx context.Context, req *CheckRequest) (*CheckResponse, error) {
+	resp := &CheckResponse{}
+	if err := c.invoke(ctx, "Check", req, resp); err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return &CheckResponse{Allow: true}, nil
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+// URLFor returns the URL the plugin redirects the reads of the path to,
+// empty if it does not.
+func (c *Client) URLFor(ctx context.Context, req *URLForRequest) (string, error) {
+	resp := &URLForResponse{}
+	if err := c.invoke(ctx, "URLFor", req, resp); err != nil {
+		if status.Code(err) == codes.Unimplemented {
+			return "", nil
+		}
+		return "", err
+	}
+	return resp.URL, nil
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp)
+}
@@ -0,0 +1,81 @@
+package plugin
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+)
+
+// testPlugin denies the deletes of manifests and implements no URLFor.
+type testPlugin struct {
+	UnimplementedServer
+}
+
+func (testPlugin) Check(ctx context.Context, req *CheckRequest) (*CheckResponse, error) {
+	if req.Operation == OperationManifestDelete {
+		return &CheckResponse{Reason: "manifests of " + req.Repository + " are immutable"}, nil
+	}
+	return &CheckResponse{Allow: true}, nil
+}
+
+func TestClient(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	RegisterServer(s, testPlugin{})
+	go s.Serve(l)
+	defer s.Stop()
+
+	o, err := ParseOptions(map[string]interface{}{"address": l.Addr().String(), "timeout": "1s"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := NewClient(o)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if shared, _ := NewClient(o); shared != c {
+		t.Fatal("expected the client to be shared")
+	}
+
+	ctx := context.Background()
+	resp, err := c.Check(ctx, &CheckRequest{Operation: OperationManifestDelete, Repository: "prod/app"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Allow || resp.Reason != "manifests of prod/app are immutable" {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+	if resp, err := c.Check(ctx, &CheckRequest{Operation: OperationBlobGet}); err != nil || !resp.Allow {
+		t.Fatalf("unexpected response %+v: %v", resp, err)
+	}
+	if url, err := c.URLFor(ctx, &URLForRequest{Path: "/docker/registry/v2/blobs"}); err != nil || url != "" {
+		t.Fatalf("expected no URL from a plugin not implementing URLFor, got %q: %v", url, err)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	o, err := ParseOptions(map[string]interface{}{"address": "unix:///run/plugin.sock"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if o.Timeout != defaultTimeout || o.TLS {
+		t.Fatalf("unexpected defaults %+v", o)
+	}
+
+	for _, options := range []map[string]interface{}{
+		{},
+		{"address": "localhost:5050", "tls": "yes"},
+		{"address": "localhost:5050", "timeout": "soon"},
+		{"address": "localhost:5050", "timeout": -time.Second},
+	} {
+		if _, err := ParseOptions(options); err == nil {
+			t.Errorf("expected an error for options %v", options)
+		}
+	}
+}
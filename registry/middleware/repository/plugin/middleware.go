@@ -0,0 +1,425 @@
+// Package middleware - checks of the operations on the content of
+// repositories by an out-of-process plugin
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/middleware/plugin"
+	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	"github.com/opencontainers/go-digest"
+)
+
+// checker asks the plugin whether the operations on a repository are
+// allowed.
+type checker struct {
+	client     *plugin.Client
+	repository string
+	operations map[string]bool
+	failOpen   bool
+}
+
+// newChecker parses the options of the middleware.
+//
+// Options:
+//
+//   - address: the gRPC target of the plugin, required
+//   - tls: whether the plugin is connected to with TLS
+//   - timeout: the maximum duration of the calls to the plugin, 5s by default
+//   - repositories: the patterns of the names of the repositories checked,
+//     all of them if unset
+//   - operations: the operations checked, all of them if unset
+//   - failopen: whether the operations are allowed when the plugin is
+//     unavailable, instead of failing
+//
+// The returned checker is nil if the repository is not checked.
+func newChecker(repository string, options map[string]interface{}) (*checker, error) {
+	o, err := plugin.ParseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	repositories, err := stringList(options, "repositories")
+	if err != nil {
+		return nil, err
+	}
+	applies := len(repositories) == 0
+	for _, pattern := range repositories {
+		matched, err := path.Match(pattern, repository)
+		if err != nil {
+			return nil, fmt.Errorf("plugin: invalid pattern %q in repositories", pattern)
+		}
+		applies = applies || matched
+	}
+
+	operations, err := stringList(options, "operations")
+	if err != nil {
+		return nil, err
+	}
+	if len(operations) == 0 {
+		operations = plugin.Operations
+	}
+	c := &checker{repository: repository, operations: map[string]bool{}}
+	for _, operation := range operations {
+		if !contains(plugin.Operations, operation) {
+			return nil, fmt.Errorf("plugin: unknown operation %q", operation)
+		}
+		c.operations[operation] = true
+	}
+
+	switch v := options["failopen"].(type) {
+	case nil:
+	case bool:
+		c.failOpen = v
+	default:
+		return nil, fmt.Errorf("plugin: failopen must be a boolean")
+	}
+
+	if !applies {
+		return nil, nil
+	}
+	if c.client, err = plugin.NewClient(o); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func stringList(options map[string]interface{}, option string) ([]string, error) {
+	switch v := options[option].(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("plugin: %s must be a list of strings, %#v invalid", option, value)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("plugin: %s must be a list of strings", option)
+	}
+}
+
+func contains(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// check returns an errcode.Error with the ErrorCodeDenied code if the plugin
+// denies the operation, and with the ErrorCodeUnavailable code if the plugin
+// cannot be called, unless the checker fails open.
+func (c *checker) check(ctx context.Context, req *plugin.CheckRequest) error {
+	if !c.operations[req.Operation] {
+		return nil
+	}
+	req.Repository = c.repository
+	resp, err := c.client.Check(ctx, req)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("plugin: error checking %s on %s: %v", req.Operation, c.repository, err)
+		if c.failOpen {
+			return nil
+		}
+		return errcode.ErrorCodeUnavailable.WithMessage("the middleware plugin is unavailable")
+	}
+	if !resp.Allow {
+		reason := resp.Reason
+		if reason == "" {
+			reason = fmt.Sprintf("%s denied by the middleware plugin", req.Operation)
+		}
+		return errcode.ErrorCodeDenied.WithMessage(reason)
+	}
+	return nil
+}
+
+// digestAlgorithm returns the algorithm the service computes the digests of
+// the content put with, so that the plugin checks the digest it is stored
+// under.
+func digestAlgorithm(service interface{}) digest.Algorithm {
+	if provider, ok := service.(distribution.DigestAlgorithmProvider); ok {
+		return provider.DigestAlgorithm()
+	}
+	return digest.Canonical
+}
+
+// pluginRepository checks the operations on the repository with the plugin.
+type pluginRepository struct {
+	distribution.Repository
+	checker *checker
+}
+
+// pluginReferrerRepository is a pluginRepository serving the referrers of
+// the repository it wraps.
+type pluginReferrerRepository struct {
+	*pluginRepository
+	distribution.ReferrerService
+}
+
+func newPluginRepository(ctx context.Context, repository distribution.Repository, options map[string]interface{}) (distribution.Repository, error) {
+	checker, err := newChecker(repository.Named().Name(), options)
+	if err != nil {
+		return nil, err
+	}
+	if checker == nil {
+		return repository, nil
+	}
+
+	pr := &pluginRepository{
+		Repository: repository,
+		checker:    checker,
+	}
+	if referrers, ok := repository.(distribution.ReferrerService); ok {
+		return &pluginReferrerRepository{pluginRepository: pr, ReferrerService: referrers}, nil
+	}
+	return pr, nil
+}
+
+func init() {
+	repositorymiddleware.Register("plugin", newPluginRepository)
+}
+
+func (pr *pluginRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	manifests, err := pr.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginManifestService{ManifestService: manifests, checker: pr.checker}, nil
+}
+
+func (pr *pluginRepository) Blobs(ctx context.Context) distribution.BlobStore {
+	return &pluginBlobStore{BlobStore: pr.Repository.Blobs(ctx), checker: pr.checker}
+}
+
+func (pr *pluginRepository) Tags(ctx context.Context) distribution.TagService {
+	tags := pr.Repository.Tags(ctx)
+	pts := &pluginTagService{TagService: tags, checker: pr.checker}
+	if history, ok := tags.(distribution.TagHistoryProvider); ok {
+		return &pluginTagHistoryService{pluginTagService: pts, TagHistoryProvider: history}
+	}
+	return pts
+}
+
+// pluginManifestService checks the gets, puts and deletes of manifests.
+type pluginManifestService struct {
+	distribution.ManifestService
+	checker *checker
+}
+
+func (pms *pluginManifestService) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	manifest, err := pms.ManifestService.Get(ctx, dgst, options...)
+	if err != nil {
+		return nil, err
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+	err = pms.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationManifestGet,
+		Digest:    dgst.String(),
+		MediaType: mediaType,
+		Size:      int64(len(payload)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func (pms *pluginManifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+	req := &plugin.CheckRequest{
+		Operation: plugin.OperationManifestPut,
+		Digest:    digestAlgorithm(pms.ManifestService).FromBytes(payload).String(),
+		MediaType: mediaType,
+		Size:      int64(len(payload)),
+		Payload:   payload,
+	}
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			req.Tag = opt.Tag
+		}
+	}
+	if err := pms.checker.check(ctx, req); err != nil {
+		return "", err
+	}
+	return pms.ManifestService.Put(ctx, manifest, options...)
+}
+
+func (pms *pluginManifestService) Delete(ctx context.Context, dgst digest.Digest) error {
+	err := pms.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationManifestDelete,
+		Digest:    dgst.String(),
+	})
+	if err != nil {
+		return err
+	}
+	return pms.ManifestService.Delete(ctx, dgst)
+}
+
+// pluginBlobStore checks the reads, writes and deletes of blobs.
+type pluginBlobStore struct {
+	distribution.BlobStore
+	checker *checker
+}
+
+func (pbs *pluginBlobStore) checkGet(ctx context.Context, dgst digest.Digest) error {
+	return pbs.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationBlobGet,
+		Digest:    dgst.String(),
+	})
+}
+
+func (pbs *pluginBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if err := pbs.checkGet(ctx, dgst); err != nil {
+		return nil, err
+	}
+	return pbs.BlobStore.Get(ctx, dgst)
+}
+
+func (pbs *pluginBlobStore) Open(ctx context.Context, dgst digest.Digest) (distribution.ReadSeekCloser, error) {
+	if err := pbs.checkGet(ctx, dgst); err != nil {
+		return nil, err
+	}
+	return pbs.BlobStore.Open(ctx, dgst)
+}
+
+func (pbs *pluginBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	if err := pbs.checkGet(ctx, dgst); err != nil {
+		return err
+	}
+	return pbs.BlobStore.ServeBlob(ctx, w, r, dgst)
+}
+
+func (pbs *pluginBlobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
+	err := pbs.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationBlobPut,
+		Digest:    digestAlgorithm(pbs.BlobStore).FromBytes(p).String(),
+		MediaType: mediaType,
+		Size:      int64(len(p)),
+	})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return pbs.BlobStore.Put(ctx, mediaType, p)
+}
+
+func (pbs *pluginBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	bw, err := pbs.BlobStore.Create(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return newPluginBlobWriter(bw, pbs.checker), nil
+}
+
+func (pbs *pluginBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
+	bw, err := pbs.BlobStore.Resume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return newPluginBlobWriter(bw, pbs.checker), nil
+}
+
+func (pbs *pluginBlobStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	err := pbs.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationBlobDelete,
+		Digest:    dgst.String(),
+	})
+	if err != nil {
+		return err
+	}
+	return pbs.BlobStore.Delete(ctx, dgst)
+}
+
+// pluginBlobWriter checks the blob uploaded when it is committed. The size
+// of the blob is counted as it is written, as the writer of the storage
+// only reports the content flushed.
+type pluginBlobWriter struct {
+	distribution.BlobWriter
+	checker *checker
+	size    int64
+}
+
+func newPluginBlobWriter(bw distribution.BlobWriter, checker *checker) *pluginBlobWriter {
+	return &pluginBlobWriter{BlobWriter: bw, checker: checker, size: bw.Size()}
+}
+
+func (pbw *pluginBlobWriter) Write(p []byte) (int, error) {
+	n, err := pbw.BlobWriter.Write(p)
+	pbw.size += int64(n)
+	return n, err
+}
+
+func (pbw *pluginBlobWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := pbw.BlobWriter.ReadFrom(r)
+	pbw.size += n
+	return n, err
+}
+
+func (pbw *pluginBlobWriter) Commit(ctx context.Context, provisional distribution.Descriptor) (distribution.Descriptor, error) {
+	err := pbw.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationBlobPut,
+		Digest:    provisional.Digest.String(),
+		MediaType: provisional.MediaType,
+		Size:      pbw.size,
+	})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return pbw.BlobWriter.Commit(ctx, provisional)
+}
+
+// pluginTagService checks the tagging and untagging of manifests.
+type pluginTagService struct {
+	distribution.TagService
+	checker *checker
+}
+
+// pluginTagHistoryService is a pluginTagService providing the history of
+// the tags of the service it wraps.
+type pluginTagHistoryService struct {
+	*pluginTagService
+	distribution.TagHistoryProvider
+}
+
+func (pts *pluginTagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	err := pts.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationTag,
+		Digest:    desc.Digest.String(),
+		MediaType: desc.MediaType,
+		Size:      desc.Size,
+		Tag:       tag,
+	})
+	if err != nil {
+		return err
+	}
+	return pts.TagService.Tag(ctx, tag, desc)
+}
+
+func (pts *pluginTagService) Untag(ctx context.Context, tag string) error {
+	err := pts.checker.check(ctx, &plugin.CheckRequest{
+		Operation: plugin.OperationUntag,
+		Tag:       tag,
+	})
+	if err != nil {
+		return err
+	}
+	return pts.TagService.Untag(ctx, tag)
+}
@@ -0,0 +1,219 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/middleware/plugin"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+	"google.golang.org/grpc"
+)
+
+// testPlugin denies the blobs larger than a byte and the latest tag.
+type testPlugin struct {
+	plugin.UnimplementedServer
+	requests []plugin.CheckRequest
+}
+
+func (p *testPlugin) Check(ctx context.Context, req *plugin.CheckRequest) (*plugin.CheckResponse, error) {
+	p.requests = append(p.requests, *req)
+	switch {
+	case req.Operation == plugin.OperationBlobPut && req.Size > 1:
+		return &plugin.CheckResponse{Reason: "blob too large"}, nil
+	case req.Tag == "latest":
+		return &plugin.CheckResponse{}, nil
+	}
+	return &plugin.CheckResponse{Allow: true}, nil
+}
+
+func startPlugin(t *testing.T) (*testPlugin, string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testPlugin{}
+	s := grpc.NewServer()
+	plugin.RegisterServer(s, p)
+	go s.Serve(l)
+	t.Cleanup(s.Stop)
+	return p, l.Addr().String()
+}
+
+func newRepository(t *testing.T, options map[string]interface{}, registryOptions ...storage.RegistryOption) distribution.Repository {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New(), append([]storage.RegistryOption{storage.EnableDelete}, registryOptions...)...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName("prod/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err = newPluginRepository(ctx, repo, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating the middleware: %v", err)
+	}
+	return repo
+}
+
+func errorCode(err error) errcode.ErrorCode {
+	if e, ok := err.(errcode.Error); ok {
+		return e.Code
+	}
+	return 0
+}
+
+func TestPlugin(t *testing.T) {
+	ctx := context.Background()
+	p, address := startPlugin(t)
+	repo := newRepository(t, map[string]interface{}{
+		"address":    address,
+		"operations": []interface{}{plugin.OperationBlobPut, plugin.OperationTag},
+	})
+
+	blobs := repo.Blobs(ctx)
+	desc, err := blobs.Put(ctx, "application/octet-stream", []byte{0})
+	if err != nil {
+		t.Fatalf("unexpected error putting an allowed blob: %v", err)
+	}
+	_, err = blobs.Put(ctx, "application/octet-stream", []byte{0, 1})
+	if errorCode(err) != errcode.ErrorCodeDenied {
+		t.Fatalf("expected the blob to be denied, got %v", err)
+	}
+	if err.(errcode.Error).Message != "blob too large" {
+		t.Fatalf("unexpected message %q", err.(errcode.Error).Message)
+	}
+
+	bw, err := blobs.Create(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bw.Write([]byte{0, 1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := bw.Commit(ctx, distribution.Descriptor{Digest: desc.Digest}); errorCode(err) != errcode.ErrorCodeDenied {
+		t.Fatalf("expected the upload to be denied, got %v", err)
+	}
+
+	tags := repo.Tags(ctx)
+	if err := tags.Tag(ctx, "1.0", desc); err != nil {
+		t.Fatalf("unexpected error tagging: %v", err)
+	}
+	if err := tags.Tag(ctx, "latest", desc); errorCode(err) != errcode.ErrorCodeDenied {
+		t.Fatalf("expected the tag to be denied, got %v", err)
+	}
+	if _, ok := tags.(distribution.TagHistoryProvider); !ok {
+		t.Fatal("the middleware hides the history of the tags")
+	}
+
+	// the operations not configured are not checked
+	if _, err := blobs.Get(ctx, desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+	for _, req := range p.requests {
+		if req.Operation != plugin.OperationBlobPut && req.Operation != plugin.OperationTag {
+			t.Errorf("unexpected check of %s", req.Operation)
+		}
+		if req.Repository != "prod/app" {
+			t.Errorf("unexpected repository %s", req.Repository)
+		}
+	}
+	if len(p.requests) != 5 {
+		t.Fatalf("expected 5 checks, got %d", len(p.requests))
+	}
+}
+
+func TestPluginDigestAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	p, address := startPlugin(t)
+	repo := newRepository(t, map[string]interface{}{
+		"address":    address,
+		"operations": []interface{}{plugin.OperationBlobPut, plugin.OperationManifestPut},
+	}, storage.DigestAlgorithm(digest.SHA512))
+
+	config, err := repo.Blobs(ctx).Put(ctx, v1.MediaTypeImageConfig, []byte("{"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config:    config,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the plugin checks the digests the content is stored under
+	if len(p.requests) != 2 {
+		t.Fatalf("expected 2 checks, got %d", len(p.requests))
+	}
+	for i, expected := range []digest.Digest{config.Digest, dgst} {
+		if expected.Algorithm() != digest.SHA512 {
+			t.Fatalf("unexpected algorithm of %s", expected)
+		}
+		if p.requests[i].Digest != expected.String() {
+			t.Errorf("expected the %s check of %s, got %s", p.requests[i].Operation, expected, p.requests[i].Digest)
+		}
+	}
+}
+
+func TestPluginUnavailable(t *testing.T) {
+	ctx := context.Background()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	address := l.Addr().String()
+	l.Close()
+
+	repo := newRepository(t, map[string]interface{}{"address": address, "timeout": "100ms"})
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte{0}); errorCode(err) != errcode.ErrorCodeUnavailable {
+		t.Fatalf("expected the plugin to be unavailable, got %v", err)
+	}
+
+	repo = newRepository(t, map[string]interface{}{"address": address, "timeout": "100ms", "failopen": true})
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte{0}); err != nil {
+		t.Fatalf("expected the middleware to fail open, got %v", err)
+	}
+}
+
+func TestPluginOptions(t *testing.T) {
+	repo := newRepository(t, map[string]interface{}{
+		"address":      "localhost:5050",
+		"repositories": []interface{}{"staging/*"},
+	})
+	if _, ok := repo.(*pluginReferrerRepository); ok {
+		t.Fatal("the middleware wraps a repository it does not apply to")
+	}
+
+	for _, options := range []map[string]interface{}{
+		{},
+		{"address": "localhost:5050", "operations": []interface{}{"manifest.list"}},
+		{"address": "localhost:5050", "repositories": []interface{}{"prod/["}},
+		{"address": "localhost:5050", "failopen": "yes"},
+	} {
+		if _, err := newChecker("prod/app", options); err == nil {
+			t.Errorf("expected an error for options %v", options)
+		}
+	}
+}
@@ -0,0 +1,375 @@
+// Package middleware - validation of the manifests pushed to repositories
+// against a configurable policy
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"reflect"
+	"strconv"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	repositorymiddleware "github.com/distribution/distribution/v3/registry/middleware/repository"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// annotationBaseDigest is the annotation of the digest of the manifest of
+// the base image of an image.
+const annotationBaseDigest = "org.opencontainers.image.base.digest"
+
+// Violation is the detail of the error returned when a manifest violates a
+// rule of the policy.
+type Violation struct {
+	// Rule is the option of the policy which the manifest violates
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// policy is the set of rules enforced on the manifests pushed to the
+// matching repositories.
+type policy struct {
+	repositories        []string
+	requiredAnnotations []string
+	requiredLabels      []string
+	maxLayers           int64
+	maxSize             int64
+	mediaTypes          []string
+	layerMediaTypes     []string
+	baseDigests         []string
+}
+
+// newPolicy parses the options of the middleware.
+//
+// Optional options:
+//
+//   - repositories: the patterns of the names of the repositories the policy
+//     applies to, all of them if unset
+//   - requiredannotations: the annotations OCI manifests and image indexes
+//     must have
+//   - requiredlabels: the labels the config of images must have
+//   - maxlayers: the maximum number of layers of images
+//   - maxsize: the maximum size of images, the sum of the sizes of their
+//     config and layers, in bytes
+//   - mediatypes: the patterns of the allowed media types of manifests
+//   - layermediatypes: the patterns of the allowed media types of the layers
+//     of images
+//   - basedigests: the allowed digests of the base images of images, given by
+//     their org.opencontainers.image.base.digest annotation
+func newPolicy(options map[string]interface{}) (*policy, error) {
+	p := &policy{}
+	var err error
+	for _, list := range []struct {
+		option string
+		values *[]string
+		match  bool
+	}{
+		{"repositories", &p.repositories, true},
+		{"requiredannotations", &p.requiredAnnotations, false},
+		{"requiredlabels", &p.requiredLabels, false},
+		{"mediatypes", &p.mediaTypes, true},
+		{"layermediatypes", &p.layerMediaTypes, true},
+		{"basedigests", &p.baseDigests, false},
+	} {
+		*list.values, err = stringList(options, list.option)
+		if err != nil {
+			return nil, err
+		}
+		if list.match {
+			for _, pattern := range *list.values {
+				if _, err := path.Match(pattern, ""); err != nil {
+					return nil, fmt.Errorf("invalid pattern %q in %s", pattern, list.option)
+				}
+			}
+		}
+	}
+	if p.maxLayers, err = positiveInt(options, "maxlayers"); err != nil {
+		return nil, err
+	}
+	if p.maxSize, err = positiveInt(options, "maxsize"); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func stringList(options map[string]interface{}, option string) ([]string, error) {
+	switch v := options[option].(type) {
+	case nil:
+		return nil, nil
+	case []string:
+		return v, nil
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, value := range v {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("%s must be a list of strings, %#v invalid", option, value)
+			}
+			values = append(values, s)
+		}
+		return values, nil
+	default:
+		return nil, fmt.Errorf("%s must be a list of strings", option)
+	}
+}
+
+func positiveInt(options map[string]interface{}, option string) (int64, error) {
+	var n int64
+	switch v := options[option].(type) {
+	case string:
+		i, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%s must be an integer, %v invalid", option, v)
+		}
+		n = i
+	case int, uint, int32, uint32, int64, uint64:
+		n = reflect.ValueOf(v).Convert(reflect.TypeOf(n)).Int()
+	case nil:
+		return 0, nil
+	default:
+		return 0, fmt.Errorf("invalid value for %s: %#v", option, v)
+	}
+	if n <= 0 {
+		return 0, fmt.Errorf("%s must be positive, %d invalid", option, n)
+	}
+	return n, nil
+}
+
+// applies reports whether the policy applies to the repository.
+func (p *policy) applies(name string) bool {
+	return len(p.repositories) == 0 || matchAny(p.repositories, name)
+}
+
+func matchAny(patterns []string, s string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, s); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validate returns the violations of the policy by the manifest. The config
+// of images is read from blobs when labels are required.
+func (p *policy) validate(ctx context.Context, blobs distribution.BlobProvider, m distribution.Manifest) ([]Violation, error) {
+	mediaType, _, err := m.Payload()
+	if err != nil {
+		return nil, err
+	}
+
+	var violations []Violation
+	if len(p.mediaTypes) > 0 && !matchAny(p.mediaTypes, mediaType) {
+		violations = append(violations, Violation{
+			Rule:    "mediatypes",
+			Message: fmt.Sprintf("manifest media type %s is not allowed", mediaType),
+		})
+	}
+
+	var (
+		isImage     bool
+		annotations map[string]string
+		config      distribution.Descriptor
+		layers      []distribution.Descriptor
+	)
+	switch m := m.(type) {
+	case *schema2.DeserializedManifest:
+		isImage = m.Config.MediaType == schema2.MediaTypeImageConfig
+		config, layers = m.Config, m.Layers
+	case *ocischema.DeserializedManifest:
+		isImage = m.ArtifactType == "" && m.Config.MediaType == v1.MediaTypeImageConfig
+		config, layers = m.Config, m.Layers
+		annotations = m.Annotations
+		if annotations == nil {
+			annotations = map[string]string{}
+		}
+	case *manifestlist.DeserializedManifestList:
+		if mediaType == v1.MediaTypeImageIndex {
+			annotations = m.Annotations
+			if annotations == nil {
+				annotations = map[string]string{}
+			}
+		}
+	}
+
+	// Only the manifests which can carry annotations are checked
+	if annotations != nil {
+		for _, key := range p.requiredAnnotations {
+			if _, ok := annotations[key]; !ok {
+				violations = append(violations, Violation{
+					Rule:    "requiredannotations",
+					Message: fmt.Sprintf("annotation %s is required", key),
+				})
+			}
+		}
+	}
+	if !isImage {
+		return violations, nil
+	}
+
+	if p.maxLayers > 0 && int64(len(layers)) > p.maxLayers {
+		violations = append(violations, Violation{
+			Rule:    "maxlayers",
+			Message: fmt.Sprintf("image has %d layers, more than the maximum of %d", len(layers), p.maxLayers),
+		})
+	}
+	if p.maxSize > 0 {
+		size := config.Size
+		for _, layer := range layers {
+			size += layer.Size
+		}
+		if size > p.maxSize {
+			violations = append(violations, Violation{
+				Rule:    "maxsize",
+				Message: fmt.Sprintf("image is %d bytes, more than the maximum of %d", size, p.maxSize),
+			})
+		}
+	}
+	if len(p.layerMediaTypes) > 0 {
+		for _, layer := range layers {
+			if !matchAny(p.layerMediaTypes, layer.MediaType) {
+				violations = append(violations, Violation{
+					Rule:    "layermediatypes",
+					Message: fmt.Sprintf("layer media type %s of %s is not allowed", layer.MediaType, layer.Digest),
+				})
+			}
+		}
+	}
+	if len(p.baseDigests) > 0 {
+		base, ok := annotations[annotationBaseDigest]
+		if !ok {
+			violations = append(violations, Violation{
+				Rule:    "basedigests",
+				Message: fmt.Sprintf("image does not declare its base image with the %s annotation", annotationBaseDigest),
+			})
+		} else if !contains(p.baseDigests, base) {
+			violations = append(violations, Violation{
+				Rule:    "basedigests",
+				Message: fmt.Sprintf("base image %s is not allowed", base),
+			})
+		}
+	}
+	if len(p.requiredLabels) > 0 {
+		labels, err := configLabels(ctx, blobs, config)
+		if err == distribution.ErrBlobUnknown {
+			// reported by the verification of the manifest
+			return violations, nil
+		} else if err != nil {
+			return nil, err
+		}
+		for _, key := range p.requiredLabels {
+			if _, ok := labels[key]; !ok {
+				violations = append(violations, Violation{
+					Rule:    "requiredlabels",
+					Message: fmt.Sprintf("label %s is required", key),
+				})
+			}
+		}
+	}
+	return violations, nil
+}
+
+// configLabels returns the labels of an image config.
+func configLabels(ctx context.Context, blobs distribution.BlobProvider, config distribution.Descriptor) (map[string]string, error) {
+	p, err := blobs.Get(ctx, config.Digest)
+	if err != nil {
+		return nil, err
+	}
+	var image struct {
+		Config struct {
+			Labels map[string]string `json:"Labels"`
+		} `json:"config"`
+	}
+	if err := json.Unmarshal(p, &image); err != nil {
+		return nil, fmt.Errorf("invalid image config %s: %v", config.Digest, err)
+	}
+	return image.Config.Labels, nil
+}
+
+func contains(values []string, s string) bool {
+	for _, value := range values {
+		if value == s {
+			return true
+		}
+	}
+	return false
+}
+
+// validationRepository validates the manifests put to the repository.
+type validationRepository struct {
+	distribution.Repository
+	policy *policy
+}
+
+// validationReferrerRepository is a validationRepository serving the
+// referrers of the repository it wraps.
+type validationReferrerRepository struct {
+	*validationRepository
+	distribution.ReferrerService
+}
+
+func newValidationRepository(ctx context.Context, repository distribution.Repository, options map[string]interface{}) (distribution.Repository, error) {
+	policy, err := newPolicy(options)
+	if err != nil {
+		return nil, err
+	}
+	if !policy.applies(repository.Named().Name()) {
+		return repository, nil
+	}
+
+	vr := &validationRepository{
+		Repository: repository,
+		policy:     policy,
+	}
+	if referrers, ok := repository.(distribution.ReferrerService); ok {
+		return &validationReferrerRepository{validationRepository: vr, ReferrerService: referrers}, nil
+	}
+	return vr, nil
+}
+
+func init() {
+	repositorymiddleware.Register("validation", newValidationRepository)
+}
+
+func (vr *validationRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	manifests, err := vr.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return &validationManifestService{
+		ManifestService: manifests,
+		blobs:           vr.Repository.Blobs(ctx),
+		policy:          vr.policy,
+	}, nil
+}
+
+// validationManifestService refuses the manifests violating the policy.
+type validationManifestService struct {
+	distribution.ManifestService
+	blobs  distribution.BlobProvider
+	policy *policy
+}
+
+// Put puts the manifest unless it violates the policy, in which case an
+// errcode.Errors is returned, with a v2.ErrorCodeManifestInvalid error per
+// violation, detailed by the Violation.
+func (vms *validationManifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	violations, err := vms.policy.validate(ctx, vms.blobs, manifest)
+	if err != nil {
+		return "", err
+	}
+	if len(violations) > 0 {
+		errs := make(errcode.Errors, 0, len(violations))
+		for _, violation := range violations {
+			errs = append(errs, v2.ErrorCodeManifestInvalid.WithMessage(violation.Message).WithDetail(violation))
+		}
+		return "", errs
+	}
+	return vms.ManifestService.Put(ctx, manifest, options...)
+}
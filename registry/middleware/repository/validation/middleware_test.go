@@ -0,0 +1,142 @@
+package middleware
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	v2 "github.com/distribution/distribution/v3/registry/api/v2"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func newRepository(t *testing.T, options map[string]interface{}) distribution.Repository {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName("prod/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err = newValidationRepository(ctx, repo, options)
+	if err != nil {
+		t.Fatalf("unexpected error creating the middleware: %v", err)
+	}
+	return repo
+}
+
+func buildImage(t *testing.T, repo distribution.Repository, config string, annotations map[string]string, layers int) distribution.Manifest {
+	ctx := context.Background()
+	blobs := repo.Blobs(ctx)
+	builder := ocischema.NewManifestBuilder(blobs, []byte(config), annotations)
+	for i := 0; i < layers; i++ {
+		desc, err := blobs.Put(ctx, v1.MediaTypeImageLayerGzip, []byte{byte(i)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		desc.MediaType = v1.MediaTypeImageLayerGzip
+		if err := builder.AppendReference(desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func violatedRules(t *testing.T, err error) []string {
+	if err == nil {
+		return nil
+	}
+	errs, ok := err.(errcode.Errors)
+	if !ok {
+		t.Fatalf("unexpected error type %T: %v", err, err)
+	}
+	var rules []string
+	for _, err := range errs {
+		e := err.(errcode.Error)
+		if e.Code != v2.ErrorCodeManifestInvalid {
+			t.Fatalf("unexpected error code %v", e.Code)
+		}
+		rules = append(rules, e.Detail.(Violation).Rule)
+	}
+	return rules
+}
+
+func TestValidation(t *testing.T) {
+	ctx := context.Background()
+	base := "sha256:4d5a3d7c8f4a9b1a2d0ec7fb3e1a4b1f8a3a5d1b8f6f1c6a3f5e9c8b7a6d5e4f"
+	repo := newRepository(t, map[string]interface{}{
+		"repositories":        []interface{}{"prod/*"},
+		"requiredannotations": []interface{}{"org.opencontainers.image.source"},
+		"requiredlabels":      []interface{}{"maintainer"},
+		"maxlayers":           2,
+		"maxsize":             "1024",
+		"layermediatypes":     []interface{}{v1.MediaTypeImageLayerGzip},
+		"basedigests":         []interface{}{base},
+	})
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid := buildImage(t, repo, `{"config":{"Labels":{"maintainer":"ops"}}}`, map[string]string{
+		"org.opencontainers.image.source": "https://example.com/app",
+		annotationBaseDigest:              base,
+	}, 2)
+	if _, err := manifests.Put(ctx, valid); err != nil {
+		t.Fatalf("unexpected error putting a valid manifest: %v", err)
+	}
+
+	invalid := buildImage(t, repo, `{"config":{}}`, map[string]string{
+		annotationBaseDigest: "sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	}, 3)
+	_, err = manifests.Put(ctx, invalid)
+	rules := violatedRules(t, err)
+	expected := []string{"requiredannotations", "maxlayers", "basedigests", "requiredlabels"}
+	if len(rules) != len(expected) {
+		t.Fatalf("expected violations of %v, got %v", expected, rules)
+	}
+	for i := range expected {
+		if rules[i] != expected[i] {
+			t.Fatalf("expected violations of %v, got %v", expected, rules)
+		}
+	}
+
+	// The policy does not apply to other repositories
+	other := newRepository(t, map[string]interface{}{
+		"repositories": []interface{}{"staging/*"},
+		"maxlayers":    1,
+	})
+	if _, ok := other.(*validationReferrerRepository); ok {
+		t.Fatal("the middleware wraps a repository the policy does not apply to")
+	}
+	if _, ok := repo.(distribution.ReferrerService); !ok {
+		t.Fatal("the middleware hides the referrers of the repository")
+	}
+}
+
+func TestValidationOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{"maxlayers": 0},
+		{"maxsize": "big"},
+		{"mediatypes": "application/vnd.oci.image.manifest.v1+json"},
+		{"repositories": []interface{}{"prod/["}},
+	} {
+		if _, err := newPolicy(options); err == nil {
+			t.Errorf("expected an error for options %v", options)
+		}
+	}
+}
@@ -0,0 +1,274 @@
+// Package ocilayout exports the images of repositories to OCI image layouts,
+// and imports them back, so that content can be moved between registries
+// which cannot reach each other.
+package ocilayout
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/schema1" //nolint:staticcheck // Ignore SA1019: schema1 manifests are detected to be rejected
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// indexFile is the name of the index of an image layout.
+const indexFile = "index.json"
+
+// Writer writes the files of an image layout, to a directory or a tar
+// archive.
+type Writer interface {
+	// exists reports whether the file was written before, so that the
+	// blobs of a layout exported again are not copied again
+	exists(name string) bool
+	writeFile(name string, size int64, r io.Reader) error
+	Close() error
+}
+
+// NewDirWriter returns a writer of an image layout in the directory dir,
+// which is created if needed. The blobs already in the directory are kept.
+func NewDirWriter(dir string) (Writer, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &dirWriter{dir: dir}, nil
+}
+
+// NewTarWriter returns a writer of an image layout to a tar archive written
+// to w.
+func NewTarWriter(w io.Writer) Writer {
+	return &tarWriter{tw: tar.NewWriter(w), written: make(map[string]struct{})}
+}
+
+type dirWriter struct {
+	dir string
+}
+
+func (w *dirWriter) exists(name string) bool {
+	_, err := os.Stat(filepath.Join(w.dir, filepath.FromSlash(name)))
+	return err == nil
+}
+
+func (w *dirWriter) writeFile(name string, size int64, r io.Reader) error {
+	filename := filepath.Join(w.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		return err
+	}
+	// Files are written under a temporary name, so that an interrupted
+	// export does not leave truncated blobs behind
+	f, err := os.CreateTemp(filepath.Dir(filename), ".tmp-")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, r)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), filename)
+	}
+	if err != nil {
+		os.Remove(f.Name())
+	}
+	return err
+}
+
+func (w *dirWriter) Close() error {
+	return nil
+}
+
+type tarWriter struct {
+	tw      *tar.Writer
+	written map[string]struct{}
+}
+
+func (w *tarWriter) exists(name string) bool {
+	_, ok := w.written[name]
+	return ok
+}
+
+func (w *tarWriter) writeFile(name string, size int64, r io.Reader) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Size:     size,
+		Mode:     0o644,
+		ModTime:  time.Now(),
+	})
+	if err != nil {
+		return err
+	}
+	n, err := io.Copy(w.tw, r)
+	if err != nil {
+		return err
+	}
+	if n != size {
+		return fmt.Errorf("%s: expected %d bytes, got %d", name, size, n)
+	}
+	w.written[name] = struct{}{}
+	return nil
+}
+
+func (w *tarWriter) Close() error {
+	return w.tw.Close()
+}
+
+// blobPath returns the path of a blob in an image layout.
+func blobPath(dgst digest.Digest) string {
+	return path.Join("blobs", dgst.Algorithm().String(), dgst.Encoded())
+}
+
+// Export writes the images of the tags of the repository to the image
+// layout written by w, which is returned by NewDirWriter or NewTarWriter.
+// All the tags of the repository are exported when tags is empty. The tags
+// are recorded in the index of the layout with the
+// org.opencontainers.image.ref.name annotation. Image indexes are exported
+// with all their children, and layers which are not stored in the
+// repository, such as non-distributable layers, are skipped.
+func Export(ctx context.Context, repository distribution.Repository, tags []string, w Writer) error {
+	if len(tags) == 0 {
+		var err error
+		tags, err = repository.Tags(ctx).All(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to list the tags of %s: %v", repository.Named().Name(), err)
+		}
+		sort.Strings(tags)
+	}
+
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	e := &exporter{
+		repository: repository,
+		manifests:  manifests,
+		w:          w,
+		exported:   make(map[digest.Digest]struct{}),
+	}
+
+	index := v1.Index{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: v1.MediaTypeImageIndex,
+		Manifests: []v1.Descriptor{},
+	}
+	for _, tag := range tags {
+		desc, err := repository.Tags(ctx).Get(ctx, tag)
+		if err != nil {
+			return fmt.Errorf("failed to resolve tag %s: %v", tag, err)
+		}
+		mediaType, size, err := e.exportManifest(ctx, desc.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to export %s:%s: %v", repository.Named().Name(), tag, err)
+		}
+		index.Manifests = append(index.Manifests, v1.Descriptor{
+			MediaType:   mediaType,
+			Digest:      desc.Digest,
+			Size:        size,
+			Annotations: map[string]string{v1.AnnotationRefName: tag},
+		})
+	}
+
+	if err := writeJSON(w, v1.ImageLayoutFile, v1.ImageLayout{Version: v1.ImageLayoutVersion}); err != nil {
+		return err
+	}
+	return writeJSON(w, indexFile, index)
+}
+
+type exporter struct {
+	repository distribution.Repository
+	manifests  distribution.ManifestService
+	w          Writer
+	exported   map[digest.Digest]struct{}
+}
+
+// exportManifest writes the manifest and the content it references, and
+// returns its media type and size.
+func (e *exporter) exportManifest(ctx context.Context, dgst digest.Digest) (string, int64, error) {
+	manifest, err := e.manifests.Get(ctx, dgst)
+	if err != nil {
+		return "", 0, err
+	}
+	if _, ok := manifest.(*schema1.SignedManifest); ok {
+		return "", 0, fmt.Errorf("schema1 manifest %s cannot be exported", dgst)
+	}
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return "", 0, err
+	}
+	if _, ok := e.exported[dgst]; ok {
+		return mediaType, int64(len(payload)), nil
+	}
+
+	_, isIndex := manifest.(*manifestlist.DeserializedManifestList)
+	for _, desc := range manifest.References() {
+		if isIndex {
+			if _, _, err := e.exportManifest(ctx, desc.Digest); err != nil {
+				return "", 0, err
+			}
+			continue
+		}
+		if err := e.exportBlob(ctx, desc); err != nil {
+			return "", 0, err
+		}
+	}
+
+	if err := e.w.writeFile(blobPath(dgst), int64(len(payload)), bytes.NewReader(payload)); err != nil {
+		return "", 0, err
+	}
+	e.exported[dgst] = struct{}{}
+	return mediaType, int64(len(payload)), nil
+}
+
+func (e *exporter) exportBlob(ctx context.Context, desc distribution.Descriptor) error {
+	if _, ok := e.exported[desc.Digest]; ok {
+		return nil
+	}
+	if e.w.exists(blobPath(desc.Digest)) {
+		e.exported[desc.Digest] = struct{}{}
+		return nil
+	}
+
+	blobs := e.repository.Blobs(ctx)
+	stat, err := blobs.Stat(ctx, desc.Digest)
+	if err == distribution.ErrBlobUnknown && len(desc.URLs) > 0 {
+		// Non-distributable layers are pulled from their URLs
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to export blob %s: %v", desc.Digest, err)
+	}
+	rc, err := blobs.Open(ctx, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to export blob %s: %v", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	verifier := desc.Digest.Verifier()
+	if err := e.w.writeFile(blobPath(desc.Digest), stat.Size, io.TeeReader(rc, verifier)); err != nil {
+		return fmt.Errorf("failed to export blob %s: %v", desc.Digest, err)
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("failed to export blob %s: content does not match digest", desc.Digest)
+	}
+	e.exported[desc.Digest] = struct{}{}
+	return nil
+}
+
+func writeJSON(w Writer, name string, v interface{}) error {
+	p, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return w.writeFile(name, int64(len(p)), bytes.NewReader(p))
+}
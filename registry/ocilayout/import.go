@@ -0,0 +1,232 @@
+package ocilayout
+
+import (
+	"archive/tar"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Import pushes the manifests of the index of the image layout in fsys, such
+// as returned by os.DirFS, to the repository, along with the content they
+// reference. The manifests whose descriptor in the index carries the
+// org.opencontainers.image.ref.name annotation are tagged with it. Blobs
+// which are already in the repository are not pushed again. It returns the
+// references imported, in the form repository:tag, or repository@digest for
+// untagged manifests.
+func Import(ctx context.Context, repository distribution.Repository, fsys fs.FS) ([]string, error) {
+	var layout v1.ImageLayout
+	if err := readJSON(fsys, v1.ImageLayoutFile, &layout); err != nil {
+		return nil, err
+	}
+	if layout.Version != v1.ImageLayoutVersion {
+		return nil, fmt.Errorf("unsupported image layout version %q", layout.Version)
+	}
+	var index v1.Index
+	if err := readJSON(fsys, indexFile, &index); err != nil {
+		return nil, err
+	}
+
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	i := &importer{
+		repository: repository,
+		manifests:  manifests,
+		fsys:       fsys,
+		imported:   make(map[digest.Digest]struct{}),
+	}
+
+	name := repository.Named().Name()
+	var imported []string
+	for _, desc := range index.Manifests {
+		if err := i.importManifest(ctx, desc.MediaType, desc.Digest); err != nil {
+			return imported, fmt.Errorf("failed to import %s: %v", desc.Digest, err)
+		}
+
+		tag := refNameTag(desc.Annotations[v1.AnnotationRefName])
+		if tag == "" {
+			imported = append(imported, name+"@"+desc.Digest.String())
+			continue
+		}
+		if err := repository.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size}); err != nil {
+			return imported, fmt.Errorf("failed to tag %s: %v", tag, err)
+		}
+		imported = append(imported, name+":"+tag)
+	}
+	return imported, nil
+}
+
+// refNameTag returns the tag of a reference name annotation, which is
+// either a tag or a full reference.
+func refNameTag(refName string) string {
+	if i := strings.LastIndex(refName, ":"); i > strings.LastIndex(refName, "/") {
+		return refName[i+1:]
+	}
+	if strings.Contains(refName, "/") {
+		return ""
+	}
+	return refName
+}
+
+type importer struct {
+	repository distribution.Repository
+	manifests  distribution.ManifestService
+	fsys       fs.FS
+	imported   map[digest.Digest]struct{}
+}
+
+// importManifest pushes the content referenced by the manifest, and then the
+// manifest itself.
+func (i *importer) importManifest(ctx context.Context, mediaType string, dgst digest.Digest) error {
+	if _, ok := i.imported[dgst]; ok {
+		return nil
+	}
+	if err := dgst.Validate(); err != nil {
+		return err
+	}
+	payload, err := fs.ReadFile(i.fsys, blobPath(dgst))
+	if err != nil {
+		return err
+	}
+	if dgst.Algorithm().FromBytes(payload) != dgst {
+		return fmt.Errorf("manifest %s does not match its digest", dgst)
+	}
+	// The media type of the manifest takes precedence over the one of the
+	// descriptor, which is optional in image indexes
+	var versioned manifest.Versioned
+	if err := json.Unmarshal(payload, &versioned); err == nil && versioned.MediaType != "" {
+		mediaType = versioned.MediaType
+	}
+	m, _, err := distribution.UnmarshalManifest(mediaType, payload)
+	if err != nil {
+		return err
+	}
+
+	_, isIndex := m.(*manifestlist.DeserializedManifestList)
+	for _, desc := range m.References() {
+		if isIndex {
+			err = i.importManifest(ctx, desc.MediaType, desc.Digest)
+		} else {
+			err = i.importBlob(ctx, desc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := i.manifests.Put(ctx, m); err != nil {
+		return err
+	}
+	i.imported[dgst] = struct{}{}
+	return nil
+}
+
+func (i *importer) importBlob(ctx context.Context, desc distribution.Descriptor) error {
+	if _, ok := i.imported[desc.Digest]; ok {
+		return nil
+	}
+	if err := desc.Digest.Validate(); err != nil {
+		return err
+	}
+
+	blobs := i.repository.Blobs(ctx)
+	_, err := blobs.Stat(ctx, desc.Digest)
+	if err == nil {
+		i.imported[desc.Digest] = struct{}{}
+		return nil
+	} else if err != distribution.ErrBlobUnknown {
+		return err
+	}
+
+	f, err := i.fsys.Open(blobPath(desc.Digest))
+	if os.IsNotExist(err) && len(desc.URLs) > 0 {
+		// Non-distributable layers are pulled from their URLs
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	bw, err := blobs.Create(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, f); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	// The blob is verified against its digest when committed
+	if _, err := bw.Commit(ctx, distribution.Descriptor{Digest: desc.Digest, Size: desc.Size, MediaType: desc.MediaType}); err != nil {
+		bw.Cancel(ctx)
+		return fmt.Errorf("failed to import blob %s: %v", desc.Digest, err)
+	}
+	i.imported[desc.Digest] = struct{}{}
+	return nil
+}
+
+func readJSON(fsys fs.FS, name string, v interface{}) error {
+	p, err := fs.ReadFile(fsys, name)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(p, v); err != nil {
+		return fmt.Errorf("invalid %s: %v", name, err)
+	}
+	return nil
+}
+
+// Untar extracts the image layout in the tar archive read from r to the
+// directory dir, for Import. Only the regular files and directories of the
+// archive are extracted.
+func Untar(r io.Reader, dir string) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "./"))
+		if !fs.ValidPath(name) {
+			return fmt.Errorf("invalid path %q in the archive", hdr.Name)
+		}
+		filename := filepath.Join(dir, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(filename, 0o755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(filename, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package ocilayout
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func newRepository(t *testing.T, name string) (distribution.Namespace, distribution.Repository) {
+	t.Helper()
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry, repo
+}
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	registry, src := newRepository(t, "library/app")
+	image := testutil.PushImage(t, src, "v1").Descriptor.Digest
+	children := []digest.Digest{testutil.PushImage(t, src).Descriptor.Digest, testutil.PushImage(t, src).Descriptor.Digest}
+	list, err := testutil.MakeManifestList(registry.BlobStatter(), children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := src.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := manifests.Put(ctx, list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Tags(ctx).Tag(ctx, "multi", distribution.Descriptor{Digest: index}); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(t.TempDir(), "layout")
+	w, err := NewDirWriter(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Export(ctx, src, nil, w); err != nil {
+		t.Fatal(err)
+	}
+	var archive bytes.Buffer
+	tw := NewTarWriter(&archive)
+	if err := Export(ctx, src, []string{"multi"}, tw); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	extracted := t.TempDir()
+	if err := Untar(&archive, extracted); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		dir  string
+		tags map[string]digest.Digest
+	}{
+		{dir: dir, tags: map[string]digest.Digest{"multi": index, "v1": image}},
+		{dir: extracted, tags: map[string]digest.Digest{"multi": index}},
+	} {
+		_, dst := newRepository(t, "mirror/app")
+		imported, err := Import(ctx, dst, os.DirFS(tc.dir))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var expected []string
+		for tag, dgst := range tc.tags {
+			expected = append(expected, "mirror/app:"+tag)
+			desc, err := dst.Tags(ctx).Get(ctx, tag)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if desc.Digest != dgst {
+				t.Errorf("expected %s to be imported as %s, got %s", tag, dgst, desc.Digest)
+			}
+		}
+		sort.Strings(expected)
+		sort.Strings(imported)
+		if len(imported) != len(expected) || imported[0] != expected[0] || imported[len(imported)-1] != expected[len(expected)-1] {
+			t.Errorf("expected %v to be imported, got %v", expected, imported)
+		}
+
+		dstManifests, err := dst.Manifests(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, child := range children {
+			m, err := dstManifests.Get(ctx, child)
+			if err != nil {
+				t.Fatalf("expected the children of the index to be imported: %v", err)
+			}
+			for _, desc := range m.References() {
+				if _, err := dst.Blobs(ctx).Stat(ctx, desc.Digest); err != nil {
+					t.Errorf("expected blob %s to be imported: %v", desc.Digest, err)
+				}
+			}
+		}
+	}
+}
+
+func TestUntarRejectsEscapingPaths(t *testing.T) {
+	var archive bytes.Buffer
+	tw := NewTarWriter(&archive)
+	if err := tw.writeFile("../escape", 1, bytes.NewReader([]byte("x"))); err != nil {
+		t.Fatal(err)
+	}
+	tw.Close()
+	if err := Untar(&archive, t.TempDir()); err == nil {
+		t.Error("expected an error extracting a path outside of the directory")
+	}
+}
@@ -0,0 +1,217 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/opencontainers/go-digest"
+)
+
+// archiveRetryInterval is how long the expiry of a blob which could not be
+// archived is postponed.
+const archiveRetryInterval = time.Hour
+
+// archiveCounter counts the blobs archived when they expire, and restored
+// when they are pulled again
+var archiveCounter = prometheus.ProxyNamespace.NewLabeledCounter("archive_blobs", "The number of blobs archived to cold storage and restored from it", "action")
+
+// coldArchive keeps the blobs expiring from the cache in a cold storage,
+// from which they are restored when they are pulled again. The content of a
+// blob is stored once, along with a record for each repository it was
+// cached for, so that a blob is only restored into those repositories. A
+// nil archive keeps nothing.
+type coldArchive struct {
+	driver       driver.StorageDriver
+	repositories []string // path.Match patterns, all repositories if empty
+	minSize      int64
+}
+
+// newColdArchive returns the archive configured, nil if expiring blobs are
+// deleted.
+func newColdArchive(config configuration.ProxyArchive) (*coldArchive, error) {
+	if len(config.Storage) == 0 {
+		if len(config.Repositories) > 0 || config.MinSize != 0 {
+			return nil, fmt.Errorf("archive: storage is required")
+		}
+		return nil, nil
+	}
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("archive: invalid repository %q: %v", pattern, err)
+		}
+	}
+	if config.MinSize < 0 {
+		return nil, fmt.Errorf("archive: minsize must not be negative")
+	}
+
+	d, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		return nil, fmt.Errorf("archive: %v", err)
+	}
+	return &coldArchive{
+		driver:       d,
+		repositories: config.Repositories,
+		minSize:      config.MinSize,
+	}, nil
+}
+
+func archivedContentPath(dgst digest.Digest) string {
+	return path.Join("/blobs", dgst.Algorithm().String(), dgst.Hex()[:2], dgst.Hex(), "data")
+}
+
+func archivedRecordPath(repository string, dgst digest.Digest) string {
+	return path.Join("/repositories", repository, dgst.Algorithm().String(), dgst.Hex())
+}
+
+// applies reports whether the blob of the repository is archived when it
+// expires.
+func (ca *coldArchive) applies(repository string, size int64) bool {
+	if ca == nil || size < ca.minSize {
+		return false
+	}
+	return len(ca.repositories) == 0 || matchesAnyPattern(ca.repositories, repository)
+}
+
+// store archives the expiring blob of the repository, if the archive applies
+// to it. The content is only copied when withContent is set, as the blob is
+// kept in the cache while other repositories link it, and once: the record
+// of the repository is enough for a blob already archived.
+func (ca *coldArchive) store(ctx context.Context, ref reference.Canonical, blobs distribution.BlobStore, withContent bool) error {
+	if ca == nil {
+		return nil
+	}
+	dgst := ref.Digest()
+	desc, err := blobs.Stat(ctx, dgst)
+	if err == distribution.ErrBlobUnknown {
+		// there is nothing left to archive
+		return nil
+	} else if err != nil {
+		return err
+	}
+	if !ca.applies(ref.Name(), desc.Size) {
+		return nil
+	}
+
+	if withContent {
+		contentPath := archivedContentPath(dgst)
+		fi, err := ca.driver.Stat(ctx, contentPath)
+		if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			return err
+		}
+		if err != nil || fi.Size() != desc.Size {
+			if err := ca.copyContent(ctx, contentPath, blobs, dgst); err != nil {
+				return err
+			}
+			dcontext.GetLogger(ctx).Infof("Archived blob %s of %s", dgst, ref.Name())
+			archiveCounter.WithValues("archived").Inc(1)
+		}
+	}
+
+	record, err := json.Marshal(distribution.Descriptor{MediaType: desc.MediaType, Digest: dgst, Size: desc.Size})
+	if err != nil {
+		return err
+	}
+	return ca.driver.PutContent(ctx, archivedRecordPath(ref.Name(), dgst), record)
+}
+
+func (ca *coldArchive) copyContent(ctx context.Context, contentPath string, blobs distribution.BlobStore, dgst digest.Digest) error {
+	r, err := blobs.Open(ctx, dgst)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	w, err := ca.driver.Writer(ctx, contentPath, false)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Cancel(ctx)
+		return err
+	}
+	if err := w.Commit(); err != nil {
+		w.Cancel(ctx)
+		return err
+	}
+	return w.Close()
+}
+
+// stat returns the descriptor of the blob archived for the repository.
+func (ca *coldArchive) stat(ctx context.Context, repository string, dgst digest.Digest) (distribution.Descriptor, bool) {
+	if ca == nil {
+		return distribution.Descriptor{}, false
+	}
+	record, err := ca.driver.GetContent(ctx, archivedRecordPath(repository, dgst))
+	if err != nil {
+		return distribution.Descriptor{}, false
+	}
+	var desc distribution.Descriptor
+	if err := json.Unmarshal(record, &desc); err != nil || desc.Digest != dgst {
+		return distribution.Descriptor{}, false
+	}
+	if fi, err := ca.driver.Stat(ctx, archivedContentPath(dgst)); err != nil || fi.Size() != desc.Size {
+		return distribution.Descriptor{}, false
+	}
+	return desc, true
+}
+
+// restoreArchived restores a blob archived for the repository into the
+// cache instead of fetching it from the upstream again. It reports whether
+// the blob was restored.
+func (pbs *proxyBlobStore) restoreArchived(ctx context.Context, dgst digest.Digest) bool {
+	desc, ok := pbs.archive.stat(ctx, pbs.repositoryName.Name(), dgst)
+	if !ok {
+		return false
+	}
+	if err := pbs.restore(ctx, desc); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error restoring archived blob %s: %s", dgst, err)
+		return false
+	}
+
+	blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
+	if err != nil {
+		return false
+	}
+	dcontext.GetLogger(ctx).Infof("Restored archived blob %s into %s", dgst, pbs.repositoryName)
+	archiveCounter.WithValues("restored").Inc(1)
+	pbs.scheduler.AddBlob(blobRef, pbs.policies.ttl(dgst))
+	pbs.stats.cached(blobEntry, pbs.namespace, blobRef.String(), desc.Size)
+	pbs.policies.cached(blobEntry, blobRef, desc.Size)
+	return true
+}
+
+// restore copies the archived content of the blob into the cache. The
+// commit verifies the content against the digest.
+func (pbs *proxyBlobStore) restore(ctx context.Context, desc distribution.Descriptor) error {
+	ctx = pbs.objectTags.with(ctx, pbs.namespace)
+	r, err := pbs.archive.driver.Reader(ctx, archivedContentPath(desc.Digest), 0)
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+
+	bw, err := pbs.localStore.Create(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, r); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	if _, err := bw.Commit(ctx, desc); err != nil {
+		bw.Cancel(ctx)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+func TestNewColdArchive(t *testing.T) {
+	archive, err := newColdArchive(configuration.ProxyArchive{})
+	if err != nil || archive != nil {
+		t.Fatalf("unexpected archive %v without storage: %v", archive, err)
+	}
+	archive, err = newColdArchive(configuration.ProxyArchive{
+		Storage:      configuration.Storage{"inmemory": configuration.Parameters{}},
+		Repositories: []string{"library/*"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !archive.applies("library/debian", 0) || archive.applies("mycompany/app", 0) {
+		t.Fatal("unexpected repositories archived")
+	}
+
+	for _, config := range []configuration.ProxyArchive{
+		{MinSize: 1024},
+		{Storage: configuration.Storage{"inmemory": configuration.Parameters{}}, Repositories: []string{"["}},
+		{Storage: configuration.Storage{"inmemory": configuration.Parameters{}}, MinSize: -1},
+		{Storage: configuration.Storage{"unknown": configuration.Parameters{}}},
+	} {
+		if _, err := newColdArchive(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestArchiveRestore(t *testing.T) {
+	ctx := context.Background()
+	name, err := reference.WithName("library/debian")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := reference.WithName("library/ubuntu")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expiring, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	expiringRepo, err := expiring.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer := testutil.PushImage(t, expiringRepo, "12.1").Layers[0].Digest
+	expected, err := expiringRepo.Blobs(ctx).Get(ctx, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	archive := &coldArchive{driver: inmemory.New()}
+	ref, err := reference.WithDigest(name, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.store(ctx, ref, expiringRepo.Blobs(ctx), true); err != nil {
+		t.Fatal(err)
+	}
+
+	// the cache the blob expired from
+	local, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	blobStore := func(name reference.Named) (*proxyBlobStore, distribution.BlobStore) {
+		repo, err := local.Repository(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &proxyBlobStore{
+			localStore:     repo.Blobs(ctx),
+			remoteStore:    repo.Blobs(ctx), // the upstream lost the blob too
+			scheduler:      s,
+			repositoryName: name,
+			authChallenger: &mockChallenger{},
+			archive:        archive,
+		}, repo.Blobs(ctx)
+	}
+
+	// the blob is only restored into the repositories it was cached for
+	pbs, _ := blobStore(other)
+	if _, err := pbs.Stat(ctx, layer); err == nil {
+		t.Fatal("unexpected blob archived for another repository")
+	}
+
+	pbs, blobs := blobStore(name)
+	desc, err := pbs.Stat(ctx, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if desc.Digest != layer || desc.MediaType == "" {
+		t.Fatalf("unexpected descriptor %+v", desc)
+	}
+	content, err := pbs.Get(ctx, layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(content, expected) {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if _, err := blobs.Stat(ctx, layer); err != nil {
+		t.Fatalf("expected the blob to be restored into the cache: %v", err)
+	}
+	if s.Len() != 1 {
+		t.Fatalf("expected the restored blob to be scheduled, got %d entries", s.Len())
+	}
+}
@@ -0,0 +1,262 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	registryauth "github.com/distribution/distribution/v3/registry/auth"
+	"github.com/opencontainers/go-digest"
+)
+
+// Reasons recorded for upstream fetches in the audit log
+const (
+	fetchReasonNotCached     = "not_cached"
+	fetchReasonTagRefresh    = "tag_refresh"
+	fetchReasonTagListing    = "tag_listing"
+	fetchReasonPrefetch      = "platform_prefetch"
+	fetchReasonReferrer      = "referrer"
+	fetchReasonCacheFill     = "cache_fill"
+	fetchReasonVerification  = "signature_verification"
+	fetchReasonConversion    = "layer_conversion"
+	fetchReasonMirror        = "mirror_sync"
+	fetchReasonLayerPrefetch = "layer_prefetch"
+)
+
+type fetchReasonKey struct{}
+
+// withFetchReason records why the upstream is contacted by the calls made
+// with the returned context.
+func withFetchReason(ctx context.Context, reason string) context.Context {
+	return context.WithValue(ctx, fetchReasonKey{}, reason)
+}
+
+func fetchReason(ctx context.Context) string {
+	if reason, ok := ctx.Value(fetchReasonKey{}).(string); ok {
+		return reason
+	}
+	return fetchReasonNotCached
+}
+
+// auditRecord describes a single request made to the upstream registry.
+type auditRecord struct {
+	Time       time.Time `json:"time"`
+	Namespace  string    `json:"namespace"`
+	Repository string    `json:"repository"`
+	Operation  string    `json:"operation"`
+	Digest     string    `json:"digest,omitempty"`
+	Tag        string    `json:"tag,omitempty"`
+	Bytes      int64     `json:"bytes"`
+	DurationMS float64   `json:"duration_ms"`
+	Client     string    `json:"client,omitempty"`
+	ClientAddr string    `json:"client_addr,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+	Reason     string    `json:"reason"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// auditLogger writes audit records as a stream of JSON objects, one per
+// line. A nil logger discards all records.
+type auditLogger struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// newAuditLogger opens the configured audit log, or returns nil when audit
+// logging is disabled.
+func newAuditLogger(config configuration.ProxyAuditLog) (*auditLogger, error) {
+	var w io.Writer
+	switch config.Path {
+	case "":
+		return nil, nil
+	case "stdout":
+		w = os.Stdout
+	case "stderr":
+		w = os.Stderr
+	default:
+		f, err := os.OpenFile(config.Path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o640)
+		if err != nil {
+			return nil, err
+		}
+		w = f
+	}
+	return &auditLogger{enc: json.NewEncoder(w)}, nil
+}
+
+// log completes the record with the client of the request in ctx and writes
+// it.
+func (al *auditLogger) log(ctx context.Context, record auditRecord) {
+	if al == nil {
+		return
+	}
+
+	record.Time = record.Time.UTC()
+	record.Reason = fetchReason(ctx)
+	record.Client = dcontext.GetStringValue(ctx, registryauth.UserNameKey)
+	record.RequestID = dcontext.GetRequestID(ctx)
+	if r, err := dcontext.GetRequest(ctx); err == nil {
+		record.ClientAddr = dcontext.RemoteAddr(r)
+	}
+
+	al.mu.Lock()
+	defer al.mu.Unlock()
+
+	if err := al.enc.Encode(record); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error writing proxy audit log: %v", err)
+	}
+}
+
+// auditor records the upstream requests made for a proxied repository.
+type auditor struct {
+	logger     *auditLogger
+	namespace  string
+	repository string
+}
+
+// record logs an upstream request which started at start.
+func (a auditor) record(ctx context.Context, start time.Time, operation string, dgst digest.Digest, tag string, bytes int64, err error) {
+	record := auditRecord{
+		Time:       start,
+		Namespace:  a.namespace,
+		Repository: a.repository,
+		Operation:  operation,
+		Tag:        tag,
+		Bytes:      bytes,
+		DurationMS: float64(time.Since(start)) / float64(time.Millisecond),
+	}
+	if dgst != "" {
+		record.Digest = dgst.String()
+	}
+	if err != nil {
+		record.Error = err.Error()
+	}
+	a.logger.log(ctx, record)
+}
+
+// auditingManifestService records the manifests fetched from the upstream.
+type auditingManifestService struct {
+	distribution.ManifestService
+	auditor auditor
+}
+
+func (ams auditingManifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	start := time.Now()
+	exists, err := ams.ManifestService.Exists(ctx, dgst)
+	ams.auditor.record(ctx, start, "manifest_exists", dgst, "", 0, err)
+	return exists, err
+}
+
+func (ams auditingManifestService) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	start := time.Now()
+	manifest, err := ams.ManifestService.Get(ctx, dgst, options...)
+
+	var tag string
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			tag = opt.Tag
+		}
+	}
+
+	var size int64
+	if err == nil {
+		if _, payload, payloadErr := manifest.Payload(); payloadErr == nil {
+			size = int64(len(payload))
+		}
+	}
+	ams.auditor.record(ctx, start, "manifest_get", dgst, tag, size, err)
+	return manifest, err
+}
+
+// auditingBlobService records the blobs fetched from the upstream. Opened
+// blobs are recorded when closed, with the number of bytes read.
+type auditingBlobService struct {
+	distribution.BlobService
+	auditor auditor
+}
+
+func (abs auditingBlobService) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	start := time.Now()
+	desc, err := abs.BlobService.Stat(ctx, dgst)
+	abs.auditor.record(ctx, start, "blob_stat", dgst, "", 0, err)
+	return desc, err
+}
+
+func (abs auditingBlobService) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	start := time.Now()
+	p, err := abs.BlobService.Get(ctx, dgst)
+	abs.auditor.record(ctx, start, "blob_get", dgst, "", int64(len(p)), err)
+	return p, err
+}
+
+func (abs auditingBlobService) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	start := time.Now()
+	rsc, err := abs.BlobService.Open(ctx, dgst)
+	if err != nil {
+		abs.auditor.record(ctx, start, "blob_get", dgst, "", 0, err)
+		return nil, err
+	}
+	return &auditingReader{ReadSeekCloser: rsc, ctx: ctx, start: start, dgst: dgst, auditor: abs.auditor}, nil
+}
+
+type auditingReader struct {
+	io.ReadSeekCloser
+	ctx     context.Context
+	start   time.Time
+	dgst    digest.Digest
+	auditor auditor
+	bytes   int64
+	err     error
+}
+
+func (ar *auditingReader) Read(p []byte) (int, error) {
+	n, err := ar.ReadSeekCloser.Read(p)
+	ar.bytes += int64(n)
+	if err != nil && err != io.EOF {
+		ar.err = err
+	}
+	return n, err
+}
+
+func (ar *auditingReader) Close() error {
+	ar.auditor.record(ar.ctx, ar.start, "blob_get", ar.dgst, "", ar.bytes, ar.err)
+	return ar.ReadSeekCloser.Close()
+}
+
+// auditingTagService records the tags resolved and listed at the upstream.
+type auditingTagService struct {
+	distribution.TagService
+	auditor auditor
+}
+
+func (ats auditingTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	start := time.Now()
+	desc, err := ats.TagService.Get(ctx, tag)
+	ats.auditor.record(ctx, start, "tag_get", desc.Digest, tag, 0, err)
+	return desc, err
+}
+
+func (ats auditingTagService) All(ctx context.Context) ([]string, error) {
+	start := time.Now()
+	tags, err := ats.TagService.All(ctx)
+	ats.auditor.record(ctx, start, "tag_list", "", "", 0, err)
+	return tags, err
+}
+
+// auditingReferrerService records the referrers listed at the upstream.
+type auditingReferrerService struct {
+	distribution.ReferrerService
+	auditor auditor
+}
+
+func (ars auditingReferrerService) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	start := time.Now()
+	referrers, err := ars.ReferrerService.Referrers(ctx, subject, artifactType)
+	ars.auditor.record(ctx, start, "referrers_list", subject, "", 0, err)
+	return referrers, err
+}
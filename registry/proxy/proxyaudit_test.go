@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestAuditLog(t *testing.T) {
+	var buf bytes.Buffer
+	a := auditor{
+		logger:     &auditLogger{enc: json.NewEncoder(&buf)},
+		namespace:  "registry-1.docker.io",
+		repository: "library/redis",
+	}
+	ctx := context.Background()
+
+	content := []byte("audited blob content")
+	dgst := digest.FromBytes(content)
+	tags := auditingTagService{
+		TagService: &mockTagStore{mapping: map[string]distribution.Descriptor{"latest": {Digest: dgst}}},
+		auditor:    a,
+	}
+	blobs := auditingBlobService{
+		// Skip the failing first open of the flaky service
+		BlobService: &flakyBlobService{content: content, opens: 1},
+		auditor:     a,
+	}
+
+	if _, err := tags.Get(withFetchReason(ctx, fetchReasonTagRefresh), "latest"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tags.Get(ctx, "missing"); err == nil {
+		t.Fatal("expected error getting missing tag")
+	}
+
+	r, err := blobs.Open(ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.Copy(io.Discard, r); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+
+	var records []auditRecord
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var record auditRecord
+		if err := dec.Decode(&record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+
+	if len(records) != 3 {
+		t.Fatalf("expected 3 audit records, got %d", len(records))
+	}
+	for _, record := range records {
+		if record.Namespace != "registry-1.docker.io" || record.Repository != "library/redis" {
+			t.Errorf("unexpected repository in record: %+v", record)
+		}
+	}
+
+	if records[0].Operation != "tag_get" || records[0].Tag != "latest" || records[0].Digest != dgst.String() || records[0].Reason != fetchReasonTagRefresh || records[0].Error != "" {
+		t.Errorf("unexpected tag record: %+v", records[0])
+	}
+	if records[1].Tag != "missing" || records[1].Error == "" || records[1].Reason != fetchReasonNotCached {
+		t.Errorf("unexpected failed tag record: %+v", records[1])
+	}
+	if records[2].Operation != "blob_get" || records[2].Digest != dgst.String() || records[2].Bytes != int64(len(content)) {
+		t.Errorf("unexpected blob record: %+v", records[2])
+	}
+}
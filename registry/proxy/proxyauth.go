@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"fmt"
 	"net/http"
 	"net/url"
 	"strings"
@@ -19,7 +20,8 @@ type userpass struct {
 }
 
 type credentials struct {
-	creds map[string]userpass
+	creds map[string]userpass // keyed by token server URL
+	hosts map[string]userpass // keyed by upstream host, for basic auth
 }
 
 func (c credentials) Basic(u *url.URL) (string, string) {
@@ -36,11 +38,26 @@ func (c credentials) SetRefreshToken(u *url.URL, service, token string) {
 }
 
 // configureAuth stores credentials for challenge responses
-func configureAuth(configCredentials map[string]configuration.ProxyCredential) (auth.CredentialStore, error) {
+func configureAuth(configCredentials map[string]configuration.ProxyCredential, transports *upstreamTransports, resolvers upstreamResolvers) (auth.CredentialStore, error) {
 	creds := map[string]userpass{}
+	hosts := map[string]userpass{}
 
 	for remoteURL, credential := range configCredentials {
-		authURLs, err := getAuthURLs(remoteURL)
+		hosts[upstreamHost(remoteURL)] = userpass{
+			username: credential.Username,
+			password: credential.Password,
+		}
+
+		pingURL := remoteURL + "/v2/"
+		if u, err := url.Parse(remoteURL); err == nil && u.Host != "" {
+			resolved, err := resolvers.pingURL(*u)
+			if err != nil {
+				return nil, err
+			}
+			pingURL = resolved.String()
+		}
+
+		authURLs, err := getAuthURLs(pingURL, transports.forURL(remoteURL))
 		if err != nil {
 			return nil, err
 		}
@@ -54,13 +71,14 @@ func configureAuth(configCredentials map[string]configuration.ProxyCredential) (
 		}
 	}
 
-	return credentials{creds: creds}, nil
+	return credentials{creds: creds, hosts: hosts}, nil
 }
 
-func getAuthURLs(remoteURL string) ([]string, error) {
+func getAuthURLs(pingURL string, tr http.RoundTripper) ([]string, error) {
 	authURLs := []string{}
 
-	resp, err := http.Get(remoteURL + "/v2/")
+	client := &http.Client{Transport: tr}
+	resp, err := client.Get(pingURL)
 	if err != nil {
 		return nil, err
 	}
@@ -75,8 +93,9 @@ func getAuthURLs(remoteURL string) ([]string, error) {
 	return authURLs, nil
 }
 
-func ping(manager challenge.Manager, endpoint, versionHeader string) error {
-	resp, err := http.Get(endpoint)
+func ping(manager challenge.Manager, endpoint, versionHeader string, tr http.RoundTripper) error {
+	client := &http.Client{Transport: tr}
+	resp, err := client.Get(endpoint)
 	if err != nil {
 		return err
 	}
@@ -84,3 +103,107 @@ func ping(manager challenge.Manager, endpoint, versionHeader string) error {
 
 	return manager.AddResponse(resp)
 }
+
+// Authorization modes of the upstreams.
+const (
+	authModeToken     = "token"
+	authModeBasic     = "basic"
+	authModeAnonymous = "anonymous"
+)
+
+// repositoryPlaceholder is replaced with the name of the repository in the
+// scope templates.
+const repositoryPlaceholder = "{repository}"
+
+// upstreamAuth is how the requests to an upstream are authorized.
+type upstreamAuth struct {
+	mode   string
+	scopes []string // templates of the scopes requested in token mode
+}
+
+// upstreamAuths holds the authorization of the upstreams configured with
+// one, keyed by host.
+type upstreamAuths map[string]upstreamAuth
+
+// forHost returns the authorization of the upstream host, token
+// authentication with the default scope unless configured.
+func (ua upstreamAuths) forHost(host string) upstreamAuth {
+	if auth, ok := ua[host]; ok {
+		return auth
+	}
+	return upstreamAuth{mode: authModeToken}
+}
+
+// parseAuths parses the authorization of the upstreams.
+func parseAuths(config map[string]configuration.ProxyAuth) (upstreamAuths, error) {
+	auths := make(upstreamAuths, len(config))
+	for key, authConfig := range config {
+		host := upstreamHost(key)
+		mode := strings.ToLower(authConfig.Mode)
+		switch mode {
+		case "":
+			mode = authModeToken
+		case authModeToken, authModeBasic, authModeAnonymous:
+		default:
+			return nil, fmt.Errorf("auth for %s: unknown mode %q, expected token, basic or anonymous", host, authConfig.Mode)
+		}
+		if len(authConfig.Scopes) > 0 && mode != authModeToken {
+			return nil, fmt.Errorf("auth for %s: scopes are only requested in token mode", host)
+		}
+		for _, scope := range authConfig.Scopes {
+			if strings.TrimSpace(scope) == "" || strings.ContainsAny(scope, " \t") {
+				return nil, fmt.Errorf("auth for %s: invalid scope %q", host, scope)
+			}
+		}
+		auths[host] = upstreamAuth{mode: mode, scopes: authConfig.Scopes}
+	}
+	return auths, nil
+}
+
+// tokenScopes returns the scopes requested from the token server for the
+// repository of the upstream.
+func (ua upstreamAuth) tokenScopes(repository string) []auth.Scope {
+	if len(ua.scopes) == 0 {
+		return []auth.Scope{
+			auth.RepositoryScope{
+				Repository: repository,
+				Actions:    []string{"pull"},
+			},
+		}
+	}
+	scopes := make([]auth.Scope, 0, len(ua.scopes))
+	for _, template := range ua.scopes {
+		scopes = append(scopes, customScope(strings.ReplaceAll(template, repositoryPlaceholder, repository)))
+	}
+	return scopes
+}
+
+// customScope is a scope requested as configured, such as
+// repository(plugin):name:pull.
+type customScope string
+
+func (s customScope) String() string {
+	return string(s)
+}
+
+// basicAuthModifier authorizes the requests to an upstream without a token
+// server by sending its credentials with basic auth, rather than in exchange
+// for a token.
+type basicAuthModifier struct {
+	host       string
+	challenger authChallenger
+}
+
+func (m basicAuthModifier) ModifyRequest(req *http.Request) error {
+	// the credentials are not sent to the hosts the upstream redirects to,
+	// such as blob storage
+	if req.URL.Host != m.host {
+		return nil
+	}
+	if c, ok := m.challenger.credentialStore().(credentials); ok {
+		if up, ok := c.hosts[m.host]; ok && up.username != "" {
+			req.SetBasicAuth(up.username, up.password)
+		}
+	}
+	return nil
+}
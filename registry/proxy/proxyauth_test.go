@@ -0,0 +1,95 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/client/transport"
+)
+
+func TestParseAuths(t *testing.T) {
+	auths, err := parseAuths(map[string]configuration.ProxyAuth{
+		"docker.io":           {},
+		"https://charts.io":   {Mode: "Basic"},
+		"public.example.com":  {Mode: "anonymous"},
+		"plugins.example.com": {Scopes: []string{"repository(plugin):{repository}:pull"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, mode := range map[string]string{
+		"registry-1.docker.io": authModeToken,
+		"charts.io":            authModeBasic,
+		"public.example.com":   authModeAnonymous,
+		"plugins.example.com":  authModeToken,
+		"other.example.com":    authModeToken,
+	} {
+		if auth := auths.forHost(host); auth.mode != mode {
+			t.Errorf("unexpected mode %q for %s, expected %q", auth.mode, host, mode)
+		}
+	}
+
+	scopes := auths.forHost("plugins.example.com").tokenScopes("vieux/sshfs")
+	if len(scopes) != 1 || scopes[0].String() != "repository(plugin):vieux/sshfs:pull" {
+		t.Fatalf("unexpected scopes %v", scopes)
+	}
+	scopes = auths.forHost("other.example.com").tokenScopes("library/redis")
+	if len(scopes) != 1 || scopes[0].String() != "repository:library/redis:pull" {
+		t.Fatalf("unexpected default scopes %v", scopes)
+	}
+
+	for _, config := range []configuration.ProxyAuth{
+		{Mode: "digest"},
+		{Mode: "basic", Scopes: []string{"repository:{repository}:pull"}},
+		{Scopes: []string{"repository:{repository}:pull push"}},
+	} {
+		if _, err := parseAuths(map[string]configuration.ProxyAuth{"example.com": config}); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestBasicAuthModifier(t *testing.T) {
+	var authorized bool
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		authorized = ok && username == "user" && password == "secret"
+	}))
+	defer upstream.Close()
+
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := &remoteAuthChallenger{cs: credentials{hosts: map[string]userpass{
+		u.Host: {username: "user", password: "secret"},
+	}}}
+	client := &http.Client{Transport: transport.NewTransport(http.DefaultTransport, basicAuthModifier{host: u.Host, challenger: c})}
+
+	resp, err := client.Get(upstream.URL + "/v2/library/redis/manifests/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !authorized {
+		t.Fatal("expected the credentials to be sent with basic auth")
+	}
+
+	// the credentials are not sent to other hosts, such as those the
+	// upstream redirects to
+	c.cs = credentials{hosts: map[string]userpass{
+		"other.example.com": {username: "user", password: "secret"},
+	}}
+	client.Transport = transport.NewTransport(http.DefaultTransport, basicAuthModifier{host: "other.example.com", challenger: c})
+	resp, err = client.Get(upstream.URL + "/v2/library/redis/manifests/latest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if authorized {
+		t.Fatal("unexpected credentials sent to another host")
+	}
+}
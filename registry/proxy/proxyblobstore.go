@@ -2,24 +2,38 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"io"
 	"net/http"
 	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/tracing"
 	"github.com/opencontainers/go-digest"
 )
 
 type proxyBlobStore struct {
 	localStore     distribution.BlobStore
 	remoteStore    distribution.BlobService
+	globalBlobs    distribution.BlobStatter // blobs cached for any repository, nil to disable mounting
 	scheduler      *scheduler.TTLExpirationScheduler
 	repositoryName reference.Named
 	authChallenger authChallenger
+	namespace      string // upstream host, for statistics
+	stats          *statsCollector
+	policies       *artifactPolicies
+	fetchOnRange   bool
+	fetches        *fetchTracker
+	notifier       *eventNotifier
+	archive        *coldArchive    // nil unless expiring blobs are archived
+	locals         *localReferrers // nil unless referrers are pushed to the cache
+	objectTags     *objectTags     // nil unless the objects cached are tagged
 }
 
 var _ distribution.BlobStore = &proxyBlobStore{}
@@ -73,36 +87,104 @@ func (pbs *proxyBlobStore) serveLocal(ctx context.Context, w http.ResponseWriter
 	}
 
 	proxyMetrics.BlobPush(uint64(localDesc.Size))
+	// the hit is recorded before the response is written, as it sets its
+	// headers
+	pbs.stats.hit(ctx, blobEntry, pbs.namespace, pbs.cacheKey(dgst))
 	return true, pbs.localStore.ServeBlob(ctx, w, r, dgst)
 }
 
-func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest) error {
+func (pbs *proxyBlobStore) storeLocal(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	ctx = pbs.objectTags.with(ctx, pbs.namespace)
 	defer func() {
 		mu.Lock()
 		delete(inflight, dgst)
 		mu.Unlock()
 	}()
 
-	var desc distribution.Descriptor
-	var err error
-	var bw distribution.BlobWriter
-
-	bw, err = pbs.localStore.Create(ctx)
+	desc, err := pbs.remoteStore.Stat(ctx, dgst)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
 
-	desc, err = pbs.copyContent(ctx, dgst, bw)
+	bw := pbs.resumeUpload(ctx, dgst)
+	if bw == nil {
+		bw, err = pbs.localStore.Create(ctx)
+		if err != nil {
+			return distribution.Descriptor{}, err
+		}
+	}
+	offset := bw.Size()
+
+	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
 	if err != nil {
-		return err
+		bw.Cancel(ctx)
+		return distribution.Descriptor{}, err
+	}
+	defer remoteReader.Close()
+
+	if offset > 0 {
+		if _, err := remoteReader.Seek(offset, io.SeekStart); err != nil {
+			bw.Cancel(ctx)
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	if _, err := io.CopyN(bw, remoteReader, desc.Size-offset); err != nil {
+		pbs.abandonUpload(ctx, dgst, bw)
+		return distribution.Descriptor{}, err
 	}
+	proxyMetrics.BlobPush(uint64(desc.Size - offset))
 
 	_, err = bw.Commit(ctx, desc)
 	if err != nil {
-		return err
+		return distribution.Descriptor{}, err
 	}
 
-	return nil
+	return desc, nil
+}
+
+// resumeUpload returns the partial upload of the blob left by a fetch which
+// was interrupted by a shutdown, or nil if there is none to resume.
+func (pbs *proxyBlobStore) resumeUpload(ctx context.Context, dgst digest.Digest) distribution.BlobWriter {
+	checkpoint, ok := pbs.fetches.take(pbs.repositoryName.String(), dgst)
+	if !ok {
+		return nil
+	}
+
+	bw, err := pbs.localStore.Resume(ctx, checkpoint.UploadID)
+	if err != nil {
+		dcontext.GetLogger(ctx).Warnf("Error resuming upload %s of %s: %s", checkpoint.UploadID, dgst, err)
+		return nil
+	}
+	if bw.Size() != checkpoint.Offset {
+		dcontext.GetLogger(ctx).Warnf("Upload %s of %s has %d bytes, expected %d", checkpoint.UploadID, dgst, bw.Size(), checkpoint.Offset)
+		bw.Cancel(ctx)
+		return nil
+	}
+
+	dcontext.GetLogger(ctx).Infof("Resuming fetch of %s at offset %d", dgst, checkpoint.Offset)
+	return bw
+}
+
+// abandonUpload discards the upload of a failed fetch, or keeps it for a
+// later fetch to resume when the fetch was interrupted by a shutdown.
+func (pbs *proxyBlobStore) abandonUpload(ctx context.Context, dgst digest.Digest, bw distribution.BlobWriter) {
+	if !pbs.fetches.interrupted() {
+		bw.Cancel(ctx)
+		return
+	}
+
+	if err := bw.Close(); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error closing upload %s of %s: %s", bw.ID(), dgst, err)
+		bw.Cancel(ctx)
+		return
+	}
+	pbs.fetches.checkpoint(fetchCheckpoint{
+		Repository: pbs.repositoryName.String(),
+		Digest:     dgst,
+		UploadID:   bw.ID(),
+		Offset:     bw.Size(),
+	})
 }
 
 func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
@@ -115,46 +197,235 @@ func (pbs *proxyBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter,
 	if served {
 		return nil
 	}
+	pbs.stats.miss(ctx, blobEntry, pbs.namespace)
+
+	ctx, span := tracing.Start(ctx, "proxy.FetchBlob",
+		tracing.String("registry.namespace", pbs.namespace),
+		tracing.String("registry.repository", pbs.repositoryName.Name()),
+		tracing.String("registry.digest", dgst.String()))
+	err = pbs.serveRemote(ctx, w, r, dgst)
+	span.End(err)
+	return err
+}
 
+// serveRemote serves a blob missing from the cache, caching it while it is
+// served.
+func (pbs *proxyBlobStore) serveRemote(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	if pbs.restoreArchived(ctx, dgst) {
+		return pbs.localStore.ServeBlob(ctx, w, r, dgst)
+	}
 	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
 		return err
 	}
 
-	mu.Lock()
-	_, ok := inflight[dgst]
-	if ok {
-		mu.Unlock()
+	if pbs.mountCached(ctx, dgst) {
+		return pbs.localStore.ServeBlob(ctx, w, r, dgst)
+	}
+
+	if r.Header.Get("Range") != "" {
+		served, err := pbs.serveRemoteRange(ctx, w, r, dgst)
+		if served || err != nil {
+			return err
+		}
+	}
+
+	cancel, ok := pbs.cacheInBackground(dgst)
+	if !ok {
 		_, err := pbs.copyContent(ctx, dgst, w)
 		return err
 	}
-	inflight[dgst] = struct{}{}
-	mu.Unlock()
+
+	_, err := pbs.copyContent(ctx, dgst, w)
+	if err != nil {
+		cancel()
+		return err
+	}
+	return nil
+}
+
+// cacheInBackground starts storing the blob locally, unless it is already
+// being fetched. The returned function cancels the fetch.
+func (pbs *proxyBlobStore) cacheInBackground(dgst digest.Digest) (context.CancelFunc, bool) {
+	fetchCtx, ok := pbs.claim(dgst)
+	if !ok {
+		return nil, false
+	}
 
 	// storeLocalCtx will be independent with ctx, because ctx is used to fetch remote image.
 	// There could be a situation, where pulling remote bytes ends before pbs.storeLocal( 'Copy', 'Commit' ...)
 	// Then the registry fails to cache the layer, even though the layer had been served to client.
-	storeLocalCtx, cancel := context.WithCancel(context.Background())
+	storeLocalCtx, cancel := context.WithCancel(withFetchReason(fetchCtx, fetchReasonCacheFill))
 	go func(dgst digest.Digest) {
+		defer pbs.fetches.done()
 		defer cancel()
-		if err := pbs.storeLocal(storeLocalCtx, dgst); err != nil {
+		if err := pbs.cache(storeLocalCtx, dgst); err != nil {
 			dcontext.GetLogger(storeLocalCtx).Errorf("Error committing to storage: %s", err.Error())
 		}
+	}(dgst)
 
-		blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
-		if err != nil {
-			dcontext.GetLogger(storeLocalCtx).Errorf("Error creating reference: %s", err)
-			return
-		}
+	return cancel, true
+}
 
-		pbs.scheduler.AddBlob(blobRef, repositoryTTL)
-	}(dgst)
+// fetch stores the blob locally, unless it is already cached or being
+// fetched, and waits for it to be stored.
+func (pbs *proxyBlobStore) fetch(ctx context.Context, dgst digest.Digest) error {
+	if _, err := pbs.localStore.Stat(ctx, dgst); err == nil {
+		return nil
+	}
+	if pbs.restoreArchived(ctx, dgst) || pbs.mountCached(ctx, dgst) {
+		return nil
+	}
+
+	fetchCtx, ok := pbs.claim(dgst)
+	if !ok {
+		return nil
+	}
+	defer pbs.fetches.done()
+
+	return pbs.cache(withFetchReason(fetchCtx, fetchReason(ctx)), dgst)
+}
+
+// claim marks the blob as being fetched and registers the fetch, unless it
+// is already being fetched or the registry is shutting down. The returned
+// context is the one the fetch is to run with.
+func (pbs *proxyBlobStore) claim(dgst digest.Digest) (context.Context, bool) {
+	mu.Lock()
+	defer mu.Unlock()
+
+	if _, ok := inflight[dgst]; ok {
+		return nil, false
+	}
+	fetchCtx, ok := pbs.fetches.start()
+	if !ok {
+		// The registry is shutting down
+		return nil, false
+	}
+	inflight[dgst] = struct{}{}
+	return fetchCtx, true
+}
+
+// cache stores a claimed blob locally and schedules its expiry.
+func (pbs *proxyBlobStore) cache(ctx context.Context, dgst digest.Digest) error {
+	desc, storeErr := pbs.storeLocal(ctx, dgst)
 
-	_, err = pbs.copyContent(ctx, dgst, w)
+	blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
 	if err != nil {
-		cancel()
-		return err
+		dcontext.GetLogger(ctx).Errorf("Error creating reference: %s", err)
+		return storeErr
 	}
-	return nil
+
+	pbs.scheduler.AddBlob(blobRef, pbs.policies.ttl(dgst))
+	if storeErr == nil {
+		pbs.stats.cached(blobEntry, pbs.namespace, blobRef.String(), desc.Size)
+		pbs.policies.cached(blobEntry, blobRef, desc.Size)
+	} else {
+		pbs.notifier.fetchFailed(ctx, pbs.repositoryName, dgst)
+	}
+	return storeErr
+}
+
+// serveRemoteRange forwards a request for a single byte range of a blob
+// which is not cached to the upstream. When fetchOnRange is set, the whole
+// blob is cached in the background. It reports false if the range is not
+// one it can serve, in which case the whole blob is to be served instead.
+func (pbs *proxyBlobStore) serveRemoteRange(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) (bool, error) {
+	desc, err := pbs.remoteStore.Stat(ctx, dgst)
+	if err != nil {
+		return false, err
+	}
+
+	start, length, err := parseByteRange(r.Header.Get("Range"), desc.Size)
+	switch err {
+	case nil:
+	case errRangeNotSatisfiable:
+		w.Header().Set("Content-Range", fmt.Sprintf("bytes */%d", desc.Size))
+		w.WriteHeader(http.StatusRequestedRangeNotSatisfiable)
+		return true, nil
+	default:
+		return false, nil
+	}
+
+	if pbs.fetchOnRange {
+		pbs.cacheInBackground(dgst)
+	}
+
+	remoteReader, err := pbs.remoteStore.Open(ctx, dgst)
+	if err != nil {
+		return false, err
+	}
+	defer remoteReader.Close()
+
+	if _, err := remoteReader.Seek(start, io.SeekStart); err != nil {
+		return false, err
+	}
+
+	setResponseHeaders(w, length, desc.MediaType, dgst)
+	w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", start, start+length-1, desc.Size))
+	w.WriteHeader(http.StatusPartialContent)
+
+	if _, err := io.CopyN(w, remoteReader, length); err != nil {
+		return true, err
+	}
+
+	proxyMetrics.BlobPush(uint64(length))
+	return true, nil
+}
+
+var (
+	errRangeNotSatisfiable = errors.New("range not satisfiable")
+	errRangeUnsupported    = errors.New("unsupported range")
+)
+
+// parseByteRange parses a Range header holding a single byte range of a blob
+// of the given size, and returns the offset and length of the range.
+func parseByteRange(header string, size int64) (int64, int64, error) {
+	if !strings.HasPrefix(header, "bytes=") {
+		return 0, 0, errRangeUnsupported
+	}
+	spec := strings.TrimPrefix(header, "bytes=")
+	if strings.Contains(spec, ",") {
+		return 0, 0, errRangeUnsupported
+	}
+
+	first, last, ok := strings.Cut(strings.TrimSpace(spec), "-")
+	if !ok {
+		return 0, 0, errRangeUnsupported
+	}
+
+	if first == "" {
+		// A suffix range, holding the last bytes of the blob
+		n, err := strconv.ParseInt(last, 10, 64)
+		if err != nil || n < 0 {
+			return 0, 0, errRangeUnsupported
+		}
+		if n == 0 || size == 0 {
+			return 0, 0, errRangeNotSatisfiable
+		}
+		if n > size {
+			n = size
+		}
+		return size - n, n, nil
+	}
+
+	start, err := strconv.ParseInt(first, 10, 64)
+	if err != nil || start < 0 {
+		return 0, 0, errRangeUnsupported
+	}
+	if start >= size {
+		return 0, 0, errRangeNotSatisfiable
+	}
+
+	end := size - 1
+	if last != "" {
+		end, err = strconv.ParseInt(last, 10, 64)
+		if err != nil || end < start {
+			return 0, 0, errRangeUnsupported
+		}
+		if end >= size {
+			end = size - 1
+		}
+	}
+	return start, end - start + 1, nil
 }
 
 func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
@@ -167,6 +438,10 @@ func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distri
 		return distribution.Descriptor{}, err
 	}
 
+	if desc, ok := pbs.archive.stat(ctx, pbs.repositoryName.Name(), dgst); ok {
+		return desc, nil
+	}
+
 	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
 		return distribution.Descriptor{}, err
 	}
@@ -177,19 +452,29 @@ func (pbs *proxyBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distri
 func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
 	blob, err := pbs.localStore.Get(ctx, dgst)
 	if err == nil {
+		pbs.stats.hit(ctx, blobEntry, pbs.namespace, pbs.cacheKey(dgst))
 		return blob, nil
 	}
+	pbs.stats.miss(ctx, blobEntry, pbs.namespace)
+
+	if pbs.restoreArchived(ctx, dgst) {
+		return pbs.localStore.Get(ctx, dgst)
+	}
 
 	if err := pbs.authChallenger.tryEstablishChallenges(ctx); err != nil {
 		return []byte{}, err
 	}
 
+	if pbs.mountCached(ctx, dgst) {
+		return pbs.localStore.Get(ctx, dgst)
+	}
+
 	blob, err = pbs.remoteStore.Get(ctx, dgst)
 	if err != nil {
 		return []byte{}, err
 	}
 
-	_, err = pbs.localStore.Put(ctx, "", blob)
+	_, err = pbs.localStore.Put(pbs.objectTags.with(ctx, pbs.namespace), "", blob)
 	if err != nil {
 		return []byte{}, err
 	}
@@ -197,16 +482,28 @@ func (pbs *proxyBlobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte,
 }
 
 // Unsupported functions
+// The blobs of the referrers pushed to the cache are uploaded to the local
+// storage, where they are not scheduled for expiry.
+
 func (pbs *proxyBlobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
-	return distribution.Descriptor{}, distribution.ErrUnsupported
+	if !pbs.locals.applies(pbs.repositoryName.Name()) {
+		return distribution.Descriptor{}, distribution.ErrUnsupported
+	}
+	return pbs.localStore.Put(ctx, mediaType, p)
 }
 
 func (pbs *proxyBlobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
-	return nil, distribution.ErrUnsupported
+	if !pbs.locals.applies(pbs.repositoryName.Name()) {
+		return nil, distribution.ErrUnsupported
+	}
+	return pbs.localStore.Create(ctx, options...)
 }
 
 func (pbs *proxyBlobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
-	return nil, distribution.ErrUnsupported
+	if !pbs.locals.applies(pbs.repositoryName.Name()) {
+		return nil, distribution.ErrUnsupported
+	}
+	return pbs.localStore.Resume(ctx, id)
 }
 
 func (pbs *proxyBlobStore) Mount(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest) (distribution.Descriptor, error) {
@@ -220,3 +517,8 @@ func (pbs *proxyBlobStore) Open(ctx context.Context, dgst digest.Digest) (io.Rea
 func (pbs *proxyBlobStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	return distribution.ErrUnsupported
 }
+
+// cacheKey returns the key of the blob in the scheduler.
+func (pbs *proxyBlobStore) cacheKey(dgst digest.Digest) string {
+	return pbs.repositoryName.Name() + "@" + dgst.String()
+}
@@ -1,6 +1,7 @@
 package proxy
 
 import (
+	"bytes"
 	"context"
 	"io"
 	"math/rand"
@@ -11,6 +12,7 @@ import (
 	"time"
 
 	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
@@ -402,3 +404,138 @@ func testProxyStoreServe(t *testing.T, te *testEnv, numClients int) {
 		t.Fatalf("unexpected remote stats: %#v", remoteStats)
 	}
 }
+
+func TestProxyStoreServeRange(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	populate(t, te, 1, 100, 1)
+	dgst := te.inRemote[0].Digest
+	blob, err := te.store.remoteStore.Get(te.ctx, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serveRange := func(rangeHeader string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest(http.MethodGet, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Range", rangeHeader)
+
+		if err := te.store.ServeBlob(te.ctx, w, r, dgst); err != nil {
+			t.Fatal(err)
+		}
+		return w
+	}
+
+	// Ranges of blobs which are not cached are forwarded to the remote
+	w := serveRange("bytes=10-19")
+	if w.Code != http.StatusPartialContent {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	}
+	if got := w.Header().Get("Content-Range"); got != "bytes 10-19/100" {
+		t.Fatalf("unexpected content range: %q", got)
+	}
+	if !bytes.Equal(w.Body.Bytes(), blob[10:20]) {
+		t.Fatalf("unexpected range content: %q", w.Body.Bytes())
+	}
+
+	w = serveRange("bytes=100-")
+	if w.Code != http.StatusRequestedRangeNotSatisfiable {
+		t.Fatalf("unexpected status code: %d", w.Code)
+	}
+
+	if (*te.LocalStats())["create"] != 0 {
+		t.Fatalf("range request unexpectedly cached the blob")
+	}
+
+	// The whole blob is cached in the background when enabled
+	te.store.fetchOnRange = true
+	w = serveRange("bytes=-10")
+	if !bytes.Equal(w.Body.Bytes(), blob[90:]) {
+		t.Fatalf("unexpected range content: %q", w.Body.Bytes())
+	}
+
+	for i := 0; ; i++ {
+		if _, err := te.store.localStore.Stat(te.ctx, dgst); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("blob was not cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// Ranges of cached blobs are served locally
+	remoteOpens := (*te.RemoteStats())["open"]
+	w = serveRange("bytes=50-59")
+	if w.Code != http.StatusPartialContent || !bytes.Equal(w.Body.Bytes(), blob[50:60]) {
+		t.Fatalf("unexpected cached range response: %d %q", w.Code, w.Body.Bytes())
+	}
+	if (*te.RemoteStats())["open"] != remoteOpens {
+		t.Fatalf("cached range was fetched from the remote")
+	}
+}
+
+func TestParseByteRange(t *testing.T) {
+	for _, tc := range []struct {
+		header        string
+		start, length int64
+		err           error
+	}{
+		{header: "bytes=0-9", start: 0, length: 10},
+		{header: "bytes=10-", start: 10, length: 90},
+		{header: "bytes=90-200", start: 90, length: 10},
+		{header: "bytes=-5", start: 95, length: 5},
+		{header: "bytes=-500", start: 0, length: 100},
+		{header: "bytes=100-", err: errRangeNotSatisfiable},
+		{header: "bytes=-0", err: errRangeNotSatisfiable},
+		{header: "bytes=0-9,20-29", err: errRangeUnsupported},
+		{header: "bytes=9-0", err: errRangeUnsupported},
+		{header: "items=0-9", err: errRangeUnsupported},
+	} {
+		start, length, err := parseByteRange(tc.header, 100)
+		if err != tc.err || start != tc.start || length != tc.length {
+			t.Errorf("%s: got %d, %d, %v, want %d, %d, %v", tc.header, start, length, err, tc.start, tc.length, tc.err)
+		}
+	}
+}
+
+func TestProxyStoreCacheHeaders(t *testing.T) {
+	te := makeTestEnv(t, "foo/bar")
+	te.store.namespace = "registry-1.docker.io"
+	te.store.stats = newStatsCollector()
+	populate(t, te, 1, 100, 1)
+	dgst := te.inRemote[0].Digest
+
+	serve := func() http.Header {
+		t.Helper()
+		w := httptest.NewRecorder()
+		r, err := http.NewRequest(http.MethodGet, "", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ctx, w2 := dcontext.WithResponseWriter(te.ctx, w)
+		if err := te.store.ServeBlob(ctx, w2, r, dgst); err != nil {
+			t.Fatal(err)
+		}
+		return w.Header()
+	}
+
+	header := serve()
+	if header.Get("X-Cache") != "MISS" || header.Get("X-Cache-Upstream") != "registry-1.docker.io" || header.Get("Age") != "" {
+		t.Fatalf("unexpected headers of a miss: %v", header)
+	}
+
+	for i := 0; te.store.stats.stats()["registry-1.docker.io"].Blobs.Entries == 0; i++ {
+		if i == 100 {
+			t.Fatalf("blob was not cached")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	header = serve()
+	if header.Get("X-Cache") != "HIT" || header.Get("X-Cache-Upstream") != "registry-1.docker.io" || header.Get("Age") != "0" {
+		t.Fatalf("unexpected headers of a hit: %v", header)
+	}
+}
@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/client/auth/challenge"
+	"github.com/gomodule/redigo/redis"
+)
+
+// WithRedis gives the cache the redis pool of the registry, which stores the
+// auth challenges of the upstreams when configured to.
+func WithRedis(pool *redis.Pool) Option {
+	return func(options *cacheOptions) {
+		options.redis = pool
+	}
+}
+
+// validateChallenges checks the configuration of the auth challenges.
+func validateChallenges(config configuration.ProxyChallenges) error {
+	if config.TTL < 0 {
+		return errors.New("challenges: ttl must not be negative")
+	}
+	switch config.Store {
+	case "", "redis":
+	case "file":
+		if config.Path == "" {
+			return errors.New("challenges: path is required with the file store")
+		}
+	default:
+		return fmt.Errorf("challenges: unknown store %q, expected file or redis", config.Store)
+	}
+	return nil
+}
+
+// newChallengeManager returns the manager of the auth challenges of the
+// upstreams, keeping them in the configured store.
+func newChallengeManager(config configuration.ProxyChallenges, pool *redis.Pool) (challenge.Manager, error) {
+	if err := validateChallenges(config); err != nil {
+		return nil, err
+	}
+
+	var store challenge.Store
+	switch config.Store {
+	case "file":
+		store = challenge.NewFileStore(config.Path)
+	case "redis":
+		if pool == nil {
+			return nil, errors.New("challenges: the redis store requires redis to be configured")
+		}
+		store = &redisChallengeStore{pool: pool, ttl: config.TTL}
+	}
+	return challenge.NewManager(config.TTL, store), nil
+}
+
+// redisChallengeStore keeps the auth challenges in redis, where they are
+// shared by the registries of a cluster.
+type redisChallengeStore struct {
+	pool *redis.Pool
+	ttl  time.Duration // expiry of the keys, none if zero
+}
+
+func (s *redisChallengeStore) key(endpoint string) string {
+	return "proxy::challenges::" + endpoint
+}
+
+func (s *redisChallengeStore) Get(endpoint string) (challenge.Entry, bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", s.key(endpoint)))
+	if err == redis.ErrNil {
+		return challenge.Entry{}, false, nil
+	}
+	if err != nil {
+		return challenge.Entry{}, false, err
+	}
+	var entry challenge.Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return challenge.Entry{}, false, err
+	}
+	return entry, true, nil
+}
+
+func (s *redisChallengeStore) Put(endpoint string, entry challenge.Entry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	args := []interface{}{s.key(endpoint), data}
+	if s.ttl > 0 {
+		args = append(args, "PX", s.ttl.Milliseconds())
+	}
+	_, err = conn.Do("SET", args...)
+	return err
+}
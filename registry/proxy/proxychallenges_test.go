@@ -0,0 +1,68 @@
+package proxy
+
+import (
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestNewChallengeManager(t *testing.T) {
+	for _, tc := range []struct {
+		config configuration.ProxyChallenges
+		valid  bool
+	}{
+		{config: configuration.ProxyChallenges{}, valid: true},
+		{config: configuration.ProxyChallenges{TTL: time.Hour}, valid: true},
+		{config: configuration.ProxyChallenges{TTL: -time.Hour}},
+		{config: configuration.ProxyChallenges{Store: "file"}},
+		{config: configuration.ProxyChallenges{Store: "file", Path: filepath.Join(t.TempDir(), "challenges.json")}, valid: true},
+		// redis is not configured
+		{config: configuration.ProxyChallenges{Store: "redis"}},
+		{config: configuration.ProxyChallenges{Store: "etcd"}},
+	} {
+		_, err := newChallengeManager(tc.config, nil)
+		if tc.valid && err != nil {
+			t.Errorf("%+v: unexpected error: %v", tc.config, err)
+		} else if !tc.valid && err == nil {
+			t.Errorf("%+v: expected an error", tc.config)
+		}
+	}
+}
+
+func TestChallengesFileStore(t *testing.T) {
+	config := configuration.ProxyChallenges{
+		TTL:   time.Hour,
+		Store: "file",
+		Path:  filepath.Join(t.TempDir(), "challenges.json"),
+	}
+	cm, err := newChallengeManager(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	endpoint, _ := url.Parse("https://registry-1.docker.io/v2/")
+	resp := &http.Response{
+		Request:    &http.Request{URL: endpoint},
+		Header:     http.Header{"Www-Authenticate": {`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`}},
+		StatusCode: http.StatusUnauthorized,
+	}
+	if err := cm.AddResponse(resp); err != nil {
+		t.Fatal(err)
+	}
+
+	// a restarted registry does not probe the upstream again
+	cm, err = newChallengeManager(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenges, err := cm.GetChallenges(*endpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(challenges) != 1 || challenges[0].Parameters["realm"] != "https://auth.docker.io/token" {
+		t.Fatalf("unexpected challenges %v", challenges)
+	}
+}
@@ -0,0 +1,465 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// errNotConvertible is returned for manifests which have no layers to
+// convert.
+var errNotConvertible = errors.New("manifest has no convertible layers")
+
+// layerFormat is a layer format supporting lazy pulls that images can be
+// converted to.
+type layerFormat struct {
+	// tagSuffix is appended to the tag of an image to name its converted
+	// variant
+	tagSuffix string
+	convert   func(w io.Writer, r io.Reader) (convertedLayer, error)
+}
+
+var layerFormats = map[string]layerFormat{
+	"estargz":     {tagSuffix: "-esgz", convert: convertToEstargz},
+	"zstdchunked": {tagSuffix: "-zstdchunked", convert: convertToZstdChunked},
+}
+
+// imageConverter maps the manifests pulled through the cache to their
+// converted variants, which are stored in the cache under their own digests.
+type imageConverter struct {
+	format layerFormat
+
+	mu        sync.Mutex
+	converted map[string]convertedManifest
+	inflight  map[string]struct{}
+}
+
+type convertedManifest struct {
+	desc    distribution.Descriptor
+	expires time.Time
+}
+
+// newImageConverter returns the converter for the configured layer format,
+// or nil if images are not to be converted.
+func newImageConverter(config configuration.ProxyConversion) (*imageConverter, error) {
+	if config.Format == "" {
+		return nil, nil
+	}
+
+	format, ok := layerFormats[config.Format]
+	if !ok {
+		return nil, fmt.Errorf("unknown layer conversion format %q", config.Format)
+	}
+	return &imageConverter{
+		format:    format,
+		converted: make(map[string]convertedManifest),
+		inflight:  make(map[string]struct{}),
+	}, nil
+}
+
+// lookup returns the converted variant of the manifest with the given key.
+func (ic *imageConverter) lookup(key string) (distribution.Descriptor, bool) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	converted, ok := ic.converted[key]
+	if !ok || time.Now().After(converted.expires) {
+		return distribution.Descriptor{}, false
+	}
+	return converted.desc, true
+}
+
+// record maps the manifest with the given key to its converted variant,
+// which expires from the cache along with the content it was made of.
+func (ic *imageConverter) record(key string, desc distribution.Descriptor) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	now := time.Now()
+	for k, converted := range ic.converted {
+		if now.After(converted.expires) {
+			delete(ic.converted, k)
+		}
+	}
+	ic.converted[key] = convertedManifest{desc: desc, expires: now.Add(repositoryTTL)}
+}
+
+// begin reports whether the caller is the first to convert the manifest
+// with the given key. It must then call end once done.
+func (ic *imageConverter) begin(key string) bool {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+
+	if _, ok := ic.inflight[key]; ok {
+		return false
+	}
+	ic.inflight[key] = struct{}{}
+	return true
+}
+
+func (ic *imageConverter) end(key string) {
+	ic.mu.Lock()
+	defer ic.mu.Unlock()
+	delete(ic.inflight, key)
+}
+
+// imageConversion converts the images of a proxied repository, reading
+// their content from the cache or the remote and storing the converted
+// variants in the cache.
+type imageConversion struct {
+	converter       *imageConverter
+	repositoryName  reference.Named
+	manifests       distribution.ManifestService
+	remoteManifests distribution.ManifestService
+	blobs           distribution.BlobStore
+	remoteBlobs     distribution.BlobService
+	tags            distribution.TagService
+	scheduler       *scheduler.TTLExpirationScheduler
+	platforms       []platform
+	namespace       string
+	stats           *statsCollector
+	objectTags      *objectTags
+}
+
+// start converts the manifest pulled by tag in the background, unless it is
+// already converted, and tags the converted variant with the tag and the
+// suffix of the layer format.
+func (ic *imageConversion) start(tag string, dgst digest.Digest, manifest distribution.Manifest) {
+	if strings.HasSuffix(tag, ic.converter.format.tagSuffix) {
+		// Converted variants are not converted again
+		return
+	}
+
+	ctx := ic.objectTags.with(withFetchReason(context.Background(), fetchReasonConversion), ic.namespace)
+	variantTag := tag + ic.converter.format.tagSuffix
+	key := ic.key(dgst)
+
+	if desc, ok := ic.converter.lookup(key); ok {
+		if current, err := ic.tags.Get(ctx, variantTag); err != nil || current.Digest != desc.Digest {
+			ic.tag(ctx, variantTag, desc)
+		}
+		return
+	}
+
+	if !ic.converter.begin(key) {
+		return
+	}
+	go func() {
+		defer ic.converter.end(key)
+
+		desc, err := ic.convertManifest(ctx, dgst, manifest)
+		if err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error converting manifest %s: %v", dgst, err)
+			return
+		}
+		ic.tag(ctx, variantTag, desc)
+	}()
+}
+
+func (ic *imageConversion) key(dgst digest.Digest) string {
+	return ic.repositoryName.Name() + "@" + dgst.String()
+}
+
+func (ic *imageConversion) tag(ctx context.Context, tag string, desc distribution.Descriptor) {
+	if err := ic.tags.Tag(ctx, tag, desc); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error tagging converted manifest %s as %s: %v", desc.Digest, tag, err)
+	}
+}
+
+// convertManifest returns the converted variant of the manifest, converting
+// it if needed. Manifests without convertible layers are their own variant.
+func (ic *imageConversion) convertManifest(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest) (distribution.Descriptor, error) {
+	key := ic.key(dgst)
+	if desc, ok := ic.converter.lookup(key); ok {
+		return desc, nil
+	}
+
+	var desc distribution.Descriptor
+	var err error
+	switch m := manifest.(type) {
+	case *manifestlist.DeserializedManifestList:
+		desc, err = ic.convertIndex(ctx, m)
+	case *schema2.DeserializedManifest:
+		desc, err = ic.convertImage(ctx, m.Config, m.Layers, nil)
+	case *ocischema.DeserializedManifest:
+		desc, err = ic.convertImage(ctx, m.Config, m.Layers, m.Annotations)
+	default:
+		err = errNotConvertible
+	}
+
+	if err == errNotConvertible {
+		mediaType, payload, payloadErr := manifest.Payload()
+		if payloadErr != nil {
+			return distribution.Descriptor{}, payloadErr
+		}
+		desc, err = distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}, nil
+	}
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	ic.converter.record(key, desc)
+	return desc, nil
+}
+
+// convertIndex converts the children of an image index which match the
+// configured platforms, or all of them when none are configured.
+func (ic *imageConversion) convertIndex(ctx context.Context, ml *manifestlist.DeserializedManifestList) (distribution.Descriptor, error) {
+	var converted bool
+	children := make([]manifestlist.ManifestDescriptor, 0, len(ml.Manifests))
+	for _, child := range ml.Manifests {
+		if len(ic.platforms) == 0 || matchesAnyPlatform(ic.platforms, child.Platform) {
+			manifest, err := ic.fetchManifest(ctx, child.Digest)
+			if err != nil {
+				return distribution.Descriptor{}, err
+			}
+
+			desc, err := ic.convertManifest(ctx, child.Digest, manifest)
+			if err != nil {
+				return distribution.Descriptor{}, err
+			}
+			if desc.Digest != child.Digest {
+				child.MediaType = desc.MediaType
+				child.Digest = desc.Digest
+				child.Size = desc.Size
+				converted = true
+			}
+		}
+		children = append(children, child)
+	}
+
+	if !converted {
+		return distribution.Descriptor{}, errNotConvertible
+	}
+
+	index, err := manifestlist.FromDescriptorsWithMediaType(children, v1.MediaTypeImageIndex)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return ic.putManifest(ctx, index)
+}
+
+// convertImage converts the gzip layers of an image, and stores an OCI image
+// manifest referencing them along with a configuration holding their diff
+// IDs.
+func (ic *imageConversion) convertImage(ctx context.Context, config distribution.Descriptor, layers []distribution.Descriptor, annotations map[string]string) (distribution.Descriptor, error) {
+	var converted bool
+	convertedLayers := make([]distribution.Descriptor, len(layers))
+	diffIDs := make(map[int]digest.Digest)
+	for i, layer := range layers {
+		if layer.MediaType != schema2.MediaTypeLayer && layer.MediaType != v1.MediaTypeImageLayerGzip {
+			convertedLayers[i] = layer
+			continue
+		}
+
+		desc, diffID, err := ic.convertLayer(ctx, layer)
+		if err != nil {
+			return distribution.Descriptor{}, fmt.Errorf("converting layer %s: %v", layer.Digest, err)
+		}
+		convertedLayers[i] = desc
+		diffIDs[i] = diffID
+		converted = true
+	}
+
+	if !converted {
+		return distribution.Descriptor{}, errNotConvertible
+	}
+
+	configDesc, err := ic.convertConfig(ctx, config, len(layers), diffIDs)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	manifest, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned:   ocischema.SchemaVersion,
+		Config:      configDesc,
+		Layers:      convertedLayers,
+		Annotations: annotations,
+	})
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return ic.putManifest(ctx, manifest)
+}
+
+// convertLayer stores the converted variant of a gzip layer, and returns
+// its descriptor and diff ID.
+func (ic *imageConversion) convertLayer(ctx context.Context, layer distribution.Descriptor) (distribution.Descriptor, digest.Digest, error) {
+	rc, err := ic.openBlob(ctx, layer.Digest)
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer rc.Close()
+
+	gz, err := gzip.NewReader(rc)
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer gz.Close()
+
+	bw, err := ic.blobs.Create(ctx)
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+
+	// Stream the converted layer to the blob writer in a single copy, as
+	// when caching blobs, rather than in many small writes
+	pr, pw := io.Pipe()
+	var layerInfo convertedLayer
+	go func() {
+		var err error
+		layerInfo, err = ic.converter.format.convert(pw, gz)
+		pw.CloseWithError(err)
+	}()
+
+	digester := digest.Canonical.Digester()
+	size, err := io.Copy(bw, io.TeeReader(pr, digester.Hash()))
+	if err != nil {
+		pr.CloseWithError(err)
+		bw.Cancel(ctx)
+		return distribution.Descriptor{}, "", err
+	}
+
+	desc := distribution.Descriptor{
+		MediaType:   layerInfo.mediaType,
+		Digest:      digester.Digest(),
+		Size:        size,
+		Annotations: layerInfo.annotations,
+	}
+	if _, err := bw.Commit(ctx, desc); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+
+	ic.schedule(blobEntry, desc.Digest, desc.Size)
+	return desc, layerInfo.diffID, nil
+}
+
+// convertConfig stores a copy of the image configuration with the diff IDs
+// of the converted layers.
+func (ic *imageConversion) convertConfig(ctx context.Context, config distribution.Descriptor, layerCount int, diffIDs map[int]digest.Digest) (distribution.Descriptor, error) {
+	payload, err := ic.fetchBlob(ctx, config.Digest)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	var image map[string]json.RawMessage
+	if err := json.Unmarshal(payload, &image); err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("invalid image configuration %s: %v", config.Digest, err)
+	}
+
+	var rootfs struct {
+		Type    string          `json:"type"`
+		DiffIDs []digest.Digest `json:"diff_ids"`
+	}
+	if err := json.Unmarshal(image["rootfs"], &rootfs); err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("invalid image configuration %s: %v", config.Digest, err)
+	}
+	if len(rootfs.DiffIDs) != layerCount {
+		return distribution.Descriptor{}, fmt.Errorf("image configuration %s has %d diff IDs for %d layers", config.Digest, len(rootfs.DiffIDs), layerCount)
+	}
+
+	for i, diffID := range diffIDs {
+		rootfs.DiffIDs[i] = diffID
+	}
+	if image["rootfs"], err = json.Marshal(rootfs); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if payload, err = json.Marshal(image); err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	desc, err := ic.blobs.Put(ctx, v1.MediaTypeImageConfig, payload)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	ic.schedule(blobEntry, desc.Digest, desc.Size)
+	return desc, nil
+}
+
+func (ic *imageConversion) putManifest(ctx context.Context, manifest distribution.Manifest) (distribution.Descriptor, error) {
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	dgst, err := ic.manifests.Put(ctx, manifest)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	ic.schedule(manifestEntry, dgst, int64(len(payload)))
+	return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(payload))}, nil
+}
+
+// schedule expires converted content along with the content it was made of.
+func (ic *imageConversion) schedule(kind cacheEntryKind, dgst digest.Digest, size int64) {
+	ref, err := reference.WithDigest(ic.repositoryName, dgst)
+	if err != nil {
+		return
+	}
+
+	if kind == manifestEntry {
+		ic.scheduler.AddManifest(ref, repositoryTTL)
+	} else {
+		ic.scheduler.AddBlob(ref, repositoryTTL)
+	}
+	ic.stats.cached(kind, ic.namespace, ref.String(), size)
+}
+
+// fetchManifest returns a manifest from the cache, or from the remote when
+// it is not cached.
+func (ic *imageConversion) fetchManifest(ctx context.Context, dgst digest.Digest) (distribution.Manifest, error) {
+	if manifest, err := ic.manifests.Get(ctx, dgst); err == nil {
+		return manifest, nil
+	}
+
+	manifest, err := ic.remoteManifests.Get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+
+	// Children of an image index are trusted by digest only
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return nil, err
+	}
+	if dgst.Algorithm().FromBytes(payload) != dgst {
+		return nil, fmt.Errorf("manifest %s does not match its digest", dgst)
+	}
+	return manifest, nil
+}
+
+// fetchBlob returns a blob from the cache, or from the remote when it is not
+// cached.
+func (ic *imageConversion) fetchBlob(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	if p, err := ic.blobs.Get(ctx, dgst); err == nil {
+		return p, nil
+	}
+	return ic.remoteBlobs.Get(ctx, dgst)
+}
+
+// openBlob opens a blob from the cache, or from the remote when it is not
+// cached.
+func (ic *imageConversion) openBlob(ctx context.Context, dgst digest.Digest) (io.ReadCloser, error) {
+	if rc, err := ic.blobs.Open(ctx, dgst); err == nil {
+		return rc, nil
+	}
+	return ic.remoteBlobs.Open(ctx, dgst)
+}
@@ -0,0 +1,391 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/cache/memory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+)
+
+var testLayerFiles = map[string]string{
+	"etc/hostname": "proxy\n",
+	"bin/big":      strings.Repeat("0123456789abcdef", layerChunkSize/8), // two chunks
+}
+
+// makeTarLayer returns an uncompressed layer holding a directory and the
+// test files.
+func makeTarLayer(t *testing.T) []byte {
+	t.Helper()
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "etc/", Typeflag: tar.TypeDir, Mode: 0o755}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"etc/hostname", "bin/big"} {
+		content := testLayerFiles[name]
+		if err := tw.WriteHeader(&tar.Header{Name: name, Typeflag: tar.TypeReg, Mode: 0o644, Size: int64(len(content))}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// readTarFiles returns the content of the regular files of a tar stream.
+func readTarFiles(t *testing.T, r io.Reader) map[string]string {
+	t.Helper()
+
+	files := make(map[string]string)
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if hdr.Typeflag == tar.TypeReg {
+			content, err := io.ReadAll(tr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			files[hdr.Name] = string(content)
+		}
+	}
+}
+
+// checkTOC verifies that the TOC entries of the test files point at frames
+// holding their content.
+func checkTOC(t *testing.T, toc tableOfContents, readFrame func(offset, size int64) []byte) {
+	t.Helper()
+
+	var chunks int
+	for _, entry := range toc.Entries {
+		if entry.Type != "reg" && entry.Type != "chunk" {
+			continue
+		}
+		content, ok := testLayerFiles[entry.Name]
+		if !ok {
+			continue
+		}
+
+		size := entry.ChunkSize
+		if size == 0 {
+			size = entry.Size
+		}
+		want := content[entry.ChunkOffset : entry.ChunkOffset+size]
+		if got := readFrame(entry.Offset, size); string(got) != want {
+			t.Errorf("unexpected content at offset %d of %s", entry.ChunkOffset, entry.Name)
+		}
+		if entry.ChunkDigest != digest.FromString(want).String() {
+			t.Errorf("unexpected chunk digest for %s", entry.Name)
+		}
+		chunks++
+	}
+	if chunks != 3 {
+		t.Errorf("expected 3 file chunks, got %d", chunks)
+	}
+}
+
+func TestConvertToEstargz(t *testing.T) {
+	var buf bytes.Buffer
+	layer, err := convertToEstargz(&buf, bytes.NewReader(makeTarLayer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := buf.Bytes()
+
+	// The blob is a valid gzip layer
+	gz, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.FromBytes(uncompressed) != layer.diffID {
+		t.Errorf("unexpected diff ID %s", layer.diffID)
+	}
+	if layer.annotations[estargzUncompressedSize] != fmt.Sprint(len(uncompressed)) {
+		t.Errorf("unexpected uncompressed size %s", layer.annotations[estargzUncompressedSize])
+	}
+	files := readTarFiles(t, bytes.NewReader(uncompressed))
+	for name, content := range testLayerFiles {
+		if files[name] != content {
+			t.Errorf("unexpected content of %s", name)
+		}
+	}
+
+	// The footer locates the TOC
+	footer, err := gzip.NewReader(bytes.NewReader(blob[len(blob)-estargzFooterSize:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tocOffset int64
+	if _, err := fmt.Sscanf(string(footer.Header.Extra[4:]), "%016xSTARGZ", &tocOffset); err != nil {
+		t.Fatal(err)
+	}
+
+	gz, err = gzip.NewReader(bytes.NewReader(blob[tocOffset:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tocFiles := readTarFiles(t, gz)
+	tocJSON := tocFiles[estargzTOCName]
+	if layer.annotations[estargzTOCDigest] != digest.FromString(tocJSON).String() {
+		t.Errorf("unexpected TOC digest")
+	}
+
+	var toc tableOfContents
+	if err := json.Unmarshal([]byte(tocJSON), &toc); err != nil {
+		t.Fatal(err)
+	}
+	if toc.Entries[0].Name != estargzNoPrefetchName {
+		t.Errorf("expected the landmark first, got %s", toc.Entries[0].Name)
+	}
+	checkTOC(t, toc, func(offset, size int64) []byte {
+		gz, err := gzip.NewReader(bytes.NewReader(blob[offset:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		gz.Multistream(false)
+		p, _ := io.ReadAll(io.LimitReader(gz, size))
+		return p
+	})
+}
+
+func TestConvertToZstdChunked(t *testing.T) {
+	var buf bytes.Buffer
+	layer, err := convertToZstdChunked(&buf, bytes.NewReader(makeTarLayer(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob := buf.Bytes()
+
+	// The blob is a valid zstd layer
+	dec, err := zstd.NewReader(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dec.Close()
+	uncompressed, err := dec.DecodeAll(blob, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if digest.FromBytes(uncompressed) != layer.diffID {
+		t.Errorf("unexpected diff ID %s", layer.diffID)
+	}
+	files := readTarFiles(t, bytes.NewReader(uncompressed))
+	for name, content := range testLayerFiles {
+		if files[name] != content {
+			t.Errorf("unexpected content of %s", name)
+		}
+	}
+
+	// The footer locates the TOC
+	footer := blob[len(blob)-40:]
+	if !bytes.Equal(footer[32:], zstdChunkedFrameMagic) {
+		t.Fatal("missing zstd:chunked footer")
+	}
+	offset := binary.LittleEndian.Uint64(footer[0:])
+	length := binary.LittleEndian.Uint64(footer[8:])
+	position := fmt.Sprintf("%d:%d:%d:%d", offset, length, binary.LittleEndian.Uint64(footer[16:]), zstdChunkedManifestTypeTOC)
+	if layer.annotations[zstdChunkedManifestPosition] != position {
+		t.Errorf("unexpected manifest position %s, footer has %s", layer.annotations[zstdChunkedManifestPosition], position)
+	}
+
+	manifest := blob[offset : offset+length]
+	if layer.annotations[zstdChunkedManifestChecksum] != digest.FromBytes(manifest).String() {
+		t.Errorf("unexpected manifest checksum")
+	}
+	tocJSON, err := dec.DecodeAll(manifest, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var toc tableOfContents
+	if err := json.Unmarshal(tocJSON, &toc); err != nil {
+		t.Fatal(err)
+	}
+	checkTOC(t, toc, func(offset, size int64) []byte {
+		zr, err := zstd.NewReader(bytes.NewReader(blob[offset:]))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer zr.Close()
+		p, _ := io.ReadAll(io.LimitReader(zr, size))
+		return p
+	})
+}
+
+func TestProxyManifestsConvertImage(t *testing.T) {
+	ctx := context.Background()
+	name, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRepo := func() distribution.Repository {
+		registry, err := storage.NewRegistry(ctx, inmemory.New(), storage.BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := registry.Repository(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+	truthRepo := newRepo()
+	localRepo := newRepo()
+
+	// Push an image with a gzip layer to the remote
+	tarLayer := makeTarLayer(t)
+	var gzLayer bytes.Buffer
+	gw := gzip.NewWriter(&gzLayer)
+	gw.Write(tarLayer)
+	gw.Close()
+	layerDesc, err := truthRepo.Blobs(ctx).Put(ctx, schema2.MediaTypeLayer, gzLayer.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	layerDesc.MediaType = schema2.MediaTypeLayer
+
+	config := fmt.Sprintf(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[%q]}}`, digest.FromBytes(tarLayer))
+	configDesc, err := truthRepo.Blobs(ctx).Put(ctx, schema2.MediaTypeImageConfig, []byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	configDesc.MediaType = schema2.MediaTypeImageConfig
+
+	image, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    configDesc,
+		Layers:    []distribution.Descriptor{layerDesc},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	truthManifests, err := truthRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	imageDigest, err := truthManifests.Put(ctx, image)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := newImageConverter(configuration.ProxyConversion{Format: "estargz"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+	pms := proxyManifestStore{
+		ctx:             ctx,
+		localManifests:  localManifests,
+		remoteManifests: truthManifests,
+		scheduler:       s,
+		repositoryName:  name,
+		authChallenger:  &mockChallenger{},
+	}
+	pms.conversion = &imageConversion{
+		converter:       converter,
+		repositoryName:  name,
+		manifests:       localManifests,
+		remoteManifests: truthManifests,
+		blobs:           localRepo.Blobs(ctx),
+		remoteBlobs:     truthRepo.Blobs(ctx),
+		tags:            localRepo.Tags(ctx),
+		scheduler:       s,
+	}
+
+	if _, err := pms.Get(ctx, imageDigest, distribution.WithTag("latest")); err != nil {
+		t.Fatal(err)
+	}
+
+	// The converted variant is tagged once converted
+	var converted distribution.Descriptor
+	for i := 0; ; i++ {
+		if converted, err = localRepo.Tags(ctx).Get(ctx, "latest-esgz"); err == nil {
+			break
+		}
+		if i == 100 {
+			t.Fatalf("image was not converted: %v", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	manifest, err := localManifests.Get(ctx, converted.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ociManifest, ok := manifest.(*ocischema.DeserializedManifest)
+	if !ok {
+		t.Fatalf("unexpected converted manifest type %T", manifest)
+	}
+	convertedLayer := ociManifest.Layers[0]
+	if convertedLayer.Annotations[estargzTOCDigest] == "" {
+		t.Fatalf("converted layer has no TOC digest: %v", convertedLayer)
+	}
+
+	blob, err := localRepo.Blobs(ctx).Get(ctx, convertedLayer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	uncompressed, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	configBlob, err := localRepo.Blobs(ctx).Get(ctx, ociManifest.Config.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(configBlob), digest.FromBytes(uncompressed).String()) {
+		t.Errorf("converted configuration does not hold the diff ID of the converted layer: %s", configBlob)
+	}
+
+	// Pulling the converted variant does not convert it again
+	if _, err := pms.Get(ctx, converted.Digest, distribution.WithTag("latest-esgz")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := localRepo.Tags(ctx).Get(ctx, "latest-esgz-esgz"); err == nil {
+		t.Error("converted variant was converted again")
+	}
+}
@@ -0,0 +1,418 @@
+package proxy
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// layerChunkSize is the largest part of a file compressed as a single
+	// frame, which is the unit lazy pulling clients fetch.
+	layerChunkSize = 4 << 20
+
+	estargzTOCName          = "stargz.index.json"
+	estargzNoPrefetchName   = ".no.prefetch.landmark"
+	estargzFooterSize       = 51
+	estargzTOCDigest        = "containerd.io/snapshot/stargz/toc.digest"
+	estargzUncompressedSize = "io.containers.estargz.uncompressed-size"
+
+	zstdChunkedManifestChecksum = "io.github.containers.zstd-chunked.manifest-checksum"
+	zstdChunkedManifestPosition = "io.github.containers.zstd-chunked.manifest-position"
+	zstdChunkedManifestTypeTOC  = 1
+	zstdSkippableFrameMagic     = 0x184D2A50
+
+	mediaTypeImageLayerZstd = "application/vnd.oci.image.layer.v1.tar+zstd"
+)
+
+// zstdChunkedFrameMagic ends the footer of a zstd:chunked layer.
+var zstdChunkedFrameMagic = []byte{0x47, 0x4e, 0x55, 0x6c, 0x49, 0x6e, 0x55, 0x78}
+
+// tocEntry describes a file, or a chunk of a file, in the table of contents
+// of a converted layer. Offsets are those of the compressed frame holding
+// the content.
+type tocEntry struct {
+	Name        string            `json:"name"`
+	Type        string            `json:"type"`
+	Size        int64             `json:"size,omitempty"`
+	ModTime     string            `json:"modtime,omitempty"`
+	LinkName    string            `json:"linkName,omitempty"`
+	Mode        int64             `json:"mode,omitempty"`
+	UID         int               `json:"uid,omitempty"`
+	GID         int               `json:"gid,omitempty"`
+	Uname       string            `json:"userName,omitempty"`
+	Gname       string            `json:"groupName,omitempty"`
+	Offset      int64             `json:"offset,omitempty"`
+	DevMajor    int64             `json:"devMajor,omitempty"`
+	DevMinor    int64             `json:"devMinor,omitempty"`
+	Xattrs      map[string][]byte `json:"xattrs,omitempty"`
+	Digest      string            `json:"digest,omitempty"`
+	ChunkOffset int64             `json:"chunkOffset,omitempty"`
+	ChunkSize   int64             `json:"chunkSize,omitempty"`
+	ChunkDigest string            `json:"chunkDigest,omitempty"`
+}
+
+type tableOfContents struct {
+	Version int        `json:"version"`
+	Entries []tocEntry `json:"entries"`
+}
+
+// convertedLayer describes a layer written by a layerWriter.
+type convertedLayer struct {
+	mediaType   string
+	diffID      digest.Digest
+	annotations map[string]string
+}
+
+// frameCompressor compresses the independent frames a converted layer is
+// made of.
+type frameCompressor interface {
+	// newFrame returns a writer compressing a new frame to w.
+	newFrame(w io.Writer) io.WriteCloser
+}
+
+type gzipFrames struct {
+	gz *gzip.Writer
+}
+
+func (gf *gzipFrames) newFrame(w io.Writer) io.WriteCloser {
+	if gf.gz == nil {
+		gf.gz = gzip.NewWriter(w)
+	} else {
+		gf.gz.Reset(w)
+	}
+	return gf.gz
+}
+
+type zstdFrames struct {
+	enc *zstd.Encoder
+}
+
+func (zf *zstdFrames) newFrame(w io.Writer) io.WriteCloser {
+	zf.enc.Reset(w)
+	return zf.enc
+}
+
+// countingWriter counts the bytes written to w.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (cw *countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += int64(n)
+	return n, err
+}
+
+// layerWriter rewrites a layer so that each file chunk starts a new
+// compressed frame, and records where each frame starts in a table of
+// contents. Clients can then fetch single files with Range requests.
+type layerWriter struct {
+	out    *countingWriter
+	frames frameCompressor
+	frame  io.WriteCloser
+
+	// uncompressed receives everything written to the frames, to compute
+	// the diff ID and uncompressed size of the layer
+	diffID       digest.Digester
+	uncompressed *countingWriter
+
+	tw  *tar.Writer
+	toc tableOfContents
+}
+
+func newLayerWriter(w io.Writer, frames frameCompressor) *layerWriter {
+	lw := &layerWriter{
+		out:    &countingWriter{w: w},
+		frames: frames,
+		diffID: digest.Canonical.Digester(),
+		toc:    tableOfContents{Version: 1},
+	}
+	lw.uncompressed = &countingWriter{w: lw.diffID.Hash()}
+	lw.tw = tar.NewWriter(lw)
+	return lw
+}
+
+// Write writes uncompressed data to the current frame.
+func (lw *layerWriter) Write(p []byte) (int, error) {
+	if lw.frame == nil {
+		lw.frame = lw.frames.newFrame(lw.out)
+	}
+	n, err := lw.frame.Write(p)
+	lw.uncompressed.Write(p[:n])
+	return n, err
+}
+
+// cut closes the current frame, so that the next write starts a new one.
+func (lw *layerWriter) cut() error {
+	if lw.frame == nil {
+		return nil
+	}
+	err := lw.frame.Close()
+	lw.frame = nil
+	return err
+}
+
+// convert copies the entries of the tar stream to the layer.
+func (lw *layerWriter) convert(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err := lw.writeEntry(hdr, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// writeEntry writes a tar entry, starting a new frame for each chunk of a
+// regular file.
+func (lw *layerWriter) writeEntry(hdr *tar.Header, r io.Reader) error {
+	if err := lw.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	entry := tocEntry{
+		Name:     hdr.Name,
+		Type:     tocEntryType(hdr.Typeflag),
+		Size:     hdr.Size,
+		ModTime:  hdr.ModTime.UTC().Format(time.RFC3339),
+		LinkName: hdr.Linkname,
+		Mode:     hdr.Mode,
+		UID:      hdr.Uid,
+		GID:      hdr.Gid,
+		Uname:    hdr.Uname,
+		Gname:    hdr.Gname,
+		DevMajor: hdr.Devmajor,
+		DevMinor: hdr.Devminor,
+	}
+	for key, value := range hdr.PAXRecords {
+		if strings.HasPrefix(key, "SCHILY.xattr.") {
+			if entry.Xattrs == nil {
+				entry.Xattrs = make(map[string][]byte)
+			}
+			entry.Xattrs[strings.TrimPrefix(key, "SCHILY.xattr.")] = []byte(value)
+		}
+	}
+	if entry.Type != "reg" || hdr.Size == 0 {
+		if entry.Type != "reg" {
+			entry.Size = 0
+		}
+		lw.toc.Entries = append(lw.toc.Entries, entry)
+		return nil
+	}
+
+	fileDigest := digest.Canonical.Digester()
+	first := len(lw.toc.Entries)
+	for offset := int64(0); offset < hdr.Size; offset += layerChunkSize {
+		size := hdr.Size - offset
+		if size > layerChunkSize {
+			size = layerChunkSize
+		}
+
+		if err := lw.cut(); err != nil {
+			return err
+		}
+
+		chunk := entry
+		if offset > 0 {
+			chunk = tocEntry{Name: hdr.Name, Type: "chunk"}
+		}
+		chunk.Offset = lw.out.n
+		chunk.ChunkOffset = offset
+		if hdr.Size > layerChunkSize {
+			chunk.ChunkSize = size
+		}
+
+		chunkDigest := digest.Canonical.Digester()
+		if _, err := io.CopyN(io.MultiWriter(lw.tw, chunkDigest.Hash(), fileDigest.Hash()), r, size); err != nil {
+			return err
+		}
+		chunk.ChunkDigest = chunkDigest.Digest().String()
+		lw.toc.Entries = append(lw.toc.Entries, chunk)
+	}
+	lw.toc.Entries[first].Digest = fileDigest.Digest().String()
+
+	// Start the next entry in a new frame, so that the frames of the file
+	// only hold its content
+	if err := lw.tw.Flush(); err != nil {
+		return err
+	}
+	return lw.cut()
+}
+
+func tocEntryType(typeflag byte) string {
+	switch typeflag {
+	case tar.TypeReg:
+		return "reg"
+	case tar.TypeDir:
+		return "dir"
+	case tar.TypeSymlink:
+		return "symlink"
+	case tar.TypeLink:
+		return "hardlink"
+	case tar.TypeChar:
+		return "char"
+	case tar.TypeBlock:
+		return "block"
+	case tar.TypeFifo:
+		return "fifo"
+	default:
+		return "unknown"
+	}
+}
+
+// convertToEstargz writes the uncompressed tar stream r to w as an eStargz
+// layer: a gzip layer whose table of contents is stored as the last tar
+// entry, located through a footer at the end of the blob.
+func convertToEstargz(w io.Writer, r io.Reader) (convertedLayer, error) {
+	lw := newLayerWriter(w, &gzipFrames{})
+
+	// Lazy pulling clients prefetch nothing when they find this landmark
+	landmark := &tar.Header{Name: estargzNoPrefetchName, Typeflag: tar.TypeReg, Mode: 0o644, Size: 1}
+	if err := lw.writeEntry(landmark, bytes.NewReader([]byte{0xf})); err != nil {
+		return convertedLayer{}, err
+	}
+
+	if err := lw.convert(r); err != nil {
+		return convertedLayer{}, err
+	}
+	if err := lw.tw.Flush(); err != nil {
+		return convertedLayer{}, err
+	}
+	if err := lw.cut(); err != nil {
+		return convertedLayer{}, err
+	}
+
+	toc, err := json.MarshalIndent(lw.toc, "", "\t")
+	if err != nil {
+		return convertedLayer{}, err
+	}
+
+	tocOffset := lw.out.n
+	if err := lw.tw.WriteHeader(&tar.Header{
+		Name:     estargzTOCName,
+		Typeflag: tar.TypeReg,
+		Mode:     0o444,
+		Size:     int64(len(toc)),
+		Format:   tar.FormatPAX,
+	}); err != nil {
+		return convertedLayer{}, err
+	}
+	if _, err := lw.tw.Write(toc); err != nil {
+		return convertedLayer{}, err
+	}
+	if err := lw.tw.Close(); err != nil {
+		return convertedLayer{}, err
+	}
+	if err := lw.cut(); err != nil {
+		return convertedLayer{}, err
+	}
+
+	if _, err := lw.out.Write(estargzFooter(tocOffset)); err != nil {
+		return convertedLayer{}, err
+	}
+
+	return convertedLayer{
+		mediaType: v1.MediaTypeImageLayerGzip,
+		diffID:    lw.diffID.Digest(),
+		annotations: map[string]string{
+			estargzTOCDigest:        digest.FromBytes(toc).String(),
+			estargzUncompressedSize: strconv.FormatInt(lw.uncompressed.n, 10),
+		},
+	}, nil
+}
+
+// estargzFooter returns an empty gzip stream whose extra field holds the
+// offset of the table of contents. It is written by hand as its size is
+// fixed, unlike the output of the compressor.
+func estargzFooter(tocOffset int64) []byte {
+	subfield := fmt.Sprintf("%016xSTARGZ", tocOffset)
+
+	footer := make([]byte, estargzFooterSize)
+	copy(footer, []byte{0x1f, 0x8b, 8, 4, 0, 0, 0, 0, 0, 0xff}) // gzip header with an extra field
+	binary.LittleEndian.PutUint16(footer[10:], uint16(4+len(subfield)))
+	copy(footer[12:], "SG")
+	binary.LittleEndian.PutUint16(footer[14:], uint16(len(subfield)))
+	copy(footer[16:], subfield)
+	copy(footer[38:], []byte{1, 0, 0, 0xff, 0xff}) // empty final stored block
+	// The CRC-32 and size of the empty content are zero
+	return footer
+}
+
+// convertToZstdChunked writes the uncompressed tar stream r to w as a
+// zstd:chunked layer: a zstd layer whose table of contents is stored in a
+// skippable frame, located through a footer frame at the end of the blob.
+func convertToZstdChunked(w io.Writer, r io.Reader) (convertedLayer, error) {
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	if err != nil {
+		return convertedLayer{}, err
+	}
+
+	lw := newLayerWriter(w, &zstdFrames{enc: enc})
+	if err := lw.convert(r); err != nil {
+		return convertedLayer{}, err
+	}
+	if err := lw.tw.Close(); err != nil {
+		return convertedLayer{}, err
+	}
+	if err := lw.cut(); err != nil {
+		return convertedLayer{}, err
+	}
+
+	toc, err := json.Marshal(lw.toc)
+	if err != nil {
+		return convertedLayer{}, err
+	}
+	manifest := enc.EncodeAll(toc, nil)
+
+	// The manifest starts after the header of its skippable frame
+	manifestOffset := lw.out.n + 8
+	if _, err := lw.out.Write(zstdSkippableFrame(manifest)); err != nil {
+		return convertedLayer{}, err
+	}
+
+	footer := make([]byte, 40)
+	binary.LittleEndian.PutUint64(footer[0:], uint64(manifestOffset))
+	binary.LittleEndian.PutUint64(footer[8:], uint64(len(manifest)))
+	binary.LittleEndian.PutUint64(footer[16:], uint64(len(toc)))
+	binary.LittleEndian.PutUint64(footer[24:], zstdChunkedManifestTypeTOC)
+	copy(footer[32:], zstdChunkedFrameMagic)
+	if _, err := lw.out.Write(zstdSkippableFrame(footer)); err != nil {
+		return convertedLayer{}, err
+	}
+
+	return convertedLayer{
+		mediaType: mediaTypeImageLayerZstd,
+		diffID:    lw.diffID.Digest(),
+		annotations: map[string]string{
+			zstdChunkedManifestChecksum: digest.FromBytes(manifest).String(),
+			zstdChunkedManifestPosition: fmt.Sprintf("%d:%d:%d:%d", manifestOffset, len(manifest), len(toc), zstdChunkedManifestTypeTOC),
+		},
+	}, nil
+}
+
+// zstdSkippableFrame wraps data in a frame which zstd decoders skip.
+func zstdSkippableFrame(data []byte) []byte {
+	frame := make([]byte, 8, 8+len(data))
+	binary.LittleEndian.PutUint32(frame[0:], zstdSkippableFrameMagic)
+	binary.LittleEndian.PutUint32(frame[4:], uint32(len(data)))
+	return append(frame, data...)
+}
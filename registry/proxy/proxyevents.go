@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"context"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	registryauth "github.com/distribution/distribution/v3/registry/auth"
+	"github.com/distribution/distribution/v3/uuid"
+	events "github.com/docker/go-events"
+	"github.com/gomodule/redigo/redis"
+	"github.com/opencontainers/go-digest"
+)
+
+// eventProvider is the source provider of the events of the cache.
+const eventProvider = "proxy"
+
+// Option configures a registry acting as a pull through cache.
+type Option func(*cacheOptions)
+
+type cacheOptions struct {
+	notifier *eventNotifier
+	redis    *redis.Pool
+}
+
+// WithEventSink makes the cache write events to sink when it caches a
+// manifest, evicts content or fails to fetch content from an upstream. The
+// events are attributed to source, with the "proxy" provider. When
+// includeReferences is set, the events of cached manifests carry the
+// descriptors the manifests reference.
+func WithEventSink(sink events.Sink, source notifications.SourceRecord, includeReferences bool) Option {
+	return func(options *cacheOptions) {
+		source.Provider = eventProvider
+		options.notifier = &eventNotifier{
+			sink:              sink,
+			source:            source,
+			includeReferences: includeReferences,
+		}
+	}
+}
+
+// eventNotifier writes the events of the cache. A nil notifier writes
+// nothing.
+type eventNotifier struct {
+	sink              events.Sink
+	source            notifications.SourceRecord
+	includeReferences bool
+}
+
+// manifestCached notifies that a manifest fetched from an upstream entered
+// the cache.
+func (en *eventNotifier) manifestCached(ctx context.Context, repo reference.Named, dgst digest.Digest, manifest distribution.Manifest, tag string) {
+	if en == nil {
+		return
+	}
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error creating proxy cache event: %s", err)
+		return
+	}
+
+	event := en.createEvent(ctx, notifications.EventActionCache, repo)
+	event.Target.MediaType = mediaType
+	event.Target.Digest = dgst
+	event.Target.Size = int64(len(payload))
+	event.Target.Length = event.Target.Size
+	event.Target.Tag = tag
+	if en.includeReferences {
+		event.Target.References = append(event.Target.References, manifest.References()...)
+	}
+	en.write(ctx, event)
+}
+
+// evicted notifies that cached content expired and was removed.
+func (en *eventNotifier) evicted(ctx context.Context, ref reference.Canonical) {
+	if en == nil {
+		return
+	}
+
+	event := en.createEvent(ctx, notifications.EventActionEvict, ref)
+	event.Target.Digest = ref.Digest()
+	en.write(ctx, event)
+}
+
+// fetchFailed notifies that content could not be fetched from an upstream.
+// Fetches stopped because their request went away are not reported.
+func (en *eventNotifier) fetchFailed(ctx context.Context, repo reference.Named, dgst digest.Digest) {
+	if en == nil || ctx.Err() != nil {
+		return
+	}
+
+	event := en.createEvent(ctx, notifications.EventActionFetchFailed, repo)
+	event.Target.Digest = dgst
+	en.write(ctx, event)
+}
+
+// createEvent creates an event for the repository, with the actor and
+// request of ctx when the event was caused by a request.
+func (en *eventNotifier) createEvent(ctx context.Context, action string, repo reference.Named) *notifications.Event {
+	event := &notifications.Event{
+		ID:        uuid.Generate().String(),
+		Timestamp: time.Now(),
+		Action:    action,
+		Source:    en.source,
+		Actor: notifications.ActorRecord{
+			Name: dcontext.GetStringValue(ctx, registryauth.UserNameKey),
+		},
+	}
+	event.Target.Repository = repo.Name()
+	if r, err := dcontext.GetRequest(ctx); err == nil {
+		event.Request = notifications.NewRequestRecord(dcontext.GetRequestID(ctx), r)
+	}
+	return event
+}
+
+func (en *eventNotifier) write(ctx context.Context, event *notifications.Event) {
+	if err := en.sink.Write(*event); err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error writing proxy cache event: %s", err)
+	}
+}
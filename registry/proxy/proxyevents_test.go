@@ -0,0 +1,86 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+type eventRecorder struct {
+	events []notifications.Event
+}
+
+func (er *eventRecorder) Write(event events.Event) error {
+	er.events = append(er.events, event.(notifications.Event))
+	return nil
+}
+
+func (er *eventRecorder) Close() error {
+	return nil
+}
+
+func TestProxyEvents(t *testing.T) {
+	ctx := context.Background()
+	env := newManifestStoreTestEnv(t, "foo/bar", "latest")
+
+	recorder := &eventRecorder{}
+	var opts cacheOptions
+	WithEventSink(recorder, notifications.SourceRecord{Addr: "registry:5000"}, true)(&opts)
+	env.manifests.notifier = opts.notifier
+
+	manifest, err := env.manifests.Get(ctx, env.manifestDigest, distribution.WithTag("latest"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Served from the cache, without an event
+	if _, err := env.manifests.Get(ctx, env.manifestDigest); err != nil {
+		t.Fatal(err)
+	}
+
+	missing := digest.FromString("missing")
+	if _, err := env.manifests.Get(ctx, missing); err == nil {
+		t.Fatal("expected an error fetching a missing manifest")
+	}
+
+	ref, err := reference.WithDigest(env.manifests.repositoryName, env.manifestDigest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts.notifier.evicted(ctx, ref)
+
+	if len(recorder.events) != 3 {
+		t.Fatalf("expected 3 events, got %d: %+v", len(recorder.events), recorder.events)
+	}
+	for i, expected := range []struct {
+		action string
+		dgst   digest.Digest
+	}{
+		{notifications.EventActionCache, env.manifestDigest},
+		{notifications.EventActionFetchFailed, missing},
+		{notifications.EventActionEvict, env.manifestDigest},
+	} {
+		event := recorder.events[i]
+		if event.Action != expected.action || event.Target.Digest != expected.dgst {
+			t.Errorf("expected %s event for %s, got %s event for %s", expected.action, expected.dgst, event.Action, event.Target.Digest)
+		}
+		if event.Source.Provider != "proxy" || event.Source.Addr != "registry:5000" {
+			t.Errorf("unexpected event source: %+v", event.Source)
+		}
+		if event.Target.Repository != "foo/bar" {
+			t.Errorf("unexpected event repository: %s", event.Target.Repository)
+		}
+	}
+
+	cached := recorder.events[0]
+	if cached.Target.Tag != "latest" {
+		t.Errorf("expected tag latest, got %q", cached.Target.Tag)
+	}
+	if len(cached.Target.References) != len(manifest.References()) {
+		t.Errorf("expected %d references, got %d", len(manifest.References()), len(cached.Target.References))
+	}
+}
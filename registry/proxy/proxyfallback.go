@@ -0,0 +1,125 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/docker/go-metrics"
+)
+
+// credentialRetryInterval is how long the requests to an upstream are made
+// anonymously after its credentials were rejected, before the credentials
+// are tried again.
+const credentialRetryInterval = 5 * time.Minute
+
+var (
+	// credentialsRejectedGauge is 1 while the credentials of the upstream are rejected
+	credentialsRejectedGauge = prometheus.ProxyNamespace.NewLabeledGauge("credentials_rejected", "Whether the configured credentials of the upstream registry are rejected", metrics.Total, "remote")
+	// anonymousFallbackCounter counts the requests made anonymously in place of rejected credentials
+	anonymousFallbackCounter = prometheus.ProxyNamespace.NewLabeledCounter("anonymous_fallbacks", "The number of upstream requests made anonymously because the configured credentials were rejected", "remote")
+)
+
+// anonymousFallback tracks the upstreams whose configured credentials were
+// rejected, and which are accessed anonymously in the meantime.
+type anonymousFallback struct {
+	mu       sync.Mutex
+	rejected map[string]time.Time // upstream host to the time of the rejection
+}
+
+func newAnonymousFallback() *anonymousFallback {
+	return &anonymousFallback{rejected: make(map[string]time.Time)}
+}
+
+// useCredentials reports whether the credentials of the upstream are to be
+// tried, which they are again once credentialRetryInterval has passed since
+// they were rejected.
+func (af *anonymousFallback) useCredentials(host string) bool {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	rejectedAt, ok := af.rejected[host]
+	return !ok || time.Since(rejectedAt) >= credentialRetryInterval
+}
+
+func (af *anonymousFallback) reject(ctx context.Context, host string) {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	if _, ok := af.rejected[host]; !ok {
+		dcontext.GetLogger(ctx).Warnf("Credentials for %s were rejected, pulling anonymously until they are rotated", host)
+	}
+	af.rejected[host] = time.Now()
+	credentialsRejectedGauge.WithValues(host).Set(1)
+}
+
+func (af *anonymousFallback) accept(ctx context.Context, host string) {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	if _, ok := af.rejected[host]; !ok {
+		return
+	}
+	dcontext.GetLogger(ctx).Infof("Credentials for %s were accepted again", host)
+	delete(af.rejected, host)
+	credentialsRejectedGauge.WithValues(host).Set(0)
+}
+
+// retry makes the credentials of the upstreams whose credentials were
+// rejected be tried again by the next requests, such as once they were
+// rotated.
+func (af *anonymousFallback) retry() {
+	af.mu.Lock()
+	defer af.mu.Unlock()
+
+	for host := range af.rejected {
+		af.rejected[host] = time.Time{}
+	}
+}
+
+// fallbackTransport makes requests to an upstream with its configured
+// credentials, and anonymously when the credentials are rejected by the
+// token server.
+type fallbackTransport struct {
+	host          string
+	fallback      *anonymousFallback
+	authenticated http.RoundTripper
+	anonymous     http.RoundTripper
+}
+
+func (t *fallbackTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.fallback.useCredentials(t.host) {
+		resp, err := t.authenticated.RoundTrip(req)
+		if !credentialsRejected(err) {
+			if err == nil {
+				t.fallback.accept(req.Context(), t.host)
+			}
+			return resp, err
+		}
+		t.fallback.reject(req.Context(), t.host)
+	}
+
+	anonymousFallbackCounter.WithValues(t.host).Inc(1)
+	return t.anonymous.RoundTrip(req)
+}
+
+// credentialsRejected reports whether the error is the rejection of the
+// credentials by the token server, which fails the request before it is
+// sent.
+func credentialsRejected(err error) bool {
+	switch err := err.(type) {
+	case errcode.Error:
+		return err.Code == errcode.ErrorCodeUnauthorized
+	case errcode.Errors:
+		for _, err := range err {
+			if credentialsRejected(err) {
+				return true
+			}
+		}
+	}
+	return false
+}
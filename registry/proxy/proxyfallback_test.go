@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/client/auth"
+	"github.com/distribution/distribution/v3/registry/client/auth/challenge"
+	"github.com/distribution/distribution/v3/registry/client/transport"
+)
+
+func TestFallbackTransport(t *testing.T) {
+	var credentialed, anonymous int
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/token":
+			// The configured credentials have expired
+			if _, _, ok := r.BasicAuth(); ok {
+				credentialed++
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			anonymous++
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(`{"token":"anonymous"}`))
+		default:
+			if r.Header.Get("Authorization") != "Bearer anonymous" {
+				w.Header().Set("WWW-Authenticate", `Bearer realm="`+upstream.URL+`/token",service="registry"`)
+				w.WriteHeader(http.StatusUnauthorized)
+			}
+		}
+	}))
+	defer upstream.Close()
+
+	u, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cm := challenge.NewSimpleManager()
+	if err := ping(cm, upstream.URL+"/v2/", challengeHeader, http.DefaultTransport); err != nil {
+		t.Fatal(err)
+	}
+	creds := credentials{creds: map[string]userpass{
+		upstream.URL + "/token": {username: "user", password: "expired"},
+	}}
+	authorized := func(creds auth.CredentialStore) http.RoundTripper {
+		return transport.NewTransport(http.DefaultTransport, auth.NewAuthorizer(cm,
+			auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{Credentials: creds})))
+	}
+
+	fallback := newAnonymousFallback()
+	tr := &fallbackTransport{
+		host:          u.Host,
+		fallback:      fallback,
+		authenticated: authorized(creds),
+		anonymous:     authorized(nil),
+	}
+	get := func() {
+		t.Helper()
+		req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, upstream.URL+"/v2/library/redis/manifests/latest", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := tr.RoundTrip(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected status %d", resp.StatusCode)
+		}
+	}
+
+	get()
+	if credentialed != 1 || anonymous != 1 {
+		t.Fatalf("expected the credentials to be tried once before pulling anonymously, got %d and %d token requests", credentialed, anonymous)
+	}
+
+	// The credentials are not tried again until the retry interval passed,
+	// and the anonymous token is reused
+	get()
+	if credentialed != 1 {
+		t.Fatalf("expected an anonymous pull, got %d credentialed token requests", credentialed)
+	}
+
+	fallback.rejected[u.Host] = time.Now().Add(-credentialRetryInterval)
+	get()
+	if credentialed != 2 {
+		t.Fatalf("expected the credentials to be tried again, got %d credentialed token requests", credentialed)
+	}
+}
@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// fetchStatePath is where the uploads of fetches interrupted by a shutdown
+// are recorded, next to the scheduler state.
+const fetchStatePath = "/proxy-fetch-state.json"
+
+// Drainer is implemented by registries with background work which is to be
+// finished before the registry stops.
+type Drainer interface {
+	// Drain waits for background work to finish until ctx is done, after
+	// which the remaining work is abandoned or saved for a later start.
+	Drain(ctx context.Context) error
+}
+
+// fetchCheckpoint records the partial upload of a blob whose fetch was
+// interrupted, so that the next fetch of the blob can resume it.
+type fetchCheckpoint struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	UploadID   string        `json:"upload_id"`
+	Offset     int64         `json:"offset"`
+	CreatedAt  time.Time     `json:"created_at"`
+}
+
+// fetchTracker keeps track of the fetches which cache blobs in the
+// background. When the registry shuts down, it waits for them to finish and
+// checkpoints the uploads of those still running when the drain timeout
+// expires. A nil tracker runs fetches without tracking them.
+type fetchTracker struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	driver driver.StorageDriver
+
+	wg          sync.WaitGroup
+	mu          sync.Mutex
+	draining    bool
+	checkpoints map[string]fetchCheckpoint // keyed by repository@digest
+}
+
+// newFetchTracker loads the checkpoints of the previous run and removes the
+// uploads left behind by it, except those which can still be resumed.
+func newFetchTracker(ctx context.Context, d driver.StorageDriver) (*fetchTracker, error) {
+	startedAt := time.Now()
+	checkpoints, err := readFetchState(ctx, d)
+	if err != nil {
+		return nil, err
+	}
+
+	keep := make(map[string]struct{}, len(checkpoints))
+	for key, checkpoint := range checkpoints {
+		if startedAt.Sub(checkpoint.CreatedAt) > repositoryTTL {
+			delete(checkpoints, key)
+			continue
+		}
+		keep[checkpoint.UploadID] = struct{}{}
+	}
+
+	go func() {
+		// A pull through cache does not accept pushes, so every upload
+		// started before now belongs to an abandoned fetch. Upload start
+		// times are stored with a precision of a second.
+		_, errs := storage.PurgeUploadsExcept(ctx, d, startedAt.Truncate(time.Second), keep, true)
+		for _, err := range errs {
+			dcontext.GetLogger(ctx).Errorf("Error removing abandoned upload: %s", err)
+		}
+	}()
+
+	fetchCtx, cancel := context.WithCancel(context.Background())
+	return &fetchTracker{
+		ctx:         fetchCtx,
+		cancel:      cancel,
+		driver:      d,
+		checkpoints: checkpoints,
+	}, nil
+}
+
+func readFetchState(ctx context.Context, d driver.StorageDriver) (map[string]fetchCheckpoint, error) {
+	checkpoints := make(map[string]fetchCheckpoint)
+	content, err := d.GetContent(ctx, fetchStatePath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return checkpoints, nil
+		}
+		return nil, err
+	}
+
+	if err := json.Unmarshal(content, &checkpoints); err != nil {
+		return nil, err
+	}
+	return checkpoints, nil
+}
+
+func checkpointKey(repository string, dgst digest.Digest) string {
+	return repository + "@" + dgst.String()
+}
+
+// start registers a fetch and returns the context it runs with, which is
+// canceled when the drain timeout expires. It reports false once the
+// registry is draining, in which case no new fetch is to be started.
+func (ft *fetchTracker) start() (context.Context, bool) {
+	if ft == nil {
+		return context.Background(), true
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	if ft.draining {
+		return nil, false
+	}
+	ft.wg.Add(1)
+	return ft.ctx, true
+}
+
+// done unregisters a fetch registered with start.
+func (ft *fetchTracker) done() {
+	if ft == nil {
+		return
+	}
+	ft.wg.Done()
+}
+
+// interrupted reports whether fetches were canceled by the drain timeout.
+func (ft *fetchTracker) interrupted() bool {
+	return ft != nil && ft.ctx.Err() != nil
+}
+
+// take removes and returns the checkpoint of an interrupted fetch of the
+// blob, if there is one.
+func (ft *fetchTracker) take(repository string, dgst digest.Digest) (fetchCheckpoint, bool) {
+	if ft == nil {
+		return fetchCheckpoint{}, false
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	key := checkpointKey(repository, dgst)
+	checkpoint, ok := ft.checkpoints[key]
+	delete(ft.checkpoints, key)
+	return checkpoint, ok
+}
+
+// checkpoint records the upload of an interrupted fetch.
+func (ft *fetchTracker) checkpoint(checkpoint fetchCheckpoint) {
+	if ft == nil {
+		return
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	checkpoint.CreatedAt = time.Now()
+	ft.checkpoints[checkpointKey(checkpoint.Repository, checkpoint.Digest)] = checkpoint
+}
+
+// drain stops new fetches from being started and waits for the running
+// ones until ctx is done. Fetches still running then are canceled, and the
+// checkpoints of their uploads are saved.
+func (ft *fetchTracker) drain(ctx context.Context) error {
+	ft.mu.Lock()
+	ft.draining = true
+	ft.mu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		ft.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		dcontext.GetLogger(ctx).Warn("Drain timeout expired, checkpointing in-flight upstream fetches")
+		ft.cancel()
+		<-finished
+	}
+
+	ft.mu.Lock()
+	defer ft.mu.Unlock()
+	content, err := json.Marshal(ft.checkpoints)
+	if err != nil {
+		return err
+	}
+	return ft.driver.PutContent(context.Background(), fetchStatePath, content)
+}
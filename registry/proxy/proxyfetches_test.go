@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// stallingBlobService serves the first stallAt bytes of a blob, after which
+// reads block until the fetch is canceled.
+type stallingBlobService struct {
+	distribution.BlobService
+	stallAt int64
+	stalled chan struct{}
+	read    *int64
+}
+
+func (sbs stallingBlobService) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	rsc, err := sbs.BlobService.Open(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	return &stallingReader{ReadSeekCloser: rsc, ctx: ctx, service: sbs}, nil
+}
+
+type stallingReader struct {
+	io.ReadSeekCloser
+	ctx     context.Context
+	service stallingBlobService
+	offset  int64
+}
+
+func (sr *stallingReader) Seek(offset int64, whence int) (int64, error) {
+	n, err := sr.ReadSeekCloser.Seek(offset, whence)
+	sr.offset = n
+	return n, err
+}
+
+func (sr *stallingReader) Read(p []byte) (int, error) {
+	if sr.service.stallAt > 0 && sr.offset >= sr.service.stallAt {
+		close(sr.service.stalled)
+		<-sr.ctx.Done()
+		return 0, sr.ctx.Err()
+	}
+	if sr.service.stallAt > 0 && sr.offset+int64(len(p)) > sr.service.stallAt {
+		p = p[:sr.service.stallAt-sr.offset]
+	}
+	n, err := sr.ReadSeekCloser.Read(p)
+	sr.offset += int64(n)
+	atomic.AddInt64(sr.service.read, int64(n))
+	return n, err
+}
+
+func TestProxyFetchCheckpointResume(t *testing.T) {
+	ctx := context.Background()
+	name, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteRegistry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteRepo, err := remoteRegistry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := make([]byte, 1<<20)
+	rand.Read(content)
+	desc, err := remoteRepo.Blobs(ctx).Put(ctx, "application/octet-stream", content)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localDriver := inmemory.New()
+	localRegistry, err := storage.NewRegistry(ctx, localDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localRepo, err := localRegistry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newStore := func(fetches *fetchTracker, remote stallingBlobService) *proxyBlobStore {
+		return &proxyBlobStore{
+			localStore:     localRepo.Blobs(ctx),
+			remoteStore:    remote,
+			scheduler:      scheduler.New(ctx, inmemory.New(), "/scheduler-state.json"),
+			repositoryName: name,
+			authChallenger: &mockChallenger{},
+			fetches:        fetches,
+		}
+	}
+
+	// Interrupt a fetch half way through the blob
+	fetches, err := newFetchTracker(ctx, localDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var read int64
+	stalled := make(chan struct{})
+	pbs := newStore(fetches, stallingBlobService{
+		BlobService: remoteRepo.Blobs(ctx),
+		stallAt:     desc.Size / 2,
+		stalled:     stalled,
+		read:        &read,
+	})
+	if _, ok := pbs.cacheInBackground(desc.Digest); !ok {
+		t.Fatal("expected the fetch to start")
+	}
+	<-stalled
+
+	drainCtx, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if err := fetches.drain(drainCtx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := fetches.start(); ok {
+		t.Fatal("expected no fetch to start while draining")
+	}
+
+	checkpoints, err := readFetchState(ctx, localDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, ok := checkpoints[checkpointKey(name.String(), desc.Digest)]
+	if !ok {
+		t.Fatalf("expected a checkpoint of the fetch, got %v", checkpoints)
+	}
+	if checkpoint.Offset != desc.Size/2 {
+		t.Fatalf("expected checkpoint at offset %d, got %d", desc.Size/2, checkpoint.Offset)
+	}
+
+	// Resume the fetch after a restart
+	fetches, err = newFetchTracker(ctx, localDriver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read = 0
+	pbs = newStore(fetches, stallingBlobService{
+		BlobService: remoteRepo.Blobs(ctx),
+		read:        &read,
+	})
+	if _, err := pbs.storeLocal(ctx, desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+	if read != desc.Size-checkpoint.Offset {
+		t.Fatalf("expected %d bytes fetched on resume, got %d", desc.Size-checkpoint.Offset, read)
+	}
+
+	cached, err := localRepo.Blobs(ctx).Get(ctx, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(cached, content) {
+		t.Fatal("resumed blob does not match the upstream")
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3/health"
+)
+
+// UpstreamChecker is implemented by registries acting as a pull through
+// cache.
+type UpstreamChecker interface {
+	// UpstreamChecks returns a check of the reachability of each upstream,
+	// keyed by namespace.
+	UpstreamChecks(timeout time.Duration) map[string]health.Checker
+}
+
+// UpstreamChecks returns checks pinging the API version check of the
+// upstreams, with their transports.
+func (pr *proxyingRegistry) UpstreamChecks(timeout time.Duration) map[string]health.Checker {
+	namespaces := pr.ProxyNamespaces()
+	checks := make(map[string]health.Checker, len(namespaces))
+	for _, ns := range namespaces {
+		checks[ns.Name] = upstreamCheck(ns.URL, pr.transports.forURL(ns.URL), timeout)
+	}
+	return checks
+}
+
+// upstreamCheck pings the /v2/ endpoint of the upstream at base. The
+// upstream is reachable when it answers, anonymously, with 200 or with the
+// 401 of a registry requiring authentication.
+func upstreamCheck(base string, transport http.RoundTripper, timeout time.Duration) health.Checker {
+	client := &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+	return health.CheckFunc(func() error {
+		u, err := url.Parse(base)
+		if err != nil {
+			return err
+		}
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/v2/"
+		resp, err := client.Get(u.String())
+		if err != nil {
+			return fmt.Errorf("upstream unreachable: %v", err)
+		}
+		resp.Body.Close()
+
+		switch resp.StatusCode {
+		case http.StatusOK, http.StatusUnauthorized:
+			return nil
+		}
+		return fmt.Errorf("upstream returned unexpected status: %s", resp.Status)
+	})
+}
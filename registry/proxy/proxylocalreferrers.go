@@ -0,0 +1,202 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// AnnotationLocalReferrer is the annotation of the descriptors of the
+// referrers pushed to the cache in the referrers listings, distinguishing
+// them from the referrers of the upstream.
+const AnnotationLocalReferrer = "io.distribution.proxy.local-referrer"
+
+// localReferrersPath is the root of the records of the referrers pushed to
+// the cache.
+const localReferrersPath = "/proxy-local-referrers"
+
+// localReferrers accepts pushes of referrer artifacts, such as SBOMs, to the
+// proxied repositories. The referrers are stored in the cache and never
+// expire. Each is recorded along with the blobs it references, which do not
+// expire either. A nil localReferrers accepts none.
+type localReferrers struct {
+	driver        driver.StorageDriver
+	repositories  []string // path.Match patterns, all repositories if empty
+	artifactTypes []string // all artifact types if empty
+}
+
+// newLocalReferrers returns the local referrers configured, nil if pushes
+// are not accepted.
+func newLocalReferrers(config configuration.ProxyLocalReferrers, d driver.StorageDriver) (*localReferrers, error) {
+	if !config.Enabled {
+		if len(config.Repositories) > 0 || len(config.ArtifactTypes) > 0 {
+			return nil, fmt.Errorf("localreferrers: enabled is required")
+		}
+		return nil, nil
+	}
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("localreferrers: invalid repository %q: %v", pattern, err)
+		}
+	}
+	return &localReferrers{
+		driver:        d,
+		repositories:  config.Repositories,
+		artifactTypes: config.ArtifactTypes,
+	}, nil
+}
+
+// The records of the repository live under a _referrers directory, as
+// repository name components cannot start with an underscore.
+func localReferrerRecordsPath(repository string) string {
+	return path.Join(localReferrersPath, repository, "_referrers")
+}
+
+func localReferrerRecordPath(repository string, dgst digest.Digest) string {
+	return path.Join(localReferrerRecordsPath(repository), dgst.Algorithm().String(), dgst.Hex())
+}
+
+// applies reports whether the repository accepts referrers.
+func (lr *localReferrers) applies(repository string) bool {
+	if lr == nil {
+		return false
+	}
+	return len(lr.repositories) == 0 || matchesAnyPattern(lr.repositories, repository)
+}
+
+// check returns an error if the manifest cannot be pushed as a referrer:
+// only OCI image manifests with a subject and an accepted artifact type are.
+func (lr *localReferrers) check(manifest distribution.Manifest) error {
+	m, ok := manifest.(*ocischema.DeserializedManifest)
+	if !ok || m.Subject == nil {
+		return errcode.ErrorCodeUnsupported.WithMessage("only referrers, OCI image manifests with a subject, can be pushed to the cache")
+	}
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+	if len(lr.artifactTypes) > 0 && !contains(lr.artifactTypes, artifactType) {
+		return errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("referrers of artifact type %q cannot be pushed to the cache", artifactType))
+	}
+	return nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// record records the referrer pushed, along with the blobs it references.
+func (lr *localReferrers) record(ctx context.Context, repository string, dgst digest.Digest, manifest distribution.Manifest) error {
+	var blobs []digest.Digest
+	for _, desc := range manifest.References() {
+		blobs = append(blobs, desc.Digest)
+	}
+	content, err := json.Marshal(blobs)
+	if err != nil {
+		return err
+	}
+	return lr.driver.PutContent(ctx, localReferrerRecordPath(repository, dgst), content)
+}
+
+// remove removes the record of the referrer.
+func (lr *localReferrers) remove(ctx context.Context, repository string, dgst digest.Digest) error {
+	err := lr.driver.Delete(ctx, localReferrerRecordPath(repository, dgst))
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
+
+// isLocal reports whether the manifest was pushed to the cache.
+func (lr *localReferrers) isLocal(ctx context.Context, repository string, dgst digest.Digest) (bool, error) {
+	if lr == nil {
+		return false, nil
+	}
+	_, err := lr.driver.Stat(ctx, localReferrerRecordPath(repository, dgst))
+	switch err.(type) {
+	case nil:
+		return true, nil
+	case driver.PathNotFoundError:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// holds reports whether the content of the repository is a referrer pushed
+// to the cache, or is referenced by one, in which case it does not expire.
+func (lr *localReferrers) holds(ctx context.Context, repository string, dgst digest.Digest) (bool, error) {
+	if lr == nil {
+		return false, nil
+	}
+	if local, err := lr.isLocal(ctx, repository, dgst); err != nil || local {
+		return local, err
+	}
+
+	algorithms, err := lr.driver.List(ctx, localReferrerRecordsPath(repository))
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	for _, algorithm := range algorithms {
+		records, err := lr.driver.List(ctx, algorithm)
+		if err != nil {
+			return false, err
+		}
+		for _, record := range records {
+			content, err := lr.driver.GetContent(ctx, record)
+			if err != nil {
+				return false, err
+			}
+			var blobs []digest.Digest
+			if err := json.Unmarshal(content, &blobs); err != nil {
+				return false, fmt.Errorf("invalid record of local referrer %s: %v", record, err)
+			}
+			for _, blob := range blobs {
+				if blob == dgst {
+					return true, nil
+				}
+			}
+		}
+	}
+	return false, nil
+}
+
+// annotate marks the local referrers in the referrers listed by the local
+// storage, returning them, and the referrers listed which were pulled from
+// the upstream.
+func (lr *localReferrers) annotate(ctx context.Context, repository string, referrers []distribution.Descriptor) (local, cached []distribution.Descriptor, err error) {
+	for _, desc := range referrers {
+		isLocal, err := lr.isLocal(ctx, repository, desc.Digest)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !isLocal {
+			cached = append(cached, desc)
+			continue
+		}
+		annotations := make(map[string]string, len(desc.Annotations)+1)
+		for k, v := range desc.Annotations {
+			annotations[k] = v
+		}
+		annotations[AnnotationLocalReferrer] = "true"
+		desc.Annotations = annotations
+		local = append(local, desc)
+	}
+	return local, cached, nil
+}
@@ -0,0 +1,191 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const testSBOMType = "application/spdx+json"
+
+// buildReferrer uploads the blobs of a referrer of the subject through the
+// blob store and returns its manifest.
+func buildReferrer(ctx context.Context, t *testing.T, blobs distribution.BlobService, subject digest.Digest, artifactType string) distribution.Manifest {
+	layer, err := blobs.Put(ctx, artifactType, []byte(`{"spdxVersion":"SPDX-2.3"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	layer.MediaType = artifactType
+
+	builder := ocischema.NewManifestBuilder(blobs, []byte("{}"), nil).(*ocischema.Builder)
+	if err := builder.SetConfigMediaType("application/vnd.oci.empty.v1+json"); err != nil {
+		t.Fatal(err)
+	}
+	builder.SetArtifactType(artifactType)
+	builder.SetSubject(distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: subject, Size: 100})
+	if err := builder.AppendReference(layer); err != nil {
+		t.Fatal(err)
+	}
+	m, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestLocalReferrers(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, d, storage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := reference.WithName("docker.io/library/app")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localManifests, err := repo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	referrerManifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	locals, err := newLocalReferrers(configuration.ProxyLocalReferrers{
+		Enabled:       true,
+		Repositories:  []string{"docker.io/library/*"},
+		ArtifactTypes: []string{testSBOMType},
+	}, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamRepo, err := upstream.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blobs := &proxyBlobStore{
+		localStore:     repo.Blobs(ctx),
+		remoteStore:    upstreamRepo.Blobs(ctx),
+		repositoryName: name,
+		authChallenger: &mockChallenger{},
+		locals:         locals,
+	}
+	manifests := proxyManifestStore{
+		ctx:               ctx,
+		localManifests:    localManifests,
+		referrerManifests: referrerManifests,
+		repositoryName:    name,
+		authChallenger:    &mockChallenger{},
+		locals:            locals,
+	}
+
+	subject := digest.FromString("subject")
+	sbom := buildReferrer(ctx, t, blobs, subject, testSBOMType)
+	if _, err := manifests.Put(ctx, sbom, distribution.WithTag("sbom")); err == nil {
+		t.Fatal("expected referrers pushed by tag to be rejected")
+	}
+	other := buildReferrer(ctx, t, blobs, subject, "application/vnd.example.other")
+	if _, err := manifests.Put(ctx, other); err == nil {
+		t.Fatal("expected the artifact type to be rejected")
+	} else if e, ok := err.(errcode.Error); !ok || e.Code != errcode.ErrorCodeDenied {
+		t.Fatalf("unexpected error %v", err)
+	}
+
+	dgst, err := manifests.Put(ctx, sbom)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if local, err := locals.isLocal(ctx, name.Name(), dgst); err != nil || !local {
+		t.Fatalf("expected %s to be recorded as a local referrer: %v", dgst, err)
+	}
+	for _, desc := range sbom.References() {
+		if held, err := locals.holds(ctx, name.Name(), desc.Digest); err != nil || !held {
+			t.Fatalf("expected blob %s to be held by the referrer: %v", desc.Digest, err)
+		}
+	}
+
+	// the referrers pushed are listed with those of the upstream, annotated
+	signature := distribution.Descriptor{Digest: digest.FromString("signature"), ArtifactType: "application/vnd.example.signature"}
+	remote := &mockReferrerService{referrers: map[digest.Digest][]distribution.Descriptor{subject: {signature}}}
+	prs := proxyReferrerService{
+		localReferrers:  repo.(distribution.ReferrerService),
+		remoteReferrers: remote,
+		manifests:       &countingManifests{gets: make(map[digest.Digest]int)},
+		authChallenger:  &mockChallenger{},
+		locals:          locals,
+		repositoryName:  name,
+	}
+	referrers, err := prs.Referrers(ctx, subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 2 || referrers[0].Digest != signature.Digest || referrers[1].Digest != dgst {
+		t.Fatalf("unexpected referrers %v", referrers)
+	}
+	if referrers[1].Annotations[AnnotationLocalReferrer] != "true" || referrers[0].Annotations[AnnotationLocalReferrer] != "" {
+		t.Fatalf("unexpected annotations of referrers %v", referrers)
+	}
+	if referrers, err := prs.Referrers(ctx, subject, "application/vnd.example.signature"); err != nil || len(referrers) != 1 {
+		t.Fatalf("unexpected filtered referrers %v: %v", referrers, err)
+	}
+
+	// the referrers pushed, and their blobs, never expire
+	entries, err := rebuildSchedulerState(registry, nil, locals)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 0 {
+		t.Fatalf("unexpected scheduler entries %v", entries)
+	}
+
+	if err := manifests.Delete(ctx, subject); err != distribution.ErrUnsupported {
+		t.Fatalf("expected the deletion of upstream content to be unsupported, got %v", err)
+	}
+	if err := manifests.Delete(ctx, dgst); err != nil {
+		t.Fatal(err)
+	}
+	if local, err := locals.isLocal(ctx, name.Name(), dgst); err != nil || local {
+		t.Fatalf("expected the record of %s to be removed: %v", dgst, err)
+	}
+
+	// other repositories do not accept pushes
+	otherName, _ := reference.WithName("quay.io/app")
+	blobs.repositoryName = otherName
+	if _, err := blobs.Put(ctx, testSBOMType, []byte("{}")); err != distribution.ErrUnsupported {
+		t.Fatalf("expected pushes to be unsupported, got %v", err)
+	}
+	manifests.repositoryName = otherName
+	if _, err := manifests.Put(ctx, sbom); err != distribution.ErrUnsupported {
+		t.Fatalf("expected pushes to be unsupported, got %v", err)
+	}
+}
+
+func TestNewLocalReferrers(t *testing.T) {
+	for _, config := range []configuration.ProxyLocalReferrers{
+		{ArtifactTypes: []string{testSBOMType}},
+		{Enabled: true, Repositories: []string{"["}},
+	} {
+		if _, err := newLocalReferrers(config, nil); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+	if locals, err := newLocalReferrers(configuration.ProxyLocalReferrers{}, nil); err != nil || locals != nil {
+		t.Fatalf("unexpected local referrers %v: %v", locals, err)
+	}
+}
@@ -6,8 +6,11 @@ import (
 
 	"github.com/distribution/distribution/v3"
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
 	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/tracing"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -15,12 +18,26 @@ import (
 const repositoryTTL = 24 * 7 * time.Hour
 
 type proxyManifestStore struct {
-	ctx             context.Context
-	localManifests  distribution.ManifestService
-	remoteManifests distribution.ManifestService
-	repositoryName  reference.Named
-	scheduler       *scheduler.TTLExpirationScheduler
-	authChallenger  authChallenger
+	ctx               context.Context
+	localManifests    distribution.ManifestService
+	remoteManifests   distribution.ManifestService
+	repositoryName    reference.Named
+	scheduler         *scheduler.TTLExpirationScheduler
+	authChallenger    authChallenger
+	platforms         []platform         // platforms whose index children are prefetched
+	platformPins      *platformPins      // keeps the children prefetched while their index is cached
+	fetches           *fetchTracker      // waits for the children prefetched on shutdown
+	verifier          *signatureVerifier // nil unless a trust policy applies
+	namespace         string             // upstream host, for statistics
+	stats             *statsCollector
+	policies          *artifactPolicies
+	conversion        *imageConversion // nil unless images are converted
+	notifier          *eventNotifier
+	prefetcher        *layerPrefetcher             // nil unless layers are prefetched
+	blobs             *proxyBlobStore              // caches the prefetched layers
+	locals            *localReferrers              // nil unless referrers are pushed to the cache
+	objectTags        *objectTags                  // nil unless the objects cached are tagged
+	referrerManifests distribution.ManifestService // verifies the blobs of the referrers pushed
 }
 
 var _ distribution.ManifestService = &proxyManifestStore{}
@@ -49,23 +66,54 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 			return nil, err
 		}
 
-		manifest, err = pms.remoteManifests.Get(ctx, dgst, options...)
+		fetchCtx, span := tracing.Start(ctx, "proxy.FetchManifest",
+			tracing.String("registry.namespace", pms.namespace),
+			tracing.String("registry.repository", pms.repositoryName.Name()),
+			tracing.String("registry.digest", dgst.String()))
+		manifest, err = pms.remoteManifests.Get(fetchCtx, dgst, options...)
+		span.End(err)
 		if err != nil {
+			pms.notifier.fetchFailed(ctx, pms.repositoryName, dgst)
 			return nil, err
 		}
 		fromRemote = true
+		pms.stats.miss(ctx, manifestEntry, pms.namespace)
+	} else {
+		pms.stats.hit(ctx, manifestEntry, pms.namespace, pms.repositoryName.Name()+"@"+dgst.String())
+	}
+
+	if pms.verifier != nil {
+		if fromRemote {
+			// Only verified content may enter the cache
+			if err := pms.verifier.verify(ctx, dgst, manifest); err != nil {
+				return nil, err
+			}
+		} else {
+			pms.verifier.trustChildren(manifest)
+		}
 	}
 
 	_, payload, err := manifest.Payload()
 	if err != nil {
 		return nil, err
 	}
+	// the policy of the content it references is learnt from the manifest,
+	// including when it is served from the cache after a restart
+	pms.policies.classify(dgst, manifest)
+	ml, isIndex := manifest.(*manifestlist.DeserializedManifestList)
+	if isIndex {
+		for _, child := range ml.Manifests {
+			if matchesAnyPlatform(pms.platforms, child.Platform) {
+				pms.platformPins.pin(pms.repositoryName.Name(), dgst, child.Digest)
+			}
+		}
+	}
 
 	proxyMetrics.ManifestPush(uint64(len(payload)))
 	if fromRemote {
 		proxyMetrics.ManifestPull(uint64(len(payload)))
 
-		_, err = pms.localManifests.Put(ctx, manifest)
+		_, err = pms.localManifests.Put(pms.objectTags.with(ctx, pms.namespace), manifest)
 		if err != nil {
 			return nil, err
 		}
@@ -77,20 +125,115 @@ func (pms proxyManifestStore) Get(ctx context.Context, dgst digest.Digest, optio
 			return nil, err
 		}
 
-		pms.scheduler.AddManifest(repoBlob, repositoryTTL)
+		pms.scheduler.AddManifest(repoBlob, pms.policies.ttl(dgst))
+		pms.stats.cached(manifestEntry, pms.namespace, repoBlob.String(), int64(len(payload)))
+		pms.policies.cached(manifestEntry, repoBlob, int64(len(payload)))
+		pms.notifier.manifestCached(ctx, pms.repositoryName, dgst, manifest, tagOption(options))
 		// Ensure the manifest blob is cleaned up
 		// pms.scheduler.AddBlob(blobRef, repositoryTTL)
 
+		if isIndex && len(pms.platforms) > 0 {
+			pms.prefetchPlatforms(ctx, ml)
+		}
+		pms.prefetcher.prefetch(ctx, pms.blobs, manifest)
+	}
+
+	if pms.conversion != nil {
+		if tag := tagOption(options); tag != "" {
+			pms.conversion.start(tag, dgst, manifest)
+		}
 	}
 
 	return manifest, err
 }
 
+// tagOption returns the tag a manifest is fetched by, if any.
+func tagOption(options []distribution.ManifestServiceOption) string {
+	for _, option := range options {
+		if opt, ok := option.(distribution.WithTagOption); ok {
+			return opt.Tag
+		}
+	}
+	return ""
+}
+
+// prefetchPlatforms pulls the children of an image index that match the
+// configured platforms into the cache in the background, so they are
+// available even when only the index has been requested. Children for other
+// platforms are left to be fetched on demand. The prefetch is waited for
+// when the registry drains, and not started once it does.
+func (pms proxyManifestStore) prefetchPlatforms(ctx context.Context, ml *manifestlist.DeserializedManifestList) {
+	fetchCtx, ok := pms.fetches.start()
+	if !ok {
+		return
+	}
+	// the fetches outlive the request for the index
+	ctx = withFetchReason(dcontext.WithLogger(fetchCtx, dcontext.GetLogger(ctx)), fetchReasonPrefetch)
+	go func() {
+		defer pms.fetches.done()
+		for _, child := range ml.Manifests {
+			if !matchesAnyPlatform(pms.platforms, child.Platform) {
+				continue
+			}
+			if ctx.Err() != nil {
+				return
+			}
+
+			if _, err := pms.Get(ctx, child.Digest); err != nil {
+				dcontext.GetLogger(ctx).Warnf("Error prefetching manifest %s for platform %s/%s: %s", child.Digest, child.Platform.OS, child.Platform.Architecture, err)
+			}
+		}
+	}()
+}
+
+// Put stores a referrer pushed to the cache, if the repository accepts
+// them. Referrers are pushed by digest, and are never written to the
+// upstream.
 func (pms proxyManifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	var d digest.Digest
-	return d, distribution.ErrUnsupported
+	repository := pms.repositoryName.Name()
+	if !pms.locals.applies(repository) {
+		return d, distribution.ErrUnsupported
+	}
+	if tagOption(options) != "" {
+		return d, errcode.ErrorCodeUnsupported.WithMessage("referrers are pushed to the cache by digest, not by tag")
+	}
+	if err := pms.locals.check(manifest); err != nil {
+		return d, err
+	}
+
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return d, err
+	}
+	// The referrer is recorded first, so that it is never taken for content
+	// of the upstream, which expires
+	d = digest.FromBytes(payload)
+	if err := pms.locals.record(ctx, repository, d, manifest); err != nil {
+		return "", err
+	}
+	if _, err := pms.referrerManifests.Put(ctx, manifest); err != nil {
+		if err := pms.locals.remove(ctx, repository, d); err != nil {
+			dcontext.GetLogger(ctx).Errorf("Error removing the record of referrer %s: %s", d, err)
+		}
+		return "", err
+	}
+	return d, nil
 }
 
+// Delete deletes a referrer pushed to the cache. The content of the upstream
+// cannot be deleted.
 func (pms proxyManifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
-	return distribution.ErrUnsupported
+	repository := pms.repositoryName.Name()
+	local, err := pms.locals.isLocal(ctx, repository, dgst)
+	if err != nil {
+		return err
+	}
+	if !local {
+		return distribution.ErrUnsupported
+	}
+	if err := pms.localManifests.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	return pms.locals.remove(ctx, repository, dgst)
 }
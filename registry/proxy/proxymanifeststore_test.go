@@ -64,6 +64,7 @@ func (sm statsManifest) Put(ctx context.Context, manifest distribution.Manifest,
 type mockChallenger struct {
 	sync.Mutex
 	count int
+	err   error // returned by the attempts to establish the challenges
 }
 
 // Called for remote operations only
@@ -71,7 +72,7 @@ func (m *mockChallenger) tryEstablishChallenges(context.Context) error {
 	m.Lock()
 	defer m.Unlock()
 	m.count++
-	return nil
+	return m.err
 }
 
 func (m *mockChallenger) credentialStore() auth.CredentialStore {
@@ -3,6 +3,8 @@ package proxy
 import (
 	"expvar"
 	"sync/atomic"
+
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 )
 
 // Metrics is used to hold metric counters
@@ -70,4 +72,19 @@ func init() {
 	pm.(*expvar.Map).Set("manifests", expvar.Func(func() interface{} {
 		return proxyMetrics.manifestMetrics
 	}))
+
+	pm.(*expvar.Map).Set("inflight", expvar.Func(func() interface{} {
+		mu.Lock()
+		defer mu.Unlock()
+		return len(inflight)
+	}))
+}
+
+// publishScheduler makes the number of entries of the scheduler available
+// via expvar, next to the proxy metrics.
+func publishScheduler(s *scheduler.TTLExpirationScheduler) {
+	pm := expvar.Get("registry").(*expvar.Map).Get("proxy").(*expvar.Map)
+	pm.Set("scheduler", expvar.Func(func() interface{} {
+		return map[string]int{"entries": s.Len()}
+	}))
 }
@@ -0,0 +1,230 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultMirrorInterval is the time between synchronizations of a mirror
+// job without a configured interval.
+const defaultMirrorInterval = time.Hour
+
+// mirrorJob keeps the matching tags of an upstream repository in sync with
+// the cache.
+type mirrorJob struct {
+	namespace string // upstream host, empty when namespaces are disabled
+	name      reference.Named
+	tags      []string
+	interval  time.Duration
+}
+
+func (job mirrorJob) String() string {
+	if job.namespace == "" {
+		return job.name.Name()
+	}
+	return job.namespace + "/" + job.name.Name()
+}
+
+// matches reports whether the job synchronizes the tag.
+func (job mirrorJob) matches(tag string) bool {
+	return len(job.tags) == 0 || matchesAnyPattern(job.tags, tag)
+}
+
+// parseMirrorJobs validates the configured mirror jobs.
+func parseMirrorJobs(config []configuration.ProxyMirrorJob, enableNamespaces bool) ([]mirrorJob, error) {
+	jobs := make([]mirrorJob, 0, len(config))
+	for _, jobConfig := range config {
+		name, err := reference.WithName(jobConfig.Repository)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mirror job repository %q: %v", jobConfig.Repository, err)
+		}
+
+		job := mirrorJob{
+			name:     name,
+			tags:     jobConfig.Tags,
+			interval: jobConfig.Interval,
+		}
+		switch {
+		case enableNamespaces && jobConfig.Namespace == "":
+			return nil, fmt.Errorf("mirror job for %s has no namespace", name)
+		case !enableNamespaces && jobConfig.Namespace != "":
+			return nil, fmt.Errorf("mirror job for %s has a namespace, but namespaces are disabled", name)
+		case enableNamespaces:
+			job.namespace = upstreamHost(jobConfig.Namespace)
+		}
+
+		for _, pattern := range job.tags {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid tag pattern %q in mirror job for %s: %v", pattern, job, err)
+			}
+		}
+		if job.interval <= 0 {
+			job.interval = defaultMirrorInterval
+		}
+		jobs = append(jobs, job)
+	}
+	return jobs, nil
+}
+
+type mirrorTargetKey struct{}
+
+// mirrorTarget is the upstream repository of a mirror job, which takes the
+// place of the ns parameter and repository name of a request.
+type mirrorTarget struct {
+	remoteURL url.URL
+	name      reference.Named
+}
+
+// startMirrorJobs runs each job until the registry drains.
+func (pr *proxyingRegistry) startMirrorJobs(ctx context.Context, jobs []mirrorJob) {
+	for _, job := range jobs {
+		go func(job mirrorJob) {
+			ticker := time.NewTicker(job.interval)
+			defer ticker.Stop()
+
+			for {
+				if err := pr.syncMirror(ctx, job); err != nil {
+					dcontext.GetLogger(ctx).Errorf("Error synchronizing mirror of %s: %s", job, err)
+				}
+
+				select {
+				case <-ticker.C:
+				case <-pr.mirrorStop:
+					return
+				}
+			}
+		}(job)
+	}
+}
+
+// stopping reports whether the registry has started draining.
+func (pr *proxyingRegistry) stopping() bool {
+	select {
+	case <-pr.mirrorStop:
+		return true
+	default:
+		return false
+	}
+}
+
+// syncMirror pulls the manifests and blobs of the job's tags which are new
+// or have changed upstream, and removes the tags which were deleted
+// upstream from the cache.
+func (pr *proxyingRegistry) syncMirror(ctx context.Context, job mirrorJob) error {
+	ctx = withFetchReason(ctx, fetchReasonMirror)
+	if job.namespace != "" {
+		ctx = context.WithValue(ctx, mirrorTargetKey{}, mirrorTarget{
+			remoteURL: url.URL{Scheme: "https", Host: job.namespace},
+			name:      job.name,
+		})
+	}
+
+	repo, err := pr.Repository(ctx, job.name)
+	if err != nil {
+		return err
+	}
+	return pr.syncRepository(ctx, job, repo.(*proxiedRepository))
+}
+
+// syncRepository synchronizes the tags of the job in the proxied
+// repository.
+func (pr *proxyingRegistry) syncRepository(ctx context.Context, job mirrorJob, proxied *proxiedRepository) error {
+	tags := proxied.tags.(*proxyTagService)
+	blobs := proxied.blobStore.(*proxyBlobStore)
+
+	if err := tags.authChallenger.tryEstablishChallenges(ctx); err != nil {
+		return err
+	}
+	remoteTags, err := tags.remoteTags.All(ctx)
+	if err != nil {
+		return err
+	}
+
+	upstream := make(map[string]struct{}, len(remoteTags))
+	var synced, failed int
+	for _, tag := range remoteTags {
+		upstream[tag] = struct{}{}
+		if !job.matches(tag) {
+			continue
+		}
+		if pr.stopping() {
+			return nil
+		}
+
+		desc, err := tags.Get(ctx, tag)
+		if err == nil {
+			err = pr.syncManifest(ctx, proxied.manifests, blobs, desc.Digest, distribution.WithTag(tag))
+		}
+		if err != nil {
+			dcontext.GetLogger(ctx).Warnf("Error synchronizing %s:%s: %s", job, tag, err)
+			failed++
+			continue
+		}
+		synced++
+	}
+
+	localTags, err := tags.localTags.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+			return err
+		}
+	}
+	remaining := localTags[:0]
+	var removed int
+	for _, tag := range localTags {
+		if _, ok := upstream[tag]; !ok && job.matches(tag) {
+			if err := tags.localTags.Untag(ctx, tag); err != nil {
+				return err
+			}
+			removed++
+			continue
+		}
+		remaining = append(remaining, tag)
+	}
+	pr.tagLists.put(tags.repositoryName, mergeTags(remoteTags, remaining))
+
+	dcontext.GetLogger(ctx).Infof("Synchronized mirror of %s: %d tags synchronized, %d failed, %d removed", job, synced, failed, removed)
+	return nil
+}
+
+// syncManifest caches a manifest along with the blobs it references. Only
+// the children of an image index matching the configured platforms are
+// synchronized, if any are configured.
+func (pr *proxyingRegistry) syncManifest(ctx context.Context, manifests distribution.ManifestService, blobs *proxyBlobStore, dgst digest.Digest, options ...distribution.ManifestServiceOption) error {
+	manifest, err := manifests.Get(ctx, dgst, options...)
+	if err != nil {
+		return err
+	}
+
+	if ml, ok := manifest.(*manifestlist.DeserializedManifestList); ok {
+		for _, child := range ml.Manifests {
+			if len(pr.platforms) > 0 && !matchesAnyPlatform(pr.platforms, child.Platform) {
+				continue
+			}
+			if err := pr.syncManifest(ctx, manifests, blobs, child.Digest); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, desc := range manifest.References() {
+		if pr.stopping() {
+			return nil
+		}
+		if err := blobs.fetch(ctx, desc.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestParseMirrorJobs(t *testing.T) {
+	jobs, err := parseMirrorJobs([]configuration.ProxyMirrorJob{
+		{Namespace: "docker.io", Repository: "library/alpine", Tags: []string{"3.*"}},
+	}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(jobs) != 1 || jobs[0].namespace != "registry-1.docker.io" || jobs[0].interval != defaultMirrorInterval {
+		t.Fatalf("unexpected mirror jobs: %+v", jobs)
+	}
+	if !jobs[0].matches("3.19") || jobs[0].matches("edge") {
+		t.Fatal("unexpected tag matches")
+	}
+
+	for _, invalid := range []struct {
+		job              configuration.ProxyMirrorJob
+		enableNamespaces bool
+	}{
+		{configuration.ProxyMirrorJob{Repository: "library/alpine"}, true},
+		{configuration.ProxyMirrorJob{Namespace: "docker.io", Repository: "library/alpine"}, false},
+		{configuration.ProxyMirrorJob{Repository: "Library/Alpine"}, false},
+		{configuration.ProxyMirrorJob{Repository: "library/alpine", Tags: []string{"["}}, false},
+	} {
+		if _, err := parseMirrorJobs([]configuration.ProxyMirrorJob{invalid.job}, invalid.enableNamespaces); err == nil {
+			t.Errorf("expected an error parsing %+v", invalid.job)
+		}
+	}
+}
+
+func TestSyncMirror(t *testing.T) {
+	ctx := context.Background()
+	name, err := reference.WithName("library/debian")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteRegistry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteRepo, err := remoteRegistry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	images := make(map[string][]digest.Digest)
+	for _, tag := range []string{"12.1", "12.2", "13.0"} {
+		images[tag] = testutil.PushImage(t, remoteRepo, tag).Digests()
+	}
+
+	localRegistry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	localRepo, err := localRegistry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A release which was deleted upstream since the last synchronization
+	testutil.PushImage(t, localRepo, "12.0")
+
+	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteManifests, err := remoteRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+	proxied := &proxiedRepository{
+		blobStore: &proxyBlobStore{
+			localStore:     localRepo.Blobs(ctx),
+			remoteStore:    remoteRepo.Blobs(ctx),
+			scheduler:      s,
+			repositoryName: name,
+			authChallenger: &mockChallenger{},
+		},
+		manifests: &proxyManifestStore{
+			ctx:             ctx,
+			localManifests:  localManifests,
+			remoteManifests: remoteManifests,
+			scheduler:       s,
+			repositoryName:  name,
+			authChallenger:  &mockChallenger{},
+		},
+		name: name,
+		tags: &proxyTagService{
+			localTags:      localRepo.Tags(ctx),
+			remoteTags:     remoteRepo.Tags(ctx),
+			authChallenger: &mockChallenger{},
+			repositoryName: name,
+		},
+	}
+
+	pr := &proxyingRegistry{mirrorStop: make(chan struct{})}
+	job := mirrorJob{name: name, tags: []string{"12.*"}, interval: defaultMirrorInterval}
+	if err := pr.syncRepository(ctx, job, proxied); err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := localRepo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+	if !reflect.DeepEqual(tags, []string{"12.1", "12.2"}) {
+		t.Fatalf("unexpected local tags: %v", tags)
+	}
+
+	for tag, cached := range map[string]bool{"12.1": true, "12.2": true, "13.0": false} {
+		exists, err := localManifests.Exists(ctx, images[tag][0])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != cached {
+			t.Errorf("%s: expected manifest cached %t", tag, cached)
+		}
+		for _, dgst := range images[tag][1:] {
+			_, err := localRepo.Blobs(ctx).Stat(ctx, dgst)
+			if cached && err != nil {
+				t.Errorf("%s: expected %s to be cached: %v", tag, dgst, err)
+			}
+			if !cached && err == nil {
+				t.Errorf("%s: expected %s not to be cached", tag, dgst)
+			}
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// mountCached links a blob cached for another repository, possibly of
+// another upstream host, into the repository instead of fetching it from the
+// upstream again. The upstream is still asked whether the repository holds
+// the blob, which is cheaper than fetching it, so that cached content is not
+// exposed to repositories it does not belong to. It reports whether the blob
+// was mounted.
+func (pbs *proxyBlobStore) mountCached(ctx context.Context, dgst digest.Digest) bool {
+	if pbs.globalBlobs == nil {
+		return false
+	}
+
+	cached, err := pbs.globalBlobs.Stat(ctx, dgst)
+	if err != nil {
+		return false
+	}
+
+	remote, err := pbs.remoteStore.Stat(ctx, dgst)
+	if err != nil || remote.Size != cached.Size {
+		return false
+	}
+
+	blobRef, err := reference.WithDigest(pbs.repositoryName, dgst)
+	if err != nil {
+		return false
+	}
+
+	_, err = pbs.localStore.Create(pbs.objectTags.with(ctx, pbs.namespace), mountCachedBlob(blobRef, cached))
+	var mounted distribution.ErrBlobMounted
+	if !errors.As(err, &mounted) {
+		if err == nil {
+			// The store does not support mounting
+			dcontext.GetLogger(ctx).Warnf("Unable to mount cached blob %s", dgst)
+		} else {
+			dcontext.GetLogger(ctx).Errorf("Error mounting cached blob %s: %s", dgst, err)
+		}
+		return false
+	}
+
+	dcontext.GetLogger(ctx).Debugf("Mounted cached blob %s into %s", dgst, pbs.repositoryName)
+	pbs.scheduler.AddBlob(blobRef, pbs.policies.ttl(dgst))
+	pbs.stats.cached(blobEntry, pbs.namespace, blobRef.String(), cached.Size)
+	pbs.policies.cached(blobEntry, blobRef, cached.Size)
+	return true
+}
+
+// mountCachedBlob returns a BlobCreateOption which links the blob described
+// by desc into the repository, without looking it up in a source repository.
+func mountCachedBlob(ref reference.Canonical, desc distribution.Descriptor) distribution.BlobCreateOption {
+	return mountOption(func(v interface{}) error {
+		opts, ok := v.(*distribution.CreateOptions)
+		if !ok {
+			return fmt.Errorf("unexpected options type: %T", v)
+		}
+
+		opts.Mount.ShouldMount = true
+		opts.Mount.From = ref
+		opts.Mount.Stat = &desc
+		return nil
+	})
+}
+
+type mountOption func(interface{}) error
+
+func (f mountOption) Apply(v interface{}) error {
+	return f(v)
+}
+
+// linkedElsewhere reports whether a repository other than the given one
+// links the blob, in which case its content is to be kept when the blob
+// expires from the repository. Only the repositories holding manifests are
+// listed, which are those images were pulled from.
+func linkedElsewhere(ctx context.Context, registry distribution.Namespace, ref reference.Canonical) (bool, error) {
+	enumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return false, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	var linked bool
+	err := enumerator.Enumerate(ctx, func(repoName string) error {
+		if linked || repoName == ref.Name() {
+			return nil
+		}
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return err
+		}
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			return err
+		}
+		if _, err := repo.Blobs(ctx).Stat(ctx, ref.Digest()); err == nil {
+			linked = true
+		}
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return linked, nil
+}
@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestProxyStoreMountCached(t *testing.T) {
+	ctx := context.Background()
+	newRegistry := func() distribution.Namespace {
+		registry, err := storage.NewRegistry(ctx, inmemory.New())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return registry
+	}
+	repository := func(registry distribution.Namespace, name string) distribution.Repository {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+	localRegistry := newRegistry()
+	truthRepo := repository(newRegistry(), "foo/bar")
+
+	// The blob is cached for another repository, and the upstream holds it
+	// along with a blob which is not cached
+	shared := makeBlob(100)
+	cachedDesc, err := repository(localRegistry, "other/app").Blobs(ctx).Put(ctx, "", shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := truthRepo.Blobs(ctx).Put(ctx, "", shared); err != nil {
+		t.Fatal(err)
+	}
+	uncachedDesc, err := truthRepo.Blobs(ctx).Put(ctx, "", makeBlob(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	name, _ := reference.WithName("foo/bar")
+	remoteBlobs := statsBlobStore{stats: make(map[string]int), blobs: truthRepo.Blobs(ctx)}
+	pbs := proxyBlobStore{
+		localStore:     repository(localRegistry, "foo/bar").Blobs(ctx),
+		remoteStore:    remoteBlobs,
+		globalBlobs:    localRegistry.BlobStatter(),
+		scheduler:      scheduler.New(ctx, inmemory.New(), "/scheduler-state.json"),
+		repositoryName: name,
+		authChallenger: &mockChallenger{},
+	}
+
+	w := httptest.NewRecorder()
+	r, _ := http.NewRequest(http.MethodGet, "/", nil)
+	if err := pbs.ServeBlob(ctx, w, r, cachedDesc.Digest); err != nil {
+		t.Fatalf("unexpected error serving blob: %v", err)
+	}
+	if w.Body.String() != string(shared) {
+		t.Fatal("unexpected content served")
+	}
+	if remoteBlobs.stats["open"] != 0 || remoteBlobs.stats["get"] != 0 {
+		t.Fatalf("cached blob was fetched from the upstream: %v", remoteBlobs.stats)
+	}
+	if _, err := pbs.localStore.Stat(ctx, cachedDesc.Digest); err != nil {
+		t.Fatalf("blob was not mounted into the repository: %v", err)
+	}
+
+	// Blobs which are not cached, or not held by the upstream, are not mounted
+	if pbs.mountCached(ctx, uncachedDesc.Digest) {
+		t.Fatal("mounted a blob which is not cached")
+	}
+	otherDesc, err := repository(localRegistry, "other/app").Blobs(ctx).Put(ctx, "", makeBlob(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pbs.mountCached(ctx, otherDesc.Digest) {
+		t.Fatal("mounted a blob the upstream does not hold")
+	}
+
+	// The content is kept while another repository links it. Repositories
+	// are only listed once they hold manifests.
+	if err := repository(localRegistry, "other/app").Tags(ctx).Tag(ctx, "latest", cachedDesc); err != nil {
+		t.Fatal(err)
+	}
+	ref, _ := reference.WithDigest(name, cachedDesc.Digest)
+	if linked, err := linkedElsewhere(ctx, localRegistry, ref); err != nil || !linked {
+		t.Fatalf("expected the blob to be linked elsewhere: %v, %v", linked, err)
+	}
+	ref, _ = reference.WithDigest(name, otherDesc.Digest)
+	if linked, err := linkedElsewhere(ctx, localRegistry, ref); err != nil || !linked {
+		t.Fatalf("expected the blob to be linked elsewhere: %v, %v", linked, err)
+	}
+	otherName, _ := reference.WithName("other/app")
+	ref, _ = reference.WithDigest(otherName, otherDesc.Digest)
+	if linked, err := linkedElsewhere(ctx, localRegistry, ref); err != nil || linked {
+		t.Fatalf("expected the blob not to be linked elsewhere: %v, %v", linked, err)
+	}
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// Namespace describes an upstream registry configured for a pull through
+// cache.
+type Namespace struct {
+	// Name is the upstream host, which is passed as the ns parameter and
+	// prefixes the names of the repositories cached from the upstream
+	Name string `json:"name"`
+
+	// URL is the base URL of the upstream registry API
+	URL string `json:"url"`
+
+	// Credentials reports whether the upstream is accessed with configured
+	// credentials rather than anonymously
+	Credentials bool `json:"credentials"`
+}
+
+// NamespaceLister is implemented by registries acting as a pull through
+// cache.
+type NamespaceLister interface {
+	// ProxyNamespaces returns the configured upstreams, sorted by name.
+	ProxyNamespaces() []Namespace
+
+	// NamespaceRepositories fills repos with the names of the repositories
+	// cached from the upstream, without the host prefix, in lexical order
+	// after last. Like Repositories, it returns io.EOF when there are no
+	// more repositories to list.
+	NamespaceRepositories(ctx context.Context, namespace string, repos []string, last string) (int, error)
+}
+
+// configuredNamespaces returns the upstreams named in the proxy
+// configuration.
+func configuredNamespaces(config configuration.Proxy, resolvers upstreamResolvers) ([]Namespace, error) {
+	upstreams := make(map[string]url.URL)
+	add := func(key string) {
+		u, err := url.Parse(key)
+		if err != nil || u.Host == "" {
+			u = &url.URL{Scheme: "https", Host: key}
+		}
+		u.Host = upstreamHost(u.Host)
+		if _, ok := upstreams[u.Host]; !ok {
+			upstreams[u.Host] = *u
+		}
+	}
+
+	if !config.EnableNamespaces {
+		add(config.RemoteURL)
+	} else {
+		for key := range config.NamespaceCredentials {
+			add(key)
+		}
+		for key := range config.TrustPolicies {
+			add(key)
+		}
+		for key := range config.Transports {
+			add(key)
+		}
+		for key := range config.Remotes {
+			add(key)
+		}
+	}
+
+	credentials := make(map[string]bool)
+	for key, credential := range config.NamespaceCredentials {
+		credentials[upstreamHost(key)] = credential.Username != ""
+	}
+	if !config.EnableNamespaces {
+		credentials[upstreamHost(config.RemoteURL)] = config.Username != ""
+	}
+
+	namespaces := make([]Namespace, 0, len(upstreams))
+	for host, upstream := range upstreams {
+		base, err := resolvers.baseURL(upstream)
+		if err != nil {
+			return nil, err
+		}
+		namespaces = append(namespaces, Namespace{
+			Name:        host,
+			URL:         base.String(),
+			Credentials: credentials[host],
+		})
+	}
+
+	sort.Slice(namespaces, func(i, j int) bool {
+		return namespaces[i].Name < namespaces[j].Name
+	})
+	return namespaces, nil
+}
+
+// ProxyNamespaces returns the configured upstreams.
+func (pr *proxyingRegistry) ProxyNamespaces() []Namespace {
+	pr.mu.RLock()
+	defer pr.mu.RUnlock()
+	return pr.namespaces
+}
+
+// NamespaceRepositories lists the repositories cached from an upstream. The
+// local repositories of an upstream are those prefixed with its host, which
+// sort together in the catalog.
+func (pr *proxyingRegistry) NamespaceRepositories(ctx context.Context, namespace string, repos []string, last string) (int, error) {
+	prefix := upstreamHost(namespace) + "/"
+	if !pr.enableNamespaces {
+		prefix = ""
+	}
+
+	found := make([]string, len(repos))
+	n, err := pr.embedded.Repositories(ctx, found, prefix+last)
+
+	filled := 0
+	for _, repo := range found[:n] {
+		if !strings.HasPrefix(repo, prefix) {
+			// Past the repositories of the upstream
+			return filled, io.EOF
+		}
+		repos[filled] = strings.TrimPrefix(repo, prefix)
+		filled++
+	}
+	return filled, err
+}
@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+const (
+	// objectTagOrigin is the tag of the origin of the objects cached
+	objectTagOrigin = "origin"
+	// objectTagNamespace is the tag of the upstream host of the objects
+	// cached
+	objectTagNamespace = "namespace"
+	// maxObjectTags is the number of tags an object may have in s3
+	maxObjectTags = 10
+)
+
+// objectTagRegexp matches the characters s3 accepts in the keys and values
+// of the tags.
+var objectTagRegexp = regexp.MustCompile(`^[\p{L}\p{Z}\p{N}_.:/=+\-@]*$`)
+
+// objectTags tags the objects written to the storage when caching content
+// from an upstream, for the lifecycle rules and the cost reports of the
+// storage. A nil objectTags tags nothing.
+type objectTags struct {
+	tags map[string]string
+}
+
+// newObjectTags returns the tags of the objects cached configured, nil if
+// the objects are not tagged.
+func newObjectTags(config configuration.ProxyObjectTags) (*objectTags, error) {
+	if !config.Enabled {
+		if len(config.Tags) > 0 {
+			return nil, fmt.Errorf("objecttags: enabled is required")
+		}
+		return nil, nil
+	}
+	if len(config.Tags) > maxObjectTags-2 {
+		return nil, fmt.Errorf("objecttags: at most %d tags are allowed", maxObjectTags-2)
+	}
+	keys := make([]string, 0, len(config.Tags))
+	for key := range config.Tags {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value := config.Tags[key]
+		switch {
+		case key == objectTagOrigin || key == objectTagNamespace:
+			return nil, fmt.Errorf("objecttags: tag %q is reserved", key)
+		case key == "" || len(key) > 128 || !objectTagRegexp.MatchString(key):
+			return nil, fmt.Errorf("objecttags: invalid tag key %q", key)
+		case len(value) > 256 || !objectTagRegexp.MatchString(value):
+			return nil, fmt.Errorf("objecttags: invalid value %q of tag %q", value, key)
+		}
+	}
+	return &objectTags{tags: config.Tags}, nil
+}
+
+// with returns a context with which the objects cached from the upstream
+// namespace are tagged.
+func (ot *objectTags) with(ctx context.Context, namespace string) context.Context {
+	if ot == nil {
+		return ctx
+	}
+	tags := make(map[string]string, len(ot.tags)+2)
+	for key, value := range ot.tags {
+		tags[key] = value
+	}
+	tags[objectTagOrigin] = "proxy"
+	tags[objectTagNamespace] = namespace
+	return driver.WithObjectTags(ctx, tags)
+}
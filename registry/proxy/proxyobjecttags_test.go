@@ -0,0 +1,137 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+// taggingDriver records the tags of the objects written.
+type taggingDriver struct {
+	driver.StorageDriver
+	mu   sync.Mutex
+	tags map[string]map[string]string
+}
+
+func (d *taggingDriver) record(ctx context.Context, path string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.tags[path] = driver.ObjectTags(ctx)
+}
+
+func (d *taggingDriver) PutContent(ctx context.Context, path string, content []byte) error {
+	d.record(ctx, path)
+	return d.StorageDriver.PutContent(ctx, path, content)
+}
+
+func (d *taggingDriver) Writer(ctx context.Context, path string, append bool) (driver.FileWriter, error) {
+	d.record(ctx, path)
+	return d.StorageDriver.Writer(ctx, path, append)
+}
+
+// dataTags returns the tags of the data of the blobs written.
+func (d *taggingDriver) dataTags() []map[string]string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	var tags []map[string]string
+	for path, t := range d.tags {
+		if strings.HasSuffix(path, "/data") {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
+
+func TestNewObjectTags(t *testing.T) {
+	for _, config := range []configuration.ProxyObjectTags{
+		{Tags: map[string]string{"team": "platform"}},
+		{Enabled: true, Tags: map[string]string{"origin": "mirror"}},
+		{Enabled: true, Tags: map[string]string{"team": "plat#form"}},
+		{Enabled: true, Tags: map[string]string{"": "platform"}},
+		{Enabled: true, Tags: map[string]string{"a": "", "b": "", "c": "", "d": "", "e": "", "f": "", "g": "", "h": "", "i": ""}},
+	} {
+		if _, err := newObjectTags(config); err == nil {
+			t.Errorf("expected %+v to be invalid", config)
+		}
+	}
+	if ot, err := newObjectTags(configuration.ProxyObjectTags{}); err != nil || ot != nil {
+		t.Fatalf("expected no object tags: %v", err)
+	}
+}
+
+func TestObjectTagsOfCachedBlobs(t *testing.T) {
+	ctx := context.Background()
+	d := &taggingDriver{StorageDriver: inmemory.New(), tags: make(map[string]map[string]string)}
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, _ := reference.WithName("docker.io/library/app")
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstream, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	upstreamRepo, err := upstream.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	small, err := upstreamRepo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("small"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	large, err := upstreamRepo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte(strings.Repeat("large", 1024)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	objectTags, err := newObjectTags(configuration.ProxyObjectTags{Enabled: true, Tags: map[string]string{"team": "platform"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pbs := &proxyBlobStore{
+		localStore:     repo.Blobs(ctx),
+		remoteStore:    upstreamRepo.Blobs(ctx),
+		repositoryName: name,
+		authChallenger: &mockChallenger{},
+		namespace:      "registry-1.docker.io",
+		objectTags:     objectTags,
+	}
+
+	// the blobs written other than by the cache are not tagged
+	if _, err := repo.Blobs(ctx).Put(ctx, "application/octet-stream", []byte("pushed")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pbs.Get(ctx, small.Digest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pbs.storeLocal(ctx, large.Digest); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]string{"origin": "proxy", "namespace": "registry-1.docker.io", "team": "platform"}
+	var tagged int
+	for _, tags := range d.dataTags() {
+		if tags == nil {
+			continue
+		}
+		if !reflect.DeepEqual(tags, expected) {
+			t.Fatalf("unexpected tags %v", tags)
+		}
+		tagged++
+	}
+	if tagged != 2 {
+		t.Fatalf("expected the data of the 2 blobs cached to be tagged, got %d", tagged)
+	}
+}
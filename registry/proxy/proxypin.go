@@ -0,0 +1,157 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+// repositoryPin matches cached content which never expires. Without a tag
+// pattern, all content of the matching repositories is pinned. Otherwise
+// only the manifests of the matching tags, and the content they reference,
+// is pinned.
+type repositoryPin struct {
+	repository string
+	tag        string
+}
+
+// pinnedRepositories holds the pins of the cache.
+type pinnedRepositories []repositoryPin
+
+// parsePins parses pins of the form repository[:tag], where both parts are
+// path.Match patterns matched against the local repository names.
+func parsePins(patterns []string) (pinnedRepositories, error) {
+	pins := make(pinnedRepositories, 0, len(patterns))
+	for _, pattern := range patterns {
+		pin := repositoryPin{repository: pattern}
+		// A colon before the last slash separates a registry port
+		if i := strings.LastIndex(pattern, ":"); i > strings.LastIndex(pattern, "/") {
+			pin.repository, pin.tag = pattern[:i], pattern[i+1:]
+			if pin.tag == "" {
+				return nil, fmt.Errorf("pinned repository %q has an empty tag pattern", pattern)
+			}
+		}
+
+		if _, err := path.Match(pin.repository, ""); err != nil {
+			return nil, fmt.Errorf("invalid pinned repository %q: %v", pattern, err)
+		}
+		if _, err := path.Match(pin.tag, ""); err != nil {
+			return nil, fmt.Errorf("invalid pinned repository %q: %v", pattern, err)
+		}
+		pins = append(pins, pin)
+	}
+	return pins, nil
+}
+
+// repository reports whether all content of the named repository is
+// pinned, in which case it is not scheduled for expiry.
+func (pins pinnedRepositories) repository(name string) bool {
+	for _, pin := range pins {
+		if pin.tag != "" {
+			continue
+		}
+		if matched, _ := path.Match(pin.repository, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// pinned reports whether content of the repository is pinned, either by the
+// pin of the whole repository or by a pinned tag referencing it.
+func (pins pinnedRepositories) pinned(ctx context.Context, repo distribution.Repository, dgst digest.Digest) (bool, error) {
+	name := repo.Named().Name()
+	if pins.repository(name) {
+		return true, nil
+	}
+
+	var tagPatterns []string
+	for _, pin := range pins {
+		if matched, _ := path.Match(pin.repository, name); matched && pin.tag != "" {
+			tagPatterns = append(tagPatterns, pin.tag)
+		}
+	}
+	if len(tagPatterns) == 0 {
+		return false, nil
+	}
+
+	tagService := repo.Tags(ctx)
+	tags, err := tagService.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return false, err
+	}
+
+	for _, tag := range tags {
+		if !matchesAnyPattern(tagPatterns, tag) {
+			continue
+		}
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			continue
+		}
+		referenced, err := references(ctx, manifests, desc.Digest, dgst)
+		if err != nil {
+			return false, err
+		}
+		if referenced {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func matchesAnyPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// references reports whether the cached manifest is, or references, the
+// content with the given digest. The children of image indexes are searched
+// when they are cached.
+func references(ctx context.Context, manifests distribution.ManifestService, manifestDigest, dgst digest.Digest) (bool, error) {
+	if manifestDigest == dgst {
+		return true, nil
+	}
+
+	manifest, err := manifests.Get(ctx, manifestDigest)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+			return false, nil
+		}
+		return false, err
+	}
+
+	_, isIndex := manifest.(*manifestlist.DeserializedManifestList)
+	for _, desc := range manifest.References() {
+		if desc.Digest == dgst {
+			return true, nil
+		}
+		if isIndex {
+			referenced, err := references(ctx, manifests, desc.Digest, dgst)
+			if err != nil {
+				return false, err
+			}
+			if referenced {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,91 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestParsePins(t *testing.T) {
+	pins, err := parsePins([]string{
+		"library/debian",
+		"registry-1.docker.io/library/*",
+		"localhost:5000/base:1.*",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := pinnedRepositories{
+		{repository: "library/debian"},
+		{repository: "registry-1.docker.io/library/*"},
+		{repository: "localhost:5000/base", tag: "1.*"},
+	}
+	if len(pins) != len(expected) {
+		t.Fatalf("expected %d pins, got %d", len(expected), len(pins))
+	}
+	for i := range expected {
+		if pins[i] != expected[i] {
+			t.Errorf("expected pin %+v, got %+v", expected[i], pins[i])
+		}
+	}
+
+	for name, pinned := range map[string]bool{
+		"library/debian":                      true,
+		"library/alpine":                      false,
+		"registry-1.docker.io/library/alpine": true,
+		"localhost:5000/base":                 false,
+	} {
+		if pins.repository(name) != pinned {
+			t.Errorf("%s: expected pinned %t", name, pinned)
+		}
+	}
+
+	for _, invalid := range []string{"library/[", "library/debian:", "library/debian:[a"} {
+		if _, err := parsePins([]string{invalid}); err == nil {
+			t.Errorf("expected an error parsing %q", invalid)
+		}
+	}
+}
+
+func TestPinnedTags(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := reference.WithName("library/debian")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tag an image for each release, each with its own layer
+	images := make(map[string][]digest.Digest)
+	for _, tag := range []string{"bookworm", "trixie"} {
+		images[tag] = testutil.PushImage(t, repo, tag).Digests()
+	}
+
+	pins, err := parsePins([]string{"library/*:book*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for tag, pinned := range map[string]bool{"bookworm": true, "trixie": false} {
+		for _, dgst := range images[tag] {
+			got, err := pins.pinned(ctx, repo, dgst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != pinned {
+				t.Errorf("%s %s: expected pinned %t", tag, dgst, pinned)
+			}
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+// platform identifies an image index child by operating system,
+// architecture and optional CPU variant.
+type platform struct {
+	os           string
+	architecture string
+	variant      string
+}
+
+// parsePlatforms parses platforms given in os/arch[/variant] form.
+func parsePlatforms(specs []string) ([]platform, error) {
+	platforms := make([]platform, 0, len(specs))
+	for _, spec := range specs {
+		parts := strings.Split(spec, "/")
+		if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid platform %q: expected os/arch[/variant]", spec)
+		}
+
+		p := platform{os: parts[0], architecture: parts[1]}
+		if len(parts) == 3 {
+			p.variant = parts[2]
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+// matches returns true if the platform spec describes this platform. An
+// empty variant matches any variant.
+func (p platform) matches(spec manifestlist.PlatformSpec) bool {
+	if p.os != spec.OS || p.architecture != spec.Architecture {
+		return false
+	}
+	return p.variant == "" || p.variant == spec.Variant
+}
+
+// matchesAnyPlatform returns true if the platform spec matches one of the
+// given platforms.
+func matchesAnyPlatform(platforms []platform, spec manifestlist.PlatformSpec) bool {
+	for _, p := range platforms {
+		if p.matches(spec) {
+			return true
+		}
+	}
+	return false
+}
+
+// platformPins holds the children of the cached image indexes which match
+// the configured platforms, so that they are not evicted while one of their
+// indexes is cached: a client pulling the index finds the children it was
+// prefetched for. The pins are learnt again from the indexes served after a
+// restart. A nil platformPins pins nothing.
+type platformPins struct {
+	mu      sync.Mutex
+	indexes map[string]map[digest.Digest]struct{} // keyed by repository@digest of the child
+}
+
+// newPlatformPins returns the pins of the children of the platforms, nil if
+// no platform is configured.
+func newPlatformPins(platforms []platform) *platformPins {
+	if len(platforms) == 0 {
+		return nil
+	}
+	return &platformPins{indexes: make(map[string]map[digest.Digest]struct{})}
+}
+
+// pin pins a child of the index of the repository.
+func (pp *platformPins) pin(repository string, index, child digest.Digest) {
+	if pp == nil {
+		return
+	}
+
+	pp.mu.Lock()
+	defer pp.mu.Unlock()
+	key := checkpointKey(repository, child)
+	if pp.indexes[key] == nil {
+		pp.indexes[key] = make(map[digest.Digest]struct{})
+	}
+	pp.indexes[key][index] = struct{}{}
+}
+
+// pinned reports whether the manifest of the repository is the child of an
+// index still cached. The pins of the indexes evicted are forgotten.
+func (pp *platformPins) pinned(ctx context.Context, repo distribution.Repository, dgst digest.Digest) (bool, error) {
+	if pp == nil {
+		return false, nil
+	}
+
+	key := checkpointKey(repo.Named().Name(), dgst)
+	pp.mu.Lock()
+	indexes := make([]digest.Digest, 0, len(pp.indexes[key]))
+	for index := range pp.indexes[key] {
+		indexes = append(indexes, index)
+	}
+	pp.mu.Unlock()
+	if len(indexes) == 0 {
+		return false, nil
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, index := range indexes {
+		exists, err := manifests.Exists(ctx, index)
+		if err != nil {
+			return false, err
+		}
+		if exists {
+			return true, nil
+		}
+
+		pp.mu.Lock()
+		delete(pp.indexes[key], index)
+		if len(pp.indexes[key]) == 0 {
+			delete(pp.indexes, key)
+		}
+		pp.mu.Unlock()
+	}
+	return false, nil
+}
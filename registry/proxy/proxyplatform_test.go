@@ -0,0 +1,234 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+// mapManifests is a ManifestService backed by a map
+type mapManifests struct {
+	sync.Mutex
+	manifests map[digest.Digest]distribution.Manifest
+}
+
+func newMapManifests() *mapManifests {
+	return &mapManifests{manifests: make(map[digest.Digest]distribution.Manifest)}
+}
+
+func (m *mapManifests) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	m.Lock()
+	defer m.Unlock()
+	_, ok := m.manifests[dgst]
+	return ok, nil
+}
+
+func (m *mapManifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	m.Lock()
+	defer m.Unlock()
+	if mf, ok := m.manifests[dgst]; ok {
+		return mf, nil
+	}
+	return nil, distribution.ErrManifestUnknownRevision{Revision: dgst}
+}
+
+func (m *mapManifests) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return "", err
+	}
+	dgst := digest.FromBytes(payload)
+
+	m.Lock()
+	defer m.Unlock()
+	m.manifests[dgst] = manifest
+	return dgst, nil
+}
+
+func (m *mapManifests) Delete(ctx context.Context, dgst digest.Digest) error {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.manifests, dgst)
+	return nil
+}
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := parsePlatforms([]string{"linux/amd64", "linux/arm64/v8"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []platform{
+		{os: "linux", architecture: "amd64"},
+		{os: "linux", architecture: "arm64", variant: "v8"},
+	}
+	if len(platforms) != len(expected) {
+		t.Fatalf("unexpected platforms: %v", platforms)
+	}
+	for i := range expected {
+		if platforms[i] != expected[i] {
+			t.Errorf("unexpected platform at %d: %v", i, platforms[i])
+		}
+	}
+
+	for _, invalid := range []string{"linux", "linux/", "/amd64", "linux/arm/v7/extra"} {
+		if _, err := parsePlatforms([]string{invalid}); err == nil {
+			t.Errorf("expected error parsing %q", invalid)
+		}
+	}
+}
+
+func TestPlatformMatches(t *testing.T) {
+	p := platform{os: "linux", architecture: "arm"}
+	if !p.matches(manifestlist.PlatformSpec{OS: "linux", Architecture: "arm", Variant: "v7"}) {
+		t.Error("expected platform without variant to match any variant")
+	}
+
+	p.variant = "v6"
+	if p.matches(manifestlist.PlatformSpec{OS: "linux", Architecture: "arm", Variant: "v7"}) {
+		t.Error("expected variant mismatch")
+	}
+	if p.matches(manifestlist.PlatformSpec{OS: "windows", Architecture: "arm", Variant: "v6"}) {
+		t.Error("expected os mismatch")
+	}
+}
+
+func TestProxyManifestsPrefetchPlatforms(t *testing.T) {
+	ctx := context.Background()
+	remote := newMapManifests()
+	local := newMapManifests()
+
+	var children []manifestlist.ManifestDescriptor
+	for _, p := range []manifestlist.PlatformSpec{
+		{OS: "linux", Architecture: "amd64"},
+		{OS: "linux", Architecture: "s390x"},
+	} {
+		m, err := schema2.FromStruct(schema2.Manifest{
+			Versioned: schema2.SchemaVersion,
+			Config: distribution.Descriptor{
+				MediaType: schema2.MediaTypeImageConfig,
+				Digest:    digest.FromString(p.Architecture),
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		dgst, err := remote.Put(ctx, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		children = append(children, manifestlist.ManifestDescriptor{
+			Descriptor: distribution.Descriptor{MediaType: schema2.MediaTypeManifest, Digest: dgst},
+			Platform:   p,
+		})
+	}
+
+	index, err := manifestlist.FromDescriptors(children)
+	if err != nil {
+		t.Fatal(err)
+	}
+	indexDigest, err := remote.Put(ctx, index)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nameRef, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fetches, err := newFetchTracker(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	platforms := []platform{{os: "linux", architecture: "amd64"}}
+	pms := proxyManifestStore{
+		ctx:             ctx,
+		localManifests:  local,
+		remoteManifests: remote,
+		repositoryName:  nameRef,
+		scheduler:       scheduler.New(ctx, inmemory.New(), "/scheduler-state.json"),
+		authChallenger:  &mockChallenger{},
+		platforms:       platforms,
+		platformPins:    newPlatformPins(platforms),
+		fetches:         fetches,
+	}
+
+	if _, err := pms.Get(ctx, indexDigest); err != nil {
+		t.Fatal(err)
+	}
+	// The children are prefetched in the background, which the drain waits
+	// for
+	if err := fetches.drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for dgst, expected := range map[digest.Digest]bool{
+		indexDigest:        true,
+		children[0].Digest: true,
+		children[1].Digest: false,
+	} {
+		exists, err := local.Exists(ctx, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != expected {
+			t.Errorf("unexpected local existence of %s: %v", dgst, exists)
+		}
+	}
+
+	// The child prefetched is pinned while the index is cached
+	repo := &manifestsRepository{name: nameRef, manifests: local}
+	for dgst, expected := range map[digest.Digest]bool{
+		children[0].Digest: true,
+		children[1].Digest: false,
+	} {
+		pinned, err := pms.platformPins.pinned(ctx, repo, dgst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pinned != expected {
+			t.Errorf("unexpected pin of %s: %v", dgst, pinned)
+		}
+	}
+	if err := local.Delete(ctx, indexDigest); err != nil {
+		t.Fatal(err)
+	}
+	if pinned, err := pms.platformPins.pinned(ctx, repo, children[0].Digest); err != nil || pinned {
+		t.Fatalf("expected the child of the evicted index not to be pinned, got %v %v", pinned, err)
+	}
+
+	// Nothing is prefetched once the registry drains
+	remote.Delete(ctx, children[0].Digest)
+	local.Delete(ctx, children[0].Digest)
+	if _, err := pms.Get(ctx, indexDigest); err != nil {
+		t.Fatal(err)
+	}
+	if exists, _ := local.Exists(ctx, children[0].Digest); exists {
+		t.Fatal("expected no prefetch once the registry drained")
+	}
+}
+
+// manifestsRepository is a repository of the manifests of a
+// ManifestService
+type manifestsRepository struct {
+	distribution.Repository
+	name      reference.Named
+	manifests distribution.ManifestService
+}
+
+func (r *manifestsRepository) Named() reference.Named {
+	return r.name
+}
+
+func (r *manifestsRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	return r.manifests, nil
+}
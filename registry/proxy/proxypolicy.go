@@ -0,0 +1,299 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/opencontainers/go-digest"
+)
+
+// artifactPolicy applies to the cached artifacts of some media types, such
+// as Helm charts, in place of the defaults for container images.
+type artifactPolicy struct {
+	name       string
+	mediaTypes []string // path.Match patterns
+	ttl        time.Duration
+	pins       pinnedRepositories
+	quota      int64 // bytes of the bucket, unbounded if zero
+}
+
+// bucketEntry is content counted against the quota of a policy.
+type bucketEntry struct {
+	kind     cacheEntryKind
+	ref      reference.Canonical
+	size     int64
+	cachedAt time.Time
+	evicting bool // expiring early, to bring the bucket under its quota
+}
+
+// artifactPolicies holds the artifact policies of the cache, along with the
+// content they apply to, which is learnt from the manifests served. Content
+// which no policy applies to is cached for repositoryTTL. A nil value
+// applies no policy.
+type artifactPolicies struct {
+	policies  []*artifactPolicy
+	scheduler *scheduler.TTLExpirationScheduler // expires the content past the quotas
+
+	mu      sync.Mutex
+	content map[digest.Digest]*artifactPolicy
+	buckets map[*artifactPolicy]map[string]*bucketEntry // keyed as in the scheduler
+}
+
+// PolicyStats reports the content cached under an artifact policy since
+// the registry started.
+type PolicyStats struct {
+	Entries int   `json:"entries"`
+	Bytes   int64 `json:"bytes"`
+	Quota   int64 `json:"quota,omitempty"`
+}
+
+// parseArtifactPolicies parses the artifact policies, returning nil if
+// there are none.
+func parseArtifactPolicies(config []configuration.ProxyArtifactPolicy) (*artifactPolicies, error) {
+	if len(config) == 0 {
+		return nil, nil
+	}
+
+	ap := &artifactPolicies{
+		content: make(map[digest.Digest]*artifactPolicy),
+		buckets: make(map[*artifactPolicy]map[string]*bucketEntry),
+	}
+	names := make(map[string]bool)
+	for i, policyConfig := range config {
+		if policyConfig.Name == "" {
+			return nil, fmt.Errorf("artifact policy %d: name is required", i)
+		}
+		if names[policyConfig.Name] {
+			return nil, fmt.Errorf("artifact policy %s: duplicate name", policyConfig.Name)
+		}
+		names[policyConfig.Name] = true
+		if len(policyConfig.MediaTypes) == 0 {
+			return nil, fmt.Errorf("artifact policy %s: mediatypes are required", policyConfig.Name)
+		}
+		for _, pattern := range policyConfig.MediaTypes {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("artifact policy %s: invalid media type %q: %v", policyConfig.Name, pattern, err)
+			}
+		}
+		if policyConfig.TTL < 0 {
+			return nil, fmt.Errorf("artifact policy %s: ttl must not be negative", policyConfig.Name)
+		}
+		if policyConfig.Quota < 0 {
+			return nil, fmt.Errorf("artifact policy %s: quota must not be negative", policyConfig.Name)
+		}
+		pins, err := parsePins(policyConfig.PinnedRepositories)
+		if err != nil {
+			return nil, fmt.Errorf("artifact policy %s: %v", policyConfig.Name, err)
+		}
+
+		policy := &artifactPolicy{
+			name:       policyConfig.Name,
+			mediaTypes: policyConfig.MediaTypes,
+			ttl:        policyConfig.TTL,
+			pins:       pins,
+			quota:      policyConfig.Quota,
+		}
+		if policy.ttl == 0 {
+			policy.ttl = repositoryTTL
+		}
+		ap.policies = append(ap.policies, policy)
+		ap.buckets[policy] = make(map[string]*bucketEntry)
+	}
+	return ap, nil
+}
+
+// match returns the policy applying to the manifest, nil if none does. A
+// policy applies if it matches the media type or artifact type of the
+// manifest, or the media type of content it references, such as its config.
+func (ap *artifactPolicies) match(manifest distribution.Manifest) *artifactPolicy {
+	mediaType, _, err := manifest.Payload()
+	if err != nil {
+		return nil
+	}
+	mediaTypes := []string{mediaType}
+	if m, ok := manifest.(*ocischema.DeserializedManifest); ok && m.ArtifactType != "" {
+		mediaTypes = append(mediaTypes, m.ArtifactType)
+	}
+	for _, desc := range manifest.References() {
+		mediaTypes = append(mediaTypes, desc.MediaType)
+	}
+
+	for _, policy := range ap.policies {
+		for _, mediaType := range mediaTypes {
+			if matchesAnyPattern(policy.mediaTypes, mediaType) {
+				return policy
+			}
+		}
+	}
+	return nil
+}
+
+// classify records the policy applying to the manifest and the content it
+// references, if any.
+func (ap *artifactPolicies) classify(dgst digest.Digest, manifest distribution.Manifest) {
+	if ap == nil {
+		return
+	}
+	policy := ap.match(manifest)
+	if policy == nil {
+		return
+	}
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	ap.content[dgst] = policy
+	for _, desc := range manifest.References() {
+		ap.content[desc.Digest] = policy
+	}
+}
+
+// forDigest returns the policy applying to the content, nil if none does.
+func (ap *artifactPolicies) forDigest(dgst digest.Digest) *artifactPolicy {
+	if ap == nil {
+		return nil
+	}
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	return ap.content[dgst]
+}
+
+// ttl returns how long the content is cached.
+func (ap *artifactPolicies) ttl(dgst digest.Digest) time.Duration {
+	if policy := ap.forDigest(dgst); policy != nil {
+		return policy.ttl
+	}
+	return repositoryTTL
+}
+
+// pinned reports whether the content is pinned by the pins of its policy.
+func (ap *artifactPolicies) pinned(ctx context.Context, repo distribution.Repository, dgst digest.Digest) (bool, error) {
+	policy := ap.forDigest(dgst)
+	if policy == nil {
+		return false, nil
+	}
+	return policy.pins.pinned(ctx, repo, dgst)
+}
+
+// cached records content added to the cache, keyed as in the scheduler, in
+// the bucket of its policy, and expires the oldest content of the bucket
+// once it is over its quota.
+func (ap *artifactPolicies) cached(kind cacheEntryKind, ref reference.Canonical, size int64) {
+	policy := ap.forDigest(ref.Digest())
+	if policy == nil {
+		return
+	}
+
+	ap.mu.Lock()
+	bucket := ap.buckets[policy]
+	bucket[ref.String()] = &bucketEntry{
+		kind:     kind,
+		ref:      ref,
+		size:     size,
+		cachedAt: time.Now(),
+	}
+	evicted := ap.overQuota(policy)
+	ap.mu.Unlock()
+
+	// the scheduler is not called with the lock held, as it calls expired
+	// with its own lock held
+	for _, entry := range evicted {
+		if entry.kind == manifestEntry {
+			ap.scheduler.AddManifest(entry.ref, 0)
+		} else {
+			ap.scheduler.AddBlob(entry.ref, 0)
+		}
+	}
+}
+
+// overQuota returns the oldest content of the bucket of the policy to
+// expire for the bucket to be within its quota, and marks it as expiring.
+func (ap *artifactPolicies) overQuota(policy *artifactPolicy) []*bucketEntry {
+	if policy.quota == 0 || ap.scheduler == nil {
+		return nil
+	}
+
+	var size int64
+	entries := make([]*bucketEntry, 0, len(ap.buckets[policy]))
+	for _, entry := range ap.buckets[policy] {
+		if !entry.evicting {
+			size += entry.size
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].cachedAt.Before(entries[j].cachedAt)
+	})
+
+	var evicted []*bucketEntry
+	for _, entry := range entries {
+		if size <= policy.quota {
+			break
+		}
+		entry.evicting = true
+		size -= entry.size
+		evicted = append(evicted, entry)
+	}
+	return evicted
+}
+
+// expired records content removed from the cache.
+func (ap *artifactPolicies) expired(ref reference.Canonical) {
+	if ap == nil {
+		return
+	}
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	for _, bucket := range ap.buckets {
+		delete(bucket, ref.String())
+	}
+}
+
+// kept records pinned content kept in the cache when it expired, which
+// counts as the newest content of its bucket.
+func (ap *artifactPolicies) kept(ref reference.Canonical) {
+	if ap == nil {
+		return
+	}
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	for _, bucket := range ap.buckets {
+		if entry, ok := bucket[ref.String()]; ok {
+			entry.evicting = false
+			entry.cachedAt = time.Now()
+		}
+	}
+}
+
+// stats returns the statistics of the buckets of the policies.
+func (ap *artifactPolicies) stats() map[string]PolicyStats {
+	if ap == nil {
+		return nil
+	}
+
+	ap.mu.Lock()
+	defer ap.mu.Unlock()
+	stats := make(map[string]PolicyStats, len(ap.policies))
+	for _, policy := range ap.policies {
+		ps := PolicyStats{Quota: policy.quota}
+		for _, entry := range ap.buckets[policy] {
+			if !entry.evicting {
+				ps.Entries++
+				ps.Bytes += entry.size
+			}
+		}
+		stats[policy.name] = ps
+	}
+	return stats
+}
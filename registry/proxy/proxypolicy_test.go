@@ -0,0 +1,152 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func helmChart(t *testing.T, chart string) *ocischema.DeserializedManifest {
+	t.Helper()
+	m, err := ocischema.FromStruct(ocischema.Manifest{
+		Versioned: ocischema.SchemaVersion,
+		Config: distribution.Descriptor{
+			MediaType: "application/vnd.cncf.helm.config.v1+json",
+			Digest:    digest.FromString(chart + " config"),
+			Size:      100,
+		},
+		Layers: []distribution.Descriptor{
+			{
+				MediaType: "application/vnd.cncf.helm.chart.content.v1.tar+gzip",
+				Digest:    digest.FromString(chart),
+				Size:      1000,
+			},
+			{
+				MediaType: "application/vnd.cncf.helm.chart.provenance.v1.prov",
+				Digest:    digest.FromString(chart + " provenance"),
+				Size:      10,
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestParseArtifactPolicies(t *testing.T) {
+	policies, err := parseArtifactPolicies(nil)
+	if err != nil || policies != nil {
+		t.Fatalf("unexpected policies %v without configuration: %v", policies, err)
+	}
+	if ttl := policies.ttl(digest.FromString("layer")); ttl != repositoryTTL {
+		t.Fatalf("unexpected ttl %s without policies", ttl)
+	}
+
+	for _, config := range [][]configuration.ProxyArtifactPolicy{
+		{{MediaTypes: []string{"application/vnd.cncf.helm.*"}}},
+		{{Name: "helm"}},
+		{{Name: "helm", MediaTypes: []string{"application/vnd.cncf.helm.["}}},
+		{{Name: "helm", MediaTypes: []string{"application/vnd.cncf.helm.*"}, TTL: -time.Hour}},
+		{{Name: "helm", MediaTypes: []string{"application/vnd.cncf.helm.*"}, Quota: -1}},
+		{{Name: "helm", MediaTypes: []string{"application/vnd.cncf.helm.*"}, PinnedRepositories: []string{"charts/["}}},
+		{
+			{Name: "helm", MediaTypes: []string{"application/vnd.cncf.helm.*"}},
+			{Name: "helm", MediaTypes: []string{"application/vnd.wasm.*"}},
+		},
+	} {
+		if _, err := parseArtifactPolicies(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestArtifactPolicyTTL(t *testing.T) {
+	policies, err := parseArtifactPolicies([]configuration.ProxyArtifactPolicy{
+		{Name: "helm", MediaTypes: []string{"application/vnd.cncf.helm.*"}, TTL: time.Hour},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chart := helmChart(t, "nginx")
+	_, payload, err := chart.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst := digest.FromBytes(payload)
+	policies.classify(dgst, chart)
+	for _, desc := range append(chart.References(), distribution.Descriptor{Digest: dgst}) {
+		if ttl := policies.ttl(desc.Digest); ttl != time.Hour {
+			t.Errorf("unexpected ttl %s of %s", ttl, desc.MediaType)
+		}
+	}
+	if ttl := policies.ttl(digest.FromString("image layer")); ttl != repositoryTTL {
+		t.Errorf("unexpected ttl %s of an image layer", ttl)
+	}
+}
+
+func TestArtifactPolicyQuota(t *testing.T) {
+	ctx := context.Background()
+	policies, err := parseArtifactPolicies([]configuration.ProxyArtifactPolicy{
+		{Name: "helm", MediaTypes: []string{"application/vnd.cncf.helm.*"}, Quota: 1500},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired := make(chan string, 10)
+	s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+	s.OnBlobExpire(func(ref reference.Reference) error {
+		policies.expired(ref.(reference.Canonical))
+		expired <- ref.String()
+		return nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	policies.scheduler = s
+
+	name, err := reference.WithName("charts/nginx")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var refs []reference.Canonical
+	for _, chart := range []string{"nginx-1.0.0", "nginx-1.1.0"} {
+		m := helmChart(t, chart)
+		policies.classify(digest.FromString(chart+" manifest"), m)
+		ref, err := reference.WithDigest(name, digest.FromString(chart))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := s.AddBlob(ref, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+		policies.cached(blobEntry, ref, 1000)
+		refs = append(refs, ref)
+	}
+
+	// the oldest chart expires, for the bucket to be within its quota
+	select {
+	case ref := <-expired:
+		if ref != refs[0].String() {
+			t.Fatalf("unexpected expiry of %s, expected %s", ref, refs[0])
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the oldest chart to expire")
+	}
+
+	stats := policies.stats()["helm"]
+	if stats.Entries != 1 || stats.Bytes != 1000 || stats.Quota != 1500 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
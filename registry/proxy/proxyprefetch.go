@@ -0,0 +1,178 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+)
+
+const defaultPrefetchConcurrency = 4
+
+// prefetchQueueLength bounds the blobs waiting to be prefetched. The blobs
+// referenced once the queue is full are left to be fetched on demand.
+const prefetchQueueLength = 256
+
+// layerPrefetchCounter counts the blobs referenced by the manifests cached
+// on a miss, by what became of their prefetch
+var layerPrefetchCounter = prometheus.ProxyNamespace.NewLabeledCounter("layer_prefetches", "The number of blobs prefetched along with the manifests cached on a miss", "result")
+
+// layerPrefetcher fetches the layers of the manifests cached on a miss in
+// the background, so that the requests for the layers which follow the
+// manifest are served from the cache rather than each fetching its layer
+// from the upstream. The blobs are queued for a fixed pool of workers, and
+// dropped when the queue is full. A nil prefetcher prefetches nothing.
+type layerPrefetcher struct {
+	queue    chan prefetchJob
+	maxBytes int64 // bounds the bytes being prefetched, unbounded if zero
+
+	stopOnce sync.Once
+	stopped  chan struct{} // closed when the registry drains
+
+	mu       sync.Mutex
+	released *sync.Cond // signaled when bytes are released
+	inflight int64
+}
+
+// prefetchJob is a blob queued to be prefetched into the cache of blobs.
+type prefetchJob struct {
+	ctx   context.Context
+	blobs *proxyBlobStore
+	desc  distribution.Descriptor
+}
+
+// newLayerPrefetcher returns the layer prefetcher configured, with its
+// workers started, nil if it is disabled.
+func newLayerPrefetcher(config configuration.ProxyLayerPrefetch) (*layerPrefetcher, error) {
+	if config.Concurrency < 0 {
+		return nil, fmt.Errorf("layer prefetch concurrency must not be negative")
+	}
+	if config.MaxBytes < 0 {
+		return nil, fmt.Errorf("layer prefetch maxbytes must not be negative")
+	}
+	if !config.Enabled {
+		return nil, nil
+	}
+
+	concurrency := config.Concurrency
+	if concurrency == 0 {
+		concurrency = defaultPrefetchConcurrency
+	}
+	lp := &layerPrefetcher{
+		queue:    make(chan prefetchJob, prefetchQueueLength),
+		maxBytes: config.MaxBytes,
+		stopped:  make(chan struct{}),
+	}
+	lp.released = sync.NewCond(&lp.mu)
+	for i := 0; i < concurrency; i++ {
+		go lp.work()
+	}
+	return lp, nil
+}
+
+// prefetch queues the blobs referenced by an image manifest to be fetched
+// in the background, within the byte budget of the prefetcher. It returns
+// at once, dropping the blobs which don't fit in the queue.
+func (lp *layerPrefetcher) prefetch(ctx context.Context, blobs *proxyBlobStore, manifest distribution.Manifest) {
+	if lp == nil {
+		return
+	}
+	// the children of an index are manifests, which are prefetched by
+	// platform, and the mirror jobs fetch the blobs themselves
+	if _, ok := manifest.(*manifestlist.DeserializedManifestList); ok || fetchReason(ctx) == fetchReasonMirror {
+		return
+	}
+	references := manifest.References()
+	if len(references) == 0 {
+		return
+	}
+
+	// the fetches outlive the request which cached the manifest
+	ctx = withFetchReason(dcontext.WithLogger(context.Background(), dcontext.GetLogger(ctx)), fetchReasonLayerPrefetch)
+	for _, desc := range references {
+		if lp.maxBytes > 0 && desc.Size > lp.maxBytes {
+			layerPrefetchCounter.WithValues("skipped").Inc(1)
+			continue
+		}
+		if lp.stopping() {
+			return
+		}
+
+		select {
+		case lp.queue <- prefetchJob{ctx: ctx, blobs: blobs, desc: desc}:
+		default:
+			layerPrefetchCounter.WithValues("dropped").Inc(1)
+		}
+	}
+}
+
+// work prefetches the queued blobs until the registry drains.
+func (lp *layerPrefetcher) work() {
+	for {
+		select {
+		case <-lp.stopped:
+			return
+		case job := <-lp.queue:
+			lp.reserve(job.desc.Size)
+			lp.fetch(job)
+			lp.release(job.desc.Size)
+		}
+	}
+}
+
+// fetch prefetches a queued blob. The fetch is tracked by the fetches of
+// the blob store, which the registry waits for when it drains.
+func (lp *layerPrefetcher) fetch(job prefetchJob) {
+	if lp.stopping() {
+		return
+	}
+	if err := job.blobs.fetch(job.ctx, job.desc.Digest); err != nil {
+		layerPrefetchCounter.WithValues("failed").Inc(1)
+		dcontext.GetLogger(job.ctx).Warnf("Error prefetching blob %s of %s: %s", job.desc.Digest, job.blobs.repositoryName.Name(), err)
+		return
+	}
+	layerPrefetchCounter.WithValues("fetched").Inc(1)
+}
+
+// stop stops the workers when the registry drains. The blobs still queued
+// are dropped, and those being fetched are drained with the other fetches.
+func (lp *layerPrefetcher) stop() {
+	if lp == nil {
+		return
+	}
+	lp.stopOnce.Do(func() { close(lp.stopped) })
+}
+
+// stopping reports whether the registry has started draining.
+func (lp *layerPrefetcher) stopping() bool {
+	select {
+	case <-lp.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// reserve waits until size bytes fit in the byte budget and reserves them.
+// A blob is always let through when nothing else is being prefetched.
+func (lp *layerPrefetcher) reserve(size int64) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	for lp.maxBytes > 0 && lp.inflight > 0 && lp.inflight+size > lp.maxBytes {
+		lp.released.Wait()
+	}
+	lp.inflight += size
+}
+
+// release returns size bytes reserved to the byte budget.
+func (lp *layerPrefetcher) release(size int64) {
+	lp.mu.Lock()
+	defer lp.mu.Unlock()
+	lp.inflight -= size
+	lp.released.Broadcast()
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestNewLayerPrefetcher(t *testing.T) {
+	lp, err := newLayerPrefetcher(configuration.ProxyLayerPrefetch{})
+	if err != nil || lp != nil {
+		t.Fatalf("unexpected prefetcher %v when disabled: %v", lp, err)
+	}
+	lp, err = newLayerPrefetcher(configuration.ProxyLayerPrefetch{Enabled: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lp.stop()
+	if cap(lp.queue) != prefetchQueueLength || lp.maxBytes != 0 {
+		t.Fatalf("unexpected defaults: queue %d, maxbytes %d", cap(lp.queue), lp.maxBytes)
+	}
+
+	for _, config := range []configuration.ProxyLayerPrefetch{
+		{Enabled: true, Concurrency: -1},
+		{Enabled: true, MaxBytes: -1},
+	} {
+		if _, err := newLayerPrefetcher(config); err == nil {
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+}
+
+func TestLayerPrefetch(t *testing.T) {
+	ctx := context.Background()
+	name, err := reference.WithName("library/debian")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteRegistry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteRepo, err := remoteRegistry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteManifests, err := remoteRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		config   configuration.ProxyLayerPrefetch
+		tag      string
+		prefetch bool
+	}{
+		{name: "disabled", tag: "12.0"},
+		{name: "enabled", config: configuration.ProxyLayerPrefetch{Enabled: true, Concurrency: 1}, tag: "12.1", prefetch: true},
+		// the layers of the image are larger than the byte budget
+		{name: "over budget", config: configuration.ProxyLayerPrefetch{Enabled: true, MaxBytes: 2}, tag: "12.2"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			image := testutil.PushImage(t, remoteRepo, tc.tag).Digests()
+
+			localRegistry, err := storage.NewRegistry(ctx, inmemory.New())
+			if err != nil {
+				t.Fatal(err)
+			}
+			localRepo, err := localRegistry.Repository(ctx, name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefetcher, err := newLayerPrefetcher(tc.config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer prefetcher.stop()
+
+			s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+			pms := proxyManifestStore{
+				ctx:             ctx,
+				localManifests:  localManifests,
+				remoteManifests: remoteManifests,
+				scheduler:       s,
+				repositoryName:  name,
+				authChallenger:  &mockChallenger{},
+				prefetcher:      prefetcher,
+				blobs: &proxyBlobStore{
+					localStore:     localRepo.Blobs(ctx),
+					remoteStore:    remoteRepo.Blobs(ctx),
+					scheduler:      s,
+					repositoryName: name,
+					authChallenger: &mockChallenger{},
+				},
+			}
+			if _, err := pms.Get(ctx, image[0]); err != nil {
+				t.Fatal(err)
+			}
+
+			deadline := time.Now().Add(5 * time.Second)
+			for _, dgst := range image[1:] {
+				for {
+					_, err := localRepo.Blobs(ctx).Stat(ctx, dgst)
+					if err == nil || !tc.prefetch || time.Now().After(deadline) {
+						if tc.prefetch && err != nil {
+							t.Fatalf("expected %s to be prefetched: %v", dgst, err)
+						}
+						if !tc.prefetch && err == nil {
+							t.Fatalf("expected %s not to be prefetched", dgst)
+						}
+						break
+					}
+					time.Sleep(10 * time.Millisecond)
+				}
+			}
+		})
+	}
+}
+
+func TestLayerPrefetchQueue(t *testing.T) {
+	ctx := context.Background()
+	manifest, err := schema2.FromStruct(schema2.Manifest{
+		Versioned: schema2.SchemaVersion,
+		Config:    distribution.Descriptor{MediaType: schema2.MediaTypeImageConfig, Digest: digest.FromString("config"), Size: 1},
+		Layers: []distribution.Descriptor{
+			{MediaType: schema2.MediaTypeLayer, Digest: digest.FromString("layer"), Size: 1},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the prefetcher has no worker, so that the queue fills up
+	lp := &layerPrefetcher{queue: make(chan prefetchJob, 1), stopped: make(chan struct{})}
+	lp.released = sync.NewCond(&lp.mu)
+	lp.prefetch(ctx, nil, manifest)
+	if len(lp.queue) != 1 {
+		t.Fatalf("expected the blobs beyond the queue to be dropped, got %d queued", len(lp.queue))
+	}
+
+	// once the registry drains, the workers return without fetching the
+	// blobs queued, whose store is nil, and no blob is queued any more
+	lp.stop()
+	lp.work()
+	for len(lp.queue) > 0 {
+		<-lp.queue
+	}
+	lp.prefetch(ctx, nil, manifest)
+	if len(lp.queue) != 0 {
+		t.Fatalf("expected nothing to be queued once stopped, got %d queued", len(lp.queue))
+	}
+}
@@ -0,0 +1,70 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+)
+
+// PruneNamespace removes every repository cached for the upstream host when
+// namespaces are enabled, where cached repositories are named after the host
+// they were pulled from (such as registry-1.docker.io/library/redis). It
+// returns the names of the removed repositories. When dryRun is set, the
+// repositories are only listed.
+//
+// Blobs are shared between repositories and are left in place, to be removed
+// by garbage collection.
+func PruneNamespace(ctx context.Context, registry distribution.Namespace, host string, dryRun bool) ([]string, error) {
+	host = strings.TrimSuffix(host, "/")
+	if host == "" {
+		return nil, fmt.Errorf("namespace must not be empty")
+	}
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+
+	enumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+	remover, ok := registry.(distribution.RepositoryRemover)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryRemover")
+	}
+
+	// Collect the names first, as removal during the walk would modify the
+	// tree being walked.
+	var names []string
+	err := enumerator.Enumerate(ctx, func(repoName string) error {
+		if strings.HasPrefix(repoName, host+"/") {
+			names = append(names, repoName)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to enumerate repositories: %v", err)
+	}
+
+	for _, repoName := range names {
+		if dryRun {
+			dcontext.GetLogger(ctx).Infof("would remove repository: %s", repoName)
+			continue
+		}
+
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+
+		dcontext.GetLogger(ctx).Infof("removing repository: %s", repoName)
+		if err := remover.Remove(ctx, named); err != nil {
+			return nil, fmt.Errorf("failed to remove repository %s: %v", repoName, err)
+		}
+	}
+
+	return names, nil
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestPruneNamespace(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	names := []string{
+		"registry-1.docker.io/library/redis",
+		"registry-1.docker.io/library/nginx",
+		"quay.io/coreos/etcd",
+		"registry-1.docker.io.example.com/foo",
+	}
+	for _, name := range names {
+		named, err := reference.WithName(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: digest.FromString(name)})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	listRepositories := func() []string {
+		var repos []string
+		err := registry.(distribution.RepositoryEnumerator).Enumerate(ctx, func(name string) error {
+			repos = append(repos, name)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sort.Strings(repos)
+		return repos
+	}
+
+	pruned, err := PruneNamespace(ctx, registry, "docker.io", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(pruned)
+	expected := []string{"registry-1.docker.io/library/nginx", "registry-1.docker.io/library/redis"}
+	if !reflect.DeepEqual(pruned, expected) {
+		t.Fatalf("unexpected pruned repositories: got %v, expected %v", pruned, expected)
+	}
+	if repos := listRepositories(); len(repos) != len(names) {
+		t.Fatalf("dry run removed repositories: %v", repos)
+	}
+
+	if _, err := PruneNamespace(ctx, registry, "registry-1.docker.io", false); err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"quay.io/coreos/etcd", "registry-1.docker.io.example.com/foo"}
+	if repos := listRepositories(); !reflect.DeepEqual(repos, expected) {
+		t.Fatalf("unexpected remaining repositories: got %v, expected %v", repos, expected)
+	}
+}
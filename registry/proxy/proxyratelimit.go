@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+const (
+	rateLimitLimitHeader     = "RateLimit-Limit"
+	rateLimitRemainingHeader = "RateLimit-Remaining"
+)
+
+var (
+	// rateLimitLimitGauge tracks the request quota advertised by the upstream
+	rateLimitLimitGauge = prometheus.ProxyNamespace.NewLabeledGauge("ratelimit_limit", "The request quota advertised by the upstream registry", metrics.Total, "remote")
+	// rateLimitRemainingGauge tracks the remaining requests advertised by the upstream
+	rateLimitRemainingGauge = prometheus.ProxyNamespace.NewLabeledGauge("ratelimit_remaining", "The remaining requests advertised by the upstream registry", metrics.Total, "remote")
+)
+
+func init() {
+	metrics.Register(prometheus.ProxyNamespace)
+}
+
+// rateLimitTransport records the rate limit headers returned by the upstream
+// registry (as sent by Docker Hub) and relays them to the client response
+// associated with the request context, if any.
+type rateLimitTransport struct {
+	base http.RoundTripper
+}
+
+func newRateLimitTransport(base http.RoundTripper) http.RoundTripper {
+	return &rateLimitTransport{base: base}
+}
+
+func (t *rateLimitTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return resp, err
+	}
+
+	limit := resp.Header.Get(rateLimitLimitHeader)
+	remaining := resp.Header.Get(rateLimitRemainingHeader)
+	if limit == "" && remaining == "" {
+		return resp, nil
+	}
+
+	if v, ok := parseRateLimit(limit); ok {
+		rateLimitLimitGauge.WithValues(req.URL.Host).Set(v)
+	}
+	if v, ok := parseRateLimit(remaining); ok {
+		rateLimitRemainingGauge.WithValues(req.URL.Host).Set(v)
+	}
+
+	if w, err := dcontext.GetResponseWriter(req.Context()); err == nil {
+		if limit != "" {
+			w.Header().Set(rateLimitLimitHeader, limit)
+		}
+		if remaining != "" {
+			w.Header().Set(rateLimitRemainingHeader, remaining)
+		}
+	}
+
+	return resp, nil
+}
+
+// parseRateLimit parses the quota from a rate limit header value of the form
+// "100;w=21600", ignoring the policy parameters.
+func parseRateLimit(value string) (float64, bool) {
+	quota, _, _ := strings.Cut(value, ";")
+	v, err := strconv.ParseFloat(strings.TrimSpace(quota), 64)
+	if err != nil {
+		return 0, false
+	}
+	return v, true
+}
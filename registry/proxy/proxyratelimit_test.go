@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+)
+
+func TestParseRateLimit(t *testing.T) {
+	for _, tc := range []struct {
+		value    string
+		expected float64
+		ok       bool
+	}{
+		{value: "100;w=21600", expected: 100, ok: true},
+		{value: "76", expected: 76, ok: true},
+		{value: " 5 ; w=60", expected: 5, ok: true},
+		{value: "", ok: false},
+		{value: "w=21600", ok: false},
+	} {
+		v, ok := parseRateLimit(tc.value)
+		if ok != tc.ok || v != tc.expected {
+			t.Errorf("parseRateLimit(%q) = %v, %v; expected %v, %v", tc.value, v, ok, tc.expected, tc.ok)
+		}
+	}
+}
+
+func TestRateLimitTransport(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ratelimit-limit", "100;w=21600")
+		w.Header().Set("ratelimit-remaining", "76;w=21600")
+	}))
+	defer upstream.Close()
+
+	recorder := httptest.NewRecorder()
+	ctx, _ := dcontext.WithResponseWriter(context.Background(), recorder)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, upstream.URL+"/v2/library/redis/manifests/latest", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := newRateLimitTransport(http.DefaultTransport).RoundTrip(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if v := recorder.Header().Get(rateLimitLimitHeader); v != "100;w=21600" {
+		t.Errorf("unexpected %s header: %q", rateLimitLimitHeader, v)
+	}
+	if v := recorder.Header().Get(rateLimitRemainingHeader); v != "76;w=21600" {
+		t.Errorf("unexpected %s header: %q", rateLimitRemainingHeader, v)
+	}
+}
@@ -0,0 +1,92 @@
+package proxy
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// proxyReferrerService lists referrers from the remote, caching the referring
+// manifests locally so they remain available when the remote is not. The
+// referrers pushed to the cache are listed along with them.
+type proxyReferrerService struct {
+	localReferrers  distribution.ReferrerService
+	remoteReferrers distribution.ReferrerService
+	manifests       distribution.ManifestService
+	authChallenger  authChallenger
+	locals          *localReferrers // nil unless referrers are pushed to the cache
+	repositoryName  reference.Named
+}
+
+var _ distribution.ReferrerService = proxyReferrerService{}
+
+// Referrers fetches the referrers of the subject from the remote and pulls
+// each referring manifest through the cache, which indexes it under the
+// subject locally. If the remote is unavailable the local index is used.
+func (prs proxyReferrerService) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	err := prs.authChallenger.tryEstablishChallenges(ctx)
+	if err == nil {
+		remoteCtx := withFetchReason(ctx, fetchReasonReferrer)
+		// The unfiltered list is requested so that all referrers are cached
+		referrers, err := prs.remoteReferrers.Referrers(remoteCtx, subject, "")
+		if err == nil {
+			for _, desc := range referrers {
+				if _, err := prs.manifests.Get(remoteCtx, desc.Digest); err != nil {
+					dcontext.GetLogger(ctx).Warnf("Error caching referrer %s of %s: %s", desc.Digest, subject, err)
+				}
+			}
+			local, err := prs.pushedReferrers(ctx, subject, artifactType)
+			if err != nil {
+				return nil, err
+			}
+			return append(filterReferrers(referrers, artifactType), local...), nil
+		}
+		dcontext.GetLogger(ctx).Debugf("Error listing remote referrers of %s, using local referrers: %s", subject, err)
+	}
+
+	if prs.localReferrers == nil {
+		return nil, distribution.ErrUnsupported
+	}
+	referrers, err := prs.localReferrers.Referrers(ctx, subject, artifactType)
+	if err != nil || prs.locals == nil || !prs.locals.applies(prs.repositoryName.Name()) {
+		return referrers, err
+	}
+	local, cached, err := prs.locals.annotate(ctx, prs.repositoryName.Name(), referrers)
+	if err != nil {
+		return nil, err
+	}
+	return append(cached, local...), nil
+}
+
+// pushedReferrers lists the referrers of the subject pushed to the cache,
+// annotated with AnnotationLocalReferrer.
+func (prs proxyReferrerService) pushedReferrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	if prs.localReferrers == nil || prs.locals == nil || !prs.locals.applies(prs.repositoryName.Name()) {
+		return nil, nil
+	}
+	referrers, err := prs.localReferrers.Referrers(ctx, subject, artifactType)
+	if err != nil {
+		return nil, err
+	}
+	local, _, err := prs.locals.annotate(ctx, prs.repositoryName.Name(), referrers)
+	return local, err
+}
+
+// filterReferrers returns the referrers with the given artifact type, or all
+// referrers if artifactType is empty.
+func filterReferrers(referrers []distribution.Descriptor, artifactType string) []distribution.Descriptor {
+	if artifactType == "" {
+		return referrers
+	}
+
+	filtered := make([]distribution.Descriptor, 0, len(referrers))
+	for _, desc := range referrers {
+		if desc.ArtifactType == artifactType {
+			filtered = append(filtered, desc)
+		}
+	}
+	return filtered
+}
@@ -0,0 +1,85 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+type mockReferrerService struct {
+	referrers map[digest.Digest][]distribution.Descriptor
+	err       error
+}
+
+func (m *mockReferrerService) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	return filterReferrers(m.referrers[subject], artifactType), nil
+}
+
+type countingManifests struct {
+	distribution.ManifestService
+	gets map[digest.Digest]int
+}
+
+func (m *countingManifests) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	m.gets[dgst]++
+	return nil, nil
+}
+
+func TestProxyReferrers(t *testing.T) {
+	ctx := context.Background()
+	subject := digest.FromString("subject")
+	signature := distribution.Descriptor{Digest: digest.FromString("signature"), ArtifactType: "application/vnd.example.signature"}
+	sbom := distribution.Descriptor{Digest: digest.FromString("sbom"), ArtifactType: "application/vnd.example.sbom"}
+
+	remote := &mockReferrerService{
+		referrers: map[digest.Digest][]distribution.Descriptor{
+			subject: {signature, sbom},
+		},
+	}
+	local := &mockReferrerService{
+		referrers: map[digest.Digest][]distribution.Descriptor{
+			subject: {signature},
+		},
+	}
+	manifests := &countingManifests{gets: make(map[digest.Digest]int)}
+
+	prs := proxyReferrerService{
+		localReferrers:  local,
+		remoteReferrers: remote,
+		manifests:       manifests,
+		authChallenger:  &mockChallenger{},
+	}
+
+	referrers, err := prs.Referrers(ctx, subject, "application/vnd.example.sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sbom.Digest {
+		t.Fatalf("unexpected referrers: %v", referrers)
+	}
+
+	// All referrers are pulled through the cache, regardless of the filter
+	if manifests.gets[signature.Digest] != 1 || manifests.gets[sbom.Digest] != 1 {
+		t.Fatalf("expected all referrers to be cached, got %v", manifests.gets)
+	}
+
+	// When the remote fails, the local referrers are served
+	remote.err = errors.New("remote unavailable")
+	referrers, err = prs.Referrers(ctx, subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != signature.Digest {
+		t.Fatalf("unexpected local referrers: %v", referrers)
+	}
+
+	if prs.authChallenger.(*mockChallenger).count != 2 {
+		t.Fatalf("Expected 2 auth challenges, got %#v", prs.authChallenger)
+	}
+}
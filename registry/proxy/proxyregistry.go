@@ -20,6 +20,8 @@ import (
 	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/tracing"
+	"github.com/opencontainers/go-digest"
 )
 
 // proxyingRegistry fetches content from a remote registry and caches it locally
@@ -29,17 +31,154 @@ type proxyingRegistry struct {
 	remoteURL        url.URL
 	enableNamespaces bool
 	authChallenger   authChallenger
+	platforms        []platform
+	platformPins     *platformPins
+	prefetcher       *layerPrefetcher
+	tagLists         *tagListCache
+	trustPolicies    map[string]*trustPolicy
+	trusted          *trustedDigests
+	transports       *upstreamTransports
+	resolvers        upstreamResolvers
+	auths            upstreamAuths
+	policies         *artifactPolicies
+	retry            *retryPolicy
+	audit            *auditLogger
+	stats            *statsCollector
+	fetchOnRange     bool
+	converter        *imageConverter
+	schema1Converter *schema1Converter
+	fetches          *fetchTracker
+	mirrorStop       chan struct{}
+	notifier         *eventNotifier
+	fallback         *anonymousFallback // nil unless rejected credentials fall back to anonymous pulls
+	archive          *coldArchive
+	locals           *localReferrers
+	objectTags       *objectTags
+
+	mu         sync.RWMutex // protects namespaces, which are replaced by a reload
+	namespaces []Namespace
 }
 
 // NewRegistryPullThroughCache creates a registry acting as a pull through cache
-func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy) (distribution.Namespace, error) {
+func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Namespace, driver driver.StorageDriver, config configuration.Proxy, options ...Option) (distribution.Namespace, error) {
+	var opts cacheOptions
+	for _, option := range options {
+		option(&opts)
+	}
+	notifier := opts.notifier
+
 	remoteURL, err := url.Parse(config.RemoteURL)
 	if err != nil {
 		return nil, err
 	}
 
+	platforms, err := parsePlatforms(config.Platforms)
+	if err != nil {
+		return nil, err
+	}
+
+	prefetcher, err := newLayerPrefetcher(config.LayerPrefetch)
+	if err != nil {
+		return nil, err
+	}
+
+	trustPolicies, err := parseTrustPolicies(config.TrustPolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	transports, err := parseTransports(config.Transports, config.Pool)
+	if err != nil {
+		return nil, err
+	}
+
+	resolvers, err := parseResolvers(config.Remotes)
+	if err != nil {
+		return nil, err
+	}
+
+	auths, err := parseAuths(config.Auth)
+	if err != nil {
+		return nil, err
+	}
+
+	pins, err := parsePins(config.PinnedRepositories)
+	if err != nil {
+		return nil, err
+	}
+
+	policies, err := parseArtifactPolicies(config.ArtifactPolicies)
+	if err != nil {
+		return nil, err
+	}
+
+	mirrorJobs, err := parseMirrorJobs(config.MirrorJobs, config.EnableNamespaces)
+	if err != nil {
+		return nil, err
+	}
+
+	namespaces, err := configuredNamespaces(config, resolvers)
+	if err != nil {
+		return nil, err
+	}
+
+	audit, err := newAuditLogger(config.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+
+	converter, err := newImageConverter(config.Conversion)
+	if err != nil {
+		return nil, err
+	}
+
+	schema1Converter, err := newSchema1Converter(config.Conversion)
+	if err != nil {
+		return nil, err
+	}
+
+	fetches, err := newFetchTracker(ctx, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	archive, err := newColdArchive(config.Archive)
+	if err != nil {
+		return nil, err
+	}
+
+	locals, err := newLocalReferrers(config.LocalReferrers, driver)
+	if err != nil {
+		return nil, err
+	}
+
+	objectTags, err := newObjectTags(config.ObjectTags)
+	if err != nil {
+		return nil, err
+	}
+
+	stats := newStatsCollector()
 	v := storage.NewVacuum(ctx, driver)
-	s := scheduler.New(ctx, driver, "/scheduler-state.json")
+	s := scheduler.New(ctx, driver, schedulerStatePath)
+	if policies != nil {
+		policies.scheduler = s
+	}
+	platformPins := newPlatformPins(platforms)
+	// isPinned reports whether content is pinned, by the pins of the cache or
+	// by those of the policy of its artifact, or held by a referrer pushed to
+	// the cache or by the cached index it was prefetched for
+	isPinned := func(repo distribution.Repository, dgst digest.Digest) (bool, error) {
+		if held, err := locals.holds(ctx, repo.Named().Name(), dgst); err != nil || held {
+			return held, err
+		}
+		if pinned, err := platformPins.pinned(ctx, repo, dgst); err != nil || pinned {
+			return pinned, err
+		}
+		if pinned, err := pins.pinned(ctx, repo, dgst); err != nil || pinned {
+			return pinned, err
+		}
+		return policies.pinned(ctx, repo, dgst)
+	}
 	s.OnBlobExpire(func(ref reference.Reference) error {
 		var r reference.Canonical
 		var ok bool
@@ -52,19 +191,47 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 			return err
 		}
 
+		if pinned, err := isPinned(repo, r.Digest()); err != nil {
+			return err
+		} else if pinned {
+			// The expiring entry is removed once this returns, so it is
+			// added back when the scheduler is unlocked. The pin is checked
+			// again at the next expiry, as a pinned tag may have moved.
+			go s.AddBlob(r, policies.ttl(r.Digest()))
+			policies.kept(r)
+			return nil
+		}
+
 		blobs := repo.Blobs(ctx)
 
-		// Clear the repository reference and descriptor caches
-		err = blobs.Delete(ctx, r.Digest())
+		// The content may have been mounted into other repositories
+		linked, err := linkedElsewhere(ctx, registry, r)
 		if err != nil {
 			return err
 		}
 
-		err = v.RemoveBlob(r.Digest().String())
+		// The content is kept until it is archived
+		if err := archive.store(ctx, r, blobs, !linked); err != nil {
+			go s.AddBlob(r, archiveRetryInterval)
+			return fmt.Errorf("failed to archive %s: %v", r, err)
+		}
+
+		// Clear the repository reference and descriptor caches
+		err = blobs.Delete(ctx, r.Digest())
 		if err != nil {
 			return err
 		}
 
+		if !linked {
+			err = v.RemoveBlob(r.Digest().String())
+			if err != nil {
+				return err
+			}
+		}
+
+		stats.expired(blobEntry, r.String())
+		policies.expired(r)
+		notifier.evicted(ctx, r)
 		return nil
 	})
 
@@ -80,6 +247,14 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 			return err
 		}
 
+		if pinned, err := isPinned(repo, r.Digest()); err != nil {
+			return err
+		} else if pinned {
+			go s.AddManifest(r, policies.ttl(r.Digest()))
+			policies.kept(r)
+			return nil
+		}
+
 		manifests, err := repo.Manifests(ctx)
 		if err != nil {
 			return err
@@ -88,29 +263,32 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 		if err != nil {
 			return err
 		}
+
+		stats.expired(manifestEntry, r.String())
+		policies.expired(r)
+		notifier.evicted(ctx, r)
 		return nil
 	})
 
+	s.OnRebuild(rebuildSchedulerState(registry, policies, locals))
+
 	err = s.Start()
 	if err != nil {
 		return nil, err
 	}
+	publishScheduler(s)
 
-	if !config.EnableNamespaces {
-		config.NamespaceCredentials = map[string]configuration.ProxyCredential{
-			config.RemoteURL: {
-				Username: config.Username,
-				Password: config.Password,
-			},
-		}
+	cs, err := configureAuth(upstreamCredentials(config), transports, resolvers)
+	if err != nil {
+		return nil, err
 	}
 
-	cs, err := configureAuth(config.NamespaceCredentials)
+	cm, err := newChallengeManager(config.Challenges, opts.redis)
 	if err != nil {
 		return nil, err
 	}
 
-	return &proxyingRegistry{
+	pr := &proxyingRegistry{
 		embedded:         registry,
 		scheduler:        s,
 		remoteURL:        *remoteURL,
@@ -118,10 +296,63 @@ func NewRegistryPullThroughCache(ctx context.Context, registry distribution.Name
 		authChallenger: &remoteAuthChallenger{
 			remoteURL:        *remoteURL,
 			enableNamespaces: config.EnableNamespaces,
-			cm:               challenge.NewSimpleManager(),
+			cm:               cm,
+			pings:            transport.NewResponseCache(),
 			cs:               cs,
+			transports:       transports,
+			resolvers:        resolvers,
 		},
-	}, nil
+		platforms:        platforms,
+		platformPins:     platformPins,
+		prefetcher:       prefetcher,
+		tagLists:         newTagListCache(config.TagListTTL),
+		trustPolicies:    trustPolicies,
+		trusted:          newTrustedDigests(),
+		transports:       transports,
+		resolvers:        resolvers,
+		auths:            auths,
+		policies:         policies,
+		retry:            newRetryPolicy(config.Retry),
+		audit:            audit,
+		stats:            stats,
+		fetchOnRange:     config.FetchOnRange,
+		converter:        converter,
+		schema1Converter: schema1Converter,
+		fetches:          fetches,
+		namespaces:       namespaces,
+		mirrorStop:       make(chan struct{}),
+		notifier:         notifier,
+		archive:          archive,
+		locals:           locals,
+		objectTags:       objectTags,
+	}
+	if config.AnonymousFallback {
+		pr.fallback = newAnonymousFallback()
+	}
+	pr.startMirrorJobs(ctx, mirrorJobs)
+	return pr, nil
+}
+
+// Drain stops the mirror jobs and the layer prefetch and waits for the blobs
+// being cached in the background, checkpointing the uploads of those not
+// cached when ctx is done, and stops the scheduler.
+func (pr *proxyingRegistry) Drain(ctx context.Context) error {
+	close(pr.mirrorStop)
+	pr.prefetcher.stop()
+	err := pr.fetches.drain(ctx)
+	pr.scheduler.Stop()
+	return err
+}
+
+// hasCredentials reports whether credentials are configured for the
+// upstream host.
+func (pr *proxyingRegistry) hasCredentials(host string) bool {
+	for _, namespace := range pr.ProxyNamespaces() {
+		if namespace.Name == host {
+			return namespace.Credentials
+		}
+	}
+	return false
 }
 
 func (pr *proxyingRegistry) Scope() distribution.Scope {
@@ -150,21 +381,42 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		}
 	}
 
-	tkopts := auth.TokenHandlerOptions{
-		Transport:   http.DefaultTransport,
-		Credentials: c.credentialStore(),
-		Scopes: []auth.Scope{
-			auth.RepositoryScope{
-				Repository: name.Name(),
-				Actions:    []string{"pull"},
-			},
-		},
-		Logger: dcontext.GetLogger(ctx),
+	// the upstream calls, including those of the token handler, are traced
+	upstreamTransport := tracing.Transport(pr.transports.forHost(remoteURL.Host))
+	upstreamAuth := pr.auths.forHost(remoteURL.Host)
+	authorizedTransport := func(credentials auth.CredentialStore) http.RoundTripper {
+		switch upstreamAuth.mode {
+		case authModeAnonymous:
+			return transport.NewTransport(newRateLimitTransport(upstreamTransport))
+		case authModeBasic:
+			if credentials == nil {
+				return transport.NewTransport(newRateLimitTransport(upstreamTransport))
+			}
+			return transport.NewTransport(newRateLimitTransport(upstreamTransport),
+				basicAuthModifier{host: remoteURL.Host, challenger: c})
+		}
+
+		tkopts := auth.TokenHandlerOptions{
+			Transport:   upstreamTransport,
+			Credentials: credentials,
+			Scopes:      upstreamAuth.tokenScopes(name.Name()),
+			Logger:      dcontext.GetLogger(ctx),
+		}
+
+		return transport.NewTransport(newRateLimitTransport(upstreamTransport),
+			auth.NewAuthorizer(c.challengeManager(),
+				auth.NewTokenHandlerWithOptions(tkopts)))
 	}
 
-	tr := transport.NewTransport(http.DefaultTransport,
-		auth.NewAuthorizer(c.challengeManager(),
-			auth.NewTokenHandlerWithOptions(tkopts)))
+	tr := authorizedTransport(c.credentialStore())
+	if pr.fallback != nil && upstreamAuth.mode != authModeAnonymous && pr.hasCredentials(remoteURL.Host) {
+		tr = &fallbackTransport{
+			host:          remoteURL.Host,
+			fallback:      pr.fallback,
+			authenticated: tr,
+			anonymous:     authorizedTransport(nil),
+		}
+	}
 
 	localRepo, err := pr.embedded.Repository(ctx, localName)
 	if err != nil {
@@ -175,7 +427,12 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
-	remoteRepo, err := client.NewRepository(name, remoteURL.String(), tr)
+	baseURL, err := pr.resolvers.repositoryBaseURL(remoteURL)
+	if err != nil {
+		return nil, err
+	}
+
+	remoteRepo, err := client.NewRepository(name, baseURL, tr)
 	if err != nil {
 		return nil, err
 	}
@@ -185,31 +442,150 @@ func (pr *proxyingRegistry) Repository(ctx context.Context, name reference.Named
 		return nil, err
 	}
 
-	return &proxiedRepository{
-		blobStore: &proxyBlobStore{
-			localStore:     localRepo.Blobs(ctx),
-			remoteStore:    remoteRepo.Blobs(ctx),
-			scheduler:      pr.scheduler,
-			repositoryName: localName,
-			authChallenger: pr.authChallenger,
-		},
-		manifests: &proxyManifestStore{
+	var remoteBlobs distribution.BlobService = remoteRepo.Blobs(ctx)
+	if pr.retry != nil {
+		remoteManifests = retryingManifestService{ManifestService: remoteManifests, policy: pr.retry}
+		remoteBlobs = retryingBlobService{BlobService: remoteBlobs, policy: pr.retry}
+	}
+
+	remoteTags := remoteRepo.Tags(ctx)
+	remoteReferrers, _ := remoteRepo.(distribution.ReferrerService)
+	if pr.audit != nil {
+		a := auditor{logger: pr.audit, namespace: remoteURL.Host, repository: name.Name()}
+		remoteManifests = auditingManifestService{ManifestService: remoteManifests, auditor: a}
+		remoteBlobs = auditingBlobService{BlobService: remoteBlobs, auditor: a}
+		remoteTags = auditingTagService{TagService: remoteTags, auditor: a}
+		if remoteReferrers != nil {
+			remoteReferrers = auditingReferrerService{ReferrerService: remoteReferrers, auditor: a}
+		}
+	}
+
+	blobStore := &proxyBlobStore{
+		localStore:     localRepo.Blobs(ctx),
+		remoteStore:    remoteBlobs,
+		globalBlobs:    pr.embedded.BlobStatter(),
+		scheduler:      pr.scheduler,
+		repositoryName: localName,
+		authChallenger: pr.authChallenger,
+		namespace:      remoteURL.Host,
+		stats:          pr.stats,
+		policies:       pr.policies,
+		fetchOnRange:   pr.fetchOnRange,
+		fetches:        pr.fetches,
+		notifier:       pr.notifier,
+		archive:        pr.archive,
+		locals:         pr.locals,
+		objectTags:     pr.objectTags,
+	}
+
+	manifests := &proxyManifestStore{
+		repositoryName:  localName,
+		localManifests:  localManifests, // Options?
+		remoteManifests: remoteManifests,
+		ctx:             ctx,
+		scheduler:       pr.scheduler,
+		authChallenger:  pr.authChallenger,
+		platforms:       pr.platforms,
+		platformPins:    pr.platformPins,
+		fetches:         pr.fetches,
+		namespace:       remoteURL.Host,
+		stats:           pr.stats,
+		policies:        pr.policies,
+		notifier:        pr.notifier,
+		prefetcher:      pr.prefetcher,
+		blobs:           blobStore,
+		locals:          pr.locals,
+		objectTags:      pr.objectTags,
+	}
+	if pr.locals.applies(localName.Name()) {
+		// unlike the content pulled from the upstream, the blobs of the
+		// referrers pushed are verified
+		manifests.referrerManifests, err = localRepo.Manifests(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if policy, ok := pr.trustPolicies[remoteURL.Host]; ok && policy.applies(name.Name()) {
+		manifests.verifier = &signatureVerifier{
+			policy:     policy,
+			repository: localName.Name(),
+			tags:       remoteTags,
+			manifests:  remoteManifests,
+			blobs:      remoteBlobs,
+			trusted:    pr.trusted,
+		}
+	}
+
+	if pr.converter != nil {
+		manifests.conversion = &imageConversion{
+			converter:       pr.converter,
+			repositoryName:  localName,
+			manifests:       localManifests,
+			remoteManifests: remoteManifests,
+			blobs:           localRepo.Blobs(ctx),
+			remoteBlobs:     remoteBlobs,
+			tags:            localRepo.Tags(ctx),
+			scheduler:       pr.scheduler,
+			platforms:       pr.platforms,
+			namespace:       remoteURL.Host,
+			stats:           pr.stats,
+			objectTags:      pr.objectTags,
+		}
+	}
+
+	tags := &proxyTagService{
+		localTags:      localRepo.Tags(ctx),
+		remoteTags:     remoteTags,
+		authChallenger: pr.authChallenger,
+		repositoryName: localName,
+		tagLists:       pr.tagLists,
+	}
+	if pr.schema1Converter != nil {
+		tags.schema1 = &schema1Conversion{
+			converter:       pr.schema1Converter,
 			repositoryName:  localName,
-			localManifests:  localManifests, // Options?
+			manifests:       localManifests,
 			remoteManifests: remoteManifests,
-			ctx:             ctx,
+			blobs:           localRepo.Blobs(ctx),
+			remoteBlobs:     remoteBlobs,
+			verifier:        manifests.verifier,
 			scheduler:       pr.scheduler,
+			namespace:       remoteURL.Host,
+			stats:           pr.stats,
+			objectTags:      pr.objectTags,
+		}
+	}
+
+	// The local repository may not support referrers if it is wrapped by
+	// registry middleware.
+	localReferrers, _ := localRepo.(distribution.ReferrerService)
+
+	return &proxiedRepository{
+		blobStore: blobStore,
+		manifests: manifests,
+		name:      name,
+		tags:      tags,
+		referrers: proxyReferrerService{
+			localReferrers:  localReferrers,
+			remoteReferrers: remoteReferrers,
+			manifests:       manifests,
 			authChallenger:  pr.authChallenger,
-		},
-		name: name,
-		tags: &proxyTagService{
-			localTags:      localRepo.Tags(ctx),
-			remoteTags:     remoteRepo.Tags(ctx),
-			authChallenger: pr.authChallenger,
+			locals:          pr.locals,
+			repositoryName:  localName,
 		},
 	}, nil
 }
 
+// ProxyStats reports the statistics of the cache per upstream host.
+func (pr *proxyingRegistry) ProxyStats() Stats {
+	return Stats{
+		Namespaces:       pr.stats.stats(),
+		SchedulerBacklog: pr.scheduler.Len(),
+		Policies:         pr.policies.stats(),
+	}
+}
+
 func (pr *proxyingRegistry) Blobs() distribution.BlobEnumerator {
 	return pr.embedded.Blobs()
 }
@@ -229,11 +605,18 @@ type remoteAuthChallenger struct {
 	remoteURL        url.URL
 	enableNamespaces bool
 	sync.Mutex
-	cm challenge.Manager
-	cs auth.CredentialStore
+	cm         challenge.Manager
+	pings      *transport.ResponseCache // responses to the pings, as long as the upstreams allow
+	transports *upstreamTransports
+	resolvers  upstreamResolvers
+
+	csMu sync.RWMutex // protects cs, which is replaced by a reload
+	cs   auth.CredentialStore
 }
 
 func (r *remoteAuthChallenger) credentialStore() auth.CredentialStore {
+	r.csMu.RLock()
+	defer r.csMu.RUnlock()
 	return r.cs
 }
 
@@ -255,7 +638,11 @@ func (r *remoteAuthChallenger) tryEstablishChallenges(ctx context.Context) error
 		remoteURL = requestRemoteNSURL
 	}
 
-	remoteURL.Path = "/v2/"
+	remoteURL, err := r.resolvers.pingURL(remoteURL)
+	if err != nil {
+		return err
+	}
+
 	challenges, err := r.cm.GetChallenges(remoteURL)
 	if err != nil {
 		return err
@@ -266,7 +653,7 @@ func (r *remoteAuthChallenger) tryEstablishChallenges(ctx context.Context) error
 	}
 
 	// establish challenge type with upstream
-	if err := ping(r.cm, remoteURL.String(), challengeHeader); err != nil {
+	if err := ping(r.cm, remoteURL.String(), challengeHeader, transport.NewCacheTransport(r.transports.forHost(remoteURL.Host), r.pings)); err != nil {
 		return err
 	}
 
@@ -282,6 +669,7 @@ type proxiedRepository struct {
 	manifests distribution.ManifestService
 	name      reference.Named
 	tags      distribution.TagService
+	referrers distribution.ReferrerService
 }
 
 func (pr *proxiedRepository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
@@ -300,7 +688,15 @@ func (pr *proxiedRepository) Tags(ctx context.Context) distribution.TagService {
 	return pr.tags
 }
 
+func (pr *proxiedRepository) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	return pr.referrers.Referrers(ctx, subject, artifactType)
+}
+
 func extractRemoteURL(ctx context.Context) (url.URL, reference.Named, error) {
+	if target, ok := ctx.Value(mirrorTargetKey{}).(mirrorTarget); ok {
+		return target.remoteURL, target.name, nil
+	}
+
 	r, err := dcontext.GetRequest(ctx)
 	if err != nil {
 		return url.URL{}, nil, err
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// Reloader is implemented by registries acting as a pull through cache.
+type Reloader interface {
+	// Reload applies the credentials of the upstreams and the TTL of the tag
+	// listings of the configuration, without interrupting the requests in
+	// progress. The other options of the configuration are ignored, but the
+	// upstreams must be those of the running cache.
+	Reload(config configuration.Proxy) error
+}
+
+// upstreamCredentials returns the configured credentials, keyed by upstream.
+func upstreamCredentials(config configuration.Proxy) map[string]configuration.ProxyCredential {
+	if !config.EnableNamespaces {
+		return map[string]configuration.ProxyCredential{
+			config.RemoteURL: {
+				Username: config.Username,
+				Password: config.Password,
+			},
+		}
+	}
+	return config.NamespaceCredentials
+}
+
+// Reload applies the credentials and tag list TTL of the configuration.
+func (pr *proxyingRegistry) Reload(config configuration.Proxy) error {
+	if config.EnableNamespaces != pr.enableNamespaces {
+		return fmt.Errorf("proxy: enablenamespaces can't be changed without a restart")
+	}
+	if remoteURL, err := url.Parse(config.RemoteURL); err != nil || remoteURL.String() != pr.remoteURL.String() {
+		return fmt.Errorf("proxy: remoteurl can't be changed without a restart")
+	}
+	namespaces, err := configuredNamespaces(config, pr.resolvers)
+	if err != nil {
+		return err
+	}
+	current := pr.ProxyNamespaces()
+	if len(namespaces) != len(current) {
+		return fmt.Errorf("proxy: the upstreams can't be changed without a restart")
+	}
+	for i := range namespaces {
+		if namespaces[i].Name != current[i].Name || namespaces[i].URL != current[i].URL {
+			return fmt.Errorf("proxy: the upstreams can't be changed without a restart")
+		}
+	}
+
+	// the token servers of the upstreams are discovered again, in case the
+	// credentials were added to an anonymous upstream
+	cs, err := configureAuth(upstreamCredentials(config), pr.transports, pr.resolvers)
+	if err != nil {
+		return err
+	}
+	if c, ok := pr.authChallenger.(*remoteAuthChallenger); ok {
+		c.csMu.Lock()
+		c.cs = cs
+		c.csMu.Unlock()
+	}
+
+	pr.mu.Lock()
+	pr.namespaces = namespaces
+	pr.mu.Unlock()
+
+	if pr.fallback != nil {
+		pr.fallback.retry()
+	}
+	pr.tagLists.setTTL(config.TagListTTL)
+	return nil
+}
@@ -0,0 +1,73 @@
+package proxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestReload(t *testing.T) {
+	var upstream *httptest.Server
+	upstream = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("WWW-Authenticate", `Bearer realm="`+upstream.URL+`/token",service="registry"`)
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer upstream.Close()
+
+	config := configuration.Proxy{RemoteURL: upstream.URL}
+	remoteURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces, err := configuredNamespaces(config, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cs, err := configureAuth(upstreamCredentials(config), nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenger := &remoteAuthChallenger{remoteURL: *remoteURL, cs: cs}
+	pr := &proxyingRegistry{
+		remoteURL:      *remoteURL,
+		authChallenger: challenger,
+		tagLists:       newTagListCache(0),
+		fallback:       newAnonymousFallback(),
+		namespaces:     namespaces,
+	}
+	pr.fallback.reject(context.Background(), remoteURL.Host)
+
+	config.Username, config.Password = "user", "rotated"
+	config.TagListTTL = time.Minute
+	if err := pr.Reload(config); err != nil {
+		t.Fatalf("unexpected error reloading: %v", err)
+	}
+	tokenURL, _ := url.Parse(upstream.URL + "/token")
+	if username, password := challenger.credentialStore().Basic(tokenURL); username != "user" || password != "rotated" {
+		t.Fatalf("unexpected credentials after reloading: %s:%s", username, password)
+	}
+	if !pr.hasCredentials(remoteURL.Host) {
+		t.Fatal("expected the upstream to have credentials after reloading")
+	}
+	if !pr.fallback.useCredentials(remoteURL.Host) {
+		t.Fatal("expected the rotated credentials to be tried")
+	}
+	if pr.tagLists.ttl != time.Minute {
+		t.Fatalf("unexpected tag list TTL after reloading: %v", pr.tagLists.ttl)
+	}
+
+	config.RemoteURL = "https://registry-1.docker.io"
+	if err := pr.Reload(config); err == nil {
+		t.Fatal("expected an error changing the upstream")
+	}
+	config.RemoteURL = upstream.URL
+	config.EnableNamespaces = true
+	if err := pr.Reload(config); err == nil {
+		t.Fatal("expected an error enabling namespaces")
+	}
+}
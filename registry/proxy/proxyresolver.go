@@ -0,0 +1,124 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// Resolver locates the registry API of upstreams which do not serve it at
+// the root of their host, such as registries behind a repository manager.
+type Resolver interface {
+	// BaseURL returns the URL under which the upstream serves /v2/.
+	BaseURL(upstream url.URL) (url.URL, error)
+}
+
+// ResolverInitFunc creates a Resolver from the options of a proxy remote.
+type ResolverInitFunc func(options map[string]interface{}) (Resolver, error)
+
+var resolvers = make(map[string]ResolverInitFunc)
+
+// RegisterResolver makes a resolver available by name to the remotes of the
+// proxy configuration. It is meant to be called from init functions.
+func RegisterResolver(name string, initFunc ResolverInitFunc) error {
+	if _, exists := resolvers[name]; exists {
+		return fmt.Errorf("resolver already registered with name %q", name)
+	}
+	resolvers[name] = initFunc
+	return nil
+}
+
+func init() {
+	if err := RegisterResolver("artifactory", newArtifactoryResolver); err != nil {
+		panic(err)
+	}
+}
+
+// pathPrefixResolver serves the registry API of upstreams under a fixed path.
+type pathPrefixResolver string
+
+func (prefix pathPrefixResolver) BaseURL(upstream url.URL) (url.URL, error) {
+	upstream.Path = strings.TrimSuffix(path.Join("/", string(prefix)), "/")
+	upstream.RawPath = ""
+	return upstream, nil
+}
+
+// newArtifactoryResolver returns the resolver for an Artifactory Docker
+// repository, which serves the registry API under
+// /artifactory/api/docker/<repository>.
+func newArtifactoryResolver(options map[string]interface{}) (Resolver, error) {
+	repository, ok := options["repository"].(string)
+	if !ok || repository == "" {
+		return nil, errors.New("artifactory resolver requires a repository option")
+	}
+	return pathPrefixResolver("/artifactory/api/docker/" + repository), nil
+}
+
+// upstreamResolvers holds the resolvers of upstream hosts which do not serve
+// the registry API at their root.
+type upstreamResolvers map[string]Resolver
+
+// parseResolvers builds the resolvers for the configured remotes.
+func parseResolvers(config map[string]configuration.ProxyRemote) (upstreamResolvers, error) {
+	resolved := make(upstreamResolvers, len(config))
+	for key, remote := range config {
+		host := upstreamHost(key)
+
+		switch {
+		case remote.Resolver != "" && remote.PathPrefix != "":
+			return nil, fmt.Errorf("remote %s has both a path prefix and a resolver", host)
+		case remote.Resolver != "":
+			initFunc, ok := resolvers[remote.Resolver]
+			if !ok {
+				return nil, fmt.Errorf("remote %s uses unknown resolver %q", host, remote.Resolver)
+			}
+			resolver, err := initFunc(remote.Options)
+			if err != nil {
+				return nil, fmt.Errorf("remote %s: %v", host, err)
+			}
+			resolved[host] = resolver
+		case remote.PathPrefix != "":
+			resolved[host] = pathPrefixResolver(remote.PathPrefix)
+		}
+	}
+	return resolved, nil
+}
+
+// baseURL returns the URL under which the upstream serves /v2/. Upstreams
+// without a resolver serve it at their root.
+func (ur upstreamResolvers) baseURL(upstream url.URL) (url.URL, error) {
+	resolver, ok := ur[upstream.Host]
+	if !ok {
+		upstream.Path = ""
+		upstream.RawPath = ""
+		return upstream, nil
+	}
+	return resolver.BaseURL(upstream)
+}
+
+// repositoryBaseURL returns the base URL passed to the registry client, which
+// resolves the API paths relative to it.
+func (ur upstreamResolvers) repositoryBaseURL(upstream url.URL) (string, error) {
+	base, err := ur.baseURL(upstream)
+	if err != nil {
+		return "", err
+	}
+	if base.Path != "" {
+		base.Path += "/"
+	}
+	return base.String(), nil
+}
+
+// pingURL returns the URL of the API version check of the upstream.
+func (ur upstreamResolvers) pingURL(upstream url.URL) (url.URL, error) {
+	base, err := ur.baseURL(upstream)
+	if err != nil {
+		return url.URL{}, err
+	}
+	base.Path += "/v2/"
+	return base, nil
+}
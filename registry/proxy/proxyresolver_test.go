@@ -0,0 +1,103 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/client"
+)
+
+func TestParseResolvers(t *testing.T) {
+	resolvers, err := parseResolvers(map[string]configuration.ProxyRemote{
+		"https://prefixed.example.com": {PathPrefix: "/registry/"},
+		"artifactory.example.com": {
+			Resolver: "artifactory",
+			Options:  configuration.Parameters{"repository": "docker-remote"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for upstream, expected := range map[string]string{
+		"https://prefixed.example.com":    "https://prefixed.example.com/registry/v2/",
+		"https://artifactory.example.com": "https://artifactory.example.com/artifactory/api/docker/docker-remote/v2/",
+		"https://quay.io/":                "https://quay.io/v2/",
+	} {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pingURL, err := resolvers.pingURL(*u)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pingURL.String() != expected {
+			t.Errorf("%s: expected ping URL %s, got %s", upstream, expected, pingURL.String())
+		}
+	}
+
+	for _, remotes := range []map[string]configuration.ProxyRemote{
+		{"example.com": {PathPrefix: "/registry", Resolver: "artifactory"}},
+		{"example.com": {Resolver: "unknown"}},
+		{"example.com": {Resolver: "artifactory"}},
+	} {
+		if _, err := parseResolvers(remotes); err == nil {
+			t.Errorf("expected an error parsing %v", remotes)
+		}
+	}
+}
+
+func TestResolvedRepository(t *testing.T) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/artifactory/api/docker/docker-remote/v2/library/alpine/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"name": "library/alpine",
+			"tags": []string{"latest"},
+		})
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	u, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolvers, err := parseResolvers(map[string]configuration.ProxyRemote{
+		server.URL: {
+			Resolver: "artifactory",
+			Options:  configuration.Parameters{"repository": "docker-remote"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	baseURL, err := resolvers.repositoryBaseURL(*u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := reference.WithName("library/alpine")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := client.NewRepository(name, baseURL, http.DefaultTransport)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags, err := repo.Tags(context.Background()).All(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tags) != 1 || tags[0] != "latest" {
+		t.Fatalf("unexpected tags: %v", tags)
+	}
+}
@@ -0,0 +1,281 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/client"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	defaultRetryInitialBackoff = 100 * time.Millisecond
+	defaultRetryMaxBackoff     = 5 * time.Second
+)
+
+// defaultRetryStatusCodes are the upstream response codes retried when no
+// codes are configured.
+var defaultRetryStatusCodes = []int{
+	http.StatusTooManyRequests,
+	http.StatusInternalServerError,
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// retryPolicy decides which failed upstream requests are retried, and how
+// long to wait between attempts.
+type retryPolicy struct {
+	attempts       int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+	statusCodes    map[int]struct{}
+}
+
+// newRetryPolicy returns the configured policy, or nil if requests are not
+// to be retried.
+func newRetryPolicy(config configuration.ProxyRetry) *retryPolicy {
+	if config.Attempts <= 1 {
+		return nil
+	}
+
+	policy := &retryPolicy{
+		attempts:       config.Attempts,
+		initialBackoff: config.InitialBackoff,
+		maxBackoff:     config.MaxBackoff,
+		statusCodes:    make(map[int]struct{}),
+	}
+	if policy.initialBackoff <= 0 {
+		policy.initialBackoff = defaultRetryInitialBackoff
+	}
+	if policy.maxBackoff <= 0 {
+		policy.maxBackoff = defaultRetryMaxBackoff
+	}
+
+	statusCodes := config.StatusCodes
+	if len(statusCodes) == 0 {
+		statusCodes = defaultRetryStatusCodes
+	}
+	for _, code := range statusCodes {
+		policy.statusCodes[code] = struct{}{}
+	}
+	return policy
+}
+
+// backoff returns the delay before the given retry, starting at 1.
+func (rp *retryPolicy) backoff(retry int) time.Duration {
+	delay := rp.initialBackoff
+	for i := 1; i < retry && delay < rp.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > rp.maxBackoff {
+		delay = rp.maxBackoff
+	}
+	return delay
+}
+
+// retryable reports whether the error is transient: a connection failure or
+// one of the configured response codes.
+func (rp *retryPolicy) retryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return false
+	}
+
+	if code, ok := responseStatusCode(err); ok {
+		_, retry := rp.statusCodes[code]
+		return retry
+	}
+
+	var netErr net.Error
+	return errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// responseStatusCode extracts the upstream HTTP status code from an error
+// returned by the registry client.
+func responseStatusCode(err error) (int, bool) {
+	var responseErr *client.UnexpectedHTTPResponseError
+	if errors.As(err, &responseErr) {
+		return responseErr.StatusCode, true
+	}
+
+	var statusErr *client.UnexpectedHTTPStatusError
+	if errors.As(err, &statusErr) {
+		code, convErr := strconv.Atoi(strings.Fields(statusErr.Status + " ")[0])
+		return code, convErr == nil
+	}
+
+	switch err := err.(type) {
+	case errcode.Errors:
+		if len(err) == 1 {
+			return responseStatusCode(err[0])
+		}
+	case errcode.Error:
+		return err.Code.Descriptor().HTTPStatusCode, true
+	case errcode.ErrorCode:
+		return err.Descriptor().HTTPStatusCode, true
+	}
+	return 0, false
+}
+
+// do calls f until it succeeds, fails with an error that is not transient,
+// the attempts are exhausted or the context is done.
+func (rp *retryPolicy) do(ctx context.Context, operation string, f func() error) error {
+	return rp.retry(ctx, operation, f(), f)
+}
+
+// retry calls f again after the first attempt failed with err, under the
+// same conditions as do.
+func (rp *retryPolicy) retry(ctx context.Context, operation string, err error, f func() error) error {
+	for retry := 1; retry < rp.attempts && rp.retryable(err); retry++ {
+		delay := rp.backoff(retry)
+		dcontext.GetLogger(ctx).Warnf("Retrying upstream %s in %s after error: %v", operation, delay, err)
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		err = f()
+	}
+	return err
+}
+
+// retryingManifestService retries transient failures of the remote manifest
+// service.
+type retryingManifestService struct {
+	distribution.ManifestService
+	policy *retryPolicy
+}
+
+func (rms retryingManifestService) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	var exists bool
+	err := rms.policy.do(ctx, "manifest check", func() error {
+		var err error
+		exists, err = rms.ManifestService.Exists(ctx, dgst)
+		return err
+	})
+	return exists, err
+}
+
+func (rms retryingManifestService) Get(ctx context.Context, dgst digest.Digest, options ...distribution.ManifestServiceOption) (distribution.Manifest, error) {
+	var manifest distribution.Manifest
+	err := rms.policy.do(ctx, "manifest fetch", func() error {
+		var err error
+		manifest, err = rms.ManifestService.Get(ctx, dgst, options...)
+		return err
+	})
+	return manifest, err
+}
+
+// retryingBlobService retries transient failures of the remote blob
+// service. Interrupted reads of opened blobs resume where they stopped.
+type retryingBlobService struct {
+	distribution.BlobService
+	policy *retryPolicy
+}
+
+func (rbs retryingBlobService) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	var desc distribution.Descriptor
+	err := rbs.policy.do(ctx, "blob stat", func() error {
+		var err error
+		desc, err = rbs.BlobService.Stat(ctx, dgst)
+		return err
+	})
+	return desc, err
+}
+
+func (rbs retryingBlobService) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	var p []byte
+	err := rbs.policy.do(ctx, "blob fetch", func() error {
+		var err error
+		p, err = rbs.BlobService.Get(ctx, dgst)
+		return err
+	})
+	return p, err
+}
+
+func (rbs retryingBlobService) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	var rsc io.ReadSeekCloser
+	err := rbs.policy.do(ctx, "blob fetch", func() error {
+		var err error
+		rsc, err = rbs.BlobService.Open(ctx, dgst)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &retryingReader{
+		ctx:    ctx,
+		open:   func() (io.ReadSeekCloser, error) { return rbs.BlobService.Open(ctx, dgst) },
+		reader: rsc,
+		policy: rbs.policy,
+	}, nil
+}
+
+// retryingReader reopens the remote blob at the current offset when a read
+// fails with a transient error.
+type retryingReader struct {
+	ctx    context.Context
+	open   func() (io.ReadSeekCloser, error)
+	reader io.ReadSeekCloser
+	offset int64
+	policy *retryPolicy
+}
+
+func (rr *retryingReader) Read(p []byte) (int, error) {
+	n, err := rr.reader.Read(p)
+	rr.offset += int64(n)
+	if n > 0 || err == io.EOF {
+		return n, err
+	}
+
+	err = rr.policy.retry(rr.ctx, "blob read", err, func() error {
+		reader, err := rr.open()
+		if err != nil {
+			return err
+		}
+		if _, err := reader.Seek(rr.offset, io.SeekStart); err != nil {
+			reader.Close()
+			return err
+		}
+
+		n, err = reader.Read(p)
+		if n == 0 && err != nil && err != io.EOF {
+			reader.Close()
+			return err
+		}
+
+		rr.reader.Close()
+		rr.reader = reader
+		return err
+	})
+	rr.offset += int64(n)
+	return n, err
+}
+
+func (rr *retryingReader) Seek(offset int64, whence int) (int64, error) {
+	newOffset, err := rr.reader.Seek(offset, whence)
+	if err == nil {
+		rr.offset = newOffset
+	}
+	return newOffset, err
+}
+
+func (rr *retryingReader) Close() error {
+	return rr.reader.Close()
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/client"
+	"github.com/opencontainers/go-digest"
+)
+
+// flakyBlobService fails the first calls of each operation and then serves
+// content. Readers it opens fail once after returning half of the content.
+type flakyBlobService struct {
+	distribution.BlobService
+	content  []byte
+	failures int
+	calls    int
+	opens    int
+}
+
+func (f *flakyBlobService) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	f.calls++
+	if f.calls <= f.failures {
+		return distribution.Descriptor{}, &client.UnexpectedHTTPResponseError{ParseErr: errors.New("unavailable"), StatusCode: http.StatusServiceUnavailable}
+	}
+	return distribution.Descriptor{Digest: dgst, Size: int64(len(f.content))}, nil
+}
+
+func (f *flakyBlobService) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	f.opens++
+	r := &flakyReader{Reader: bytes.NewReader(f.content)}
+	if f.opens == 1 {
+		r.failAt = int64(len(f.content) / 2)
+	}
+	return r, nil
+}
+
+type flakyReader struct {
+	*bytes.Reader
+	failAt int64
+}
+
+func (r *flakyReader) Read(p []byte) (int, error) {
+	offset := r.Size() - int64(r.Len())
+	if r.failAt > 0 {
+		if offset >= r.failAt {
+			return 0, io.ErrUnexpectedEOF
+		}
+		if int64(len(p)) > r.failAt-offset {
+			p = p[:r.failAt-offset]
+		}
+	}
+	return r.Reader.Read(p)
+}
+
+func (r *flakyReader) Close() error {
+	return nil
+}
+
+func testRetryPolicy(attempts int) *retryPolicy {
+	return newRetryPolicy(configuration.ProxyRetry{
+		Attempts:       attempts,
+		InitialBackoff: time.Millisecond,
+		MaxBackoff:     2 * time.Millisecond,
+	})
+}
+
+func TestRetryPolicy(t *testing.T) {
+	if newRetryPolicy(configuration.ProxyRetry{Attempts: 1}) != nil {
+		t.Fatal("expected no policy for a single attempt")
+	}
+
+	policy := newRetryPolicy(configuration.ProxyRetry{Attempts: 5, InitialBackoff: time.Second, MaxBackoff: 3 * time.Second})
+	for retry, expected := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 3 * time.Second, 4: 3 * time.Second} {
+		if backoff := policy.backoff(retry); backoff != expected {
+			t.Errorf("backoff(%d): expected %s, got %s", retry, expected, backoff)
+		}
+	}
+
+	for _, tc := range []struct {
+		err       error
+		retryable bool
+	}{
+		{&client.UnexpectedHTTPResponseError{ParseErr: errors.New("bad gateway"), StatusCode: http.StatusBadGateway}, true},
+		{&client.UnexpectedHTTPResponseError{ParseErr: errors.New("not found"), StatusCode: http.StatusNotFound}, false},
+		{&client.UnexpectedHTTPStatusError{Status: "503 Service Unavailable"}, true},
+		{errcode.Errors{errcode.ErrorCodeUnavailable.WithDetail(nil)}, true},
+		{errcode.Errors{errcode.ErrorCodeDenied.WithDetail(nil)}, false},
+		{io.ErrUnexpectedEOF, true},
+		{context.Canceled, false},
+		{distribution.ErrBlobUnknown, false},
+	} {
+		if policy.retryable(tc.err) != tc.retryable {
+			t.Errorf("retryable(%v): expected %v", tc.err, tc.retryable)
+		}
+	}
+}
+
+func TestRetryingBlobService(t *testing.T) {
+	ctx := context.Background()
+	content := []byte("some blob content which is read in two parts")
+	remote := &flakyBlobService{content: content, failures: 2}
+
+	blobs := retryingBlobService{BlobService: remote, policy: testRetryPolicy(3)}
+	desc, err := blobs.Stat(ctx, digest.FromBytes(content))
+	if err != nil {
+		t.Fatalf("expected stat to succeed after retries: %v", err)
+	}
+	if remote.calls != 3 {
+		t.Fatalf("expected 3 stat calls, got %d", remote.calls)
+	}
+
+	r, err := blobs.Open(ctx, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	p, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("expected interrupted read to resume: %v", err)
+	}
+	if !bytes.Equal(p, content) {
+		t.Fatalf("unexpected content: %q", p)
+	}
+	if remote.opens != 2 {
+		t.Fatalf("expected blob to be reopened once, got %d opens", remote.opens)
+	}
+
+	// Attempts are limited
+	remote = &flakyBlobService{content: content, failures: 5}
+	blobs = retryingBlobService{BlobService: remote, policy: testRetryPolicy(3)}
+	if _, err := blobs.Stat(ctx, desc.Digest); err == nil {
+		t.Fatal("expected stat to fail")
+	}
+	if remote.calls != 3 {
+		t.Fatalf("expected 3 stat calls, got %d", remote.calls)
+	}
+
+	// Retries stop when the context is done
+	cctx, cancel := context.WithCancel(ctx)
+	cancel()
+	remote = &flakyBlobService{content: content, failures: 5}
+	blobs = retryingBlobService{BlobService: remote, policy: testRetryPolicy(3)}
+	if _, err := blobs.Stat(cctx, desc.Digest); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context cancellation, got %v", err)
+	}
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	"context"
+	"strings"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	registryauth "github.com/distribution/distribution/v3/registry/auth"
+)
+
+// revalidateHeader is set to true by the clients forcing the proxy to
+// revalidate the tags they pull against the upstream, like
+// Cache-Control: no-cache, for clients which cannot set the latter.
+const revalidateHeader = "X-Registry-Revalidate"
+
+// fetchReasonRevalidation is the reason recorded in the audit log for the
+// upstream fetches forced by a client.
+const fetchReasonRevalidation = "client_revalidation"
+
+// revalidationForced reports whether the client of the request in ctx forces
+// the tags to be revalidated against the upstream, bypassing the cached tag
+// listings and failing rather than serving the cached tags when the upstream
+// is unavailable. It is forced with Cache-Control: no-cache, or max-age=0,
+// Pragma: no-cache, or X-Registry-Revalidate: true.
+func revalidationForced(ctx context.Context) bool {
+	r, err := dcontext.GetRequest(ctx)
+	if err != nil {
+		return false
+	}
+
+	forced := strings.EqualFold(r.Header.Get(revalidateHeader), "true") ||
+		strings.EqualFold(r.Header.Get("Pragma"), "no-cache")
+	for _, value := range r.Header.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			switch strings.ToLower(strings.TrimSpace(directive)) {
+			case "no-cache", "max-age=0":
+				forced = true
+			}
+		}
+	}
+	return forced
+}
+
+// logRevalidation logs who forced the revalidation of the tag, or of the
+// tag listing if tag is empty.
+func logRevalidation(ctx context.Context, repository, tag string) {
+	fields := map[interface{}]interface{}{
+		"repository": repository,
+		"client":     dcontext.GetStringValue(ctx, registryauth.UserNameKey),
+	}
+	if r, err := dcontext.GetRequest(ctx); err == nil {
+		fields["client_addr"] = dcontext.RemoteAddr(r)
+	}
+	if tag != "" {
+		fields["tag"] = tag
+	}
+	dcontext.GetLoggerWithFields(ctx, fields).Info("cache bypass forced by the client")
+}
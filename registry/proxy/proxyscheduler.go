@@ -0,0 +1,110 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// schedulerStatePath is where the scheduler records the expiry of the
+// content cached.
+const schedulerStatePath = "/scheduler-state.json"
+
+// rebuildSchedulerState returns the function re-deriving the scheduler
+// entries from the content cached. Every manifest and blob linked in a
+// repository expires a TTL after the rebuild, as if it was cached then,
+// except the referrers pushed to the cache and their blobs, which never
+// expire.
+func rebuildSchedulerState(registry distribution.Namespace, policies *artifactPolicies, locals *localReferrers) scheduler.RebuildFunc {
+	return func(ctx context.Context) ([]scheduler.Entry, error) {
+		enumerator, ok := registry.(distribution.RepositoryEnumerator)
+		if !ok {
+			return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+		}
+
+		var entries []scheduler.Entry
+		err := enumerator.Enumerate(ctx, func(repoName string) error {
+			named, err := reference.WithName(repoName)
+			if err != nil {
+				return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+			}
+			repo, err := registry.Repository(ctx, named)
+			if err != nil {
+				return err
+			}
+			add := func(manifest bool) func(digest.Digest) error {
+				return func(dgst digest.Digest) error {
+					if held, err := locals.holds(ctx, repoName, dgst); err != nil || held {
+						return err
+					}
+					ref, err := reference.WithDigest(named, dgst)
+					if err != nil {
+						return err
+					}
+					entries = append(entries, scheduler.Entry{Ref: ref, Manifest: manifest})
+					return nil
+				}
+			}
+
+			manifests, err := repo.Manifests(ctx)
+			if err != nil {
+				return err
+			}
+			manifestEnumerator, ok := manifests.(distribution.ManifestEnumerator)
+			if !ok {
+				return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+			}
+			addManifest := add(true)
+			err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+				// the policies of the content are learnt from the manifests
+				if manifest, err := manifests.Get(ctx, dgst); err == nil {
+					policies.classify(dgst, manifest)
+				}
+				return addManifest(dgst)
+			})
+			if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+				return fmt.Errorf("failed to enumerate the manifests of %s: %v", repoName, err)
+			}
+
+			blobEnumerator, ok := repo.Blobs(ctx).(distribution.BlobEnumerator)
+			if !ok {
+				return fmt.Errorf("unable to convert BlobStore into BlobEnumerator")
+			}
+			err = blobEnumerator.Enumerate(ctx, add(false))
+			if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+				return fmt.Errorf("failed to enumerate the blobs of %s: %v", repoName, err)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for i := range entries {
+			entries[i].TTL = policies.ttl(entries[i].Ref.Digest())
+		}
+		return entries, nil
+	}
+}
+
+// RepairSchedulerState repairs the scheduler state of the pull-through cache
+// configured, which must not be running. The state is read from its backup
+// if it is corrupt, and rebuilt by walking the storage if the backup is
+// corrupt too, or if opts.Rebuild is set.
+func RepairSchedulerState(ctx context.Context, d driver.StorageDriver, registry distribution.Namespace, config configuration.Proxy, opts scheduler.RepairOpts) (scheduler.LoadReport, error) {
+	policies, err := parseArtifactPolicies(config.ArtifactPolicies)
+	if err != nil {
+		return scheduler.LoadReport{}, err
+	}
+	locals, err := newLocalReferrers(config.LocalReferrers, d)
+	if err != nil {
+		return scheduler.LoadReport{}, err
+	}
+	return scheduler.Repair(ctx, d, schedulerStatePath, rebuildSchedulerState(registry, policies, locals), opts)
+}
@@ -0,0 +1,81 @@
+package proxy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+func TestRebuildSchedulerState(t *testing.T) {
+	ctx := context.Background()
+	d := inmemory.New()
+	registry, err := storage.NewRegistry(ctx, d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name, err := reference.WithName("library/debian")
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := testutil.PushImage(t, repo, "12.1").Digests()
+
+	if err := d.PutContent(ctx, schedulerStatePath, []byte("{")); err != nil {
+		t.Fatal(err)
+	}
+	config := configuration.Proxy{
+		ArtifactPolicies: []configuration.ProxyArtifactPolicy{
+			{Name: "images", MediaTypes: []string{"application/vnd.oci.image.manifest.v1+json"}, TTL: time.Hour},
+		},
+	}
+	report, err := RepairSchedulerState(ctx, d, registry, config, scheduler.RepairOpts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Source != scheduler.SourceRebuild || report.Entries != len(image) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	// the rebuilt state is read as is
+	report, err = RepairSchedulerState(ctx, d, registry, config, scheduler.RepairOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Source != scheduler.SourceState || report.Entries != len(image) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	rebuilt, err := rebuildSchedulerState(registry, nil, nil)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range rebuilt {
+		if entry.Manifest != (entry.Ref.Digest() == image[0]) || entry.TTL != repositoryTTL {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+	}
+
+	policies, err := parseArtifactPolicies(config.ArtifactPolicies)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rebuilt, err = rebuildSchedulerState(registry, policies, nil)(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, entry := range rebuilt {
+		if entry.TTL != time.Hour {
+			t.Errorf("expected the ttl of the policy for %s, got %s", entry.Ref, entry.TTL)
+		}
+	}
+}
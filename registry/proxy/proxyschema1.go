@@ -0,0 +1,347 @@
+package proxy
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema1" //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// schema1Format is a manifest format schema1 manifests can be converted to.
+type schema1Format struct {
+	configMediaType string
+	layerMediaType  string
+	build           func(config distribution.Descriptor, layers []distribution.Descriptor) (distribution.Manifest, error)
+}
+
+var schema1Formats = map[string]schema1Format{
+	"schema2": {
+		configMediaType: schema2.MediaTypeImageConfig,
+		layerMediaType:  schema2.MediaTypeLayer,
+		build: func(config distribution.Descriptor, layers []distribution.Descriptor) (distribution.Manifest, error) {
+			return schema2.FromStruct(schema2.Manifest{
+				Versioned: schema2.SchemaVersion,
+				Config:    config,
+				Layers:    layers,
+			})
+		},
+	},
+	"oci": {
+		configMediaType: v1.MediaTypeImageConfig,
+		layerMediaType:  v1.MediaTypeImageLayerGzip,
+		build: func(config distribution.Descriptor, layers []distribution.Descriptor) (distribution.Manifest, error) {
+			return ocischema.FromStruct(ocischema.Manifest{
+				Versioned: ocischema.SchemaVersion,
+				Config:    config,
+				Layers:    layers,
+			})
+		},
+	},
+}
+
+// schema1V1Keys are the keys of the v1 compatibility information of a
+// schema1 manifest which have no equivalent in an image configuration.
+var schema1V1Keys = []string{"id", "parent", "Size", "parent_id", "layer_id", "throwaway"}
+
+// schema1Converter maps the schema1 manifests served by remotes to the
+// images they are converted to, which are stored in the cache under their
+// own digests.
+type schema1Converter struct {
+	format schema1Format
+
+	mu        sync.Mutex
+	converted map[string]convertedManifest
+}
+
+// newSchema1Converter returns the converter for the configured manifest
+// format, or nil if schema1 manifests are not to be converted.
+func newSchema1Converter(config configuration.ProxyConversion) (*schema1Converter, error) {
+	if config.Schema1 == "" {
+		return nil, nil
+	}
+
+	format, ok := schema1Formats[config.Schema1]
+	if !ok {
+		return nil, fmt.Errorf("unknown schema1 conversion format %q", config.Schema1)
+	}
+	return &schema1Converter{
+		format:    format,
+		converted: make(map[string]convertedManifest),
+	}, nil
+}
+
+// lookup returns the image the schema1 manifest with the given key was
+// converted to.
+func (sc *schema1Converter) lookup(key string) (distribution.Descriptor, bool) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	converted, ok := sc.converted[key]
+	if !ok || time.Now().After(converted.expires) {
+		return distribution.Descriptor{}, false
+	}
+	return converted.desc, true
+}
+
+// record maps the schema1 manifest with the given key to the image it was
+// converted to, which expires from the cache along with its configuration.
+func (sc *schema1Converter) record(key string, desc distribution.Descriptor) {
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	now := time.Now()
+	for k, converted := range sc.converted {
+		if now.After(converted.expires) {
+			delete(sc.converted, k)
+		}
+	}
+	sc.converted[key] = convertedManifest{desc: desc, expires: now.Add(repositoryTTL)}
+}
+
+// isSchema1 reports whether a manifest of the media type may be a schema1
+// manifest. Old registries serve schema1 manifests as application/json.
+func isSchema1(mediaType string) bool {
+	switch mediaType {
+	case schema1.MediaTypeSignedManifest, schema1.MediaTypeManifest, "application/json", "": //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+		return true
+	}
+	return false
+}
+
+// schema1Conversion converts the schema1 manifests of a proxied repository
+// to images, reading their layers from the cache or the remote and storing
+// the images in the cache.
+type schema1Conversion struct {
+	converter       *schema1Converter
+	repositoryName  reference.Named
+	manifests       distribution.ManifestService
+	remoteManifests distribution.ManifestService
+	blobs           distribution.BlobStore
+	remoteBlobs     distribution.BlobService
+	verifier        *signatureVerifier // nil unless a trust policy applies
+	scheduler       *scheduler.TTLExpirationScheduler
+	namespace       string
+	stats           *statsCollector
+	objectTags      *objectTags
+}
+
+// convert returns the descriptor of the image the remote manifest is
+// converted to, converting it if needed. Manifests which are not schema1
+// manifests are returned as is.
+func (sc *schema1Conversion) convert(ctx context.Context, desc distribution.Descriptor) (distribution.Descriptor, error) {
+	key := sc.repositoryName.Name() + "@" + desc.Digest.String()
+	if converted, ok := sc.converter.lookup(key); ok {
+		return converted, nil
+	}
+
+	manifest, err := sc.remoteManifests.Get(ctx, desc.Digest)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	sm, ok := manifest.(*schema1.SignedManifest) //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	if !ok {
+		return desc, nil
+	}
+	if desc.Digest.Algorithm().FromBytes(sm.Canonical) != desc.Digest {
+		return distribution.Descriptor{}, fmt.Errorf("manifest %s does not match its digest", desc.Digest)
+	}
+	if sc.verifier != nil {
+		// Only verified content may be converted into the cache
+		if err := sc.verifier.verify(ctx, desc.Digest, sm); err != nil {
+			return distribution.Descriptor{}, err
+		}
+	}
+
+	converted, err := sc.convertManifest(ctx, sm)
+	if err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("converting schema1 manifest %s: %v", desc.Digest, err)
+	}
+	dcontext.GetLogger(ctx).Infof("Converted schema1 manifest %s of %s to %s", desc.Digest, sc.repositoryName.Name(), converted.Digest)
+
+	sc.converter.record(key, converted)
+	return converted, nil
+}
+
+// convertManifest stores the image made of the layers of a schema1
+// manifest, along with a configuration computed from its v1 compatibility
+// information.
+func (sc *schema1Conversion) convertManifest(ctx context.Context, sm *schema1.SignedManifest) (distribution.Descriptor, error) { //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	if len(sm.History) == 0 || len(sm.History) != len(sm.FSLayers) {
+		return distribution.Descriptor{}, fmt.Errorf("manifest has %d history entries for %d layers", len(sm.History), len(sm.FSLayers))
+	}
+	ctx = sc.objectTags.with(ctx, sc.namespace)
+
+	var (
+		layers  []distribution.Descriptor
+		diffIDs []digest.Digest
+		history []v1.History
+	)
+	inspected := make(map[digest.Digest]distribution.Descriptor)
+	layerDiffIDs := make(map[digest.Digest]digest.Digest)
+
+	// The layers of schema1 manifests are listed from the top one
+	for i := len(sm.History) - 1; i >= 0; i-- {
+		var compat struct {
+			Created         *time.Time `json:"created"`
+			Author          string     `json:"author"`
+			Comment         string     `json:"comment"`
+			ThrowAway       bool       `json:"throwaway"`
+			ContainerConfig struct {
+				Cmd []string `json:"Cmd"`
+			} `json:"container_config"`
+		}
+		if err := json.Unmarshal([]byte(sm.History[i].V1Compatibility), &compat); err != nil {
+			return distribution.Descriptor{}, fmt.Errorf("invalid v1 compatibility information: %v", err)
+		}
+		history = append(history, v1.History{
+			Created:    compat.Created,
+			CreatedBy:  strings.Join(compat.ContainerConfig.Cmd, " "),
+			Author:     compat.Author,
+			Comment:    compat.Comment,
+			EmptyLayer: compat.ThrowAway,
+		})
+		if compat.ThrowAway {
+			continue
+		}
+
+		blobSum := sm.FSLayers[i].BlobSum
+		layer, ok := inspected[blobSum]
+		if !ok {
+			var diffID digest.Digest
+			var err error
+			layer, diffID, err = sc.inspectLayer(ctx, blobSum)
+			if err != nil {
+				return distribution.Descriptor{}, fmt.Errorf("inspecting layer %s: %v", blobSum, err)
+			}
+			inspected[blobSum] = layer
+			layerDiffIDs[blobSum] = diffID
+		}
+		layers = append(layers, layer)
+		diffIDs = append(diffIDs, layerDiffIDs[blobSum])
+	}
+
+	// The configuration is the v1 compatibility information of the top
+	// layer, completed with the diff IDs and history of the layers
+	var image map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(sm.History[0].V1Compatibility), &image); err != nil {
+		return distribution.Descriptor{}, fmt.Errorf("invalid v1 compatibility information: %v", err)
+	}
+	for _, key := range schema1V1Keys {
+		delete(image, key)
+	}
+	rootfs := v1.RootFS{Type: "layers", DiffIDs: diffIDs}
+	var err error
+	if image["rootfs"], err = json.Marshal(rootfs); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	if image["history"], err = json.Marshal(history); err != nil {
+		return distribution.Descriptor{}, err
+	}
+	payload, err := json.Marshal(image)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	format := sc.converter.format
+	config, err := sc.blobs.Put(ctx, format.configMediaType, payload)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	config.MediaType = format.configMediaType
+	sc.schedule(blobEntry, config.Digest, config.Size)
+
+	manifest, err := format.build(config, layers)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	mediaType, manifestPayload, err := manifest.Payload()
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	dgst, err := sc.manifests.Put(ctx, manifest)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	sc.schedule(manifestEntry, dgst, int64(len(manifestPayload)))
+	return distribution.Descriptor{MediaType: mediaType, Digest: dgst, Size: int64(len(manifestPayload))}, nil
+}
+
+// inspectLayer reads a gzip layer from the cache, or from the remote when
+// it is not cached, and returns its descriptor and diff ID.
+func (sc *schema1Conversion) inspectLayer(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, digest.Digest, error) {
+	rc, err := sc.blobs.Open(ctx, dgst)
+	if err != nil {
+		if rc, err = sc.remoteBlobs.Open(ctx, dgst); err != nil {
+			return distribution.Descriptor{}, "", err
+		}
+	}
+	defer rc.Close()
+
+	verifier := dgst.Verifier()
+	counter := &countingReader{r: io.TeeReader(rc, verifier)}
+	gz, err := gzip.NewReader(counter)
+	if err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	defer gz.Close()
+
+	diffID := digest.Canonical.Digester()
+	if _, err := io.Copy(diffID.Hash(), gz); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	// Include any padding after the compressed stream in the size
+	if _, err := io.Copy(io.Discard, counter); err != nil {
+		return distribution.Descriptor{}, "", err
+	}
+	if !verifier.Verified() {
+		return distribution.Descriptor{}, "", fmt.Errorf("layer does not match its digest")
+	}
+
+	desc := distribution.Descriptor{
+		MediaType: sc.converter.format.layerMediaType,
+		Digest:    dgst,
+		Size:      counter.n,
+	}
+	return desc, diffID.Digest(), nil
+}
+
+// schedule expires converted content along with the content it was made of.
+func (sc *schema1Conversion) schedule(kind cacheEntryKind, dgst digest.Digest, size int64) {
+	ref, err := reference.WithDigest(sc.repositoryName, dgst)
+	if err != nil {
+		return
+	}
+
+	if kind == manifestEntry {
+		sc.scheduler.AddManifest(ref, repositoryTTL)
+	} else {
+		sc.scheduler.AddBlob(ref, repositoryTTL)
+	}
+	sc.stats.cached(kind, sc.namespace, ref.String(), size)
+}
+
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (cr *countingReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += int64(n)
+	return n, err
+}
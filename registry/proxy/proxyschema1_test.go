@@ -0,0 +1,163 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/schema1" //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestProxyTagsConvertSchema1(t *testing.T) {
+	ctx := context.Background()
+	name, err := reference.WithName("legacy/app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := libtrust.GenerateECP256PrivateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	newRepo := func(options ...storage.RegistryOption) distribution.Repository {
+		registry, err := storage.NewRegistry(ctx, inmemory.New(), options...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		repo, err := registry.Repository(ctx, name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return repo
+	}
+	truthRepo := newRepo(storage.Schema1SigningKey(key), storage.EnableSchema1)
+	localRepo := newRepo()
+
+	// Push a schema1 image of a layer and an empty layer to the remote
+	tarLayer := makeTarLayer(t)
+	var gzLayer bytes.Buffer
+	gw := gzip.NewWriter(&gzLayer)
+	gw.Write(tarLayer)
+	gw.Close()
+	layerDesc, err := truthRepo.Blobs(ctx).Put(ctx, schema1.MediaTypeManifestLayer, gzLayer.Bytes()) //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := schema1.Manifest{ //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+		Versioned:    manifest.Versioned{SchemaVersion: 1},
+		Name:         name.Name(),
+		Tag:          "latest",
+		Architecture: "amd64",
+		FSLayers: []schema1.FSLayer{ //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+			{BlobSum: layerDesc.Digest},
+			{BlobSum: layerDesc.Digest},
+		},
+		History: []schema1.History{ //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+			{V1Compatibility: `{"id":"top","parent":"base","architecture":"amd64","os":"linux","created":"2016-01-02T00:00:00Z","config":{"Cmd":["/app"]},"container_config":{"Cmd":["/bin/sh","-c","#(nop) CMD [\"/app\"]"]},"throwaway":true}`},
+			{V1Compatibility: `{"id":"base","created":"2016-01-01T00:00:00Z","container_config":{"Cmd":["/bin/sh","-c","#(nop) ADD file:app in /"]}}`},
+		},
+	}
+	sm, err := schema1.Sign(&m, key) //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
+	if err != nil {
+		t.Fatal(err)
+	}
+	truthManifests, err := truthRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	schema1Digest, err := truthManifests.Put(ctx, sm)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := truthRepo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: schema1Digest}); err != nil {
+		t.Fatal(err)
+	}
+
+	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+	converter, err := newSchema1Converter(configuration.ProxyConversion{Schema1: "schema2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	pt := proxyTagService{
+		localTags:      localRepo.Tags(ctx),
+		remoteTags:     truthRepo.Tags(ctx),
+		authChallenger: &mockChallenger{},
+		repositoryName: name,
+		schema1: &schema1Conversion{
+			converter:       converter,
+			repositoryName:  name,
+			manifests:       localManifests,
+			remoteManifests: truthManifests,
+			blobs:           localRepo.Blobs(ctx),
+			remoteBlobs:     truthRepo.Blobs(ctx),
+			scheduler:       scheduler.New(ctx, inmemory.New(), "/scheduler-state.json"),
+		},
+	}
+
+	desc, err := pt.Get(ctx, "latest")
+	if err != nil {
+		t.Fatalf("unexpected error getting tag: %v", err)
+	}
+	if desc.MediaType != schema2.MediaTypeManifest || desc.Digest == schema1Digest {
+		t.Fatalf("tag was not converted: %v", desc)
+	}
+	if local, err := localRepo.Tags(ctx).Get(ctx, "latest"); err != nil || local.Digest != desc.Digest {
+		t.Fatalf("local tag points to %s, expected %s: %v", local.Digest, desc.Digest, err)
+	}
+
+	converted, err := localManifests.Get(ctx, desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	image, ok := converted.(*schema2.DeserializedManifest)
+	if !ok {
+		t.Fatalf("unexpected converted manifest type %T", converted)
+	}
+	if len(image.Layers) != 1 || image.Layers[0].Digest != layerDesc.Digest || image.Layers[0].Size != int64(gzLayer.Len()) {
+		t.Fatalf("unexpected converted layers: %v", image.Layers)
+	}
+
+	configBlob, err := localRepo.Blobs(ctx).Get(ctx, image.Config.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var config v1.Image
+	if err := json.Unmarshal(configBlob, &config); err != nil {
+		t.Fatal(err)
+	}
+	if len(config.RootFS.DiffIDs) != 1 || config.RootFS.DiffIDs[0] != digest.FromBytes(tarLayer) {
+		t.Fatalf("unexpected diff IDs: %v", config.RootFS.DiffIDs)
+	}
+	if len(config.History) != 2 || config.History[0].EmptyLayer || !config.History[1].EmptyLayer {
+		t.Fatalf("unexpected history: %v", config.History)
+	}
+	if config.Architecture != "amd64" || len(config.Config.Cmd) != 1 || config.Config.Cmd[0] != "/app" {
+		t.Fatalf("unexpected configuration: %s", configBlob)
+	}
+	if bytes.Contains(configBlob, []byte(`"parent"`)) || bytes.Contains(configBlob, []byte(`"throwaway"`)) {
+		t.Fatalf("configuration holds v1 compatibility keys: %s", configBlob)
+	}
+
+	// The conversion is not repeated
+	again, err := pt.Get(ctx, "latest")
+	if err != nil || again.Digest != desc.Digest {
+		t.Fatalf("unexpected descriptor getting tag again: %v, %v", again, err)
+	}
+}
@@ -0,0 +1,236 @@
+package proxy
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/accesslog"
+)
+
+// Headers of the responses reporting whether the content was served from the
+// cache, and from which upstream. The age of the cached content is reported
+// with the Age header, when known.
+const (
+	cacheStatusHeader   = "X-Cache"
+	cacheUpstreamHeader = "X-Cache-Upstream"
+)
+
+// Values of the X-Cache header.
+const (
+	cacheStatusHit  = "HIT"
+	cacheStatusMiss = "MISS"
+)
+
+// Stats reports the state of a pull through cache.
+type Stats struct {
+	// Namespaces holds the statistics of each upstream host
+	Namespaces map[string]NamespaceStats `json:"namespaces"`
+
+	// SchedulerBacklog is the number of cached entries waiting for their
+	// TTL to expire
+	SchedulerBacklog int `json:"scheduler_backlog"`
+
+	// Policies holds the content cached under each artifact policy
+	Policies map[string]PolicyStats `json:"policies,omitempty"`
+}
+
+// NamespaceStats reports the cache statistics of an upstream host.
+type NamespaceStats struct {
+	Manifests CacheStats `json:"manifests"`
+	Blobs     CacheStats `json:"blobs"`
+}
+
+// CacheStats reports the cache statistics of one kind of content. Entries
+// and bytes cover the content cached since the registry started.
+type CacheStats struct {
+	Entries  int        `json:"entries"`
+	Bytes    int64      `json:"bytes"`
+	Hits     uint64     `json:"hits"`
+	Misses   uint64     `json:"misses"`
+	HitRatio float64    `json:"hit_ratio"`
+	Oldest   *time.Time `json:"oldest,omitempty"`
+	Newest   *time.Time `json:"newest,omitempty"`
+}
+
+// StatsReporter is implemented by registries acting as a pull through cache.
+type StatsReporter interface {
+	ProxyStats() Stats
+}
+
+type cacheEntryKind int
+
+const (
+	manifestEntry cacheEntryKind = iota
+	blobEntry
+)
+
+type cacheEntry struct {
+	namespace string
+	size      int64
+	cachedAt  time.Time
+}
+
+type requestCounts struct {
+	hits   uint64
+	misses uint64
+}
+
+// statsCollector keeps the in-memory statistics reported by the stats
+// endpoint. The proxy stores record hits, misses and newly cached content,
+// and expired content is removed when the scheduler evicts it. A nil
+// collector records nothing.
+type statsCollector struct {
+	mu       sync.Mutex
+	entries  map[cacheEntryKind]map[string]cacheEntry
+	requests map[cacheEntryKind]map[string]*requestCounts
+}
+
+func newStatsCollector() *statsCollector {
+	return &statsCollector{
+		entries: map[cacheEntryKind]map[string]cacheEntry{
+			manifestEntry: {},
+			blobEntry:     {},
+		},
+		requests: map[cacheEntryKind]map[string]*requestCounts{
+			manifestEntry: {},
+			blobEntry:     {},
+		},
+	}
+}
+
+func (sc *statsCollector) counts(kind cacheEntryKind, namespace string) *requestCounts {
+	counts, ok := sc.requests[kind][namespace]
+	if !ok {
+		counts = &requestCounts{}
+		sc.requests[kind][namespace] = counts
+	}
+	return counts
+}
+
+// hit records a request for the content with the given key, as in the
+// scheduler, served from the cache, and annotates the access log entry and
+// the response of the request.
+func (sc *statsCollector) hit(ctx context.Context, kind cacheEntryKind, namespace, key string) {
+	accesslog.Annotate(ctx, accesslog.FieldNamespace, namespace)
+	accesslog.Annotate(ctx, accesslog.FieldCache, accesslog.CacheHit)
+	if sc == nil {
+		setCacheHeaders(ctx, cacheStatusHit, namespace, time.Time{})
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.counts(kind, namespace).hits++
+	// the content cached before the registry started has no known age
+	setCacheHeaders(ctx, cacheStatusHit, namespace, sc.entries[kind][key].cachedAt)
+}
+
+// miss records a request served from the upstream, and annotates the access
+// log entry and the response of the request.
+func (sc *statsCollector) miss(ctx context.Context, kind cacheEntryKind, namespace string) {
+	accesslog.Annotate(ctx, accesslog.FieldNamespace, namespace)
+	accesslog.Annotate(ctx, accesslog.FieldCache, accesslog.CacheMiss)
+	setCacheHeaders(ctx, cacheStatusMiss, namespace, time.Time{})
+	if sc == nil {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.counts(kind, namespace).misses++
+}
+
+// cached records content added to the cache, keyed as in the scheduler.
+func (sc *statsCollector) cached(kind cacheEntryKind, namespace, key string, size int64) {
+	if sc == nil {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	sc.entries[kind][key] = cacheEntry{
+		namespace: namespace,
+		size:      size,
+		cachedAt:  time.Now(),
+	}
+}
+
+// expired records content removed from the cache.
+func (sc *statsCollector) expired(kind cacheEntryKind, key string) {
+	if sc == nil {
+		return
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+	delete(sc.entries[kind], key)
+}
+
+// stats returns the statistics per upstream host.
+func (sc *statsCollector) stats() map[string]NamespaceStats {
+	namespaces := make(map[string]NamespaceStats)
+	if sc == nil {
+		return namespaces
+	}
+
+	sc.mu.Lock()
+	defer sc.mu.Unlock()
+
+	cacheStats := func(ns *NamespaceStats, kind cacheEntryKind) *CacheStats {
+		if kind == manifestEntry {
+			return &ns.Manifests
+		}
+		return &ns.Blobs
+	}
+
+	for kind, entries := range sc.entries {
+		for _, entry := range entries {
+			ns := namespaces[entry.namespace]
+			cs := cacheStats(&ns, kind)
+			cs.Entries++
+			cs.Bytes += entry.size
+			if cs.Oldest == nil || entry.cachedAt.Before(*cs.Oldest) {
+				cachedAt := entry.cachedAt
+				cs.Oldest = &cachedAt
+			}
+			if cs.Newest == nil || entry.cachedAt.After(*cs.Newest) {
+				cachedAt := entry.cachedAt
+				cs.Newest = &cachedAt
+			}
+			namespaces[entry.namespace] = ns
+		}
+	}
+
+	for kind, requests := range sc.requests {
+		for namespace, counts := range requests {
+			ns := namespaces[namespace]
+			cs := cacheStats(&ns, kind)
+			cs.Hits = counts.hits
+			cs.Misses = counts.misses
+			if total := counts.hits + counts.misses; total > 0 {
+				cs.HitRatio = float64(counts.hits) / float64(total)
+			}
+			namespaces[namespace] = ns
+		}
+	}
+
+	return namespaces
+}
+
+// setCacheHeaders sets the headers of the response of the request in ctx
+// reporting how it is served, along with the age of the cached content if
+// cachedAt is set.
+func setCacheHeaders(ctx context.Context, status, namespace string, cachedAt time.Time) {
+	w, err := dcontext.GetResponseWriter(ctx)
+	if err != nil {
+		return
+	}
+	w.Header().Set(cacheStatusHeader, status)
+	w.Header().Set(cacheUpstreamHeader, namespace)
+	if !cachedAt.IsZero() {
+		w.Header().Set("Age", strconv.FormatInt(int64(time.Since(cachedAt)/time.Second), 10))
+	}
+}
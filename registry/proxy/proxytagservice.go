@@ -2,8 +2,12 @@ package proxy
 
 import (
 	"context"
+	"sort"
+	"sync"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
 )
 
 // proxyTagService supports local and remote lookup of tags.
@@ -11,17 +15,35 @@ type proxyTagService struct {
 	localTags      distribution.TagService
 	remoteTags     distribution.TagService
 	authChallenger authChallenger
+	repositoryName reference.Named
+	tagLists       *tagListCache
+	schema1        *schema1Conversion // nil unless schema1 manifests are converted
 }
 
 var _ distribution.TagService = proxyTagService{}
 
 // Get attempts to get the most recent digest for the tag by checking the remote
 // tag service first and then caching it locally.  If the remote is unavailable
-// the local association is returned
+// the local association is returned, unless the client forces the tag to be
+// revalidated. When schema1 manifests are converted, the tag is associated
+// with the image a schema1 manifest is converted to.
 func (pt proxyTagService) Get(ctx context.Context, tag string) (distribution.Descriptor, error) {
+	reason := fetchReasonTagRefresh
+	revalidate := revalidationForced(ctx)
+	if revalidate {
+		reason = fetchReasonRevalidation
+		logRevalidation(ctx, pt.repositoryName.Name(), tag)
+	}
+
 	err := pt.authChallenger.tryEstablishChallenges(ctx)
 	if err == nil {
-		desc, err := pt.remoteTags.Get(ctx, tag)
+		var desc distribution.Descriptor
+		desc, err = pt.remoteTags.Get(withFetchReason(ctx, reason), tag)
+		if err == nil && pt.schema1 != nil && isSchema1(desc.MediaType) {
+			if desc, err = pt.schema1.convert(ctx, desc); err != nil {
+				return distribution.Descriptor{}, err
+			}
+		}
 		if err == nil {
 			err := pt.localTags.Tag(ctx, tag, desc)
 			if err != nil {
@@ -30,6 +52,9 @@ func (pt proxyTagService) Get(ctx context.Context, tag string) (distribution.Des
 			return desc, nil
 		}
 	}
+	if revalidate {
+		return distribution.Descriptor{}, err
+	}
 
 	desc, err := pt.localTags.Get(ctx, tag)
 	if err != nil {
@@ -50,17 +75,149 @@ func (pt proxyTagService) Untag(ctx context.Context, tag string) error {
 	return nil
 }
 
+// All returns the sorted union of the remote and local tags. The listing is
+// cached for the configured TTL so that repeated listings do not reach the
+// remote, unless the client forces the listing to be revalidated. If the
+// remote is unavailable only the local tags are returned, unless forced.
 func (pt proxyTagService) All(ctx context.Context) ([]string, error) {
+	reason := fetchReasonTagListing
+	revalidate := revalidationForced(ctx)
+	if revalidate {
+		reason = fetchReasonRevalidation
+		logRevalidation(ctx, pt.repositoryName.Name(), "")
+	} else if tags, ok := pt.tagLists.get(pt.repositoryName); ok {
+		return tags, nil
+	}
+
 	err := pt.authChallenger.tryEstablishChallenges(ctx)
 	if err == nil {
-		tags, err := pt.remoteTags.All(ctx)
+		var remoteTags []string
+		remoteTags, err = pt.remoteTags.All(withFetchReason(ctx, reason))
 		if err == nil {
-			return tags, err
+			localTags, err := pt.localTags.All(ctx)
+			if err != nil {
+				if _, ok := err.(distribution.ErrRepositoryUnknown); !ok {
+					return nil, err
+				}
+			}
+
+			tags := mergeTags(remoteTags, localTags)
+			pt.tagLists.put(pt.repositoryName, tags)
+			return tags, nil
 		}
 	}
+	if revalidate {
+		return nil, err
+	}
 	return pt.localTags.All(ctx)
 }
 
+// History returns the history of the tag in the cache, which records the
+// changes of the tag seen by the cache.
+func (pt proxyTagService) History(ctx context.Context, tag string) ([]distribution.TagHistoryEntry, error) {
+	history, ok := pt.localTags.(distribution.TagHistoryProvider)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return history.History(ctx, tag)
+}
+
 func (pt proxyTagService) Lookup(ctx context.Context, digest distribution.Descriptor) ([]string, error) {
 	return []string{}, distribution.ErrUnsupported
 }
+
+// mergeTags returns the sorted union of the given tag lists. The tags handler
+// relies on the ordering to paginate the listing.
+func mergeTags(lists ...[]string) []string {
+	seen := make(map[string]struct{})
+	tags := []string{}
+	for _, list := range lists {
+		for _, tag := range list {
+			if _, ok := seen[tag]; ok {
+				continue
+			}
+			seen[tag] = struct{}{}
+			tags = append(tags, tag)
+		}
+	}
+	sort.Strings(tags)
+	return tags
+}
+
+// tagListCache holds tag listings of proxied repositories for a fixed TTL.
+// A nil cache, or one with a non-positive TTL, caches nothing.
+type tagListCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]tagListEntry
+}
+
+type tagListEntry struct {
+	tags    []string
+	expires time.Time
+}
+
+func newTagListCache(ttl time.Duration) *tagListCache {
+	return &tagListCache{
+		ttl:     ttl,
+		entries: make(map[string]tagListEntry),
+	}
+}
+
+func (c *tagListCache) get(name reference.Named) ([]string, bool) {
+	if c == nil || name == nil {
+		return nil, false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 {
+		return nil, false
+	}
+	entry, ok := c.entries[name.Name()]
+	if !ok {
+		return nil, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(c.entries, name.Name())
+		return nil, false
+	}
+	return append([]string(nil), entry.tags...), true
+}
+
+func (c *tagListCache) put(name reference.Named, tags []string) {
+	if c == nil || name == nil {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.ttl <= 0 {
+		return
+	}
+	now := time.Now()
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			delete(c.entries, key)
+		}
+	}
+	c.entries[name.Name()] = tagListEntry{
+		tags:    append([]string(nil), tags...),
+		expires: now.Add(c.ttl),
+	}
+}
+
+// setTTL changes the TTL of the cache, dropping the listings cached with the
+// previous TTL.
+func (c *tagListCache) setTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if ttl != c.ttl {
+		c.ttl = ttl
+		c.entries = make(map[string]tagListEntry)
+	}
+}
@@ -2,12 +2,18 @@ package proxy
 
 import (
 	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
 	"reflect"
 	"sort"
 	"sync"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
 )
 
 type mockTagStore struct {
@@ -175,3 +181,104 @@ func TestGet(t *testing.T) {
 		t.Fatalf("Expected 4 auth challenge calls, got %#v", proxyTags.authChallenger)
 	}
 }
+
+func TestAllCachesMergedTags(t *testing.T) {
+	ctx := context.Background()
+	proxyTags := testProxyTagService(
+		map[string]distribution.Descriptor{"local": {Size: 1}, "shared": {Size: 2}},
+		map[string]distribution.Descriptor{"shared": {Size: 2}, "remote": {Size: 3}},
+	)
+	repositoryName, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyTags.repositoryName = repositoryName
+	proxyTags.tagLists = newTagListCache(time.Hour)
+
+	all, err := proxyTags.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"local", "remote", "shared"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Fatalf("unexpected tags: got %v, expected %v", all, expected)
+	}
+
+	// New remote tags are not seen until the cached listing expires
+	err = proxyTags.remoteTags.Tag(ctx, "newer", distribution.Descriptor{Size: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	all, err = proxyTags.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(all, expected) {
+		t.Fatalf("unexpected cached tags: got %v, expected %v", all, expected)
+	}
+	if proxyTags.authChallenger.(*mockChallenger).count != 1 {
+		t.Fatalf("Expected 1 auth challenge call, got %#v", proxyTags.authChallenger)
+	}
+
+	proxyTags.tagLists.ttl = -1
+	all, err = proxyTags.All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []string{"local", "newer", "remote", "shared"}
+	if !reflect.DeepEqual(all, expected) {
+		t.Fatalf("unexpected tags without caching: got %v, expected %v", all, expected)
+	}
+}
+
+func TestRevalidation(t *testing.T) {
+	repositoryName, err := reference.WithName("foo/bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxyTags := testProxyTagService(
+		map[string]distribution.Descriptor{"latest": {Size: 1}},
+		map[string]distribution.Descriptor{"latest": {Size: 2}},
+	)
+	proxyTags.repositoryName = repositoryName
+	proxyTags.tagLists = newTagListCache(time.Hour)
+
+	ctx := context.Background()
+	if _, err := proxyTags.All(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := proxyTags.remoteTags.Tag(ctx, "newer", distribution.Descriptor{Size: 3}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, header := range []http.Header{
+		{"Cache-Control": {"no-cache"}},
+		{"Cache-Control": {"max-age=0"}},
+		{"Pragma": {"no-cache"}},
+		{"X-Registry-Revalidate": {"true"}},
+	} {
+		r := httptest.NewRequest(http.MethodGet, "/v2/foo/bar/tags/list", nil)
+		r.Header = header
+		ctx := dcontext.WithRequest(context.Background(), r)
+
+		// the cached listing is bypassed
+		all, err := proxyTags.All(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := []string{"latest", "newer"}; !reflect.DeepEqual(all, expected) {
+			t.Fatalf("%v: unexpected tags %v, expected %v", header, all, expected)
+		}
+
+		// the cached tags are not served when the upstream is unavailable
+		proxyTags.authChallenger = &mockChallenger{err: errors.New("upstream unavailable")}
+		if _, err := proxyTags.Get(ctx, "latest"); err == nil {
+			t.Fatalf("%v: expected the revalidation to fail", header)
+		}
+		if _, err := proxyTags.All(ctx); err == nil {
+			t.Fatalf("%v: expected the revalidation of the listing to fail", header)
+		}
+		proxyTags.authChallenger = &mockChallenger{}
+	}
+}
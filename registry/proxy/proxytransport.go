@@ -0,0 +1,329 @@
+package proxy
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// upstreamHost normalizes a host, or URL, used to key per-upstream
+// configuration to the host requests are sent to.
+func upstreamHost(key string) string {
+	if u, err := url.Parse(key); err == nil && u.Host != "" {
+		key = u.Host
+	}
+	if key == "docker.io" {
+		key = "registry-1.docker.io"
+	}
+	return key
+}
+
+// defaultDialTimeout and defaultKeepAlive match the dialer of the default
+// transport.
+const (
+	defaultDialTimeout = 30 * time.Second
+	defaultKeepAlive   = 30 * time.Second
+)
+
+// upstreamTransports holds the transports used to reach upstream hosts which
+// must be reached through an outbound HTTP proxy, resolved and dialed
+// differently from other hosts, or pool their connections apart. The
+// transports, and their pools of connections, are shared by the calls to
+// the upstreams. A nil value uses the default transport for every host.
+type upstreamTransports struct {
+	configured map[string]http.RoundTripper
+	pool       configuration.ProxyConnectionPool // of the hosts without a transport
+
+	mu     sync.Mutex
+	pooled map[string]http.RoundTripper // created on first use
+}
+
+// forHost returns the transport for the upstream host. Hosts without a
+// configured transport use the default transport, which honors the proxy
+// environment variables, or a transport of their own with the same
+// settings when the pool is tuned.
+func (ut *upstreamTransports) forHost(host string) http.RoundTripper {
+	if ut == nil {
+		return http.DefaultTransport
+	}
+	if tr, ok := ut.configured[host]; ok {
+		return tr
+	}
+	if ut.pool == (configuration.ProxyConnectionPool{}) {
+		return http.DefaultTransport
+	}
+
+	ut.mu.Lock()
+	defer ut.mu.Unlock()
+	tr, ok := ut.pooled[host]
+	if !ok {
+		tr = newPooledTransport(ut.pool)
+		ut.pooled[host] = tr
+	}
+	return tr
+}
+
+// forURL returns the transport for the host of the upstream URL.
+func (ut *upstreamTransports) forURL(rawURL string) http.RoundTripper {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return http.DefaultTransport
+	}
+	return ut.forHost(u.Host)
+}
+
+// parseTransports builds the transports for the configured upstream hosts,
+// whose connections are pooled as configured by pool unless they override
+// it.
+func parseTransports(config map[string]configuration.ProxyTransport, pool configuration.ProxyConnectionPool) (*upstreamTransports, error) {
+	if err := validatePool(pool); err != nil {
+		return nil, fmt.Errorf("pool: %v", err)
+	}
+
+	transports := &upstreamTransports{
+		configured: make(map[string]http.RoundTripper, len(config)),
+		pool:       pool,
+		pooled:     make(map[string]http.RoundTripper),
+	}
+	for key, transportConfig := range config {
+		host := upstreamHost(key)
+
+		proxy, err := newProxyFunc(transportConfig)
+		if err != nil {
+			return nil, fmt.Errorf("transport for %s: %v", host, err)
+		}
+
+		dial, err := newDialFunc(transportConfig)
+		if err != nil {
+			return nil, fmt.Errorf("transport for %s: %v", host, err)
+		}
+
+		if err := validatePool(transportConfig.Pool); err != nil {
+			return nil, fmt.Errorf("transport for %s: pool: %v", host, err)
+		}
+
+		tr := newPooledTransport(mergePools(pool, transportConfig.Pool))
+		tr.Proxy = proxy
+		tr.DialContext = dial
+		transports.configured[host] = tr
+	}
+	return transports, nil
+}
+
+// validatePool checks the settings of a pool of connections.
+func validatePool(pool configuration.ProxyConnectionPool) error {
+	if pool.MaxIdleConnsPerHost < 0 || pool.MaxConnsPerHost < 0 || pool.IdleConnTimeout < 0 || pool.TLSSessionCacheSize < 0 {
+		return fmt.Errorf("settings must not be negative")
+	}
+	return nil
+}
+
+// mergePools returns the settings of base overridden by those of override
+// which are set.
+func mergePools(base, override configuration.ProxyConnectionPool) configuration.ProxyConnectionPool {
+	if override.MaxIdleConnsPerHost != 0 {
+		base.MaxIdleConnsPerHost = override.MaxIdleConnsPerHost
+	}
+	if override.MaxConnsPerHost != 0 {
+		base.MaxConnsPerHost = override.MaxConnsPerHost
+	}
+	if override.IdleConnTimeout != 0 {
+		base.IdleConnTimeout = override.IdleConnTimeout
+	}
+	if override.TLSSessionCacheSize != 0 {
+		base.TLSSessionCacheSize = override.TLSSessionCacheSize
+	}
+	return base
+}
+
+// newPooledTransport returns a transport with the settings of the default
+// transport, and its pool of connections tuned as configured.
+func newPooledTransport(pool configuration.ProxyConnectionPool) *http.Transport {
+	tr := http.DefaultTransport.(*http.Transport).Clone()
+	if pool.MaxIdleConnsPerHost > 0 {
+		tr.MaxIdleConnsPerHost = pool.MaxIdleConnsPerHost
+		if tr.MaxIdleConns < pool.MaxIdleConnsPerHost {
+			tr.MaxIdleConns = pool.MaxIdleConnsPerHost
+		}
+	}
+	if pool.MaxConnsPerHost > 0 {
+		tr.MaxConnsPerHost = pool.MaxConnsPerHost
+	}
+	if pool.IdleConnTimeout > 0 {
+		tr.IdleConnTimeout = pool.IdleConnTimeout
+	}
+	if pool.TLSSessionCacheSize > 0 {
+		tr.TLSClientConfig = &tls.Config{
+			ClientSessionCache: tls.NewLRUClientSessionCache(pool.TLSSessionCacheSize),
+		}
+	}
+	return tr
+}
+
+// newProxyFunc returns the function selecting the outbound proxy for a
+// request, following the conventions of the HTTP_PROXY, HTTPS_PROXY and
+// NO_PROXY environment variables.
+func newProxyFunc(config configuration.ProxyTransport) (func(*http.Request) (*url.URL, error), error) {
+	httpProxy, err := parseProxyURL(config.HTTPProxy)
+	if err != nil {
+		return nil, err
+	}
+	httpsProxy, err := parseProxyURL(config.HTTPSProxy)
+	if err != nil {
+		return nil, err
+	}
+
+	var noProxy []string
+	for _, entry := range strings.Split(config.NoProxy, ",") {
+		if entry = strings.ToLower(strings.TrimSpace(entry)); entry != "" {
+			noProxy = append(noProxy, entry)
+		}
+	}
+
+	return func(req *http.Request) (*url.URL, error) {
+		if bypassProxy(noProxy, req.URL.Hostname()) {
+			return nil, nil
+		}
+		if req.URL.Scheme == "https" {
+			return httpsProxy, nil
+		}
+		return httpProxy, nil
+	}, nil
+}
+
+// newDialFunc returns the function establishing the connections of a
+// transport, which resolves hosts with the static mappings and DNS servers
+// of the configuration.
+func newDialFunc(config configuration.ProxyTransport) (func(ctx context.Context, network, addr string) (net.Conn, error), error) {
+	dialer := &net.Dialer{
+		Timeout:       config.DialTimeout,
+		KeepAlive:     defaultKeepAlive,
+		FallbackDelay: config.FallbackDelay,
+	}
+	if dialer.Timeout <= 0 {
+		dialer.Timeout = defaultDialTimeout
+	}
+
+	var family string
+	switch strings.ToLower(config.IPFamily) {
+	case "":
+	case "ipv4":
+		family = "4"
+	case "ipv6":
+		family = "6"
+	default:
+		return nil, fmt.Errorf("invalid ip family %q, expected ipv4 or ipv6", config.IPFamily)
+	}
+
+	if len(config.DNSServers) > 0 {
+		servers := make([]string, 0, len(config.DNSServers))
+		for _, server := range config.DNSServers {
+			addr, err := dnsServerAddr(server)
+			if err != nil {
+				return nil, err
+			}
+			servers = append(servers, addr)
+		}
+
+		dialer.Resolver = &net.Resolver{
+			PreferGo: true,
+			Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+				d := net.Dialer{Timeout: dialer.Timeout}
+				var err error
+				for _, server := range servers {
+					var conn net.Conn
+					if conn, err = d.DialContext(ctx, network, server); err == nil {
+						return conn, nil
+					}
+				}
+				return nil, err
+			},
+		}
+	}
+
+	hosts := make(map[string]string, len(config.Hosts))
+	for host, ip := range config.Hosts {
+		if net.ParseIP(ip) == nil {
+			return nil, fmt.Errorf("invalid address %q for host %s", ip, host)
+		}
+		hosts[strings.ToLower(host)] = ip
+	}
+
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		if host, port, err := net.SplitHostPort(addr); err == nil {
+			if ip, ok := hosts[strings.ToLower(host)]; ok {
+				addr = net.JoinHostPort(ip, port)
+			}
+		}
+		if family != "" && (network == "tcp" || network == "udp") {
+			network += family
+		}
+		return dialer.DialContext(ctx, network, addr)
+	}, nil
+}
+
+// dnsServerAddr returns the address of a DNS server given as an IP address,
+// with an optional port.
+func dnsServerAddr(server string) (string, error) {
+	host, port, err := net.SplitHostPort(server)
+	if err != nil {
+		// Without a port, which IPv6 addresses may also be given in brackets
+		host, port = strings.TrimSuffix(strings.TrimPrefix(server, "["), "]"), "53"
+	}
+	if net.ParseIP(host) == nil {
+		return "", fmt.Errorf("invalid DNS server %q, expected an IP address", server)
+	}
+	return net.JoinHostPort(host, port), nil
+}
+
+func parseProxyURL(rawURL string) (*url.URL, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		// Allow proxies given without a scheme, such as proxy:3128
+		u, err = url.Parse("http://" + rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid proxy URL %q: %v", rawURL, err)
+		}
+	}
+	return u, nil
+}
+
+// bypassProxy reports whether host matches one of the no proxy entries,
+// which are either "*", a host, a domain suffix or an IP range in CIDR
+// notation.
+func bypassProxy(noProxy []string, host string) bool {
+	host = strings.ToLower(host)
+	ip := net.ParseIP(host)
+	for _, entry := range noProxy {
+		if entry == "*" {
+			return true
+		}
+		if _, ipNet, err := net.ParseCIDR(entry); err == nil {
+			if ip != nil && ipNet.Contains(ip) {
+				return true
+			}
+			continue
+		}
+
+		entry = strings.TrimPrefix(entry, ".")
+		if h, _, err := net.SplitHostPort(entry); err == nil {
+			entry = h
+		}
+		if host == entry || strings.HasSuffix(host, "."+entry) {
+			return true
+		}
+	}
+	return false
+}
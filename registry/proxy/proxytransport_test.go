@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestProxyFunc(t *testing.T) {
+	proxy, err := newProxyFunc(configuration.ProxyTransport{
+		HTTPProxy:  "http://proxy.example.com:3128",
+		HTTPSProxy: "proxy.example.com:3129",
+		NoProxy:    "auth.example.com, .internal, 10.0.0.0/8",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for requestURL, expected := range map[string]string{
+		"http://registry.example.com/v2/":  "http://proxy.example.com:3128",
+		"https://registry.example.com/v2/": "http://proxy.example.com:3129",
+		"https://auth.example.com/token":   "",
+		"https://mirror.internal/v2/":      "",
+		"https://10.1.2.3:5000/v2/":        "",
+		"https://11.1.2.3:5000/v2/":        "http://proxy.example.com:3129",
+	} {
+		req, err := http.NewRequest(http.MethodGet, requestURL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		u, err := proxy(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var got string
+		if u != nil {
+			got = u.String()
+		}
+		if got != expected {
+			t.Errorf("%s: expected proxy %q, got %q", requestURL, expected, got)
+		}
+	}
+}
+
+func TestUpstreamTransports(t *testing.T) {
+	var proxied bool
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = true
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxyServer.Close()
+
+	transports, err := parseTransports(map[string]configuration.ProxyTransport{
+		"https://registry.example.com": {HTTPProxy: proxyServer.URL},
+	}, configuration.ProxyConnectionPool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if transports.forHost("quay.io") != http.DefaultTransport {
+		t.Fatal("expected default transport for unconfigured host")
+	}
+
+	client := &http.Client{Transport: transports.forHost("registry.example.com")}
+	resp, err := client.Get("http://registry.example.com/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if !proxied {
+		t.Fatal("request was not sent through the configured proxy")
+	}
+}
+
+func TestConnectionPool(t *testing.T) {
+	transports, err := parseTransports(map[string]configuration.ProxyTransport{
+		"registry.example.com": {Pool: configuration.ProxyConnectionPool{MaxIdleConnsPerHost: 4}},
+	}, configuration.ProxyConnectionPool{MaxIdleConnsPerHost: 16, TLSSessionCacheSize: 64})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Hosts without a transport get a pool of their own
+	pooled, ok := transports.forHost("quay.io").(*http.Transport)
+	if !ok || pooled.MaxIdleConnsPerHost != 16 || pooled.TLSClientConfig.ClientSessionCache == nil {
+		t.Fatalf("unexpected transport for an unconfigured host: %#v", transports.forHost("quay.io"))
+	}
+	if transports.forHost("quay.io") != pooled || transports.forHost("ghcr.io") == pooled {
+		t.Fatal("expected a transport per host")
+	}
+
+	// Transports override the pool
+	configured := transports.forHost("registry.example.com").(*http.Transport)
+	if configured.MaxIdleConnsPerHost != 4 || configured.TLSClientConfig.ClientSessionCache == nil {
+		t.Fatalf("unexpected transport for a configured host: %#v", configured)
+	}
+
+	// The TLS sessions are resumed by new connections
+	upstream := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	pooled.TLSClientConfig.RootCAs = upstream.Client().Transport.(*http.Transport).TLSClientConfig.RootCAs
+	client := &http.Client{Transport: pooled}
+	for i, resumed := range []bool{false, true} {
+		resp, err := client.Get(upstream.URL)
+		if err != nil {
+			t.Fatal(err)
+		}
+		io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.TLS.DidResume != resumed {
+			t.Fatalf("request %d: unexpected resumption %t of the TLS session", i, resp.TLS.DidResume)
+		}
+		pooled.CloseIdleConnections()
+	}
+
+	for _, invalid := range []configuration.ProxyConnectionPool{
+		{MaxIdleConnsPerHost: -1},
+		{IdleConnTimeout: -time.Second},
+	} {
+		if _, err := parseTransports(nil, invalid); err == nil {
+			t.Errorf("expected an error parsing %+v", invalid)
+		}
+		if _, err := parseTransports(map[string]configuration.ProxyTransport{"registry.example.com": {Pool: invalid}}, configuration.ProxyConnectionPool{}); err == nil {
+			t.Errorf("expected an error parsing %+v", invalid)
+		}
+	}
+}
+
+func TestDialFunc(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+	_, port, err := net.SplitHostPort(upstream.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A DNS server which never answers, to observe the queries it receives
+	dns, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dns.Close()
+	queried := make(chan struct{}, 1)
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			if _, _, err := dns.ReadFrom(buf); err != nil {
+				return
+			}
+			select {
+			case queried <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	transports, err := parseTransports(map[string]configuration.ProxyTransport{
+		"registry.example.com": {
+			DNSServers: []string{dns.LocalAddr().String()},
+			Hosts:      map[string]string{"registry.example.com": "127.0.0.1"},
+		},
+		"registry6.example.com": {
+			Hosts:    map[string]string{"registry6.example.com": "127.0.0.1"},
+			IPFamily: "ipv6",
+		},
+	}, configuration.ProxyConnectionPool{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Static hosts bypass DNS
+	client := &http.Client{Transport: transports.forHost("registry.example.com")}
+	resp, err := client.Get("http://registry.example.com:" + port + "/v2/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	// Other hosts are resolved by the configured DNS servers
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, "http://other.example.com/v2/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := client.Do(req); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected resolving with an unresponsive DNS server to fail")
+	}
+	select {
+	case <-queried:
+	default:
+		t.Fatal("the configured DNS server was not queried")
+	}
+
+	// An IPv4 address cannot be reached over IPv6
+	client = &http.Client{Transport: transports.forHost("registry6.example.com")}
+	if resp, err := client.Get("http://registry6.example.com:" + port + "/v2/"); err == nil {
+		resp.Body.Close()
+		t.Fatal("expected dialing an IPv4 address over IPv6 to fail")
+	}
+
+	for _, invalid := range []configuration.ProxyTransport{
+		{DNSServers: []string{"dns.example.com"}},
+		{Hosts: map[string]string{"registry.example.com": "localhost"}},
+		{IPFamily: "ipv5"},
+	} {
+		if _, err := parseTransports(map[string]configuration.ProxyTransport{"registry.example.com": invalid}, configuration.ProxyConnectionPool{}); err == nil {
+			t.Errorf("expected an error parsing %+v", invalid)
+		}
+	}
+}
+
+func TestDNSServerAddr(t *testing.T) {
+	for server, expected := range map[string]string{
+		"10.0.0.2":       "10.0.0.2:53",
+		"10.0.0.2:5353":  "10.0.0.2:5353",
+		"fd00::2":        "[fd00::2]:53",
+		"[fd00::2]":      "[fd00::2]:53",
+		"[fd00::2]:5353": "[fd00::2]:5353",
+	} {
+		addr, err := dnsServerAddr(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if addr != expected {
+			t.Errorf("%s: expected %s, got %s", server, expected, addr)
+		}
+	}
+}
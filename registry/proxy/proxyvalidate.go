@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// Validate checks the configuration of a pull through cache without
+// starting it, nor contacting its upstreams. It returns all the problems
+// found rather than the first, such as for `registry config validate`.
+func Validate(config configuration.Proxy) []error {
+	var errs []error
+	check := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if !config.EnableNamespaces {
+		check(validateUpstreamURL("remoteurl", config.RemoteURL, false))
+		if (config.Username == "") != (config.Password == "") {
+			errs = append(errs, fmt.Errorf("username and password must be set together"))
+		}
+	}
+
+	keys := make([]string, 0, len(config.NamespaceCredentials))
+	for key := range config.NamespaceCredentials {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		credential := config.NamespaceCredentials[key]
+		if err := validateUpstreamURL("credentials", key, true); err != nil {
+			check(err)
+			continue
+		}
+		if (credential.Username == "") != (credential.Password == "") {
+			errs = append(errs, fmt.Errorf("credentials for %s: username and password must be set together", key))
+		}
+	}
+
+	_, err := parsePlatforms(config.Platforms)
+	check(err)
+	_, err = newLayerPrefetcher(config.LayerPrefetch)
+	check(err)
+	_, err = parseTrustPolicies(config.TrustPolicies)
+	check(err)
+	_, err = parseTransports(config.Transports, config.Pool)
+	check(err)
+	resolvers, err := parseResolvers(config.Remotes)
+	check(err)
+	_, err = parsePins(config.PinnedRepositories)
+	check(err)
+	_, err = parseArtifactPolicies(config.ArtifactPolicies)
+	check(err)
+	_, err = newColdArchive(config.Archive)
+	check(err)
+	_, err = newLocalReferrers(config.LocalReferrers, nil)
+	check(err)
+	_, err = newObjectTags(config.ObjectTags)
+	check(err)
+	_, err = parseMirrorJobs(config.MirrorJobs, config.EnableNamespaces)
+	check(err)
+	if resolvers != nil {
+		_, err = configuredNamespaces(config, resolvers)
+		check(err)
+	}
+	_, err = newImageConverter(config.Conversion)
+	check(err)
+	_, err = newSchema1Converter(config.Conversion)
+	check(err)
+	check(validateChallenges(config.Challenges))
+	_, err = parseAuths(config.Auth)
+	check(err)
+	return errs
+}
+
+// validateUpstreamURL checks that an upstream is an http or https URL, or,
+// if allowed, a bare host such as registry-1.docker.io.
+func validateUpstreamURL(field, rawURL string, bareHost bool) error {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		if bareHost && rawURL != "" && !strings.ContainsAny(rawURL, "/ ") {
+			return nil
+		}
+		return fmt.Errorf("%s: invalid upstream %q: not an http or https URL", field, rawURL)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%s: invalid upstream %q: not an http or https URL", field, rawURL)
+	}
+	return nil
+}
@@ -0,0 +1,252 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/opencontainers/go-digest"
+)
+
+// cosignSignatureAnnotation holds the base64 encoded signature of the
+// simple signing payload stored in a cosign signature layer.
+const cosignSignatureAnnotation = "dev.cosignproject.cosign/signature"
+
+// trustPolicy holds the public keys that must have signed the manifests of
+// the matching repositories of an upstream registry.
+type trustPolicy struct {
+	keys         []crypto.PublicKey
+	repositories []string
+}
+
+// parseTrustPolicies loads the configured trust policies, keyed by upstream
+// host.
+func parseTrustPolicies(config map[string]configuration.ProxyTrustPolicy) (map[string]*trustPolicy, error) {
+	policies := make(map[string]*trustPolicy, len(config))
+	for key, policyConfig := range config {
+		host := upstreamHost(key)
+
+		if len(policyConfig.PublicKeys) == 0 {
+			return nil, fmt.Errorf("trust policy for %s has no public keys", host)
+		}
+		for _, pattern := range policyConfig.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid repository pattern %q in trust policy for %s: %v", pattern, host, err)
+			}
+		}
+
+		policy := &trustPolicy{repositories: policyConfig.Repositories}
+		for _, keyPath := range policyConfig.PublicKeys {
+			key, err := loadPublicKey(keyPath)
+			if err != nil {
+				return nil, fmt.Errorf("trust policy for %s: %v", host, err)
+			}
+			policy.keys = append(policy.keys, key)
+		}
+		policies[host] = policy
+	}
+	return policies, nil
+}
+
+// loadPublicKey reads a PEM encoded PKIX public key, as written by
+// `cosign generate-key-pair`.
+func loadPublicKey(keyPath string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM data found in %s", keyPath)
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("unable to parse public key %s: %v", keyPath, err)
+	}
+	return key, nil
+}
+
+// applies reports whether the policy covers the named upstream repository.
+func (p *trustPolicy) applies(name string) bool {
+	if len(p.repositories) == 0 {
+		return true
+	}
+	for _, pattern := range p.repositories {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// trustedDigests records the manifests which are trusted because an image
+// index that references them was verified. Image signatures cover the index
+// a tag points at rather than each of its children.
+type trustedDigests struct {
+	mu      sync.Mutex
+	entries map[string]time.Time
+}
+
+func newTrustedDigests() *trustedDigests {
+	return &trustedDigests{entries: make(map[string]time.Time)}
+}
+
+func (td *trustedDigests) add(repository string, dgst digest.Digest) {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+
+	now := time.Now()
+	for key, expires := range td.entries {
+		if now.After(expires) {
+			delete(td.entries, key)
+		}
+	}
+	td.entries[repository+"@"+dgst.String()] = now.Add(repositoryTTL)
+}
+
+func (td *trustedDigests) contains(repository string, dgst digest.Digest) bool {
+	td.mu.Lock()
+	defer td.mu.Unlock()
+
+	expires, ok := td.entries[repository+"@"+dgst.String()]
+	return ok && time.Now().Before(expires)
+}
+
+// signatureVerifier checks the cosign signatures of manifests pulled from an
+// upstream repository against a trust policy.
+type signatureVerifier struct {
+	policy     *trustPolicy
+	repository string
+	tags       distribution.TagService
+	manifests  distribution.ManifestService
+	blobs      distribution.BlobProvider
+	trusted    *trustedDigests
+}
+
+// simpleSigningPayload holds the fields of a cosign simple signing payload
+// that bind the signature to a manifest.
+type simpleSigningPayload struct {
+	Critical struct {
+		Image struct {
+			DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+		} `json:"image"`
+	} `json:"critical"`
+}
+
+// verify returns an error unless the manifest is signed by one of the keys
+// of the policy, or is referenced by a verified image index. The children of
+// a verified image index are trusted in turn.
+func (sv *signatureVerifier) verify(ctx context.Context, dgst digest.Digest, manifest distribution.Manifest) error {
+	if !sv.trusted.contains(sv.repository, dgst) {
+		if err := sv.verifySignature(ctx, dgst); err != nil {
+			return distribution.ErrManifestVerification{err}
+		}
+	}
+
+	sv.trustChildren(manifest)
+	return nil
+}
+
+// trustChildren trusts the children of an image index which has been
+// verified, either now or before it was cached.
+func (sv *signatureVerifier) trustChildren(manifest distribution.Manifest) {
+	ml, ok := manifest.(*manifestlist.DeserializedManifestList)
+	if !ok {
+		return
+	}
+	for _, child := range ml.Manifests {
+		sv.trusted.add(sv.repository, child.Digest)
+	}
+}
+
+// verifySignature looks up the cosign signature manifest of dgst, which is
+// tagged sha256-<hex>.sig, and checks its signature layers.
+func (sv *signatureVerifier) verifySignature(ctx context.Context, dgst digest.Digest) error {
+	ctx = withFetchReason(ctx, fetchReasonVerification)
+	signatureTag := strings.Replace(dgst.String(), ":", "-", 1) + ".sig"
+	desc, err := sv.tags.Get(ctx, signatureTag)
+	if err != nil {
+		if _, ok := err.(distribution.ErrTagUnknown); ok {
+			return fmt.Errorf("manifest %s is not signed", dgst)
+		}
+		return fmt.Errorf("unable to look up signature of manifest %s: %v", dgst, err)
+	}
+
+	signatures, err := sv.manifests.Get(ctx, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("unable to fetch signature of manifest %s: %v", dgst, err)
+	}
+
+	for _, layer := range signatures.References() {
+		encoded, ok := layer.Annotations[cosignSignatureAnnotation]
+		if !ok {
+			continue
+		}
+
+		if sv.verifyLayer(ctx, dgst, layer, encoded) {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("manifest %s has no signature matching the trust policy", dgst)
+}
+
+// verifyLayer reports whether the signature layer holds a payload for dgst
+// which was signed by one of the keys of the policy.
+func (sv *signatureVerifier) verifyLayer(ctx context.Context, dgst digest.Digest, layer distribution.Descriptor, encoded string) bool {
+	signature, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return false
+	}
+
+	payload, err := sv.blobs.Get(ctx, layer.Digest)
+	if err != nil || layer.Digest.Validate() != nil || layer.Digest.Algorithm().FromBytes(payload) != layer.Digest {
+		return false
+	}
+
+	var p simpleSigningPayload
+	if err := json.Unmarshal(payload, &p); err != nil || p.Critical.Image.DockerManifestDigest != dgst {
+		return false
+	}
+
+	for _, key := range sv.policy.keys {
+		if verifyPayload(key, payload, signature) {
+			return true
+		}
+	}
+	return false
+}
+
+// verifyPayload checks a signature over payload made with the private part
+// of key, hashed with SHA-256 as cosign does.
+func verifyPayload(key crypto.PublicKey, payload, signature []byte) bool {
+	hash := sha256.Sum256(payload)
+	switch key := key.(type) {
+	case *ecdsa.PublicKey:
+		return ecdsa.VerifyASN1(key, hash[:], signature)
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], signature) == nil
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, payload, signature)
+	default:
+		return false
+	}
+}
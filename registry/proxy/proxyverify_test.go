@@ -0,0 +1,218 @@
+package proxy
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// putRawManifest puts an OCI image manifest given as raw JSON.
+func putRawManifest(t *testing.T, repo distribution.Repository, payload string) digest.Digest {
+	ctx := context.Background()
+	manifests, err := repo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m := &ocischema.DeserializedManifest{}
+	if err := m.UnmarshalJSON([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dgst
+}
+
+// signManifest stores a cosign signature of dgst in repo.
+func signManifest(t *testing.T, repo distribution.Repository, key *ecdsa.PrivateKey, dgst digest.Digest) {
+	ctx := context.Background()
+	payload := []byte(fmt.Sprintf(`{"critical":{"identity":{"docker-reference":"example.com/foo"},"image":{"docker-manifest-digest":%q},"type":"cosign container image signature"},"optional":null}`, dgst))
+	hash := sha256.Sum256(payload)
+	signature, err := ecdsa.SignASN1(rand.Reader, key, hash[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	desc, err := repo.Blobs(ctx).Put(ctx, "application/vnd.dev.cosign.simplesigning.v1+json", payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signatureDigest := putRawManifest(t, repo, fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": %q,
+		"config": {"mediaType": %q, "digest": %q, "size": 2},
+		"layers": [{
+			"mediaType": "application/vnd.dev.cosign.simplesigning.v1+json",
+			"digest": %q,
+			"size": %d,
+			"annotations": {%q: %q}
+		}]
+	}`, v1.MediaTypeImageManifest, v1.MediaTypeImageConfig, digest.FromString("{}"), desc.Digest, desc.Size,
+		cosignSignatureAnnotation, base64.StdEncoding.EncodeToString(signature)))
+
+	signatureTag := fmt.Sprintf("%s-%s.sig", dgst.Algorithm(), dgst.Encoded())
+	if err := repo.Tags(ctx).Tag(ctx, signatureTag, distribution.Descriptor{Digest: signatureDigest}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func writePublicKey(t *testing.T, key crypto.PublicKey) string {
+	der, err := x509.MarshalPKIXPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyPath := filepath.Join(t.TempDir(), "cosign.pub")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return keyPath
+}
+
+func TestProxyManifestsVerifySignatures(t *testing.T) {
+	ctx := context.Background()
+	name, err := reference.WithName("foo/signed")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remoteRegistry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteRepo, err := remoteRegistry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	remoteManifests, err := remoteRepo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	localRegistry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	localRepo, err := localRegistry.Repository(ctx, name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	localManifests, err := localRepo.Manifests(ctx, storage.SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	trustedKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	policies, err := parseTrustPolicies(map[string]configuration.ProxyTrustPolicy{
+		"https://example.com": {PublicKeys: []string{writePublicKey(t, trustedKey.Public())}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy, ok := policies["example.com"]
+	if !ok {
+		t.Fatalf("policy not keyed by host: %v", policies)
+	}
+
+	manifestTemplate := `{
+		"schemaVersion": 2,
+		"mediaType": %q,
+		"config": {"mediaType": %q, "digest": %q, "size": 2},
+		"layers": [],
+		"annotations": {"org.example.name": %q}
+	}`
+	signed := putRawManifest(t, remoteRepo, fmt.Sprintf(manifestTemplate, v1.MediaTypeImageManifest, v1.MediaTypeImageConfig, digest.FromString("{}"), "signed"))
+	signManifest(t, remoteRepo, trustedKey, signed)
+	unsigned := putRawManifest(t, remoteRepo, fmt.Sprintf(manifestTemplate, v1.MediaTypeImageManifest, v1.MediaTypeImageConfig, digest.FromString("{}"), "unsigned"))
+	untrusted := putRawManifest(t, remoteRepo, fmt.Sprintf(manifestTemplate, v1.MediaTypeImageManifest, v1.MediaTypeImageConfig, digest.FromString("{}"), "untrusted"))
+	signManifest(t, remoteRepo, otherKey, untrusted)
+
+	s := scheduler.New(ctx, inmemory.New(), "/scheduler-state.json")
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+
+	pms := proxyManifestStore{
+		ctx:             ctx,
+		localManifests:  localManifests,
+		remoteManifests: remoteManifests,
+		repositoryName:  name,
+		scheduler:       s,
+		authChallenger:  &mockChallenger{},
+		verifier: &signatureVerifier{
+			policy:     policy,
+			repository: name.Name(),
+			tags:       remoteRepo.Tags(ctx),
+			manifests:  remoteManifests,
+			blobs:      remoteRepo.Blobs(ctx),
+			trusted:    newTrustedDigests(),
+		},
+	}
+
+	if _, err := pms.Get(ctx, signed); err != nil {
+		t.Fatalf("unexpected error getting signed manifest: %v", err)
+	}
+	if exists, err := localManifests.Exists(ctx, signed); err != nil || !exists {
+		t.Fatalf("signed manifest was not cached: %v", err)
+	}
+
+	for _, dgst := range []digest.Digest{unsigned, untrusted} {
+		_, err := pms.Get(ctx, dgst)
+		if _, ok := err.(distribution.ErrManifestVerification); !ok {
+			t.Fatalf("expected verification error for %s, got %v", dgst, err)
+		}
+		if exists, err := localManifests.Exists(ctx, dgst); err != nil || exists {
+			t.Fatalf("unverified manifest %s was cached: %v", dgst, err)
+		}
+	}
+}
+
+func TestTrustPolicyApplies(t *testing.T) {
+	policy := &trustPolicy{repositories: []string{"library/*", "example/app"}}
+	for name, expected := range map[string]bool{
+		"library/redis":     true,
+		"library/sub/redis": false,
+		"example/app":       true,
+		"example/other":     false,
+	} {
+		if policy.applies(name) != expected {
+			t.Errorf("applies(%q): expected %v", name, expected)
+		}
+	}
+
+	if !(&trustPolicy{}).applies("anything/at/all") {
+		t.Error("policy without repositories should apply to all repositories")
+	}
+}
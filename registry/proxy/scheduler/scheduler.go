@@ -2,7 +2,6 @@ package scheduler
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"sync"
 	"time"
@@ -59,6 +58,7 @@ type TTLExpirationScheduler struct {
 
 	onBlobExpire     expiryFunc
 	onManifestExpire expiryFunc
+	rebuild          RebuildFunc
 
 	indexDirty bool
 	saveTimer  *time.Ticker
@@ -81,6 +81,15 @@ func (ttles *TTLExpirationScheduler) OnManifestExpire(f expiryFunc) {
 	ttles.onManifestExpire = f
 }
 
+// OnRebuild is called to re-derive the entries from the content in storage
+// when neither the state nor its backup can be read
+func (ttles *TTLExpirationScheduler) OnRebuild(f RebuildFunc) {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	ttles.rebuild = f
+}
+
 // AddBlob schedules a blob cleanup after ttl expires
 func (ttles *TTLExpirationScheduler) AddBlob(blobRef reference.Canonical, ttl time.Duration) error {
 	ttles.Lock()
@@ -107,20 +116,28 @@ func (ttles *TTLExpirationScheduler) AddManifest(manifestRef reference.Canonical
 	return nil
 }
 
+// Len returns the number of entries waiting for their TTL to expire
+func (ttles *TTLExpirationScheduler) Len() int {
+	ttles.Lock()
+	defer ttles.Unlock()
+
+	return len(ttles.entries)
+}
+
 // Start starts the scheduler
 func (ttles *TTLExpirationScheduler) Start() error {
 	ttles.Lock()
 	defer ttles.Unlock()
 
+	if !ttles.stopped {
+		return fmt.Errorf("scheduler already started")
+	}
+
 	err := ttles.readState()
 	if err != nil {
 		return err
 	}
 
-	if !ttles.stopped {
-		return fmt.Errorf("scheduler already started")
-	}
-
 	dcontext.GetLogger(ttles.ctx).Infof("Starting cached object TTL expiration scheduler...")
 	ttles.stopped = false
 
@@ -222,39 +239,3 @@ func (ttles *TTLExpirationScheduler) Stop() {
 	ttles.saveTimer.Stop()
 	ttles.stopped = true
 }
-
-func (ttles *TTLExpirationScheduler) writeState() error {
-	jsonBytes, err := json.Marshal(ttles.entries)
-	if err != nil {
-		return err
-	}
-
-	err = ttles.driver.PutContent(ttles.ctx, ttles.pathToStateFile, jsonBytes)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
-
-func (ttles *TTLExpirationScheduler) readState() error {
-	if _, err := ttles.driver.Stat(ttles.ctx, ttles.pathToStateFile); err != nil {
-		switch err := err.(type) {
-		case driver.PathNotFoundError:
-			return nil
-		default:
-			return err
-		}
-	}
-
-	bytes, err := ttles.driver.GetContent(ttles.ctx, ttles.pathToStateFile)
-	if err != nil {
-		return err
-	}
-
-	err = json.Unmarshal(bytes, &ttles.entries)
-	if err != nil {
-		return err
-	}
-	return nil
-}
@@ -0,0 +1,321 @@
+package scheduler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// stateVersion is the version of the state written, whose entries are
+// checksummed. A state without a version holds the entries alone, as
+// written by earlier releases.
+const stateVersion = 2
+
+// Suffixes of the files kept next to the state
+const (
+	tempSuffix    = ".tmp"     // the state being written
+	backupSuffix  = ".bak"     // the state written before the current one
+	corruptSuffix = ".corrupt" // the last state which could not be read
+)
+
+// Sources of the entries a scheduler starts with
+const (
+	SourceState   = "state"
+	SourceBackup  = "backup"
+	SourceRebuild = "rebuild"
+	SourceEmpty   = "empty"
+)
+
+// stateFile is the serialization of the entries of a scheduler.
+type stateFile struct {
+	Version  int             `json:"version"`
+	Checksum digest.Digest   `json:"checksum"`
+	Entries  json.RawMessage `json:"entries"`
+}
+
+// Entry is content whose expiry is scheduled, as re-derived by a rebuild.
+type Entry struct {
+	Ref      reference.Canonical
+	Manifest bool
+	TTL      time.Duration
+}
+
+// RebuildFunc re-derives the entries of a scheduler from the content in
+// storage.
+type RebuildFunc func(ctx context.Context) ([]Entry, error)
+
+// LoadReport describes where the entries of a scheduler were loaded from.
+type LoadReport struct {
+	Source  string `json:"source"`
+	Entries int    `json:"entries"`
+	Dropped int    `json:"dropped"` // invalid entries left out
+}
+
+// RepairOpts configures a repair of the state of a scheduler.
+type RepairOpts struct {
+	// Rebuild re-derives the entries from storage even if the state can
+	// be read
+	Rebuild bool
+	// DryRun reports how the state would be repaired without writing it
+	DryRun bool
+}
+
+// corruptStateError is returned when a state can't be decoded or doesn't
+// match its checksum.
+type corruptStateError struct {
+	path   string
+	reason string
+}
+
+func (e corruptStateError) Error() string {
+	return fmt.Sprintf("scheduler state %s is corrupt: %s", e.path, e.reason)
+}
+
+func isPathNotFound(err error) bool {
+	_, ok := err.(driver.PathNotFoundError)
+	return ok
+}
+
+func isCorrupt(err error) bool {
+	_, ok := err.(corruptStateError)
+	return ok
+}
+
+// encodeState serializes the entries, with their checksum.
+func encodeState(entries map[string]*schedulerEntry) ([]byte, error) {
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(stateFile{
+		Version:  stateVersion,
+		Checksum: digest.FromBytes(content),
+		Entries:  content,
+	})
+}
+
+// decodeState deserializes the entries of the state at path, checking
+// their checksum.
+func decodeState(path string, content []byte) (map[string]*schedulerEntry, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(content, &fields); err != nil {
+		return nil, corruptStateError{path: path, reason: err.Error()}
+	}
+
+	entries := make(map[string]*schedulerEntry)
+	if _, ok := fields["version"]; !ok {
+		if err := json.Unmarshal(content, &entries); err != nil {
+			return nil, corruptStateError{path: path, reason: err.Error()}
+		}
+		return entries, nil
+	}
+
+	var sf stateFile
+	if err := json.Unmarshal(content, &sf); err != nil {
+		return nil, corruptStateError{path: path, reason: err.Error()}
+	}
+	if sf.Version != stateVersion {
+		return nil, corruptStateError{path: path, reason: fmt.Sprintf("unsupported version %d", sf.Version)}
+	}
+	var compacted bytes.Buffer
+	if err := json.Compact(&compacted, sf.Entries); err != nil {
+		return nil, corruptStateError{path: path, reason: err.Error()}
+	}
+	if digest.FromBytes(compacted.Bytes()) != sf.Checksum {
+		return nil, corruptStateError{path: path, reason: "checksum mismatch"}
+	}
+	if err := json.Unmarshal(compacted.Bytes(), &entries); err != nil {
+		return nil, corruptStateError{path: path, reason: err.Error()}
+	}
+	return entries, nil
+}
+
+// compactEntries leaves out the entries which can't be expired, and
+// returns how many were.
+func compactEntries(entries map[string]*schedulerEntry) (map[string]*schedulerEntry, int) {
+	compacted := make(map[string]*schedulerEntry, len(entries))
+	for _, entry := range entries {
+		if entry == nil || (entry.EntryType != entryTypeBlob && entry.EntryType != entryTypeManifest) {
+			continue
+		}
+		if ref, err := reference.Parse(entry.Key); err != nil {
+			continue
+		} else if _, ok := ref.(reference.Canonical); !ok {
+			continue
+		}
+		compacted[entry.Key] = entry
+	}
+	return compacted, len(entries) - len(compacted)
+}
+
+func readStateFile(ctx context.Context, d driver.StorageDriver, path string) (map[string]*schedulerEntry, error) {
+	content, err := d.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	return decodeState(path, content)
+}
+
+// writeStateFile writes the entries to a temporary file which then replaces
+// the state, so that the state is never partially written. The state it
+// replaces is kept as a backup.
+func writeStateFile(ctx context.Context, d driver.StorageDriver, path string, entries map[string]*schedulerEntry) error {
+	content, err := encodeState(entries)
+	if err != nil {
+		return err
+	}
+	if err := d.PutContent(ctx, path+tempSuffix, content); err != nil {
+		return err
+	}
+	if err := d.Move(ctx, path, path+backupSuffix); err != nil && !isPathNotFound(err) {
+		return err
+	}
+	return d.Move(ctx, path+tempSuffix, path)
+}
+
+// setAside moves the state which could not be read out of the way of the
+// next write, which would otherwise replace the backup with it.
+func setAside(ctx context.Context, d driver.StorageDriver, path string) error {
+	if err := d.Move(ctx, path, path+corruptSuffix); err != nil && !isPathNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// loadState reads the entries of the state at path. The backup is read if
+// the state is missing or corrupt, and the entries are rebuilt if the
+// backup is unusable too. Errors of the storage are returned as they are.
+func loadState(ctx context.Context, d driver.StorageDriver, path string, rebuild RebuildFunc) (map[string]*schedulerEntry, LoadReport, error) {
+	log := dcontext.GetLogger(ctx)
+	report := func(entries map[string]*schedulerEntry, source string) (map[string]*schedulerEntry, LoadReport, error) {
+		entries, dropped := compactEntries(entries)
+		if dropped > 0 {
+			log.Warnf("Dropped %d invalid scheduler entries", dropped)
+		}
+		return entries, LoadReport{Source: source, Entries: len(entries), Dropped: dropped}, nil
+	}
+
+	entries, err := readStateFile(ctx, d, path)
+	if err == nil {
+		return report(entries, SourceState)
+	}
+	missing := isPathNotFound(err)
+	if !missing && !isCorrupt(err) {
+		return nil, LoadReport{}, err
+	}
+	if !missing {
+		log.Errorf("%s, reading its backup", err)
+	}
+
+	entries, err = readStateFile(ctx, d, path+backupSuffix)
+	if err == nil {
+		if missing {
+			log.Warnf("Scheduler state %s is missing, reading its backup", path)
+		}
+		return report(entries, SourceBackup)
+	}
+	if isPathNotFound(err) && missing {
+		// no state was ever written
+		return report(nil, SourceEmpty)
+	}
+	if !isPathNotFound(err) && !isCorrupt(err) {
+		return nil, LoadReport{}, err
+	}
+	log.Errorf("Scheduler state %s can't be recovered from its backup: %s", path, err)
+
+	if rebuild == nil {
+		log.Errorf("Starting with an empty scheduler state: the content cached expires once it is cached again")
+		return report(nil, SourceEmpty)
+	}
+	entries, err = rebuildEntries(ctx, rebuild)
+	if err != nil {
+		return nil, LoadReport{}, err
+	}
+	return report(entries, SourceRebuild)
+}
+
+// rebuildEntries re-derives the entries from storage, expiring after their
+// TTL from now.
+func rebuildEntries(ctx context.Context, rebuild RebuildFunc) (map[string]*schedulerEntry, error) {
+	dcontext.GetLogger(ctx).Infof("Rebuilding the scheduler state from storage")
+	rebuilt, err := rebuild(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to rebuild the scheduler state: %v", err)
+	}
+
+	now := time.Now()
+	entries := make(map[string]*schedulerEntry, len(rebuilt))
+	for _, e := range rebuilt {
+		entry := &schedulerEntry{
+			Key:       e.Ref.String(),
+			Expiry:    now.Add(e.TTL),
+			EntryType: entryTypeBlob,
+		}
+		if e.Manifest {
+			entry.EntryType = entryTypeManifest
+		}
+		entries[entry.Key] = entry
+	}
+	return entries, nil
+}
+
+func (ttles *TTLExpirationScheduler) writeState() error {
+	return writeStateFile(ttles.ctx, ttles.driver, ttles.pathToStateFile, ttles.entries)
+}
+
+func (ttles *TTLExpirationScheduler) readState() error {
+	entries, report, err := loadState(ttles.ctx, ttles.driver, ttles.pathToStateFile, ttles.rebuild)
+	if err != nil {
+		return err
+	}
+	if report.Source != SourceState {
+		if err := setAside(ttles.ctx, ttles.driver, ttles.pathToStateFile); err != nil {
+			return err
+		}
+	}
+	// the state is written back once the entries changed from those read
+	ttles.indexDirty = report.Source == SourceBackup || report.Source == SourceRebuild || report.Dropped > 0
+	ttles.entries = entries
+	return nil
+}
+
+// Repair loads the state at path as a scheduler starting does, falling back
+// to its backup and then to a rebuild, and writes it back checksummed
+// without its invalid entries. It is not to be run while a registry uses
+// the state.
+func Repair(ctx context.Context, d driver.StorageDriver, path string, rebuild RebuildFunc, opts RepairOpts) (LoadReport, error) {
+	var entries map[string]*schedulerEntry
+	var report LoadReport
+	if opts.Rebuild {
+		if rebuild == nil {
+			return LoadReport{}, fmt.Errorf("the scheduler state can't be rebuilt")
+		}
+		var err error
+		if entries, err = rebuildEntries(ctx, rebuild); err != nil {
+			return LoadReport{}, err
+		}
+		report = LoadReport{Source: SourceRebuild, Entries: len(entries)}
+	} else {
+		var err error
+		if entries, report, err = loadState(ctx, d, path, rebuild); err != nil {
+			return LoadReport{}, err
+		}
+	}
+	if opts.DryRun {
+		return report, nil
+	}
+
+	if report.Source != SourceState && !opts.Rebuild {
+		if err := setAside(ctx, d, path); err != nil {
+			return LoadReport{}, err
+		}
+	}
+	return report, writeStateFile(ctx, d, path, entries)
+}
@@ -0,0 +1,100 @@
+package scheduler
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestStateRecovery(t *testing.T) {
+	ref1, ref2, ref3 := testRefs(t)
+	ctx := context.Background()
+	fs := inmemory.New()
+	path := "/ttl"
+
+	// two writes, so that the first is kept as the backup
+	s := New(ctx, fs, path)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	s.add(ref1, time.Hour, entryTypeBlob)
+	if err := s.writeState(); err != nil {
+		t.Fatal(err)
+	}
+	s.add(ref2, time.Hour, entryTypeManifest)
+	s.Stop()
+
+	if err := fs.PutContent(ctx, path, []byte(`{"version":2,"checksum":"sha256:`)); err != nil {
+		t.Fatal(err)
+	}
+	s = New(ctx, fs, path)
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.entries[ref1.String()]; !ok || s.Len() != 1 {
+		t.Fatalf("expected the entries of the backup, got %v", s.entries)
+	}
+	s.Stop()
+	if _, err := fs.GetContent(ctx, path+corruptSuffix); err != nil {
+		t.Fatalf("expected the corrupt state to be set aside: %v", err)
+	}
+
+	// the entries are rebuilt when the backup is corrupt too
+	for _, p := range []string{path, path + backupSuffix} {
+		if err := fs.PutContent(ctx, p, []byte(`{"version":2,"checksum":"sha256:0","entries":{}}`)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s = New(ctx, fs, path)
+	s.OnRebuild(func(ctx context.Context) ([]Entry, error) {
+		return []Entry{{Ref: ref3.(reference.Canonical), Manifest: true, TTL: time.Hour}}, nil
+	})
+	if err := s.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer s.Stop()
+	if entry, ok := s.entries[ref3.String()]; !ok || s.Len() != 1 || entry.EntryType != entryTypeManifest {
+		t.Fatalf("expected the rebuilt entries, got %v", s.entries)
+	}
+}
+
+func TestRepair(t *testing.T) {
+	ref1, _, _ := testRefs(t)
+	ctx := context.Background()
+	fs := inmemory.New()
+	path := "/ttl"
+
+	legacy := []byte(`{"` + ref1.String() + `":{"Key":"` + ref1.String() + `","ExpiryData":"2030-01-01T00:00:00Z","EntryType":0},"invalid":{"Key":"invalid","EntryType":0}}`)
+	if err := fs.PutContent(ctx, path, legacy); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Repair(ctx, fs, path, nil, RepairOpts{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report != (LoadReport{Source: SourceState, Entries: 1, Dropped: 1}) {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if content, err := fs.GetContent(ctx, path); err != nil || string(content) != string(legacy) {
+		t.Fatalf("unexpected state written by a dry run: %s", content)
+	}
+
+	if _, err := Repair(ctx, fs, path, nil, RepairOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	entries, err := readStateFile(ctx, fs, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := entries[ref1.String()]; !ok || len(entries) != 1 {
+		t.Fatalf("unexpected entries of the repaired state %v", entries)
+	}
+
+	if _, err := Repair(ctx, fs, path, nil, RepairOpts{Rebuild: true}); err == nil {
+		t.Fatal("expected an error rebuilding without a rebuild function")
+	}
+}
@@ -0,0 +1,185 @@
+// Package ratelimit limits the rate of the requests, and the concurrent
+// uploads, of each client of the registry, so that runaway clients such as
+// CI loops can't overload the registry and its upstreams.
+//
+// The rate of each client is limited with a token bucket, refilled at the
+// configured rate up to the burst.
+package ratelimit
+
+import (
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+// Keys the clients can be identified by.
+const (
+	KeyIdentity = "identity"
+	KeyIP       = "ip"
+)
+
+// sweepInterval is the interval between the removals of the clients which
+// have been idle long enough for their bucket to be full.
+const sweepInterval = time.Minute
+
+type client struct {
+	tokens  float64
+	last    time.Time
+	uploads int
+}
+
+// Limiter limits the requests of clients.
+type Limiter struct {
+	// Key identifies the clients, KeyIdentity or KeyIP
+	Key string
+
+	rate    float64
+	burst   float64
+	uploads int
+
+	mu        sync.Mutex
+	clients   map[string]*client
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+// New validates the configuration and returns the limiter it defines.
+func New(config configuration.RateLimit) (*Limiter, error) {
+	if config.Requests < 0 || config.Burst < 0 || config.Uploads < 0 {
+		return nil, fmt.Errorf("ratelimit: requests, burst and uploads must not be negative")
+	}
+	l := &Limiter{
+		Key:     config.Key,
+		rate:    config.Requests,
+		burst:   float64(config.Burst),
+		uploads: config.Uploads,
+		clients: make(map[string]*client),
+		now:     time.Now,
+	}
+	switch l.Key {
+	case "":
+		l.Key = KeyIdentity
+	case KeyIdentity, KeyIP:
+	default:
+		return nil, fmt.Errorf("ratelimit: unknown key %q", config.Key)
+	}
+	if l.burst == 0 {
+		l.burst = math.Max(math.Ceil(l.rate), 1)
+	}
+	return l, nil
+}
+
+// Update changes the limits to those of the configuration, such as when it
+// is reloaded. The buckets of the clients are kept, but for the tokens
+// beyond the new burst. The key can't be changed.
+func (l *Limiter) Update(config configuration.RateLimit) error {
+	updated, err := New(config)
+	if err != nil {
+		return err
+	}
+	if updated.Key != l.Key {
+		return fmt.Errorf("ratelimit: the key can't be changed without a restart")
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.rate, l.burst, l.uploads = updated.rate, updated.burst, updated.uploads
+	for _, c := range l.clients {
+		c.tokens = math.Min(c.tokens, l.burst)
+	}
+	return nil
+}
+
+// Allow takes a request of the client from its bucket. If the bucket is
+// empty, it returns false and how long to wait for the next request to be
+// allowed.
+func (l *Limiter) Allow(key string) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return true, 0
+	}
+
+	c := l.client(key)
+	now := l.now()
+	c.tokens = math.Min(l.burst, c.tokens+now.Sub(c.last).Seconds()*l.rate)
+	c.last = now
+	if c.tokens < 1 {
+		return false, time.Duration((1 - c.tokens) / l.rate * float64(time.Second))
+	}
+	c.tokens--
+	return true, 0
+}
+
+// Return gives back a request taken from the bucket of the client, such as
+// when the request is charged to another client once authenticated.
+func (l *Limiter) Return(key string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.rate == 0 {
+		return
+	}
+
+	c := l.client(key)
+	c.tokens = math.Min(l.burst, c.tokens+1)
+}
+
+// StartUpload reserves an upload of the client, if it has less than the
+// limit in progress. The returned function releases the upload.
+func (l *Limiter) StartUpload(key string) (func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.uploads == 0 {
+		return func() {}, true
+	}
+
+	c := l.client(key)
+	if c.uploads >= l.uploads {
+		return nil, false
+	}
+	c.uploads++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			l.mu.Lock()
+			c.uploads--
+			l.mu.Unlock()
+		})
+	}, true
+}
+
+// client returns the state of the client, with l.mu held, creating it
+// with a full bucket.
+func (l *Limiter) client(key string) *client {
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	c, ok := l.clients[key]
+	if !ok {
+		c = &client{tokens: l.burst, last: now}
+		l.clients[key] = c
+	}
+	return c
+}
+
+// sweep removes the clients whose bucket is full, and which have no upload
+// in progress, as they would be created again with the same state.
+func (l *Limiter) sweep(now time.Time) {
+	for key, c := range l.clients {
+		full := l.rate == 0 || c.tokens+now.Sub(c.last).Seconds()*l.rate >= l.burst
+		if full && c.uploads == 0 {
+			delete(l.clients, key)
+		}
+	}
+	l.lastSweep = now
+}
@@ -0,0 +1,147 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+)
+
+func TestAllow(t *testing.T) {
+	l, err := New(configuration.RateLimit{Requests: 2, Burst: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatalf("expected request %d of the burst to be allowed", i)
+		}
+	}
+	ok, wait := l.Allow("alice")
+	if ok || wait != 500*time.Millisecond {
+		t.Fatalf("expected the request to wait 500ms, got %v %v", ok, wait)
+	}
+	// Clients have buckets of their own
+	if ok, _ := l.Allow("bob"); !ok {
+		t.Fatalf("expected the request of another client to be allowed")
+	}
+
+	now = now.Add(500 * time.Millisecond)
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Fatalf("expected the request to be allowed once the bucket refilled")
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Fatalf("expected the request to be limited")
+	}
+
+	// Idle clients are forgotten
+	now = now.Add(time.Hour)
+	l.Allow("carol")
+	if len(l.clients) != 1 {
+		t.Fatalf("expected the idle clients to be removed, got %d clients", len(l.clients))
+	}
+}
+
+func TestReturn(t *testing.T) {
+	l, err := New(configuration.RateLimit{Requests: 1, Burst: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+
+	l.Allow("alice")
+	l.Return("alice")
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Fatalf("expected the returned request to be allowed")
+	}
+	// The bucket doesn't overflow
+	l.Return("alice")
+	l.Return("alice")
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Fatalf("expected the returned request to be allowed")
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Fatalf("expected the returned requests to be capped by the burst")
+	}
+}
+
+func TestStartUpload(t *testing.T) {
+	l, err := New(configuration.RateLimit{Uploads: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := l.Allow("alice"); !ok {
+		t.Fatalf("expected the requests not to be limited")
+	}
+
+	release1, ok1 := l.StartUpload("alice")
+	_, ok2 := l.StartUpload("alice")
+	_, ok3 := l.StartUpload("alice")
+	if !ok1 || !ok2 || ok3 {
+		t.Fatalf("expected two uploads to be allowed, got %v %v %v", ok1, ok2, ok3)
+	}
+	if _, ok := l.StartUpload("bob"); !ok {
+		t.Fatalf("expected the upload of another client to be allowed")
+	}
+
+	release1()
+	release1()
+	if _, ok := l.StartUpload("alice"); !ok {
+		t.Fatalf("expected an upload to be allowed once one finished")
+	}
+	if _, ok := l.StartUpload("alice"); ok {
+		t.Fatalf("expected the upload to be limited, as the release only counts once")
+	}
+}
+
+func TestNewInvalid(t *testing.T) {
+	for _, config := range []configuration.RateLimit{
+		{Requests: -1},
+		{Uploads: -1},
+		{Requests: 1, Key: "token"},
+	} {
+		if _, err := New(config); err == nil {
+			t.Errorf("expected %+v to be invalid", config)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	l, err := New(configuration.RateLimit{Requests: 1, Burst: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1700000000, 0)
+	l.now = func() time.Time { return now }
+
+	l.Allow("alice")
+	if err := l.Update(configuration.RateLimit{Requests: 1, Burst: 2, Uploads: 1}); err != nil {
+		t.Fatalf("unexpected error updating the limits: %v", err)
+	}
+	// the bucket of the client is capped by the new burst
+	for i := 0; i < 2; i++ {
+		if ok, _ := l.Allow("alice"); !ok {
+			t.Fatalf("expected request %d of the new burst to be allowed", i)
+		}
+	}
+	if ok, _ := l.Allow("alice"); ok {
+		t.Fatalf("expected the request to be limited by the new burst")
+	}
+	if _, ok := l.StartUpload("alice"); !ok {
+		t.Fatalf("expected the first upload to be allowed")
+	}
+	if _, ok := l.StartUpload("alice"); ok {
+		t.Fatalf("expected the uploads to be limited")
+	}
+
+	if err := l.Update(configuration.RateLimit{Key: KeyIP, Requests: 1}); err == nil {
+		t.Fatalf("expected an error changing the key")
+	}
+	if err := l.Update(configuration.RateLimit{Requests: -1}); err == nil {
+		t.Fatalf("expected an error with a negative rate")
+	}
+}
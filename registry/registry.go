@@ -6,6 +6,7 @@ import (
 	"crypto/x509"
 	"fmt"
 	"net/http"
+	"net/http/pprof"
 	"os"
 	"os/signal"
 	"strings"
@@ -17,18 +18,18 @@ import (
 	logstash "github.com/bshuster-repo/logrus-logstash-hook"
 	"github.com/bugsnag/bugsnag-go"
 	"github.com/docker/go-metrics"
-	gorhandlers "github.com/gorilla/handlers"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 	"github.com/yvasiyarov/gorelic"
 	"golang.org/x/crypto/acme"
-	"golang.org/x/crypto/acme/autocert"
 
 	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
 	"github.com/distribution/distribution/v3/health"
+	"github.com/distribution/distribution/v3/registry/accesslog"
 	"github.com/distribution/distribution/v3/registry/handlers"
 	"github.com/distribution/distribution/v3/registry/listener"
+	"github.com/distribution/distribution/v3/tracing"
 	"github.com/distribution/distribution/v3/uuid"
 	"github.com/distribution/distribution/v3/version"
 )
@@ -106,6 +107,8 @@ var ServeCmd = &cobra.Command{
 		}
 
 		configureDebugServer(config)
+		handleReadOnlySignals(registry.app)
+		handleReloadSignals(registry, args)
 
 		if err = registry.ListenAndServe(); err != nil {
 			logrus.Fatalln(err)
@@ -117,9 +120,13 @@ var ServeCmd = &cobra.Command{
 //
 // TODO(aaronl): It might make sense for Registry to become an interface.
 type Registry struct {
-	config *configuration.Configuration
-	app    *handlers.App
-	server *http.Server
+	config    *configuration.Configuration
+	app       *handlers.App
+	server    *http.Server
+	listeners []*planeListener
+	tracer    *tracing.Tracer
+
+	drainTracker *drainTracker
 }
 
 // NewRegistry creates a new registry from a context and configuration struct.
@@ -136,6 +143,15 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	// with uuid generation under low entropy.
 	uuid.Loggerf = dcontext.GetLogger(ctx).Warnf
 
+	var tracer *tracing.Tracer
+	if config.Tracing.Enabled {
+		tracer, err = tracing.NewFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		tracing.SetTracer(tracer)
+	}
+
 	app := handlers.NewApp(ctx, config)
 	// TODO(aaronl): The global scope of the health checks means NewRegistry
 	// can only be called once per process.
@@ -145,20 +161,47 @@ func NewRegistry(ctx context.Context, config *configuration.Configuration) (*Reg
 	handler = health.Handler(handler)
 	handler = panicHandler(handler)
 	if !config.Log.AccessLog.Disabled {
-		handler = gorhandlers.CombinedLoggingHandler(os.Stdout, handler)
+		handler, err = accesslog.NewHandler(config.Log.AccessLog, handler)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	tracker := &drainTracker{}
+	handler = tracker.handler(handler)
+
+	listeners, handler, err := configureListeners(config, handler)
+	if err != nil {
+		return nil, err
 	}
 
 	server := &http.Server{
 		Handler: handler,
 	}
+	if server.Handler, err = configureHTTP2(server, config); err != nil {
+		return nil, err
+	}
 
 	return &Registry{
-		app:    app,
-		config: config,
-		server: server,
+		app:          app,
+		config:       config,
+		server:       server,
+		listeners:    listeners,
+		tracer:       tracer,
+		drainTracker: tracker,
 	}, nil
 }
 
+// Reload applies the log level of the configuration, and the sections the
+// application can reload, without interrupting the requests in progress.
+func (registry *Registry) Reload(config *configuration.Configuration) error {
+	if err := registry.app.Reload(config); err != nil {
+		return err
+	}
+	logrus.SetLevel(logLevel(config.Log.Level))
+	return nil
+}
+
 // takes a list of cipher suites and converts it to a list of respective tls constants
 // if an empty list is provided, then the defaults will be used
 func getCipherSuites(names []string) ([]uint16, error) {
@@ -197,7 +240,8 @@ func (registry *Registry) ListenAndServe() error {
 		return err
 	}
 
-	if config.HTTP.TLS.Certificate != "" || config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
+	letsEncrypt := config.HTTP.TLS.LetsEncrypt.CacheFile != "" || config.HTTP.TLS.LetsEncrypt.Storage
+	if config.HTTP.TLS.Certificate != "" || letsEncrypt {
 		if config.HTTP.TLS.MinimumTLS == "" {
 			config.HTTP.TLS.MinimumTLS = defaultTLSVersionStr
 		}
@@ -227,24 +271,19 @@ func (registry *Registry) ListenAndServe() error {
 			CipherSuites: tlsCipherSuites,
 		}
 
-		if config.HTTP.TLS.LetsEncrypt.CacheFile != "" {
+		if letsEncrypt {
 			if config.HTTP.TLS.Certificate != "" {
 				return fmt.Errorf("cannot specify both certificate and Let's Encrypt")
 			}
-			m := &autocert.Manager{
-				HostPolicy: autocert.HostWhitelist(config.HTTP.TLS.LetsEncrypt.Hosts...),
-				Cache:      autocert.DirCache(config.HTTP.TLS.LetsEncrypt.CacheFile),
-				Email:      config.HTTP.TLS.LetsEncrypt.Email,
-				Prompt:     autocert.AcceptTOS,
-			}
+			m := letsEncryptManager(config, registry.app.Driver())
 			tlsConf.GetCertificate = m.GetCertificate
 			tlsConf.NextProtos = append(tlsConf.NextProtos, acme.ALPNProto)
 		} else {
-			tlsConf.Certificates = make([]tls.Certificate, 1)
-			tlsConf.Certificates[0], err = tls.LoadX509KeyPair(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key)
+			certs, err := newCertReloader(config.HTTP.TLS.Certificate, config.HTTP.TLS.Key)
 			if err != nil {
 				return err
 			}
+			tlsConf.GetCertificate = certs.GetCertificate
 		}
 
 		if len(config.HTTP.TLS.ClientCAs) != 0 {
@@ -265,7 +304,14 @@ func (registry *Registry) ListenAndServe() error {
 				dcontext.GetLogger(registry.app).Debugf("CA Subject: %s", string(subj))
 			}
 
-			tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+			switch config.HTTP.TLS.ClientAuth {
+			case "", "require":
+				tlsConf.ClientAuth = tls.RequireAndVerifyClientCert
+			case "optional":
+				tlsConf.ClientAuth = tls.VerifyClientCertIfGiven
+			default:
+				return fmt.Errorf("unknown client auth %q", config.HTTP.TLS.ClientAuth)
+			}
 			tlsConf.ClientCAs = pool
 		}
 
@@ -275,6 +321,11 @@ func (registry *Registry) ListenAndServe() error {
 		dcontext.GetLogger(registry.app).Infof("listening on %v", ln.Addr())
 	}
 
+	if err := registry.serveListeners(); err != nil {
+		ln.Close()
+		return err
+	}
+
 	if config.HTTP.DrainTimeout == 0 {
 		return registry.server.Serve(ln)
 	}
@@ -296,15 +347,23 @@ func (registry *Registry) ListenAndServe() error {
 		// shutdown the server with a grace period of configured timeout
 		c, cancel := context.WithTimeout(context.Background(), config.HTTP.DrainTimeout)
 		defer cancel()
-		return registry.server.Shutdown(c)
+		err := registry.drain(c)
+		if drainErr := registry.app.Shutdown(c); drainErr != nil {
+			dcontext.GetLogger(registry.app).Errorf("error draining background work: %v", drainErr)
+		}
+		if tracerErr := registry.tracer.Shutdown(c); tracerErr != nil {
+			dcontext.GetLogger(registry.app).Errorf("error exporting the traces: %v", tracerErr)
+		}
+		return err
 	}
 }
 
 func configureDebugServer(config *configuration.Configuration) {
 	if config.HTTP.Debug.Addr != "" {
+		handler := debugHandler(config)
 		go func(addr string) {
 			logrus.Infof("debug server listening %v", addr)
-			if err := http.ListenAndServe(addr, nil); err != nil {
+			if err := http.ListenAndServe(addr, handler); err != nil {
 				logrus.Fatalf("error listening on debug interface: %v", err)
 			}
 		}(config.HTTP.Debug.Addr)
@@ -312,6 +371,25 @@ func configureDebugServer(config *configuration.Configuration) {
 	}
 }
 
+// debugHandler serves the handlers of the default mux, such as expvar and
+// the health checks, and the profiles of pprof if they are enabled.
+func debugHandler(config *configuration.Configuration) http.Handler {
+	mux := http.NewServeMux()
+	mux.Handle("/", http.DefaultServeMux)
+	if !config.HTTP.Debug.Pprof.Enabled {
+		// importing pprof registers it on the default mux
+		mux.Handle("/debug/pprof/", http.NotFoundHandler())
+		return mux
+	}
+	logrus.Info("providing pprof profiles on /debug/pprof/")
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return mux
+}
+
 func configurePrometheus(config *configuration.Configuration) {
 	if config.HTTP.Debug.Prometheus.Enabled {
 		path := config.HTTP.Debug.Prometheus.Path
@@ -457,7 +535,9 @@ func alive(path string, handler http.Handler) http.Handler {
 	})
 }
 
-func resolveConfiguration(args []string) (*configuration.Configuration, error) {
+// resolveConfigurationPath returns the path of the configuration file, the
+// first argument or else the REGISTRY_CONFIGURATION_PATH variable.
+func resolveConfigurationPath(args []string) (string, error) {
 	var configurationPath string
 
 	if len(args) > 0 {
@@ -467,7 +547,15 @@ func resolveConfiguration(args []string) (*configuration.Configuration, error) {
 	}
 
 	if configurationPath == "" {
-		return nil, fmt.Errorf("configuration path unspecified")
+		return "", fmt.Errorf("configuration path unspecified")
+	}
+	return configurationPath, nil
+}
+
+func resolveConfiguration(args []string) (*configuration.Configuration, error) {
+	configurationPath, err := resolveConfigurationPath(args)
+	if err != nil {
+		return nil, err
 	}
 
 	fp, err := os.Open(configurationPath)
@@ -17,6 +17,7 @@ import (
 	"math/big"
 	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
@@ -26,8 +27,10 @@ import (
 
 	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
-	_ "github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/acme/autocert"
+	"golang.org/x/net/http2"
 	"gopkg.in/yaml.v2"
 )
 
@@ -424,3 +427,201 @@ log:
 		t.Error("field baz not configured correctly; expected 'xyzzy' got: ", val)
 	}
 }
+
+func TestDebugHandler(t *testing.T) {
+	config := &configuration.Configuration{}
+	for _, tc := range []struct {
+		enabled bool
+		path    string
+		status  int
+	}{
+		{false, "/debug/pprof/goroutine?debug=2", http.StatusNotFound},
+		{false, "/debug/vars", http.StatusOK},
+		{true, "/debug/pprof/goroutine?debug=2", http.StatusOK},
+		{true, "/debug/pprof/cmdline", http.StatusOK},
+		{true, "/debug/vars", http.StatusOK},
+	} {
+		config.HTTP.Debug.Pprof.Enabled = tc.enabled
+		w := httptest.NewRecorder()
+		debugHandler(config).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tc.path, nil))
+		if w.Code != tc.status {
+			t.Errorf("unexpected status of %s with pprof enabled %v: %d", tc.path, tc.enabled, w.Code)
+		}
+	}
+}
+
+func TestConfigureListeners(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.Prefix = "/registry/"
+	config.HTTP.Listeners.Metrics.Addr = "127.0.0.1:5002"
+	config.HTTP.Listeners.Admin.Addr = "127.0.0.1:5003"
+	config.HTTP.Listeners.Admin.Auth.Token = "secret"
+	ok := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {})
+
+	listeners, api, err := configureListeners(config, ok)
+	if err != nil {
+		t.Fatalf("unexpected error configuring the listeners: %v", err)
+	}
+	if len(listeners) != 2 || listeners[0].name != "metrics" || listeners[1].name != "admin" {
+		t.Fatalf("unexpected listeners: %v", listeners)
+	}
+	metricsHandler, admin := listeners[0].server.Handler, listeners[1].server.Handler
+
+	for _, tc := range []struct {
+		handler http.Handler
+		path    string
+		token   string
+		status  int
+	}{
+		{api, "/registry/v2/", "", http.StatusOK},
+		{api, "/registry/v2/_admin/readonly", "", http.StatusNotFound},
+		{api, "/registry/v2/_proxy/stats", "", http.StatusNotFound},
+		{admin, "/registry/v2/_admin/readonly", "", http.StatusUnauthorized},
+		{admin, "/registry/v2/_admin/readonly", "secret", http.StatusOK},
+		{admin, "/registry/v2/_replication/status", "secret", http.StatusOK},
+		{admin, "/registry/v2/", "secret", http.StatusNotFound},
+		{metricsHandler, "/metrics", "", http.StatusOK},
+		{metricsHandler, "/registry/v2/", "", http.StatusNotFound},
+	} {
+		r := httptest.NewRequest(http.MethodGet, tc.path, nil)
+		if tc.token != "" {
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		w := httptest.NewRecorder()
+		tc.handler.ServeHTTP(w, r)
+		if w.Code != tc.status {
+			t.Errorf("unexpected status of %s: %d, expected %d", tc.path, w.Code, tc.status)
+		}
+	}
+
+	config.Auth = configuration.Auth{"silly": configuration.Parameters{}}
+	if _, _, err := configureListeners(config, ok); err == nil {
+		t.Fatal("expected an error with a token on the admin listener along with auth")
+	}
+	if _, err := listenerTLSConfig(configuration.ListenerTLS{ClientCAs: []string{"ca.pem"}}); err == nil {
+		t.Fatal("expected an error with client CAs without certificate")
+	}
+}
+
+func TestH2C(t *testing.T) {
+	config := &configuration.Configuration{}
+	config.HTTP.HTTP2.H2C = true
+	tracker := &drainTracker{}
+	protos := make(chan string, 2)
+	server := &http.Server{Handler: tracker.handler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if n := tracker.inflightRequests(); n < 1 {
+			t.Errorf("unexpected requests in flight: %d", n)
+		}
+		protos <- r.Proto
+	}))}
+	handler, err := configureHTTP2(server, config)
+	if err != nil {
+		t.Fatalf("unexpected error configuring http2: %v", err)
+	}
+	server.Handler = handler
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(ln)
+	defer server.Close()
+
+	h2c := &http2.Transport{
+		AllowHTTP: true,
+		DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+			return net.Dial(network, addr)
+		},
+	}
+	for _, client := range []*http.Client{{Transport: h2c}, http.DefaultClient} {
+		resp, err := client.Get("http://" + ln.Addr().String() + "/v2/")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		resp.Body.Close()
+	}
+	if first, second := <-protos, <-protos; first != "HTTP/2.0" || second != "HTTP/1.1" {
+		t.Fatalf("unexpected protocols: %s, %s", first, second)
+	}
+	if n := tracker.inflightRequests(); n != 0 {
+		t.Fatalf("unexpected requests in flight: %d", n)
+	}
+}
+
+func TestCertReloader(t *testing.T) {
+	first, err := buildRegistryTLSConfig("reload-first", "rsa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(first.certificatePath)
+	defer os.Remove(first.privateKeyPath)
+	second, err := buildRegistryTLSConfig("reload-second", "rsa", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(second.certificatePath)
+	defer os.Remove(second.privateKeyPath)
+
+	r, err := newCertReloader(first.certificatePath, first.privateKeyPath)
+	if err != nil {
+		t.Fatalf("unexpected error loading the certificate: %v", err)
+	}
+	served := func() []byte {
+		r.checked = time.Time{}
+		cert, err := r.GetCertificate(nil)
+		if err != nil {
+			t.Fatalf("unexpected error getting the certificate: %v", err)
+		}
+		return cert.Certificate[0]
+	}
+	rotate := func(cert, key []byte, age time.Duration) {
+		for name, data := range map[string][]byte{first.certificatePath: cert, first.privateKeyPath: key} {
+			if err := os.WriteFile(name, data, 0o600); err != nil {
+				t.Fatal(err)
+			}
+			modTime := time.Now().Add(age)
+			if err := os.Chtimes(name, modTime, modTime); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if !reflect.DeepEqual(served(), first.certificate.Certificate[0]) {
+		t.Fatal("unexpected certificate served")
+	}
+
+	cert, _ := os.ReadFile(second.certificatePath)
+	key, _ := os.ReadFile(second.privateKeyPath)
+	rotate(cert, key, time.Minute)
+	if !reflect.DeepEqual(served(), second.certificate.Certificate[0]) {
+		t.Fatal("expected the rotated certificate to be served")
+	}
+
+	// a rotation in progress keeps the previous certificate
+	rotate([]byte("garbage"), key, 2*time.Minute)
+	if !reflect.DeepEqual(served(), second.certificate.Certificate[0]) {
+		t.Fatal("expected the previous certificate to be served")
+	}
+}
+
+func TestDriverCache(t *testing.T) {
+	ctx := context.Background()
+	cache := driverCache{driver: inmemory.New()}
+	if _, err := cache.Get(ctx, "example.com+rsa"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected a cache miss, got %v", err)
+	}
+	if err := cache.Put(ctx, "example.com+rsa", []byte("cert")); err != nil {
+		t.Fatalf("unexpected error putting: %v", err)
+	}
+	if data, err := cache.Get(ctx, "example.com+rsa"); err != nil || string(data) != "cert" {
+		t.Fatalf("unexpected cached data: %q, %v", data, err)
+	}
+	if err := cache.Delete(ctx, "example.com+rsa"); err != nil {
+		t.Fatalf("unexpected error deleting: %v", err)
+	}
+	if err := cache.Delete(ctx, "example.com+rsa"); err != nil {
+		t.Fatalf("unexpected error deleting a missing key: %v", err)
+	}
+	if _, err := cache.Get(ctx, "example.com+rsa"); err != autocert.ErrCacheMiss {
+		t.Fatalf("expected a cache miss after deleting, got %v", err)
+	}
+}
@@ -0,0 +1,218 @@
+package replication
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/client"
+	"github.com/distribution/distribution/v3/registry/client/auth"
+	"github.com/distribution/distribution/v3/registry/client/auth/challenge"
+	"github.com/distribution/distribution/v3/registry/client/transport"
+	"github.com/opencontainers/go-digest"
+)
+
+// remote is a downstream registry, with the credentials of its user.
+type remote struct {
+	baseURL    *url.URL
+	username   string
+	password   string
+	challenges challenge.Manager
+
+	mu     sync.Mutex
+	pinged bool
+}
+
+func newRemote(baseURL *url.URL, username, password string) *remote {
+	return &remote{
+		baseURL:    baseURL,
+		username:   username,
+		password:   password,
+		challenges: challenge.NewSimpleManager(),
+	}
+}
+
+// Basic implements auth.CredentialStore.
+func (r *remote) Basic(*url.URL) (string, string) {
+	return r.username, r.password
+}
+
+// RefreshToken implements auth.CredentialStore.
+func (r *remote) RefreshToken(*url.URL, string) string {
+	return ""
+}
+
+// SetRefreshToken implements auth.CredentialStore.
+func (r *remote) SetRefreshToken(*url.URL, string, string) {
+}
+
+// ping records the authentication challenges of the registry, until it
+// answers.
+func (r *remote) ping() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pinged {
+		return nil
+	}
+
+	resp, err := http.Get(strings.TrimSuffix(r.baseURL.String(), "/") + "/v2/")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if err := r.challenges.AddResponse(resp); err != nil {
+		return err
+	}
+	r.pinged = true
+	return nil
+}
+
+// repository returns the repository of the registry, authorized to pull and
+// push.
+func (r *remote) repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	if err := r.ping(); err != nil {
+		return nil, err
+	}
+
+	credentials := auth.CredentialStore(r)
+	if r.username == "" {
+		credentials = nil
+	}
+	tr := transport.NewTransport(http.DefaultTransport,
+		auth.NewAuthorizer(r.challenges,
+			auth.NewTokenHandlerWithOptions(auth.TokenHandlerOptions{
+				Transport:   http.DefaultTransport,
+				Credentials: credentials,
+				Scopes: []auth.Scope{
+					auth.RepositoryScope{
+						Repository: name.Name(),
+						Actions:    []string{"pull", "push"},
+					},
+				},
+				Logger: dcontext.GetLogger(ctx),
+			}),
+			auth.NewBasicHandler(credentials)))
+	return client.NewRepository(name, r.baseURL.String(), tr)
+}
+
+// pusher pushes manifests and their content from a local repository to a
+// downstream one.
+type pusher struct {
+	local           distribution.Repository
+	localManifests  distribution.ManifestService
+	remote          distribution.Repository
+	remoteManifests distribution.ManifestService
+	pushed          map[digest.Digest]struct{}
+}
+
+func newPusher(ctx context.Context, local, remote distribution.Repository) (*pusher, error) {
+	localManifests, err := local.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	remoteManifests, err := remote.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &pusher{
+		local:           local,
+		localManifests:  localManifests,
+		remote:          remote,
+		remoteManifests: remoteManifests,
+		pushed:          make(map[digest.Digest]struct{}),
+	}, nil
+}
+
+// pushManifest pushes the content referenced by the manifest, and then the
+// manifest itself, tagged with tag unless it is empty. The children of image
+// indexes which the downstream repository has are not pushed again.
+func (p *pusher) pushManifest(ctx context.Context, dgst digest.Digest, tag string) error {
+	if _, ok := p.pushed[dgst]; ok && tag == "" {
+		return nil
+	}
+	manifest, err := p.localManifests.Get(ctx, dgst)
+	if err != nil {
+		return fmt.Errorf("failed to read manifest %s: %v", dgst, err)
+	}
+
+	_, isIndex := manifest.(*manifestlist.DeserializedManifestList)
+	for _, desc := range manifest.References() {
+		if isIndex {
+			exists, err := p.remoteManifests.Exists(ctx, desc.Digest)
+			if err != nil {
+				return err
+			}
+			if !exists {
+				err = p.pushManifest(ctx, desc.Digest, "")
+			}
+		} else {
+			err = p.pushBlob(ctx, desc)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	var options []distribution.ManifestServiceOption
+	if tag != "" {
+		options = append(options, distribution.WithTag(tag))
+	}
+	if _, err := p.remoteManifests.Put(ctx, manifest, options...); err != nil {
+		return fmt.Errorf("failed to push manifest %s: %v", dgst, err)
+	}
+	p.pushed[dgst] = struct{}{}
+	return nil
+}
+
+// pushBlob pushes a blob the downstream repository does not have.
+func (p *pusher) pushBlob(ctx context.Context, desc distribution.Descriptor) error {
+	if _, ok := p.pushed[desc.Digest]; ok {
+		return nil
+	}
+
+	remoteBlobs := p.remote.Blobs(ctx)
+	_, err := remoteBlobs.Stat(ctx, desc.Digest)
+	if err == nil {
+		p.pushed[desc.Digest] = struct{}{}
+		return nil
+	} else if err != distribution.ErrBlobUnknown {
+		return err
+	}
+
+	localBlobs := p.local.Blobs(ctx)
+	stat, err := localBlobs.Stat(ctx, desc.Digest)
+	if err == distribution.ErrBlobUnknown && len(desc.URLs) > 0 {
+		// Non-distributable layers are pulled from their URLs
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read blob %s: %v", desc.Digest, err)
+	}
+	rc, err := localBlobs.Open(ctx, desc.Digest)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %v", desc.Digest, err)
+	}
+	defer rc.Close()
+
+	bw, err := remoteBlobs.Create(ctx)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(bw, rc); err != nil {
+		bw.Cancel(ctx)
+		return fmt.Errorf("failed to push blob %s: %v", desc.Digest, err)
+	}
+	if _, err := bw.Commit(ctx, distribution.Descriptor{Digest: desc.Digest, Size: stat.Size, MediaType: desc.MediaType}); err != nil {
+		bw.Cancel(ctx)
+		return fmt.Errorf("failed to push blob %s: %v", desc.Digest, err)
+	}
+	p.pushed[desc.Digest] = struct{}{}
+	return nil
+}
@@ -0,0 +1,402 @@
+// Package replication pushes the manifests pushed to the registry, or cached
+// by a pull through cache, to downstream registries, along with the blobs
+// they reference which the downstream registries do not have.
+package replication
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	defaultWorkers        = 4
+	defaultAttempts       = 5
+	defaultInitialBackoff = time.Second
+	defaultMaxBackoff     = time.Minute
+
+	// queueSize is the number of replications which can wait for a
+	// worker. Replications beyond it fail immediately.
+	queueSize = 10000
+
+	// recentJobs is the number of finished replications reported per
+	// rule.
+	recentJobs = 20
+)
+
+// Status is the state of the replications of each rule.
+type Status struct {
+	Rules []RuleStatus `json:"rules"`
+}
+
+// RuleStatus counts the replications of a rule since the registry started,
+// and lists the most recent ones.
+type RuleStatus struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Pending is the number of replications waiting for a worker or for
+	// their next attempt, or in progress
+	Pending     int        `json:"pending"`
+	Succeeded   int64      `json:"succeeded"`
+	Failed      int64      `json:"failed"`
+	LastSuccess *time.Time `json:"last_success,omitempty"`
+	LastError   string     `json:"last_error,omitempty"`
+	LastFailure *time.Time `json:"last_failure,omitempty"`
+	// Recent are the most recently finished replications, most recent
+	// first
+	Recent []JobStatus `json:"recent"`
+}
+
+// JobStatus is the outcome of the replication of a manifest.
+type JobStatus struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	Tag        string        `json:"tag,omitempty"`
+	Succeeded  bool          `json:"succeeded"`
+	Attempts   int           `json:"attempts"`
+	Error      string        `json:"error,omitempty"`
+	Finished   time.Time     `json:"finished"`
+}
+
+// job is the replication of a manifest to the downstream registry of a
+// rule.
+type job struct {
+	rule       *rule
+	repository string
+	digest     digest.Digest
+	tag        string
+	attempts   int
+}
+
+// key identifies the replications which are the same.
+func (j job) key() string {
+	return j.rule.name + " " + j.repository + "@" + j.digest.String() + ":" + j.tag
+}
+
+// Replicator is a sink of registry events which replicates the manifests of
+// the push and cache events matching its rules. The events are queued and
+// replicated in the background, so writing them does not block.
+type Replicator struct {
+	ctx      context.Context
+	cancel   context.CancelFunc
+	registry distribution.Namespace
+	rules    []*rule
+	queue    chan job
+
+	attempts       int
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	mu      sync.Mutex
+	pending map[string]struct{}
+	status  map[*rule]*RuleStatus
+}
+
+// NewReplicator returns a replicator reading the manifests and blobs from
+// registry, and starts its workers, which stop when ctx is done or the
+// replicator is closed. For the replication of manifests cached by a pull
+// through cache, registry is the local storage of the cache: blobs which are
+// not cached yet fail the attempts until they are.
+func NewReplicator(ctx context.Context, registry distribution.Namespace, config configuration.Replication) (*Replicator, error) {
+	r := &Replicator{
+		registry:       registry,
+		queue:          make(chan job, queueSize),
+		attempts:       config.Retry.Attempts,
+		initialBackoff: config.Retry.InitialBackoff,
+		maxBackoff:     config.Retry.MaxBackoff,
+		pending:        make(map[string]struct{}),
+		status:         make(map[*rule]*RuleStatus),
+	}
+	if r.attempts <= 0 {
+		r.attempts = defaultAttempts
+	}
+	if r.initialBackoff <= 0 {
+		r.initialBackoff = defaultInitialBackoff
+	}
+	if r.maxBackoff <= 0 {
+		r.maxBackoff = defaultMaxBackoff
+	}
+
+	names := make(map[string]struct{})
+	for _, config := range config.Rules {
+		rule, err := newRule(config)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := names[rule.name]; ok {
+			return nil, fmt.Errorf("duplicate replication rule %q", rule.name)
+		}
+		names[rule.name] = struct{}{}
+		r.rules = append(r.rules, rule)
+		r.status[rule] = &RuleStatus{Name: rule.name, URL: rule.url.String(), Recent: []JobStatus{}}
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	r.ctx, r.cancel = context.WithCancel(ctx)
+	for i := 0; i < workers; i++ {
+		go r.work()
+	}
+	return r, nil
+}
+
+// Write queues the replication of the manifest of a push or cache event to
+// the rules matching its repository. Other events are ignored.
+func (r *Replicator) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok {
+		return nil
+	}
+	switch e.Action {
+	case notifications.EventActionPush, notifications.EventActionCache:
+	default:
+		return nil
+	}
+	if !isManifest(e.Target.MediaType) || e.Target.Digest == "" {
+		return nil
+	}
+
+	for _, rule := range r.rules {
+		if rule.matches(e.Target.Repository) {
+			r.enqueue(job{
+				rule:       rule,
+				repository: e.Target.Repository,
+				digest:     e.Target.Digest,
+				tag:        e.Target.Tag,
+			})
+		}
+	}
+	return nil
+}
+
+// Close stops the workers. The pending replications are dropped.
+func (r *Replicator) Close() error {
+	r.cancel()
+	return nil
+}
+
+// Status returns the state of the replications of each rule, in the order of
+// the rules.
+func (r *Replicator) Status() Status {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	status := Status{Rules: make([]RuleStatus, 0, len(r.rules))}
+	for _, rule := range r.rules {
+		ruleStatus := *r.status[rule]
+		ruleStatus.Recent = append([]JobStatus{}, ruleStatus.Recent...)
+		status.Rules = append(status.Rules, ruleStatus)
+	}
+	return status
+}
+
+// enqueue queues a replication, unless the same replication is pending.
+func (r *Replicator) enqueue(j job) {
+	r.mu.Lock()
+	if _, ok := r.pending[j.key()]; ok {
+		r.mu.Unlock()
+		return
+	}
+	r.pending[j.key()] = struct{}{}
+	r.status[j.rule].Pending++
+	r.mu.Unlock()
+
+	r.requeue(j)
+}
+
+// requeue hands a pending replication to the workers.
+func (r *Replicator) requeue(j job) {
+	select {
+	case r.queue <- j:
+	default:
+		r.finish(j, fmt.Errorf("replication queue is full"))
+	}
+}
+
+func (r *Replicator) work() {
+	for {
+		select {
+		case <-r.ctx.Done():
+			return
+		case j := <-r.queue:
+			r.run(j)
+		}
+	}
+}
+
+// run makes an attempt at a replication, and schedules the next attempt if
+// it fails.
+func (r *Replicator) run(j job) {
+	j.attempts++
+	ctx := dcontext.WithLogger(r.ctx, dcontext.GetLoggerWithFields(r.ctx, map[interface{}]interface{}{
+		"replication.rule":       j.rule.name,
+		"replication.repository": j.repository,
+		"replication.digest":     j.digest,
+	}))
+
+	err := r.replicate(ctx, j)
+	if err == nil || j.attempts >= r.attempts || r.ctx.Err() != nil {
+		r.finish(j, err)
+		return
+	}
+
+	delay := r.backoff(j.attempts)
+	dcontext.GetLogger(ctx).Warnf("Retrying replication in %s after error: %v", delay, err)
+	time.AfterFunc(delay, func() {
+		if r.ctx.Err() == nil {
+			r.requeue(j)
+		}
+	})
+}
+
+// backoff returns the delay before the given retry, starting at 1.
+func (r *Replicator) backoff(retry int) time.Duration {
+	delay := r.initialBackoff
+	for i := 1; i < retry && delay < r.maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > r.maxBackoff {
+		delay = r.maxBackoff
+	}
+	return delay
+}
+
+// finish records the outcome of a replication.
+func (r *Replicator) finish(j job, err error) {
+	now := time.Now()
+	jobStatus := JobStatus{
+		Repository: j.repository,
+		Digest:     j.digest,
+		Tag:        j.tag,
+		Succeeded:  err == nil,
+		Attempts:   j.attempts,
+		Finished:   now,
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.pending, j.key())
+	status := r.status[j.rule]
+	status.Pending--
+	if err == nil {
+		status.Succeeded++
+		status.LastSuccess = &now
+	} else {
+		jobStatus.Error = err.Error()
+		status.Failed++
+		status.LastError = err.Error()
+		status.LastFailure = &now
+		dcontext.GetLogger(r.ctx).Errorf("Failed to replicate %s@%s to %s: %v", j.repository, j.digest, j.rule.name, err)
+	}
+	status.Recent = append([]JobStatus{jobStatus}, status.Recent...)
+	if len(status.Recent) > recentJobs {
+		status.Recent = status.Recent[:recentJobs]
+	}
+}
+
+// replicate pushes the manifest of a replication, and the content it
+// references, to the downstream registry.
+func (r *Replicator) replicate(ctx context.Context, j job) error {
+	named, err := reference.WithName(j.repository)
+	if err != nil {
+		return err
+	}
+	local, err := r.registry.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+	remoteName, err := reference.WithName(path.Join(j.rule.prefix, j.repository))
+	if err != nil {
+		return err
+	}
+	remote, err := j.rule.repository(ctx, remoteName)
+	if err != nil {
+		return err
+	}
+
+	p, err := newPusher(ctx, local, remote)
+	if err != nil {
+		return err
+	}
+	return p.pushManifest(ctx, j.digest, j.tag)
+}
+
+// isManifest reports whether the media type is the one of a manifest.
+func isManifest(mediaType string) bool {
+	for _, manifestMediaType := range distribution.ManifestMediaTypes() {
+		if mediaType == manifestMediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// rule is a validated replication rule.
+type rule struct {
+	name         string
+	url          *url.URL
+	repositories []string
+	prefix       string
+
+	// repository returns the downstream repository of the given name
+	repository func(ctx context.Context, name reference.Named) (distribution.Repository, error)
+}
+
+func newRule(config configuration.ReplicationRule) (*rule, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid url %q of replication rule %q", config.URL, config.Name)
+	}
+	name := config.Name
+	if name == "" {
+		name = u.Host
+	}
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid repository pattern %q of replication rule %q", pattern, name)
+		}
+	}
+	prefix := strings.Trim(config.Prefix, "/")
+	if prefix != "" {
+		if _, err := reference.WithName(prefix); err != nil {
+			return nil, fmt.Errorf("invalid prefix %q of replication rule %q", config.Prefix, name)
+		}
+	}
+
+	rule := &rule{
+		name:         name,
+		url:          u,
+		repositories: append([]string{}, config.Repositories...),
+		prefix:       prefix,
+	}
+	rule.repository = newRemote(u, config.Username, config.Password).repository
+	return rule, nil
+}
+
+// matches reports whether the rule replicates the repository.
+func (r *rule) matches(repository string) bool {
+	if len(r.repositories) == 0 {
+		return true
+	}
+	for _, pattern := range r.repositories {
+		if matched, _ := path.Match(pattern, repository); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,153 @@
+package replication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+)
+
+func newRegistry(t *testing.T) distribution.Namespace {
+	registry, err := storage.NewRegistry(context.Background(), inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return registry
+}
+
+func pushEvent(name string, image testutil.Image, tag string) notifications.Event {
+	mediaType, _, _ := image.Manifest.Payload()
+	var event notifications.Event
+	event.Action = notifications.EventActionPush
+	event.Target.MediaType = mediaType
+	event.Target.Digest = image.Descriptor.Digest
+	event.Target.Repository = name
+	event.Target.Tag = tag
+	return event
+}
+
+func waitForStatus(t *testing.T, r *Replicator) RuleStatus {
+	for start := time.Now(); ; time.Sleep(5 * time.Millisecond) {
+		status := r.Status().Rules[0]
+		if status.Pending == 0 && len(status.Recent) > 0 {
+			return status
+		}
+		if time.Since(start) > 5*time.Second {
+			t.Fatalf("replication did not finish: %+v", status)
+		}
+	}
+}
+
+func TestReplicator(t *testing.T) {
+	ctx := context.Background()
+	local := newRegistry(t)
+	downstream := newRegistry(t)
+
+	r, err := NewReplicator(ctx, local, configuration.Replication{
+		Retry: configuration.ReplicationRetry{InitialBackoff: time.Millisecond},
+		Rules: []configuration.ReplicationRule{
+			{Name: "downstream", URL: "https://registry.example.com", Repositories: []string{"team/*"}, Prefix: "replica"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The downstream registry fails the first attempt
+	var attempts int
+	r.rules[0].repository = func(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+		attempts++
+		if attempts == 1 {
+			return nil, errors.New("unavailable")
+		}
+		return downstream.Repository(ctx, name)
+	}
+
+	image := testutil.PushImage(t, testutil.Repository(t, local, "team/app"))
+	other := testutil.PushImage(t, testutil.Repository(t, local, "other/app"))
+
+	// Blob events and repositories matching no rule are not replicated
+	blobEvent := pushEvent("team/app", image, "")
+	blobEvent.Target.MediaType = "application/octet-stream"
+	for _, event := range []notifications.Event{blobEvent, pushEvent("other/app", other, "")} {
+		if err := r.Write(event); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if status := r.Status().Rules[0]; status.Pending != 0 || len(status.Recent) != 0 {
+		t.Fatalf("unexpected replication: %+v", status)
+	}
+
+	if err := r.Write(pushEvent("team/app", image, "v1")); err != nil {
+		t.Fatal(err)
+	}
+	status := waitForStatus(t, r)
+	if status.Succeeded != 1 || status.Failed != 0 || status.Recent[0].Attempts != 2 {
+		t.Fatalf("unexpected replication status: %+v", status)
+	}
+
+	named, _ := reference.WithName("replica/team/app")
+	repository, err := downstream.Repository(ctx, named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifests, err := repository.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := manifests.Exists(ctx, image.Descriptor.Digest); err != nil || !exists {
+		t.Fatalf("replicated manifest not found: %v", err)
+	}
+	for _, ref := range image.Manifest.References() {
+		if _, err := repository.Blobs(ctx).Stat(ctx, ref.Digest); err != nil {
+			t.Fatalf("replicated blob %s not found: %v", ref.Digest, err)
+		}
+	}
+}
+
+func TestReplicatorGivesUp(t *testing.T) {
+	local := newRegistry(t)
+	r, err := NewReplicator(context.Background(), local, configuration.Replication{
+		Retry: configuration.ReplicationRetry{Attempts: 3, InitialBackoff: time.Millisecond},
+		Rules: []configuration.ReplicationRule{
+			{URL: "https://registry.example.com"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	r.rules[0].repository = func(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+		return nil, errors.New("unavailable")
+	}
+
+	image := testutil.PushImage(t, testutil.Repository(t, local, "app"))
+	if err := r.Write(pushEvent("app", image, "latest")); err != nil {
+		t.Fatal(err)
+	}
+	status := waitForStatus(t, r)
+	if status.Name != "registry.example.com" || status.Succeeded != 0 || status.Failed != 1 || status.LastError != "unavailable" || status.Recent[0].Attempts != 3 {
+		t.Fatalf("unexpected replication status: %+v", status)
+	}
+}
+
+func TestReplicationRuleValidation(t *testing.T) {
+	for _, rule := range []configuration.ReplicationRule{
+		{URL: "registry.example.com"},
+		{URL: "https://registry.example.com", Repositories: []string{"["}},
+		{URL: "https://registry.example.com", Prefix: "Invalid"},
+	} {
+		if _, err := newRule(rule); err == nil {
+			t.Errorf("expected rule %+v to be invalid", rule)
+		}
+	}
+}
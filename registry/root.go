@@ -1,13 +1,30 @@
 package registry
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
+	"net"
 	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
 	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/ocilayout"
+	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/proxy/scheduler"
 	"github.com/distribution/distribution/v3/registry/storage"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/metadata"
+	"github.com/distribution/distribution/v3/registry/storage/metadata/postgres"
 	"github.com/distribution/distribution/v3/version"
+	events "github.com/docker/go-events"
 	"github.com/docker/libtrust"
 	"github.com/spf13/cobra"
 )
@@ -19,6 +36,42 @@ func init() {
 	RootCmd.AddCommand(GCCmd)
 	GCCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "do everything except remove the blobs")
 	GCCmd.Flags().BoolVarP(&removeUntagged, "delete-untagged", "m", false, "delete manifests that are not currently referenced via tag")
+	GCCmd.Flags().StringVarP(&gcRepository, "repository", "r", "", "only collect the blobs of the given repository which no other repository links")
+	GCCmd.Flags().BoolVar(&gcJSONReport, "json", false, "print a JSON report of the content eligible for deletion, with sizes, without removing anything")
+	GCCmd.Flags().BoolVar(&gcOnline, "online", false, "run while the registry serves pushes, keeping the content referenced within the grace period")
+	GCCmd.Flags().DurationVar(&gcGracePeriod, "grace-period", 24*time.Hour, "how long content referenced before an online garbage collection is kept")
+	GCCmd.Flags().IntVarP(&gcConcurrency, "concurrency", "c", 1, "number of parallel walks of the storage and repositories marked at the same time")
+	RootCmd.AddCommand(PruneCmd)
+	PruneCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "list the tags and manifests without removing them")
+	RootCmd.AddCommand(StatsCmd)
+	StatsCmd.Flags().IntVarP(&statsTop, "top", "n", 10, "number of largest layers reported")
+	StatsCmd.Flags().BoolVar(&statsJSON, "json", false, "print the statistics as JSON")
+	StatsCmd.Flags().IntVarP(&statsConcurrency, "concurrency", "c", 1, "number of parallel walks of the storage")
+	RootCmd.AddCommand(VerifyCmd)
+	VerifyCmd.Flags().Float64Var(&verifySample, "sample", 0, "fraction of the blobs re-hashed, all when 0, instead of integrity.sample")
+	VerifyCmd.Flags().BoolVar(&verifyQuarantine, "quarantine", false, "move the corrupt blobs to the quarantine, as integrity.quarantine does")
+	VerifyCmd.Flags().BoolVar(&verifyJSON, "json", false, "print the report as JSON")
+	VerifyCmd.Flags().IntVarP(&verifyConcurrency, "concurrency", "c", 1, "number of blobs re-hashed at the same time, instead of integrity.concurrency")
+	RootCmd.AddCommand(MigrateLayoutCmd)
+	MigrateLayoutCmd.Flags().IntVar(&migrateLayoutVersion, "to", 3, "layout version the links are migrated to: 3 packs them into the index of the repositories, 2 unpacks them")
+	MigrateLayoutCmd.Flags().StringVarP(&migrateLayoutRepository, "repository", "r", "", "only migrate the repositories matching the pattern")
+	MigrateLayoutCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "count the links without migrating them")
+	RootCmd.AddCommand(ExportCmd)
+	ExportCmd.Flags().StringVarP(&layoutPath, "output", "o", "", "directory, or file ending in .tar, the OCI image layout is written to")
+	RootCmd.AddCommand(ImportCmd)
+	ImportCmd.Flags().StringVarP(&layoutPath, "input", "i", "", "directory, or tar file, of the OCI image layout to import")
+	RootCmd.AddCommand(ProxyPruneCmd)
+	RootCmd.AddCommand(MetadataImportCmd)
+	ProxyPruneCmd.Flags().StringVarP(&pruneNamespace, "namespace", "n", "", "upstream host whose cached repositories are removed")
+	ProxyPruneCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "list the repositories without removing them")
+	RootCmd.AddCommand(ConfigCmd)
+	ConfigCmd.AddCommand(ConfigValidateCmd)
+	ConfigValidateCmd.Flags().BoolVar(&validateProbe, "probe", false, "query the storage driver to check it is reachable")
+	ConfigValidateCmd.Flags().BoolVar(&validateJSON, "json", false, "print the problems found as JSON")
+	RootCmd.AddCommand(SchedulerCmd)
+	SchedulerCmd.AddCommand(SchedulerRepairCmd)
+	SchedulerRepairCmd.Flags().BoolVar(&schedulerRebuild, "rebuild", false, "rebuild the state from the content in storage even if it can be read")
+	SchedulerRepairCmd.Flags().BoolVarP(&dryRun, "dry-run", "d", false, "report how the state would be repaired without writing it")
 	RootCmd.Flags().BoolVarP(&showVersion, "version", "v", false, "show the version and exit")
 }
 
@@ -39,6 +92,11 @@ var RootCmd = &cobra.Command{
 var (
 	dryRun         bool
 	removeUntagged bool
+	gcConcurrency  int
+	gcOnline       bool
+	gcJSONReport   bool
+	gcRepository   string
+	gcGracePeriod  time.Duration
 )
 
 // GCCmd is the cobra command that corresponds to the garbage-collect subcommand
@@ -73,19 +131,716 @@ var GCCmd = &cobra.Command{
 			os.Exit(1)
 		}
 
-		registry, err := storage.NewRegistry(ctx, driver, storage.Schema1SigningKey(k))
+		registry, err := storage.NewRegistry(ctx, driver, append(layoutOptions(config), storage.Schema1SigningKey(k))...)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
 			os.Exit(1)
 		}
 
-		err = storage.MarkAndSweep(ctx, driver, registry, storage.GCOpts{
+		opts := storage.GCOpts{
 			DryRun:         dryRun,
 			RemoveUntagged: removeUntagged,
-		})
+			Concurrency:    gcConcurrency,
+			Online:         gcOnline,
+			GracePeriod:    gcGracePeriod,
+			Repository:     gcRepository,
+		}
+		if gcJSONReport {
+			opts.Report = os.Stdout
+		}
+		err = storage.MarkAndSweep(ctx, driver, registry, opts)
 		if err != nil {
 			fmt.Fprintf(os.Stderr, "failed to garbage collect: %v", err)
 			os.Exit(1)
 		}
 	},
 }
+
+// PruneCmd is the cobra command that corresponds to the prune subcommand
+var PruneCmd = &cobra.Command{
+	Use:   "prune <config>",
+	Short: "`prune` deletes the tags and untagged manifests selected by the retention policies",
+	Long:  "`prune` applies the retention policies of the configuration once, deleting the tags and untagged manifests they select and notifying the configured endpoints. Run `garbage-collect` afterwards to delete their blobs.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		opts := storage.RetentionOpts{
+			Policies: handlers.RetentionPolicies(config),
+			DryRun:   dryRun,
+		}
+		var sink events.Sink
+		if !dryRun {
+			sink = newEventSink(config)
+			opts.Listener = notifications.NewBridge(nil, eventSource(config), notifications.ActorRecord{}, notifications.RequestRecord{}, sink, false)
+		}
+		deleted, err := storage.ApplyRetention(ctx, driver, registry, opts)
+		for _, ref := range deleted {
+			fmt.Println(ref)
+		}
+		if sink != nil {
+			// Deliver the queued notifications
+			sink.Close()
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to apply the retention policies: %v", err)
+			os.Exit(1)
+		}
+	},
+}
+
+// newEventSink returns a sink writing events to the enabled notification
+// endpoints of the configuration.
+func newEventSink(config *configuration.Configuration) events.Sink {
+	var sinks []events.Sink
+	for _, endpoint := range config.Notifications.Endpoints {
+		if endpoint.Disabled {
+			continue
+		}
+		sink, err := notifications.NewConfiguredEndpoint(endpoint)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure notification endpoint %s: %v", endpoint.Name, err)
+			os.Exit(1)
+		}
+		sinks = append(sinks, sink)
+	}
+	return events.NewBroadcaster(sinks...)
+}
+
+// eventSource returns the source of the events of the retention policies.
+func eventSource(config *configuration.Configuration) notifications.SourceRecord {
+	addr, err := os.Hostname()
+	if err != nil {
+		addr = config.HTTP.Addr
+	} else if _, port, err := net.SplitHostPort(config.HTTP.Addr); err == nil {
+		addr = net.JoinHostPort(addr, port)
+	}
+	return notifications.SourceRecord{Addr: addr, Provider: "retention"}
+}
+
+var (
+	statsTop         int
+	statsJSON        bool
+	statsConcurrency int
+)
+
+// StatsCmd is the cobra command that corresponds to the stats subcommand
+var StatsCmd = &cobra.Command{
+	Use:   "stats <config>",
+	Short: "`stats` reports the space used by the blobs and repositories",
+	Long:  "`stats` walks the storage and reports the size of the unique blobs, the logical size of the repositories, the deduplication ratio, the largest layers and the footprint of each repository.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		stats, err := storage.CollectStats(ctx, driver, registry, storage.StatsOpts{Top: statsTop, Concurrency: statsConcurrency})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to collect statistics: %v", err)
+			os.Exit(1)
+		}
+
+		if statsJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(stats)
+			return
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintf(w, "blobs:\t%d\n", stats.Blobs)
+		fmt.Fprintf(w, "unique bytes:\t%d\n", stats.UniqueBytes)
+		fmt.Fprintf(w, "logical bytes:\t%d\n", stats.LogicalBytes)
+		fmt.Fprintf(w, "dedup ratio:\t%.2f\n", stats.DedupRatio)
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "LAYER\tSIZE\tREPOSITORIES")
+		for _, layer := range stats.LargestLayers {
+			fmt.Fprintf(w, "%s\t%d\t%d\n", layer.Digest, layer.Size, layer.Repositories)
+		}
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "REPOSITORY\tBLOBS\tBYTES\tEXCLUSIVE BYTES")
+		for _, repo := range stats.Repositories {
+			fmt.Fprintf(w, "%s\t%d\t%d\t%d\n", repo.Name, repo.Blobs, repo.Bytes, repo.ExclusiveBytes)
+		}
+		w.Flush()
+	},
+}
+
+var (
+	verifySample      float64
+	verifyQuarantine  bool
+	verifyJSON        bool
+	verifyConcurrency int
+)
+
+// VerifyCmd is the cobra command that corresponds to the verify subcommand
+var VerifyCmd = &cobra.Command{
+	Use:   "verify <config>",
+	Short: "`verify` re-hashes the blobs and checks the links of the manifests to their blobs",
+	Long:  "`verify` re-hashes a sample, or all, of the blobs stored against their digests, checks that the content referenced by the manifests of each repository is present and not corrupt, and notifies the configured endpoints of the corrupt blobs. It exits with status 2 if corrupt content is found.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		opts := handlers.IntegrityOpts(config)
+		if cmd.Flags().Changed("sample") {
+			opts.Sample = verifySample
+		}
+		if cmd.Flags().Changed("quarantine") {
+			opts.Quarantine = verifyQuarantine
+		}
+		if cmd.Flags().Changed("concurrency") || opts.Concurrency < 1 {
+			opts.Concurrency = verifyConcurrency
+		}
+		sink := newEventSink(config)
+		source := eventSource(config)
+		source.Provider = "integrity"
+		opts.Listener = notifications.NewIntegrityBridge(source, sink)
+
+		report, err := storage.VerifyIntegrity(ctx, driver, registry, opts)
+		// Deliver the queued notifications
+		sink.Close()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to verify the integrity of the storage: %v", err)
+			os.Exit(1)
+		}
+
+		if verifyJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(report)
+		} else {
+			w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+			fmt.Fprintf(w, "blobs:\t%d\n", report.Blobs)
+			fmt.Fprintf(w, "bytes:\t%d\n", report.Bytes)
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "CORRUPT BLOB\tACTUAL DIGEST\tSIZE\tQUARANTINED")
+			for _, blob := range report.Corrupt {
+				fmt.Fprintf(w, "%s\t%s\t%d\t%t\n", blob.Digest, blob.Actual, blob.Size, blob.Quarantined)
+			}
+			fmt.Fprintln(w)
+			fmt.Fprintln(w, "BROKEN MANIFEST\tMISSING OR CORRUPT")
+			for _, manifest := range report.BrokenManifests {
+				fmt.Fprintf(w, "%s@%s\t%v\n", manifest.Repository, manifest.Digest, manifest.Blobs)
+			}
+			w.Flush()
+		}
+		if len(report.Corrupt) > 0 || len(report.BrokenManifests) > 0 {
+			os.Exit(2)
+		}
+	},
+}
+
+var (
+	migrateLayoutVersion    int
+	migrateLayoutRepository string
+)
+
+// MigrateLayoutCmd is the cobra command that corresponds to the
+// migrate-layout subcommand
+var MigrateLayoutCmd = &cobra.Command{
+	Use:   "migrate-layout <config>",
+	Short: "`migrate-layout` packs the link files of the repositories into their index, or unpacks them",
+	Long:  "`migrate-layout` packs the link files of the layers and manifests of the repositories into their sharded index, which requires storage.layout.version 3, and can run while the registries serve. With --to 2 it unpacks the index back into link files, before version 3 is disabled, and should run while the registry is read-only.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		report, err := storage.MigrateLayout(ctx, driver, registry, storage.LayoutOpts{
+			Version:    migrateLayoutVersion,
+			Repository: migrateLayoutRepository,
+			DryRun:     dryRun,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to migrate the layout: %v", err)
+			os.Exit(1)
+		}
+		verb := "packed"
+		if migrateLayoutVersion == 2 {
+			verb = "unpacked"
+		}
+		if dryRun {
+			verb = "to be " + verb
+		}
+		fmt.Printf("%d links of %d repositories %s\n", report.Links, report.Repositories, verb)
+	},
+}
+
+// layoutOptions returns the registry options of the layout of the links of
+// the configuration.
+func layoutOptions(config *configuration.Configuration) []storage.RegistryOption {
+	options, err := handlers.LayoutOptions(config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		os.Exit(1)
+	}
+	return options
+}
+
+var layoutPath string
+
+// ExportCmd is the cobra command that corresponds to the export subcommand
+var ExportCmd = &cobra.Command{
+	Use:   "export <repository>[:tag] <config> --output <dir|file.tar>",
+	Short: "`export` writes the images of a repository to an OCI image layout",
+	Long:  "`export` writes the images of the tag, or of all the tags, of a repository to an OCI image layout in a directory or a tar archive, which `import` pushes to another registry. The registry does not need to run.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 || layoutPath == "" {
+			fmt.Fprintln(os.Stderr, "a repository and the --output flag are required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		ref, err := reference.Parse(args[0])
+		named, ok := ref.(reference.Named)
+		if err != nil || !ok {
+			fmt.Fprintf(os.Stderr, "invalid repository %s\n", args[0])
+			os.Exit(1)
+		}
+		var tags []string
+		if tagged, ok := named.(reference.Tagged); ok {
+			tags = []string{tagged.Tag()}
+		}
+
+		ctx, repository := layoutRepository(cmd, args[1:], reference.TrimNamed(named))
+
+		var w ocilayout.Writer
+		var f *os.File
+		if strings.HasSuffix(layoutPath, ".tar") {
+			f, err = os.Create(layoutPath)
+			if err == nil {
+				w = ocilayout.NewTarWriter(f)
+			}
+		} else {
+			w, err = ocilayout.NewDirWriter(layoutPath)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to create the image layout: %v", err)
+			os.Exit(1)
+		}
+
+		err = ocilayout.Export(ctx, repository, tags, w)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+		if f != nil {
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to export %s: %v", args[0], err)
+			os.Exit(1)
+		}
+	},
+}
+
+// ImportCmd is the cobra command that corresponds to the import subcommand
+var ImportCmd = &cobra.Command{
+	Use:   "import <repository> <config> --input <dir|file.tar>",
+	Short: "`import` pushes the images of an OCI image layout to a repository",
+	Long:  "`import` pushes the images of an OCI image layout, in a directory or a tar archive, to a repository, tagging them with their org.opencontainers.image.ref.name annotation. Blobs already in the repository are not pushed again.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if len(args) == 0 || layoutPath == "" {
+			fmt.Fprintln(os.Stderr, "a repository and the --input flag are required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+		named, err := reference.WithName(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid repository %s: %v\n", args[0], err)
+			os.Exit(1)
+		}
+
+		ctx, repository := layoutRepository(cmd, args[1:], named)
+
+		dir := layoutPath
+		if fi, err := os.Stat(layoutPath); err == nil && !fi.IsDir() {
+			dir, err = os.MkdirTemp("", "registry-import-")
+			if err == nil {
+				defer os.RemoveAll(dir)
+				err = untarFile(layoutPath, dir)
+			}
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "failed to extract %s: %v", layoutPath, err)
+				os.Exit(1)
+			}
+		}
+
+		imported, err := ocilayout.Import(ctx, repository, os.DirFS(dir))
+		for _, ref := range imported {
+			fmt.Println(ref)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import %s: %v", layoutPath, err)
+			os.Exit(1)
+		}
+	},
+}
+
+// layoutRepository returns the repository of the storage of the
+// configuration that an image layout is exported from or imported to.
+func layoutRepository(cmd *cobra.Command, args []string, named reference.Named) (context.Context, distribution.Repository) {
+	config, err := resolveConfiguration(args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+		cmd.Usage()
+		os.Exit(1)
+	}
+
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+		os.Exit(1)
+	}
+
+	ctx := dcontext.Background()
+	ctx, err = configureLogging(ctx, config)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+		os.Exit(1)
+	}
+
+	registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+		os.Exit(1)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to construct repository: %v", err)
+		os.Exit(1)
+	}
+	return ctx, repository
+}
+
+func untarFile(name string, dir string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return ocilayout.Untar(f, dir)
+}
+
+var pruneNamespace string
+
+// ProxyPruneCmd is the cobra command that corresponds to the proxy-prune subcommand
+var ProxyPruneCmd = &cobra.Command{
+	Use:   "proxy-prune --namespace <host> <config>",
+	Short: "`proxy-prune` deletes repositories cached from an upstream registry",
+	Long:  "`proxy-prune` deletes the repositories a namespaced pull-through cache stored for an upstream registry. Run `garbage-collect` afterwards to delete their blobs.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if pruneNamespace == "" {
+			fmt.Fprintln(os.Stderr, "the --namespace flag is required")
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		names, err := proxy.PruneNamespace(ctx, registry, pruneNamespace, dryRun)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to prune namespace: %v", err)
+			os.Exit(1)
+		}
+
+		for _, name := range names {
+			fmt.Println(name)
+		}
+	},
+}
+
+// MetadataImportCmd is the cobra command that corresponds to the
+// metadata-import subcommand
+var MetadataImportCmd = &cobra.Command{
+	Use:   "metadata-import <config>",
+	Short: "`metadata-import` records the content of the storage in the metadata database",
+	Long:  "`metadata-import` records the manifests, tags and repositories found in the storage in the configured metadata database. Run it before enabling the database, and again to catch up with the pushes made while it ran.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+
+		if config.Metadata.Database != "postgres" {
+			fmt.Fprintf(os.Stderr, "unsupported metadata database %q\n", config.Metadata.Database)
+			os.Exit(1)
+		}
+		if err := postgres.Validate(config.Metadata); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		store, err := postgres.Open(ctx, config.Metadata)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to open the metadata database: %v", err)
+			os.Exit(1)
+		}
+		defer store.Close()
+
+		result, err := metadata.Import(ctx, registry, store)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to import metadata: %v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("imported %d repositories, %d manifests, %d tags and %d layer links\n", result.Repositories, result.Manifests, result.Tags, result.Layers)
+	},
+}
+
+var (
+	validateProbe bool
+	validateJSON  bool
+)
+
+// ConfigCmd is the cobra command grouping the subcommands handling the
+// configuration
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "`config` handles the configuration of the registry",
+	Long:  "`config` handles the configuration of the registry",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+// ConfigValidateCmd is the cobra command that corresponds to the config
+// validate subcommand
+var ConfigValidateCmd = &cobra.Command{
+	Use:   "validate <config>",
+	Short: "`validate` checks a configuration before it is deployed",
+	Long:  "`validate` parses the configuration as the registry does, with the overrides of the environment, and reports the unknown keys and invalid options, such as the parameters of the storage driver and the upstreams of a pull-through cache. It exits with status 1 if there are errors.",
+	Run: func(cmd *cobra.Command, args []string) {
+		path, err := resolveConfigurationPath(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		in, err := os.ReadFile(path)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+
+		problems := validateConfiguration(dcontext.Background(), in, validateProbe)
+		errors := 0
+		for _, p := range problems {
+			if p.Severity == severityError {
+				errors++
+			}
+		}
+
+		if validateJSON {
+			if problems == nil {
+				problems = []problem{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "  ")
+			enc.Encode(problems)
+		} else {
+			for _, p := range problems {
+				fmt.Println(p)
+			}
+			if errors == 0 {
+				fmt.Printf("%s is valid\n", path)
+			}
+		}
+		if errors > 0 {
+			os.Exit(1)
+		}
+	},
+}
+
+var schedulerRebuild bool
+
+// SchedulerCmd is the cobra command grouping the subcommands handling the
+// scheduler expiring the content of a pull-through cache
+var SchedulerCmd = &cobra.Command{
+	Use:   "scheduler",
+	Short: "`scheduler` handles the expiry scheduler of a pull-through cache",
+	Long:  "`scheduler` handles the expiry scheduler of a pull-through cache",
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Usage()
+	},
+}
+
+// SchedulerRepairCmd is the cobra command that corresponds to the scheduler
+// repair subcommand
+var SchedulerRepairCmd = &cobra.Command{
+	Use:   "repair <config>",
+	Short: "`repair` recovers the scheduler state of a pull-through cache",
+	Long:  "`repair` reads the scheduler state of a pull-through cache as the registry starting does, falling back to its backup when it is corrupt, and to a rebuild walking the storage when the backup is corrupt too, and writes it back without its invalid entries. Stop the registry before running it.",
+	Run: func(cmd *cobra.Command, args []string) {
+		config, err := resolveConfiguration(args)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "configuration error: %v\n", err)
+			cmd.Usage()
+			os.Exit(1)
+		}
+		if config.Proxy.RemoteURL == "" && !config.Proxy.EnableNamespaces {
+			fmt.Fprintln(os.Stderr, "the registry is not configured as a pull-through cache")
+			os.Exit(1)
+		}
+
+		driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct %s driver: %v", config.Storage.Type(), err)
+			os.Exit(1)
+		}
+
+		ctx := dcontext.Background()
+		ctx, err = configureLogging(ctx, config)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "unable to configure logging with config: %s", err)
+			os.Exit(1)
+		}
+
+		registry, err := storage.NewRegistry(ctx, driver, layoutOptions(config)...)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to construct registry: %v", err)
+			os.Exit(1)
+		}
+
+		report, err := proxy.RepairSchedulerState(ctx, driver, registry, config.Proxy, scheduler.RepairOpts{Rebuild: schedulerRebuild, DryRun: dryRun})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to repair the scheduler state: %v", err)
+			os.Exit(1)
+		}
+		fmt.Printf("loaded %d entries from the %s, dropped %d invalid entries\n", report.Entries, report.Source, report.Dropped)
+	},
+}
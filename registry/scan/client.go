@@ -0,0 +1,159 @@
+package scan
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+)
+
+// The media types of the pluggable scanner API of Harbor
+const (
+	mediaTypeScanRequest  = "application/vnd.scanner.adapter.scan.request+json; version=1.0"
+	mediaTypeScanResponse = "application/vnd.scanner.adapter.scan.response+json; version=1.0"
+	mediaTypeReport       = "application/vnd.security.vulnerability.report; version=1.1"
+)
+
+// defaultPollInterval is how often the report of a scan is requested when
+// the scanner does not tell when to.
+const defaultPollInterval = 5 * time.Second
+
+// scanRequest asks the scanner to scan an artifact of the registry.
+type scanRequest struct {
+	Registry struct {
+		URL           string `json:"url"`
+		Authorization string `json:"authorization,omitempty"`
+	} `json:"registry"`
+	Artifact struct {
+		Repository string        `json:"repository"`
+		Digest     digest.Digest `json:"digest"`
+		MimeType   string        `json:"mime_type"`
+	} `json:"artifact"`
+}
+
+// report is the vulnerability report of a scan.
+type report struct {
+	GeneratedAt time.Time `json:"generated_at"`
+	Scanner     struct {
+		Name    string `json:"name"`
+		Vendor  string `json:"vendor"`
+		Version string `json:"version"`
+	} `json:"scanner"`
+	Vulnerabilities []struct {
+		ID       string `json:"id"`
+		Severity string `json:"severity"`
+	} `json:"vulnerabilities"`
+}
+
+// client calls a scanner implementing the pluggable scanner API of Harbor.
+type client struct {
+	url                   *url.URL
+	authorization         string
+	registryURL           string
+	registryAuthorization string
+	pollInterval          time.Duration
+	httpClient            *http.Client
+}
+
+// scan scans the manifest and waits for its report, until ctx is done.
+func (c *client) scan(ctx context.Context, repository string, dgst digest.Digest, mediaType string) (*report, error) {
+	var req scanRequest
+	req.Registry.URL = c.registryURL
+	req.Registry.Authorization = c.registryAuthorization
+	req.Artifact.Repository = repository
+	req.Artifact.Digest = dgst
+	req.Artifact.MimeType = mediaType
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(ctx, http.MethodPost, "/api/v1/scan", mediaTypeScanRequest, mediaTypeScanResponse, body)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		return nil, unexpectedStatus(resp)
+	}
+	var scanResponse struct {
+		ID string `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&scanResponse); err != nil || scanResponse.ID == "" {
+		return nil, fmt.Errorf("invalid scan response from the scanner")
+	}
+
+	for {
+		r, retryAfter, err := c.report(ctx, scanResponse.ID)
+		if err != nil || r != nil {
+			return r, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(retryAfter):
+		}
+	}
+}
+
+// report returns the report of the scan, or nil and when to ask again if the
+// scan is in progress.
+func (c *client) report(ctx context.Context, id string) (*report, time.Duration, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/v1/scan/"+url.PathEscape(id)+"/report", "", mediaTypeReport, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var r report
+		if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+			return nil, 0, fmt.Errorf("invalid report from the scanner: %v", err)
+		}
+		return &r, 0, nil
+	case http.StatusFound:
+		retryAfter := c.pollInterval
+		if seconds, err := strconv.Atoi(resp.Header.Get("Refresh-After")); err == nil && seconds > 0 {
+			retryAfter = time.Duration(seconds) * time.Second
+		}
+		return nil, retryAfter, nil
+	default:
+		return nil, 0, unexpectedStatus(resp)
+	}
+}
+
+func (c *client) do(ctx context.Context, method, path, contentType, accept string, body []byte) (*http.Response, error) {
+	u := *c.url
+	u.Path = strings.TrimSuffix(u.Path, "/") + path
+	req, err := http.NewRequestWithContext(ctx, method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	req.Header.Set("Accept", accept)
+	if c.authorization != "" {
+		req.Header.Set("Authorization", c.authorization)
+	}
+	// Redirects are how the scanner reports a scan in progress
+	client := *c.httpClient
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+	return client.Do(req)
+}
+
+func unexpectedStatus(resp *http.Response) error {
+	message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return fmt.Errorf("unexpected status %s from the scanner: %s", resp.Status, strings.TrimSpace(string(message)))
+}
@@ -0,0 +1,290 @@
+// Package scan scans the images pushed to the registry, or cached by a pull
+// through cache, with a vulnerability scanner, stores the summary of each
+// scan as a referrer of the image, and blocks the pulls of the images whose
+// vulnerabilities exceed the severity allowed by a policy.
+package scan
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/manifest/schema2"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	defaultWorkers = 2
+	defaultTimeout = 10 * time.Minute
+
+	// queueSize is the number of scans which can wait for a worker. Scans
+	// beyond it are dropped.
+	queueSize = 10000
+)
+
+// job is the scan of a manifest.
+type job struct {
+	repository string
+	digest     digest.Digest
+	mediaType  string
+}
+
+// policy blocks the pulls of the images of the matching repositories.
+type policy struct {
+	repositories []string
+	threshold    int
+}
+
+// Scanner is a sink of registry events which scans the images of the push
+// and cache events of the repositories scanned. The events are queued and
+// scanned in the background, so writing them does not block.
+type Scanner struct {
+	ctx          context.Context
+	cancel       context.CancelFunc
+	registry     distribution.Namespace
+	client       *client
+	timeout      time.Duration
+	repositories []string
+	policies     []policy
+	queue        chan job
+
+	mu      sync.Mutex
+	pending map[job]struct{}
+}
+
+// NewScanner returns a scanner storing the summaries of the scans in
+// registry, and starts its workers, which stop when ctx is done or the
+// scanner is closed. For a pull through cache, registry is the local
+// storage of the cache.
+func NewScanner(ctx context.Context, registry distribution.Namespace, config configuration.Scan) (*Scanner, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || u.Host == "" || (u.Scheme != "http" && u.Scheme != "https") {
+		return nil, fmt.Errorf("invalid scanner url %q", config.URL)
+	}
+	if config.RegistryURL == "" {
+		return nil, fmt.Errorf("the url of the registry for the scanner is required")
+	}
+	if _, err := url.Parse(config.RegistryURL); err != nil {
+		return nil, fmt.Errorf("invalid registry url %q: %v", config.RegistryURL, err)
+	}
+	for _, pattern := range config.Repositories {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return nil, fmt.Errorf("invalid repository pattern %q", pattern)
+		}
+	}
+
+	s := &Scanner{
+		registry: registry,
+		client: &client{
+			url:                   u,
+			authorization:         config.Authorization,
+			registryURL:           config.RegistryURL,
+			registryAuthorization: config.RegistryAuthorization,
+			pollInterval:          defaultPollInterval,
+			httpClient:            http.DefaultClient,
+		},
+		timeout:      config.Timeout,
+		repositories: append([]string{}, config.Repositories...),
+		queue:        make(chan job, queueSize),
+		pending:      make(map[job]struct{}),
+	}
+	if s.timeout <= 0 {
+		s.timeout = defaultTimeout
+	}
+	for _, config := range config.Policies {
+		threshold, err := parseThreshold(config.Severity)
+		if err != nil {
+			return nil, err
+		}
+		for _, pattern := range config.Repositories {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return nil, fmt.Errorf("invalid repository pattern %q of scan policy", pattern)
+			}
+		}
+		s.policies = append(s.policies, policy{repositories: config.Repositories, threshold: threshold})
+	}
+
+	workers := config.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	s.ctx, s.cancel = context.WithCancel(ctx)
+	for i := 0; i < workers; i++ {
+		go s.work()
+	}
+	return s, nil
+}
+
+// Write queues the scan of the image manifest of a push or cache event of a
+// scanned repository. Other events are ignored.
+func (s *Scanner) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok {
+		return nil
+	}
+	switch e.Action {
+	case notifications.EventActionPush, notifications.EventActionCache:
+	default:
+		return nil
+	}
+	// the scanners scan images, not the indexes of their platforms
+	if e.Target.MediaType != schema2.MediaTypeManifest && e.Target.MediaType != v1.MediaTypeImageManifest {
+		return nil
+	}
+	if e.Target.Digest == "" || !matchesAny(s.repositories, e.Target.Repository, true) {
+		return nil
+	}
+
+	j := job{repository: e.Target.Repository, digest: e.Target.Digest, mediaType: e.Target.MediaType}
+	s.mu.Lock()
+	if _, ok := s.pending[j]; ok {
+		s.mu.Unlock()
+		return nil
+	}
+	s.pending[j] = struct{}{}
+	s.mu.Unlock()
+
+	select {
+	case s.queue <- j:
+	default:
+		s.finish(j)
+		dcontext.GetLogger(s.ctx).Errorf("Dropped the scan of %s@%s: the scan queue is full", j.repository, j.digest)
+	}
+	return nil
+}
+
+// Close stops the workers. The pending scans are dropped.
+func (s *Scanner) Close() error {
+	s.cancel()
+	return nil
+}
+
+func (s *Scanner) work() {
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case j := <-s.queue:
+			ctx := dcontext.WithLogger(s.ctx, dcontext.GetLoggerWithFields(s.ctx, map[interface{}]interface{}{
+				"scan.repository": j.repository,
+				"scan.digest":     j.digest,
+			}))
+			if err := s.scan(ctx, j); err != nil {
+				dcontext.GetLogger(ctx).Errorf("Failed to scan %s@%s: %v", j.repository, j.digest, err)
+			}
+			s.finish(j)
+		}
+	}
+}
+
+func (s *Scanner) finish(j job) {
+	s.mu.Lock()
+	delete(s.pending, j)
+	s.mu.Unlock()
+}
+
+// scan scans the manifest of the job and stores the summary of the scan.
+// Artifacts, such as signatures and scan summaries, are not scanned.
+func (s *Scanner) scan(ctx context.Context, j job) error {
+	named, err := reference.WithName(j.repository)
+	if err != nil {
+		return err
+	}
+	repo, err := s.registry.Repository(ctx, named)
+	if err != nil {
+		return err
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return err
+	}
+	m, err := manifests.Get(ctx, j.digest)
+	if err != nil {
+		return err
+	}
+	if m, ok := m.(*ocischema.DeserializedManifest); ok && (m.ArtifactType != "" || m.Config.MediaType != v1.MediaTypeImageConfig) {
+		return nil
+	}
+	_, payload, err := m.Payload()
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, s.timeout)
+	defer cancel()
+	r, err := s.client.scan(ctx, j.repository, j.digest, j.mediaType)
+	if err != nil {
+		return err
+	}
+	summary := summarize(r)
+	subject := distribution.Descriptor{MediaType: j.mediaType, Digest: j.digest, Size: int64(len(payload))}
+	dgst, err := store(ctx, repo, subject, summary)
+	if err != nil {
+		return fmt.Errorf("failed to store the scan summary: %v", err)
+	}
+	dcontext.GetLogger(ctx).Infof("Scanned %s@%s: highest severity %s, summary %s", j.repository, j.digest, summary.Severity, dgst)
+	return nil
+}
+
+// Check returns an errcode.Error with the ErrorCodeDenied code if a policy
+// blocks the pulls of the manifest, because its last scan found
+// vulnerabilities of the severity of the policy or higher. The manifests
+// which were not scanned, and the ones whose scans cannot be read, are not
+// blocked.
+func (s *Scanner) Check(ctx context.Context, repository string, dgst digest.Digest) error {
+	var p *policy
+	for i := range s.policies {
+		if matchesAny(s.policies[i].repositories, repository, false) {
+			p = &s.policies[i]
+			break
+		}
+	}
+	if p == nil {
+		return nil
+	}
+
+	named, err := reference.WithName(repository)
+	if err != nil {
+		return nil
+	}
+	repo, err := s.registry.Repository(ctx, named)
+	if err != nil {
+		return nil
+	}
+	severity, ok, err := latestSeverity(ctx, repo, dgst)
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("Error reading the scan summaries of %s@%s: %v", repository, dgst, err)
+		return nil
+	}
+	if !ok || severityRank(severity) < p.threshold {
+		return nil
+	}
+	return errcode.ErrorCodeDenied.WithMessage(fmt.Sprintf("the manifest has vulnerabilities of %s severity, pulls are blocked from %s", severity, severities[p.threshold]))
+}
+
+// matchesAny reports whether the repository matches one of the patterns,
+// or whether empty is set if there are none.
+func matchesAny(patterns []string, repository string, empty bool) bool {
+	if len(patterns) == 0 {
+		return empty
+	}
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, repository); matched {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,169 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/api/errcode"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// newTestScanner serves the pluggable scanner API, reporting a high and a
+// low vulnerability once the report was requested once.
+func newTestScanner(t *testing.T) (*httptest.Server, *int32) {
+	var scans int32
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer scanner" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch {
+		case r.Method == http.MethodPost && r.URL.Path == "/api/v1/scan":
+			var req scanRequest
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.Artifact.Repository != "library/app" || req.Registry.URL != "https://registry.example.com" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			atomic.AddInt32(&scans, 1)
+			w.WriteHeader(http.StatusAccepted)
+			w.Write([]byte(`{"id":"1"}`))
+		case r.Method == http.MethodGet && r.URL.Path == "/api/v1/scan/1/report":
+			if atomic.AddInt32(&polls, 1) == 1 {
+				w.Header().Set("Location", r.URL.String())
+				w.WriteHeader(http.StatusFound)
+				return
+			}
+			w.Write([]byte(`{
+				"generated_at": "2026-01-02T03:04:05Z",
+				"scanner": {"name": "Trivy", "vendor": "Aqua Security", "version": "0.50.0"},
+				"vulnerabilities": [
+					{"id": "CVE-2024-0001", "severity": "High"},
+					{"id": "CVE-2024-0002", "severity": "Low"}
+				]
+			}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, &scans
+}
+
+func TestScanner(t *testing.T) {
+	ctx := context.Background()
+	server, scans := newTestScanner(t)
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	image := testutil.PushImage(t, testutil.Repository(t, registry, "library/app")).Descriptor
+
+	s, err := NewScanner(ctx, registry, configuration.Scan{
+		URL:           server.URL,
+		Authorization: "Bearer scanner",
+		RegistryURL:   "https://registry.example.com",
+		Repositories:  []string{"library/*"},
+		Policies: []configuration.ScanPolicy{
+			{Repositories: []string{"library/app"}, Severity: "high"},
+			{Repositories: []string{"library/*"}, Severity: "critical"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	s.client.pollInterval = 10 * time.Millisecond
+
+	if err := s.Check(ctx, "library/app", image.Digest); err != nil {
+		t.Fatalf("unexpected block of an image not scanned: %v", err)
+	}
+
+	event := notifications.Event{Action: notifications.EventActionPush}
+	event.Target.Repository = "library/app"
+	event.Target.Digest = image.Digest
+	event.Target.MediaType = v1.MediaTypeImageIndex
+	if err := s.Write(event); err != nil {
+		t.Fatal(err)
+	}
+	event.Target.MediaType = image.MediaType
+	if err := s.Write(event); err != nil {
+		t.Fatal(err)
+	}
+
+	var severity string
+	for i := 0; i < 100; i++ {
+		named, _ := reference.WithName("library/app")
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ok bool
+		if severity, ok, err = latestSeverity(ctx, repo, image.Digest); err != nil {
+			t.Fatal(err)
+		} else if ok {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	if severity != "high" {
+		t.Fatalf("expected a summary of high severity, got %q", severity)
+	}
+	if n := atomic.LoadInt32(scans); n != 1 {
+		t.Fatalf("expected a single scan, got %d", n)
+	}
+
+	err = s.Check(ctx, "library/app", image.Digest)
+	if e, ok := err.(errcode.Error); !ok || e.Code != errcode.ErrorCodeDenied {
+		t.Fatalf("expected the pull to be blocked, got %v", err)
+	}
+	// the first policy matching the repository applies
+	s.policies = s.policies[1:]
+	if err := s.Check(ctx, "library/app", image.Digest); err != nil {
+		t.Fatalf("unexpected block below the severity of the policy: %v", err)
+	}
+}
+
+func TestSummarize(t *testing.T) {
+	r := &report{}
+	if s := summarize(r); s.Severity != "none" || len(s.Vulnerabilities) != 0 {
+		t.Fatalf("unexpected summary %+v", s)
+	}
+	r.Vulnerabilities = append(r.Vulnerabilities, struct {
+		ID       string `json:"id"`
+		Severity string `json:"severity"`
+	}{ID: "CVE-2024-0003", Severity: "Severe"})
+	if s := summarize(r); s.Severity != "unknown" || s.Vulnerabilities["unknown"] != 1 {
+		t.Fatalf("unexpected summary %+v", s)
+	}
+}
+
+func TestNewScanner(t *testing.T) {
+	ctx := context.Background()
+	for _, config := range []configuration.Scan{
+		{URL: "scanner:8080", RegistryURL: "https://registry.example.com"},
+		{URL: "http://scanner:8080"},
+		{URL: "http://scanner:8080", RegistryURL: "https://registry.example.com", Repositories: []string{"["}},
+		{URL: "http://scanner:8080", RegistryURL: "https://registry.example.com", Policies: []configuration.ScanPolicy{{Severity: "none"}}},
+		{URL: "http://scanner:8080", RegistryURL: "https://registry.example.com", Policies: []configuration.ScanPolicy{{Severity: "high", Repositories: []string{"["}}}},
+	} {
+		if s, err := NewScanner(ctx, nil, config); err == nil {
+			s.Close()
+			t.Errorf("expected an error for %+v", config)
+		}
+	}
+	if _, err := parseThreshold("Critical"); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,164 @@
+package scan
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+const (
+	// ArtifactType is the artifact type of the referrers holding the
+	// summary of the scans of images.
+	ArtifactType = "application/vnd.distribution.scan.summary.v1+json"
+
+	// AnnotationSeverity is the annotation of the scan summaries holding the
+	// highest severity of the vulnerabilities found, which is none if the
+	// scan found none.
+	AnnotationSeverity = "io.distribution.scan.severity"
+
+	// AnnotationScanner is the annotation of the scan summaries naming the
+	// scanner.
+	AnnotationScanner = "io.distribution.scan.scanner"
+
+	// mediaTypeEmptyJSON is the media type of the empty config of the scan
+	// summaries.
+	mediaTypeEmptyJSON = "application/vnd.oci.empty.v1+json"
+)
+
+// The severities of vulnerabilities, from the lowest
+var severities = []string{"none", "unknown", "negligible", "low", "medium", "high", "critical"}
+
+// severityRank returns the rank of the severity in severities, -1 if it is
+// not one.
+func severityRank(severity string) int {
+	severity = strings.ToLower(severity)
+	for i, s := range severities {
+		if s == severity {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseThreshold parses the severity of a policy.
+func parseThreshold(severity string) (int, error) {
+	rank := severityRank(severity)
+	if rank < severityRank("low") {
+		return 0, fmt.Errorf("invalid severity %q, must be low, medium, high or critical", severity)
+	}
+	return rank, nil
+}
+
+// Summary is the content of the referrers summarizing the scans of images.
+type Summary struct {
+	// Scanner is the name, vendor and version of the scanner
+	Scanner string `json:"scanner"`
+
+	// GeneratedAt is when the report of the scan was generated
+	GeneratedAt time.Time `json:"generatedAt"`
+
+	// Severity is the highest severity of the vulnerabilities found, none
+	// if there are none
+	Severity string `json:"severity"`
+
+	// Vulnerabilities counts the vulnerabilities found per severity
+	Vulnerabilities map[string]int `json:"vulnerabilities"`
+}
+
+// summarize summarizes the report of a scan.
+func summarize(r *report) Summary {
+	s := Summary{
+		Scanner:         strings.TrimSpace(fmt.Sprintf("%s %s %s", r.Scanner.Vendor, r.Scanner.Name, r.Scanner.Version)),
+		GeneratedAt:     r.GeneratedAt,
+		Severity:        "none",
+		Vulnerabilities: map[string]int{},
+	}
+	if s.GeneratedAt.IsZero() {
+		s.GeneratedAt = time.Now().UTC()
+	}
+	for _, vulnerability := range r.Vulnerabilities {
+		severity := strings.ToLower(vulnerability.Severity)
+		if severityRank(severity) < 0 {
+			severity = "unknown"
+		}
+		s.Vulnerabilities[severity]++
+		if severityRank(severity) > severityRank(s.Severity) {
+			s.Severity = severity
+		}
+	}
+	return s
+}
+
+// store stores the summary as a referrer of the scanned manifest.
+func store(ctx context.Context, repo distribution.Repository, subject distribution.Descriptor, s Summary) (digest.Digest, error) {
+	content, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	blobs := repo.Blobs(ctx)
+	desc, err := blobs.Put(ctx, ArtifactType, content)
+	if err != nil {
+		return "", err
+	}
+	desc.MediaType = ArtifactType
+
+	builder := ocischema.NewManifestBuilder(blobs, []byte("{}"), map[string]string{
+		AnnotationSeverity:   s.Severity,
+		AnnotationScanner:    s.Scanner,
+		v1.AnnotationCreated: s.GeneratedAt.UTC().Format(time.RFC3339),
+	}).(*ocischema.Builder)
+	if err := builder.SetConfigMediaType(mediaTypeEmptyJSON); err != nil {
+		return "", err
+	}
+	builder.SetArtifactType(ArtifactType)
+	builder.SetSubject(distribution.Descriptor{MediaType: subject.MediaType, Digest: subject.Digest, Size: subject.Size})
+	if err := builder.AppendReference(desc); err != nil {
+		return "", err
+	}
+	m, err := builder.Build(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return "", err
+	}
+	return manifests.Put(ctx, m)
+}
+
+// latestSeverity returns the highest severity of the vulnerabilities found by
+// the last scan of the manifest, false if it was never scanned.
+func latestSeverity(ctx context.Context, repo distribution.Repository, dgst digest.Digest) (string, bool, error) {
+	referrers, ok := repo.(distribution.ReferrerService)
+	if !ok {
+		return "", false, fmt.Errorf("the repository does not serve referrers")
+	}
+	summaries, err := referrers.Referrers(ctx, dgst, ArtifactType)
+	if err != nil {
+		return "", false, err
+	}
+
+	var (
+		latest   time.Time
+		severity string
+		found    bool
+	)
+	for _, desc := range summaries {
+		created, err := time.Parse(time.RFC3339, desc.Annotations[v1.AnnotationCreated])
+		if err != nil {
+			continue
+		}
+		if !found || created.After(latest) {
+			latest, severity, found = created, desc.Annotations[AnnotationSeverity], true
+		}
+	}
+	return severity, found, nil
+}
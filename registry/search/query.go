@@ -0,0 +1,138 @@
+package search
+
+import (
+	"sort"
+	"strings"
+)
+
+// term is a part of a query. Its value is matched as a case insensitive
+// substring of the field.
+type term struct {
+	// field is repository, tag, namespace, or an annotation key. Terms
+	// without a field match any of them.
+	field      string
+	annotation bool
+	value      string
+}
+
+// Query is a parsed search query, matching the repositories which match all
+// of its terms.
+type Query []term
+
+// ParseQuery parses the terms of a query separated by spaces. A term is
+// either a text matched against the name, tags, annotations and namespace of
+// repositories, a repository:, tag: or namespace: prefixed text matched
+// against that field only, or a key=value pair matched against the
+// annotation of that key. Texts are matched as case insensitive substrings.
+func ParseQuery(q string) Query {
+	var query Query
+	for _, s := range strings.Fields(q) {
+		if key, value, ok := strings.Cut(s, "="); ok && key != "" {
+			query = append(query, term{field: key, annotation: true, value: strings.ToLower(value)})
+			continue
+		}
+		if field, value, ok := strings.Cut(s, ":"); ok {
+			switch field {
+			case "repository", "tag", "namespace":
+				query = append(query, term{field: field, value: strings.ToLower(value)})
+				continue
+			}
+		}
+		query = append(query, term{value: strings.ToLower(s)})
+	}
+	return query
+}
+
+func contains(s, value string) bool {
+	return strings.Contains(strings.ToLower(s), value)
+}
+
+func (t term) matches(name string, repo *repository) bool {
+	if t.annotation {
+		for _, annotations := range repo.annotations {
+			if value, ok := annotations[t.field]; ok && contains(value, t.value) {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch t.field {
+	case "repository":
+		return contains(name, t.value)
+	case "namespace":
+		return contains(repo.namespace, t.value)
+	case "tag":
+		return t.matchesTag(repo)
+	}
+	if contains(name, t.value) || contains(repo.namespace, t.value) || t.matchesTag(repo) {
+		return true
+	}
+	for _, annotations := range repo.annotations {
+		for _, value := range annotations {
+			if contains(value, t.value) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func (t term) matchesTag(repo *repository) bool {
+	for tag := range repo.tags {
+		if contains(tag, t.value) {
+			return true
+		}
+	}
+	return false
+}
+
+func (query Query) matches(name string, repo *repository) bool {
+	for _, t := range query {
+		if !t.matches(name, repo) {
+			return false
+		}
+	}
+	return true
+}
+
+// result returns the repository as a result of the query, listing the tags
+// matching its tag terms.
+func (query Query) result(name string, repo *repository) Result {
+	var tagTerms []term
+	for _, t := range query {
+		if t.field == "tag" && !t.annotation {
+			tagTerms = append(tagTerms, t)
+		}
+	}
+
+	result := Result{
+		Repository: name,
+		Namespace:  repo.namespace,
+		Tags:       []string{},
+	}
+	for tag := range repo.tags {
+		matched := true
+		for _, t := range tagTerms {
+			if !contains(tag, t.value) {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			result.Tags = append(result.Tags, tag)
+		}
+	}
+	sort.Strings(result.Tags)
+
+	// The annotations of the manifests of later tags win
+	for _, tag := range result.Tags {
+		for key, value := range repo.annotations[repo.tags[tag]] {
+			if result.Annotations == nil {
+				result.Annotations = make(map[string]string)
+			}
+			result.Annotations[key] = value
+		}
+	}
+	return result
+}
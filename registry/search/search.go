@@ -0,0 +1,336 @@
+// Package search indexes the names, tags and annotations of the repositories
+// of a registry, and of the repositories a pull through cache stored for its
+// upstreams, so that they can be searched without walking the storage.
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	events "github.com/docker/go-events"
+	"github.com/opencontainers/go-digest"
+)
+
+// queueSize is the number of pushed manifests which can wait to be read.
+// Pushes beyond it are indexed by the next rebuild.
+const queueSize = 10000
+
+// Result is a repository matching a query.
+type Result struct {
+	Repository string `json:"repository"`
+	// Namespace is the upstream host a pull through cache stored the
+	// repository for
+	Namespace string `json:"namespace,omitempty"`
+	// Tags are the tags of the repository, or those matching the tag terms
+	// of the query if it has any
+	Tags []string `json:"tags"`
+	// Annotations are the annotations of the manifests of the tags
+	Annotations map[string]string `json:"annotations,omitempty"`
+}
+
+// repository is the indexed state of a repository.
+type repository struct {
+	namespace   string
+	tags        map[string]digest.Digest
+	annotations map[digest.Digest]map[string]string
+}
+
+func newRepository(namespace string) *repository {
+	return &repository{
+		namespace:   namespace,
+		tags:        make(map[string]digest.Digest),
+		annotations: make(map[digest.Digest]map[string]string),
+	}
+}
+
+// tag points the tag to the manifest, and drops the annotations of the
+// manifests no longer tagged.
+func (repo *repository) tag(tag string, dgst digest.Digest, annotations map[string]string) {
+	repo.tags[tag] = dgst
+	if len(annotations) > 0 {
+		repo.annotations[dgst] = annotations
+	}
+	repo.collect()
+}
+
+func (repo *repository) collect() {
+	tagged := make(map[digest.Digest]struct{}, len(repo.tags))
+	for _, dgst := range repo.tags {
+		tagged[dgst] = struct{}{}
+	}
+	for dgst := range repo.annotations {
+		if _, ok := tagged[dgst]; !ok {
+			delete(repo.annotations, dgst)
+		}
+	}
+}
+
+// update is a manifest pushed to, or cached in, a repository.
+type update struct {
+	repository string
+	tag        string
+	digest     digest.Digest
+}
+
+// Index is a sink of registry events which keeps an in-memory index of the
+// repositories up to date with the pushes, cache fills and deletions of the
+// registry. It is built from the storage by Rebuild, which must be called
+// again to pick up the changes made by other instances.
+type Index struct {
+	ctx        context.Context
+	cancel     context.CancelFunc
+	registry   distribution.Namespace
+	namespaced bool
+	queue      chan update
+
+	mu           sync.RWMutex
+	repositories map[string]*repository
+}
+
+// NewIndex returns an empty index reading the manifests from registry. When
+// namespaced is true, the first component of the name of a repository is
+// the upstream host it was cached from. The index stops reading pushed
+// manifests when ctx is done or the index is closed.
+func NewIndex(ctx context.Context, registry distribution.Namespace, namespaced bool) *Index {
+	idx := &Index{
+		registry:     registry,
+		namespaced:   namespaced,
+		queue:        make(chan update, queueSize),
+		repositories: make(map[string]*repository),
+	}
+	idx.ctx, idx.cancel = context.WithCancel(ctx)
+	go idx.work()
+	return idx
+}
+
+// Write updates the index with a push, cache or delete event. The manifests
+// of pushes are read in the background, so writing them does not block.
+func (idx *Index) Write(event events.Event) error {
+	e, ok := event.(notifications.Event)
+	if !ok {
+		return nil
+	}
+	switch e.Action {
+	case notifications.EventActionPush, notifications.EventActionCache:
+		if !isManifest(e.Target.MediaType) || e.Target.Digest == "" {
+			return nil
+		}
+		select {
+		case idx.queue <- update{repository: e.Target.Repository, tag: e.Target.Tag, digest: e.Target.Digest}:
+		default:
+			dcontext.GetLogger(idx.ctx).Warnf("search index queue is full, dropping %s@%s", e.Target.Repository, e.Target.Digest)
+		}
+	case notifications.EventActionDelete, notifications.EventActionEvict:
+		idx.delete(e.Target.Repository, e.Target.Tag, e.Target.Digest)
+	}
+	return nil
+}
+
+// Close stops reading the pushed manifests.
+func (idx *Index) Close() error {
+	idx.cancel()
+	return nil
+}
+
+func (idx *Index) work() {
+	for {
+		select {
+		case <-idx.ctx.Done():
+			return
+		case u := <-idx.queue:
+			idx.apply(u)
+		}
+	}
+}
+
+// apply indexes a pushed manifest.
+func (idx *Index) apply(u update) {
+	var annotations map[string]string
+	if u.tag != "" {
+		named, err := reference.WithName(u.repository)
+		if err != nil {
+			return
+		}
+		repo, err := idx.registry.Repository(idx.ctx, named)
+		if err != nil {
+			dcontext.GetLogger(idx.ctx).Errorf("search index: %v", err)
+			return
+		}
+		annotations, err = manifestAnnotations(idx.ctx, repo, u.digest)
+		if err != nil {
+			dcontext.GetLogger(idx.ctx).Warnf("search index: unable to read the annotations of %s@%s: %v", u.repository, u.digest, err)
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	repo, ok := idx.repositories[u.repository]
+	if !ok {
+		repo = newRepository(idx.namespace(u.repository))
+		idx.repositories[u.repository] = repo
+	}
+	if u.tag != "" {
+		repo.tag(u.tag, u.digest, annotations)
+	}
+}
+
+// delete removes a tag, the tags of a manifest, or a repository from the
+// index.
+func (idx *Index) delete(name, tag string, dgst digest.Digest) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	repo, ok := idx.repositories[name]
+	if !ok {
+		return
+	}
+	switch {
+	case tag != "":
+		delete(repo.tags, tag)
+	case dgst != "":
+		for tag, tagged := range repo.tags {
+			if tagged == dgst {
+				delete(repo.tags, tag)
+			}
+		}
+	default:
+		delete(idx.repositories, name)
+		return
+	}
+	repo.collect()
+}
+
+// namespace returns the upstream host of a repository of a namespaced pull
+// through cache.
+func (idx *Index) namespace(name string) string {
+	if !idx.namespaced {
+		return ""
+	}
+	host, _, _ := strings.Cut(name, "/")
+	return host
+}
+
+// Rebuild replaces the index with the repositories, tags and annotations
+// found in the storage.
+func (idx *Index) Rebuild(ctx context.Context) error {
+	enumerator, ok := idx.registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil
+	}
+
+	started := time.Now()
+	repositories := make(map[string]*repository)
+	err := enumerator.Enumerate(ctx, func(name string) error {
+		named, err := reference.WithName(name)
+		if err != nil {
+			return nil
+		}
+		repo, err := idx.registry.Repository(ctx, named)
+		if err != nil {
+			return err
+		}
+		indexed := newRepository(idx.namespace(name))
+		repositories[name] = indexed
+
+		tagService := repo.Tags(ctx)
+		tags, err := tagService.All(ctx)
+		if err != nil {
+			if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+				return nil
+			}
+			return err
+		}
+		for _, tag := range tags {
+			desc, err := tagService.Get(ctx, tag)
+			if err != nil {
+				continue
+			}
+			annotations, ok := indexed.annotations[desc.Digest]
+			if !ok {
+				annotations, err = manifestAnnotations(ctx, repo, desc.Digest)
+				if err != nil {
+					dcontext.GetLogger(ctx).Warnf("search index: unable to read the annotations of %s@%s: %v", name, desc.Digest, err)
+				}
+			}
+			indexed.tag(tag, desc.Digest, annotations)
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		// No repository was pushed yet
+		err = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	idx.mu.Lock()
+	idx.repositories = repositories
+	idx.mu.Unlock()
+	dcontext.GetLogger(ctx).Infof("search index rebuilt with %d repositories in %s", len(repositories), time.Since(started))
+	return nil
+}
+
+// Search returns up to n repositories matching the query, in lexical order
+// of their names after last, and whether more repositories match.
+func (idx *Index) Search(query Query, last string, n int) ([]Result, bool) {
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	var names []string
+	for name, repo := range idx.repositories {
+		if name > last && query.matches(name, repo) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	more := len(names) > n
+	if more {
+		names = names[:n]
+	}
+	results := make([]Result, 0, len(names))
+	for _, name := range names {
+		results = append(results, query.result(name, idx.repositories[name]))
+	}
+	return results, more
+}
+
+// manifestAnnotations returns the annotations of an OCI manifest or image
+// index. Other manifests have none.
+func manifestAnnotations(ctx context.Context, repo distribution.Repository, dgst digest.Digest) (map[string]string, error) {
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		return nil, err
+	}
+	manifest, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	switch m := manifest.(type) {
+	case *ocischema.DeserializedManifest:
+		return m.Annotations, nil
+	case *manifestlist.DeserializedManifestList:
+		return m.Annotations, nil
+	}
+	return nil, nil
+}
+
+func isManifest(mediaType string) bool {
+	for _, manifestMediaType := range distribution.ManifestMediaTypes() {
+		if mediaType == manifestMediaType {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,147 @@
+package search
+
+import (
+	"context"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3/notifications"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func repositories(results []Result) []string {
+	names := []string{}
+	for _, result := range results {
+		names = append(names, result.Repository)
+	}
+	return names
+}
+
+func TestParseQuery(t *testing.T) {
+	query := ParseQuery("Alpine  tag:v1 namespace:docker.io org.opencontainers.image.vendor=Acme other:x")
+	expected := Query{
+		{value: "alpine"},
+		{field: "tag", value: "v1"},
+		{field: "namespace", value: "docker.io"},
+		{field: "org.opencontainers.image.vendor", annotation: true, value: "acme"},
+		{value: "other:x"},
+	}
+	if len(query) != len(expected) {
+		t.Fatalf("expected %+v, got %+v", expected, query)
+	}
+	for i := range expected {
+		if query[i] != expected[i] {
+			t.Fatalf("expected term %d to be %+v, got %+v", i, expected[i], query[i])
+		}
+	}
+}
+
+func TestIndexSearch(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	testutil.PushAnnotatedImage(t, testutil.Repository(t, registry, "docker.io/library/alpine"), map[string]string{"org.opencontainers.image.vendor": "Alpine Linux"}, "3.18")
+	testutil.PushAnnotatedImage(t, testutil.Repository(t, registry, "docker.io/library/alpine"), nil, "latest")
+	testutil.PushAnnotatedImage(t, testutil.Repository(t, registry, "ghcr.io/acme/tool"), map[string]string{"org.opencontainers.image.vendor": "Acme"}, "v1.0")
+	testutil.PushAnnotatedImage(t, testutil.Repository(t, registry, "quay.io/acme/alpine-base"), nil, "v2")
+
+	index := NewIndex(ctx, registry, true)
+	defer index.Close()
+	if err := index.Rebuild(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tc := range []struct {
+		query    string
+		expected []string
+	}{
+		{"", []string{"docker.io/library/alpine", "ghcr.io/acme/tool", "quay.io/acme/alpine-base"}},
+		{"alpine", []string{"docker.io/library/alpine", "quay.io/acme/alpine-base"}},
+		{"acme", []string{"ghcr.io/acme/tool", "quay.io/acme/alpine-base"}},
+		{"namespace:ghcr.io", []string{"ghcr.io/acme/tool"}},
+		{"org.opencontainers.image.vendor=acme", []string{"ghcr.io/acme/tool"}},
+		{"repository:alpine tag:v", []string{"quay.io/acme/alpine-base"}},
+		{"tag:missing", []string{}},
+	} {
+		results, more := index.Search(ParseQuery(tc.query), "", 10)
+		if more || !reflect.DeepEqual(repositories(results), tc.expected) {
+			t.Errorf("query %q: expected %v, got %v (more: %v)", tc.query, tc.expected, repositories(results), more)
+		}
+	}
+
+	results, _ := index.Search(ParseQuery("tag:3"), "", 10)
+	if len(results) != 1 || !reflect.DeepEqual(results[0].Tags, []string{"3.18"}) {
+		t.Fatalf("expected the tags matching the query, got %+v", results)
+	}
+	if results[0].Namespace != "docker.io" || results[0].Annotations["org.opencontainers.image.vendor"] != "Alpine Linux" {
+		t.Fatalf("expected the namespace and annotations of the repository, got %+v", results[0])
+	}
+
+	// Paginate
+	results, more := index.Search(nil, "", 2)
+	if !more || !reflect.DeepEqual(repositories(results), []string{"docker.io/library/alpine", "ghcr.io/acme/tool"}) {
+		t.Fatalf("unexpected first page: %v (more: %v)", repositories(results), more)
+	}
+	results, more = index.Search(nil, "ghcr.io/acme/tool", 2)
+	if more || !reflect.DeepEqual(repositories(results), []string{"quay.io/acme/alpine-base"}) {
+		t.Fatalf("unexpected last page: %v (more: %v)", repositories(results), more)
+	}
+}
+
+func TestIndexEvents(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	index := NewIndex(ctx, registry, false)
+	defer index.Close()
+
+	dgst := testutil.PushAnnotatedImage(t, testutil.Repository(t, registry, "team/app"), map[string]string{"team": "platform"}, "v1").Descriptor.Digest
+	event := notifications.Event{Action: notifications.EventActionPush}
+	event.Target.Repository = "team/app"
+	event.Target.MediaType = v1.MediaTypeImageManifest
+	event.Target.Digest = dgst
+	event.Target.Tag = "v1"
+	if err := index.Write(event); err != nil {
+		t.Fatal(err)
+	}
+
+	// The pushed manifests are read in the background
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		results, _ := index.Search(ParseQuery("team=platform"), "", 10)
+		if len(results) == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected the pushed manifest to be indexed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	event = notifications.Event{Action: notifications.EventActionDelete}
+	event.Target.Repository = "team/app"
+	event.Target.Digest = dgst
+	if err := index.Write(event); err != nil {
+		t.Fatal(err)
+	}
+	results, _ := index.Search(nil, "", 10)
+	if len(results) != 1 || len(results[0].Tags) != 0 || len(results[0].Annotations) != 0 {
+		t.Fatalf("expected the tags of the deleted manifest to be removed, got %+v", results)
+	}
+
+	event.Target.Digest = ""
+	if err := index.Write(event); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := index.Search(nil, "", 10); len(results) != 0 {
+		t.Fatalf("expected the deleted repository to be removed, got %+v", results)
+	}
+}
@@ -0,0 +1,46 @@
+//go:build !windows
+
+package registry
+
+import (
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/sirupsen/logrus"
+)
+
+// handleReadOnlySignals switches the registry to read-only mode when the
+// process receives SIGUSR1, and back to read-write mode on SIGUSR2.
+func handleReadOnlySignals(app *handlers.App) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGUSR1, syscall.SIGUSR2)
+	go func() {
+		for sig := range signals {
+			app.SetReadOnly(sig == syscall.SIGUSR1)
+		}
+	}()
+}
+
+// handleReloadSignals reads the configuration again when the process
+// receives SIGHUP, and reloads the sections which can change without a
+// restart.
+func handleReloadSignals(registry *Registry, args []string) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP)
+	go func() {
+		for range signals {
+			config, err := resolveConfiguration(args)
+			if err != nil {
+				logrus.Errorf("error reloading the configuration: %v", err)
+				continue
+			}
+			if err := registry.Reload(config); err != nil {
+				logrus.Errorf("error reloading the configuration: %v", err)
+				continue
+			}
+			logrus.Info("reloaded the configuration")
+		}
+	}()
+}
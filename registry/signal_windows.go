@@ -0,0 +1,10 @@
+package registry
+
+import "github.com/distribution/distribution/v3/registry/handlers"
+
+// handleReadOnlySignals does nothing, as there are no user signals on
+// Windows. The read-only mode is switched with the administration API.
+func handleReadOnlySignals(app *handlers.App) {}
+
+// handleReloadSignals does nothing, as there is no SIGHUP on Windows.
+func handleReloadSignals(registry *Registry, args []string) {}
@@ -527,6 +527,74 @@ func TestLayerUploadZeroLength(t *testing.T) {
 	simpleUpload(t, bs, []byte{}, digestSha256Empty)
 }
 
+func TestDigestAlgorithm(t *testing.T) {
+	ctx := context.Background()
+	imageName, _ := reference.WithName("foo/bar")
+	driver := inmemory.New()
+	registry, err := NewRegistry(ctx, driver, BlobDescriptorCacheProvider(memory.NewInMemoryBlobDescriptorCacheProvider(memory.UnlimitedSize)), EnableDelete, EnableRedirect, DigestAlgorithm(digest.SHA512))
+	if err != nil {
+		t.Fatalf("error creating registry: %v", err)
+	}
+	repository, err := registry.Repository(ctx, imageName)
+	if err != nil {
+		t.Fatalf("unexpected error getting repo: %v", err)
+	}
+	bs := repository.Blobs(ctx)
+
+	desc, err := bs.Put(ctx, "application/octet-stream", []byte("put content"))
+	if err != nil {
+		t.Fatalf("unexpected error putting blob: %v", err)
+	}
+	if desc.Digest != digest.SHA512.FromString("put content") {
+		t.Fatalf("unexpected digest %s of put content", desc.Digest)
+	}
+
+	upload := func(blob []byte, dgst digest.Digest) distribution.Descriptor {
+		wr, err := bs.Create(ctx)
+		if err != nil {
+			t.Fatalf("unexpected error starting upload: %v", err)
+		}
+		if _, err := io.Copy(wr, bytes.NewReader(blob)); err != nil {
+			t.Fatalf("error copying into blob writer: %v", err)
+		}
+		desc, err := wr.Commit(ctx, distribution.Descriptor{Digest: dgst})
+		if err != nil {
+			t.Fatalf("unexpected error committing %s: %v", dgst, err)
+		}
+		return desc
+	}
+
+	// uploads are addressed with sha512, and verified with the digest the
+	// client provides
+	blob := []byte("uploaded content")
+	if desc := upload(blob, digest.SHA512.FromBytes(blob)); desc.Digest != digest.SHA512.FromBytes(blob) {
+		t.Fatalf("unexpected digest %s of uploaded content", desc.Digest)
+	}
+	other := []byte("sha256 content")
+	upload(other, digest.SHA256.FromBytes(other))
+	for _, dgst := range []digest.Digest{digest.SHA512.FromBytes(blob), digest.SHA256.FromBytes(other), digest.SHA512.FromBytes(other)} {
+		if _, err := bs.Stat(ctx, dgst); err != nil {
+			t.Fatalf("unexpected error statting %s: %v", dgst, err)
+		}
+	}
+
+	wr, err := bs.Create(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error starting upload: %v", err)
+	}
+	if _, err := io.Copy(wr, bytes.NewReader(blob)); err != nil {
+		t.Fatalf("error copying into blob writer: %v", err)
+	}
+	if _, err := wr.Commit(ctx, distribution.Descriptor{Digest: digest.SHA512.FromString("other content")}); err == nil {
+		t.Fatal("expected an error committing a blob with a mismatching sha512 digest")
+	}
+
+	upload([]byte{}, digestSha512Empty)
+	if _, err := bs.Stat(ctx, digestSha512Empty); err != nil {
+		t.Fatalf("unexpected error statting the empty blob: %v", err)
+	}
+}
+
 func simpleUpload(t *testing.T, bs distribution.BlobIngester, blob []byte, expectedDigest digest.Digest) {
 	ctx := context.Background()
 	wr, err := bs.Create(ctx)
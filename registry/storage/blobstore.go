@@ -18,6 +18,12 @@ import (
 type blobStore struct {
 	driver  driver.StorageDriver
 	statter distribution.BlobStatter
+	// journal records the linked blobs for online garbage collection, if
+	// enabled
+	journal *gcJournal
+	// algorithm is the algorithm of the digests computed for new content,
+	// digest.Canonical if empty
+	algorithm digest.Algorithm
 }
 
 var _ distribution.BlobProvider = &blobStore{}
@@ -56,11 +62,24 @@ func (bs *blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekC
 	return newFileReader(ctx, bs.driver, path, desc.Size)
 }
 
+// digestAlgorithm returns the algorithm of the digests computed for new
+// content.
+func (bs *blobStore) digestAlgorithm() digest.Algorithm {
+	if bs.algorithm == "" {
+		return digest.Canonical
+	}
+	return bs.algorithm
+}
+
 // Put stores the content p in the blob store, calculating the digest. If the
 // content is already present, only the digest will be returned. This should
 // only be used for small objects, such as manifests. This implemented as a convenience for other Put implementations
 func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
-	dgst := digest.FromBytes(p)
+	return bs.put(ctx, bs.digestAlgorithm().FromBytes(p), p)
+}
+
+// put stores the content p under dgst, its digest.
+func (bs *blobStore) put(ctx context.Context, dgst digest.Digest, p []byte) (distribution.Descriptor, error) {
 	desc, err := bs.statter.Stat(ctx, dgst)
 	if err == nil {
 		// content already present
@@ -89,12 +108,19 @@ func (bs *blobStore) Put(ctx context.Context, mediaType string, p []byte) (distr
 }
 
 func (bs *blobStore) Enumerate(ctx context.Context, ingester func(dgst digest.Digest) error) error {
+	return bs.enumerateParallel(ctx, 1, ingester)
+}
+
+// enumerateParallel applies ingester to each blob like Enumerate, walking
+// the blobs with up to concurrency parallel walks. The ingester is called
+// concurrently when concurrency is greater than one.
+func (bs *blobStore) enumerateParallel(ctx context.Context, concurrency int, ingester func(dgst digest.Digest) error) error {
 	specPath, err := pathFor(blobsPathSpec{})
 	if err != nil {
 		return err
 	}
 
-	return bs.driver.Walk(ctx, specPath, func(fileInfo driver.FileInfo) error {
+	return driver.WalkParallel(ctx, bs.driver, specPath, concurrency, func(fileInfo driver.FileInfo) error {
 		// skip directories
 		if fileInfo.IsDir() {
 			return nil
@@ -132,6 +158,12 @@ func (bs *blobStore) path(dgst digest.Digest) (string, error) {
 // link links the path to the provided digest by writing the digest into the
 // target file. Caller must ensure that the blob actually exists.
 func (bs *blobStore) link(ctx context.Context, path string, dgst digest.Digest) error {
+	if bs.journal != nil {
+		if err := bs.journal.record(ctx, dgst); err != nil {
+			return err
+		}
+	}
+
 	// The contents of the "link" file are the exact string contents of the
 	// digest, which is specified in that package.
 	return bs.driver.PutContent(ctx, path, []byte(dgst))
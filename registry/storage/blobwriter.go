@@ -20,6 +20,9 @@ var errResumableDigestNotAvailable = errors.New("resumable digest not available"
 const (
 	// digestSha256Empty is the canonical sha256 digest of empty data
 	digestSha256Empty = "sha256:e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+	// digestSha512Empty is the canonical sha512 digest of empty data
+	digestSha512Empty = "sha512:cf83e1357eefb8bdf1542850d66d8007d620e4050b5715dc83f4a921d36ce9ce47d0d13c5d85f2b0ff8318d2877eec2f63b931bd47417a81a538327af927da3e"
 )
 
 // blobWriter is used to control the various aspects of resumable
@@ -214,7 +217,7 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 
 		if canonical.Algorithm() == desc.Digest.Algorithm() {
 			// Common case: client and server prefer the same canonical digest
-			// algorithm - currently SHA256.
+			// algorithm - SHA256 unless configured otherwise.
 			verified = desc.Digest == canonical
 		} else {
 			// The client wants to use a different digest algorithm. They'll just
@@ -234,7 +237,7 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 		// the same, we don't need to read the data from the backend. This is
 		// because we've written the entire file in the lifecycle of the
 		// current instance.
-		if bw.written == size && digest.Canonical == desc.Digest.Algorithm() {
+		if bw.written == size && bw.digester.Digest().Algorithm() == desc.Digest.Algorithm() {
 			canonical = bw.digester.Digest()
 			verified = desc.Digest == canonical
 		}
@@ -243,7 +246,7 @@ func (bw *blobWriter) validateBlob(ctx context.Context, desc distribution.Descri
 		// paths. We may be able to make the size-based check a stronger
 		// guarantee, so this may be defensive.
 		if !verified {
-			digester := digest.Canonical.Digester()
+			digester := bw.blobStore.DigestAlgorithm().Digester()
 			verifier := desc.Digest.Verifier()
 
 			// Read the file from the backend driver and validate it.
@@ -326,7 +329,7 @@ func (bw *blobWriter) moveBlob(ctx context.Context, desc distribution.Descriptor
 			// a zero-length blob into a nonzero-length blob location. To
 			// prevent this horrid thing, we employ the hack of only allowing
 			// to this happen for the digest of an empty blob.
-			if desc.Digest == digestSha256Empty {
+			if desc.Digest == digestSha256Empty || desc.Digest == digestSha512Empty {
 				return bw.blobStore.driver.PutContent(ctx, blobPath, []byte{})
 			}
 
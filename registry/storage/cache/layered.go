@@ -0,0 +1,78 @@
+package cache
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/opencontainers/go-digest"
+)
+
+// layeredCacheProvider serves descriptors from a first level cache, such as
+// one in memory, in front of a second level one shared between instances,
+// such as redis.
+type layeredCacheProvider struct {
+	layeredBlobDescriptorService
+	l1 BlobDescriptorCacheProvider
+	l2 BlobDescriptorCacheProvider
+}
+
+// NewLayeredCacheProvider returns a cache provider looking descriptors up in
+// l1 first, then in l2. Descriptors found in l2 are added to l1. Since l1 is
+// not shared, descriptors cleared through another instance may still be
+// served from it until they are evicted.
+func NewLayeredCacheProvider(l1, l2 BlobDescriptorCacheProvider) BlobDescriptorCacheProvider {
+	return &layeredCacheProvider{
+		layeredBlobDescriptorService: layeredBlobDescriptorService{l1: l1, l2: l2},
+		l1:                           l1,
+		l2:                           l2,
+	}
+}
+
+func (lcp *layeredCacheProvider) RepositoryScoped(repo string) (distribution.BlobDescriptorService, error) {
+	l1, err := lcp.l1.RepositoryScoped(repo)
+	if err != nil {
+		return nil, err
+	}
+	l2, err := lcp.l2.RepositoryScoped(repo)
+	if err != nil {
+		return nil, err
+	}
+	return &layeredBlobDescriptorService{l1: l1, l2: l2}, nil
+}
+
+type layeredBlobDescriptorService struct {
+	l1 distribution.BlobDescriptorService
+	l2 distribution.BlobDescriptorService
+}
+
+func (lbds *layeredBlobDescriptorService) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	if desc, err := lbds.l1.Stat(ctx, dgst); err == nil {
+		return desc, nil
+	}
+
+	desc, err := lbds.l2.Stat(ctx, dgst)
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+
+	if err := lbds.l1.SetDescriptor(ctx, dgst, desc); err != nil {
+		dcontext.GetLoggerWithField(ctx, "blob", dgst).WithError(err).Error("error from first level cache setting desc")
+	}
+	return desc, nil
+}
+
+func (lbds *layeredBlobDescriptorService) Clear(ctx context.Context, dgst digest.Digest) error {
+	l1Err := lbds.l1.Clear(ctx, dgst)
+	if err := lbds.l2.Clear(ctx, dgst); err != nil {
+		return err
+	}
+	return l1Err
+}
+
+func (lbds *layeredBlobDescriptorService) SetDescriptor(ctx context.Context, dgst digest.Digest, desc distribution.Descriptor) error {
+	if err := lbds.l2.SetDescriptor(ctx, dgst, desc); err != nil {
+		return err
+	}
+	return lbds.l1.SetDescriptor(ctx, dgst, desc)
+}
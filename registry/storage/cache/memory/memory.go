@@ -3,11 +3,14 @@ package memory
 import (
 	"context"
 	"math"
+	"sync"
 
 	"github.com/distribution/distribution/v3"
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	"github.com/distribution/distribution/v3/reference"
 	"github.com/distribution/distribution/v3/registry/storage/cache"
-	lru "github.com/hashicorp/golang-lru"
+	"github.com/docker/go-metrics"
+	"github.com/hashicorp/golang-lru/simplelru"
 	"github.com/opencontainers/go-digest"
 )
 
@@ -18,6 +21,18 @@ const (
 
 	// UnlimitedSize indicates the cache size should not be limited.
 	UnlimitedSize = math.MaxInt
+
+	// entryOverhead approximates the memory taken by a cache entry besides
+	// its strings.
+	entryOverhead = 128
+)
+
+var (
+	// cacheCount counts the hits, misses and evictions of the in-memory
+	// caches
+	cacheCount = prometheus.StorageNamespace.NewLabeledCounter("inmemory_cache", "The number of requests and evictions of the in-memory blob descriptor cache", "type")
+	// cacheSizeGauge is the approximate size of the in-memory caches
+	cacheSizeGauge = prometheus.StorageNamespace.NewGauge("inmemory_cache_size", "The approximate size of the descriptors in the in-memory blob descriptor cache", metrics.Bytes)
 )
 
 type descriptorCacheKey struct {
@@ -25,26 +40,99 @@ type descriptorCacheKey struct {
 	repo   string
 }
 
+// size approximates the memory taken by the entry of the descriptor.
+func (key descriptorCacheKey) size(desc distribution.Descriptor) int64 {
+	return int64(len(key.digest) + len(key.repo) + len(desc.Digest) + len(desc.MediaType) + entryOverhead)
+}
+
 type inMemoryBlobDescriptorCacheProvider struct {
-	lru *lru.ARCCache
+	mu       sync.Mutex
+	lru      *simplelru.LRU
+	size     int64 // approximate size of the entries
+	maxBytes int64
+	removing bool // set while entries are removed rather than evicted
 }
 
 // NewInMemoryBlobDescriptorCacheProvider returns a new mapped-based cache for
 // storing blob descriptor data.
 func NewInMemoryBlobDescriptorCacheProvider(size int) cache.BlobDescriptorCacheProvider {
-	if size <= 0 {
-		size = math.MaxInt
+	return NewBoundedInMemoryBlobDescriptorCacheProvider(size, 0)
+}
+
+// NewBoundedInMemoryBlobDescriptorCacheProvider returns a new cache for
+// storing blob descriptor data, holding up to maxEntries descriptors taking
+// up to about maxBytes of memory. The least recently used descriptors are
+// evicted first. Either limit is disabled when it is not positive.
+func NewBoundedInMemoryBlobDescriptorCacheProvider(maxEntries int, maxBytes int64) cache.BlobDescriptorCacheProvider {
+	if maxEntries <= 0 {
+		maxEntries = math.MaxInt
+	}
+	imbdcp := &inMemoryBlobDescriptorCacheProvider{
+		maxBytes: maxBytes,
 	}
-	lruCache, err := lru.NewARC(size)
+	lru, err := simplelru.NewLRU(maxEntries, imbdcp.onRemove)
 	if err != nil {
-		// NewARC can only fail if size is <= 0, so this unreachable
+		// NewLRU can only fail if size is <= 0, so this unreachable
 		panic(err)
 	}
-	return &inMemoryBlobDescriptorCacheProvider{
-		lru: lruCache,
+	imbdcp.lru = lru
+	return imbdcp
+}
+
+// get returns the descriptor cached for the key, recording a hit or a miss.
+func (imbdcp *inMemoryBlobDescriptorCacheProvider) get(key descriptorCacheKey) (distribution.Descriptor, bool) {
+	imbdcp.mu.Lock()
+	defer imbdcp.mu.Unlock()
+
+	descriptor, ok := imbdcp.lru.Get(key)
+	if ok {
+		// Type assertion not really necessary, but included in case
+		// it's necessary for the fuzzer
+		if desc, ok := descriptor.(distribution.Descriptor); ok {
+			cacheCount.WithValues("Hit").Inc(1)
+			return desc, true
+		}
+	}
+	cacheCount.WithValues("Miss").Inc(1)
+	return distribution.Descriptor{}, false
+}
+
+// add caches the descriptor for the key, evicting the least recently used
+// descriptors beyond the limits.
+func (imbdcp *inMemoryBlobDescriptorCacheProvider) add(key descriptorCacheKey, desc distribution.Descriptor) {
+	imbdcp.mu.Lock()
+	defer imbdcp.mu.Unlock()
+
+	imbdcp.remove(key)
+	imbdcp.lru.Add(key, desc)
+	imbdcp.grow(key.size(desc))
+
+	for imbdcp.maxBytes > 0 && imbdcp.size > imbdcp.maxBytes && imbdcp.lru.Len() > 1 {
+		imbdcp.lru.RemoveOldest()
+	}
+}
+
+// remove removes the descriptor cached for the key. The caller holds mu.
+func (imbdcp *inMemoryBlobDescriptorCacheProvider) remove(key descriptorCacheKey) {
+	imbdcp.removing = true
+	imbdcp.lru.Remove(key)
+	imbdcp.removing = false
+}
+
+// onRemove accounts for the removal of an entry, whether evicted or removed.
+// It is called with mu held.
+func (imbdcp *inMemoryBlobDescriptorCacheProvider) onRemove(key, value interface{}) {
+	imbdcp.grow(-key.(descriptorCacheKey).size(value.(distribution.Descriptor)))
+	if !imbdcp.removing {
+		cacheCount.WithValues("Eviction").Inc(1)
 	}
 }
 
+func (imbdcp *inMemoryBlobDescriptorCacheProvider) grow(delta int64) {
+	imbdcp.size += delta
+	cacheSizeGauge.Inc(float64(delta))
+}
+
 func (imbdcp *inMemoryBlobDescriptorCacheProvider) RepositoryScoped(repo string) (distribution.BlobDescriptorService, error) {
 	if _, err := reference.ParseNormalizedNamed(repo); err != nil {
 		return nil, err
@@ -64,13 +152,8 @@ func (imbdcp *inMemoryBlobDescriptorCacheProvider) Stat(ctx context.Context, dgs
 	key := descriptorCacheKey{
 		digest: dgst,
 	}
-	descriptor, ok := imbdcp.lru.Get(key)
-	if ok {
-		// Type assertion not really necessary, but included in case
-		// it's necessary for the fuzzer
-		if desc, ok := descriptor.(distribution.Descriptor); ok {
-			return desc, nil
-		}
+	if desc, ok := imbdcp.get(key); ok {
+		return desc, nil
 	}
 	return distribution.Descriptor{}, distribution.ErrBlobUnknown
 }
@@ -79,7 +162,9 @@ func (imbdcp *inMemoryBlobDescriptorCacheProvider) Clear(ctx context.Context, dg
 	key := descriptorCacheKey{
 		digest: dgst,
 	}
-	imbdcp.lru.Remove(key)
+	imbdcp.mu.Lock()
+	defer imbdcp.mu.Unlock()
+	imbdcp.remove(key)
 	return nil
 }
 
@@ -104,7 +189,7 @@ func (imbdcp *inMemoryBlobDescriptorCacheProvider) SetDescriptor(ctx context.Con
 		key := descriptorCacheKey{
 			digest: dgst,
 		}
-		imbdcp.lru.Add(key, desc)
+		imbdcp.add(key, desc)
 		return nil
 	}
 	// we already know it, do nothing
@@ -128,13 +213,8 @@ func (rsimbdcp *repositoryScopedInMemoryBlobDescriptorCache) Stat(ctx context.Co
 		digest: dgst,
 		repo:   rsimbdcp.repo,
 	}
-	descriptor, ok := rsimbdcp.parent.lru.Get(key)
-	if ok {
-		// Type assertion not really necessary, but included in case
-		// it's necessary for the fuzzer
-		if desc, ok := descriptor.(distribution.Descriptor); ok {
-			return desc, nil
-		}
+	if desc, ok := rsimbdcp.parent.get(key); ok {
+		return desc, nil
 	}
 	return distribution.Descriptor{}, distribution.ErrBlobUnknown
 }
@@ -144,7 +224,9 @@ func (rsimbdcp *repositoryScopedInMemoryBlobDescriptorCache) Clear(ctx context.C
 		digest: dgst,
 		repo:   rsimbdcp.repo,
 	}
-	rsimbdcp.parent.lru.Remove(key)
+	rsimbdcp.parent.mu.Lock()
+	defer rsimbdcp.parent.mu.Unlock()
+	rsimbdcp.parent.remove(key)
 	return nil
 }
 
@@ -161,6 +243,6 @@ func (rsimbdcp *repositoryScopedInMemoryBlobDescriptorCache) SetDescriptor(ctx c
 		digest: dgst,
 		repo:   rsimbdcp.repo,
 	}
-	rsimbdcp.parent.lru.Add(key, desc)
+	rsimbdcp.parent.add(key, desc)
 	return rsimbdcp.parent.SetDescriptor(ctx, dgst, desc)
 }
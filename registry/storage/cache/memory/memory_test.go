@@ -1,9 +1,14 @@
 package memory
 
 import (
+	"context"
+	"strconv"
 	"testing"
 
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/cache"
 	"github.com/distribution/distribution/v3/registry/storage/cache/cachecheck"
+	"github.com/opencontainers/go-digest"
 )
 
 // TestInMemoryBlobInfoCache checks the in memory implementation is working
@@ -11,3 +16,79 @@ import (
 func TestInMemoryBlobInfoCache(t *testing.T) {
 	cachecheck.CheckBlobDescriptorCache(t, NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize))
 }
+
+func TestInMemoryBlobInfoCacheBounded(t *testing.T) {
+	ctx := context.Background()
+	descriptor := func(i int) distribution.Descriptor {
+		return distribution.Descriptor{
+			Digest:    digest.FromString(strconv.Itoa(i)),
+			Size:      int64(i),
+			MediaType: "application/octet-stream",
+		}
+	}
+	stat := func(provider cache.BlobDescriptorCacheProvider, i int) error {
+		_, err := provider.Stat(ctx, descriptor(i).Digest)
+		return err
+	}
+
+	byEntries := NewBoundedInMemoryBlobDescriptorCacheProvider(2, 0)
+	for i := 0; i < 3; i++ {
+		if err := byEntries.SetDescriptor(ctx, descriptor(i).Digest, descriptor(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stat(byEntries, 0) != distribution.ErrBlobUnknown || stat(byEntries, 1) != nil || stat(byEntries, 2) != nil {
+		t.Fatal("expected the least recently used descriptor to be evicted")
+	}
+
+	// Each descriptor takes up about 300 bytes
+	byBytes := NewBoundedInMemoryBlobDescriptorCacheProvider(0, 600)
+	for i := 0; i < 3; i++ {
+		if err := byBytes.SetDescriptor(ctx, descriptor(i).Digest, descriptor(i)); err != nil {
+			t.Fatal(err)
+		}
+		if i == 1 {
+			// descriptor 1 becomes the least recently used
+			if err := stat(byBytes, 0); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if stat(byBytes, 0) != nil || stat(byBytes, 1) != distribution.ErrBlobUnknown || stat(byBytes, 2) != nil {
+		t.Fatal("expected the least recently used descriptor to be evicted")
+	}
+
+	imbdcp := byBytes.(*inMemoryBlobDescriptorCacheProvider)
+	if err := byBytes.Clear(ctx, descriptor(0).Digest); err != nil {
+		t.Fatal(err)
+	}
+	if err := byBytes.Clear(ctx, descriptor(2).Digest); err != nil {
+		t.Fatal(err)
+	}
+	if imbdcp.size != 0 || imbdcp.lru.Len() != 0 {
+		t.Fatalf("expected an empty cache, got %d entries of %d bytes", imbdcp.lru.Len(), imbdcp.size)
+	}
+}
+
+// TestLayeredBlobInfoCache checks an in memory cache in front of another.
+func TestLayeredBlobInfoCache(t *testing.T) {
+	cachecheck.CheckBlobDescriptorCache(t, cache.NewLayeredCacheProvider(NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize), NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize)))
+
+	ctx := context.Background()
+	l1 := NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize)
+	l2 := NewInMemoryBlobDescriptorCacheProvider(UnlimitedSize)
+	desc := distribution.Descriptor{
+		Digest:    digest.FromString("layered"),
+		Size:      7,
+		MediaType: "application/octet-stream",
+	}
+	if err := l2.SetDescriptor(ctx, desc.Digest, desc); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cache.NewLayeredCacheProvider(l1, l2).Stat(ctx, desc.Digest); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := l1.Stat(ctx, desc.Digest); err != nil {
+		t.Fatalf("descriptor was not added to the first level cache: %v", err)
+	}
+}
@@ -0,0 +1,312 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// clusterSlots is the number of hash slots keys are spread over in a Redis
+// Cluster.
+const clusterSlots = 16384
+
+// defaultMaxRedirects is the number of times a command is redirected to
+// another node before failing, unless configured otherwise.
+const defaultMaxRedirects = 3
+
+var errPipelineUnsupported = errors.New("redis cluster: pipelining is not supported")
+
+// Cluster routes commands to the nodes of a Redis Cluster holding their keys.
+// The nodes are discovered from the slots reported by the configured ones,
+// which are looked up again when a node reports a key has moved. Each node
+// has its own connection pool.
+type Cluster struct {
+	addrs        []string
+	newPool      func(addr string) *redis.Pool
+	maxRedirects int
+
+	mu    sync.RWMutex
+	slots []string               // address of the node serving each slot, nil until discovered
+	pools map[string]*redis.Pool // keyed by node address
+}
+
+// NewCluster returns a cluster discovered from the nodes at addrs. newPool
+// returns the connection pool of the node at an address. maxRedirects is the
+// number of times a command is redirected to another node before failing.
+func NewCluster(addrs []string, maxRedirects int, newPool func(addr string) *redis.Pool) *Cluster {
+	if maxRedirects <= 0 {
+		maxRedirects = defaultMaxRedirects
+	}
+	return &Cluster{
+		addrs:        addrs,
+		newPool:      newPool,
+		maxRedirects: maxRedirects,
+		pools:        make(map[string]*redis.Pool),
+	}
+}
+
+// Dial returns a connection routing each command to the node holding its
+// key. It is meant to be the Dial function of the pool handed to the cache.
+func (c *Cluster) Dial() (redis.Conn, error) {
+	return &clusterConn{cluster: c}, nil
+}
+
+// ActiveCount returns the number of connections open to the nodes.
+func (c *Cluster) ActiveCount() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	var n int
+	for _, pool := range c.pools {
+		n += pool.ActiveCount()
+	}
+	return n
+}
+
+// Close closes the connections to the nodes.
+func (c *Cluster) Close() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var err error
+	for addr, pool := range c.pools {
+		if closeErr := pool.Close(); closeErr != nil && err == nil {
+			err = closeErr
+		}
+		delete(c.pools, addr)
+	}
+	return err
+}
+
+// pool returns the connection pool of the node at addr.
+func (c *Cluster) pool(addr string) *redis.Pool {
+	c.mu.RLock()
+	pool, ok := c.pools[addr]
+	c.mu.RUnlock()
+	if ok {
+		return pool
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if pool, ok := c.pools[addr]; ok {
+		return pool
+	}
+	pool = c.newPool(addr)
+	c.pools[addr] = pool
+	return pool
+}
+
+// node returns the address of the node serving the slot, discovering the
+// slots first if they are not known.
+func (c *Cluster) node(slot int) (string, error) {
+	c.mu.RLock()
+	slots := c.slots
+	c.mu.RUnlock()
+
+	if slots == nil {
+		if err := c.refresh(); err != nil {
+			return "", err
+		}
+		c.mu.RLock()
+		slots = c.slots
+		c.mu.RUnlock()
+	}
+
+	if slot < 0 {
+		// Commands without keys go to any node
+		return c.addrs[0], nil
+	}
+	if slots[slot] == "" {
+		return "", fmt.Errorf("redis cluster: slot %d is not served by any node", slot)
+	}
+	return slots[slot], nil
+}
+
+// refresh discovers the slots served by each node from the first configured
+// node which reports them.
+func (c *Cluster) refresh() error {
+	var err error
+	for _, addr := range c.addrs {
+		var slots []string
+		slots, err = c.clusterSlots(addr)
+		if err == nil {
+			c.mu.Lock()
+			c.slots = slots
+			c.mu.Unlock()
+			return nil
+		}
+	}
+	return fmt.Errorf("redis cluster: unable to discover slots: %v", err)
+}
+
+// clusterSlots returns the address of the master node serving each slot, as
+// reported by the node at addr.
+func (c *Cluster) clusterSlots(addr string) ([]string, error) {
+	conn := c.pool(addr).Get()
+	defer conn.Close()
+
+	ranges, err := redis.Values(conn.Do("CLUSTER", "SLOTS"))
+	if err != nil {
+		return nil, err
+	}
+
+	slots := make([]string, clusterSlots)
+	for _, r := range ranges {
+		fields, err := redis.Values(r, nil)
+		if err != nil || len(fields) < 3 {
+			return nil, fmt.Errorf("unexpected slot range %v", r)
+		}
+		start, err := redis.Int(fields[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		end, err := redis.Int(fields[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		master, err := redis.Values(fields[2], nil)
+		if err != nil || len(master) < 2 {
+			return nil, fmt.Errorf("unexpected slot node %v", fields[2])
+		}
+		host, err := redis.String(master[0], nil)
+		if err != nil {
+			return nil, err
+		}
+		port, err := redis.Int(master[1], nil)
+		if err != nil {
+			return nil, err
+		}
+		if host == "" {
+			// The node reports an unknown host for itself
+			host, _, _ = net.SplitHostPort(addr)
+		}
+		if start < 0 || end >= clusterSlots || start > end {
+			return nil, fmt.Errorf("unexpected slot range %d-%d", start, end)
+		}
+
+		node := net.JoinHostPort(host, strconv.Itoa(port))
+		for slot := start; slot <= end; slot++ {
+			slots[slot] = node
+		}
+	}
+	return slots, nil
+}
+
+// clusterConn routes each command to the node holding its key, taking a
+// connection from the pool of the node for the command. Commands are
+// expected to have their key as their first argument, as the commands of the
+// cache do.
+type clusterConn struct {
+	cluster *Cluster
+}
+
+func (cc *clusterConn) Close() error {
+	return nil
+}
+
+func (cc *clusterConn) Err() error {
+	return nil
+}
+
+func (cc *clusterConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" {
+		// Flushes pending commands, which there are none of
+		return nil, nil
+	}
+
+	slot := -1
+	if len(args) > 0 {
+		slot = keySlot(fmt.Sprint(args[0]))
+	}
+	addr, err := cc.cluster.node(slot)
+	if err != nil {
+		return nil, err
+	}
+
+	var asking bool
+	for redirects := 0; ; redirects++ {
+		reply, err := cc.do(addr, asking, commandName, args...)
+		redirect, ok := err.(redis.Error)
+		if !ok || redirects >= cc.cluster.maxRedirects {
+			return reply, err
+		}
+
+		// Errors are of the form "MOVED 3999 127.0.0.1:6381"
+		fields := strings.Fields(string(redirect))
+		if len(fields) != 3 {
+			return reply, err
+		}
+		switch fields[0] {
+		case "MOVED":
+			// The slot has moved for good, so the slots are out of date
+			if err := cc.cluster.refresh(); err != nil {
+				return nil, err
+			}
+			addr, asking = fields[2], false
+		case "ASK":
+			// The slot is being migrated, and the key is on the target
+			addr, asking = fields[2], true
+		default:
+			return reply, err
+		}
+	}
+}
+
+func (cc *clusterConn) do(addr string, asking bool, commandName string, args ...interface{}) (interface{}, error) {
+	conn := cc.cluster.pool(addr).Get()
+	defer conn.Close()
+
+	if asking {
+		if _, err := conn.Do("ASKING"); err != nil {
+			return nil, err
+		}
+	}
+	return conn.Do(commandName, args...)
+}
+
+func (cc *clusterConn) Send(commandName string, args ...interface{}) error {
+	return errPipelineUnsupported
+}
+
+func (cc *clusterConn) Flush() error {
+	return nil
+}
+
+func (cc *clusterConn) Receive() (interface{}, error) {
+	return nil, errPipelineUnsupported
+}
+
+// keySlot returns the hash slot of the key. Only the part of the key within
+// the first braces is hashed when there is one, so that related keys can be
+// kept on the same node.
+func keySlot(key string) int {
+	if start := strings.IndexByte(key, '{'); start >= 0 {
+		if end := strings.IndexByte(key[start+1:], '}'); end > 0 {
+			key = key[start+1 : start+1+end]
+		}
+	}
+	return int(crc16(key) % clusterSlots)
+}
+
+// crc16 computes the CRC16-CCITT (XMODEM) checksum Redis Cluster hashes keys
+// with.
+func crc16(s string) uint16 {
+	var crc uint16
+	for i := 0; i < len(s); i++ {
+		crc ^= uint16(s[i]) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+	}
+	return crc
+}
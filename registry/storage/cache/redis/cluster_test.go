@@ -0,0 +1,195 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// fakeConn answers commands with a function, in place of a server.
+type fakeConn struct {
+	do func(commandName string, args ...interface{}) (interface{}, error)
+}
+
+func (fc fakeConn) Close() error { return nil }
+func (fc fakeConn) Err() error   { return nil }
+func (fc fakeConn) Flush() error { return nil }
+
+func (fc fakeConn) Do(commandName string, args ...interface{}) (interface{}, error) {
+	if commandName == "" {
+		return nil, nil
+	}
+	return fc.do(commandName, args...)
+}
+
+func (fc fakeConn) Send(commandName string, args ...interface{}) error {
+	return errors.New("unexpected send")
+}
+
+func (fc fakeConn) Receive() (interface{}, error) {
+	return nil, errors.New("unexpected receive")
+}
+
+// fakeNode is a node of a cluster of two, each serving half of the slots.
+type fakeNode struct {
+	addr   string
+	slots  *[2]string // the nodes serving the first and second half of the slots
+	values map[string]string
+}
+
+func (fn *fakeNode) do(commandName string, args ...interface{}) (interface{}, error) {
+	half := func(start, end int, addr string) interface{} {
+		return []interface{}{int64(start), int64(end), []interface{}{[]byte("127.0.0.1"), int64(port(addr))}}
+	}
+	switch commandName {
+	case "CLUSTER":
+		return []interface{}{
+			half(0, clusterSlots/2-1, fn.slots[0]),
+			half(clusterSlots/2, clusterSlots-1, fn.slots[1]),
+		}, nil
+	case "PING":
+		return "PONG", nil
+	case "ASKING":
+		return "OK", nil
+	}
+
+	key := args[0].(string)
+	slot := keySlot(key)
+	if owner := fn.slots[slot/(clusterSlots/2)]; owner != fn.addr {
+		return nil, redis.Error(fmt.Sprintf("MOVED %d %s", slot, owner))
+	}
+	switch commandName {
+	case "SET":
+		fn.values[key] = args[1].(string)
+		return "OK", nil
+	case "GET":
+		return []byte(fn.values[key]), nil
+	}
+	return nil, fmt.Errorf("unexpected command %s", commandName)
+}
+
+func port(addr string) int {
+	var p int
+	fmt.Sscanf(addr, "127.0.0.1:%d", &p)
+	return p
+}
+
+func TestKeySlot(t *testing.T) {
+	for key, expected := range map[string]int{
+		"123456789": 12739,
+		"foo":       12182,
+	} {
+		if slot := keySlot(key); slot != expected {
+			t.Errorf("expected slot %d for key %q, got %d", expected, key, slot)
+		}
+	}
+	if keySlot("{user1000}.following") != keySlot("user1000") {
+		t.Error("the hash tag of the key is not used")
+	}
+	if keySlot("foo{}{bar}") != int(crc16("foo{}{bar}")%clusterSlots) {
+		t.Error("an empty hash tag is used")
+	}
+}
+
+func TestCluster(t *testing.T) {
+	slots := [2]string{"127.0.0.1:7000", "127.0.0.1:7001"}
+	nodes := map[string]*fakeNode{}
+	for _, addr := range []string{"127.0.0.1:7000", "127.0.0.1:7001", "127.0.0.1:7002"} {
+		nodes[addr] = &fakeNode{addr: addr, slots: &slots, values: map[string]string{}}
+	}
+
+	cluster := NewCluster([]string{"127.0.0.1:7000"}, 0, func(addr string) *redis.Pool {
+		return &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				node, ok := nodes[addr]
+				if !ok {
+					return nil, fmt.Errorf("unknown node %s", addr)
+				}
+				return fakeConn{do: node.do}, nil
+			},
+		}
+	})
+	pool := &redis.Pool{Dial: cluster.Dial}
+
+	// Keys are spread over the nodes
+	var keys [2]string
+	for i := 0; keys[0] == "" || keys[1] == ""; i++ {
+		key := fmt.Sprintf("blobs::%d", i)
+		keys[keySlot(key)/(clusterSlots/2)] = key
+	}
+	conn := pool.Get()
+	defer conn.Close()
+	for _, key := range keys {
+		if _, err := conn.Do("SET", key, "value of "+key); err != nil {
+			t.Fatalf("unexpected error setting %s: %v", key, err)
+		}
+	}
+	for i, key := range keys {
+		if nodes[slots[i]].values[key] != "value of "+key {
+			t.Fatalf("key %s was not set on node %s", key, slots[i])
+		}
+	}
+	if _, err := conn.Do("PING"); err != nil {
+		t.Fatalf("unexpected error pinging: %v", err)
+	}
+
+	// The slots are looked up again once a node reports they moved
+	slots[1] = "127.0.0.1:7002"
+	if _, err := conn.Do("SET", keys[1], "moved"); err != nil {
+		t.Fatalf("unexpected error setting moved key: %v", err)
+	}
+	if nodes["127.0.0.1:7002"].values[keys[1]] != "moved" {
+		t.Fatal("moved key was not set on its new node")
+	}
+	value, err := redis.String(conn.Do("GET", keys[1]))
+	if err != nil || value != "moved" {
+		t.Fatalf("unexpected value of moved key: %q, %v", value, err)
+	}
+
+	// Redirects are bounded
+	nodes["127.0.0.1:7002"].addr = "127.0.0.1:7003"
+	if _, err := conn.Do("GET", keys[1]); err == nil {
+		t.Fatal("expected an error when redirected endlessly")
+	}
+
+	if err := conn.Send("GET", keys[0]); err == nil {
+		t.Fatal("expected an error pipelining commands")
+	}
+}
+
+func TestClusterAsk(t *testing.T) {
+	var commands []string
+	cluster := NewCluster([]string{"127.0.0.1:7000"}, 0, func(addr string) *redis.Pool {
+		return &redis.Pool{
+			Dial: func() (redis.Conn, error) {
+				return fakeConn{do: func(commandName string, args ...interface{}) (interface{}, error) {
+					commands = append(commands, addr+" "+commandName)
+					switch {
+					case commandName == "CLUSTER":
+						return []interface{}{[]interface{}{int64(0), int64(clusterSlots - 1), []interface{}{[]byte(""), int64(7000)}}}, nil
+					case addr == "127.0.0.1:7000":
+						return nil, redis.Error("ASK 1 127.0.0.1:7001")
+					default:
+						return "OK", nil
+					}
+				}}, nil
+			},
+		}
+	})
+
+	conn, _ := cluster.Dial()
+	if _, err := conn.Do("SET", "key", "value"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := []string{
+		"127.0.0.1:7000 CLUSTER",
+		"127.0.0.1:7000 SET",
+		"127.0.0.1:7001 ASKING",
+		"127.0.0.1:7001 SET",
+	}
+	if fmt.Sprint(commands) != fmt.Sprint(expected) {
+		t.Fatalf("expected commands %v, got %v", expected, commands)
+	}
+}
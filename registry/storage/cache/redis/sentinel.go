@@ -0,0 +1,64 @@
+package redis
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+// SentinelMaster returns the address of the master the sentinels at addrs
+// monitor as name, as reported by the first sentinel which knows it. dial
+// connects to a sentinel.
+func SentinelMaster(name string, addrs []string, dial func(addr string) (redis.Conn, error)) (string, error) {
+	var err error
+	for _, addr := range addrs {
+		var master string
+		master, err = sentinelMaster(name, addr, dial)
+		if err == nil {
+			return master, nil
+		}
+	}
+	return "", fmt.Errorf("redis sentinel: unable to find master %q: %v", name, err)
+}
+
+func sentinelMaster(name, addr string, dial func(addr string) (redis.Conn, error)) (string, error) {
+	conn, err := dial(addr)
+	if err != nil {
+		return "", err
+	}
+	defer conn.Close()
+
+	reply, err := redis.Strings(conn.Do("SENTINEL", "get-master-addr-by-name", name))
+	if err != nil {
+		if err == redis.ErrNil {
+			return "", fmt.Errorf("master %q is unknown to sentinel %s", name, addr)
+		}
+		return "", err
+	}
+	if len(reply) != 2 {
+		return "", fmt.Errorf("unexpected reply from sentinel %s: %v", addr, reply)
+	}
+	return net.JoinHostPort(reply[0], reply[1]), nil
+}
+
+// CheckMaster returns an error unless the connection is to a master. A
+// connection to a former master is to be closed after a failover, as it
+// would reject writes.
+func CheckMaster(conn redis.Conn) error {
+	reply, err := redis.Values(conn.Do("ROLE"))
+	if err != nil {
+		return err
+	}
+	if len(reply) == 0 {
+		return fmt.Errorf("unexpected reply to ROLE: %v", reply)
+	}
+	role, err := redis.String(reply[0], nil)
+	if err != nil {
+		return err
+	}
+	if role != "master" {
+		return fmt.Errorf("redis server is a %s, not a master", role)
+	}
+	return nil
+}
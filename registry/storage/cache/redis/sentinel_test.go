@@ -0,0 +1,49 @@
+package redis
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/gomodule/redigo/redis"
+)
+
+func TestSentinel(t *testing.T) {
+	sentinels := map[string]fakeConn{
+		"127.0.0.1:26379": {do: func(commandName string, args ...interface{}) (interface{}, error) {
+			return nil, errors.New("sentinel is down")
+		}},
+		"127.0.0.1:26380": {do: func(commandName string, args ...interface{}) (interface{}, error) {
+			if commandName != "SENTINEL" || args[0] != "get-master-addr-by-name" {
+				return nil, fmt.Errorf("unexpected command %s %v", commandName, args)
+			}
+			if args[1] != "registry" {
+				return nil, nil
+			}
+			return []interface{}{[]byte("10.0.0.1"), []byte("6379")}, nil
+		}},
+	}
+	dial := func(addr string) (redis.Conn, error) {
+		return sentinels[addr], nil
+	}
+
+	master, err := SentinelMaster("registry", []string{"127.0.0.1:26379", "127.0.0.1:26380"}, dial)
+	if err != nil || master != "10.0.0.1:6379" {
+		t.Fatalf("unexpected master %q: %v", master, err)
+	}
+	if _, err := SentinelMaster("other", []string{"127.0.0.1:26380"}, dial); err == nil {
+		t.Fatal("expected an error for an unknown master")
+	}
+
+	role := func(role string) redis.Conn {
+		return fakeConn{do: func(commandName string, args ...interface{}) (interface{}, error) {
+			return []interface{}{[]byte(role), int64(0)}, nil
+		}}
+	}
+	if err := CheckMaster(role("master")); err != nil {
+		t.Fatalf("unexpected error checking master: %v", err)
+	}
+	if err := CheckMaster(role("slave")); err == nil {
+		t.Fatal("expected an error checking a replica")
+	}
+}
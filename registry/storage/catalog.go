@@ -59,12 +59,19 @@ func (reg *registry) Repositories(ctx context.Context, repos []string, last stri
 
 // Enumerate applies ingester to each repository
 func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error) error {
+	return reg.enumerateParallel(ctx, 1, ingester)
+}
+
+// enumerateParallel applies ingester to each repository like Enumerate,
+// walking the repositories with up to concurrency parallel walks. The
+// ingester is called concurrently when concurrency is greater than one.
+func (reg *registry) enumerateParallel(ctx context.Context, concurrency int, ingester func(string) error) error {
 	root, err := pathFor(repositoriesRootPathSpec{})
 	if err != nil {
 		return err
 	}
 
-	err = reg.blobStore.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+	err = driver.WalkParallel(ctx, reg.blobStore.driver, root, concurrency, func(fileInfo driver.FileInfo) error {
 		return handleRepository(fileInfo, root, "", ingester)
 	})
 
@@ -65,14 +65,8 @@ func newAzureClient(params *Parameters) (*azureClient, error) {
 		}, nil
 	}
 
-	var cred azcore.TokenCredential
-	var err error
-	if params.Credentials.Type == "client_secret" {
-		creds := &params.Credentials
-		if cred, err = azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.Secret, nil); err != nil {
-			return nil, err
-		}
-	} else if cred, err = azidentity.NewDefaultAzureCredential(nil); err != nil {
+	cred, err := newTokenCredential(params.Credentials)
+	if err != nil {
 		return nil, err
 	}
 
@@ -91,6 +85,33 @@ func newAzureClient(params *Parameters) (*azureClient, error) {
 	}, nil
 }
 
+// newTokenCredential returns the Azure AD credential of the given type. The
+// default credential tries the environment, workload identity and managed
+// identity in turn.
+func newTokenCredential(creds Credentials) (azcore.TokenCredential, error) {
+	switch creds.Type {
+	case credentialsClientSecret:
+		return azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.Secret, nil)
+	case credentialsManagedIdentity:
+		options := &azidentity.ManagedIdentityCredentialOptions{}
+		if creds.ClientID != "" {
+			// A user-assigned identity, rather than the system-assigned one
+			options.ID = azidentity.ClientID(creds.ClientID)
+		}
+		return azidentity.NewManagedIdentityCredential(options)
+	case credentialsWorkloadIdentity:
+		// Unset options are read from the environment set up by the
+		// workload identity webhook
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientID:      creds.ClientID,
+			TenantID:      creds.TenantID,
+			TokenFilePath: creds.TokenFile,
+		})
+	default:
+		return azidentity.NewDefaultAzureCredential(nil)
+	}
+}
+
 func (a *azureClient) ContainerClient() *container.Client {
 	return a.client.ServiceClient().NewContainerClient(a.container)
 }
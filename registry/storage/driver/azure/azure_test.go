@@ -81,6 +81,8 @@ func TestParamParsing(t *testing.T) {
 	expectErrors := []map[string]interface{}{
 		{},
 		{"accountname": "acc1"},
+		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "client_secret", "clientid": "c1"}},
+		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "shared_key"}},
 	}
 	for _, parameters := range expectErrors {
 		if _, err := NewParameters(parameters); err == nil {
@@ -91,6 +93,8 @@ func TestParamParsing(t *testing.T) {
 		{"accountname": "acc1", "accountkey": "k1", "container": "c1"},
 		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "default"}},
 		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "client_secret", "clientid": "c1", "tenantid": "t1", "secret": "s1"}},
+		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "managed_identity", "clientid": "c1"}},
+		{"accountname": "acc1", "container": "c1", "credentials": map[string]interface{}{"type": "workload_identity", "tokenfile": "/var/run/token"}},
 	}
 	expecteds := []Parameters{
 		{
@@ -106,6 +110,16 @@ func TestParamParsing(t *testing.T) {
 			Credentials: Credentials{Type: "client_secret", ClientID: "c1", TenantID: "t1", Secret: "s1"},
 			Realm:       "core.windows.net", ServiceURL: "https://acc1.blob.core.windows.net",
 		},
+		{
+			Container: "c1", AccountName: "acc1",
+			Credentials: Credentials{Type: "managed_identity", ClientID: "c1"},
+			Realm:       "core.windows.net", ServiceURL: "https://acc1.blob.core.windows.net",
+		},
+		{
+			Container: "c1", AccountName: "acc1",
+			Credentials: Credentials{Type: "workload_identity", TokenFile: "/var/run/token"},
+			Realm:       "core.windows.net", ServiceURL: "https://acc1.blob.core.windows.net",
+		},
 	}
 	for i, expected := range expecteds {
 		actual, err := NewParameters(input[i])
@@ -117,3 +131,15 @@ func TestParamParsing(t *testing.T) {
 		}
 	}
 }
+
+func TestNewTokenCredential(t *testing.T) {
+	for _, creds := range []Credentials{
+		{Type: "managed_identity"},
+		{Type: "managed_identity", ClientID: "c1"},
+		{Type: "workload_identity", ClientID: "c1", TenantID: "t1", TokenFile: "/var/run/token"},
+	} {
+		if _, err := newTokenCredential(creds); err != nil {
+			t.Errorf("%+v: %v", creds, err)
+		}
+	}
+}
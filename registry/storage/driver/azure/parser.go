@@ -11,11 +11,22 @@ const (
 	defaultRealm = "core.windows.net"
 )
 
+// Types of the credentials used in place of an account key
+const (
+	credentialsDefault          = "default"
+	credentialsClientSecret     = "client_secret"
+	credentialsManagedIdentity  = "managed_identity"
+	credentialsWorkloadIdentity = "workload_identity"
+)
+
 type Credentials struct {
 	Type     string `mapstructure:"type"`
 	ClientID string `mapstructure:"clientid"`
 	TenantID string `mapstructure:"tenantid"`
 	Secret   string `mapstructure:"secret"`
+
+	// TokenFile is the path of the federated token of a workload identity
+	TokenFile string `mapstructure:"tokenfile"`
 }
 
 type Parameters struct {
@@ -42,6 +53,18 @@ func NewParameters(parameters map[string]interface{}) (*Parameters, error) {
 	if params.Container == "" {
 		return nil, errors.New("no container parameter provider")
 	}
+	if params.AccountKey == "" {
+		switch creds := params.Credentials; creds.Type {
+		case "", credentialsDefault, credentialsManagedIdentity, credentialsWorkloadIdentity:
+		case credentialsClientSecret:
+			if creds.ClientID == "" || creds.TenantID == "" || creds.Secret == "" {
+				return nil, errors.New("client_secret credentials require the clientid, tenantid and secret parameters")
+			}
+		default:
+			return nil, fmt.Errorf("unknown credentials type %q, expected one of %s, %s, %s or %s",
+				creds.Type, credentialsDefault, credentialsClientSecret, credentialsManagedIdentity, credentialsWorkloadIdentity)
+		}
+	}
 	if params.ServiceURL == "" {
 		params.ServiceURL = fmt.Sprintf("https://%s.blob.%s", params.AccountName, params.Realm)
 	}
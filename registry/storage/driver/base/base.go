@@ -39,17 +39,28 @@ package base
 
 import (
 	"context"
+	"errors"
 	"io"
 	"time"
 
 	dcontext "github.com/distribution/distribution/v3/context"
 	prometheus "github.com/distribution/distribution/v3/metrics"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/tracing"
 	"github.com/docker/go-metrics"
 )
 
-// storageAction is the metrics of blob related operations
-var storageAction = prometheus.StorageNamespace.NewLabeledTimer("action", "The number of seconds that the storage action takes", "driver", "action")
+var (
+	// storageAction is the metrics of blob related operations
+	storageAction = prometheus.StorageNamespace.NewLabeledTimer("action", "The number of seconds that the storage action takes", "driver", "action")
+
+	// storageErrors counts the failed storage actions, but for the paths
+	// not found
+	storageErrors = prometheus.StorageNamespace.NewLabeledCounter("errors", "The number of storage actions which failed", "driver", "action")
+
+	// storageBytes counts the bytes read and written by the storage actions
+	storageBytes = prometheus.StorageNamespace.NewLabeledCounter("bytes", "The number of bytes read and written by the storage actions", "driver", "action")
+)
 
 func init() {
 	metrics.Register(prometheus.StorageNamespace)
@@ -88,6 +99,52 @@ func (base *Base) setDriverName(e error) error {
 	}
 }
 
+// observe records the duration of a storage action and, if it failed for
+// another reason than a path not found, its error.
+func (base *Base) observe(action string, start time.Time, err error) {
+	storageAction.WithValues(base.Name(), action).UpdateSince(start)
+	var notFound storagedriver.PathNotFoundError
+	if err != nil && !errors.As(err, &notFound) {
+		storageErrors.WithValues(base.Name(), action).Inc(1)
+	}
+}
+
+// countingReader counts the bytes read from the storage.
+type countingReader struct {
+	io.ReadCloser
+	bytes metrics.Counter
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if n > 0 {
+		r.bytes.Inc(float64(n))
+	}
+	return n, err
+}
+
+// countingWriter counts the bytes written to the storage.
+type countingWriter struct {
+	storagedriver.FileWriter
+	bytes metrics.Counter
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	n, err := w.FileWriter.Write(p)
+	if n > 0 {
+		w.bytes.Inc(float64(n))
+	}
+	return n, err
+}
+
+// startSpan starts the span of an operation of the storage driver, within
+// the span of the request of ctx.
+func (base *Base) startSpan(ctx context.Context, action, path string) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "storage."+action,
+		tracing.String("storage.driver", base.Name()),
+		tracing.String("storage.path", path))
+}
+
 // GetContent wraps GetContent of underlying storage driver.
 func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 	ctx, done := dcontext.WithTrace(ctx)
@@ -98,8 +155,11 @@ func (base *Base) GetContent(ctx context.Context, path string) ([]byte, error) {
 	}
 
 	start := time.Now()
+	ctx, span := base.startSpan(ctx, "GetContent", path)
 	b, e := base.StorageDriver.GetContent(ctx, path)
-	storageAction.WithValues(base.Name(), "GetContent").UpdateSince(start)
+	span.End(e)
+	base.observe("GetContent", start, e)
+	storageBytes.WithValues(base.Name(), "GetContent").Inc(float64(len(b)))
 	return b, base.setDriverName(e)
 }
 
@@ -113,8 +173,13 @@ func (base *Base) PutContent(ctx context.Context, path string, content []byte) e
 	}
 
 	start := time.Now()
+	ctx, span := base.startSpan(ctx, "PutContent", path)
 	err := base.setDriverName(base.StorageDriver.PutContent(ctx, path, content))
-	storageAction.WithValues(base.Name(), "PutContent").UpdateSince(start)
+	span.End(err)
+	base.observe("PutContent", start, err)
+	if err == nil {
+		storageBytes.WithValues(base.Name(), "PutContent").Inc(float64(len(content)))
+	}
 	return err
 }
 
@@ -131,8 +196,15 @@ func (base *Base) Reader(ctx context.Context, path string, offset int64) (io.Rea
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	start := time.Now()
+	ctx, span := base.startSpan(ctx, "Reader", path)
 	rc, e := base.StorageDriver.Reader(ctx, path, offset)
-	return rc, base.setDriverName(e)
+	span.End(e)
+	base.observe("Reader", start, e)
+	if e != nil {
+		return nil, base.setDriverName(e)
+	}
+	return &countingReader{ReadCloser: rc, bytes: storageBytes.WithValues(base.Name(), "Reader")}, nil
 }
 
 // Writer wraps Writer of underlying storage driver.
@@ -144,8 +216,15 @@ func (base *Base) Writer(ctx context.Context, path string, append bool) (storage
 		return nil, storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
+	start := time.Now()
+	ctx, span := base.startSpan(ctx, "Writer", path)
 	writer, e := base.StorageDriver.Writer(ctx, path, append)
-	return writer, base.setDriverName(e)
+	span.End(e)
+	base.observe("Writer", start, e)
+	if e != nil {
+		return nil, base.setDriverName(e)
+	}
+	return &countingWriter{FileWriter: writer, bytes: storageBytes.WithValues(base.Name(), "Writer")}, nil
 }
 
 // Stat wraps Stat of underlying storage driver.
@@ -158,8 +237,10 @@ func (base *Base) Stat(ctx context.Context, path string) (storagedriver.FileInfo
 	}
 
 	start := time.Now()
+	ctx, span := base.startSpan(ctx, "Stat", path)
 	fi, e := base.StorageDriver.Stat(ctx, path)
-	storageAction.WithValues(base.Name(), "Stat").UpdateSince(start)
+	span.End(e)
+	base.observe("Stat", start, e)
 	return fi, base.setDriverName(e)
 }
 
@@ -173,8 +254,10 @@ func (base *Base) List(ctx context.Context, path string) ([]string, error) {
 	}
 
 	start := time.Now()
+	ctx, span := base.startSpan(ctx, "List", path)
 	str, e := base.StorageDriver.List(ctx, path)
-	storageAction.WithValues(base.Name(), "List").UpdateSince(start)
+	span.End(e)
+	base.observe("List", start, e)
 	return str, base.setDriverName(e)
 }
 
@@ -190,8 +273,11 @@ func (base *Base) Move(ctx context.Context, sourcePath string, destPath string)
 	}
 
 	start := time.Now()
+	ctx, span := base.startSpan(ctx, "Move", sourcePath)
+	span.SetAttributes(tracing.String("storage.destination", destPath))
 	err := base.setDriverName(base.StorageDriver.Move(ctx, sourcePath, destPath))
-	storageAction.WithValues(base.Name(), "Move").UpdateSince(start)
+	span.End(err)
+	base.observe("Move", start, err)
 	return err
 }
 
@@ -205,8 +291,10 @@ func (base *Base) Delete(ctx context.Context, path string) error {
 	}
 
 	start := time.Now()
+	ctx, span := base.startSpan(ctx, "Delete", path)
 	err := base.setDriverName(base.StorageDriver.Delete(ctx, path))
-	storageAction.WithValues(base.Name(), "Delete").UpdateSince(start)
+	span.End(err)
+	base.observe("Delete", start, err)
 	return err
 }
 
@@ -220,8 +308,10 @@ func (base *Base) URLFor(ctx context.Context, path string, options map[string]in
 	}
 
 	start := time.Now()
+	ctx, span := base.startSpan(ctx, "URLFor", path)
 	str, e := base.StorageDriver.URLFor(ctx, path, options)
-	storageAction.WithValues(base.Name(), "URLFor").UpdateSince(start)
+	span.End(e)
+	base.observe("URLFor", start, e)
 	return str, base.setDriverName(e)
 }
 
@@ -234,5 +324,10 @@ func (base *Base) Walk(ctx context.Context, path string, f storagedriver.WalkFn)
 		return storagedriver.InvalidPathError{Path: path, DriverName: base.StorageDriver.Name()}
 	}
 
-	return base.setDriverName(base.StorageDriver.Walk(ctx, path, f))
+	start := time.Now()
+	ctx, span := base.startSpan(ctx, "Walk", path)
+	err := base.setDriverName(base.StorageDriver.Walk(ctx, path, f))
+	span.End(err)
+	base.observe("Walk", start, err)
+	return err
 }
@@ -0,0 +1,82 @@
+package base
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/docker/go-metrics"
+)
+
+// fakeDriver implements the storage actions measured by the tests.
+type fakeDriver struct {
+	storagedriver.StorageDriver
+	content []byte
+}
+
+func (d *fakeDriver) Name() string {
+	return "fake"
+}
+
+func (d *fakeDriver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	return io.NopCloser(bytes.NewReader(d.content[offset:])), nil
+}
+
+func (d *fakeDriver) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	if path == "/missing" {
+		return nil, storagedriver.PathNotFoundError{Path: path}
+	}
+	return nil, errors.New("backend unavailable")
+}
+
+// metricValue returns the value of the counter of the storage action, as
+// scraped by prometheus.
+func metricValue(t *testing.T, name, action string) float64 {
+	w := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	prefix := fmt.Sprintf(`%s{action="%s",driver="fake"} `, name, action)
+	for _, line := range strings.Split(w.Body.String(), "\n") {
+		if strings.HasPrefix(line, prefix) {
+			value, err := strconv.ParseFloat(strings.TrimPrefix(line, prefix), 64)
+			if err != nil {
+				t.Fatalf("unexpected metric %q: %v", line, err)
+			}
+			return value
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	base := &Base{StorageDriver: &fakeDriver{content: []byte("0123456789")}}
+	ctx := context.Background()
+
+	rc, err := base.Reader(ctx, "/blob", 4)
+	if err != nil {
+		t.Fatalf("unexpected error opening reader: %v", err)
+	}
+	if _, err := io.Copy(io.Discard, rc); err != nil {
+		t.Fatalf("unexpected error reading: %v", err)
+	}
+	rc.Close()
+	if read := metricValue(t, "registry_storage_bytes_total", "Reader"); read != 6 {
+		t.Fatalf("unexpected bytes read: %v", read)
+	}
+
+	base.Stat(ctx, "/missing")
+	if failed := metricValue(t, "registry_storage_errors_total", "Stat"); failed != 0 {
+		t.Fatalf("unexpected errors counted for a path not found: %v", failed)
+	}
+	base.Stat(ctx, "/path")
+	if failed := metricValue(t, "registry_storage_errors_total", "Stat"); failed != 1 {
+		t.Fatalf("unexpected errors: %v", failed)
+	}
+}
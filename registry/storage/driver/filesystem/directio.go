@@ -0,0 +1,74 @@
+package filesystem
+
+import (
+	"os"
+)
+
+// directIOAlignment is the alignment of the offsets, sizes and memory
+// addresses of direct I/O, which is the logical block size of most disks.
+const directIOAlignment = 4096
+
+// directWriter buffers the content written to a file, and writes the full
+// buffers with direct I/O, bypassing the page cache. The content which does
+// not fill a buffer, or which is not aligned, is written through the page
+// cache.
+type directWriter struct {
+	file   *os.File
+	direct *os.File
+	offset int64
+	// mem is the aligned memory of the buffer
+	mem []byte
+	buf []byte
+}
+
+func newDirectWriter(file, direct *os.File, offset int64, size int) *directWriter {
+	w := &directWriter{
+		file:   file,
+		direct: direct,
+		offset: offset,
+		mem:    alignedBuffer(size),
+	}
+	w.reset()
+	return w
+}
+
+// reset empties the buffer, limiting it so that it ends at an aligned offset
+// of the file, after which all the following buffers are aligned.
+func (w *directWriter) reset() {
+	w.buf = w.mem[: 0 : len(w.mem)-int(w.offset%directIOAlignment)]
+}
+
+func (w *directWriter) Write(p []byte) (int, error) {
+	var nn int
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		nn += n
+		if len(w.buf) == cap(w.buf) {
+			if err := w.Flush(); err != nil {
+				return nn, err
+			}
+		}
+	}
+	return nn, nil
+}
+
+// Flush writes the buffered content to the file.
+func (w *directWriter) Flush() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+
+	f := w.file
+	if w.offset%directIOAlignment == 0 && len(w.buf)%directIOAlignment == 0 {
+		f = w.direct
+	}
+	n, err := f.WriteAt(w.buf, w.offset)
+	if err != nil {
+		return err
+	}
+	w.offset += int64(n)
+	w.reset()
+	return nil
+}
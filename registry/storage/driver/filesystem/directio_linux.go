@@ -0,0 +1,25 @@
+package filesystem
+
+import (
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+const directIOSupported = true
+
+// openDirect opens the file at path for writing with direct I/O.
+func openDirect(path string) (*os.File, error) {
+	return os.OpenFile(path, os.O_WRONLY|syscall.O_DIRECT, 0)
+}
+
+// alignedBuffer returns a buffer of the given size whose address is aligned
+// for direct I/O.
+func alignedBuffer(size int) []byte {
+	mem := make([]byte, size+directIOAlignment)
+	off := 0
+	if rem := int(uintptr(unsafe.Pointer(&mem[0])) % directIOAlignment); rem != 0 {
+		off = directIOAlignment - rem
+	}
+	return mem[off : off+size : off+size]
+}
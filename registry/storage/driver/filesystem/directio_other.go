@@ -0,0 +1,18 @@
+//go:build !linux
+
+package filesystem
+
+import (
+	"errors"
+	"os"
+)
+
+const directIOSupported = false
+
+func openDirect(path string) (*os.File, error) {
+	return nil, errors.New("direct I/O is not supported on this platform")
+}
+
+func alignedBuffer(size int) []byte {
+	return make([]byte, size)
+}
@@ -8,8 +8,10 @@ import (
 	"io"
 	"os"
 	"path"
+	"strconv"
 	"time"
 
+	dcontext "github.com/distribution/distribution/v3/context"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/registry/storage/driver/base"
 	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
@@ -24,6 +26,30 @@ const (
 	// parameter. If the driver's parameters are less than this we set
 	// the parameters to minThreads
 	minThreads = uint64(25)
+
+	// defaultBufferSize is the default size of the buffer of the writers,
+	// which is the default size of a bufio.Writer.
+	defaultBufferSize = uint64(4096)
+
+	// minBufferSize is the minimum value for the buffersize configuration
+	// parameter, which is the alignment of direct I/O.
+	minBufferSize = uint64(directIOAlignment)
+)
+
+// The fsync policies of the writers.
+const (
+	// FsyncAlways syncs the content of the writers to the disk when they
+	// are closed or committed.
+	FsyncAlways = "always"
+
+	// FsyncCommit syncs the content of the writers to the disk only when
+	// they are committed. The content of uploads in progress may be lost
+	// on a crash, after which the uploads are restarted.
+	FsyncCommit = "commit"
+
+	// FsyncNever leaves syncing the content of the writers to the disk to
+	// the operating system.
+	FsyncNever = "never"
 )
 
 // DriverParameters represents all configuration options available for the
@@ -31,6 +57,14 @@ const (
 type DriverParameters struct {
 	RootDirectory string
 	MaxThreads    uint64
+
+	// Fsync is the fsync policy of the writers.
+	Fsync string
+	// DirectIO makes the writers write their content with direct I/O,
+	// bypassing the page cache.
+	DirectIO bool
+	// BufferSize is the size of the buffer of the writers.
+	BufferSize int
 }
 
 func init() {
@@ -46,6 +80,9 @@ func (factory *filesystemDriverFactory) Create(parameters map[string]interface{}
 
 type driver struct {
 	rootDirectory string
+	fsync         string
+	directIO      bool
+	bufferSize    int
 }
 
 type baseEmbed struct {
@@ -62,6 +99,9 @@ type Driver struct {
 // Optional Parameters:
 // - rootdirectory
 // - maxthreads
+// - fsync
+// - directio
+// - buffersize
 func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 	params, err := fromParametersImpl(parameters)
 	if err != nil || params == nil {
@@ -75,6 +115,9 @@ func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, e
 		err           error
 		maxThreads    = defaultMaxThreads
 		rootDirectory = defaultRootDirectory
+		fsync         = FsyncAlways
+		directIO      = false
+		bufferSize    = defaultBufferSize
 	)
 
 	if parameters != nil {
@@ -86,18 +129,66 @@ func fromParametersImpl(parameters map[string]interface{}) (*DriverParameters, e
 		if err != nil {
 			return nil, fmt.Errorf("maxthreads config error: %s", err.Error())
 		}
+
+		if policy, ok := parameters["fsync"]; ok {
+			fsync = fmt.Sprint(policy)
+			switch fsync {
+			case FsyncAlways, FsyncCommit, FsyncNever:
+			default:
+				return nil, fmt.Errorf("fsync must be one of %q, %q or %q, %q invalid", FsyncAlways, FsyncCommit, FsyncNever, fsync)
+			}
+		}
+
+		switch v := parameters["directio"].(type) {
+		case string:
+			directIO, err = strconv.ParseBool(v)
+			if err != nil {
+				return nil, fmt.Errorf("the directio parameter should be a boolean")
+			}
+		case bool:
+			directIO = v
+		case nil:
+			// do nothing
+		default:
+			return nil, fmt.Errorf("the directio parameter should be a boolean")
+		}
+		if directIO && !directIOSupported {
+			return nil, fmt.Errorf("directio is not supported on this platform")
+		}
+
+		bufferSize, err = base.GetLimitFromParameter(parameters["buffersize"], minBufferSize, defaultBufferSize)
+		if err != nil {
+			return nil, fmt.Errorf("buffersize config error: %s", err.Error())
+		}
+		if directIO && bufferSize%directIOAlignment != 0 {
+			return nil, fmt.Errorf("buffersize must be a multiple of %d with directio, %d invalid", directIOAlignment, bufferSize)
+		}
 	}
 
 	params := &DriverParameters{
 		RootDirectory: rootDirectory,
 		MaxThreads:    maxThreads,
+		Fsync:         fsync,
+		DirectIO:      directIO,
+		BufferSize:    int(bufferSize),
 	}
 	return params, nil
 }
 
 // New constructs a new Driver with a given rootDirectory
 func New(params DriverParameters) *Driver {
-	fsDriver := &driver{rootDirectory: params.RootDirectory}
+	fsDriver := &driver{
+		rootDirectory: params.RootDirectory,
+		fsync:         params.Fsync,
+		directIO:      params.DirectIO,
+		bufferSize:    params.BufferSize,
+	}
+	if fsDriver.fsync == "" {
+		fsDriver.fsync = FsyncAlways
+	}
+	if fsDriver.bufferSize <= 0 {
+		fsDriver.bufferSize = int(defaultBufferSize)
+	}
 
 	return &Driver{
 		baseEmbed: baseEmbed{
@@ -198,7 +289,20 @@ func (d *driver) Writer(ctx context.Context, subPath string, append bool) (stora
 		offset = n
 	}
 
-	return newFileWriter(fp, offset), nil
+	fw := newFileWriter(fp, offset, d.fsync)
+	if d.directIO {
+		direct, err := openDirect(fullPath)
+		if err != nil {
+			// Not all filesystems support direct I/O
+			dcontext.GetLogger(ctx).Debugf("direct I/O unavailable for %s: %v", subPath, err)
+		} else {
+			fw.direct = direct
+			fw.bw = newDirectWriter(fp, direct, offset, d.bufferSize)
+			return fw, nil
+		}
+	}
+	fw.bw = bufio.NewWriterSize(fp, d.bufferSize)
+	return fw, nil
 }
 
 // Stat retrieves the FileInfo for the given path, including the current size
@@ -333,20 +437,29 @@ func (fi fileInfo) IsDir() bool {
 	return fi.FileInfo.IsDir()
 }
 
+// bufferedWriter is a writer buffering the content written to a file.
+type bufferedWriter interface {
+	io.Writer
+	Flush() error
+}
+
 type fileWriter struct {
-	file      *os.File
+	file *os.File
+	// direct is the file opened for direct I/O, if any
+	direct    *os.File
 	size      int64
-	bw        *bufio.Writer
+	bw        bufferedWriter
+	fsync     string
 	closed    bool
 	committed bool
 	cancelled bool
 }
 
-func newFileWriter(file *os.File, size int64) *fileWriter {
+func newFileWriter(file *os.File, size int64, fsync string) *fileWriter {
 	return &fileWriter{
-		file: file,
-		size: size,
-		bw:   bufio.NewWriter(file),
+		file:  file,
+		size:  size,
+		fsync: fsync,
 	}
 }
 
@@ -376,10 +489,15 @@ func (fw *fileWriter) Close() error {
 		return err
 	}
 
-	if err := fw.file.Sync(); err != nil {
-		return err
+	if fw.fsync == FsyncAlways {
+		if err := fw.file.Sync(); err != nil {
+			return err
+		}
 	}
 
+	if fw.direct != nil {
+		fw.direct.Close()
+	}
 	if err := fw.file.Close(); err != nil {
 		return err
 	}
@@ -393,6 +511,9 @@ func (fw *fileWriter) Cancel(ctx context.Context) error {
 	}
 
 	fw.cancelled = true
+	if fw.direct != nil {
+		fw.direct.Close()
+	}
 	fw.file.Close()
 	return os.Remove(fw.file.Name())
 }
@@ -410,8 +531,10 @@ func (fw *fileWriter) Commit() error {
 		return err
 	}
 
-	if err := fw.file.Sync(); err != nil {
-		return err
+	if fw.fsync != FsyncNever {
+		if err := fw.file.Sync(); err != nil {
+			return err
+		}
 	}
 
 	fw.committed = true
@@ -1,6 +1,9 @@
 package filesystem
 
 import (
+	"bytes"
+	"context"
+	"crypto/rand"
 	"os"
 	"reflect"
 	"testing"
@@ -44,6 +47,8 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncAlways,
+				BufferSize:    int(defaultBufferSize),
 			},
 			pass: true,
 		},
@@ -62,6 +67,8 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    uint64(100),
+				Fsync:         FsyncAlways,
+				BufferSize:    int(defaultBufferSize),
 			},
 			pass: true,
 		},
@@ -72,6 +79,8 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    uint64(100),
+				Fsync:         FsyncAlways,
+				BufferSize:    int(defaultBufferSize),
 			},
 			pass: true,
 		},
@@ -83,9 +92,62 @@ func TestFromParametersImpl(t *testing.T) {
 			expected: DriverParameters{
 				RootDirectory: defaultRootDirectory,
 				MaxThreads:    minThreads,
+				Fsync:         FsyncAlways,
+				BufferSize:    int(defaultBufferSize),
 			},
 			pass: true,
 		},
+		{
+			params: map[string]interface{}{
+				"fsync":      "commit",
+				"directio":   "true",
+				"buffersize": 1 << 20,
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncCommit,
+				DirectIO:      true,
+				BufferSize:    1 << 20,
+			},
+			pass: true,
+		},
+		// check that we use the minimum buffer size
+		{
+			params: map[string]interface{}{
+				"buffersize": 1,
+			},
+			expected: DriverParameters{
+				RootDirectory: defaultRootDirectory,
+				MaxThreads:    defaultMaxThreads,
+				Fsync:         FsyncAlways,
+				BufferSize:    int(minBufferSize),
+			},
+			pass: true,
+		},
+		{
+			params: map[string]interface{}{
+				"fsync": "sometimes",
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		{
+			params: map[string]interface{}{
+				"directio": "maybe",
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
+		// direct I/O needs aligned buffers
+		{
+			params: map[string]interface{}{
+				"directio":   true,
+				"buffersize": 5000,
+			},
+			expected: DriverParameters{},
+			pass:     false,
+		},
 	}
 
 	for _, item := range tests {
@@ -108,3 +170,59 @@ func TestFromParametersImpl(t *testing.T) {
 		}
 	}
 }
+
+func TestDirectIOWriter(t *testing.T) {
+	if !directIOSupported {
+		t.Skip("direct I/O is not supported on this platform")
+	}
+
+	root := t.TempDir()
+	d, err := FromParameters(map[string]interface{}{
+		"rootdirectory": root,
+		"fsync":         FsyncCommit,
+		"directio":      true,
+		"buffersize":    2 * directIOAlignment,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	content := make([]byte, 5*directIOAlignment+100)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write the content over several writers, the second one starting at
+	// an unaligned offset
+	var written int
+	for i, size := range []int{directIOAlignment + 10, 3 * directIOAlignment, directIOAlignment + 90} {
+		w, err := d.Writer(ctx, "/upload", i > 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Size() != int64(written) {
+			t.Fatalf("expected writer size %d, got %d", written, w.Size())
+		}
+		if _, err := w.Write(content[written : written+size]); err != nil {
+			t.Fatal(err)
+		}
+		written += size
+		if written == len(content) {
+			if err := w.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stored, err := d.GetContent(ctx, "/upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(stored, content) {
+		t.Error("unexpected content written with direct I/O")
+	}
+}
@@ -61,6 +61,9 @@ const (
 
 var rangeHeader = regexp.MustCompile(`^bytes=([0-9])+-([0-9]+)$`)
 
+// kmsKeyNamePattern matches the resource names of Cloud KMS keys.
+var kmsKeyNamePattern = regexp.MustCompile(`^projects/[^/]+/locations/[^/]+/keyRings/[^/]+/cryptoKeys/[^/]+$`)
+
 var _ storagedriver.FileWriter = &writer{}
 
 // driverParameters is a struct that encapsulates all of the driver parameters after all values have been set
@@ -74,6 +77,14 @@ type driverParameters struct {
 	chunkSize     int
 	gcs           *storage.Client
 
+	// kmsKeyName is the Cloud KMS key the objects written by the driver
+	// are encrypted with, instead of the default key of the bucket.
+	kmsKeyName string
+
+	// userProject is the project billed for the requests to a
+	// requester-pays bucket.
+	userProject string
+
 	// maxConcurrency limits the number of concurrent driver operations
 	// to GCS, which ultimately increases reliability of many simultaneous
 	// pushes by ensuring we aren't DoSing our own server with many
@@ -103,6 +114,8 @@ type driver struct {
 	rootDirectory string
 	chunkSize     int
 	gcs           *storage.Client
+	kmsKeyName    string
+	userProject   string
 }
 
 // Wrapper wraps `driver` with a throttler, ensuring that no more than N
@@ -210,6 +223,16 @@ func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDri
 		}
 	}
 
+	kmsKeyName, ok := parameters["kmskeyname"]
+	if !ok || kmsKeyName == nil {
+		kmsKeyName = ""
+	}
+
+	userProject, ok := parameters["userproject"]
+	if !ok || userProject == nil {
+		userProject = ""
+	}
+
 	maxConcurrency, err := base.GetLimitFromParameter(parameters["maxconcurrency"], minConcurrency, defaultMaxConcurrency)
 	if err != nil {
 		return nil, fmt.Errorf("maxconcurrency config error: %s", err)
@@ -227,6 +250,8 @@ func FromParameters(parameters map[string]interface{}) (storagedriver.StorageDri
 		chunkSize:      chunkSize,
 		maxConcurrency: maxConcurrency,
 		gcs:            gcs,
+		kmsKeyName:     fmt.Sprint(kmsKeyName),
+		userProject:    fmt.Sprint(userProject),
 	}
 
 	return New(params)
@@ -241,6 +266,12 @@ func New(params driverParameters) (storagedriver.StorageDriver, error) {
 	if params.chunkSize <= 0 || params.chunkSize%minChunkSize != 0 {
 		return nil, fmt.Errorf("Invalid chunksize: %d is not a positive multiple of %d", params.chunkSize, minChunkSize)
 	}
+	if params.kmsKeyName != "" && !kmsKeyNamePattern.MatchString(params.kmsKeyName) {
+		return nil, fmt.Errorf("Invalid kmskeyname: %q is not of the form projects/<project>/locations/<location>/keyRings/<keyring>/cryptoKeys/<key>", params.kmsKeyName)
+	}
+	if strings.ContainsAny(params.userProject, "/ ") {
+		return nil, fmt.Errorf("Invalid userproject: %q is not a project ID", params.userProject)
+	}
 	d := &driver{
 		bucket:        params.bucket,
 		rootDirectory: rootDirectory,
@@ -249,6 +280,8 @@ func New(params driverParameters) (storagedriver.StorageDriver, error) {
 		client:        params.client,
 		chunkSize:     params.chunkSize,
 		gcs:           params.gcs,
+		kmsKeyName:    params.kmsKeyName,
+		userProject:   params.userProject,
 	}
 
 	return &Wrapper{
@@ -270,7 +303,7 @@ func (d *driver) Name() string {
 // This should primarily be used for small objects.
 func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 	name := d.pathToKey(path)
-	rc, err := d.gcs.Bucket(d.bucket).Object(name).NewReader(ctx)
+	rc, err := d.bucketHandle().Object(name).NewReader(ctx)
 	if err == storage.ErrObjectNotExist {
 		return nil, storagedriver.PathNotFoundError{Path: path}
 	}
@@ -289,8 +322,9 @@ func (d *driver) GetContent(ctx context.Context, path string) ([]byte, error) {
 // PutContent stores the []byte content at a location designated by "path".
 // This should primarily be used for small objects.
 func (d *driver) PutContent(ctx context.Context, path string, contents []byte) error {
-	wc := d.gcs.Bucket(d.bucket).Object(d.pathToKey(path)).NewWriter(ctx)
+	wc := d.bucketHandle().Object(d.pathToKey(path)).NewWriter(ctx)
 	wc.ContentType = "application/octet-stream"
+	wc.KMSKeyName = d.kmsKeyName
 	return putContentsClose(wc, contents)
 }
 
@@ -298,7 +332,7 @@ func (d *driver) PutContent(ctx context.Context, path string, contents []byte) e
 // with a given byte offset.
 // May be used to resume reading a stream by providing a nonzero offset.
 func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
-	res, err := getObject(d.client, d.bucket, d.pathToKey(path), offset)
+	res, err := getObject(d.client, d.bucket, d.pathToKey(path), offset, d.userProject)
 	if err != nil {
 		if res != nil {
 			if res.StatusCode == http.StatusNotFound {
@@ -327,7 +361,7 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 	return res.Body, nil
 }
 
-func getObject(client *http.Client, bucket string, name string, offset int64) (*http.Response, error) {
+func getObject(client *http.Client, bucket string, name string, offset int64, userProject string) (*http.Response, error) {
 	// copied from cloud.google.com/go/storage#NewReader :
 	// to set the additional "Range" header
 	u := &url.URL{
@@ -335,6 +369,9 @@ func getObject(client *http.Client, bucket string, name string, offset int64) (*
 		Host:   "storage.googleapis.com",
 		Path:   fmt.Sprintf("/%s/%s", bucket, name),
 	}
+	if userProject != "" {
+		u.RawQuery = url.Values{"userProject": {userProject}}.Encode()
+	}
 	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
@@ -363,6 +400,9 @@ func (d *driver) Writer(ctx context.Context, path string, append bool) (storaged
 		name:   d.pathToKey(path),
 		buffer: make([]byte, d.chunkSize),
 		gcs:    d.gcs,
+
+		kmsKeyName:  d.kmsKeyName,
+		userProject: d.userProject,
 	}
 
 	if append {
@@ -385,12 +425,15 @@ type writer struct {
 	buffer     []byte
 	buffSize   int
 	gcs        *storage.Client
+
+	kmsKeyName  string
+	userProject string
 }
 
 // Cancel removes any written content from this FileWriter.
 func (w *writer) Cancel(ctx context.Context) error {
 	w.closed = true
-	err := storageDeleteObject(ctx, w.bucket, w.name, w.gcs)
+	err := storageDeleteObject(ctx, w.bucketHandle(), w.name)
 	if err != nil {
 		if err == storage.ErrObjectNotExist {
 			err = nil
@@ -421,8 +464,9 @@ func (w *writer) Close() error {
 	// commit the writes by updating the upload session
 	ctx := context.TODO()
 	err = retry(func() error {
-		wc := w.gcs.Bucket(w.bucket).Object(w.name).NewWriter(ctx)
+		wc := w.bucketHandle().Object(w.name).NewWriter(ctx)
 		wc.ContentType = uploadSessionContentType
+		wc.KMSKeyName = w.kmsKeyName
 		wc.Metadata = map[string]string{
 			"Session-URI": w.sessionURI,
 			"Offset":      strconv.FormatInt(w.offset, 10),
@@ -469,8 +513,9 @@ func (w *writer) Commit() error {
 	// no session started yet just perform a simple upload
 	if w.sessionURI == "" {
 		err := retry(func() error {
-			wc := w.gcs.Bucket(w.bucket).Object(w.name).NewWriter(ctx)
+			wc := w.bucketHandle().Object(w.name).NewWriter(ctx)
 			wc.ContentType = "application/octet-stream"
+			wc.KMSKeyName = w.kmsKeyName
 			return putContentsClose(wc, w.buffer[0:w.buffSize])
 		})
 		if err != nil {
@@ -501,6 +546,12 @@ func (w *writer) Commit() error {
 	return nil
 }
 
+// bucketHandle returns the handle of the bucket the writer writes to,
+// billing the requests to the user project when one is configured.
+func (w *writer) bucketHandle() *storage.BucketHandle {
+	return userProjectBucket(w.gcs, w.bucket, w.userProject)
+}
+
 func (w *writer) checkClosed() error {
 	if w.closed {
 		return fmt.Errorf("Writer already closed")
@@ -518,7 +569,7 @@ func (w *writer) writeChunk() error {
 	}
 	// if their is no sessionURI yet, obtain one by starting the session
 	if w.sessionURI == "" {
-		w.sessionURI, err = startSession(w.client, w.bucket, w.name)
+		w.sessionURI, err = startSession(w.client, w.bucket, w.name, w.kmsKeyName, w.userProject)
 	}
 	if err != nil {
 		return err
@@ -562,7 +613,7 @@ func (w *writer) Size() int64 {
 }
 
 func (w *writer) init(path string) error {
-	res, err := getObject(w.client, w.bucket, w.name, 0)
+	res, err := getObject(w.client, w.bucket, w.name, 0, w.userProject)
 	if err != nil {
 		return err
 	}
@@ -634,7 +685,7 @@ func (d *driver) Stat(ctx context.Context, path string) (storagedriver.FileInfo,
 	query = &storage.Query{}
 	query.Prefix = dirpath
 
-	objects, err := storageListObjects(ctx, d.bucket, query, d.gcs)
+	objects, err := storageListObjects(ctx, d.bucketHandle(), query)
 	if err != nil {
 		return nil, err
 	}
@@ -661,7 +712,7 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 	query.Delimiter = "/"
 	query.Prefix = d.pathToDirKey(path)
 	list := make([]string, 0, 64)
-	objects, err := storageListObjects(ctx, d.bucket, query, d.gcs)
+	objects, err := storageListObjects(ctx, d.bucketHandle(), query)
 	if err != nil {
 		return nil, err
 	}
@@ -690,7 +741,7 @@ func (d *driver) List(ctx context.Context, path string) ([]string, error) {
 // Move moves an object stored at sourcePath to destPath, removing the
 // original object.
 func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) error {
-	_, err := storageCopyObject(ctx, d.bucket, d.pathToKey(sourcePath), d.bucket, d.pathToKey(destPath), nil, d.gcs)
+	_, err := storageCopyObject(ctx, d.bucketHandle(), d.pathToKey(sourcePath), d.pathToKey(destPath), d.kmsKeyName)
 	if err != nil {
 		if status, ok := err.(*googleapi.Error); ok {
 			if status.Code == http.StatusNotFound {
@@ -699,7 +750,7 @@ func (d *driver) Move(ctx context.Context, sourcePath string, destPath string) e
 		}
 		return err
 	}
-	err = storageDeleteObject(ctx, d.bucket, d.pathToKey(sourcePath), d.gcs)
+	err = storageDeleteObject(ctx, d.bucketHandle(), d.pathToKey(sourcePath))
 	// if deleting the file fails, log the error, but do not fail; the file was successfully copied,
 	// and the original should eventually be cleaned when purging the uploads folder.
 	if err != nil {
@@ -714,7 +765,7 @@ func (d *driver) listAll(ctx context.Context, prefix string) ([]string, error) {
 	query := &storage.Query{}
 	query.Prefix = prefix
 	query.Versions = false
-	objects, err := storageListObjects(ctx, d.bucket, query, d.gcs)
+	objects, err := storageListObjects(ctx, d.bucketHandle(), query)
 	if err != nil {
 		return nil, err
 	}
@@ -739,7 +790,7 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 	if len(keys) > 0 {
 		sort.Sort(sort.Reverse(sort.StringSlice(keys)))
 		for _, key := range keys {
-			err := storageDeleteObject(ctx, d.bucket, key, d.gcs)
+			err := storageDeleteObject(ctx, d.bucketHandle(), key)
 			// GCS only guarantees eventual consistency, so listAll might return
 			// paths that no longer exist. If this happens, just ignore any not
 			// found error
@@ -754,19 +805,33 @@ func (d *driver) Delete(ctx context.Context, path string) error {
 		}
 		return nil
 	}
-	err = storageDeleteObject(ctx, d.bucket, d.pathToKey(path), d.gcs)
+	err = storageDeleteObject(ctx, d.bucketHandle(), d.pathToKey(path))
 	if err == storage.ErrObjectNotExist {
 		return storagedriver.PathNotFoundError{Path: path}
 	}
 	return err
 }
 
-func storageDeleteObject(ctx context.Context, bucket string, name string, gcs *storage.Client) error {
-	return gcs.Bucket(bucket).Object(name).Delete(ctx)
+// bucketHandle returns the handle of the bucket of the driver, billing the
+// requests to the user project when one is configured.
+func (d *driver) bucketHandle() *storage.BucketHandle {
+	return userProjectBucket(d.gcs, d.bucket, d.userProject)
+}
+
+func userProjectBucket(gcs *storage.Client, bucket string, userProject string) *storage.BucketHandle {
+	bkt := gcs.Bucket(bucket)
+	if userProject != "" {
+		bkt = bkt.UserProject(userProject)
+	}
+	return bkt
+}
+
+func storageDeleteObject(ctx context.Context, bkt *storage.BucketHandle, name string) error {
+	return bkt.Object(name).Delete(ctx)
 }
 
 func (d *driver) storageStatObject(ctx context.Context, name string) (*storage.ObjectAttrs, error) {
-	bkt := d.gcs.Bucket(d.bucket)
+	bkt := d.bucketHandle()
 	var obj *storage.ObjectAttrs
 	err := retry(func() error {
 		var err error
@@ -776,8 +841,7 @@ func (d *driver) storageStatObject(ctx context.Context, name string) (*storage.O
 	return obj, err
 }
 
-func storageListObjects(ctx context.Context, bucket string, q *storage.Query, gcs *storage.Client) ([]*storage.ObjectAttrs, error) {
-	bkt := gcs.Bucket(bucket)
+func storageListObjects(ctx context.Context, bkt *storage.BucketHandle, q *storage.Query) ([]*storage.ObjectAttrs, error) {
 	var objs []*storage.ObjectAttrs
 	it := bkt.Objects(ctx, q)
 	for {
@@ -794,10 +858,10 @@ func storageListObjects(ctx context.Context, bucket string, q *storage.Query, gc
 	return objs, nil
 }
 
-func storageCopyObject(ctx context.Context, srcBucket, srcName string, destBucket, destName string, attrs *storage.ObjectAttrs, gcs *storage.Client) (*storage.ObjectAttrs, error) {
-	src := gcs.Bucket(srcBucket).Object(srcName)
-	dst := gcs.Bucket(destBucket).Object(destName)
-	attrs, err := dst.CopierFrom(src).Run(ctx)
+func storageCopyObject(ctx context.Context, bkt *storage.BucketHandle, srcName string, destName string, kmsKeyName string) (*storage.ObjectAttrs, error) {
+	copier := bkt.Object(destName).CopierFrom(bkt.Object(srcName))
+	copier.DestinationKMSKeyName = kmsKeyName
+	attrs, err := copier.Run(ctx)
 	if err != nil {
 		var status *googleapi.Error
 		if errors.As(err, &status) {
@@ -852,12 +916,19 @@ func (d *driver) Walk(ctx context.Context, path string, f storagedriver.WalkFn)
 	return storagedriver.WalkFallback(ctx, d, path, f)
 }
 
-func startSession(client *http.Client, bucket string, name string) (uri string, err error) {
+func startSession(client *http.Client, bucket string, name string, kmsKeyName string, userProject string) (uri string, err error) {
+	query := url.Values{"uploadType": {"resumable"}, "name": {name}}
+	if kmsKeyName != "" {
+		query.Set("kmsKeyName", kmsKeyName)
+	}
+	if userProject != "" {
+		query.Set("userProject", userProject)
+	}
 	u := &url.URL{
 		Scheme:   "https",
 		Host:     "www.googleapis.com",
 		Path:     fmt.Sprintf("/upload/storage/v1/b/%v/o", bucket),
-		RawQuery: fmt.Sprintf("uploadType=resumable&name=%v", name),
+		RawQuery: query.Encode(),
 	}
 	err = retry(func() error {
 		req, err := http.NewRequest(http.MethodPost, u.String(), nil)
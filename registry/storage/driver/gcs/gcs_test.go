@@ -226,6 +226,34 @@ func TestRetry(t *testing.T) {
 	assertError("error", err)
 }
 
+func TestEncryptionAndBillingParameters(t *testing.T) {
+	for _, tc := range []struct {
+		kmsKeyName  string
+		userProject string
+		valid       bool
+	}{
+		{valid: true},
+		{kmsKeyName: "projects/p/locations/europe-west1/keyRings/registry/cryptoKeys/blobs", userProject: "billing-project", valid: true},
+		{kmsKeyName: "projects/p/locations/europe-west1/keyRings/registry"},
+		{kmsKeyName: "blobs"},
+		{userProject: "projects/billing-project"},
+	} {
+		_, err := New(driverParameters{
+			bucket:         "bucket",
+			chunkSize:      defaultChunkSize,
+			maxConcurrency: minConcurrency,
+			kmsKeyName:     tc.kmsKeyName,
+			userProject:    tc.userProject,
+		})
+		if tc.valid && err != nil {
+			t.Errorf("kmskeyname %q, userproject %q: unexpected error: %v", tc.kmsKeyName, tc.userProject, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("kmskeyname %q, userproject %q: expected an error", tc.kmsKeyName, tc.userProject)
+		}
+	}
+}
+
 func TestEmptyRootList(t *testing.T) {
 	if skipGCS() != "" {
 		t.Skip(skipGCS())
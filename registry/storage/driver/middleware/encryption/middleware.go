@@ -0,0 +1,406 @@
+// Package middleware - encryption wrapper encrypting the content stored by a
+// storage driver
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// partialSuffix is the suffix of the objects holding the encrypted end of the
+// content of a writer closed without being committed. They are hidden from
+// the listings of the driver.
+const partialSuffix = ".partial"
+
+// encryptionStorageMiddleware encrypts the content written to the storage
+// driver, and decrypts the content read from it. Every object is encrypted
+// with its own data key, which is stored in the header of the object wrapped
+// with the key of the operator.
+type encryptionStorageMiddleware struct {
+	storagedriver.StorageDriver
+	key         cipher.AEAD
+	fingerprint []byte
+}
+
+var _ storagedriver.StorageDriver = &encryptionStorageMiddleware{}
+
+// newEncryptionStorageMiddleware constructs a storage driver encrypting the
+// content stored by sd.
+//
+// Required options, one of:
+//
+//   - key: the base64 encoded 256-bit key
+//   - keyfile: a file holding the base64 encoded 256-bit key
+func newEncryptionStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	var encoded string
+	if k, ok := options["key"]; ok {
+		key, ok := k.(string)
+		if !ok {
+			return nil, fmt.Errorf("key must be a string")
+		}
+		encoded = key
+	} else if kf, ok := options["keyfile"]; ok {
+		keyfile, ok := kf.(string)
+		if !ok {
+			return nil, fmt.Errorf("keyfile must be a string")
+		}
+		content, err := os.ReadFile(keyfile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read keyfile: %s", err)
+		}
+		encoded = string(content)
+	} else {
+		return nil, fmt.Errorf("no key or keyfile provided")
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != keySize {
+		return nil, fmt.Errorf("key must be a base64 encoded %d-bit key", keySize*8)
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	fingerprint := sha256.Sum256(key)
+
+	return &encryptionStorageMiddleware{
+		StorageDriver: sd,
+		key:           aead,
+		fingerprint:   fingerprint[:fingerprintSize],
+	}, nil
+}
+
+func init() {
+	storagemiddleware.Register("encryption", newEncryptionStorageMiddleware)
+}
+
+// GetContent retrieves and decrypts the content stored at "path".
+func (d *encryptionStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	r, err := d.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	return io.ReadAll(r)
+}
+
+// PutContent encrypts and stores the content at "path".
+func (d *encryptionStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	encrypted, err := d.encrypt(content)
+	if err != nil {
+		return d.wrapError(err)
+	}
+	return d.StorageDriver.PutContent(ctx, path, encrypted)
+}
+
+// Reader returns a reader of the decrypted content stored at "path",
+// starting at offset in the decrypted content.
+func (d *encryptionStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if offset < 0 {
+		return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+	}
+
+	rc, err := d.StorageDriver.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := d.readHeader(rc)
+	if err != nil {
+		rc.Close()
+		return nil, d.wrapError(fmt.Errorf("%s: %w", path, err))
+	}
+
+	index := uint64(offset / chunkSize)
+	if index > 0 {
+		rc.Close()
+		rc, err = d.StorageDriver.Reader(ctx, path, int64(headerSize)+int64(index)*encryptedChunkSize)
+		if err != nil {
+			if _, ok := err.(storagedriver.InvalidOffsetError); ok {
+				return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+			}
+			return nil, err
+		}
+	}
+
+	return &decryptingReader{
+		rc:    rc,
+		aead:  aead,
+		index: index,
+		skip:  int(offset % chunkSize),
+		partial: func() ([]byte, bool, error) {
+			return d.readPartial(ctx, path)
+		},
+		wrapError: func(err error) error {
+			return d.wrapError(fmt.Errorf("%s: %w", path, err))
+		},
+	}, nil
+}
+
+// Writer returns a FileWriter which encrypts the content written to it
+// before storing it at "path".
+func (d *encryptionStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	if !append {
+		header, aead, err := d.newHeader()
+		if err != nil {
+			return nil, d.wrapError(err)
+		}
+		fw, err := d.StorageDriver.Writer(ctx, path, false)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := fw.Write(header); err != nil {
+			fw.Cancel(ctx)
+			return nil, err
+		}
+		return newEncryptingWriter(ctx, d, path, fw, aead), nil
+	}
+
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	chunks, rem, err := layout(fi.Size())
+	if err != nil {
+		return nil, d.wrapError(fmt.Errorf("%s: %w", path, err))
+	}
+	if rem != 0 {
+		return nil, d.wrapError(fmt.Errorf("%s: cannot append to committed content", path))
+	}
+	header, err := d.StorageDriver.Reader(ctx, path, 0)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := d.readHeader(header)
+	header.Close()
+	if err != nil {
+		return nil, d.wrapError(fmt.Errorf("%s: %w", path, err))
+	}
+	tail, partial, err := d.readPartial(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+
+	fw, err := d.StorageDriver.Writer(ctx, path, true)
+	if err != nil {
+		return nil, err
+	}
+	w := newEncryptingWriter(ctx, d, path, fw, aead)
+	w.sealer.index = uint64(chunks)
+	w.buf = w.buf[:len(tail)]
+	copy(w.buf, tail)
+	w.size = chunks*chunkSize + int64(len(tail))
+	w.partial = partial
+	return w, nil
+}
+
+// Stat retrieves the FileInfo for the given path, with the size of the
+// decrypted content.
+func (d *encryptionStorageMiddleware) Stat(ctx context.Context, path string) (storagedriver.FileInfo, error) {
+	fi, err := d.StorageDriver.Stat(ctx, path)
+	if err != nil || fi.IsDir() {
+		return fi, err
+	}
+
+	chunks, rem, err := layout(fi.Size())
+	if err != nil {
+		return nil, d.wrapError(fmt.Errorf("%s: %w", path, err))
+	}
+	size := chunks * chunkSize
+	if rem > 0 {
+		size += rem - chunkOverhead
+	} else {
+		// The content was not committed yet, and its end is in the
+		// partial object
+		pfi, err := d.StorageDriver.Stat(ctx, path+partialSuffix)
+		switch err.(type) {
+		case nil:
+			size += pfi.Size() - int64(headerSize) - chunkOverhead
+		case storagedriver.PathNotFoundError:
+		default:
+			return nil, err
+		}
+	}
+
+	return storagedriver.FileInfoInternal{FileInfoFields: storagedriver.FileInfoFields{
+		Path:    fi.Path(),
+		Size:    size,
+		ModTime: fi.ModTime(),
+		IsDir:   false,
+	}}, nil
+}
+
+// List returns the objects that are direct descendants of the given path,
+// without the partial objects of unfinished writers.
+func (d *encryptionStorageMiddleware) List(ctx context.Context, path string) ([]string, error) {
+	children, err := d.StorageDriver.List(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	list := children[:0]
+	for _, child := range children {
+		if !strings.HasSuffix(child, partialSuffix) {
+			list = append(list, child)
+		}
+	}
+	return list, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, along with the
+// partial object of an unfinished writer.
+func (d *encryptionStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := d.StorageDriver.Move(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	return ignoreNotFound(d.StorageDriver.Move(ctx, sourcePath+partialSuffix, destPath+partialSuffix))
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// along with the partial object of an unfinished writer.
+func (d *encryptionStorageMiddleware) Delete(ctx context.Context, path string) error {
+	if err := d.StorageDriver.Delete(ctx, path); err != nil {
+		return err
+	}
+	return ignoreNotFound(d.StorageDriver.Delete(ctx, path+partialSuffix))
+}
+
+// URLFor is not supported, as the content behind the URLs of the storage
+// driver is encrypted. The registry serves the decrypted content itself.
+func (d *encryptionStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	return "", storagedriver.ErrUnsupportedMethod{DriverName: d.Name()}
+}
+
+// Walk traverses the objects at the given path, calling f on each file with
+// the size of the decrypted content.
+func (d *encryptionStorageMiddleware) Walk(ctx context.Context, path string, f storagedriver.WalkFn) error {
+	return storagedriver.WalkFallback(ctx, d, path, f)
+}
+
+// encrypt encrypts content as a complete object.
+func (d *encryptionStorageMiddleware) encrypt(content []byte) ([]byte, error) {
+	header, aead, err := d.newHeader()
+	if err != nil {
+		return nil, err
+	}
+
+	encrypted := make([]byte, 0, headerSize+(len(content)/chunkSize+1)*encryptedChunkSize)
+	encrypted = append(encrypted, header...)
+	sealer := chunkSealer{aead: aead}
+	for len(content) >= chunkSize {
+		encrypted = sealer.seal(encrypted, content[:chunkSize], false)
+		content = content[chunkSize:]
+	}
+	return sealer.seal(encrypted, content, true), nil
+}
+
+// decrypt decrypts a complete object.
+func (d *encryptionStorageMiddleware) decrypt(encrypted []byte) ([]byte, error) {
+	if len(encrypted) < headerSize {
+		return nil, errCorrupted
+	}
+	aead, err := d.openHeader(encrypted[:headerSize])
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(&decryptingReader{
+		rc:        io.NopCloser(bytes.NewReader(encrypted[headerSize:])),
+		aead:      aead,
+		wrapError: func(err error) error { return err },
+	})
+}
+
+// newHeader generates the data key of an object, and returns the header of
+// the object holding the wrapped data key.
+func (d *encryptionStorageMiddleware) newHeader() ([]byte, cipher.AEAD, error) {
+	dataKey := make([]byte, keySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, nil, err
+	}
+	aead, err := newAEAD(dataKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	header := make([]byte, 0, headerSize)
+	header = append(header, magic...)
+	header = append(header, d.fingerprint...)
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, err
+	}
+	header = append(header, nonce...)
+	header = d.key.Seal(header, nonce, dataKey, header[:len(magic)+fingerprintSize])
+	return header, aead, nil
+}
+
+// openHeader unwraps the data key held by the header of an object.
+func (d *encryptionStorageMiddleware) openHeader(header []byte) (cipher.AEAD, error) {
+	if !bytes.HasPrefix(header, []byte(magic)) {
+		return nil, errCorrupted
+	}
+	prefix := len(magic) + fingerprintSize
+	if !bytes.Equal(header[len(magic):prefix], d.fingerprint) {
+		return nil, errUnknownKey
+	}
+	dataKey, err := d.key.Open(nil, header[prefix:prefix+nonceSize], header[prefix+nonceSize:], header[:prefix])
+	if err != nil {
+		return nil, errCorrupted
+	}
+	return newAEAD(dataKey)
+}
+
+func (d *encryptionStorageMiddleware) readHeader(r io.Reader) (cipher.AEAD, error) {
+	header := make([]byte, headerSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, errCorrupted
+	}
+	return d.openHeader(header)
+}
+
+// readPartial returns the decrypted content of the partial object of the
+// unfinished writer of path, and whether it exists.
+func (d *encryptionStorageMiddleware) readPartial(ctx context.Context, path string) ([]byte, bool, error) {
+	encrypted, err := d.StorageDriver.GetContent(ctx, path+partialSuffix)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+	content, err := d.decrypt(encrypted)
+	if err != nil {
+		return nil, false, d.wrapError(fmt.Errorf("%s: %w", path+partialSuffix, err))
+	}
+	return content, true, nil
+}
+
+func (d *encryptionStorageMiddleware) wrapError(err error) error {
+	return storagedriver.Error{DriverName: d.Name(), Enclosed: err}
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func ignoreNotFound(err error) error {
+	if _, ok := err.(storagedriver.PathNotFoundError); ok {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,173 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+	"gopkg.in/check.v1"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { check.TestingT(t) }
+
+func init() {
+	testsuites.RegisterSuite(func() (storagedriver.StorageDriver, error) {
+		return newEncryptionStorageMiddleware(inmemory.New(), map[string]interface{}{"key": newKey()})
+	}, testsuites.NeverSkip)
+}
+
+func newKey() string {
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+func TestOptions(t *testing.T) {
+	keyfile := filepath.Join(t.TempDir(), "key")
+	if err := os.WriteFile(keyfile, []byte(newKey()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := newEncryptionStorageMiddleware(inmemory.New(), map[string]interface{}{"keyfile": keyfile}); err != nil {
+		t.Errorf("unexpected error reading the key from a file: %v", err)
+	}
+
+	for _, options := range []map[string]interface{}{
+		{},
+		{"key": base64.StdEncoding.EncodeToString([]byte("short"))},
+		{"key": "not base64"},
+		{"key": 42},
+		{"keyfile": filepath.Join(t.TempDir(), "missing")},
+	} {
+		if _, err := newEncryptionStorageMiddleware(inmemory.New(), options); err == nil {
+			t.Errorf("expected an error with options %v", options)
+		}
+	}
+}
+
+func TestEncryptedAtRest(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	key := newKey()
+	d, err := newEncryptionStorageMiddleware(backend, map[string]interface{}{"key": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := bytes.Repeat([]byte("layer"), chunkSize/2)
+	if err := d.PutContent(ctx, "/blob", content); err != nil {
+		t.Fatal(err)
+	}
+	stored, err := backend.GetContent(ctx, "/blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(stored, []byte("layerlayer")) {
+		t.Fatal("expected the stored content to be encrypted")
+	}
+
+	// Another key cannot decrypt the content
+	other, err := newEncryptionStorageMiddleware(backend, map[string]interface{}{"key": newKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.GetContent(ctx, "/blob"); err == nil {
+		t.Error("expected an error decrypting with another key")
+	}
+
+	// Tampering with the content is detected
+	stored[len(stored)-1] ^= 1
+	if err := backend.PutContent(ctx, "/blob", stored); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetContent(ctx, "/blob"); err == nil {
+		t.Error("expected an error decrypting tampered content")
+	}
+
+	// Truncating the content at a chunk boundary is detected
+	if err := backend.PutContent(ctx, "/blob", stored[:headerSize+encryptedChunkSize]); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := d.GetContent(ctx, "/blob"); err == nil {
+		t.Error("expected an error decrypting truncated content")
+	}
+}
+
+func TestUnfinishedWriter(t *testing.T) {
+	ctx := context.Background()
+	d, err := newEncryptionStorageMiddleware(inmemory.New(), map[string]interface{}{"key": newKey()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content := make([]byte, 2*chunkSize+100)
+	if _, err := rand.Read(content); err != nil {
+		t.Fatal(err)
+	}
+
+	// Write the content over several writers, closing each one with part
+	// of a chunk buffered
+	var written int
+	for i, size := range []int{chunkSize + 10, 50, chunkSize + 40} {
+		w, err := d.Writer(ctx, "/uploads/data", i > 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w.Size() != int64(written) {
+			t.Fatalf("expected writer size %d, got %d", written, w.Size())
+		}
+		if _, err := w.Write(content[written : written+size]); err != nil {
+			t.Fatal(err)
+		}
+		written += size
+		if written == len(content) {
+			if err := w.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		fi, err := d.Stat(ctx, "/uploads/data")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fi.Size() != int64(written) {
+			t.Errorf("expected size %d, got %d", written, fi.Size())
+		}
+		r, err := d.Reader(ctx, "/uploads/data", chunkSize)
+		if err != nil {
+			t.Fatal(err)
+		}
+		read, err := io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(read, content[chunkSize:written]) {
+			t.Errorf("unexpected content read from offset %d", chunkSize)
+		}
+	}
+
+	children, err := d.List(ctx, "/uploads")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(children) != 1 || children[0] != "/uploads/data" {
+		t.Errorf("unexpected children: %v", children)
+	}
+	if _, err := d.(*encryptionStorageMiddleware).StorageDriver.Stat(ctx, "/uploads/data"+partialSuffix); err == nil {
+		t.Error("expected the partial object to be deleted on commit")
+	}
+}
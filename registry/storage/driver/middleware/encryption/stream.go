@@ -0,0 +1,317 @@
+package middleware
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// An encrypted object is made of a header holding the data key of the object,
+// followed by the content encrypted in chunks with AES-GCM:
+//
+//	header: magic | key fingerprint | nonce | wrapped data key + tag
+//	chunk:  nonce | encrypted content + tag
+//
+// Every chunk of an object holds chunkSize bytes of content, except for the
+// last chunk of a committed object, which holds less, possibly none. This
+// makes the size of the content computable from the size of the object,
+// and the position of any offset of the content in the object known. The
+// index of a chunk, and whether it is the last one, are authenticated with
+// the chunk, so that chunks cannot be reordered and objects cannot be
+// truncated.
+const (
+	keySize         = 32
+	nonceSize       = 12
+	tagSize         = 16
+	fingerprintSize = 8
+
+	// chunkSize is the size of the content of the chunks.
+	chunkSize          = 64 << 10
+	chunkOverhead      = nonceSize + tagSize
+	encryptedChunkSize = chunkSize + chunkOverhead
+)
+
+// magic identifies encrypted objects and the version of their format.
+const magic = "ENC\x00\x01"
+
+const headerSize = len(magic) + fingerprintSize + nonceSize + keySize + tagSize
+
+var (
+	errCorrupted  = errors.New("content is corrupted or not encrypted")
+	errUnknownKey = errors.New("content is encrypted with a different key")
+	errTruncated  = errors.New("content is truncated")
+)
+
+// layout returns the number of complete chunks of an object of the given
+// size, and the size of its last, incomplete chunk. Objects which were not
+// committed yet have no incomplete chunk.
+func layout(size int64) (chunks int64, rem int64, err error) {
+	body := size - int64(headerSize)
+	if body < 0 {
+		return 0, 0, errCorrupted
+	}
+	chunks, rem = body/encryptedChunkSize, body%encryptedChunkSize
+	if rem > 0 && rem < chunkOverhead {
+		return 0, 0, errCorrupted
+	}
+	return chunks, rem, nil
+}
+
+// chunkAdditionalData returns the data authenticated with a chunk.
+func chunkAdditionalData(index uint64, final bool) []byte {
+	ad := make([]byte, 9)
+	binary.BigEndian.PutUint64(ad, index)
+	if final {
+		ad[8] = 1
+	}
+	return ad
+}
+
+type chunkSealer struct {
+	aead  cipher.AEAD
+	index uint64
+}
+
+// seal appends the next chunk, encrypting content, to dst.
+func (s *chunkSealer) seal(dst []byte, content []byte, final bool) []byte {
+	nonce := make([]byte, nonceSize)
+	if _, err := rand.Read(nonce); err != nil {
+		// The system random number generator never fails on supported
+		// platforms.
+		panic(fmt.Sprintf("failed to generate nonce: %v", err))
+	}
+	dst = append(dst, nonce...)
+	dst = s.aead.Seal(dst, nonce, content, chunkAdditionalData(s.index, final))
+	s.index++
+	return dst
+}
+
+// decryptingReader decrypts the chunks of an object, starting with the chunk
+// at index.
+type decryptingReader struct {
+	rc    io.ReadCloser
+	aead  cipher.AEAD
+	index uint64
+	// skip is the number of bytes skipped at the start of the first chunk
+	skip int
+
+	// partial returns the content of the partial object of an unfinished
+	// writer, and whether it exists
+	partial   func() ([]byte, bool, error)
+	wrapError func(error) error
+
+	chunk   []byte
+	content []byte
+	eof     bool
+}
+
+func (r *decryptingReader) Read(p []byte) (int, error) {
+	for len(r.content) == 0 {
+		if r.eof {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.content)
+	r.content = r.content[n:]
+	return n, nil
+}
+
+// next decrypts the next chunk.
+func (r *decryptingReader) next() error {
+	if r.chunk == nil {
+		r.chunk = make([]byte, encryptedChunkSize)
+	}
+
+	n, err := io.ReadFull(r.rc, r.chunk)
+	switch err {
+	case nil:
+		r.content, err = r.open(r.chunk, false)
+	case io.ErrUnexpectedEOF:
+		r.eof = true
+		r.content, err = r.open(r.chunk[:n], true)
+	case io.EOF:
+		// The end of the content of an unfinished writer is in its
+		// partial object
+		r.eof = true
+		var ok bool
+		if r.partial != nil {
+			r.content, ok, err = r.partial()
+		}
+		if err == nil && !ok {
+			err = r.wrapError(errTruncated)
+		}
+	default:
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if r.skip > 0 {
+		if r.skip > len(r.content) {
+			r.skip = len(r.content)
+		}
+		r.content = r.content[r.skip:]
+		r.skip = 0
+	}
+	return nil
+}
+
+func (r *decryptingReader) open(chunk []byte, final bool) ([]byte, error) {
+	if len(chunk) < chunkOverhead {
+		return nil, r.wrapError(errCorrupted)
+	}
+	content, err := r.aead.Open(chunk[nonceSize:nonceSize], chunk[:nonceSize], chunk[nonceSize:], chunkAdditionalData(r.index, final))
+	if err != nil {
+		return nil, r.wrapError(errCorrupted)
+	}
+	r.index++
+	return content, nil
+}
+
+func (r *decryptingReader) Close() error {
+	return r.rc.Close()
+}
+
+// encryptingWriter encrypts the content written to it in chunks. The end of
+// the content which does not fill a chunk is stored in a partial object when
+// the writer is closed, and in the last chunk of the object when the writer
+// is committed.
+type encryptingWriter struct {
+	ctx    context.Context
+	driver *encryptionStorageMiddleware
+	path   string
+	fw     storagedriver.FileWriter
+	sealer chunkSealer
+
+	buf    []byte
+	sealed []byte
+	size   int64
+	// partial is whether the partial object of the writer exists
+	partial bool
+
+	closed    bool
+	committed bool
+	cancelled bool
+}
+
+var _ storagedriver.FileWriter = &encryptingWriter{}
+
+func newEncryptingWriter(ctx context.Context, driver *encryptionStorageMiddleware, path string, fw storagedriver.FileWriter, aead cipher.AEAD) *encryptingWriter {
+	return &encryptingWriter{
+		ctx:    ctx,
+		driver: driver,
+		path:   path,
+		fw:     fw,
+		sealer: chunkSealer{aead: aead},
+		buf:    make([]byte, 0, chunkSize),
+	}
+}
+
+func (w *encryptingWriter) Write(p []byte) (int, error) {
+	if err := w.checkState(); err != nil {
+		return 0, err
+	}
+
+	var nn int
+	for len(p) > 0 {
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		nn += n
+		w.size += int64(n)
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return nn, err
+			}
+		}
+	}
+	return nn, nil
+}
+
+func (w *encryptingWriter) Size() int64 {
+	return w.size
+}
+
+func (w *encryptingWriter) Close() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	}
+	w.closed = true
+
+	if !w.committed && !w.cancelled {
+		encrypted, err := w.driver.encrypt(w.buf)
+		if err != nil {
+			return err
+		}
+		if err := w.driver.StorageDriver.PutContent(w.ctx, w.path+partialSuffix, encrypted); err != nil {
+			return err
+		}
+	}
+	return w.fw.Close()
+}
+
+func (w *encryptingWriter) Cancel(ctx context.Context) error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	}
+	w.cancelled = true
+
+	if err := w.fw.Cancel(ctx); err != nil {
+		return err
+	}
+	return w.deletePartial(ctx)
+}
+
+func (w *encryptingWriter) Commit() error {
+	if err := w.checkState(); err != nil {
+		return err
+	}
+
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	if err := w.fw.Commit(); err != nil {
+		return err
+	}
+	w.committed = true
+	return w.deletePartial(w.ctx)
+}
+
+func (w *encryptingWriter) checkState() error {
+	if w.closed {
+		return fmt.Errorf("already closed")
+	} else if w.committed {
+		return fmt.Errorf("already committed")
+	} else if w.cancelled {
+		return fmt.Errorf("already cancelled")
+	}
+	return nil
+}
+
+// flush writes the buffered content as the next chunk.
+func (w *encryptingWriter) flush(final bool) error {
+	w.sealed = w.sealer.seal(w.sealed[:0], w.buf, final)
+	w.buf = w.buf[:0]
+	_, err := w.fw.Write(w.sealed)
+	return err
+}
+
+func (w *encryptingWriter) deletePartial(ctx context.Context) error {
+	if !w.partial {
+		return nil
+	}
+	w.partial = false
+	return ignoreNotFound(w.driver.StorageDriver.Delete(ctx, w.path+partialSuffix))
+}
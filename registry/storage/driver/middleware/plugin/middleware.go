@@ -0,0 +1,53 @@
+// Package middleware - redirection of the reads of the content of the
+// storage to the URLs returned by an out-of-process plugin
+package middleware
+
+import (
+	"context"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/middleware/plugin"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// pluginStorageMiddleware redirects clients to the URLs returned by the
+// plugin, such as the signed URLs of a CDN or of a storage the registry
+// cannot write to. The content of the paths the plugin returns no URL for
+// is served as if the middleware was not configured.
+type pluginStorageMiddleware struct {
+	storagedriver.StorageDriver
+	client *plugin.Client
+}
+
+var _ storagedriver.StorageDriver = &pluginStorageMiddleware{}
+
+func newPluginStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	o, err := plugin.ParseOptions(options)
+	if err != nil {
+		return nil, err
+	}
+	client, err := plugin.NewClient(o)
+	if err != nil {
+		return nil, err
+	}
+	return &pluginStorageMiddleware{StorageDriver: sd, client: client}, nil
+}
+
+// URLFor returns the URL returned by the plugin, or the URL of the storage
+// driver when the plugin returns none or fails.
+func (p *pluginStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	method, _ := options["method"].(string)
+	url, err := p.client.URLFor(ctx, &plugin.URLForRequest{Path: path, Method: method})
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("plugin: error getting the URL of %s: %v", path, err)
+	}
+	if url == "" {
+		return p.StorageDriver.URLFor(ctx, path, options)
+	}
+	return url, nil
+}
+
+func init() {
+	storagemiddleware.Register("plugin", newPluginStorageMiddleware)
+}
@@ -0,0 +1,59 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3/registry/middleware/plugin"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"google.golang.org/grpc"
+)
+
+// testPlugin redirects the reads of the paths under /cdn.
+type testPlugin struct {
+	plugin.UnimplementedServer
+}
+
+func (testPlugin) URLFor(ctx context.Context, req *plugin.URLForRequest) (*plugin.URLForResponse, error) {
+	if !strings.HasPrefix(req.Path, "/cdn/") || req.Method != "GET" {
+		return &plugin.URLForResponse{}, nil
+	}
+	return &plugin.URLForResponse{URL: "https://cdn.example.com" + req.Path}, nil
+}
+
+func TestURLFor(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := grpc.NewServer()
+	plugin.RegisterServer(s, testPlugin{})
+	go s.Serve(l)
+	defer s.Stop()
+
+	if _, err := newPluginStorageMiddleware(inmemory.New(), map[string]interface{}{}); err == nil {
+		t.Fatal("expected an error without address")
+	}
+	d, err := newPluginStorageMiddleware(inmemory.New(), map[string]interface{}{"address": l.Addr().String()})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	url, err := d.URLFor(ctx, "/cdn/data", map[string]interface{}{"method": "GET"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if url != "https://cdn.example.com/cdn/data" {
+		t.Fatalf("unexpected url %s", url)
+	}
+	// the paths the plugin returns no URL for are served by the storage driver
+	if _, err := d.URLFor(ctx, "/data", map[string]interface{}{"method": "GET"}); err == nil {
+		t.Fatal("expected the error of the storage driver")
+	} else if _, ok := err.(storagedriver.ErrUnsupportedMethod); !ok {
+		t.Fatalf("unexpected error %v", err)
+	}
+}
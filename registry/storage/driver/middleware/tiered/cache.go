@@ -0,0 +1,217 @@
+package middleware
+
+import (
+	"container/list"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/docker/go-metrics"
+)
+
+var (
+	// cacheCount counts the hits, misses and evictions of the local cache
+	cacheCount = prometheus.StorageNamespace.NewLabeledCounter("tiered_cache", "The number of requests and evictions of the local cache of the tiered storage", "type")
+	// cacheSizeGauge is the size of the content in the local cache
+	cacheSizeGauge = prometheus.StorageNamespace.NewGauge("tiered_cache_size", "The size of the content in the local cache of the tiered storage", metrics.Bytes)
+)
+
+// diskCache is a bounded cache of content on the local disk, evicting the
+// least recently used content first.
+type diskCache struct {
+	// dir holds the cached content, at the paths of the storage driver
+	dir string
+	// staging holds the content being written to the cache
+	staging string
+	maxSize int64
+
+	mu      sync.Mutex
+	lru     *list.List // of *cacheEntry, the most recently used first
+	entries map[string]*list.Element
+	size    int64
+}
+
+type cacheEntry struct {
+	path string
+	size int64
+}
+
+// newDiskCache creates a cache in the directory root, taking over the
+// content cached in it before. The content is ordered by its modification
+// time, which is updated when the content is used.
+func newDiskCache(root string, maxSize int64) (*diskCache, error) {
+	c := &diskCache{
+		dir:     filepath.Join(root, "cache"),
+		staging: filepath.Join(root, "staging"),
+		maxSize: maxSize,
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	// Content staged before a restart is incomplete
+	if err := os.RemoveAll(c.staging); err != nil {
+		return nil, err
+	}
+	for _, dir := range []string{c.dir, c.staging} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return nil, err
+		}
+	}
+
+	type cachedFile struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var files []cachedFile
+	err := filepath.WalkDir(c.dir, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil || entry.IsDir() {
+			return err
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(c.dir, name)
+		if err != nil {
+			return err
+		}
+		files = append(files, cachedFile{path: "/" + filepath.ToSlash(rel), size: info.Size(), modTime: info.ModTime()})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].modTime.Before(files[j].modTime)
+	})
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, f := range files {
+		c.entries[f.path] = c.lru.PushFront(&cacheEntry{path: f.path, size: f.size})
+		c.size += f.size
+	}
+	c.evict()
+	return c, nil
+}
+
+func (c *diskCache) filename(path string) string {
+	return filepath.Join(c.dir, filepath.FromSlash(path))
+}
+
+// open opens the cached content of path, if any.
+func (c *diskCache) open(path string) (*os.File, bool) {
+	c.mu.Lock()
+	e, ok := c.entries[path]
+	if ok {
+		c.lru.MoveToFront(e)
+	}
+	c.mu.Unlock()
+	if !ok {
+		cacheCount.WithValues("miss").Inc(1)
+		return nil, false
+	}
+
+	filename := c.filename(path)
+	f, err := os.Open(filename)
+	if err != nil {
+		c.remove(path)
+		cacheCount.WithValues("miss").Inc(1)
+		return nil, false
+	}
+	now := time.Now()
+	os.Chtimes(filename, now, now)
+	cacheCount.WithValues("hit").Inc(1)
+	return f, true
+}
+
+// createTemp creates a file in the staging directory, which is moved into
+// the cache with insert.
+func (c *diskCache) createTemp() (*os.File, error) {
+	return os.CreateTemp(c.staging, "content-")
+}
+
+// put caches content as the content of path.
+func (c *diskCache) put(path string, content []byte) error {
+	f, err := c.createTemp()
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(content)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return c.insert(path, f.Name(), int64(len(content)))
+}
+
+// insert moves the staged file holding size bytes into the cache, as the
+// content of path.
+func (c *diskCache) insert(path string, staged string, size int64) error {
+	if size > c.maxSize {
+		return os.Remove(staged)
+	}
+
+	filename := c.filename(path)
+	if err := os.MkdirAll(filepath.Dir(filename), 0o755); err != nil {
+		os.Remove(staged)
+		return err
+	}
+	if err := os.Rename(staged, filename); err != nil {
+		os.Remove(staged)
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.entries[path]; ok {
+		entry := e.Value.(*cacheEntry)
+		c.size += size - entry.size
+		entry.size = size
+		c.lru.MoveToFront(e)
+	} else {
+		c.entries[path] = c.lru.PushFront(&cacheEntry{path: path, size: size})
+		c.size += size
+	}
+	c.evict()
+	return nil
+}
+
+// remove removes the cached content of path and of its subpaths.
+func (c *diskCache) remove(path string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for p, e := range c.entries {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			c.removeElement(e)
+		}
+	}
+	cacheSizeGauge.Set(float64(c.size))
+}
+
+// evict removes the least recently used content until the cache fits in its
+// maximum size. The caller holds the lock.
+func (c *diskCache) evict() {
+	for c.size > c.maxSize {
+		c.removeElement(c.lru.Back())
+		cacheCount.WithValues("evict").Inc(1)
+	}
+	cacheSizeGauge.Set(float64(c.size))
+}
+
+func (c *diskCache) removeElement(e *list.Element) {
+	entry := c.lru.Remove(e).(*cacheEntry)
+	delete(c.entries, entry.path)
+	c.size -= entry.size
+	// Readers of the content keep reading it after its removal
+	os.Remove(c.filename(entry.path))
+}
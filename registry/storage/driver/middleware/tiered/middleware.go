@@ -0,0 +1,385 @@
+// Package middleware - tiered storage serving blobs from a bounded cache on
+// the local disk in front of the storage driver
+package middleware
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	storagemiddleware "github.com/distribution/distribution/v3/registry/storage/driver/middleware"
+)
+
+// blobsPrefix is the path of the content addressed blobs of the registry.
+// The content of a blob never changes, so the cached content of the blobs
+// stays valid until they are deleted.
+const blobsPrefix = "/docker/registry/v2/blobs/"
+
+// defaultMaxSize is the default size of the local cache.
+const defaultMaxSize = 10 << 30
+
+// tieredStorageMiddleware caches the content of the blobs stored by the
+// storage driver on the local disk. The blobs are cached when they are read
+// from, or written through, the storage driver, and are served from the
+// local disk until they are evicted.
+type tieredStorageMiddleware struct {
+	storagedriver.StorageDriver
+	cache *diskCache
+
+	mu sync.Mutex
+	// staged holds the content written by the writers of the storage
+	// driver, by path, which is cached when the content is moved to a
+	// blob
+	staged map[string]*stagedContent
+}
+
+// stagedContent is a file in the staging directory of the cache holding the
+// content written at a path.
+type stagedContent struct {
+	name      string
+	size      int64
+	committed bool
+}
+
+var _ storagedriver.StorageDriver = &tieredStorageMiddleware{}
+
+// newTieredStorageMiddleware constructs a storage driver caching the blobs
+// stored by sd on the local disk.
+//
+// Required options:
+//
+//   - rootdirectory
+//
+// Optional options:
+//
+//   - maxsize
+func newTieredStorageMiddleware(sd storagedriver.StorageDriver, options map[string]interface{}) (storagedriver.StorageDriver, error) {
+	root, ok := options["rootdirectory"]
+	if !ok {
+		return nil, fmt.Errorf("no rootdirectory provided")
+	}
+	rootDirectory, ok := root.(string)
+	if !ok || rootDirectory == "" {
+		return nil, fmt.Errorf("rootdirectory must be a non-empty string")
+	}
+
+	maxSize := int64(defaultMaxSize)
+	switch v := options["maxsize"].(type) {
+	case string:
+		size, err := strconv.ParseInt(v, 0, 64)
+		if err != nil {
+			return nil, fmt.Errorf("maxsize must be an integer, %v invalid", v)
+		}
+		maxSize = size
+	case int, uint, int32, uint32, int64, uint64:
+		maxSize = reflect.ValueOf(v).Convert(reflect.TypeOf(maxSize)).Int()
+	case nil:
+		// use the default
+	default:
+		return nil, fmt.Errorf("invalid value for maxsize: %#v", v)
+	}
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("maxsize must be positive, %d invalid", maxSize)
+	}
+
+	cache, err := newDiskCache(rootDirectory, maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("unable to create the cache in %s: %s", rootDirectory, err)
+	}
+
+	return &tieredStorageMiddleware{
+		StorageDriver: sd,
+		cache:         cache,
+		staged:        make(map[string]*stagedContent),
+	}, nil
+}
+
+func init() {
+	storagemiddleware.Register("tiered", newTieredStorageMiddleware)
+}
+
+// cacheable reports whether the content at path is cached, which is the
+// content of the blobs.
+func cacheable(path string) bool {
+	return strings.HasPrefix(path, blobsPrefix) && strings.HasSuffix(path, "/data")
+}
+
+// GetContent retrieves the content stored at "path", from the cache when it
+// is cached.
+func (d *tieredStorageMiddleware) GetContent(ctx context.Context, path string) ([]byte, error) {
+	if !cacheable(path) {
+		return d.StorageDriver.GetContent(ctx, path)
+	}
+
+	if f, ok := d.cache.open(path); ok {
+		defer f.Close()
+		return io.ReadAll(f)
+	}
+	content, err := d.StorageDriver.GetContent(ctx, path)
+	if err != nil {
+		return nil, err
+	}
+	if err := d.cache.put(path, content); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error caching %s: %s", path, err)
+	}
+	return content, nil
+}
+
+// PutContent stores the content at "path", and caches it when it is the
+// content of a blob.
+func (d *tieredStorageMiddleware) PutContent(ctx context.Context, path string, content []byte) error {
+	if err := d.StorageDriver.PutContent(ctx, path, content); err != nil {
+		return err
+	}
+	if cacheable(path) {
+		if err := d.cache.put(path, content); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error caching %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// Reader retrieves the content stored at "path" from the given offset, from
+// the cache when it is cached. The content read in full from the storage
+// driver is cached.
+func (d *tieredStorageMiddleware) Reader(ctx context.Context, path string, offset int64) (io.ReadCloser, error) {
+	if !cacheable(path) {
+		return d.StorageDriver.Reader(ctx, path, offset)
+	}
+
+	if f, ok := d.cache.open(path); ok {
+		fi, err := f.Stat()
+		if err == nil && offset > fi.Size() {
+			f.Close()
+			return nil, storagedriver.InvalidOffsetError{Path: path, Offset: offset, DriverName: d.Name()}
+		}
+		if err == nil {
+			_, err = f.Seek(offset, io.SeekStart)
+		}
+		if err == nil {
+			return f, nil
+		}
+		f.Close()
+	}
+
+	rc, err := d.StorageDriver.Reader(ctx, path, offset)
+	if err != nil || offset != 0 {
+		return rc, err
+	}
+	staging, err := d.cache.createTemp()
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error caching %s: %s", path, err)
+		return rc, nil
+	}
+	return &fillingReader{ReadCloser: rc, cache: d.cache, path: path, staging: staging}, nil
+}
+
+// Writer returns a FileWriter which stores the content written to it at
+// "path", and stages it so that it is cached when it is moved to a blob.
+func (d *tieredStorageMiddleware) Writer(ctx context.Context, path string, append bool) (storagedriver.FileWriter, error) {
+	fw, err := d.StorageDriver.Writer(ctx, path, append)
+	if err != nil {
+		return nil, err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	staged, ok := d.staged[path]
+	var f *os.File
+	if append && ok && !staged.committed && staged.size == fw.Size() {
+		f, err = os.OpenFile(staged.name, os.O_WRONLY|os.O_APPEND, 0)
+	} else {
+		// The staged content is not the content of the path when
+		// the writer was opened by another registry instance
+		if ok {
+			os.Remove(staged.name)
+			delete(d.staged, path)
+		}
+		if append && fw.Size() != 0 {
+			return fw, nil
+		}
+		staged = &stagedContent{}
+		f, err = d.cache.createTemp()
+		if err == nil {
+			staged.name = f.Name()
+			d.staged[path] = staged
+		}
+	}
+	if err != nil {
+		dcontext.GetLogger(ctx).Errorf("error staging %s: %s", path, err)
+		return fw, nil
+	}
+	return &stagingWriter{FileWriter: fw, driver: d, path: path, staged: staged, file: f}, nil
+}
+
+// Move moves an object stored at sourcePath to destPath, caching the staged
+// content of sourcePath when destPath is a blob.
+func (d *tieredStorageMiddleware) Move(ctx context.Context, sourcePath string, destPath string) error {
+	if err := d.StorageDriver.Move(ctx, sourcePath, destPath); err != nil {
+		return err
+	}
+	d.cache.remove(sourcePath)
+
+	staged := d.unstage(sourcePath)
+	if staged == nil {
+		return nil
+	}
+	if !staged.committed || !cacheable(destPath) {
+		os.Remove(staged.name)
+		return nil
+	}
+	if err := d.cache.insert(destPath, staged.name, staged.size); err != nil {
+		dcontext.GetLogger(ctx).Errorf("error caching %s: %s", destPath, err)
+	}
+	return nil
+}
+
+// Delete recursively deletes all objects stored at "path" and its subpaths,
+// along with their cached and staged content.
+func (d *tieredStorageMiddleware) Delete(ctx context.Context, path string) error {
+	err := d.StorageDriver.Delete(ctx, path)
+	d.cache.remove(path)
+
+	d.mu.Lock()
+	for p, staged := range d.staged {
+		if p == path || strings.HasPrefix(p, path+"/") {
+			os.Remove(staged.name)
+			delete(d.staged, p)
+		}
+	}
+	d.mu.Unlock()
+	return err
+}
+
+// URLFor returns ErrUnsupportedMethod for the blobs, which are served by the
+// registry so that they are cached.
+func (d *tieredStorageMiddleware) URLFor(ctx context.Context, path string, options map[string]interface{}) (string, error) {
+	if cacheable(path) {
+		return "", storagedriver.ErrUnsupportedMethod{DriverName: d.Name()}
+	}
+	return d.StorageDriver.URLFor(ctx, path, options)
+}
+
+func (d *tieredStorageMiddleware) unstage(path string) *stagedContent {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	staged := d.staged[path]
+	delete(d.staged, path)
+	return staged
+}
+
+// fillingReader caches the content it reads once it was read in full.
+type fillingReader struct {
+	io.ReadCloser
+	cache   *diskCache
+	path    string
+	staging *os.File
+	size    int64
+}
+
+func (r *fillingReader) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	if r.staging != nil && n > 0 {
+		if _, werr := r.staging.Write(p[:n]); werr != nil {
+			r.discard()
+		}
+		r.size += int64(n)
+	}
+	if err == io.EOF && r.staging != nil {
+		name := r.staging.Name()
+		if cerr := r.staging.Close(); cerr != nil {
+			os.Remove(name)
+		} else {
+			r.cache.insert(r.path, name, r.size)
+		}
+		r.staging = nil
+	}
+	return n, err
+}
+
+func (r *fillingReader) Close() error {
+	r.discard()
+	return r.ReadCloser.Close()
+}
+
+func (r *fillingReader) discard() {
+	if r.staging != nil {
+		r.staging.Close()
+		os.Remove(r.staging.Name())
+		r.staging = nil
+	}
+}
+
+// stagingWriter writes the content written to the storage driver to the
+// staging directory of the cache as well.
+type stagingWriter struct {
+	storagedriver.FileWriter
+	driver *tieredStorageMiddleware
+	path   string
+	staged *stagedContent
+	file   *os.File
+}
+
+func (w *stagingWriter) Write(p []byte) (int, error) {
+	n, err := w.FileWriter.Write(p)
+	if w.file != nil && n > 0 {
+		if _, werr := w.file.Write(p[:n]); werr != nil {
+			w.discard()
+		}
+	}
+	return n, err
+}
+
+func (w *stagingWriter) Close() error {
+	err := w.FileWriter.Close()
+	if w.file != nil {
+		w.file.Close()
+		w.file = nil
+		w.driver.mu.Lock()
+		w.staged.size = w.FileWriter.Size()
+		w.driver.mu.Unlock()
+	}
+	return err
+}
+
+func (w *stagingWriter) Cancel(ctx context.Context) error {
+	w.discard()
+	return w.FileWriter.Cancel(ctx)
+}
+
+func (w *stagingWriter) Commit() error {
+	if err := w.FileWriter.Commit(); err != nil {
+		return err
+	}
+	if w.file != nil {
+		w.driver.mu.Lock()
+		w.staged.committed = true
+		w.driver.mu.Unlock()
+	}
+	return nil
+}
+
+// discard stops staging the content of the writer.
+func (w *stagingWriter) discard() {
+	if w.file == nil {
+		return
+	}
+	w.file.Close()
+	w.file = nil
+
+	w.driver.mu.Lock()
+	defer w.driver.mu.Unlock()
+	if w.driver.staged[w.path] == w.staged {
+		delete(w.driver.staged, w.path)
+	}
+	os.Remove(w.staged.name)
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func blobPath(name string) string {
+	return blobsPrefix + "sha256/" + name[:2] + "/" + name + "/data"
+}
+
+func newTestMiddleware(t *testing.T, backend storagedriver.StorageDriver, root string, maxSize int) *tieredStorageMiddleware {
+	t.Helper()
+	d, err := newTieredStorageMiddleware(backend, map[string]interface{}{
+		"rootdirectory": root,
+		"maxsize":       maxSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d.(*tieredStorageMiddleware)
+}
+
+func read(t *testing.T, d storagedriver.StorageDriver, path string, offset int64) []byte {
+	t.Helper()
+	r, err := d.Reader(context.Background(), path, offset)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	content, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestOptions(t *testing.T) {
+	for _, options := range []map[string]interface{}{
+		{},
+		{"rootdirectory": ""},
+		{"rootdirectory": t.TempDir(), "maxsize": "ten"},
+		{"rootdirectory": t.TempDir(), "maxsize": 0},
+	} {
+		if _, err := newTieredStorageMiddleware(inmemory.New(), options); err == nil {
+			t.Errorf("expected an error with options %v", options)
+		}
+	}
+}
+
+func TestReadThrough(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	root := t.TempDir()
+	d := newTestMiddleware(t, backend, root, 10)
+
+	blob := blobPath("aaaa")
+	if err := backend.PutContent(ctx, blob, []byte("layer")); err != nil {
+		t.Fatal(err)
+	}
+	if content := read(t, d, blob, 2); string(content) != "yer" {
+		t.Fatalf("unexpected content %q", content)
+	}
+	if _, ok := d.cache.entries[blob]; ok {
+		t.Fatal("expected a partial read not to be cached")
+	}
+	if content := read(t, d, blob, 0); string(content) != "layer" {
+		t.Fatalf("unexpected content %q", content)
+	}
+
+	// The blob is served from the cache from now on
+	if err := backend.Delete(ctx, blob); err != nil {
+		t.Fatal(err)
+	}
+	if content := read(t, d, blob, 1); string(content) != "ayer" {
+		t.Fatalf("unexpected cached content %q", content)
+	}
+	if _, err := d.URLFor(ctx, blob, nil); err == nil {
+		t.Error("expected blobs not to be redirected to")
+	}
+
+	// Caching a blob over the maximum size evicts the least recently
+	// used one
+	other := blobPath("bbbb")
+	if err := d.PutContent(ctx, other, []byte("config")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := d.cache.entries[blob]; ok {
+		t.Error("expected the least recently used blob to be evicted")
+	}
+	if _, err := d.Reader(ctx, blob, 0); err == nil {
+		t.Error("expected the evicted blob not to be found")
+	}
+
+	// The cache survives restarts
+	d = newTestMiddleware(t, backend, root, 10)
+	if d.cache.size != int64(len("config")) {
+		t.Fatalf("expected the cached blob to be restored, got a cache of %d bytes", d.cache.size)
+	}
+
+	if err := d.Delete(ctx, "/docker/registry/v2/blobs"); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.cache.entries) != 0 || d.cache.size != 0 {
+		t.Errorf("expected deleted blobs to be removed from the cache, got %d entries", len(d.cache.entries))
+	}
+}
+
+func TestWriteThrough(t *testing.T) {
+	ctx := context.Background()
+	backend := inmemory.New()
+	d := newTestMiddleware(t, backend, t.TempDir(), 1<<20)
+
+	upload := "/docker/registry/v2/repositories/foo/_uploads/1234/data"
+	content := bytes.Repeat([]byte("layer"), 1000)
+	for i, part := range [][]byte{content[:2000], content[2000:]} {
+		w, err := d.Writer(ctx, upload, i > 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write(part); err != nil {
+			t.Fatal(err)
+		}
+		if i > 0 {
+			if err := w.Commit(); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	blob := blobPath("cccc")
+	if err := d.Move(ctx, upload, blob); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.staged) != 0 {
+		t.Errorf("expected the staged content to be moved, got %v", d.staged)
+	}
+	if err := backend.Delete(ctx, blob); err != nil {
+		t.Fatal(err)
+	}
+	if cached := read(t, d, blob, 0); !bytes.Equal(cached, content) {
+		t.Error("expected the written blob to be served from the cache")
+	}
+
+	// Content staged by an abandoned upload is removed with the upload
+	w, err := d.Writer(ctx, upload, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(content)
+	w.Close()
+	if err := d.Delete(ctx, "/docker/registry/v2/repositories/foo/_uploads/1234"); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.staged) != 0 {
+		t.Errorf("expected the staged content to be removed, got %v", d.staged)
+	}
+}
@@ -0,0 +1,118 @@
+// Package rgw provides a storagedriver.StorageDriver implementation to
+// store blobs in Ceph through the S3 compatible API of the RADOS gateway.
+//
+// The driver builds on the s3aws driver, with defaults suited to the
+// gateway: requests always use path-style addressing, as gateways are
+// rarely set up for virtual-host buckets, and the multipart thresholds
+// are aligned with the striping of the objects in RADOS.
+package rgw
+
+import (
+	"fmt"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	s3 "github.com/distribution/distribution/v3/registry/storage/driver/s3-aws"
+)
+
+const driverName = "rgw"
+
+const (
+	// defaultRegion is the name of the default zonegroup of a Ceph cluster,
+	// which the gateway expects in the signatures of the requests.
+	defaultRegion = "default"
+
+	// defaultChunkSize is the part size of multipart uploads. The gateway
+	// stripes objects into 4MB RADOS objects, so parts are kept a multiple
+	// of the stripe size.
+	defaultChunkSize = 16 << 20
+
+	// defaultMultipartCopyChunkSize is the part size of multipart copies.
+	defaultMultipartCopyChunkSize = 64 << 20
+
+	// defaultMultipartCopyMaxConcurrency is the default maximum number of
+	// concurrent part copies. Unlike S3, a gateway serves the copies from a
+	// few instances, which are easily overloaded by many concurrent copies.
+	defaultMultipartCopyMaxConcurrency = 16
+
+	// defaultMultipartCopyThresholdSize is the object size above which
+	// objects are copied in parts.
+	defaultMultipartCopyThresholdSize = 64 << 20
+)
+
+// unsupportedParameters are the parameters of the s3aws driver which have no
+// equivalent on the gateway.
+var unsupportedParameters = []string{"forcepathstyle", "accelerate", "usedualstack"}
+
+func init() {
+	factory.Register(driverName, &rgwDriverFactory{})
+}
+
+// rgwDriverFactory implements the factory.StorageDriverFactory interface
+type rgwDriverFactory struct{}
+
+func (factory *rgwDriverFactory) Create(parameters map[string]interface{}) (storagedriver.StorageDriver, error) {
+	return FromParameters(parameters)
+}
+
+// Driver is a storagedriver.StorageDriver implementation backed by the RADOS
+// gateway of a Ceph cluster.
+type Driver struct {
+	*s3.Driver
+}
+
+// Name returns the name of the driver.
+func (d *Driver) Name() string {
+	return driverName
+}
+
+// FromParameters constructs a new Driver with a given parameters map
+// Required parameters:
+// - regionendpoint
+// - bucket
+func FromParameters(parameters map[string]interface{}) (*Driver, error) {
+	params, err := s3Parameters(parameters)
+	if err != nil {
+		return nil, err
+	}
+
+	d, err := s3.FromParameters(params)
+	if err != nil {
+		return nil, err
+	}
+	return &Driver{Driver: d}, nil
+}
+
+// s3Parameters converts the parameters of the driver into the parameters of
+// the s3aws driver, filling in the defaults for the gateway.
+func s3Parameters(parameters map[string]interface{}) (map[string]interface{}, error) {
+	endpoint := parameters["regionendpoint"]
+	if endpoint == nil || fmt.Sprint(endpoint) == "" {
+		return nil, fmt.Errorf("no regionendpoint parameter provided")
+	}
+
+	for _, name := range unsupportedParameters {
+		if _, ok := parameters[name]; ok {
+			return nil, fmt.Errorf("the %s parameter is not supported by the %s driver", name, driverName)
+		}
+	}
+
+	params := make(map[string]interface{}, len(parameters)+1)
+	for name, value := range parameters {
+		params[name] = value
+	}
+	params["forcepathstyle"] = true
+
+	for name, value := range map[string]interface{}{
+		"region":                      defaultRegion,
+		"chunksize":                   defaultChunkSize,
+		"multipartcopychunksize":      defaultMultipartCopyChunkSize,
+		"multipartcopymaxconcurrency": defaultMultipartCopyMaxConcurrency,
+		"multipartcopythresholdsize":  defaultMultipartCopyThresholdSize,
+	} {
+		if v := params[name]; v == nil || fmt.Sprint(v) == "" {
+			params[name] = value
+		}
+	}
+	return params, nil
+}
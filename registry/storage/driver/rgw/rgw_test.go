@@ -0,0 +1,109 @@
+package rgw
+
+import (
+	"os"
+	"testing"
+
+	"gopkg.in/check.v1"
+
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/testsuites"
+)
+
+// Hook up gocheck into the "go test" runner.
+func Test(t *testing.T) { check.TestingT(t) }
+
+var skipRGW func() string
+
+func init() {
+	var (
+		endpoint  = os.Getenv("RGW_ENDPOINT")
+		accessKey = os.Getenv("RGW_ACCESS_KEY")
+		secretKey = os.Getenv("RGW_SECRET_KEY")
+		bucket    = os.Getenv("RGW_BUCKET")
+	)
+
+	// Skip the integration tests against a gateway if environment variable
+	// parameters are not provided
+	skipRGW = func() string {
+		if endpoint == "" || accessKey == "" || secretKey == "" || bucket == "" {
+			return "Must set RGW_ENDPOINT, RGW_ACCESS_KEY, RGW_SECRET_KEY and RGW_BUCKET to run RGW tests"
+		}
+		return ""
+	}
+
+	root, err := os.MkdirTemp("", "driver-")
+	if err != nil {
+		panic(err)
+	}
+	defer os.Remove(root)
+
+	testsuites.RegisterSuite(func() (storagedriver.StorageDriver, error) {
+		return FromParameters(map[string]interface{}{
+			"regionendpoint": endpoint,
+			"accesskey":      accessKey,
+			"secretkey":      secretKey,
+			"bucket":         bucket,
+			"rootdirectory":  root,
+			"useragent":      driverName + "-test",
+		})
+	}, skipRGW)
+}
+
+func TestS3Parameters(t *testing.T) {
+	params, err := s3Parameters(map[string]interface{}{
+		"regionendpoint": "http://rgw.ceph.svc:8080",
+		"bucket":         "registry",
+		"chunksize":      "33554432",
+		"region":         "",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]interface{}{
+		"forcepathstyle":              true,
+		"region":                      defaultRegion,
+		"chunksize":                   "33554432",
+		"multipartcopychunksize":      defaultMultipartCopyChunkSize,
+		"multipartcopymaxconcurrency": defaultMultipartCopyMaxConcurrency,
+		"multipartcopythresholdsize":  defaultMultipartCopyThresholdSize,
+	} {
+		if params[name] != expected {
+			t.Errorf("%s: expected %v, got %v", name, expected, params[name])
+		}
+	}
+
+	for _, invalid := range []map[string]interface{}{
+		{"bucket": "registry"},
+		{"bucket": "registry", "regionendpoint": "http://rgw.ceph.svc:8080", "forcepathstyle": false},
+		{"bucket": "registry", "regionendpoint": "http://rgw.ceph.svc:8080", "accelerate": true},
+	} {
+		if _, err := s3Parameters(invalid); err == nil {
+			t.Errorf("expected an error converting %v", invalid)
+		}
+	}
+}
+
+func TestFromParameters(t *testing.T) {
+	d, err := FromParameters(map[string]interface{}{
+		"regionendpoint": "http://rgw.ceph.svc:8080",
+		"bucket":         "registry",
+		"accesskey":      "access",
+		"secretkey":      "secret",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Name() != driverName {
+		t.Errorf("expected driver name %s, got %s", driverName, d.Name())
+	}
+
+	// The part size must stay within the limits of multipart uploads
+	if _, err := FromParameters(map[string]interface{}{
+		"regionendpoint": "http://rgw.ceph.svc:8080",
+		"bucket":         "registry",
+		"chunksize":      1024,
+	}); err == nil {
+		t.Error("expected an error with a chunk size below the minimum part size")
+	}
+}
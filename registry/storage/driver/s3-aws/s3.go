@@ -20,11 +20,13 @@ import (
 	"io"
 	"math"
 	"net/http"
+	"net/url"
 	"path/filepath"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
@@ -62,6 +64,16 @@ const (
 	// of concurrent Upload Part - Copy operations for a multipart copy.
 	defaultMultipartCopyMaxConcurrency = 100
 
+	// defaultMultipartUploadMaxConcurrency defines the default maximum
+	// number of parts of a multipart upload which are uploaded concurrently.
+	// Parts are uploaded one at a time by default.
+	defaultMultipartUploadMaxConcurrency = 1
+
+	// adaptiveChunkSizeParts is the number of parts of a multipart upload
+	// after which the part size doubles when the chunk size is adaptive.
+	// S3 allows at most 10,000 parts per upload.
+	adaptiveChunkSizeParts = 1000
+
 	// defaultMultipartCopyThresholdSize defines the default object size
 	// above which multipart copy will be used. (PUT Object - Copy is used
 	// for objects at or below this size.)  Empirically, 32 MB is optimal.
@@ -117,6 +129,9 @@ type DriverParameters struct {
 	SessionToken                string
 	UseDualStack                bool
 	Accelerate                  bool
+
+	MultipartUploadMaxConcurrency int64
+	MultipartAdaptiveChunkSize    bool
 }
 
 func init() {
@@ -164,6 +179,9 @@ type driver struct {
 	RootDirectory               string
 	StorageClass                string
 	ObjectACL                   string
+
+	MultipartUploadMaxConcurrency int64
+	MultipartAdaptiveChunkSize    bool
 }
 
 type baseEmbed struct {
@@ -328,6 +346,11 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		return nil, err
 	}
 
+	multipartUploadMaxConcurrency, err := getParameterAsInt64(parameters, "multipartuploadmaxconcurrency", defaultMultipartUploadMaxConcurrency, 1, math.MaxInt32)
+	if err != nil {
+		return nil, err
+	}
+
 	rootDirectory := parameters["rootdirectory"]
 	if rootDirectory == nil {
 		rootDirectory = ""
@@ -416,6 +439,23 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		return nil, fmt.Errorf("the multipartcombinesmallpart parameter should be a boolean")
 	}
 
+	multipartAdaptiveChunkSize := false
+	adaptive := parameters["multipartadaptivechunksize"]
+	switch adaptive := adaptive.(type) {
+	case string:
+		b, err := strconv.ParseBool(adaptive)
+		if err != nil {
+			return nil, fmt.Errorf("the multipartadaptivechunksize parameter should be a boolean")
+		}
+		multipartAdaptiveChunkSize = b
+	case bool:
+		multipartAdaptiveChunkSize = adaptive
+	case nil:
+		// do nothing
+	default:
+		return nil, fmt.Errorf("the multipartadaptivechunksize parameter should be a boolean")
+	}
+
 	sessionToken := ""
 
 	accelerateBool := false
@@ -459,6 +499,8 @@ func FromParameters(parameters map[string]interface{}) (*Driver, error) {
 		fmt.Sprint(sessionToken),
 		useDualStackBool,
 		accelerateBool,
+		multipartUploadMaxConcurrency,
+		multipartAdaptiveChunkSize,
 	}
 
 	return New(params)
@@ -578,6 +620,9 @@ func New(params DriverParameters) (*Driver, error) {
 		RootDirectory:               params.RootDirectory,
 		StorageClass:                params.StorageClass,
 		ObjectACL:                   params.ObjectACL,
+
+		MultipartUploadMaxConcurrency: params.MultipartUploadMaxConcurrency,
+		MultipartAdaptiveChunkSize:    params.MultipartAdaptiveChunkSize,
 	}
 
 	return &Driver{
@@ -614,6 +659,7 @@ func (d *driver) PutContent(ctx context.Context, path string, contents []byte) e
 		ServerSideEncryption: d.getEncryptionMode(),
 		SSEKMSKeyId:          d.getSSEKMSKeyID(),
 		StorageClass:         d.getStorageClass(),
+		Tagging:              getTagging(ctx),
 		Body:                 bytes.NewReader(contents),
 	})
 	return parseError(path, err)
@@ -641,6 +687,7 @@ func (d *driver) Reader(ctx context.Context, path string, offset int64) (io.Read
 // at the location designated by "path" after the call to Commit.
 func (d *driver) Writer(ctx context.Context, path string, appendParam bool) (storagedriver.FileWriter, error) {
 	key := d.s3Path(path)
+	tagging := getTagging(ctx)
 	if !appendParam {
 		// TODO (brianbland): cancel other uploads at this path
 		resp, err := d.S3.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
@@ -651,11 +698,12 @@ func (d *driver) Writer(ctx context.Context, path string, appendParam bool) (sto
 			ServerSideEncryption: d.getEncryptionMode(),
 			SSEKMSKeyId:          d.getSSEKMSKeyID(),
 			StorageClass:         d.getStorageClass(),
+			Tagging:              tagging,
 		})
 		if err != nil {
 			return nil, err
 		}
-		return d.newWriter(key, *resp.UploadId, nil), nil
+		return d.newWriter(key, *resp.UploadId, nil, tagging), nil
 	}
 
 	listMultipartUploadsInput := &s3.ListMultipartUploadsInput{
@@ -702,7 +750,7 @@ func (d *driver) Writer(ctx context.Context, path string, appendParam bool) (sto
 				}
 				allParts = append(allParts, partsList.Parts...)
 			}
-			return d.newWriter(key, *multi.UploadId, allParts), nil
+			return d.newWriter(key, *multi.UploadId, allParts, tagging), nil
 		}
 
 		// resp.NextUploadIdMarker must have at least one element or we would have returned not found
@@ -839,7 +887,7 @@ func (d *driver) copy(ctx context.Context, sourcePath string, destPath string) e
 	}
 
 	if fileInfo.Size() <= d.MultipartCopyThresholdSize {
-		_, err := d.S3.CopyObject(&s3.CopyObjectInput{
+		input := &s3.CopyObjectInput{
 			Bucket:               aws.String(d.Bucket),
 			Key:                  aws.String(d.s3Path(destPath)),
 			ContentType:          d.getContentType(),
@@ -848,7 +896,13 @@ func (d *driver) copy(ctx context.Context, sourcePath string, destPath string) e
 			SSEKMSKeyId:          d.getSSEKMSKeyID(),
 			StorageClass:         d.getStorageClass(),
 			CopySource:           aws.String(d.Bucket + "/" + d.s3Path(sourcePath)),
-		})
+		}
+		// The tags of the source are copied, unless the context has tags
+		if tagging := getTagging(ctx); tagging != nil {
+			input.Tagging = tagging
+			input.TaggingDirective = aws.String(s3.TaggingDirectiveReplace)
+		}
+		_, err := d.S3.CopyObject(input)
 		if err != nil {
 			return parseError(sourcePath, err)
 		}
@@ -863,6 +917,7 @@ func (d *driver) copy(ctx context.Context, sourcePath string, destPath string) e
 		SSEKMSKeyId:          d.getSSEKMSKeyID(),
 		ServerSideEncryption: d.getEncryptionMode(),
 		StorageClass:         d.getStorageClass(),
+		Tagging:              getTagging(ctx),
 	})
 	if err != nil {
 		return err
@@ -1249,14 +1304,49 @@ func (d *driver) getStorageClass() *string {
 	return aws.String(d.StorageClass)
 }
 
+// getTagging returns the tags of the objects written with the context, as the
+// URL encoded query of the Tagging of the requests.
+func getTagging(ctx context.Context) *string {
+	tags := storagedriver.ObjectTags(ctx)
+	if len(tags) == 0 {
+		return nil
+	}
+	values := url.Values{}
+	for key, value := range tags {
+		values.Set(key, value)
+	}
+	return aws.String(values.Encode())
+}
+
+// partSize returns the size of the numbered part of a multipart upload. With
+// an adaptive chunk size, the part size doubles every adaptiveChunkSizeParts
+// parts, so large objects stay within the part limit of S3.
+func (d *driver) partSize(partNumber int) int {
+	size := d.ChunkSize
+	if d.MultipartAdaptiveChunkSize {
+		for n := (partNumber - 1) / adaptiveChunkSizeParts; n > 0 && size < maxChunkSize; n-- {
+			size *= 2
+		}
+		if size > maxChunkSize {
+			size = maxChunkSize
+		}
+	}
+	return int(size)
+}
+
 // writer attempts to upload parts to S3 in a buffered fashion where the last
 // part is at least as large as the chunksize, so the multipart upload could be
 // cleanly resumed in the future. This is violated if Close is called after less
 // than a full chunk is written.
+//
+// Up to MultipartUploadMaxConcurrency parts are uploaded at once. Parts whose
+// upload failed are kept and uploaded again when the writer is closed or
+// committed, without uploading the parts which completed.
 type writer struct {
 	driver      *driver
 	key         string
 	uploadID    string
+	tagging     *string
 	parts       []*s3.Part
 	size        int64
 	readyPart   []byte
@@ -1264,9 +1354,32 @@ type writer struct {
 	closed      bool
 	committed   bool
 	cancelled   bool
+
+	uploads sync.WaitGroup
+	limiter chan struct{}
+	mu      sync.Mutex   // protects failed
+	failed  []partUpload // parts to upload again
 }
 
-func (d *driver) newWriter(key, uploadID string, parts []*s3.Part) storagedriver.FileWriter {
+// partUpload is the content of a part which was not uploaded.
+type partUpload struct {
+	part *s3.Part
+	body []byte
+}
+
+func (d *driver) newWriter(key, uploadID string, parts []*s3.Part, tagging *string) storagedriver.FileWriter {
+	// A part which failed to upload concurrently with the following parts
+	// leaves a gap, after which the parts are uploaded again
+	sort.Slice(parts, func(i, j int) bool {
+		return *parts[i].PartNumber < *parts[j].PartNumber
+	})
+	for i, part := range parts {
+		if *part.PartNumber != int64(i+1) {
+			parts = parts[:i]
+			break
+		}
+	}
+
 	var size int64
 	for _, part := range parts {
 		size += *part.Size
@@ -1275,8 +1388,10 @@ func (d *driver) newWriter(key, uploadID string, parts []*s3.Part) storagedriver
 		driver:   d,
 		key:      key,
 		uploadID: uploadID,
+		tagging:  tagging,
 		parts:    parts,
 		size:     size,
+		limiter:  make(chan struct{}, d.MultipartUploadMaxConcurrency),
 	}
 }
 
@@ -1332,6 +1447,7 @@ func (w *writer) Write(p []byte) (int, error) {
 			ACL:                  w.driver.getACL(),
 			ServerSideEncryption: w.driver.getEncryptionMode(),
 			StorageClass:         w.driver.getStorageClass(),
+			Tagging:              w.tagging,
 		})
 		if err != nil {
 			return 0, err
@@ -1380,7 +1496,7 @@ func (w *writer) Write(p []byte) (int, error) {
 
 	for len(p) > 0 {
 		// If no parts are ready to write, fill up the first part
-		if neededBytes := int(w.driver.ChunkSize) - len(w.readyPart); neededBytes > 0 {
+		if neededBytes := w.driver.partSize(len(w.parts)+1) - len(w.readyPart); neededBytes > 0 {
 			if len(p) >= neededBytes {
 				w.readyPart = append(w.readyPart, p[:neededBytes]...)
 				n += neededBytes
@@ -1392,7 +1508,7 @@ func (w *writer) Write(p []byte) (int, error) {
 			}
 		}
 
-		if neededBytes := int(w.driver.ChunkSize) - len(w.pendingPart); neededBytes > 0 {
+		if neededBytes := w.driver.partSize(len(w.parts)+2) - len(w.pendingPart); neededBytes > 0 {
 			if len(p) >= neededBytes {
 				w.pendingPart = append(w.pendingPart, p[:neededBytes]...)
 				n += neededBytes
@@ -1422,7 +1538,11 @@ func (w *writer) Close() error {
 		return fmt.Errorf("already closed")
 	}
 	w.closed = true
-	return w.flushPart()
+	if err := w.flushPart(); err != nil {
+		w.wait()
+		return err
+	}
+	return w.wait()
 }
 
 func (w *writer) Cancel(ctx context.Context) error {
@@ -1432,6 +1552,7 @@ func (w *writer) Cancel(ctx context.Context) error {
 		return fmt.Errorf("already committed")
 	}
 	w.cancelled = true
+	w.uploads.Wait()
 	_, err := w.driver.S3.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
 		Bucket:   aws.String(w.driver.Bucket),
 		Key:      aws.String(w.key),
@@ -1450,6 +1571,10 @@ func (w *writer) Commit() error {
 	}
 	err := w.flushPart()
 	if err != nil {
+		w.wait()
+		return err
+	}
+	if err := w.wait(); err != nil {
 		return err
 	}
 	w.committed = true
@@ -1514,30 +1639,91 @@ func (w *writer) flushPart() error {
 		// nothing to write
 		return nil
 	}
-	if w.driver.MultipartCombineSmallPart && len(w.pendingPart) < int(w.driver.ChunkSize) {
+	if w.driver.MultipartCombineSmallPart && len(w.pendingPart) < w.driver.partSize(len(w.parts)+2) {
 		// closing with a small pending part
 		// combine ready and pending to avoid writing a small part
 		w.readyPart = append(w.readyPart, w.pendingPart...)
 		w.pendingPart = nil
 	}
 
-	partNumber := aws.Int64(int64(len(w.parts) + 1))
+	upload := partUpload{
+		part: &s3.Part{
+			PartNumber: aws.Int64(int64(len(w.parts) + 1)),
+			Size:       aws.Int64(int64(len(w.readyPart))),
+		},
+		body: w.readyPart,
+	}
+
+	if w.driver.MultipartUploadMaxConcurrency <= 1 {
+		if err := w.uploadPart(upload); err != nil {
+			return err
+		}
+	} else {
+		if err := w.uploadError(); err != nil {
+			return err
+		}
+
+		w.limiter <- struct{}{}
+		w.uploads.Add(1)
+		go func() {
+			defer func() {
+				<-w.limiter
+				w.uploads.Done()
+			}()
+			if err := w.uploadPart(upload); err != nil {
+				w.mu.Lock()
+				w.failed = append(w.failed, upload)
+				w.mu.Unlock()
+			}
+		}()
+	}
+
+	w.parts = append(w.parts, upload.part)
+	w.readyPart = w.pendingPart
+	w.pendingPart = nil
+	return nil
+}
+
+// uploadPart uploads the content of a part, and records its ETag.
+func (w *writer) uploadPart(upload partUpload) error {
 	resp, err := w.driver.S3.UploadPart(&s3.UploadPartInput{
 		Bucket:     aws.String(w.driver.Bucket),
 		Key:        aws.String(w.key),
-		PartNumber: partNumber,
+		PartNumber: upload.part.PartNumber,
 		UploadId:   aws.String(w.uploadID),
-		Body:       bytes.NewReader(w.readyPart),
+		Body:       bytes.NewReader(upload.body),
 	})
 	if err != nil {
 		return err
 	}
-	w.parts = append(w.parts, &s3.Part{
-		ETag:       resp.ETag,
-		PartNumber: partNumber,
-		Size:       aws.Int64(int64(len(w.readyPart))),
-	})
-	w.readyPart = w.pendingPart
-	w.pendingPart = nil
+	upload.part.ETag = resp.ETag
 	return nil
 }
+
+// uploadError uploads the parts which failed to upload concurrently once
+// more, and returns the error of those which failed again. The parts still
+// being uploaded are not waited for.
+func (w *writer) uploadError() error {
+	w.mu.Lock()
+	failed := w.failed
+	w.failed = nil
+	w.mu.Unlock()
+
+	var err error
+	for i, upload := range failed {
+		if err = w.uploadPart(upload); err != nil {
+			w.mu.Lock()
+			w.failed = append(w.failed, failed[i:]...)
+			w.mu.Unlock()
+			return err
+		}
+	}
+	return nil
+}
+
+// wait waits for the parts being uploaded, and uploads those which failed
+// once more.
+func (w *writer) wait() error {
+	w.uploads.Wait()
+	return w.uploadError()
+}
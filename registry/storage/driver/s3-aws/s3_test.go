@@ -2,15 +2,20 @@ package s3
 
 import (
 	"bytes"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"io"
 	"math/rand"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path"
 	"reflect"
 	"sort"
 	"strconv"
 	"strings"
+	"sync"
 	"testing"
 
 	"gopkg.in/check.v1"
@@ -142,6 +147,8 @@ func init() {
 			sessionToken,
 			useDualStackBool,
 			accelerateBool,
+			defaultMultipartUploadMaxConcurrency,
+			false,
 		}
 
 		return New(parameters)
@@ -832,3 +839,185 @@ func compareWalked(t *testing.T, expected, walked []string) {
 		}
 	}
 }
+
+func TestPartSize(t *testing.T) {
+	d := &driver{ChunkSize: minChunkSize}
+	if size := d.partSize(5000); size != minChunkSize {
+		t.Fatalf("expected a fixed part size of %d, got %d", minChunkSize, size)
+	}
+
+	d.ChunkSize = maxChunkSize / 2
+	d.MultipartAdaptiveChunkSize = true
+	if size := d.partSize(5000); size != maxChunkSize {
+		t.Fatalf("expected the part size to be capped at %d, got %d", maxChunkSize, size)
+	}
+	d.ChunkSize = minChunkSize
+	for partNumber, expected := range map[int]int{
+		1:                            minChunkSize,
+		adaptiveChunkSizeParts:       minChunkSize,
+		adaptiveChunkSizeParts + 1:   2 * minChunkSize,
+		3*adaptiveChunkSizeParts + 1: 8 * minChunkSize,
+		10000:                        512 * minChunkSize,
+	} {
+		if size := d.partSize(partNumber); size != expected {
+			t.Errorf("part %d: expected size %d, got %d", partNumber, expected, size)
+		}
+	}
+}
+
+func TestResumeWriterWithGap(t *testing.T) {
+	d := &driver{ChunkSize: minChunkSize, MultipartUploadMaxConcurrency: 1}
+	part := func(number int64) *s3.Part {
+		return &s3.Part{PartNumber: aws.Int64(number), Size: aws.Int64(minChunkSize)}
+	}
+
+	// Part 3 failed while part 4 was uploaded concurrently
+	w := d.newWriter("key", "upload", []*s3.Part{part(4), part(2), part(1)}, nil).(*writer)
+	if len(w.parts) != 2 || w.Size() != 2*minChunkSize {
+		t.Fatalf("expected to resume after 2 parts, got %d parts of %d bytes", len(w.parts), w.Size())
+	}
+}
+
+// fakeMultipartS3 serves the multipart upload API of S3, failing the first
+// upload of the parts in failOnce.
+type fakeMultipartS3 struct {
+	mu        sync.Mutex
+	uploads   map[string]int // part number to number of uploads
+	failOnce  map[string]bool
+	completed []string // part numbers of the completed upload
+	tagging   []string // tagging of the objects created
+}
+
+func (f *fakeMultipartS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.tagging = append(f.tagging, r.Header.Get("X-Amz-Tagging"))
+		fmt.Fprint(w, `<InitiateMultipartUploadResult><Bucket>bucket</Bucket><Key>key</Key><UploadId>upload</UploadId></InitiateMultipartUploadResult>`)
+	case r.Method == http.MethodPut && query.Get("partNumber") != "":
+		io.Copy(io.Discard, r.Body)
+		partNumber := query.Get("partNumber")
+		f.uploads[partNumber]++
+		if f.failOnce[partNumber] && f.uploads[partNumber] == 1 {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, `<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>`)
+			return
+		}
+		w.Header().Set("ETag", `"etag-`+partNumber+`"`)
+	case r.Method == http.MethodPost && query.Get("uploadId") != "":
+		var complete struct {
+			Parts []struct {
+				PartNumber string
+			} `xml:"Part"`
+		}
+		if err := xml.NewDecoder(r.Body).Decode(&complete); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		for _, part := range complete.Parts {
+			f.completed = append(f.completed, part.PartNumber)
+		}
+		fmt.Fprint(w, `<CompleteMultipartUploadResult><Bucket>bucket</Bucket><Key>key</Key></CompleteMultipartUploadResult>`)
+	case r.Method == http.MethodPut:
+		io.Copy(io.Discard, r.Body)
+		f.tagging = append(f.tagging, r.Header.Get("X-Amz-Tagging"))
+	case r.Method == http.MethodDelete:
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusNotImplemented)
+	}
+}
+
+func TestConcurrentPartUpload(t *testing.T) {
+	fake := &fakeMultipartS3{
+		uploads:  make(map[string]int),
+		failOnce: map[string]bool{"2": true},
+	}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	d, err := New(DriverParameters{
+		AccessKey:                     "key",
+		SecretKey:                     "secret",
+		Bucket:                        "bucket",
+		Region:                        "us-east-1",
+		RegionEndpoint:                server.URL,
+		ForcePathStyle:                true,
+		V4Auth:                        true,
+		ChunkSize:                     minChunkSize,
+		MultipartCombineSmallPart:     true,
+		StorageClass:                  noStorageClass,
+		ObjectACL:                     s3.ObjectCannedACLPrivate,
+		MultipartUploadMaxConcurrency: 4,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	w, err := d.Writer(ctx, "/blob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(make([]byte, 3*minChunkSize+1)); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(fake.completed, []string{"1", "2", "3"}) {
+		t.Fatalf("unexpected completed parts: %v", fake.completed)
+	}
+	// Only the failed part was uploaded again
+	if !reflect.DeepEqual(fake.uploads, map[string]int{"1": 1, "2": 2, "3": 1}) {
+		t.Fatalf("unexpected part uploads: %v", fake.uploads)
+	}
+}
+
+func TestObjectTags(t *testing.T) {
+	fake := &fakeMultipartS3{uploads: make(map[string]int)}
+	server := httptest.NewServer(fake)
+	defer server.Close()
+
+	d, err := New(DriverParameters{
+		AccessKey:                     "key",
+		SecretKey:                     "secret",
+		Bucket:                        "bucket",
+		Region:                        "us-east-1",
+		RegionEndpoint:                server.URL,
+		ForcePathStyle:                true,
+		V4Auth:                        true,
+		ChunkSize:                     minChunkSize,
+		StorageClass:                  noStorageClass,
+		ObjectACL:                     s3.ObjectCannedACLPrivate,
+		MultipartUploadMaxConcurrency: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := d.PutContent(ctx, "/untagged", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	ctx = storagedriver.WithObjectTags(ctx, map[string]string{"origin": "proxy", "namespace": "registry-1.docker.io:443"})
+	if err := d.PutContent(ctx, "/tagged", []byte("content")); err != nil {
+		t.Fatal(err)
+	}
+	w, err := d.Writer(ctx, "/blob", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Cancel(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	tagging := "namespace=registry-1.docker.io%3A443&origin=proxy"
+	if !reflect.DeepEqual(fake.tagging, []string{"", tagging, tagging}) {
+		t.Fatalf("unexpected tagging: %q", fake.tagging)
+	}
+}
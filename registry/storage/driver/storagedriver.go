@@ -111,6 +111,23 @@ type FileWriter interface {
 	Commit() error
 }
 
+// objectTagsKey is the context key of the tags of the objects written.
+type objectTagsKey struct{}
+
+// WithObjectTags returns a context with which the objects written are tagged
+// with tags, by the storage drivers supporting object tags; the other drivers
+// ignore them.
+func WithObjectTags(ctx context.Context, tags map[string]string) context.Context {
+	return context.WithValue(ctx, objectTagsKey{}, tags)
+}
+
+// ObjectTags returns the tags of the objects written with the context, nil
+// when the objects are not tagged.
+func ObjectTags(ctx context.Context) map[string]string {
+	tags, _ := ctx.Value(objectTagsKey{}).(map[string]string)
+	return tags
+}
+
 // PathRegexp is the regular expression which each file path must match. A
 // file path is absolute, beginning with a slash and containing a positive
 // number of path components separated by slashes, where each component is
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"sort"
+	"sync"
 
 	"github.com/sirupsen/logrus"
 )
@@ -64,3 +65,121 @@ func doWalkFallback(ctx context.Context, driver StorageDriver, from string, f Wa
 	}
 	return true, nil
 }
+
+// maxSplitDepth is the maximum depth down to which WalkParallel splits the
+// tree into subtrees walked in parallel.
+const maxSplitDepth = 3
+
+// WalkParallel traverses a filesystem defined within driver, starting from
+// the given path, calling f on each file, like the Walk method of the driver.
+// The tree is split into subtrees, listing directories until there are at
+// least concurrency of them or maxSplitDepth is reached, and up to
+// concurrency subtrees are walked at the same time with the Walk method of
+// the driver, which lists them in bulk when the driver supports it.
+//
+// Unlike Walk, f is called concurrently and the files are not visited in
+// order. If f returns ErrSkipDir for a file, only the walk of the subtree
+// holding the file stops. If f returns any other error, the walk stops and
+// the first error is returned.
+func WalkParallel(ctx context.Context, driver StorageDriver, from string, concurrency int, f WalkFn) error {
+	if concurrency <= 1 {
+		return driver.Walk(ctx, from, f)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs error
+		sem  = make(chan struct{}, concurrency)
+	)
+	fail := func(err error) {
+		mu.Lock()
+		if errs == nil {
+			errs = err
+		}
+		mu.Unlock()
+		cancel()
+	}
+
+	dirs := []string{from}
+	for depth := 0; depth < maxSplitDepth && len(dirs) > 0 && len(dirs) < concurrency; depth++ {
+		var subdirs []string
+		for _, dir := range dirs {
+			children, err := driver.List(ctx, dir)
+			if err != nil {
+				if _, ok := err.(PathNotFoundError); ok && dir != from {
+					continue
+				}
+				return err
+			}
+			sort.Stable(sort.StringSlice(children))
+
+			// Stat the children in parallel, as it is expensive with
+			// some drivers
+			infos := make([]FileInfo, len(children))
+			for i, child := range children {
+				sem <- struct{}{}
+				wg.Add(1)
+				go func(i int, child string) {
+					defer func() { <-sem; wg.Done() }()
+					fileInfo, err := driver.Stat(ctx, child)
+					if err != nil {
+						if _, ok := err.(PathNotFoundError); !ok {
+							fail(err)
+						}
+						return
+					}
+					infos[i] = fileInfo
+				}(i, child)
+			}
+			wg.Wait()
+			if errs != nil {
+				return errs
+			}
+
+			for _, fileInfo := range infos {
+				if fileInfo == nil {
+					// removed in between listing and enumeration
+					continue
+				}
+				err := f(fileInfo)
+				switch {
+				case err == ErrSkipDir && !fileInfo.IsDir():
+					return nil
+				case err == ErrSkipDir:
+				case err != nil:
+					return err
+				case fileInfo.IsDir():
+					subdirs = append(subdirs, fileInfo.Path())
+				}
+			}
+		}
+		dirs = subdirs
+	}
+
+	for _, dir := range dirs {
+		sem <- struct{}{}
+		if ctx.Err() != nil {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(dir string) {
+			defer func() { <-sem; wg.Done() }()
+			err := driver.Walk(ctx, dir, f)
+			if _, ok := err.(PathNotFoundError); ok {
+				// the subtree was removed, or it is an empty
+				// directory which some drivers do not track
+				err = nil
+			}
+			if err != nil {
+				fail(err)
+			}
+		}(dir)
+	}
+	wg.Wait()
+	return errs
+}
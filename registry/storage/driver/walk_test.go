@@ -3,7 +3,9 @@ package driver
 import (
 	"context"
 	"fmt"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -50,6 +52,10 @@ func (cfs *fileSystem) Stat(_ context.Context, path string) (FileInfo, error) {
 	}, nil
 }
 
+func (cfs *fileSystem) Walk(ctx context.Context, path string, f WalkFn) error {
+	return WalkFallback(ctx, cfs, path, f)
+}
+
 func (cfs *fileSystem) isDir(path string) bool {
 	_, isDir := cfs.fileset[path]
 	return isDir
@@ -181,3 +187,55 @@ func compareWalked(t *testing.T, expected, walked []string) {
 		}
 	}
 }
+
+func TestWalkParallel(t *testing.T) {
+	d := &fileSystem{
+		fileset: map[string][]string{
+			"/":                {"/file1", "/folder1", "/folder2"},
+			"/folder1":         {"/folder1/file1", "/folder1/folder1"},
+			"/folder1/folder1": {"/folder1/folder1/file1"},
+			"/folder2":         {"/folder2/file1"},
+		},
+	}
+
+	for _, concurrency := range []int{1, 2, 10} {
+		t.Run(fmt.Sprint(concurrency), func(t *testing.T) {
+			var (
+				mu     sync.Mutex
+				walked []string
+			)
+			err := WalkParallel(context.Background(), d, "/", concurrency, func(fileInfo FileInfo) error {
+				mu.Lock()
+				defer mu.Unlock()
+				walked = append(walked, fileInfo.Path())
+				if fileInfo.Path() == "/folder2" {
+					return ErrSkipDir
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(walked)
+			compareWalked(t, []string{
+				"/file1",
+				"/folder1",
+				"/folder1/file1",
+				"/folder1/folder1",
+				"/folder1/folder1/file1",
+				"/folder2",
+			}, walked)
+		})
+	}
+
+	errWalk := fmt.Errorf("walk error")
+	err := WalkParallel(context.Background(), d, "/", 4, func(fileInfo FileInfo) error {
+		if fileInfo.Path() == "/folder1/folder1/file1" {
+			return errWalk
+		}
+		return nil
+	})
+	if err != errWalk {
+		t.Fatalf("expected the error of the walk function, got %v", err)
+	}
+}
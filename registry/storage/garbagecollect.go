@@ -2,7 +2,13 @@ package storage
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/reference"
@@ -18,6 +24,37 @@ func emit(format string, a ...interface{}) {
 type GCOpts struct {
 	DryRun         bool
 	RemoveUntagged bool
+	// Concurrency is the number of parallel walks of the storage used to
+	// enumerate the repositories and blobs, and the number of repositories
+	// marked at the same time. Values up to 1 walk the storage serially.
+	Concurrency int
+	// Online runs the garbage collection while the registry serves pushes,
+	// which record the blobs they reference in the journal enabled with
+	// EnableGCJournal. The blobs and manifests referenced within
+	// GracePeriod before the garbage collection started are kept.
+	Online      bool
+	GracePeriod time.Duration
+	// Report receives a JSON report of the manifests and blobs eligible
+	// for deletion, a GCReport, instead of the progress of the garbage
+	// collection. Nothing is deleted when it is set.
+	Report io.Writer
+	// Repository limits the garbage collection to the named repository.
+	// Only its manifests are marked, and only the blobs it links which are
+	// not linked by any other repository are eligible for deletion, so
+	// that the blobs of the other repositories are not walked.
+	Repository string
+}
+
+// parallelRepositoryEnumerator enumerates the repositories with parallel
+// walks of the storage.
+type parallelRepositoryEnumerator interface {
+	enumerateParallel(ctx context.Context, concurrency int, ingester func(string) error) error
+}
+
+// parallelBlobEnumerator enumerates the blobs with parallel walks of the
+// storage.
+type parallelBlobEnumerator interface {
+	enumerateParallel(ctx context.Context, concurrency int, ingester func(digest.Digest) error) error
 }
 
 // ManifestDel contains manifest structure which will be deleted
@@ -34,10 +71,68 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		return fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
 	}
 
+	emit := emit
+	if opts.Report != nil {
+		emit = func(string, ...interface{}) {}
+	}
+
+	enumerateRepositories := repositoryEnumerator.Enumerate
+	if e, ok := registry.(parallelRepositoryEnumerator); ok && opts.Concurrency > 1 {
+		enumerateRepositories = func(ctx context.Context, ingester func(string) error) error {
+			return e.enumerateParallel(ctx, opts.Concurrency, ingester)
+		}
+	}
+
+	// In online mode, content referenced after cutoff is kept
+	cutoff := time.Now().Add(-opts.GracePeriod)
+	journal := &gcJournal{driver: storageDriver}
+	recentlyReferenced := func(contentPath string, index *linkIndex, dgst digest.Digest) (bool, error) {
+		if !opts.Online {
+			return false, nil
+		}
+		modTime, err := linkedAt(ctx, storageDriver, contentPath, index, dgst)
+		if err == nil && modTime.After(cutoff) {
+			return true, nil
+		} else if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			return false, err
+		}
+		return journal.referencedSince(ctx, dgst, cutoff)
+	}
+
 	// mark
+	var mu sync.Mutex // protects markSet, manifestArr, deleteSet and linkedBy
 	markSet := make(map[digest.Digest]struct{})
 	manifestArr := make([]ManifestDel, 0)
-	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+	// linkedBy maps the blobs to the repositories linking them, for the
+	// report
+	linkedBy := make(map[digest.Digest][]string)
+	markManifest := func(repoName string, manifestService distribution.ManifestService, dgst digest.Digest) error {
+		// Mark the manifest's blob
+		emit("%s: marking manifest %s ", repoName, dgst)
+		mu.Lock()
+		markSet[dgst] = struct{}{}
+		mu.Unlock()
+
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			if opts.Online && errors.As(err, &distribution.ErrManifestUnknownRevision{}) {
+				// deleted since it was enumerated
+				return nil
+			}
+			return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
+		}
+
+		descriptors := manifest.References()
+		mu.Lock()
+		for _, descriptor := range descriptors {
+			markSet[descriptor.Digest] = struct{}{}
+			emit("%s: marking blob %s", repoName, descriptor.Digest)
+		}
+		mu.Unlock()
+
+		return nil
+	}
+	markRepository := func(repoName string) error {
 		emit(repoName)
 
 		var err error
@@ -60,6 +155,22 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
 		}
 
+		if opts.Report != nil || opts.Repository != "" {
+			blobEnumerator, ok := repository.Blobs(ctx).(distribution.BlobEnumerator)
+			if !ok {
+				return fmt.Errorf("unable to convert BlobStore into BlobEnumerator")
+			}
+			err := blobEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+				mu.Lock()
+				linkedBy[dgst] = append(linkedBy[dgst], repoName)
+				mu.Unlock()
+				return nil
+			})
+			if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+				return fmt.Errorf("failed to enumerate the blobs of %s: %v", repoName, err)
+			}
+		}
+
 		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
 			if opts.RemoveUntagged {
 				// fetch all tags where this manifest is the latest one
@@ -68,6 +179,18 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 					return fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
 				}
 				if len(tags) == 0 {
+					revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: repoName, revision: dgst})
+					if err != nil {
+						return err
+					}
+					recent, err := recentlyReferenced(revisionPath, linkIndexOf(registry, repoName, linkIndexRevisions), dgst)
+					if err != nil {
+						return fmt.Errorf("failed to check the references of digest %v: %v", dgst, err)
+					}
+					if recent {
+						emit("%s: manifest %s referenced within the grace period", repoName, dgst)
+						return markManifest(repoName, manifestService, dgst)
+					}
 					emit("manifest eligible for deletion: %s", dgst)
 					// fetch all tags from repository
 					// all of these tags could contain manifest in history
@@ -76,26 +199,13 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 					if err != nil {
 						return fmt.Errorf("failed to retrieve tags %v", err)
 					}
+					mu.Lock()
 					manifestArr = append(manifestArr, ManifestDel{Name: repoName, Digest: dgst, Tags: allTags})
+					mu.Unlock()
 					return nil
 				}
 			}
-			// Mark the manifest's blob
-			emit("%s: marking manifest %s ", repoName, dgst)
-			markSet[dgst] = struct{}{}
-
-			manifest, err := manifestService.Get(ctx, dgst)
-			if err != nil {
-				return fmt.Errorf("failed to retrieve manifest for digest %v: %v", dgst, err)
-			}
-
-			descriptors := manifest.References()
-			for _, descriptor := range descriptors {
-				markSet[descriptor.Digest] = struct{}{}
-				emit("%s: marking blob %s", repoName, descriptor.Digest)
-			}
-
-			return nil
+			return markManifest(repoName, manifestService, dgst)
 		})
 
 		// In certain situations such as unfinished uploads, deleting all
@@ -108,24 +218,92 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		}
 
 		return err
-	})
+	}
+	var err error
+	if opts.Repository != "" {
+		err = markRepository(opts.Repository)
+	} else {
+		err = enumerateRepositories(ctx, markRepository)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to mark: %v", err)
 	}
 
+	// The manifests in the trash are kept until they are purged, so that
+	// they can be restored
+	trashed, err := ListTrash(ctx, storageDriver)
+	if err != nil {
+		return fmt.Errorf("failed to list the trash: %v", err)
+	}
+	for _, record := range trashed {
+		if opts.Repository != "" && record.Repository != opts.Repository {
+			continue
+		}
+		emit("%s: marking trashed manifest %s", record.Repository, record.Digest)
+		references, err := trashedReferences(ctx, storageDriver, record.Digest)
+		if err != nil {
+			return fmt.Errorf("failed to mark trashed manifest %s: %v", record.Digest, err)
+		}
+		markSet[record.Digest] = struct{}{}
+		for _, dgst := range references {
+			markSet[dgst] = struct{}{}
+		}
+	}
+
 	// sweep
 	vacuum := NewVacuum(ctx, storageDriver)
-	if !opts.DryRun {
+	if !opts.DryRun && opts.Report == nil {
 		for _, obj := range manifestArr {
+			if opts.Online {
+				// The manifest may have been tagged since it was
+				// marked, in which case its blobs are kept too
+				recent, err := journal.referencedSince(ctx, obj.Digest, cutoff)
+				if err != nil {
+					return fmt.Errorf("failed to check the references of digest %v: %v", obj.Digest, err)
+				}
+				if recent {
+					named, err := reference.WithName(obj.Name)
+					if err != nil {
+						return fmt.Errorf("failed to parse repo name %s: %v", obj.Name, err)
+					}
+					repository, err := registry.Repository(ctx, named)
+					if err != nil {
+						return fmt.Errorf("failed to construct repository: %v", err)
+					}
+					manifestService, err := repository.Manifests(ctx)
+					if err != nil {
+						return fmt.Errorf("failed to construct manifest service: %v", err)
+					}
+					if err := markManifest(obj.Name, manifestService, obj.Digest); err != nil {
+						return err
+					}
+					continue
+				}
+			}
 			err = vacuum.RemoveManifest(obj.Name, obj.Digest, obj.Tags)
 			if err != nil {
 				return fmt.Errorf("failed to delete manifest %s: %v", obj.Digest, err)
 			}
 		}
 	}
-	blobService := registry.Blobs()
+	var enumerateBlobs func(ctx context.Context, ingester func(digest.Digest) error) error
+	if opts.Repository != "" {
+		enumerateBlobs = func(ctx context.Context, ingester func(digest.Digest) error) error {
+			return enumerateRepositoryBlobs(ctx, storageDriver, registry, opts, manifestArr, linkedBy, ingester)
+		}
+	} else {
+		blobService := registry.Blobs()
+		enumerateBlobs = blobService.Enumerate
+		if e, ok := blobService.(parallelBlobEnumerator); ok && opts.Concurrency > 1 {
+			enumerateBlobs = func(ctx context.Context, ingester func(digest.Digest) error) error {
+				return e.enumerateParallel(ctx, opts.Concurrency, ingester)
+			}
+		}
+	}
 	deleteSet := make(map[digest.Digest]struct{})
-	err = blobService.Enumerate(ctx, func(dgst digest.Digest) error {
+	err = enumerateBlobs(ctx, func(dgst digest.Digest) error {
+		mu.Lock()
+		defer mu.Unlock()
 		// check if digest is in markSet. If not, delete it!
 		if _, ok := markSet[dgst]; !ok {
 			deleteSet[dgst] = struct{}{}
@@ -135,8 +313,31 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 	if err != nil {
 		return fmt.Errorf("error enumerating blobs: %v", err)
 	}
-	emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(deleteSet), len(manifestArr))
+	var blobsArr []digest.Digest
 	for dgst := range deleteSet {
+		// Blobs pushed, or referenced by pushes, since they were marked
+		// are kept
+		blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+		if err != nil {
+			return err
+		}
+		recent, err := recentlyReferenced(blobPath, nil, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to check the references of blob %s: %v", dgst, err)
+		}
+		if recent {
+			emit("blob referenced within the grace period: %s", dgst)
+			continue
+		}
+		blobsArr = append(blobsArr, dgst)
+	}
+
+	if opts.Report != nil {
+		return writeGCReport(ctx, storageDriver, opts.Report, len(markSet), manifestArr, blobsArr, linkedBy)
+	}
+
+	emit("\n%d blobs marked, %d blobs and %d manifests eligible for deletion", len(markSet), len(blobsArr), len(manifestArr))
+	for _, dgst := range blobsArr {
 		emit("blob eligible for deletion: %s", dgst)
 		if opts.DryRun {
 			continue
@@ -147,5 +348,113 @@ func MarkAndSweep(ctx context.Context, storageDriver driver.StorageDriver, regis
 		}
 	}
 
-	return err
+	if opts.Online && !opts.DryRun {
+		if err := journal.prune(ctx, cutoff); err != nil {
+			return fmt.Errorf("failed to prune the journal: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// enumerateRepositoryBlobs applies ingester to the blobs linked by the
+// repository of a repository-scoped garbage collection, which are listed in
+// linkedBy, and to its untagged manifests, when they are not linked by any
+// other repository.
+func enumerateRepositoryBlobs(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts GCOpts, manifestArr []ManifestDel, linkedBy map[digest.Digest][]string, ingester func(digest.Digest) error) error {
+	candidates := make(map[digest.Digest]struct{}, len(linkedBy)+len(manifestArr))
+	for dgst := range linkedBy {
+		candidates[dgst] = struct{}{}
+	}
+	for _, obj := range manifestArr {
+		candidates[obj.Digest] = struct{}{}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	// Only the names of the other repositories are enumerated, including
+	// the ones without manifests, and their links to the candidates checked
+	root, err := pathFor(repositoriesRootPathSpec{})
+	if err != nil {
+		return err
+	}
+	seen := make(map[string]struct{})
+	var others []string
+	err = storageDriver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		repoPath, file := path.Split(fileInfo.Path())
+		if !fileInfo.IsDir() || !strings.HasPrefix(file, "_") {
+			return nil
+		}
+		if file == "_manifests" || file == "_layers" {
+			repoName := strings.TrimSuffix(repoPath[len(root)+1:], "/")
+			if _, ok := seen[repoName]; !ok && repoName != opts.Repository {
+				seen[repoName] = struct{}{}
+				others = append(others, repoName)
+			}
+		}
+		return driver.ErrSkipDir
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return err
+	}
+
+	linkedElsewhere := func(dgst digest.Digest) (bool, error) {
+		for _, repoName := range others {
+			for kind, spec := range map[string]pathSpec{
+				linkIndexLayers:    layerLinkPathSpec{name: repoName, digest: dgst},
+				linkIndexRevisions: manifestRevisionLinkPathSpec{name: repoName, revision: dgst},
+			} {
+				linkPath, err := pathFor(spec)
+				if err != nil {
+					return false, err
+				}
+				_, err = linkedAt(ctx, storageDriver, linkPath, linkIndexOf(registry, repoName, kind), dgst)
+				if err == nil {
+					return true, nil
+				} else if _, ok := err.(driver.PathNotFoundError); !ok {
+					return false, err
+				}
+			}
+		}
+		return false, nil
+	}
+
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+	var (
+		wg    sync.WaitGroup
+		mu    sync.Mutex
+		first error
+		sem   = make(chan struct{}, concurrency)
+	)
+	for dgst := range candidates {
+		sem <- struct{}{}
+		mu.Lock()
+		failed := first != nil
+		mu.Unlock()
+		if failed {
+			<-sem
+			break
+		}
+		wg.Add(1)
+		go func(dgst digest.Digest) {
+			defer func() { <-sem; wg.Done() }()
+			linked, err := linkedElsewhere(dgst)
+			if err == nil && !linked {
+				err = ingester(dgst)
+			}
+			if err != nil {
+				mu.Lock()
+				if first == nil {
+					first = err
+				}
+				mu.Unlock()
+			}
+		}(dgst)
+	}
+	wg.Wait()
+	return first
 }
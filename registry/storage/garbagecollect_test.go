@@ -1,9 +1,12 @@
 package storage
 
 import (
+	"bytes"
+	"encoding/json"
 	"io"
 	"path"
 	"testing"
+	"time"
 
 	"github.com/distribution/distribution/v3"
 	"github.com/distribution/distribution/v3/context"
@@ -382,6 +385,234 @@ func TestDeletionHasEffect(t *testing.T) {
 	}
 }
 
+func TestParallelDeletionHasEffect(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	var kept, deleted []image
+	for _, name := range []string{"komnenos", "palaiologos", "doukas"} {
+		repo := makeRepository(t, registry, name)
+		manifests, _ := repo.Manifests(ctx)
+
+		kept = append(kept, uploadRandomSchema2Image(t, repo))
+		image := uploadRandomSchema2Image(t, repo)
+		manifests.Delete(ctx, image.manifestDigest)
+		deleted = append(deleted, image)
+	}
+
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		Concurrency: 4,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	for _, image := range kept {
+		if _, ok := blobs[image.manifestDigest]; !ok {
+			t.Fatalf("manifest %s is missing", image.manifestDigest)
+		}
+		for layer := range image.layers {
+			if _, ok := blobs[layer]; !ok {
+				t.Fatalf("layer %s is missing", layer)
+			}
+		}
+	}
+	for _, image := range deleted {
+		for layer := range image.layers {
+			if _, ok := blobs[layer]; ok {
+				t.Fatalf("layer %s is present", layer)
+			}
+		}
+	}
+}
+
+func TestOnlineGCKeepsRecentlyReferencedBlobs(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableGCJournal)
+	repo := makeRepository(t, registry, "angelos")
+	manifests, _ := repo.Manifests(ctx)
+
+	kept := uploadRandomSchema2Image(t, repo)
+	deleted := uploadRandomSchema2Image(t, repo)
+	manifests.Delete(ctx, deleted.manifestDigest)
+
+	// The pushes record the blobs they reference in the journal
+	for _, dgst := range []digest.Digest{kept.manifestDigest, getAnyKey(kept.layers)} {
+		journalPath, _ := pathFor(gcJournalEntryPathSpec{digest: dgst})
+		if _, err := inmemoryDriver.Stat(ctx, journalPath); err != nil {
+			t.Fatalf("expected %v to be recorded in the journal: %v", dgst, err)
+		}
+	}
+
+	// The layers of the deleted image were pushed within the grace period
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		Online:      true,
+		GracePeriod: time.Hour,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs := allBlobs(t, registry)
+	for layer := range deleted.layers {
+		if _, ok := blobs[layer]; !ok {
+			t.Fatalf("layer pushed within the grace period is missing: %v", layer)
+		}
+	}
+
+	// A push referencing one of the layers after they were pushed keeps it
+	time.Sleep(100 * time.Millisecond)
+	referenced := getAnyKey(deleted.layers)
+	journal := &gcJournal{driver: inmemoryDriver}
+	if err := journal.record(ctx, referenced); err != nil {
+		t.Fatal(err)
+	}
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		Online:      true,
+		GracePeriod: 50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs = allBlobs(t, registry)
+	for layer := range deleted.layers {
+		if _, ok := blobs[layer]; ok != (layer == referenced) {
+			t.Fatalf("unexpected presence %t of layer %v", ok, layer)
+		}
+	}
+	for layer := range kept.layers {
+		if _, ok := blobs[layer]; !ok {
+			t.Fatalf("referenced layer is missing: %v", layer)
+		}
+	}
+
+	// The journal is pruned once the grace period is over
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		Online: true,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	if _, ok := allBlobs(t, registry)[referenced]; ok {
+		t.Fatalf("layer referenced before the grace period is present: %v", referenced)
+	}
+	journalPath, _ := pathFor(gcJournalEntryPathSpec{digest: referenced})
+	if _, err := inmemoryDriver.Stat(ctx, journalPath); err == nil {
+		t.Error("expected the journal entry to be pruned")
+	}
+}
+
+func TestGCReport(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	repo := makeRepository(t, registry, "theodora")
+	manifests, _ := repo.Manifests(ctx)
+
+	kept := uploadRandomSchema2Image(t, repo)
+	deleted := uploadRandomSchema2Image(t, repo)
+	manifests.Delete(ctx, deleted.manifestDigest)
+	before := allBlobs(t, registry)
+
+	var buf bytes.Buffer
+	err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		Report: &buf,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	if after := allBlobs(t, registry); len(after) != len(before) {
+		t.Fatalf("expected no blob to be deleted, %d of %d left", len(after), len(before))
+	}
+
+	var report GCReport
+	if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+		t.Fatalf("invalid report %q: %v", buf.String(), err)
+	}
+	// The layers and the config of the deleted image, and the deleted
+	// manifest itself
+	if report.Summary.Blobs != len(deleted.layers)+1 || len(report.Blobs) != report.Summary.Blobs {
+		t.Fatalf("unexpected blobs in report: %+v", report)
+	}
+	var size int64
+	for _, blob := range report.Blobs {
+		if _, ok := kept.layers[blob.Digest]; ok || blob.Digest == kept.manifestDigest {
+			t.Errorf("referenced blob %s reported", blob.Digest)
+		}
+		if blob.Size <= 0 {
+			t.Errorf("unexpected size %d of blob %s", blob.Size, blob.Digest)
+		}
+		size += blob.Size
+	}
+	if report.Summary.ReclaimableBytes != size {
+		t.Errorf("expected %d reclaimable bytes, got %d", size, report.Summary.ReclaimableBytes)
+	}
+	// The deleted manifest is not linked by the repository anymore
+	if len(report.Repositories) != 1 || report.Repositories[0].Name != "theodora" || report.Repositories[0].Blobs != len(deleted.layers) {
+		t.Errorf("unexpected repositories in report: %+v", report.Repositories)
+	}
+}
+
+func TestRepositoryScopedGC(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver)
+	scratch := makeRepository(t, registry, "ci/scratch")
+	base := makeRepository(t, registry, "base")
+	manifests, _ := scratch.Manifests(ctx)
+
+	kept := uploadRandomSchema2Image(t, scratch)
+	deleted := uploadRandomSchema2Image(t, scratch)
+	manifests.Delete(ctx, deleted.manifestDigest)
+
+	// One of the layers of the deleted image is linked by another
+	// repository, which also holds a blob referenced by no manifest
+	shared := getAnyKey(deleted.layers)
+	if _, err := deleted.layers[shared].Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(base, map[digest.Digest]io.ReadSeeker{shared: deleted.layers[shared]}); err != nil {
+		t.Fatal(err)
+	}
+	orphans, err := testutil.CreateRandomLayers(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(base, orphans); err != nil {
+		t.Fatal(err)
+	}
+
+	err = MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{
+		Repository:  "ci/scratch",
+		Concurrency: 2,
+	})
+	if err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+
+	blobs := allBlobs(t, registry)
+	for layer := range kept.layers {
+		if _, ok := blobs[layer]; !ok {
+			t.Errorf("referenced layer is missing: %v", layer)
+		}
+	}
+	for layer := range deleted.layers {
+		if _, ok := blobs[layer]; ok != (layer == shared) {
+			t.Errorf("unexpected presence %t of layer %v", ok, layer)
+		}
+	}
+	for orphan := range orphans {
+		if _, ok := blobs[orphan]; !ok {
+			t.Errorf("blob of another repository is missing: %v", orphan)
+		}
+	}
+}
+
 func getAnyKey(digests map[digest.Digest]io.ReadSeeker) (d digest.Digest) {
 	for d = range digests {
 		break
@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"path"
+	"time"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// gcJournal records when blobs were last referenced by the registry, when
+// they are linked into a repository or referenced by a manifest being put.
+// An online garbage collection keeps the blobs referenced within its grace
+// period, so that it does not delete the blobs referenced by pushes made
+// after it marked the blobs in use.
+type gcJournal struct {
+	driver driver.StorageDriver
+}
+
+// record records that the blobs were referenced now.
+func (j *gcJournal) record(ctx context.Context, dgsts ...digest.Digest) error {
+	now := []byte(time.Now().UTC().Format(time.RFC3339Nano))
+	for _, dgst := range dgsts {
+		entryPath, err := pathFor(gcJournalEntryPathSpec{digest: dgst})
+		if err != nil {
+			return err
+		}
+		if err := j.driver.PutContent(ctx, entryPath, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// referencedSince reports whether the blob was referenced since t.
+func (j *gcJournal) referencedSince(ctx context.Context, dgst digest.Digest, t time.Time) (bool, error) {
+	entryPath, err := pathFor(gcJournalEntryPathSpec{digest: dgst})
+	if err != nil {
+		return false, err
+	}
+	content, err := j.driver.GetContent(ctx, entryPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return false, nil
+		}
+		return false, err
+	}
+	referencedAt, err := time.Parse(time.RFC3339Nano, string(content))
+	if err != nil {
+		// A corrupted entry is kept on the safe side
+		return true, nil
+	}
+	return !referencedAt.Before(t), nil
+}
+
+// prune removes the entries of the blobs last referenced before t.
+func (j *gcJournal) prune(ctx context.Context, t time.Time) error {
+	root, err := pathFor(gcJournalPathSpec{})
+	if err != nil {
+		return err
+	}
+
+	var expired []string
+	err = j.driver.Walk(ctx, root, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() {
+			return nil
+		}
+		content, err := j.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				return nil
+			}
+			return err
+		}
+		referencedAt, err := time.Parse(time.RFC3339Nano, string(content))
+		if err == nil && referencedAt.Before(t) {
+			expired = append(expired, path.Dir(fileInfo.Path()))
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	for _, entry := range expired {
+		if err := j.driver.Delete(ctx, entry); err != nil {
+			if _, ok := err.(driver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+	}
+	return nil
+}
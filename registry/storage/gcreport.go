@@ -0,0 +1,160 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// GCReport is a machine-readable report of the content a garbage collection
+// would delete, written instead of deleting it when GCOpts.Report is set.
+type GCReport struct {
+	Manifests    []GCReportManifest   `json:"manifests"`
+	Blobs        []GCReportBlob       `json:"blobs"`
+	Repositories []GCReportRepository `json:"repositories"`
+	Summary      GCReportSummary      `json:"summary"`
+}
+
+// GCReportManifest is an untagged manifest eligible for deletion.
+type GCReportManifest struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	Size       int64         `json:"size"`
+}
+
+// GCReportBlob is a blob eligible for deletion, along with the repositories
+// linking it.
+type GCReportBlob struct {
+	Digest       digest.Digest `json:"digest"`
+	Size         int64         `json:"size"`
+	Repositories []string      `json:"repositories,omitempty"`
+}
+
+// GCReportRepository is the space reclaimable from a repository, which is
+// the size of the blobs eligible for deletion that the repository links.
+// Blobs linked by several repositories are accounted to each of them.
+type GCReportRepository struct {
+	Name             string `json:"name"`
+	Manifests        int    `json:"manifests"`
+	Blobs            int    `json:"blobs"`
+	ReclaimableBytes int64  `json:"reclaimable_bytes"`
+}
+
+// GCReportSummary summarizes a garbage collection report.
+type GCReportSummary struct {
+	MarkedBlobs      int   `json:"marked_blobs"`
+	Manifests        int   `json:"manifests"`
+	Blobs            int   `json:"blobs"`
+	ReclaimableBytes int64 `json:"reclaimable_bytes"`
+}
+
+// writeGCReport writes the report of the manifests and blobs eligible for
+// deletion to w, as JSON. linkedBy maps the digests of the blobs to the
+// repositories linking them.
+func writeGCReport(ctx context.Context, storageDriver driver.StorageDriver, w io.Writer, marked int, manifests []ManifestDel, blobs []digest.Digest, linkedBy map[digest.Digest][]string) error {
+	report := GCReport{
+		Manifests:    []GCReportManifest{},
+		Blobs:        []GCReportBlob{},
+		Repositories: []GCReportRepository{},
+		Summary: GCReportSummary{
+			MarkedBlobs: marked,
+			Manifests:   len(manifests),
+			Blobs:       len(blobs),
+		},
+	}
+	repositories := make(map[string]*GCReportRepository)
+	repository := func(name string) *GCReportRepository {
+		r, ok := repositories[name]
+		if !ok {
+			r = &GCReportRepository{Name: name}
+			repositories[name] = r
+		}
+		return r
+	}
+
+	// The manifests are linked by their repository as revisions
+	for _, m := range manifests {
+		if !containsString(linkedBy[m.Digest], m.Name) {
+			linkedBy[m.Digest] = append(linkedBy[m.Digest], m.Name)
+		}
+	}
+
+	sizes := make(map[digest.Digest]int64, len(blobs))
+	for _, dgst := range blobs {
+		size, err := blobSize(ctx, storageDriver, dgst)
+		if err != nil {
+			return err
+		}
+		sizes[dgst] = size
+
+		repos := linkedBy[dgst]
+		sort.Strings(repos)
+		report.Blobs = append(report.Blobs, GCReportBlob{Digest: dgst, Size: size, Repositories: repos})
+		report.Summary.ReclaimableBytes += size
+		for _, name := range repos {
+			r := repository(name)
+			r.Blobs++
+			r.ReclaimableBytes += size
+		}
+	}
+	sort.Slice(report.Blobs, func(i, j int) bool {
+		return report.Blobs[i].Digest < report.Blobs[j].Digest
+	})
+
+	for _, m := range manifests {
+		size, ok := sizes[m.Digest]
+		if !ok {
+			var err error
+			if size, err = blobSize(ctx, storageDriver, m.Digest); err != nil {
+				return err
+			}
+		}
+		report.Manifests = append(report.Manifests, GCReportManifest{Repository: m.Name, Digest: m.Digest, Size: size})
+		repository(m.Name).Manifests++
+	}
+	sort.Slice(report.Manifests, func(i, j int) bool {
+		a, b := report.Manifests[i], report.Manifests[j]
+		return a.Repository < b.Repository || a.Repository == b.Repository && a.Digest < b.Digest
+	})
+
+	for _, r := range repositories {
+		report.Repositories = append(report.Repositories, *r)
+	}
+	sort.Slice(report.Repositories, func(i, j int) bool {
+		return report.Repositories[i].Name < report.Repositories[j].Name
+	})
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(report)
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// blobSize returns the size of the blob, or zero if it does not exist
+// anymore.
+func blobSize(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) (int64, error) {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return 0, err
+	}
+	fi, err := storageDriver.Stat(ctx, blobPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return fi.Size(), nil
+}
@@ -0,0 +1,366 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	prometheus "github.com/distribution/distribution/v3/metrics"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+var (
+	// integrityBlobsCounter counts the blobs re-hashed by the integrity
+	// checks, by result
+	integrityBlobsCounter = prometheus.StorageNamespace.NewLabeledCounter("integrity_blobs", "The number of blobs re-hashed by the integrity checks, by result", "result")
+
+	// integrityBytesCounter counts the bytes re-hashed by the integrity
+	// checks
+	integrityBytesCounter = prometheus.StorageNamespace.NewCounter("integrity_bytes", "The number of bytes re-hashed by the integrity checks")
+
+	// integrityBrokenManifestsCounter counts the manifests found referencing
+	// missing or corrupt content
+	integrityBrokenManifestsCounter = prometheus.StorageNamespace.NewCounter("integrity_broken_manifests", "The number of manifests found by the integrity checks referencing missing or corrupt content")
+)
+
+// IntegrityListener is notified of the corrupt blobs linked by the
+// repositories. notifications.IntegrityListener implements it.
+type IntegrityListener interface {
+	BlobCorrupt(repo reference.Named, desc distribution.Descriptor) error
+}
+
+// IntegrityOpts contains the options of VerifyIntegrity.
+type IntegrityOpts struct {
+	// Sample is the fraction of the blobs re-hashed, chosen at random, so
+	// that successive checks cover the storage over time. All blobs are
+	// re-hashed when it is 0 or 1.
+	Sample float64
+	// Quarantine moves the corrupt blobs out of the blob store, so they are
+	// no longer served, instead of only reporting them.
+	Quarantine bool
+	// AllowMissing does not report the blobs and child manifests missing
+	// from the repositories, as in pull through caches, which cache
+	// manifests before their blobs.
+	AllowMissing bool
+	// Concurrency is the number of blobs re-hashed, and of parallel walks
+	// of the storage, at the same time.
+	Concurrency int
+	// Listener, if set, is notified of the corrupt blobs of each
+	// repository linking them.
+	Listener IntegrityListener
+}
+
+// IntegrityReport lists the corrupt content found by VerifyIntegrity.
+type IntegrityReport struct {
+	// Blobs is the number of blobs re-hashed
+	Blobs int `json:"blobs"`
+	// Bytes is the size of the blobs re-hashed
+	Bytes int64 `json:"bytes"`
+	// Corrupt are the blobs whose content does not match their digest
+	Corrupt []CorruptBlob `json:"corrupt"`
+	// BrokenManifests are the manifests which are missing, corrupt, or
+	// reference missing or corrupt content
+	BrokenManifests []BrokenManifest `json:"broken_manifests"`
+}
+
+// CorruptBlob is a blob whose content does not match its digest.
+type CorruptBlob struct {
+	Digest digest.Digest `json:"digest"`
+	// Actual is the digest of the content of the blob
+	Actual      digest.Digest `json:"actual"`
+	Size        int64         `json:"size"`
+	Quarantined bool          `json:"quarantined"`
+}
+
+// BrokenManifest is a manifest of a repository which is missing or corrupt,
+// or references missing or corrupt content.
+type BrokenManifest struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	// Blobs are the missing or corrupt blobs, which include the manifest
+	// itself if it is
+	Blobs []digest.Digest `json:"blobs"`
+}
+
+// VerifyIntegrity re-hashes a sample, or all, of the blobs stored against
+// their digests, then checks that the content linked by the manifests of
+// each repository is present and not corrupt. The corrupt blobs are moved
+// to the quarantine if opts.Quarantine is set.
+func VerifyIntegrity(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts IntegrityOpts) (*IntegrityReport, error) {
+	if opts.Sample < 0 || opts.Sample > 1 {
+		return nil, fmt.Errorf("invalid integrity sample %v, must be between 0 and 1", opts.Sample)
+	}
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	report := &IntegrityReport{Corrupt: []CorruptBlob{}, BrokenManifests: []BrokenManifest{}}
+	corrupt, err := verifyBlobs(ctx, storageDriver, opts, concurrency, report)
+	if err != nil {
+		return report, err
+	}
+
+	err = repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		return verifyRepository(ctx, storageDriver, registry, repoName, corrupt, opts, report)
+	})
+	// A registry without repositories has no repositories directory
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	return report, err
+}
+
+// verifyBlobs re-hashes the sampled blobs and returns the corrupt ones.
+func verifyBlobs(ctx context.Context, storageDriver driver.StorageDriver, opts IntegrityOpts, concurrency int, report *IntegrityReport) (map[digest.Digest]int64, error) {
+	blobsPath, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	var mu sync.Mutex
+	var sampled []driver.FileInfo
+	err = driver.WalkParallel(ctx, storageDriver, blobsPath, concurrency, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "data" {
+			return nil
+		}
+		if opts.Sample > 0 && opts.Sample < 1 && rand.Float64() >= opts.Sample {
+			return nil
+		}
+		mu.Lock()
+		sampled = append(sampled, fileInfo)
+		mu.Unlock()
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return nil, fmt.Errorf("failed to walk the blobs: %v", err)
+	}
+
+	corrupt := make(map[digest.Digest]int64)
+	work := make(chan driver.FileInfo)
+	errs := make(chan error, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for fileInfo := range work {
+				blob, err := verifyBlob(ctx, storageDriver, fileInfo, opts.Quarantine)
+				if err != nil {
+					errs <- err
+					// drain the work so that it can still be queued
+					for range work {
+					}
+					return
+				}
+				mu.Lock()
+				report.Blobs++
+				report.Bytes += fileInfo.Size()
+				if blob != nil {
+					report.Corrupt = append(report.Corrupt, *blob)
+					corrupt[blob.Digest] = blob.Size
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, fileInfo := range sampled {
+		work <- fileInfo
+	}
+	close(work)
+	wg.Wait()
+	close(errs)
+	if err := <-errs; err != nil {
+		return nil, err
+	}
+
+	sort.Slice(report.Corrupt, func(i, j int) bool {
+		return report.Corrupt[i].Digest < report.Corrupt[j].Digest
+	})
+	return corrupt, nil
+}
+
+// verifyBlob re-hashes the data of a blob, returning it if it is corrupt.
+func verifyBlob(ctx context.Context, storageDriver driver.StorageDriver, fileInfo driver.FileInfo, quarantine bool) (*CorruptBlob, error) {
+	dgst, err := digestFromPath(fileInfo.Path())
+	if err != nil {
+		return nil, err
+	}
+	reader, err := storageDriver.Reader(ctx, fileInfo.Path(), 0)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			// deleted since the walk
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer reader.Close()
+
+	digester := dgst.Algorithm().Digester()
+	if _, err := io.Copy(digester.Hash(), reader); err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %v", dgst, err)
+	}
+	integrityBytesCounter.Inc(float64(fileInfo.Size()))
+	actual := digester.Digest()
+	if actual == dgst {
+		integrityBlobsCounter.WithValues("ok").Inc(1)
+		return nil, nil
+	}
+	integrityBlobsCounter.WithValues("corrupt").Inc(1)
+
+	blob := &CorruptBlob{Digest: dgst, Actual: actual, Size: fileInfo.Size()}
+	if quarantine {
+		quarantinePath, err := pathFor(quarantineDataPathSpec{digest: dgst})
+		if err != nil {
+			return nil, err
+		}
+		if err := storageDriver.Move(ctx, fileInfo.Path(), quarantinePath); err != nil {
+			return nil, fmt.Errorf("failed to quarantine blob %s: %v", dgst, err)
+		}
+		blob.Quarantined = true
+	}
+	return blob, nil
+}
+
+// verifyRepository notifies the corrupt blobs linked by the repository and
+// reports its broken manifests.
+func verifyRepository(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, repoName string, corrupt map[digest.Digest]int64, opts IntegrityOpts, report *IntegrityReport) error {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return fmt.Errorf("failed to construct repository: %v", err)
+	}
+
+	if opts.Listener != nil && len(corrupt) > 0 {
+		// The links are read directly, as the repository no longer lists
+		// the quarantined blobs
+		notified := make(map[digest.Digest]struct{})
+		notify := func(dgst digest.Digest) error {
+			size, ok := corrupt[dgst]
+			if _, done := notified[dgst]; !ok || done {
+				return nil
+			}
+			notified[dgst] = struct{}{}
+			return opts.Listener.BlobCorrupt(named, distribution.Descriptor{Digest: dgst, Size: size})
+		}
+		for kind, spec := range map[string]pathSpec{
+			linkIndexLayers:    layersPathSpec{name: repoName},
+			linkIndexRevisions: manifestRevisionsPathSpec{name: repoName},
+		} {
+			linksPath, err := pathFor(spec)
+			if err != nil {
+				return err
+			}
+			err = storageDriver.Walk(ctx, linksPath, func(fileInfo driver.FileInfo) error {
+				if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+					return nil
+				}
+				content, err := storageDriver.GetContent(ctx, fileInfo.Path())
+				if err != nil {
+					return err
+				}
+				dgst, err := digest.Parse(string(content))
+				if err != nil {
+					return nil
+				}
+				return notify(dgst)
+			})
+			if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+				return fmt.Errorf("failed to walk the links of %s: %v", repoName, err)
+			}
+			err = linkIndexOf(registry, repoName, kind).enumerate(ctx, func(entry linkIndexEntry) error {
+				return notify(entry.target())
+			})
+			if err != nil {
+				return fmt.Errorf("failed to read the link index of %s: %v", repoName, err)
+			}
+		}
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		broken, err := brokenReferences(ctx, manifestService, repository.Blobs(ctx), dgst, corrupt, opts.AllowMissing)
+		if err != nil {
+			return fmt.Errorf("failed to verify manifest %s@%s: %v", repoName, dgst, err)
+		}
+		if len(broken) > 0 {
+			integrityBrokenManifestsCounter.Inc(1)
+			report.BrokenManifests = append(report.BrokenManifests, BrokenManifest{Repository: repoName, Digest: dgst, Blobs: broken})
+		}
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return err
+	}
+	return nil
+}
+
+// brokenReferences returns the missing or corrupt content of the manifest,
+// including the manifest itself.
+func brokenReferences(ctx context.Context, manifests distribution.ManifestService, blobs distribution.BlobStatter, dgst digest.Digest, corrupt map[digest.Digest]int64, allowMissing bool) ([]digest.Digest, error) {
+	if _, ok := corrupt[dgst]; ok {
+		return []digest.Digest{dgst}, nil
+	}
+	manifest, err := manifests.Get(ctx, dgst)
+	if err != nil {
+		if _, ok := err.(distribution.ErrManifestUnknownRevision); ok || err == distribution.ErrBlobUnknown {
+			return []digest.Digest{dgst}, nil
+		}
+		return nil, err
+	}
+
+	_, isIndex := manifest.(*manifestlist.DeserializedManifestList)
+	var broken []digest.Digest
+	for _, desc := range manifest.References() {
+		if len(desc.URLs) > 0 {
+			// foreign layers are not stored
+			continue
+		}
+		if _, ok := corrupt[desc.Digest]; ok {
+			broken = append(broken, desc.Digest)
+			continue
+		}
+		if allowMissing {
+			continue
+		}
+		var exists bool
+		if isIndex {
+			exists, err = manifests.Exists(ctx, desc.Digest)
+		} else {
+			_, err = blobs.Stat(ctx, desc.Digest)
+			exists = err == nil
+			if err == distribution.ErrBlobUnknown {
+				err = nil
+			}
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !exists {
+			broken = append(broken, desc.Digest)
+		}
+	}
+	return broken, nil
+}
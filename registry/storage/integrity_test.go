@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+type corruptListener map[string][]digest.Digest
+
+func (l corruptListener) BlobCorrupt(repo reference.Named, desc distribution.Descriptor) error {
+	l[repo.Name()] = append(l[repo.Name()], desc.Digest)
+	return nil
+}
+
+func TestVerifyIntegrity(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	registry := createRegistry(t, inmemoryDriver)
+
+	repoA := makeRepository(t, registry, "a")
+	imageA := uploadRandomSchema2Image(t, repoA)
+	repoB := makeRepository(t, registry, "b")
+	uploadRandomSchema2Image(t, repoB)
+
+	report, err := VerifyIntegrity(ctx, inmemoryDriver, registry, IntegrityOpts{Concurrency: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Blobs != len(allBlobs(t, registry)) || len(report.Corrupt) != 0 || len(report.BrokenManifests) != 0 {
+		t.Fatalf("unexpected report of intact content %+v", report)
+	}
+
+	// the bits of a layer of a rot
+	layer := getAnyKey(imageA.layers)
+	dataPath, err := pathFor(blobDataPathSpec{digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content, err := inmemoryDriver.GetContent(ctx, dataPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	content[0] ^= 0xff
+	if err := inmemoryDriver.PutContent(ctx, dataPath, content); err != nil {
+		t.Fatal(err)
+	}
+
+	listener := corruptListener{}
+	report, err = VerifyIntegrity(ctx, inmemoryDriver, registry, IntegrityOpts{Quarantine: true, Listener: listener})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Corrupt) != 1 || report.Corrupt[0].Digest != layer || report.Corrupt[0].Actual != digest.FromBytes(content) || !report.Corrupt[0].Quarantined {
+		t.Fatalf("unexpected corrupt blobs %+v", report.Corrupt)
+	}
+	if len(report.BrokenManifests) != 1 || report.BrokenManifests[0].Repository != "a" || report.BrokenManifests[0].Digest != imageA.manifestDigest {
+		t.Fatalf("unexpected broken manifests %+v", report.BrokenManifests)
+	}
+	if len(listener) != 1 || len(listener["a"]) != 1 || listener["a"][0] != layer {
+		t.Fatalf("unexpected notifications %v", listener)
+	}
+
+	// the quarantined blob is no longer served, and still reported missing
+	if _, err := repoA.Blobs(ctx).Stat(ctx, layer); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected the quarantined blob to be unknown, got %v", err)
+	}
+	quarantinePath, err := pathFor(quarantineDataPathSpec{digest: layer})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inmemoryDriver.Stat(ctx, quarantinePath); err != nil {
+		t.Fatalf("expected the blob in the quarantine: %v", err)
+	}
+	report, err = VerifyIntegrity(ctx, inmemoryDriver, registry, IntegrityOpts{Sample: 0.5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Corrupt) != 0 || len(report.BrokenManifests) != 1 || report.BrokenManifests[0].Blobs[0] != layer {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	report, err = VerifyIntegrity(ctx, inmemoryDriver, registry, IntegrityOpts{AllowMissing: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.BrokenManifests) != 0 {
+		t.Fatalf("unexpected broken manifests %+v", report.BrokenManifests)
+	}
+
+	if _, err := VerifyIntegrity(ctx, inmemoryDriver, registry, IntegrityOpts{Sample: 2}); err == nil {
+		t.Fatal("expected an error for an invalid sample")
+	}
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+)
+
+// LayoutOpts contains the options of MigrateLayout.
+type LayoutOpts struct {
+	// Version is the layout the links are migrated to: 3 packs the link
+	// files into the index of the repositories, 2 unpacks the index back
+	// into link files.
+	Version int
+	// Repository restricts the migration to the repositories matching this
+	// path.Match pattern, all when empty.
+	Repository string
+	// DryRun counts the links without migrating them.
+	DryRun bool
+}
+
+// LayoutReport reports the links migrated by MigrateLayout.
+type LayoutReport struct {
+	// Repositories is the number of repositories migrated
+	Repositories int `json:"repositories"`
+	// Links is the number of links packed or unpacked
+	Links int `json:"links"`
+}
+
+// MigrateLayout migrates the links of the repositories between the layout of
+// link files and the packed layout. Packing requires a registry created with
+// EnablePackedLinks, and can run online with registries which all have it
+// enabled; it is also run periodically to pack the links written since.
+// Unpacking writes the links back as link files before the packed layout is
+// disabled, and should run while the registry is read-only.
+func MigrateLayout(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts LayoutOpts) (*LayoutReport, error) {
+	if opts.Version != 2 && opts.Version != 3 {
+		return nil, fmt.Errorf("unsupported layout version %d", opts.Version)
+	}
+	if _, err := path.Match(opts.Repository, ""); err != nil {
+		return nil, fmt.Errorf("invalid repository %q: %v", opts.Repository, err)
+	}
+	segments := newLinkSegmentCache()
+	if index := linkIndexOf(registry, "", linkIndexLayers); index != nil {
+		segments = index.segments
+	} else if opts.Version == 3 {
+		return nil, fmt.Errorf("the packed layout of the links is not enabled")
+	}
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	report := &LayoutReport{}
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		if opts.Repository != "" {
+			if matched, _ := path.Match(opts.Repository, repoName); !matched {
+				return nil
+			}
+		}
+		for _, kind := range []string{linkIndexLayers, linkIndexRevisions} {
+			index := &linkIndex{driver: storageDriver, name: repoName, kind: kind, segments: segments}
+			var links int
+			var err error
+			if opts.Version == 3 {
+				links, err = index.pack(ctx, opts.DryRun)
+			} else {
+				links, err = index.unpack(ctx, opts.DryRun)
+			}
+			report.Links += links
+			if err != nil {
+				return fmt.Errorf("failed to migrate the %s links of %s: %v", kind, repoName, err)
+			}
+		}
+		report.Repositories++
+		return nil
+	})
+	// A registry without repositories has no repositories directory
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	return report, err
+}
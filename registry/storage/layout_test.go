@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestMigrateLayout(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	registry := createRegistry(t, inmemoryDriver, EnablePackedLinks)
+
+	repo := makeRepository(t, registry, "packed")
+	image := uploadRandomSchema2Image(t, repo)
+	other := uploadRandomSchema2Image(t, repo)
+	before := allBlobs(t, registry)
+
+	report, err := MigrateLayout(ctx, inmemoryDriver, registry, LayoutOpts{Version: 3, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Repositories != 1 || report.Links != 7 {
+		t.Fatalf("unexpected dry run report %+v", report)
+	}
+	report, err = MigrateLayout(ctx, inmemoryDriver, registry, LayoutOpts{Version: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Links != 7 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	layer := getAnyKey(image.layers)
+	linkPath, err := blobLinkPath("packed", layer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inmemoryDriver.Stat(ctx, linkPath); err == nil {
+		t.Fatal("expected the link file to be packed")
+	}
+
+	// the links packed are served, and enumerated
+	if _, err := repo.Blobs(ctx).Stat(ctx, layer); err != nil {
+		t.Fatalf("expected the packed layer to be linked: %v", err)
+	}
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := manifests.Get(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("expected the packed manifest to be linked: %v", err)
+	}
+	var enumerated []digest.Digest
+	err = manifests.(distribution.ManifestEnumerator).Enumerate(ctx, func(dgst digest.Digest) error {
+		enumerated = append(enumerated, dgst)
+		return nil
+	})
+	if err != nil || len(enumerated) != 2 {
+		t.Fatalf("unexpected manifests enumerated %v: %v", enumerated, err)
+	}
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{}); err != nil {
+		t.Fatal(err)
+	}
+	if after := allBlobs(t, registry); len(after) != len(before) {
+		t.Fatalf("expected the garbage collection to keep the packed content, %d blobs of %d", len(after), len(before))
+	}
+
+	// removing a packed link writes a tombstone, applied by the next packing
+	if err := manifests.Delete(ctx, other.manifestDigest); err != nil {
+		t.Fatal(err)
+	}
+	if exists, err := manifests.Exists(ctx, other.manifestDigest); err != nil || exists {
+		t.Fatalf("expected the manifest to be removed: %v", err)
+	}
+	tombstonePath, err := pathFor(linkIndexTombstonePathSpec{name: "packed", kind: linkIndexRevisions, digest: other.manifestDigest})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inmemoryDriver.Stat(ctx, tombstonePath); err != nil {
+		t.Fatalf("expected a tombstone: %v", err)
+	}
+
+	// links written since are read from their link files, then packed
+	newer := uploadRandomSchema2Image(t, repo)
+	if exists, err := manifests.Exists(ctx, newer.manifestDigest); err != nil || !exists {
+		t.Fatalf("expected the manifest to be linked: %v", err)
+	}
+	report, err = MigrateLayout(ctx, inmemoryDriver, registry, LayoutOpts{Version: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Links != 4 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if _, err := inmemoryDriver.Stat(ctx, tombstonePath); err == nil {
+		t.Fatal("expected the tombstone to be applied")
+	}
+	if exists, err := manifests.Exists(ctx, other.manifestDigest); err != nil || exists {
+		t.Fatalf("expected the manifest to stay removed: %v", err)
+	}
+	if exists, err := manifests.Exists(ctx, newer.manifestDigest); err != nil || !exists {
+		t.Fatalf("expected the manifest to be packed: %v", err)
+	}
+
+	// registries without the packed layout do not see the index, until it
+	// is unpacked
+	unpacked := makeRepository(t, createRegistry(t, inmemoryDriver), "packed")
+	if _, err := unpacked.Blobs(ctx).Stat(ctx, layer); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected the packed layer to be unknown, got %v", err)
+	}
+	if _, err := MigrateLayout(ctx, inmemoryDriver, createRegistry(t, inmemoryDriver), LayoutOpts{Version: 3}); err == nil {
+		t.Fatal("expected packing to require the packed layout")
+	}
+	report, err = MigrateLayout(ctx, inmemoryDriver, registry, LayoutOpts{Version: 2, Repository: "pack*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Links != 9 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if _, err := unpacked.Blobs(ctx).Stat(ctx, layer); err != nil {
+		t.Fatalf("expected the unpacked layer to be linked: %v", err)
+	}
+	indexPath, err := pathFor(linkIndexPathSpec{name: "packed", kind: linkIndexLayers})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := inmemoryDriver.Stat(ctx, indexPath); err == nil {
+		t.Fatal("expected the index to be removed")
+	} else if _, ok := err.(driver.PathNotFoundError); !ok {
+		t.Fatal(err)
+	}
+}
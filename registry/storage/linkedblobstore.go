@@ -33,6 +33,10 @@ type linkedBlobStore struct {
 	deleteEnabled          bool
 	resumableDigestEnabled bool
 
+	// algorithm overrides the algorithm of the digests computed for new
+	// content, if set
+	algorithm digest.Algorithm
+
 	// linkPath allows one to control the repository blob link set to which
 	// the blob store dispatches. This is required because manifest and layer
 	// blobs have not yet been fully merged. At some point, this functionality
@@ -41,9 +45,15 @@ type linkedBlobStore struct {
 
 	// linkDirectoryPathSpec locates the root directories in which one might find links
 	linkDirectoryPathSpec pathSpec
+
+	// index holds the links packed, nil if the links are not packed
+	index *linkIndex
 }
 
-var _ distribution.BlobStore = &linkedBlobStore{}
+var (
+	_ distribution.BlobStore               = &linkedBlobStore{}
+	_ distribution.DigestAlgorithmProvider = &linkedBlobStore{}
+)
 
 func (lbs *linkedBlobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
 	return lbs.blobAccessController.Stat(ctx, dgst)
@@ -81,10 +91,19 @@ func (lbs *linkedBlobStore) ServeBlob(ctx context.Context, w http.ResponseWriter
 	return lbs.blobServer.ServeBlob(ctx, w, r, canonical.Digest)
 }
 
+// DigestAlgorithm returns the algorithm of the digests computed for new
+// content.
+func (lbs *linkedBlobStore) DigestAlgorithm() digest.Algorithm {
+	if lbs.algorithm != "" {
+		return lbs.algorithm
+	}
+	return lbs.blobStore.digestAlgorithm()
+}
+
 func (lbs *linkedBlobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
-	dgst := digest.FromBytes(p)
+	dgst := lbs.DigestAlgorithm().FromBytes(p)
 	// Place the data in the blob store first.
-	desc, err := lbs.blobStore.Put(ctx, mediaType, p)
+	desc, err := lbs.blobStore.put(ctx, dgst, p)
 	if err != nil {
 		dcontext.GetLogger(ctx).Errorf("error putting into main store: %v", err)
 		return distribution.Descriptor{}, err
@@ -232,7 +251,9 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 	if err != nil {
 		return err
 	}
-	return lbs.driver.Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
+	// the links not packed yet are enumerated first
+	seen := make(map[digest.Digest]struct{})
+	err = lbs.driver.Walk(ctx, rootPath, func(fileInfo driver.FileInfo) error {
 		// exit early if directory...
 		if fileInfo.IsDir() {
 			return nil
@@ -260,6 +281,7 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 			}
 			return err
 		}
+		seen[digest] = struct{}{}
 
 		err = ingestor(digest)
 		if err != nil {
@@ -268,6 +290,20 @@ func (lbs *linkedBlobStore) Enumerate(ctx context.Context, ingestor func(digest.
 
 		return nil
 	})
+	if lbs.index == nil {
+		return err
+	}
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return err
+	}
+
+	return lbs.index.enumerate(ctx, func(entry linkIndexEntry) error {
+		if _, ok := seen[entry.target()]; ok {
+			return nil
+		}
+		seen[entry.target()] = struct{}{}
+		return ingestor(entry.target())
+	})
 }
 
 func (lbs *linkedBlobStore) mount(ctx context.Context, sourceRepo reference.Named, dgst digest.Digest, sourceStat *distribution.Descriptor) (distribution.Descriptor, error) {
@@ -311,7 +347,7 @@ func (lbs *linkedBlobStore) newBlobUpload(ctx context.Context, uuid, path string
 		blobStore:              lbs,
 		id:                     uuid,
 		startedAt:              startedAt,
-		digester:               digest.Canonical.Digester(),
+		digester:               lbs.DigestAlgorithm().Digester(),
 		fileWriter:             fw,
 		driver:                 lbs.driver,
 		path:                   path,
@@ -347,6 +383,9 @@ func (lbs *linkedBlobStore) linkBlob(ctx context.Context, canonical distribution
 		if err := lbs.blobStore.link(ctx, blobLinkPath, canonical.Digest); err != nil {
 			return err
 		}
+		if err := lbs.index.revive(ctx, dgst); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -361,6 +400,9 @@ type linkedBlobStatter struct {
 	// blobs have not yet been fully merged. At some point, this functionality
 	// should be removed an the blob links folder should be merged.
 	linkPath linkPathFunc
+
+	// index holds the links packed, nil if the links are not packed
+	index *linkIndex
 }
 
 var _ distribution.BlobDescriptorService = &linkedBlobStatter{}
@@ -375,7 +417,15 @@ func (lbs *linkedBlobStatter) Stat(ctx context.Context, dgst digest.Digest) (dis
 	if err != nil {
 		switch err := err.(type) {
 		case driver.PathNotFoundError:
-			return distribution.Descriptor{}, distribution.ErrBlobUnknown
+			// the link may be packed
+			entry, found, lookupErr := lbs.index.lookup(ctx, dgst)
+			if lookupErr != nil {
+				return distribution.Descriptor{}, lookupErr
+			}
+			if !found {
+				return distribution.Descriptor{}, distribution.ErrBlobUnknown
+			}
+			target = entry.target()
 		default:
 			return distribution.Descriptor{}, err
 		}
@@ -398,6 +448,9 @@ func (lbs *linkedBlobStatter) Clear(ctx context.Context, dgst digest.Digest) (er
 		return err
 	}
 
+	if lbs.index != nil {
+		return lbs.index.unlink(ctx, blobLinkPath, dgst)
+	}
 	return lbs.blobStore.driver.Delete(ctx, blobLinkPath)
 }
 
@@ -0,0 +1,534 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/uuid"
+	"github.com/opencontainers/go-digest"
+)
+
+// The links of the layers and of the manifest revisions of a repository are
+// stored as a link file each, which makes for a lot of tiny objects on object
+// storage. With the packed layout, enabled by EnablePackedLinks, the links
+// are packed into the index of the repository, in shards by the first hex
+// digit of their digests:
+//
+//	<root>/v2/repositories/<name>/_manifests/_index/<layers|revisions>/<shard>/segment-<id>
+//	<root>/v2/repositories/<name>/_manifests/_index/<layers|revisions>/<shard>/tombstone-<algorithm>-<hex digest>
+//
+// The index lives under _manifests so that the repositories are still
+// listed once all their links are packed.
+//
+// Links are still written as link files, which packing later moves into the
+// index: it writes a new segment holding all the links of a shard, then
+// removes the link files and the segments it packed. Segments are never
+// modified, so they are cached once read. Removing a packed link writes a
+// tombstone, which linking the digest again removes, and which the next
+// packing of the shard applies. Lookups read the link file first and the
+// index second, so that a link is found while it is being packed.
+
+const (
+	linkIndexLayers    = "layers"
+	linkIndexRevisions = "revisions"
+)
+
+// maxCachedLinkSegments bounds the number of segments cached by a registry.
+const maxCachedLinkSegments = 4096
+
+// linkIndexEntry is a link packed in a segment.
+type linkIndexEntry struct {
+	Digest digest.Digest `json:"digest"`
+	// Target is the digest linked, if it differs from the digest
+	Target   digest.Digest `json:"target,omitempty"`
+	LinkedAt time.Time     `json:"linkedat"`
+}
+
+func (e linkIndexEntry) target() digest.Digest {
+	if e.Target != "" {
+		return e.Target
+	}
+	return e.Digest
+}
+
+// linkSegment is a segment of a shard of the index.
+type linkSegment struct {
+	path    string
+	entries map[digest.Digest]linkIndexEntry
+}
+
+// linkSegmentCache caches the entries of the segments read by a registry.
+type linkSegmentCache struct {
+	mu       sync.Mutex
+	segments map[string]map[digest.Digest]linkIndexEntry
+}
+
+func newLinkSegmentCache() *linkSegmentCache {
+	return &linkSegmentCache{segments: make(map[string]map[digest.Digest]linkIndexEntry)}
+}
+
+func (c *linkSegmentCache) get(segmentPath string) (map[digest.Digest]linkIndexEntry, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entries, ok := c.segments[segmentPath]
+	return entries, ok
+}
+
+func (c *linkSegmentCache) add(segmentPath string, entries map[digest.Digest]linkIndexEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.segments) >= maxCachedLinkSegments {
+		for evicted := range c.segments {
+			delete(c.segments, evicted)
+			break
+		}
+	}
+	c.segments[segmentPath] = entries
+}
+
+// linkIndex is the index of the packed links of a kind, layers or revisions,
+// of a repository. A nil linkIndex holds no links, as with the layout of
+// link files only.
+type linkIndex struct {
+	driver   driver.StorageDriver
+	name     string
+	kind     string
+	segments *linkSegmentCache
+}
+
+// linkIndex returns the index of the links of the kind of the repository,
+// nil if the registry does not pack links.
+func (reg *registry) linkIndex(name, kind string) *linkIndex {
+	if reg.linkSegments == nil {
+		return nil
+	}
+	return &linkIndex{
+		driver:   reg.driver,
+		name:     name,
+		kind:     kind,
+		segments: reg.linkSegments,
+	}
+}
+
+// linkIndexOf returns the index of the links of the kind of the repository
+// of the namespace, nil if it does not pack links.
+func linkIndexOf(ns distribution.Namespace, name, kind string) *linkIndex {
+	reg, ok := ns.(*registry)
+	if !ok {
+		return nil
+	}
+	return reg.linkIndex(name, kind)
+}
+
+// linkIndexShard returns the shard of the index holding the link of the
+// digest.
+func linkIndexShard(dgst digest.Digest) string {
+	return dgst.Hex()[:1]
+}
+
+// linkedAt returns when the digest was linked into the repository, from the
+// modification time of its link file or else from the index, if not nil. A
+// driver.PathNotFoundError is returned if the digest is not linked.
+func linkedAt(ctx context.Context, storageDriver driver.StorageDriver, linkPath string, index *linkIndex, dgst digest.Digest) (time.Time, error) {
+	fi, err := storageDriver.Stat(ctx, linkPath)
+	if err == nil {
+		return fi.ModTime(), nil
+	}
+	if _, ok := err.(driver.PathNotFoundError); !ok || index == nil {
+		return time.Time{}, err
+	}
+	entry, found, lookupErr := index.lookup(ctx, dgst)
+	if lookupErr != nil {
+		return time.Time{}, lookupErr
+	}
+	if !found {
+		return time.Time{}, err
+	}
+	return entry.LinkedAt, nil
+}
+
+// linkPath returns the path of the link file of the digest.
+func (idx *linkIndex) linkPath(dgst digest.Digest) (string, error) {
+	if idx.kind == linkIndexLayers {
+		return blobLinkPath(idx.name, dgst)
+	}
+	return manifestRevisionLinkPath(idx.name, dgst)
+}
+
+// linksPath returns the path of the directory of the link files.
+func (idx *linkIndex) linksPath() (string, error) {
+	if idx.kind == linkIndexLayers {
+		return pathFor(layersPathSpec{name: idx.name})
+	}
+	return pathFor(manifestRevisionsPathSpec{name: idx.name})
+}
+
+// readShard reads the segments of the shard, and the paths of its
+// tombstones. It reads the shard again if it was packed meanwhile.
+func (idx *linkIndex) readShard(ctx context.Context, shard string) ([]linkSegment, map[digest.Digest]string, error) {
+	shardPath, err := pathFor(linkIndexShardPathSpec{name: idx.name, kind: idx.kind, shard: shard})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		paths, err := idx.driver.List(ctx, shardPath)
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				return nil, nil, nil
+			}
+			return nil, nil, err
+		}
+		sort.Strings(paths)
+
+		var segments []linkSegment
+		tombstones := make(map[digest.Digest]string)
+		for _, p := range paths {
+			name := path.Base(p)
+			switch {
+			case strings.HasPrefix(name, "segment-"):
+				var entries map[digest.Digest]linkIndexEntry
+				entries, err = idx.readSegment(ctx, p)
+				if err == nil {
+					segments = append(segments, linkSegment{path: p, entries: entries})
+				}
+			case strings.HasPrefix(name, "tombstone-"):
+				parts := strings.SplitN(strings.TrimPrefix(name, "tombstone-"), "-", 2)
+				if len(parts) != 2 {
+					continue
+				}
+				dgst := digest.NewDigestFromHex(parts[0], parts[1])
+				if dgst.Validate() == nil {
+					tombstones[dgst] = p
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		if _, ok := err.(driver.PathNotFoundError); ok && attempt < 2 {
+			// the shard was packed again meanwhile
+			continue
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+		return segments, tombstones, nil
+	}
+}
+
+// readSegment returns the entries of the segment.
+func (idx *linkIndex) readSegment(ctx context.Context, segmentPath string) (map[digest.Digest]linkIndexEntry, error) {
+	if entries, ok := idx.segments.get(segmentPath); ok {
+		return entries, nil
+	}
+	content, err := idx.driver.GetContent(ctx, segmentPath)
+	if err != nil {
+		return nil, err
+	}
+	var list []linkIndexEntry
+	if err := json.Unmarshal(content, &list); err != nil {
+		return nil, fmt.Errorf("invalid link index segment %s: %v", segmentPath, err)
+	}
+	entries := make(map[digest.Digest]linkIndexEntry, len(list))
+	for _, entry := range list {
+		entries[entry.Digest] = entry
+	}
+	idx.segments.add(segmentPath, entries)
+	return entries, nil
+}
+
+// writeSegment writes a new segment of the shard with the entries.
+func (idx *linkIndex) writeSegment(ctx context.Context, shard string, entries map[digest.Digest]linkIndexEntry) error {
+	list := make([]linkIndexEntry, 0, len(entries))
+	for _, entry := range entries {
+		list = append(list, entry)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Digest < list[j].Digest })
+	content, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	segmentPath, err := pathFor(linkIndexSegmentPathSpec{name: idx.name, kind: idx.kind, shard: shard, id: uuid.Generate().String()})
+	if err != nil {
+		return err
+	}
+	if err := idx.driver.PutContent(ctx, segmentPath, content); err != nil {
+		return err
+	}
+	idx.segments.add(segmentPath, entries)
+	return nil
+}
+
+// lookup returns the entry of the digest packed in the index.
+func (idx *linkIndex) lookup(ctx context.Context, dgst digest.Digest) (linkIndexEntry, bool, error) {
+	if idx == nil {
+		return linkIndexEntry{}, false, nil
+	}
+	if err := dgst.Validate(); err != nil {
+		return linkIndexEntry{}, false, err
+	}
+	segments, tombstones, err := idx.readShard(ctx, linkIndexShard(dgst))
+	if err != nil {
+		return linkIndexEntry{}, false, err
+	}
+	if _, removed := tombstones[dgst]; removed {
+		return linkIndexEntry{}, false, nil
+	}
+	for _, segment := range segments {
+		if entry, ok := segment.entries[dgst]; ok {
+			return entry, true, nil
+		}
+	}
+	return linkIndexEntry{}, false, nil
+}
+
+// unlink removes the link file of the digest, and writes a tombstone
+// removing it from the index in case it is packed, or being packed.
+func (idx *linkIndex) unlink(ctx context.Context, linkPath string, dgst digest.Digest) error {
+	if err := idx.driver.Delete(ctx, linkPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	tombstonePath, err := pathFor(linkIndexTombstonePathSpec{name: idx.name, kind: idx.kind, digest: dgst})
+	if err != nil {
+		return err
+	}
+	return idx.driver.PutContent(ctx, tombstonePath, []byte(dgst))
+}
+
+// revive removes the tombstone of the digest, once its link file is
+// written again.
+func (idx *linkIndex) revive(ctx context.Context, dgst digest.Digest) error {
+	if idx == nil {
+		return nil
+	}
+	tombstonePath, err := pathFor(linkIndexTombstonePathSpec{name: idx.name, kind: idx.kind, digest: dgst})
+	if err != nil {
+		return err
+	}
+	if err := idx.driver.Delete(ctx, tombstonePath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return err
+		}
+	}
+	return nil
+}
+
+// shards returns the shards of the index.
+func (idx *linkIndex) shards(ctx context.Context) ([]string, error) {
+	indexPath, err := pathFor(linkIndexPathSpec{name: idx.name, kind: idx.kind})
+	if err != nil {
+		return nil, err
+	}
+	paths, err := idx.driver.List(ctx, indexPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+	shards := make([]string, 0, len(paths))
+	for _, p := range paths {
+		shards = append(shards, path.Base(p))
+	}
+	sort.Strings(shards)
+	return shards, nil
+}
+
+// enumerate calls fn with the entries packed in the index.
+func (idx *linkIndex) enumerate(ctx context.Context, fn func(linkIndexEntry) error) error {
+	if idx == nil {
+		return nil
+	}
+	shards, err := idx.shards(ctx)
+	if err != nil {
+		return err
+	}
+	for _, shard := range shards {
+		segments, tombstones, err := idx.readShard(ctx, shard)
+		if err != nil {
+			return err
+		}
+		seen := make(map[digest.Digest]struct{})
+		for _, segment := range segments {
+			for dgst, entry := range segment.entries {
+				if _, removed := tombstones[dgst]; removed {
+					continue
+				}
+				if _, ok := seen[dgst]; ok {
+					continue
+				}
+				seen[dgst] = struct{}{}
+				if err := fn(entry); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// packedLink is a link file being packed.
+type packedLink struct {
+	entry linkIndexEntry
+	dir   string
+}
+
+// pack packs the link files into the index, shard by shard, and applies the
+// tombstones, returning the number of link files packed.
+func (idx *linkIndex) pack(ctx context.Context, dryRun bool) (int, error) {
+	linksPath, err := idx.linksPath()
+	if err != nil {
+		return 0, err
+	}
+	links := make(map[string][]packedLink)
+	err = idx.driver.Walk(ctx, linksPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "link" {
+			return nil
+		}
+		dir := path.Dir(fileInfo.Path())
+		dgst := digest.NewDigestFromHex(path.Base(path.Dir(dir)), path.Base(dir))
+		if dgst.Validate() != nil {
+			return nil
+		}
+		content, err := idx.driver.GetContent(ctx, fileInfo.Path())
+		if err != nil {
+			if _, ok := err.(driver.PathNotFoundError); ok {
+				// unlinked meanwhile
+				return nil
+			}
+			return err
+		}
+		target, err := digest.Parse(string(content))
+		if err != nil {
+			return fmt.Errorf("invalid link %s: %v", fileInfo.Path(), err)
+		}
+		entry := linkIndexEntry{Digest: dgst, LinkedAt: fileInfo.ModTime()}
+		if target != dgst {
+			entry.Target = target
+		}
+		shard := linkIndexShard(dgst)
+		links[shard] = append(links[shard], packedLink{entry: entry, dir: dir})
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return 0, err
+	}
+
+	shards, err := idx.shards(ctx)
+	if err != nil {
+		return 0, err
+	}
+	for shard := range links {
+		if !containsShard(shards, shard) {
+			shards = append(shards, shard)
+		}
+	}
+	sort.Strings(shards)
+
+	packed := 0
+	for _, shard := range shards {
+		segments, tombstones, err := idx.readShard(ctx, shard)
+		if err != nil {
+			return packed, err
+		}
+		if len(links[shard]) == 0 && len(segments) <= 1 && len(tombstones) == 0 {
+			continue
+		}
+		if dryRun {
+			packed += len(links[shard])
+			continue
+		}
+
+		entries := make(map[digest.Digest]linkIndexEntry)
+		for _, segment := range segments {
+			for dgst, entry := range segment.entries {
+				entries[dgst] = entry
+			}
+		}
+		for dgst := range tombstones {
+			delete(entries, dgst)
+		}
+		// link files written again after a removal override its tombstone
+		for _, link := range links[shard] {
+			entries[link.entry.Digest] = link.entry
+		}
+		if len(entries) > 0 {
+			if err := idx.writeSegment(ctx, shard, entries); err != nil {
+				return packed, err
+			}
+		}
+
+		var removed []string
+		for _, link := range links[shard] {
+			removed = append(removed, link.dir)
+		}
+		for _, segment := range segments {
+			removed = append(removed, segment.path)
+		}
+		for dgst, tombstonePath := range tombstones {
+			// the tombstones of links packed meanwhile are applied next time
+			if _, ok := entries[dgst]; !ok {
+				removed = append(removed, tombstonePath)
+			}
+		}
+		for _, p := range removed {
+			if err := idx.driver.Delete(ctx, p); err != nil {
+				if _, ok := err.(driver.PathNotFoundError); !ok {
+					return packed, err
+				}
+			}
+		}
+		packed += len(links[shard])
+	}
+	return packed, nil
+}
+
+func containsShard(shards []string, shard string) bool {
+	for _, s := range shards {
+		if s == shard {
+			return true
+		}
+	}
+	return false
+}
+
+// unpack writes the links packed in the index back as link files, then
+// removes the index, returning the number of links unpacked.
+func (idx *linkIndex) unpack(ctx context.Context, dryRun bool) (int, error) {
+	unpacked := 0
+	err := idx.enumerate(ctx, func(entry linkIndexEntry) error {
+		unpacked++
+		if dryRun {
+			return nil
+		}
+		linkPath, err := idx.linkPath(entry.Digest)
+		if err != nil {
+			return err
+		}
+		return idx.driver.PutContent(ctx, linkPath, []byte(entry.target()))
+	})
+	if err != nil || dryRun {
+		return unpacked, err
+	}
+	indexPath, err := pathFor(linkIndexPathSpec{name: idx.name, kind: idx.kind})
+	if err != nil {
+		return unpacked, err
+	}
+	if err := idx.driver.Delete(ctx, indexPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); !ok {
+			return unpacked, err
+		}
+	}
+	return unpacked, nil
+}
@@ -41,6 +41,26 @@ func (o skipLayerOption) Apply(m distribution.ManifestService) error {
 	return fmt.Errorf("skip layer verification only valid for manifestStore")
 }
 
+// ManifestDigestAlgorithm stores the manifests Put under digests of the
+// given algorithm, rather than the algorithm of the registry, such as when
+// the client refers to the manifest by a digest of that algorithm.
+func ManifestDigestAlgorithm(algorithm digest.Algorithm) distribution.ManifestServiceOption {
+	return manifestDigestAlgorithmOption{algorithm}
+}
+
+type manifestDigestAlgorithmOption struct{ algorithm digest.Algorithm }
+
+func (o manifestDigestAlgorithmOption) Apply(m distribution.ManifestService) error {
+	if ms, ok := m.(*manifestStore); ok {
+		if !o.algorithm.Available() {
+			return fmt.Errorf("digest algorithm %q is not available", o.algorithm)
+		}
+		ms.blobStore.algorithm = o.algorithm
+		return nil
+	}
+	return fmt.Errorf("manifest digest algorithm only valid for manifestStore")
+}
+
 type manifestStore struct {
 	repository *repository
 	blobStore  *linkedBlobStore
@@ -54,7 +74,15 @@ type manifestStore struct {
 	manifestListHandler ManifestHandler
 }
 
-var _ distribution.ManifestService = &manifestStore{}
+var (
+	_ distribution.ManifestService         = &manifestStore{}
+	_ distribution.DigestAlgorithmProvider = &manifestStore{}
+)
+
+// DigestAlgorithm returns the algorithm of the digests of the manifests put.
+func (ms *manifestStore) DigestAlgorithm() digest.Algorithm {
+	return ms.blobStore.DigestAlgorithm()
+}
 
 func (ms *manifestStore) Exists(ctx context.Context, dgst digest.Digest) (bool, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Exists")
@@ -129,23 +157,67 @@ func (ms *manifestStore) Get(ctx context.Context, dgst digest.Digest, options ..
 func (ms *manifestStore) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Put")
 
+	// Record the references before they are verified, so that an online
+	// garbage collection does not delete them after they were found
+	if journal := ms.repository.registry.blobStore.journal; journal != nil {
+		var dgsts []digest.Digest
+		for _, desc := range manifest.References() {
+			dgsts = append(dgsts, desc.Digest)
+		}
+		if err := journal.record(ctx, dgsts...); err != nil {
+			return "", err
+		}
+	}
+
 	switch manifest.(type) {
 	case *schema1.SignedManifest: //nolint:staticcheck // Ignore SA1019: "github.com/distribution/distribution/v3/manifest/schema1" is deprecated, as it's used for backward compatibility.
 		return ms.schema1Handler.Put(ctx, manifest, ms.skipDependencyVerification)
 	case *schema2.DeserializedManifest:
 		return ms.schema2Handler.Put(ctx, manifest, ms.skipDependencyVerification)
 	case *ocischema.DeserializedManifest:
-		return ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		dgst, err := ms.ocischemaHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		if err != nil {
+			return "", err
+		}
+		return dgst, ms.linkSubject(ctx, manifest, dgst)
 	case *manifestlist.DeserializedManifestList:
-		return ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		dgst, err := ms.manifestListHandler.Put(ctx, manifest, ms.skipDependencyVerification)
+		if err != nil {
+			return "", err
+		}
+		return dgst, ms.linkSubject(ctx, manifest, dgst)
 	}
 
 	return "", fmt.Errorf("unrecognized manifest type %T", manifest)
 }
 
+// linkSubject indexes the manifest under the subject it declares, if any, so
+// that it is listed by the referrers API.
+func (ms *manifestStore) linkSubject(ctx context.Context, manifest distribution.Manifest, dgst digest.Digest) error {
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		return err
+	}
+
+	subject, ok := manifestSubject(payload)
+	if !ok {
+		return nil
+	}
+
+	referrers := &referrersStore{
+		repository: ms.repository,
+		blobStore:  ms.repository.registry.blobStore,
+	}
+
+	return referrers.link(ctx, subject, dgst)
+}
+
 // Delete removes the revision of the specified manifest.
 func (ms *manifestStore) Delete(ctx context.Context, dgst digest.Digest) error {
 	dcontext.GetLogger(ms.ctx).Debug("(*manifestStore).Delete")
+	if ms.repository.trashEnabled {
+		return ms.trash(ctx, dgst)
+	}
 	return ms.blobStore.Delete(ctx, dgst)
 }
 
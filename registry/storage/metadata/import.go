@@ -0,0 +1,157 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// ImportResult counts the metadata imported from the storage.
+type ImportResult struct {
+	Repositories int
+	Manifests    int
+	Tags         int
+	Layers       int
+}
+
+// Import records the manifests, tags, layer links and repositories found in
+// the storage
+// of the registry in the store. Metadata already recorded is updated, so an
+// import can be run again to catch up with the changes made to the storage
+// while it ran.
+func Import(ctx context.Context, registry distribution.Namespace, store Store) (ImportResult, error) {
+	var result ImportResult
+
+	enumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return result, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	err := enumerator.Enumerate(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repo, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		manifests, tags, err := importRepository(ctx, repo, store)
+		if err != nil {
+			return fmt.Errorf("failed to import repository %s: %v", repoName, err)
+		}
+		layers, err := importLayers(ctx, repo, store)
+		if err != nil {
+			return fmt.Errorf("failed to import the layers of repository %s: %v", repoName, err)
+		}
+		dcontext.GetLogger(ctx).Infof("imported repository %s: %d manifests, %d tags, %d layers", repoName, manifests, tags, layers)
+
+		result.Repositories++
+		result.Manifests += manifests
+		result.Tags += tags
+		result.Layers += layers
+		return nil
+	})
+	return result, err
+}
+
+func importRepository(ctx context.Context, repo distribution.Repository, store Store) (int, int, error) {
+	name := repo.Named().Name()
+
+	manifestService, err := repo.Manifests(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return 0, 0, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+
+	var manifests int
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			if _, ok := err.(distribution.ErrManifestUnknownRevision); ok {
+				// deleted since listed
+				return nil
+			}
+			return err
+		}
+		mediaType, payload, err := manifest.Payload()
+		if err != nil {
+			return err
+		}
+
+		desc := distribution.Descriptor{
+			MediaType: mediaType,
+			Digest:    dgst,
+			Size:      int64(len(payload)),
+		}
+		if err := store.PutManifest(ctx, name, desc, manifest.References()); err != nil {
+			return err
+		}
+		manifests++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+
+	tagService := repo.Tags(ctx)
+	tags, err := tagService.All(ctx)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			// a repository without tags
+			return manifests, 0, nil
+		}
+		return 0, 0, err
+	}
+	var imported int
+	for _, tag := range tags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			if _, ok := err.(distribution.ErrTagUnknown); ok {
+				continue
+			}
+			return 0, 0, err
+		}
+		if err := store.Tag(ctx, name, tag, desc.Digest); err != nil {
+			return 0, 0, err
+		}
+		imported++
+	}
+	return manifests, imported, nil
+}
+
+// importLayers records the links of the blobs of the repository.
+func importLayers(ctx context.Context, repo distribution.Repository, store Store) (int, error) {
+	name := repo.Named().Name()
+	blobs := repo.Blobs(ctx)
+	enumerator, ok := blobs.(distribution.BlobEnumerator)
+	if !ok {
+		return 0, fmt.Errorf("unable to convert BlobStore into BlobEnumerator")
+	}
+
+	var layers int
+	err := enumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		desc, err := blobs.Stat(ctx, dgst)
+		if err != nil {
+			if err == distribution.ErrBlobUnknown {
+				// deleted since listed
+				return nil
+			}
+			return err
+		}
+		if err := store.LinkBlob(ctx, name, desc); err != nil {
+			return err
+		}
+		layers++
+		return nil
+	})
+	return layers, err
+}
@@ -0,0 +1,60 @@
+// Package metadata records the manifests, tags, layer links and repositories
+// of a registry in a database, alongside the storage, so that tags and
+// repositories are listed, and layers found, without walking the storage.
+package metadata
+
+import (
+	"context"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/opencontainers/go-digest"
+)
+
+// Store holds the metadata of the repositories of a registry. The content
+// of manifests and blobs stays in the storage.
+type Store interface {
+	// PutManifest records a manifest of the repository, along with the
+	// descriptors of the content it references, such as its layers. The
+	// repository is recorded if it is not yet.
+	PutManifest(ctx context.Context, repo string, desc distribution.Descriptor, references []distribution.Descriptor) error
+
+	// DeleteManifest removes a manifest from the repository, along with
+	// the tags pointing to it.
+	DeleteManifest(ctx context.Context, repo string, dgst digest.Digest) error
+
+	// Tag points the tag of the repository to the manifest.
+	Tag(ctx context.Context, repo, tag string, dgst digest.Digest) error
+
+	// Untag removes the tag from the repository.
+	Untag(ctx context.Context, repo, tag string) error
+
+	// Tags returns the tags of the repository in lexical order, or
+	// distribution.ErrRepositoryUnknown if it is not recorded.
+	Tags(ctx context.Context, repo string) ([]string, error)
+
+	// LinkBlob records a blob linked to the repository, such as a layer
+	// pushed or mounted into it. The repository is recorded if it is not
+	// yet.
+	LinkBlob(ctx context.Context, repo string, desc distribution.Descriptor) error
+
+	// UnlinkBlob removes the link of the blob from the repository.
+	UnlinkBlob(ctx context.Context, repo string, dgst digest.Digest) error
+
+	// Blob returns the descriptor of the blob linked to the repository, or
+	// distribution.ErrBlobUnknown if no link is recorded.
+	Blob(ctx context.Context, repo string, dgst digest.Digest) (distribution.Descriptor, error)
+
+	// Lookup returns the tags of the repository pointing to the manifest.
+	Lookup(ctx context.Context, repo string, dgst digest.Digest) ([]string, error)
+
+	// Repositories returns up to n repositories following last in lexical
+	// order.
+	Repositories(ctx context.Context, last string, n int) ([]string, error)
+
+	// RemoveRepository removes the repository, along with its manifests,
+	// tags and layer links.
+	RemoveRepository(ctx context.Context, repo string) error
+
+	// Close releases the connections to the database.
+	Close() error
+}
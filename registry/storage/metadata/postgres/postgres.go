@@ -0,0 +1,313 @@
+// Package postgres implements a metadata store in a Postgres database,
+// through a database/sql driver linked into the binary.
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/storage/metadata"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultDriver is the name of the database/sql driver used unless another
+// is configured, which lib/pq, linked into the registry, registers.
+const defaultDriver = "postgres"
+
+// defaultMaxIdleConns is the number of idle connections kept open unless
+// another is configured, that of database/sql.
+const defaultMaxIdleConns = 2
+
+// migrations create and update the schema of the database, in order. The
+// version of the schema is the number of migrations applied. Names are
+// collated by byte, so that they are listed in the lexical order the storage
+// lists them in.
+var migrations = []string{
+	`CREATE TABLE repositories (
+		name TEXT COLLATE "C" PRIMARY KEY,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+	);
+	CREATE TABLE manifests (
+		repository TEXT NOT NULL REFERENCES repositories (name) ON DELETE CASCADE,
+		digest TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (repository, digest)
+	);
+	CREATE TABLE manifest_references (
+		repository TEXT NOT NULL,
+		manifest TEXT NOT NULL,
+		digest TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		PRIMARY KEY (repository, manifest, digest),
+		FOREIGN KEY (repository, manifest) REFERENCES manifests (repository, digest) ON DELETE CASCADE
+	);
+	CREATE INDEX manifest_references_digest ON manifest_references (digest);
+	CREATE TABLE tags (
+		repository TEXT NOT NULL REFERENCES repositories (name) ON DELETE CASCADE,
+		name TEXT COLLATE "C" NOT NULL,
+		digest TEXT NOT NULL,
+		updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (repository, name)
+	);
+	CREATE INDEX tags_digest ON tags (repository, digest);`,
+	`CREATE TABLE layer_links (
+		repository TEXT NOT NULL REFERENCES repositories (name) ON DELETE CASCADE,
+		digest TEXT NOT NULL,
+		media_type TEXT NOT NULL,
+		size BIGINT NOT NULL,
+		created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+		PRIMARY KEY (repository, digest)
+	);
+	CREATE INDEX layer_links_digest ON layer_links (digest);`,
+}
+
+type store struct {
+	db *sql.DB
+}
+
+var _ metadata.Store = &store{}
+
+// Validate checks the configuration of the database without connecting to
+// it. The database/sql driver must be linked into the binary: the registry
+// links lib/pq, and binaries embedding the registry link a driver of their
+// own.
+func Validate(config configuration.Metadata) error {
+	if config.DSN == "" {
+		return fmt.Errorf("metadata database: dsn is required")
+	}
+	if config.MaxOpenConns < 0 || config.MaxIdleConns < 0 || config.ConnMaxLifetime < 0 {
+		return fmt.Errorf("metadata database: maxopenconns, maxidleconns and connmaxlifetime must not be negative")
+	}
+	driver := driverName(config)
+	for _, registered := range sql.Drivers() {
+		if registered == driver {
+			return nil
+		}
+	}
+	return fmt.Errorf("metadata database: no database/sql driver %q is linked into the binary", driver)
+}
+
+func driverName(config configuration.Metadata) string {
+	if config.Driver == "" {
+		return defaultDriver
+	}
+	return config.Driver
+}
+
+// Open connects to the database of the configuration, and migrates its
+// schema to the latest version.
+func Open(ctx context.Context, config configuration.Metadata) (metadata.Store, error) {
+	if err := Validate(config); err != nil {
+		return nil, err
+	}
+	db, err := sql.Open(driverName(config), config.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("metadata database: %v", err)
+	}
+	maxIdleConns := config.MaxIdleConns
+	if maxIdleConns == 0 {
+		maxIdleConns = defaultMaxIdleConns
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(maxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+	if err := migrate(ctx, db); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("metadata database: unable to migrate the schema: %v", err)
+	}
+	return &store{db: db}, nil
+}
+
+// migrate applies the migrations not yet applied to the database, within a
+// transaction holding a lock so that instances starting together do not
+// apply them twice.
+func migrate(ctx context.Context, db *sql.DB) error {
+	if _, err := db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)`); err != nil {
+		return err
+	}
+
+	return inTx(ctx, db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `LOCK TABLE schema_migrations IN EXCLUSIVE MODE`); err != nil {
+			return err
+		}
+		var version int
+		if err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version); err != nil {
+			return err
+		}
+		if version > len(migrations) {
+			return fmt.Errorf("schema version %d is newer than the supported version %d", version, len(migrations))
+		}
+
+		for i := version; i < len(migrations); i++ {
+			if _, err := tx.ExecContext(ctx, migrations[i]); err != nil {
+				return fmt.Errorf("migration %d: %v", i+1, err)
+			}
+		}
+		if version < len(migrations) {
+			if _, err := tx.ExecContext(ctx, `INSERT INTO schema_migrations (version) VALUES ($1)`, len(migrations)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// inTx runs fn in a transaction, committed unless fn fails.
+func inTx(ctx context.Context, db *sql.DB, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := fn(tx); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func addRepository(ctx context.Context, tx *sql.Tx, repo string) error {
+	_, err := tx.ExecContext(ctx, `INSERT INTO repositories (name) VALUES ($1) ON CONFLICT DO NOTHING`, repo)
+	return err
+}
+
+func (s *store) PutManifest(ctx context.Context, repo string, desc distribution.Descriptor, references []distribution.Descriptor) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := addRepository(ctx, tx, repo); err != nil {
+			return err
+		}
+
+		result, err := tx.ExecContext(ctx,
+			`INSERT INTO manifests (repository, digest, media_type, size) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+			repo, desc.Digest.String(), desc.MediaType, desc.Size)
+		if err != nil {
+			return err
+		}
+		if inserted, err := result.RowsAffected(); err != nil || inserted == 0 {
+			// The content of a manifest does not change, nor do its
+			// references
+			return err
+		}
+
+		for _, reference := range references {
+			if _, err := tx.ExecContext(ctx,
+				`INSERT INTO manifest_references (repository, manifest, digest, media_type, size) VALUES ($1, $2, $3, $4, $5) ON CONFLICT DO NOTHING`,
+				repo, desc.Digest.String(), reference.Digest.String(), reference.MediaType, reference.Size); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *store) DeleteManifest(ctx context.Context, repo string, dgst digest.Digest) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if _, err := tx.ExecContext(ctx, `DELETE FROM tags WHERE repository = $1 AND digest = $2`, repo, dgst.String()); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `DELETE FROM manifests WHERE repository = $1 AND digest = $2`, repo, dgst.String())
+		return err
+	})
+}
+
+func (s *store) Tag(ctx context.Context, repo, tag string, dgst digest.Digest) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := addRepository(ctx, tx, repo); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO tags (repository, name, digest) VALUES ($1, $2, $3)
+			ON CONFLICT (repository, name) DO UPDATE SET digest = EXCLUDED.digest, updated_at = now()`,
+			repo, tag, dgst.String())
+		return err
+	})
+}
+
+func (s *store) Untag(ctx context.Context, repo, tag string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM tags WHERE repository = $1 AND name = $2`, repo, tag)
+	return err
+}
+
+func (s *store) Tags(ctx context.Context, repo string) ([]string, error) {
+	var exists bool
+	if err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM repositories WHERE name = $1)`, repo).Scan(&exists); err != nil {
+		return nil, err
+	}
+	if !exists {
+		return nil, distribution.ErrRepositoryUnknown{Name: repo}
+	}
+	return s.strings(ctx, `SELECT name FROM tags WHERE repository = $1 ORDER BY name`, repo)
+}
+
+func (s *store) LinkBlob(ctx context.Context, repo string, desc distribution.Descriptor) error {
+	return inTx(ctx, s.db, func(tx *sql.Tx) error {
+		if err := addRepository(ctx, tx, repo); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx,
+			`INSERT INTO layer_links (repository, digest, media_type, size) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING`,
+			repo, desc.Digest.String(), desc.MediaType, desc.Size)
+		return err
+	})
+}
+
+func (s *store) UnlinkBlob(ctx context.Context, repo string, dgst digest.Digest) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM layer_links WHERE repository = $1 AND digest = $2`, repo, dgst.String())
+	return err
+}
+
+func (s *store) Blob(ctx context.Context, repo string, dgst digest.Digest) (distribution.Descriptor, error) {
+	desc := distribution.Descriptor{Digest: dgst}
+	err := s.db.QueryRowContext(ctx,
+		`SELECT media_type, size FROM layer_links WHERE repository = $1 AND digest = $2`,
+		repo, dgst.String()).Scan(&desc.MediaType, &desc.Size)
+	if err == sql.ErrNoRows {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	if err != nil {
+		return distribution.Descriptor{}, err
+	}
+	return desc, nil
+}
+
+func (s *store) Lookup(ctx context.Context, repo string, dgst digest.Digest) ([]string, error) {
+	return s.strings(ctx, `SELECT name FROM tags WHERE repository = $1 AND digest = $2 ORDER BY name`, repo, dgst.String())
+}
+
+func (s *store) Repositories(ctx context.Context, last string, n int) ([]string, error) {
+	return s.strings(ctx, `SELECT name FROM repositories WHERE name > $1 ORDER BY name LIMIT $2`, last, n)
+}
+
+func (s *store) RemoveRepository(ctx context.Context, repo string) error {
+	_, err := s.db.ExecContext(ctx, `DELETE FROM repositories WHERE name = $1`, repo)
+	return err
+}
+
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// strings returns the single column of text of the rows of the query.
+func (s *store) strings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	values := []string{}
+	for rows.Next() {
+		var value string
+		if err := rows.Scan(&value); err != nil {
+			return nil, err
+		}
+		values = append(values, value)
+	}
+	return values, rows.Err()
+}
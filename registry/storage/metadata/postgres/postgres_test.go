@@ -0,0 +1,418 @@
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/opencontainers/go-digest"
+)
+
+// fakeDriverName is the name of the database/sql driver of the fake
+// databases, opened by the name of the test as the data source name.
+const fakeDriverName = "fakepostgres"
+
+var fakeDBs sync.Map // data source name to *fakeDB
+
+func init() {
+	sql.Register(fakeDriverName, fakeDriver{})
+}
+
+// fakeDB records the statements run on its connections, and answers them
+// with its handlers: statements affect a row, and queries return no row,
+// unless the handlers return otherwise.
+type fakeDB struct {
+	mu         sync.Mutex
+	statements []string
+	exec       func(query string, args []driver.Value) (int64, error)
+	query      func(query string, args []driver.Value) ([][]driver.Value, error)
+}
+
+// newFakeDB returns the fake database of the test, and the configuration of
+// the metadata store opening it.
+func newFakeDB(t *testing.T) (*fakeDB, configuration.Metadata) {
+	db := &fakeDB{}
+	fakeDBs.Store(t.Name(), db)
+	t.Cleanup(func() { fakeDBs.Delete(t.Name()) })
+	return db, configuration.Metadata{Database: "postgres", Driver: fakeDriverName, DSN: t.Name()}
+}
+
+// record records the statement, with its whitespace collapsed.
+func (db *fakeDB) record(query string) string {
+	query = strings.Join(strings.Fields(query), " ")
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.statements = append(db.statements, query)
+	return query
+}
+
+// recorded returns the statements recorded since the last call.
+func (db *fakeDB) recorded() []string {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	statements := db.statements
+	db.statements = nil
+	return statements
+}
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(dsn string) (driver.Conn, error) {
+	db, ok := fakeDBs.Load(dsn)
+	if !ok {
+		return nil, fmt.Errorf("unknown database %q", dsn)
+	}
+	return &fakeConn{db: db.(*fakeDB)}, nil
+}
+
+// fakeConn is a connection to a fake database, and its transaction.
+type fakeConn struct {
+	db *fakeDB
+}
+
+func (c *fakeConn) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("prepared statements are not supported")
+}
+
+func (c *fakeConn) Close() error {
+	return nil
+}
+
+func (c *fakeConn) Begin() (driver.Tx, error) {
+	c.db.record("BEGIN")
+	return c, nil
+}
+
+func (c *fakeConn) Commit() error {
+	c.db.record("COMMIT")
+	return nil
+}
+
+func (c *fakeConn) Rollback() error {
+	c.db.record("ROLLBACK")
+	return nil
+}
+
+func (c *fakeConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	query = c.db.record(query)
+	if c.db.exec == nil {
+		return driver.RowsAffected(1), nil
+	}
+	n, err := c.db.exec(query, values(args))
+	return driver.RowsAffected(n), err
+}
+
+func (c *fakeConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	query = c.db.record(query)
+	if c.db.query == nil {
+		return &fakeRows{}, nil
+	}
+	rows, err := c.db.query(query, values(args))
+	if err != nil {
+		return nil, err
+	}
+	return &fakeRows{rows: rows}, nil
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	values := make([]driver.Value, len(args))
+	for i, arg := range args {
+		values[i] = arg.Value
+	}
+	return values
+}
+
+type fakeRows struct {
+	rows [][]driver.Value
+	next int
+}
+
+func (r *fakeRows) Columns() []string {
+	columns := 1
+	if len(r.rows) > 0 {
+		columns = len(r.rows[0])
+	}
+	return make([]string, columns)
+}
+
+func (r *fakeRows) Close() error {
+	return nil
+}
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if r.next >= len(r.rows) {
+		return io.EOF
+	}
+	copy(dest, r.rows[r.next])
+	r.next++
+	return nil
+}
+
+// schemaVersion answers the queries of the version of the schema with the
+// version.
+func schemaVersion(version int) func(string, []driver.Value) ([][]driver.Value, error) {
+	return func(query string, args []driver.Value) ([][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT COALESCE(MAX(version), 0)") {
+			return [][]driver.Value{{int64(version)}}, nil
+		}
+		return nil, nil
+	}
+}
+
+func TestValidate(t *testing.T) {
+	_, config := newFakeDB(t)
+	if err := Validate(config); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, invalid := range []configuration.Metadata{
+		{Database: "postgres", Driver: fakeDriverName},
+		{Database: "postgres", Driver: fakeDriverName, DSN: t.Name(), MaxIdleConns: -1},
+		{Database: "postgres", Driver: "unlinked", DSN: t.Name()},
+		// The driver is linked into the registry binary, not this package
+		{Database: "postgres", DSN: t.Name()},
+	} {
+		if err := Validate(invalid); err == nil {
+			t.Errorf("expected %+v to be invalid", invalid)
+		}
+		if _, err := Open(context.Background(), invalid); err == nil {
+			t.Errorf("expected %+v not to be opened", invalid)
+		}
+	}
+}
+
+func TestOpenMigrates(t *testing.T) {
+	ctx := context.Background()
+	db, config := newFakeDB(t)
+	db.query = schemaVersion(0)
+
+	s, err := Open(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	statements := db.recorded()
+	expected := []string{
+		"CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER NOT NULL)",
+		"BEGIN",
+		"LOCK TABLE schema_migrations IN EXCLUSIVE MODE",
+		"SELECT COALESCE(MAX(version), 0) FROM schema_migrations",
+	}
+	for _, migration := range migrations {
+		expected = append(expected, strings.Join(strings.Fields(migration), " "))
+	}
+	expected = append(expected, "INSERT INTO schema_migrations (version) VALUES ($1)", "COMMIT")
+	if !reflect.DeepEqual(statements, expected) {
+		t.Fatalf("unexpected statements:\n%q\nexpected:\n%q", statements, expected)
+	}
+	// The idle connection of the migration is kept by default
+	if idle := s.(*store).db.Stats().Idle; idle != 1 {
+		t.Fatalf("expected an idle connection, got %d", idle)
+	}
+
+	// An up to date schema is not migrated again
+	db.query = schemaVersion(len(migrations))
+	s, err = Open(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	for _, statement := range db.recorded() {
+		if strings.HasPrefix(statement, "CREATE TABLE repositories") || strings.HasPrefix(statement, "INSERT") {
+			t.Fatalf("unexpected statement %q", statement)
+		}
+	}
+
+	// A schema newer than the registry is rejected
+	db.query = schemaVersion(len(migrations) + 1)
+	if _, err := Open(ctx, config); err == nil {
+		t.Fatal("expected a newer schema to be rejected")
+	}
+	if statements := db.recorded(); statements[len(statements)-1] != "ROLLBACK" {
+		t.Fatalf("expected the migration to be rolled back, got %q", statements)
+	}
+}
+
+func TestPutManifest(t *testing.T) {
+	ctx := context.Background()
+	db, config := newFakeDB(t)
+	db.query = schemaVersion(len(migrations))
+	s, err := Open(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.recorded()
+
+	desc := distribution.Descriptor{MediaType: "application/vnd.oci.image.manifest.v1+json", Digest: digest.FromString("manifest"), Size: 100}
+	references := []distribution.Descriptor{
+		{MediaType: "application/vnd.oci.image.config.v1+json", Digest: digest.FromString("config"), Size: 10},
+		{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digest.FromString("layer"), Size: 20},
+	}
+	var inserted [][]driver.Value
+	db.exec = func(query string, args []driver.Value) (int64, error) {
+		if strings.HasPrefix(query, "INSERT INTO manifest_references") {
+			inserted = append(inserted, args)
+		}
+		return 1, nil
+	}
+	if err := s.PutManifest(ctx, "app", desc, references); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]driver.Value{
+		{"app", desc.Digest.String(), references[0].Digest.String(), references[0].MediaType, references[0].Size},
+		{"app", desc.Digest.String(), references[1].Digest.String(), references[1].MediaType, references[1].Size},
+	}
+	if !reflect.DeepEqual(inserted, expected) {
+		t.Fatalf("unexpected references %v", inserted)
+	}
+	if statements := db.recorded(); statements[len(statements)-1] != "COMMIT" {
+		t.Fatalf("expected the manifest to be committed, got %q", statements)
+	}
+
+	// The references of a manifest already recorded are not inserted again
+	inserted = nil
+	db.exec = func(query string, args []driver.Value) (int64, error) {
+		if strings.HasPrefix(query, "INSERT INTO manifests") {
+			return 0, nil
+		}
+		if strings.HasPrefix(query, "INSERT INTO manifest_references") {
+			inserted = append(inserted, args)
+		}
+		return 1, nil
+	}
+	if err := s.PutManifest(ctx, "app", desc, references); err != nil {
+		t.Fatal(err)
+	}
+	if len(inserted) != 0 {
+		t.Fatalf("unexpected references %v", inserted)
+	}
+
+	// A failed statement rolls the transaction back
+	db.exec = func(query string, args []driver.Value) (int64, error) {
+		if strings.HasPrefix(query, "INSERT INTO manifest_references") {
+			return 0, errors.New("connection reset")
+		}
+		return 1, nil
+	}
+	db.recorded()
+	if err := s.PutManifest(ctx, "app", desc, references); err == nil {
+		t.Fatal("expected the failed statement to fail the put")
+	}
+	if statements := db.recorded(); statements[len(statements)-1] != "ROLLBACK" {
+		t.Fatalf("expected the manifest to be rolled back, got %q", statements)
+	}
+}
+
+func TestTags(t *testing.T) {
+	ctx := context.Background()
+	db, config := newFakeDB(t)
+	db.query = schemaVersion(len(migrations))
+	s, err := Open(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	exists := false
+	db.query = func(query string, args []driver.Value) ([][]driver.Value, error) {
+		switch {
+		case strings.HasPrefix(query, "SELECT EXISTS"):
+			return [][]driver.Value{{exists}}, nil
+		case strings.HasPrefix(query, "SELECT name FROM tags"):
+			return [][]driver.Value{{"1.0"}, {"latest"}}, nil
+		}
+		return nil, nil
+	}
+	if _, err := s.Tags(ctx, "app"); !errors.As(err, &distribution.ErrRepositoryUnknown{}) {
+		t.Fatalf("expected the repository to be unknown, got %v", err)
+	}
+
+	exists = true
+	tags, err := s.Tags(ctx, "app")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tags, []string{"1.0", "latest"}) {
+		t.Fatalf("unexpected tags %v", tags)
+	}
+
+	// Repositories without tags list none, rather than nil
+	db.query = func(query string, args []driver.Value) ([][]driver.Value, error) {
+		return nil, nil
+	}
+	repositories, err := s.Repositories(ctx, "", 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if repositories == nil || len(repositories) != 0 {
+		t.Fatalf("unexpected repositories %v", repositories)
+	}
+}
+
+func TestLayerLinks(t *testing.T) {
+	ctx := context.Background()
+	db, config := newFakeDB(t)
+	db.query = schemaVersion(len(migrations))
+	s, err := Open(ctx, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	db.recorded()
+
+	desc := distribution.Descriptor{MediaType: "application/vnd.oci.image.layer.v1.tar+gzip", Digest: digest.FromString("layer"), Size: 20}
+	var inserted [][]driver.Value
+	db.exec = func(query string, args []driver.Value) (int64, error) {
+		if strings.HasPrefix(query, "INSERT INTO layer_links") {
+			inserted = append(inserted, args)
+		}
+		return 1, nil
+	}
+	if err := s.LinkBlob(ctx, "app", desc); err != nil {
+		t.Fatal(err)
+	}
+	expected := [][]driver.Value{{"app", desc.Digest.String(), desc.MediaType, desc.Size}}
+	if !reflect.DeepEqual(inserted, expected) {
+		t.Fatalf("unexpected links %v", inserted)
+	}
+	statements := db.recorded()
+	if !strings.HasPrefix(statements[1], "INSERT INTO repositories") || statements[len(statements)-1] != "COMMIT" {
+		t.Fatalf("expected the repository and the link to be committed, got %q", statements)
+	}
+
+	// A blob without a link is unknown
+	if _, err := s.Blob(ctx, "app", desc.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected the blob to be unknown, got %v", err)
+	}
+	db.query = func(query string, args []driver.Value) ([][]driver.Value, error) {
+		if strings.HasPrefix(query, "SELECT media_type, size FROM layer_links") {
+			return [][]driver.Value{{desc.MediaType, desc.Size}}, nil
+		}
+		return nil, nil
+	}
+	linked, err := s.Blob(ctx, "app", desc.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(linked, desc) {
+		t.Fatalf("unexpected descriptor %+v", linked)
+	}
+
+	db.recorded()
+	if err := s.UnlinkBlob(ctx, "app", desc.Digest); err != nil {
+		t.Fatal(err)
+	}
+	if statements := db.recorded(); len(statements) != 1 || !strings.HasPrefix(statements[0], "DELETE FROM layer_links") {
+		t.Fatalf("unexpected statements %q", statements)
+	}
+}
@@ -0,0 +1,354 @@
+package metadata
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+)
+
+// enumeratePageSize is the number of repositories read from the store at
+// once when enumerating them.
+const enumeratePageSize = 1000
+
+// registry records the changes made to the repositories of the embedded
+// namespace in the store, which serves the listings of tags and
+// repositories, and the links of the layers. The storage remains the source
+// of the content.
+type registry struct {
+	distribution.Namespace
+	store Store
+}
+
+var (
+	_ distribution.RepositoryEnumerator = &registry{}
+	_ distribution.RepositoryRemover    = &registry{}
+)
+
+// NewRegistry returns a namespace recording the manifests, tags, layer links
+// and repositories of the embedded one in the store.
+func NewRegistry(embedded distribution.Namespace, store Store) distribution.Namespace {
+	return &registry{
+		Namespace: embedded,
+		store:     store,
+	}
+}
+
+// Repositories lists the repositories recorded in the store.
+func (reg *registry) Repositories(ctx context.Context, repos []string, last string) (int, error) {
+	if len(repos) == 0 {
+		return 0, fmt.Errorf("no space in slice")
+	}
+
+	found, err := reg.store.Repositories(ctx, last, len(repos))
+	if err != nil {
+		return 0, err
+	}
+	n := copy(repos, found)
+	if n < len(repos) {
+		// No more records are available
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+// Enumerate applies ingester to each repository recorded in the store.
+func (reg *registry) Enumerate(ctx context.Context, ingester func(string) error) error {
+	var last string
+	for {
+		repos, err := reg.store.Repositories(ctx, last, enumeratePageSize)
+		if err != nil {
+			return err
+		}
+		for _, repo := range repos {
+			if err := ingester(repo); err != nil {
+				return err
+			}
+		}
+		if len(repos) < enumeratePageSize {
+			return nil
+		}
+		last = repos[len(repos)-1]
+	}
+}
+
+// Remove removes the repository from the storage and the store.
+func (reg *registry) Remove(ctx context.Context, name reference.Named) error {
+	remover, ok := reg.Namespace.(distribution.RepositoryRemover)
+	if !ok {
+		return fmt.Errorf("unable to convert Namespace to RepositoryRemover")
+	}
+	if err := remover.Remove(ctx, name); err != nil {
+		return err
+	}
+	return reg.store.RemoveRepository(ctx, name.Name())
+}
+
+func (reg *registry) Repository(ctx context.Context, name reference.Named) (distribution.Repository, error) {
+	repo, err := reg.Namespace.Repository(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	r := repository{
+		Repository: repo,
+		store:      reg.store,
+	}
+	if _, ok := repo.(distribution.ReferrerService); ok {
+		return referrerRepository{r}, nil
+	}
+	return r, nil
+}
+
+type repository struct {
+	distribution.Repository
+	store Store
+}
+
+func (repo repository) Manifests(ctx context.Context, options ...distribution.ManifestServiceOption) (distribution.ManifestService, error) {
+	manifests, err := repo.Repository.Manifests(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+	return manifestService{
+		ManifestService: manifests,
+		repo:            repo.Named().Name(),
+		store:           repo.store,
+	}, nil
+}
+
+func (repo repository) Blobs(ctx context.Context) distribution.BlobStore {
+	return blobStore{
+		BlobStore: repo.Repository.Blobs(ctx),
+		repo:      repo.Named().Name(),
+		store:     repo.store,
+	}
+}
+
+func (repo repository) Tags(ctx context.Context) distribution.TagService {
+	return tagService{
+		TagService: repo.Repository.Tags(ctx),
+		repo:       repo.Named().Name(),
+		store:      repo.store,
+	}
+}
+
+// referrerRepository preserves the referrers of the embedded repository.
+type referrerRepository struct {
+	repository
+}
+
+func (repo referrerRepository) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	return repo.Repository.(distribution.ReferrerService).Referrers(ctx, subject, artifactType)
+}
+
+type manifestService struct {
+	distribution.ManifestService
+	repo  string
+	store Store
+}
+
+var _ distribution.ManifestEnumerator = manifestService{}
+
+func (ms manifestService) Put(ctx context.Context, manifest distribution.Manifest, options ...distribution.ManifestServiceOption) (digest.Digest, error) {
+	dgst, err := ms.ManifestService.Put(ctx, manifest, options...)
+	if err != nil {
+		return dgst, err
+	}
+
+	mediaType, payload, err := manifest.Payload()
+	if err != nil {
+		return dgst, err
+	}
+	desc := distribution.Descriptor{
+		MediaType: mediaType,
+		Digest:    dgst,
+		Size:      int64(len(payload)),
+	}
+	return dgst, ms.store.PutManifest(ctx, ms.repo, desc, manifest.References())
+}
+
+func (ms manifestService) Delete(ctx context.Context, dgst digest.Digest) error {
+	if err := ms.ManifestService.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	return ms.store.DeleteManifest(ctx, ms.repo, dgst)
+}
+
+func (ms manifestService) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
+	enumerator, ok := ms.ManifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+	return enumerator.Enumerate(ctx, ingester)
+}
+
+// blobStore records the links of the blobs pushed, mounted and deleted in the
+// store, which is read to find the blobs linked to the repository.
+type blobStore struct {
+	distribution.BlobStore
+	repo  string
+	store Store
+}
+
+var _ distribution.BlobEnumerator = blobStore{}
+
+// Stat reads the link of the blob from the store, and from the storage for
+// the blobs linked before the repository was imported.
+func (bs blobStore) Stat(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, error) {
+	desc, err := bs.store.Blob(ctx, bs.repo, dgst)
+	if err == distribution.ErrBlobUnknown {
+		return bs.BlobStore.Stat(ctx, dgst)
+	}
+	return desc, err
+}
+
+func (bs blobStore) Get(ctx context.Context, dgst digest.Digest) ([]byte, error) {
+	p, err := bs.BlobStore.Get(ctx, dgst)
+	return p, bs.checkLink(ctx, dgst, err)
+}
+
+func (bs blobStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	rsc, err := bs.BlobStore.Open(ctx, dgst)
+	return rsc, bs.checkLink(ctx, dgst, err)
+}
+
+func (bs blobStore) ServeBlob(ctx context.Context, w http.ResponseWriter, r *http.Request, dgst digest.Digest) error {
+	return bs.checkLink(ctx, dgst, bs.BlobStore.ServeBlob(ctx, w, r, dgst))
+}
+
+// checkLink removes the link of a blob the storage does not have, such as
+// one garbage collected, so that it is no longer found.
+func (bs blobStore) checkLink(ctx context.Context, dgst digest.Digest, err error) error {
+	if err == distribution.ErrBlobUnknown {
+		if err := bs.store.UnlinkBlob(ctx, bs.repo, dgst); err != nil {
+			dcontext.GetLogger(ctx).Errorf("unable to remove the link of unknown blob %s from %s: %v", dgst, bs.repo, err)
+		}
+	}
+	return err
+}
+
+func (bs blobStore) Put(ctx context.Context, mediaType string, p []byte) (distribution.Descriptor, error) {
+	desc, err := bs.BlobStore.Put(ctx, mediaType, p)
+	if err != nil {
+		return desc, err
+	}
+	return desc, bs.store.LinkBlob(ctx, bs.repo, desc)
+}
+
+func (bs blobStore) Create(ctx context.Context, options ...distribution.BlobCreateOption) (distribution.BlobWriter, error) {
+	bw, err := bs.BlobStore.Create(ctx, options...)
+	if mounted, ok := err.(distribution.ErrBlobMounted); ok {
+		if err := bs.store.LinkBlob(ctx, bs.repo, mounted.Descriptor); err != nil {
+			return nil, err
+		}
+		return nil, mounted
+	}
+	if err != nil {
+		return nil, err
+	}
+	return blobWriter{BlobWriter: bw, blobs: bs}, nil
+}
+
+func (bs blobStore) Resume(ctx context.Context, id string) (distribution.BlobWriter, error) {
+	bw, err := bs.BlobStore.Resume(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	return blobWriter{BlobWriter: bw, blobs: bs}, nil
+}
+
+func (bs blobStore) Delete(ctx context.Context, dgst digest.Digest) error {
+	if err := bs.BlobStore.Delete(ctx, dgst); err != nil {
+		return err
+	}
+	return bs.store.UnlinkBlob(ctx, bs.repo, dgst)
+}
+
+func (bs blobStore) Enumerate(ctx context.Context, ingester func(digest.Digest) error) error {
+	enumerator, ok := bs.BlobStore.(distribution.BlobEnumerator)
+	if !ok {
+		return fmt.Errorf("unable to convert BlobStore into BlobEnumerator")
+	}
+	return enumerator.Enumerate(ctx, ingester)
+}
+
+// blobWriter records the link of the blob it commits.
+type blobWriter struct {
+	distribution.BlobWriter
+	blobs blobStore
+}
+
+func (bw blobWriter) Commit(ctx context.Context, provisional distribution.Descriptor) (distribution.Descriptor, error) {
+	desc, err := bw.BlobWriter.Commit(ctx, provisional)
+	if err != nil {
+		return desc, err
+	}
+	return desc, bw.blobs.store.LinkBlob(ctx, bw.blobs.repo, desc)
+}
+
+type tagService struct {
+	distribution.TagService
+	repo  string
+	store Store
+}
+
+var (
+	_ distribution.TagManifestsProvider = tagService{}
+	_ distribution.TagHistoryProvider   = tagService{}
+)
+
+func (ts tagService) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
+	if err := ts.TagService.Tag(ctx, tag, desc); err != nil {
+		return err
+	}
+	return ts.store.Tag(ctx, ts.repo, tag, desc.Digest)
+}
+
+func (ts tagService) Untag(ctx context.Context, tag string) error {
+	if err := ts.TagService.Untag(ctx, tag); err != nil {
+		return err
+	}
+	return ts.store.Untag(ctx, ts.repo, tag)
+}
+
+// All lists the tags recorded in the store.
+func (ts tagService) All(ctx context.Context) ([]string, error) {
+	tags, err := ts.store.Tags(ctx, ts.repo)
+	if err != nil {
+		if _, ok := err.(distribution.ErrRepositoryUnknown); ok {
+			// The repository may be in the storage only, if it was
+			// pushed before it was imported
+			dcontext.GetLogger(ctx).Debugf("repository %s is not recorded, listing tags from storage", ts.repo)
+			return ts.TagService.All(ctx)
+		}
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Lookup finds the tags recorded in the store.
+func (ts tagService) Lookup(ctx context.Context, desc distribution.Descriptor) ([]string, error) {
+	return ts.store.Lookup(ctx, ts.repo, desc.Digest)
+}
+
+func (ts tagService) ManifestDigests(ctx context.Context, tag string) ([]digest.Digest, error) {
+	provider, ok := ts.TagService.(distribution.TagManifestsProvider)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return provider.ManifestDigests(ctx, tag)
+}
+
+func (ts tagService) History(ctx context.Context, tag string) ([]distribution.TagHistoryEntry, error) {
+	provider, ok := ts.TagService.(distribution.TagHistoryProvider)
+	if !ok {
+		return nil, distribution.ErrUnsupported
+	}
+	return provider.History(ctx, tag)
+}
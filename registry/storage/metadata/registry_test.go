@@ -0,0 +1,335 @@
+package metadata
+
+import (
+	"context"
+	"io"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+// memoryStore is a store keeping the metadata in memory.
+type memoryStore struct {
+	manifests map[string]map[digest.Digest][]distribution.Descriptor
+	tags      map[string]map[string]digest.Digest
+	layers    map[string]map[digest.Digest]distribution.Descriptor
+}
+
+func newMemoryStore() *memoryStore {
+	return &memoryStore{
+		manifests: make(map[string]map[digest.Digest][]distribution.Descriptor),
+		tags:      make(map[string]map[string]digest.Digest),
+		layers:    make(map[string]map[digest.Digest]distribution.Descriptor),
+	}
+}
+
+func (s *memoryStore) addRepository(repo string) {
+	if _, ok := s.manifests[repo]; !ok {
+		s.manifests[repo] = make(map[digest.Digest][]distribution.Descriptor)
+		s.tags[repo] = make(map[string]digest.Digest)
+		s.layers[repo] = make(map[digest.Digest]distribution.Descriptor)
+	}
+}
+
+func (s *memoryStore) PutManifest(ctx context.Context, repo string, desc distribution.Descriptor, references []distribution.Descriptor) error {
+	s.addRepository(repo)
+	s.manifests[repo][desc.Digest] = references
+	return nil
+}
+
+func (s *memoryStore) DeleteManifest(ctx context.Context, repo string, dgst digest.Digest) error {
+	delete(s.manifests[repo], dgst)
+	for tag, tagged := range s.tags[repo] {
+		if tagged == dgst {
+			delete(s.tags[repo], tag)
+		}
+	}
+	return nil
+}
+
+func (s *memoryStore) Tag(ctx context.Context, repo, tag string, dgst digest.Digest) error {
+	s.addRepository(repo)
+	s.tags[repo][tag] = dgst
+	return nil
+}
+
+func (s *memoryStore) Untag(ctx context.Context, repo, tag string) error {
+	delete(s.tags[repo], tag)
+	return nil
+}
+
+func (s *memoryStore) Tags(ctx context.Context, repo string) ([]string, error) {
+	tags, ok := s.tags[repo]
+	if !ok {
+		return nil, distribution.ErrRepositoryUnknown{Name: repo}
+	}
+	names := []string{}
+	for tag := range tags {
+		names = append(names, tag)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *memoryStore) LinkBlob(ctx context.Context, repo string, desc distribution.Descriptor) error {
+	s.addRepository(repo)
+	s.layers[repo][desc.Digest] = desc
+	return nil
+}
+
+func (s *memoryStore) UnlinkBlob(ctx context.Context, repo string, dgst digest.Digest) error {
+	delete(s.layers[repo], dgst)
+	return nil
+}
+
+func (s *memoryStore) Blob(ctx context.Context, repo string, dgst digest.Digest) (distribution.Descriptor, error) {
+	desc, ok := s.layers[repo][dgst]
+	if !ok {
+		return distribution.Descriptor{}, distribution.ErrBlobUnknown
+	}
+	return desc, nil
+}
+
+func (s *memoryStore) Lookup(ctx context.Context, repo string, dgst digest.Digest) ([]string, error) {
+	names := []string{}
+	for tag, tagged := range s.tags[repo] {
+		if tagged == dgst {
+			names = append(names, tag)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+func (s *memoryStore) Repositories(ctx context.Context, last string, n int) ([]string, error) {
+	var repos []string
+	for repo := range s.manifests {
+		if repo > last {
+			repos = append(repos, repo)
+		}
+	}
+	sort.Strings(repos)
+	if len(repos) > n {
+		repos = repos[:n]
+	}
+	return repos, nil
+}
+
+func (s *memoryStore) RemoveRepository(ctx context.Context, repo string) error {
+	delete(s.manifests, repo)
+	delete(s.tags, repo)
+	delete(s.layers, repo)
+	return nil
+}
+
+func (s *memoryStore) Close() error {
+	return nil
+}
+
+func makeRepository(t *testing.T, registry distribution.Namespace, name string) distribution.Repository {
+	t.Helper()
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(context.Background(), named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+func TestRegistry(t *testing.T) {
+	ctx := context.Background()
+	embedded, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryStore()
+	registry := NewRegistry(embedded, store)
+
+	repo := makeRepository(t, registry, "team/app")
+	dgst := testutil.PushImage(t, repo, "latest").Descriptor.Digest
+	if references := store.manifests["team/app"][dgst]; len(references) != 3 {
+		t.Fatalf("expected the config and layers of the manifest to be recorded, got %v", references)
+	}
+	if err := repo.Tags(ctx).Tag(ctx, "v1", distribution.Descriptor{Digest: dgst}); err != nil {
+		t.Fatal(err)
+	}
+	// The tags are listed from the store
+	store.tags["team/app"]["recorded"] = dgst
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := []string{"latest", "recorded", "v1"}; !reflect.DeepEqual(tags, expected) {
+		t.Fatalf("expected tags %v, got %v", expected, tags)
+	}
+	if err := repo.Tags(ctx).Untag(ctx, "v1"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.tags["team/app"]["v1"]; ok {
+		t.Fatal("expected the tag to be removed from the store")
+	}
+
+	other := makeRepository(t, registry, "other")
+	testutil.PushImage(t, other)
+	repos := make([]string, 1)
+	n, err := registry.Repositories(ctx, repos, "")
+	if err != nil || n != 1 || repos[0] != "other" {
+		t.Fatalf("expected the first repository to be other, got %v, %v", repos[:n], err)
+	}
+	n, err = registry.Repositories(ctx, repos, "other")
+	if err != nil || n != 1 || repos[0] != "team/app" {
+		t.Fatalf("expected the next repository to be team/app, got %v, %v", repos[:n], err)
+	}
+	if n, err := registry.Repositories(ctx, repos, "team/app"); err != io.EOF || n != 0 {
+		t.Fatalf("expected the repositories to end, got %d, %v", n, err)
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := manifests.Delete(ctx, dgst); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.manifests["team/app"][dgst]; ok {
+		t.Fatal("expected the manifest to be removed from the store")
+	}
+	if len(store.tags["team/app"]) != 0 {
+		t.Fatalf("expected the tags of the manifest to be removed, got %v", store.tags["team/app"])
+	}
+
+	named, _ := reference.WithName("other")
+	if err := registry.(distribution.RepositoryRemover).Remove(ctx, named); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.manifests["other"]; ok {
+		t.Fatal("expected the repository to be removed from the store")
+	}
+}
+
+func TestRegistryLayerLinks(t *testing.T) {
+	ctx := context.Background()
+	embedded, err := storage.NewRegistry(ctx, inmemory.New(), storage.EnableDelete)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := newMemoryStore()
+	registry := NewRegistry(embedded, store)
+
+	// The blobs pushed are linked in the store
+	repo := makeRepository(t, registry, "team/app")
+	image := testutil.PushImage(t, repo, "latest")
+	blobs := repo.Blobs(ctx)
+	for _, desc := range append([]distribution.Descriptor{image.Config}, image.Layers...) {
+		if _, ok := store.layers["team/app"][desc.Digest]; !ok {
+			t.Fatalf("expected %s to be linked in the store", desc.Digest)
+		}
+	}
+	desc, err := blobs.Put(ctx, "application/octet-stream", []byte("put"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if linked := store.layers["team/app"][desc.Digest]; linked.Size != desc.Size {
+		t.Fatalf("expected the put blob to be linked, got %+v", linked)
+	}
+
+	// The blobs mounted are linked in the store
+	other := makeRepository(t, registry, "other")
+	_, err = other.Blobs(ctx).Create(ctx, storage.WithMountFrom(mustCanonical(t, "team/app", desc.Digest)))
+	if _, ok := err.(distribution.ErrBlobMounted); !ok {
+		t.Fatalf("expected the blob to be mounted, got %v", err)
+	}
+	if _, ok := store.layers["other"][desc.Digest]; !ok {
+		t.Fatal("expected the mounted blob to be linked in the store")
+	}
+
+	// Blobs are found from their link, and from the storage when no link is
+	// recorded
+	recorded := distribution.Descriptor{MediaType: "application/octet-stream", Digest: digest.FromString("recorded"), Size: 8}
+	store.layers["team/app"][recorded.Digest] = recorded
+	if stat, err := blobs.Stat(ctx, recorded.Digest); err != nil || stat.Digest != recorded.Digest {
+		t.Fatalf("expected the recorded blob to be found, got %+v, %v", stat, err)
+	}
+	delete(store.layers["team/app"], desc.Digest)
+	if _, err := blobs.Stat(ctx, desc.Digest); err != nil {
+		t.Fatalf("expected the blob to be found in the storage, got %v", err)
+	}
+
+	// A link to a blob the storage does not have is removed when read
+	if _, err := blobs.Get(ctx, recorded.Digest); err != distribution.ErrBlobUnknown {
+		t.Fatalf("expected the recorded blob to be unknown to the storage, got %v", err)
+	}
+	if _, ok := store.layers["team/app"][recorded.Digest]; ok {
+		t.Fatal("expected the link of the unknown blob to be removed")
+	}
+
+	if err := blobs.Delete(ctx, image.Layers[0].Digest); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := store.layers["team/app"][image.Layers[0].Digest]; ok {
+		t.Fatal("expected the deleted blob to be unlinked")
+	}
+	if _, ok := blobs.(distribution.BlobEnumerator); !ok {
+		t.Fatal("the registry hides the enumeration of the blobs")
+	}
+}
+
+func mustCanonical(t *testing.T, name string, dgst digest.Digest) reference.Canonical {
+	t.Helper()
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	canonical, err := reference.WithDigest(named, dgst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return canonical
+}
+
+func TestImport(t *testing.T) {
+	ctx := context.Background()
+	registry, err := storage.NewRegistry(ctx, inmemory.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	repo := makeRepository(t, registry, "team/app")
+	tagged := testutil.PushImage(t, repo, "latest").Descriptor.Digest
+	testutil.PushImage(t, repo)
+	other := makeRepository(t, registry, "other")
+	testutil.PushImage(t, other, "v1")
+
+	store := newMemoryStore()
+	result, err := Import(ctx, registry, store)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := (ImportResult{Repositories: 2, Manifests: 3, Tags: 2, Layers: 9}); result != expected {
+		t.Fatalf("expected to import %+v, got %+v", expected, result)
+	}
+	if len(store.manifests["team/app"]) != 2 {
+		t.Fatalf("expected two manifests of team/app, got %v", store.manifests["team/app"])
+	}
+	if len(store.layers["team/app"]) != 6 {
+		t.Fatalf("expected the configs and layers of team/app to be linked, got %v", store.layers["team/app"])
+	}
+	if store.tags["team/app"]["latest"] != tagged {
+		t.Fatalf("expected latest to point to %s, got %s", tagged, store.tags["team/app"]["latest"])
+	}
+
+	// Importing again records the same metadata
+	if result, err := Import(ctx, registry, store); err != nil || result.Manifests != 3 {
+		t.Fatalf("expected to import the manifests again, got %+v, %v", result, err)
+	}
+}
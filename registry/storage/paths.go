@@ -27,14 +27,33 @@ const (
 //	├── blob
 //	│   └── <algorithm>
 //	│       └── <split directory content addressable storage>
+//	├── gcjournal
+//	│   └── <algorithm>
+//	│       └── <split directory content addressable storage>
+//	├── trash
+//	│   └── <name>
+//	│       └── <manifest digest path>
+//	│           └── record
 //	└── repositories
 //	    └── <name>
 //	        ├── _layers
 //	        │   └── <layer links to blob store>
 //	        ├── _manifests
+//	        │   ├── _index
+//	        │   │   └── <layers|revisions>
+//	        │   │       └── <shard>
+//	        │   │           ├── segment-<id>
+//	        │   │           └── tombstone-<algorithm>-<hex digest>
 //	        │   ├── revisions
 //	        │   │   └── <manifest digest path>
 //	        │   │       └── link
+//	        │   ├── referrers
+//	        │   │   └── <subject digest path>
+//	        │   │       └── <manifest digest path>
+//	        │   │           └── link
+//	        │   ├── taghistory
+//	        │   │   └── <tag>
+//	        │   │       └── <entry>
 //	        │   └── tags
 //	        │       └── <tag>
 //	        │           ├── current
@@ -92,11 +111,28 @@ const (
 //	manifestTagIndexEntryPathSpec:         <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/
 //	manifestTagIndexEntryLinkPathSpec:     <root>/v2/repositories/<name>/_manifests/tags/<tag>/index/<algorithm>/<hex digest>/link
 //
+//	Referrers:
+//
+//	referrersPathSpec:             <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest>/
+//	referrerLinkPathSpec:          <root>/v2/repositories/<name>/_manifests/referrers/<algorithm>/<hex digest>/<algorithm>/<hex digest>/link
+//
+//	Tag History:
+//
+//	tagHistoryPathSpec:            <root>/v2/repositories/<name>/_manifests/taghistory/<tag>/
+//	tagHistoryEntryPathSpec:       <root>/v2/repositories/<name>/_manifests/taghistory/<tag>/<entry>
+//
 //	Blobs:
 //
 //	layerLinkPathSpec:            <root>/v2/repositories/<name>/_layers/<algorithm>/<hex digest>/link
 //	layersPathSpec:               <root>/v2/repositories/<name>/_layers
 //
+//	Link Index:
+//
+//	linkIndexPathSpec:              <root>/v2/repositories/<name>/_manifests/_index/<kind>
+//	linkIndexShardPathSpec:         <root>/v2/repositories/<name>/_manifests/_index/<kind>/<shard>
+//	linkIndexSegmentPathSpec:       <root>/v2/repositories/<name>/_manifests/_index/<kind>/<shard>/segment-<id>
+//	linkIndexTombstonePathSpec:     <root>/v2/repositories/<name>/_manifests/_index/<kind>/<shard>/tombstone-<algorithm>-<hex digest>
+//
 //	Uploads:
 //
 //	uploadDataPathSpec:             <root>/v2/repositories/<name>/_uploads/<id>/data
@@ -110,6 +146,21 @@ const (
 //	blobDataPathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //	blobMediaTypePathSpec:               <root>/v2/blobs/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
 //
+//	Garbage Collection Journal:
+//
+//	gcJournalPathSpec:              <root>/v2/gcjournal/
+//	gcJournalEntryPathSpec:         <root>/v2/gcjournal/<algorithm>/<first two hex bytes of digest>/<hex digest>/referencedat
+//
+//	Manifest Trash:
+//
+//	trashPathSpec:                  <root>/v2/trash/
+//	trashEntryPathSpec:             <root>/v2/trash/<name>/<algorithm>/<hex digest>/record
+//
+//	Quarantine:
+//
+//	quarantinePathSpec:             <root>/v2/quarantine/
+//	quarantineDataPathSpec:         <root>/v2/quarantine/<algorithm>/<first two hex bytes of digest>/<hex digest>/data
+//
 // For more information on the semantic meaning of each path and their
 // contents, please see the path spec documentation.
 func pathFor(spec pathSpec) (string, error) {
@@ -194,6 +245,40 @@ func pathFor(spec pathSpec) (string, error) {
 		}
 
 		return path.Join(root, path.Join(components...)), nil
+	case referrersPathSpec:
+		components, err := digestPathComponents(v.subject, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(append(append(repoPrefix, v.name, "_manifests", "referrers"), components...)...), nil
+	case tagHistoryPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "taghistory", v.tag)...), nil
+	case tagHistoryEntryPathSpec:
+		root, err := pathFor(tagHistoryPathSpec{
+			name: v.name,
+			tag:  v.tag,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, v.entry), nil
+	case referrerLinkPathSpec:
+		root, err := pathFor(referrersPathSpec{
+			name:    v.name,
+			subject: v.subject,
+		})
+		if err != nil {
+			return "", err
+		}
+
+		components, err := digestPathComponents(v.referrer, false)
+		if err != nil {
+			return "", err
+		}
+
+		return path.Join(root, path.Join(components...), "link"), nil
 	case layerLinkPathSpec:
 		components, err := digestPathComponents(v.digest, false)
 		if err != nil {
@@ -210,6 +295,18 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(path.Join(append(blobLinkPathComponents, components...)...), "link"), nil
 	case layersPathSpec:
 		return path.Join(append(repoPrefix, v.name, "_layers")...), nil
+	case linkIndexPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "_index", v.kind)...), nil
+	case linkIndexShardPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "_index", v.kind, v.shard)...), nil
+	case linkIndexSegmentPathSpec:
+		return path.Join(append(repoPrefix, v.name, "_manifests", "_index", v.kind, v.shard, "segment-"+v.id)...), nil
+	case linkIndexTombstonePathSpec:
+		if err := v.digest.Validate(); err != nil {
+			return "", err
+		}
+		tombstone := "tombstone-" + v.digest.Algorithm().String() + "-" + v.digest.Hex()
+		return path.Join(append(repoPrefix, v.name, "_manifests", "_index", v.kind, linkIndexShard(v.digest), tombstone)...), nil
 	case blobsPathSpec:
 		blobsPathPrefix := append(rootPrefix, "blobs")
 		return path.Join(blobsPathPrefix...), nil
@@ -243,6 +340,36 @@ func pathFor(spec pathSpec) (string, error) {
 		return path.Join(append(repoPrefix, v.name, "_uploads", v.id, "hashstates", string(v.alg), offset)...), nil
 	case repositoriesRootPathSpec:
 		return path.Join(repoPrefix...), nil
+	case gcJournalPathSpec:
+		return path.Join(append(rootPrefix, "gcjournal")...), nil
+	case gcJournalEntryPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		journalPathPrefix := append(rootPrefix, "gcjournal")
+		return path.Join(append(append(journalPathPrefix, components...), "referencedat")...), nil
+	case trashPathSpec:
+		return path.Join(append(rootPrefix, "trash")...), nil
+	case trashEntryPathSpec:
+		components, err := digestPathComponents(v.digest, false)
+		if err != nil {
+			return "", err
+		}
+
+		trashPathPrefix := append(rootPrefix, "trash", v.name)
+		return path.Join(append(append(trashPathPrefix, components...), "record")...), nil
+	case quarantinePathSpec:
+		return path.Join(append(rootPrefix, "quarantine")...), nil
+	case quarantineDataPathSpec:
+		components, err := digestPathComponents(v.digest, true)
+		if err != nil {
+			return "", err
+		}
+
+		quarantinePathPrefix := append(rootPrefix, "quarantine")
+		return path.Join(append(append(quarantinePathPrefix, components...), "data")...), nil
 	default:
 		// TODO(sday): This is an internal error. Ensure it doesn't escape (panic?).
 		return "", fmt.Errorf("unknown path spec: %#v", v)
@@ -339,6 +466,46 @@ type manifestTagIndexEntryLinkPathSpec struct {
 
 func (manifestTagIndexEntryLinkPathSpec) pathSpec() {}
 
+// tagHistoryPathSpec describes the directory holding the history of the
+// manifests a tag pointed to.
+type tagHistoryPathSpec struct {
+	name string
+	tag  string
+}
+
+func (tagHistoryPathSpec) pathSpec() {}
+
+// tagHistoryEntryPathSpec describes the record of a tag being pointed to a
+// manifest. Entries are named after the time of the change, so that they are
+// listed in chronological order.
+type tagHistoryEntryPathSpec struct {
+	name  string
+	tag   string
+	entry string
+}
+
+func (tagHistoryEntryPathSpec) pathSpec() {}
+
+// referrersPathSpec describes the directory holding links to the manifests
+// that declare the subject manifest as their subject.
+type referrersPathSpec struct {
+	name    string
+	subject digest.Digest
+}
+
+func (referrersPathSpec) pathSpec() {}
+
+// referrerLinkPathSpec describes the path of the link recording that the
+// referrer manifest refers to the subject manifest. The contents of this file
+// should just be the referrer digest.
+type referrerLinkPathSpec struct {
+	name     string
+	subject  digest.Digest
+	referrer digest.Digest
+}
+
+func (referrerLinkPathSpec) pathSpec() {}
+
 // layersPathSpec contains the path for the layers inside a repo
 type layersPathSpec struct {
 	name string
@@ -436,6 +603,86 @@ type repositoriesRootPathSpec struct{}
 
 func (repositoriesRootPathSpec) pathSpec() {}
 
+// gcJournalPathSpec contains the path for the garbage collection journal
+type gcJournalPathSpec struct{}
+
+func (gcJournalPathSpec) pathSpec() {}
+
+// gcJournalEntryPathSpec contains the path of the file recording when a blob
+// was last referenced, for online garbage collection.
+type gcJournalEntryPathSpec struct {
+	digest digest.Digest
+}
+
+func (gcJournalEntryPathSpec) pathSpec() {}
+
+// trashPathSpec contains the path of the trash of deleted manifests
+type trashPathSpec struct{}
+
+func (trashPathSpec) pathSpec() {}
+
+// trashEntryPathSpec contains the path of the record of a manifest deleted
+// from a repository, which can be restored.
+type trashEntryPathSpec struct {
+	name   string
+	digest digest.Digest
+}
+
+func (trashEntryPathSpec) pathSpec() {}
+
+// quarantinePathSpec contains the path of the quarantine of corrupt blobs
+type quarantinePathSpec struct{}
+
+func (quarantinePathSpec) pathSpec() {}
+
+// quarantineDataPathSpec contains the path of the data of a corrupt blob
+// moved out of the blob store, which is no longer served.
+type quarantineDataPathSpec struct {
+	digest digest.Digest
+}
+
+func (quarantineDataPathSpec) pathSpec() {}
+
+// linkIndexPathSpec contains the path of the index of the packed links of a
+// kind, layers or revisions, of a repository.
+type linkIndexPathSpec struct {
+	name string
+	kind string
+}
+
+func (linkIndexPathSpec) pathSpec() {}
+
+// linkIndexShardPathSpec contains the path of a shard of the index of the
+// packed links, holding the links of the digests starting with the shard.
+type linkIndexShardPathSpec struct {
+	name  string
+	kind  string
+	shard string
+}
+
+func (linkIndexShardPathSpec) pathSpec() {}
+
+// linkIndexSegmentPathSpec contains the path of an immutable segment of a
+// shard of the index, holding a batch of packed links.
+type linkIndexSegmentPathSpec struct {
+	name  string
+	kind  string
+	shard string
+	id    string
+}
+
+func (linkIndexSegmentPathSpec) pathSpec() {}
+
+// linkIndexTombstonePathSpec contains the path of the tombstone of a packed
+// link which was removed.
+type linkIndexTombstonePathSpec struct {
+	name   string
+	kind   string
+	digest digest.Digest
+}
+
+func (linkIndexTombstonePathSpec) pathSpec() {}
+
 // digestPathComponents provides a consistent path breakdown for a given
 // digest. For a generic digest, it will be as follows:
 //
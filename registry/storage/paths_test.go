@@ -68,6 +68,36 @@ func TestPathMapper(t *testing.T) {
 			},
 			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/tags/thetag/index/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/link",
 		},
+		{
+			spec: referrersPathSpec{
+				name:    "foo/bar",
+				subject: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+		},
+		{
+			spec: referrerLinkPathSpec{
+				name:     "foo/bar",
+				subject:  "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+				referrer: "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/referrers/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/sha256/0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef/link",
+		},
+		{
+			spec: tagHistoryPathSpec{
+				name: "foo/bar",
+				tag:  "prod",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/taghistory/prod",
+		},
+		{
+			spec: tagHistoryEntryPathSpec{
+				name:  "foo/bar",
+				tag:   "prod",
+				entry: "00000001700000000000000000",
+			},
+			expected: "/docker/registry/v2/repositories/foo/bar/_manifests/taghistory/prod/00000001700000000000000000",
+		},
 
 		{
 			spec: uploadDataPathSpec{
@@ -87,6 +117,13 @@ func TestPathMapper(t *testing.T) {
 			spec:     layersPathSpec{name: "foo/bar"},
 			expected: "/docker/registry/v2/repositories/foo/bar/_layers",
 		},
+		{
+			spec: trashEntryPathSpec{
+				name:   "foo/bar",
+				digest: "sha256:abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789",
+			},
+			expected: "/docker/registry/v2/trash/foo/bar/sha256/abcdef0123456789abcdef0123456789abcdef0123456789abcdef0123456789/record",
+		},
 	} {
 		p, err := pathFor(testcase.spec)
 		if err != nil {
@@ -2,20 +2,103 @@ package storage
 
 import (
 	"context"
+	"fmt"
 	"path"
 	"strings"
 	"time"
 
+	prometheus "github.com/distribution/distribution/v3/metrics"
 	storageDriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/distribution/distribution/v3/uuid"
 	"github.com/sirupsen/logrus"
 )
 
+var (
+	// purgedUploadsCounter counts the uploads deleted by the purges
+	purgedUploadsCounter = prometheus.StorageNamespace.NewCounter("purged_uploads", "The number of stale uploads deleted by the upload purges")
+
+	// purgedUploadBytesCounter counts the bytes of the uploads deleted by
+	// the purges
+	purgedUploadBytesCounter = prometheus.StorageNamespace.NewCounter("purged_upload_bytes", "The number of bytes reclaimed by the upload purges")
+)
+
 // uploadData stored the location of temporary files created during a layer upload
 // along with the date the upload was started
 type uploadData struct {
 	containingDir string
 	startedAt     time.Time
+	repository    string
+	size          int64 // bytes received, resumable if positive
+}
+
+// UploadPurgeRule sets the age of the uploads purged in the repositories
+// whose name matches Pattern, a path.Match pattern.
+type UploadPurgeRule struct {
+	Pattern string
+	Age     time.Duration
+}
+
+// UploadPurgeOpts selects the uploads deleted by PurgeUploadsWithOpts.
+type UploadPurgeOpts struct {
+	// Age is the age of the uploads purged in the repositories no rule
+	// matches
+	Age time.Duration
+
+	// Repositories are the rules setting the age of the uploads purged in
+	// the repositories they match. The first matching rule applies.
+	Repositories []UploadPurgeRule
+
+	// ResumableAge, if longer than the age of an upload, is the age of the
+	// uploads which received data, which are kept longer so that the
+	// interrupted uploads can be resumed
+	ResumableAge time.Duration
+
+	// DryRun reports the uploads which would be deleted without deleting
+	// them
+	DryRun bool
+}
+
+// UploadPurgeResult reports the uploads deleted by a purge.
+type UploadPurgeResult struct {
+	// Deleted are the directories of the uploads deleted
+	Deleted []string
+
+	// Bytes is the number of bytes received by the uploads deleted
+	Bytes int64
+}
+
+// ValidateUploadPurgeOpts checks the ages and repository patterns of opts.
+func ValidateUploadPurgeOpts(opts UploadPurgeOpts) error {
+	if opts.Age <= 0 {
+		return fmt.Errorf("upload purge age must be positive")
+	}
+	if opts.ResumableAge < 0 {
+		return fmt.Errorf("upload purge resumable age must not be negative")
+	}
+	for _, rule := range opts.Repositories {
+		if _, err := path.Match(rule.Pattern, ""); err != nil {
+			return fmt.Errorf("invalid upload purge repository pattern %q: %v", rule.Pattern, err)
+		}
+		if rule.Age <= 0 {
+			return fmt.Errorf("upload purge age of %s must be positive", rule.Pattern)
+		}
+	}
+	return nil
+}
+
+// age returns the age past which the upload is purged.
+func (opts UploadPurgeOpts) age(upload uploadData) time.Duration {
+	age := opts.Age
+	for _, rule := range opts.Repositories {
+		if matched, _ := path.Match(rule.Pattern, upload.repository); matched {
+			age = rule.Age
+			break
+		}
+	}
+	if upload.size > 0 && opts.ResumableAge > age {
+		age = opts.ResumableAge
+	}
+	return age
 }
 
 func newUploadData() uploadData {
@@ -30,27 +113,60 @@ func newUploadData() uploadData {
 // created before olderThan.  The list of files deleted and errors
 // encountered are returned
 func PurgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, actuallyDelete bool) ([]string, []error) {
+	return PurgeUploadsExcept(ctx, driver, olderThan, nil, actuallyDelete)
+}
+
+// PurgeUploadsExcept deletes files from the upload directory created before
+// olderThan, except those of the uploads whose IDs are in keep. The list of
+// files deleted and errors encountered are returned
+func PurgeUploadsExcept(ctx context.Context, driver storageDriver.StorageDriver, olderThan time.Time, keep map[string]struct{}, actuallyDelete bool) ([]string, []error) {
 	logrus.Infof("PurgeUploads starting: olderThan=%s, actuallyDelete=%t", olderThan, actuallyDelete)
+	result, errors := purgeUploads(ctx, driver, func(uploadData) time.Time { return olderThan }, keep, actuallyDelete)
+	return result.Deleted, errors
+}
+
+// PurgeUploadsWithOpts deletes the uploads older than the age opts sets for
+// them. The uploads deleted and errors encountered are returned
+func PurgeUploadsWithOpts(ctx context.Context, driver storageDriver.StorageDriver, opts UploadPurgeOpts) (UploadPurgeResult, []error) {
+	logrus.Infof("PurgeUploads starting: age=%s, rules=%d, resumableAge=%s, actuallyDelete=%t", opts.Age, len(opts.Repositories), opts.ResumableAge, !opts.DryRun)
+	now := time.Now()
+	return purgeUploads(ctx, driver, func(upload uploadData) time.Time {
+		return now.Add(-opts.age(upload))
+	}, nil, !opts.DryRun)
+}
+
+// purgeUploads deletes the uploads started before the date olderThan returns
+// for them, except those whose IDs are in keep.
+func purgeUploads(ctx context.Context, driver storageDriver.StorageDriver, olderThan func(uploadData) time.Time, keep map[string]struct{}, actuallyDelete bool) (UploadPurgeResult, []error) {
 	uploadData, errors := getOutstandingUploads(ctx, driver)
-	var deleted []string
-	for _, uploadData := range uploadData {
-		if uploadData.startedAt.Before(olderThan) {
+	var result UploadPurgeResult
+	for uuid, uploadData := range uploadData {
+		if _, ok := keep[uuid]; ok {
+			continue
+		}
+		purgeDate := olderThan(uploadData)
+		if uploadData.startedAt.Before(purgeDate) {
 			var err error
 			logrus.Infof("Upload files in %s have older date (%s) than purge date (%s).  Removing upload directory.",
-				uploadData.containingDir, uploadData.startedAt, olderThan)
+				uploadData.containingDir, uploadData.startedAt, purgeDate)
 			if actuallyDelete {
 				err = driver.Delete(ctx, uploadData.containingDir)
 			}
 			if err == nil {
-				deleted = append(deleted, uploadData.containingDir)
+				result.Deleted = append(result.Deleted, uploadData.containingDir)
+				result.Bytes += uploadData.size
+				if actuallyDelete {
+					purgedUploadsCounter.Inc(1)
+					purgedUploadBytesCounter.Inc(float64(uploadData.size))
+				}
 			} else {
 				errors = append(errors, err)
 			}
 		}
 	}
 
-	logrus.Infof("Purge uploads finished.  Num deleted=%d, num errors=%d", len(deleted), len(errors))
-	return deleted, errors
+	logrus.Infof("Purge uploads finished.  Num deleted=%d, bytes=%d, num errors=%d", len(result.Deleted), result.Bytes, len(errors))
+	return result, errors
 }
 
 // getOutstandingUploads walks the upload directory, collecting files
@@ -91,6 +207,10 @@ func getOutstandingUploads(ctx context.Context, driver storageDriver.StorageDriv
 		}
 		if isContainingDir {
 			ud.containingDir = filePath
+			ud.repository = strings.TrimPrefix(path.Dir(path.Dir(filePath)), root+"/")
+		}
+		if file == "data" {
+			ud.size = fileInfo.Size()
 		}
 		if file == "startedat" {
 			if t, err := readStartedAtFile(driver, filePath); err == nil {
@@ -105,6 +105,90 @@ func TestPurgeSome(t *testing.T) {
 	}
 }
 
+func TestPurgeExcept(t *testing.T) {
+	oldUploadCount := 5
+	oneHourAgo := time.Now().Add(-1 * time.Hour)
+	fs, ctx := testUploadFS(t, oldUploadCount, "test-repo", oneHourAgo)
+
+	keptID := uuid.Generate().String()
+	addUploads(ctx, t, fs, keptID, "test-repo", oneHourAgo)
+
+	deleted, errs := PurgeUploadsExcept(ctx, fs, time.Now(), map[string]struct{}{keptID: {}}, true)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(deleted) != oldUploadCount {
+		t.Errorf("Unexpectedly deleted file count %d != %d",
+			len(deleted), oldUploadCount)
+	}
+	for _, file := range deleted {
+		if strings.Contains(file, keptID) {
+			t.Errorf("Kept upload deleted: %s", file)
+		}
+	}
+}
+
+func TestPurgeWithOpts(t *testing.T) {
+	twoHoursAgo := time.Now().Add(-2 * time.Hour)
+	fs, ctx := testUploadFS(t, 2, "library/test-repo", twoHoursAgo)
+	addUploads(ctx, t, fs, uuid.Generate().String(), "ci/build", twoHoursAgo)
+
+	// an upload which received data can be resumed
+	resumableID := uuid.Generate().String()
+	addUploads(ctx, t, fs, resumableID, "library/test-repo", twoHoursAgo)
+	dataPath, err := pathFor(uploadDataPathSpec{name: "library/test-repo", id: resumableID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fs.PutContent(ctx, dataPath, []byte("partial layer")); err != nil {
+		t.Fatal(err)
+	}
+
+	opts := UploadPurgeOpts{
+		Age:          time.Hour,
+		Repositories: []UploadPurgeRule{{Pattern: "ci/*", Age: 3 * time.Hour}},
+		ResumableAge: 24 * time.Hour,
+		DryRun:       true,
+	}
+	if err := ValidateUploadPurgeOpts(opts); err != nil {
+		t.Fatal(err)
+	}
+	result, errs := PurgeUploadsWithOpts(ctx, fs, opts)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(result.Deleted) != 2 {
+		t.Errorf("Unexpectedly deleted %v", result.Deleted)
+	}
+	for _, file := range result.Deleted {
+		if strings.Contains(file, "ci/build") || strings.Contains(file, resumableID) {
+			t.Errorf("Upload deleted before its age: %s", file)
+		}
+	}
+
+	// the resumable upload is purged past its age
+	opts.ResumableAge = 0
+	opts.DryRun = false
+	result, errs = PurgeUploadsWithOpts(ctx, fs, opts)
+	if len(errs) != 0 {
+		t.Error("Unexpected errors:", errs)
+	}
+	if len(result.Deleted) != 3 || result.Bytes != int64(len("partial layer")) {
+		t.Errorf("Unexpectedly deleted %v, %d bytes", result.Deleted, result.Bytes)
+	}
+
+	for _, invalid := range []UploadPurgeOpts{
+		{},
+		{Age: time.Hour, ResumableAge: -time.Hour},
+		{Age: time.Hour, Repositories: []UploadPurgeRule{{Pattern: "ci/[", Age: time.Hour}}},
+		{Age: time.Hour, Repositories: []UploadPurgeRule{{Pattern: "ci/*"}}},
+	} {
+		if err := ValidateUploadPurgeOpts(invalid); err == nil {
+			t.Errorf("expected an error validating %+v", invalid)
+		}
+	}
+}
+
 func TestPurgeOnlyUploads(t *testing.T) {
 	oldUploadCount := 5
 	oneHourAgo := time.Now().Add(-1 * time.Hour)
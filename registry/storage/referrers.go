@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"path"
+	"sort"
+
+	"github.com/distribution/distribution/v3"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+var _ distribution.ReferrerService = &referrersStore{}
+
+// referrersStore maintains an index of the manifests in a repository by the
+// subject manifest they declare. Entries are added when a manifest with a
+// subject is put, and entries whose manifest was deleted are skipped when
+// listing.
+type referrersStore struct {
+	repository *repository
+	blobStore  *blobStore
+}
+
+// referrerManifest holds the manifest fields used to describe a referrer.
+type referrerManifest struct {
+	MediaType    string `json:"mediaType,omitempty"`
+	ArtifactType string `json:"artifactType,omitempty"`
+	Config       struct {
+		MediaType string `json:"mediaType,omitempty"`
+	} `json:"config,omitempty"`
+	Subject     *distribution.Descriptor `json:"subject,omitempty"`
+	Annotations map[string]string        `json:"annotations,omitempty"`
+}
+
+// link records that the referrer manifest declares subject as its subject.
+func (rs *referrersStore) link(ctx context.Context, subject, referrer digest.Digest) error {
+	linkPath, err := pathFor(referrerLinkPathSpec{
+		name:     rs.repository.Named().Name(),
+		subject:  subject,
+		referrer: referrer,
+	})
+	if err != nil {
+		return err
+	}
+
+	return rs.blobStore.link(ctx, linkPath, referrer)
+}
+
+// Referrers returns descriptors for the manifests in the repository which
+// declare subject as their subject, ordered by digest.
+func (rs *referrersStore) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	rootPath, err := pathFor(referrersPathSpec{
+		name:    rs.repository.Named().Name(),
+		subject: subject,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	algorithms, err := rs.blobStore.driver.List(ctx, rootPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return []distribution.Descriptor{}, nil
+		}
+		return nil, err
+	}
+
+	referrers := []distribution.Descriptor{}
+	for _, algorithmPath := range algorithms {
+		encodedPaths, err := rs.blobStore.driver.List(ctx, algorithmPath)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, encodedPath := range encodedPaths {
+			dgst := digest.NewDigestFromEncoded(digest.Algorithm(path.Base(algorithmPath)), path.Base(encodedPath))
+			if err := dgst.Validate(); err != nil {
+				continue
+			}
+
+			desc, ok, err := rs.describe(ctx, dgst)
+			if err != nil {
+				return nil, err
+			}
+			if !ok || (artifactType != "" && desc.ArtifactType != artifactType) {
+				continue
+			}
+			referrers = append(referrers, desc)
+		}
+	}
+
+	sort.Slice(referrers, func(i, j int) bool {
+		return referrers[i].Digest < referrers[j].Digest
+	})
+
+	return referrers, nil
+}
+
+// describe builds the referrers API descriptor of a manifest. If the manifest
+// is no longer linked into the repository, false is returned.
+func (rs *referrersStore) describe(ctx context.Context, dgst digest.Digest) (distribution.Descriptor, bool, error) {
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{
+		name:     rs.repository.Named().Name(),
+		revision: dgst,
+	})
+	if err != nil {
+		return distribution.Descriptor{}, false, err
+	}
+
+	index := rs.repository.registry.linkIndex(rs.repository.Named().Name(), linkIndexRevisions)
+	if _, err := linkedAt(ctx, rs.blobStore.driver, revisionPath, index, dgst); err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return distribution.Descriptor{}, false, nil
+		}
+		return distribution.Descriptor{}, false, err
+	}
+
+	payload, err := rs.blobStore.Get(ctx, dgst)
+	if err != nil {
+		if err == distribution.ErrBlobUnknown {
+			return distribution.Descriptor{}, false, nil
+		}
+		return distribution.Descriptor{}, false, err
+	}
+
+	var m referrerManifest
+	if err := json.Unmarshal(payload, &m); err != nil {
+		return distribution.Descriptor{}, false, err
+	}
+
+	artifactType := m.ArtifactType
+	if artifactType == "" {
+		artifactType = m.Config.MediaType
+	}
+
+	return distribution.Descriptor{
+		MediaType:    m.MediaType,
+		Digest:       dgst,
+		Size:         int64(len(payload)),
+		ArtifactType: artifactType,
+		Annotations:  m.Annotations,
+	}, true, nil
+}
+
+// manifestSubject returns the digest of the subject declared in the manifest
+// payload, if any.
+func manifestSubject(payload []byte) (digest.Digest, bool) {
+	var m referrerManifest
+	if err := json.Unmarshal(payload, &m); err != nil || m.Subject == nil || m.Subject.Digest == "" {
+		return "", false
+	}
+	return m.Subject.Digest, true
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// putRawOCIManifest puts an OCI image manifest given as raw JSON.
+func putRawOCIManifest(t *testing.T, manifestService distribution.ManifestService, payload string) digest.Digest {
+	m := &ocischema.DeserializedManifest{}
+	if err := m.UnmarshalJSON([]byte(payload)); err != nil {
+		t.Fatal(err)
+	}
+
+	dgst, err := manifestService.Put(context.Background(), m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dgst
+}
+
+func TestReferrers(t *testing.T) {
+	ctx := context.Background()
+	registry := createRegistry(t, inmemory.New())
+	repo := makeRepository(t, registry, "foo/referrers")
+
+	manifestService, err := repo.Manifests(ctx, SkipLayerVerification())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject := putRawOCIManifest(t, manifestService, fmt.Sprintf(`{
+		"schemaVersion": 2,
+		"mediaType": %q,
+		"config": {"mediaType": %q, "digest": %q, "size": 2},
+		"layers": []
+	}`, v1.MediaTypeImageManifest, v1.MediaTypeImageConfig, digest.FromString("{}")))
+
+	referrerTemplate := `{
+		"schemaVersion": 2,
+		"mediaType": %q,
+		"artifactType": %q,
+		"config": {"mediaType": "application/vnd.oci.empty.v1+json", "digest": %q, "size": 2},
+		"layers": [],
+		"subject": {"mediaType": %q, "digest": %q, "size": 100},
+		"annotations": {"org.example.name": %q}
+	}`
+
+	signature := putRawOCIManifest(t, manifestService, fmt.Sprintf(referrerTemplate,
+		v1.MediaTypeImageManifest, "application/vnd.example.signature", digest.FromString("{}"), v1.MediaTypeImageManifest, subject, "signature"))
+	sbom := putRawOCIManifest(t, manifestService, fmt.Sprintf(referrerTemplate,
+		v1.MediaTypeImageManifest, "application/vnd.example.sbom", digest.FromString("{}"), v1.MediaTypeImageManifest, subject, "sbom"))
+
+	referrerService, ok := repo.(distribution.ReferrerService)
+	if !ok {
+		t.Fatal("repository does not implement ReferrerService")
+	}
+
+	referrers, err := referrerService.Referrers(ctx, subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 2 {
+		t.Fatalf("expected 2 referrers, got %d", len(referrers))
+	}
+	for _, desc := range referrers {
+		if desc.Digest != signature && desc.Digest != sbom {
+			t.Errorf("unexpected referrer %s", desc.Digest)
+		}
+		if desc.MediaType != v1.MediaTypeImageManifest {
+			t.Errorf("unexpected media type %q", desc.MediaType)
+		}
+	}
+
+	referrers, err = referrerService.Referrers(ctx, subject, "application/vnd.example.sbom")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sbom {
+		t.Fatalf("unexpected filtered referrers: %v", referrers)
+	}
+	if referrers[0].Annotations["org.example.name"] != "sbom" {
+		t.Errorf("unexpected annotations: %v", referrers[0].Annotations)
+	}
+
+	// Deleted manifests are no longer listed
+	if err := manifestService.Delete(ctx, signature); err != nil {
+		t.Fatal(err)
+	}
+	referrers, err = referrerService.Referrers(ctx, subject, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 1 || referrers[0].Digest != sbom {
+		t.Fatalf("unexpected referrers after delete: %v", referrers)
+	}
+
+	// Manifests without referrers have an empty list
+	referrers, err = referrerService.Referrers(ctx, sbom, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(referrers) != 0 {
+		t.Fatalf("expected no referrers, got %v", referrers)
+	}
+}
@@ -2,6 +2,9 @@ package storage
 
 import (
 	"context"
+	// registers sha512, so that content addressed with it is served
+	_ "crypto/sha512"
+	"fmt"
 	"regexp"
 
 	"github.com/distribution/distribution/v3"
@@ -9,6 +12,7 @@ import (
 	"github.com/distribution/distribution/v3/registry/storage/cache"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/docker/libtrust"
+	"github.com/opencontainers/go-digest"
 )
 
 // registry is the top-level implementation of Registry for use in the storage
@@ -19,12 +23,17 @@ type registry struct {
 	statter                      *blobStatter // global statter service.
 	blobDescriptorCacheProvider  cache.BlobDescriptorCacheProvider
 	deleteEnabled                bool
+	trashEnabled                 bool
 	schema1Enabled               bool
 	resumableDigestEnabled       bool
 	schema1SigningKey            libtrust.PrivateKey
 	blobDescriptorServiceFactory distribution.BlobDescriptorServiceFactory
 	manifestURLs                 manifestURLs
 	driver                       storagedriver.StorageDriver
+
+	// linkSegments caches the segments of the indexes of the packed links,
+	// nil if the links are not packed
+	linkSegments *linkSegmentCache
 }
 
 // manifestURLs holds regular expressions for controlling manifest URL whitelisting
@@ -50,6 +59,15 @@ func EnableDelete(registry *registry) error {
 	return nil
 }
 
+// EnableManifestTrash is a functional option for NewRegistry. Manifests
+// deleted by digest are moved to a trash area, from which they can be
+// restored with RestoreManifest until they are purged. Garbage collection
+// keeps the content of the manifests in the trash.
+func EnableManifestTrash(registry *registry) error {
+	registry.trashEnabled = true
+	return nil
+}
+
 // EnableSchema1 is a functional option for NewRegistry. It enables pushing of
 // schema1 manifests.
 func EnableSchema1(registry *registry) error {
@@ -64,6 +82,38 @@ func DisableDigestResumption(registry *registry) error {
 	return nil
 }
 
+// EnableGCJournal is a functional option for NewRegistry. It records the
+// blobs referenced by the registry in a journal, so that garbage collection
+// can run online while the registry serves pushes.
+func EnableGCJournal(registry *registry) error {
+	registry.blobStore.journal = &gcJournal{driver: registry.driver}
+	return nil
+}
+
+// EnablePackedLinks is a functional option for NewRegistry. It enables the
+// packed layout of the links of the repositories: the link files of their
+// layers and manifest revisions are read from, and packed by MigrateLayout
+// into, the sharded index of each repository. The link files of the
+// layout without an index are still read.
+func EnablePackedLinks(registry *registry) error {
+	registry.linkSegments = newLinkSegmentCache()
+	return nil
+}
+
+// DigestAlgorithm returns a functional option for NewRegistry. It sets the
+// algorithm of the digests computed for new content, sha256 by default.
+// Content addressed with the other available algorithms is still verified
+// and served.
+func DigestAlgorithm(algorithm digest.Algorithm) RegistryOption {
+	return func(registry *registry) error {
+		if !algorithm.Available() {
+			return fmt.Errorf("digest algorithm %q is not available", algorithm)
+		}
+		registry.blobStore.algorithm = algorithm
+		return nil
+	}
+}
+
 // ManifestURLsAllowRegexp is a functional option for NewRegistry.
 func ManifestURLsAllowRegexp(r *regexp.Regexp) RegistryOption {
 	return func(registry *registry) error {
@@ -211,6 +261,17 @@ func (repo *repository) Tags(ctx context.Context) distribution.TagService {
 	return tags
 }
 
+// Referrers returns descriptors for the manifests in the repository which
+// declare the subject manifest as their subject.
+func (repo *repository) Referrers(ctx context.Context, subject digest.Digest, artifactType string) ([]distribution.Descriptor, error) {
+	referrers := &referrersStore{
+		repository: repo,
+		blobStore:  repo.registry.blobStore,
+	}
+
+	return referrers.Referrers(ctx, subject, artifactType)
+}
+
 // Manifests returns an instance of ManifestService. Instantiation is cheap and
 // may be context sensitive in the future. The instance should be used similar
 // to a request local.
@@ -221,6 +282,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		blobStore:  repo.blobStore,
 		repository: repo,
 		linkPath:   manifestRevisionLinkPath,
+		index:      repo.registry.linkIndex(repo.name.Name(), linkIndexRevisions),
 	}
 
 	if repo.registry.blobDescriptorServiceFactory != nil {
@@ -238,6 +300,7 @@ func (repo *repository) Manifests(ctx context.Context, options ...distribution.M
 		// manifests. This instance cannot be used for blob checks.
 		linkPath:              manifestRevisionLinkPath,
 		linkDirectoryPathSpec: manifestDirectoryPathSpec,
+		index:                 repo.registry.linkIndex(repo.name.Name(), linkIndexRevisions),
 	}
 
 	var v1Handler ManifestHandler
@@ -302,6 +365,7 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 		blobStore:  repo.blobStore,
 		repository: repo,
 		linkPath:   blobLinkPath,
+		index:      repo.registry.linkIndex(repo.name.Name(), linkIndexLayers),
 	}
 
 	if repo.descriptorCache != nil {
@@ -324,6 +388,7 @@ func (repo *repository) Blobs(ctx context.Context) distribution.BlobStore {
 		// This instance cannot be used for manifest checks.
 		linkPath:               blobLinkPath,
 		linkDirectoryPathSpec:  layersPathSpec{name: repo.name.Name()},
+		index:                  repo.registry.linkIndex(repo.name.Name(), linkIndexLayers),
 		deleteEnabled:          repo.registry.deleteEnabled,
 		resumableDigestEnabled: repo.resumableDigestEnabled,
 	}
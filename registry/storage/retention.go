@@ -0,0 +1,308 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// RetentionPolicy selects the tags and untagged manifests of the matching
+// repositories which are deleted.
+type RetentionPolicy struct {
+	// Repository is a path.Match pattern of the repository names the
+	// policy applies to.
+	Repository string
+	// Tags lists path.Match patterns of the tags subject to KeepLast and
+	// KeepNewerThan. All tags are subject to them when empty.
+	Tags []string
+	// KeepLast is the number of most recently updated tags which are
+	// kept.
+	KeepLast int
+	// KeepNewerThan keeps the tags updated within this duration. Tags are
+	// only deleted when KeepLast or KeepNewerThan is set, and are kept
+	// when either of them keeps them.
+	KeepNewerThan time.Duration
+	// UntaggedOlderThan deletes the untagged manifests pushed before this
+	// duration. Manifests referenced by a tagged image index, or declaring
+	// a kept manifest as their subject, are not untagged. Untagged
+	// manifests are kept when unset.
+	UntaggedOlderThan time.Duration
+}
+
+// validate checks the patterns of the policy.
+func (p RetentionPolicy) validate() error {
+	if _, err := path.Match(p.Repository, ""); err != nil || p.Repository == "" {
+		return fmt.Errorf("invalid retention policy repository %q", p.Repository)
+	}
+	for _, tag := range p.Tags {
+		if _, err := path.Match(tag, ""); err != nil {
+			return fmt.Errorf("invalid retention policy tag %q", tag)
+		}
+	}
+	if p.KeepLast < 0 || p.KeepNewerThan < 0 || p.UntaggedOlderThan < 0 {
+		return fmt.Errorf("retention policy of %q has a negative limit", p.Repository)
+	}
+	return nil
+}
+
+// matchesTag reports whether the tag is subject to the policy.
+func (p RetentionPolicy) matchesTag(tag string) bool {
+	if len(p.Tags) == 0 {
+		return true
+	}
+	for _, pattern := range p.Tags {
+		if matched, _ := path.Match(pattern, tag); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// RetentionListener is notified of the tags and manifests deleted by the
+// retention policies. notifications.Listener implements it.
+type RetentionListener interface {
+	TagDeleted(repo reference.Named, tag string) error
+	ManifestDeleted(repo reference.Named, dgst digest.Digest) error
+}
+
+// RetentionOpts contains the options of ApplyRetention.
+type RetentionOpts struct {
+	// Policies are the retention policies. The first policy matching a
+	// repository applies to it, and repositories no policy matches are
+	// left alone.
+	Policies []RetentionPolicy
+	// DryRun returns what the policies would delete without deleting it.
+	DryRun bool
+	// Listener, if set, is notified of the deleted tags and manifests.
+	Listener RetentionListener
+}
+
+// ValidateRetentionPolicies checks the patterns and limits of the policies.
+func ValidateRetentionPolicies(policies []RetentionPolicy) error {
+	for _, policy := range policies {
+		if err := policy.validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ApplyRetention deletes the tags and untagged manifests selected by the
+// retention policies, and returns the references deleted, in the form
+// repository:tag and repository@digest. The blobs of the deleted manifests
+// are deleted by the next garbage collection.
+func ApplyRetention(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts RetentionOpts) ([]string, error) {
+	if err := ValidateRetentionPolicies(opts.Policies); err != nil {
+		return nil, err
+	}
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+
+	var deleted []string
+	now := time.Now()
+	err := repositoryEnumerator.Enumerate(ctx, func(repoName string) error {
+		for _, policy := range opts.Policies {
+			if matched, _ := path.Match(policy.Repository, repoName); matched {
+				refs, err := applyRetentionPolicy(ctx, storageDriver, registry, repoName, policy, now, opts)
+				deleted = append(deleted, refs...)
+				return err
+			}
+		}
+		return nil
+	})
+	// A registry without repositories has no repositories directory
+	if _, ok := err.(driver.PathNotFoundError); ok {
+		err = nil
+	}
+	return deleted, err
+}
+
+// retainedTag is a tag of a repository with the time it was last updated.
+type retainedTag struct {
+	name    string
+	digest  digest.Digest
+	updated time.Time
+}
+
+func applyRetentionPolicy(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, repoName string, policy RetentionPolicy, now time.Time, opts RetentionOpts) ([]string, error) {
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return nil, fmt.Errorf("failed to construct repository: %v", err)
+	}
+	tagService := repository.Tags(ctx)
+
+	allTags, err := tagService.All(ctx)
+	if _, ok := err.(distribution.ErrRepositoryUnknown); err != nil && !ok {
+		return nil, fmt.Errorf("failed to retrieve the tags of %s: %v", repoName, err)
+	}
+	var tags []retainedTag
+	for _, tag := range allTags {
+		desc, err := tagService.Get(ctx, tag)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve tag %s of %s: %v", tag, repoName, err)
+		}
+		tagPath, err := pathFor(manifestTagCurrentPathSpec{name: repoName, tag: tag})
+		if err != nil {
+			return nil, err
+		}
+		fi, err := storageDriver.Stat(ctx, tagPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to retrieve tag %s of %s: %v", tag, repoName, err)
+		}
+		tags = append(tags, retainedTag{name: tag, digest: desc.Digest, updated: fi.ModTime()})
+	}
+
+	var deleted []string
+	notify := func(f func(RetentionListener) error) {
+		if opts.Listener == nil {
+			return
+		}
+		if err := f(opts.Listener); err != nil {
+			dcontext.GetLogger(ctx).Errorf("error notifying a retention deletion in %s: %v", repoName, err)
+		}
+	}
+
+	// The most recently updated tags subject to the policy come first
+	sort.SliceStable(tags, func(i, j int) bool {
+		return tags[i].updated.After(tags[j].updated)
+	})
+	var kept []retainedTag
+	var matching int
+	for _, tag := range tags {
+		if policy.KeepLast == 0 && policy.KeepNewerThan == 0 || !policy.matchesTag(tag.name) {
+			kept = append(kept, tag)
+			continue
+		}
+		matching++
+		if matching <= policy.KeepLast || policy.KeepNewerThan > 0 && now.Sub(tag.updated) < policy.KeepNewerThan {
+			kept = append(kept, tag)
+			continue
+		}
+
+		if !opts.DryRun {
+			if err := tagService.Untag(ctx, tag.name); err != nil {
+				return deleted, fmt.Errorf("failed to delete tag %s of %s: %v", tag.name, repoName, err)
+			}
+			notify(func(l RetentionListener) error { return l.TagDeleted(named, tag.name) })
+		}
+		deleted = append(deleted, repoName+":"+tag.name)
+	}
+
+	if policy.UntaggedOlderThan == 0 {
+		return deleted, nil
+	}
+
+	manifestService, err := repository.Manifests(ctx)
+	if err != nil {
+		return deleted, fmt.Errorf("failed to construct manifest service: %v", err)
+	}
+	manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+	if !ok {
+		return deleted, fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+	}
+	manifests := make(map[digest.Digest]distribution.Manifest)
+	err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+		manifest, err := manifestService.Get(ctx, dgst)
+		if err != nil {
+			return fmt.Errorf("failed to retrieve manifest %s of %s: %v", dgst, repoName, err)
+		}
+		manifests[dgst] = manifest
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return deleted, err
+	}
+
+	// The manifests of the kept tags, the children of kept image indexes
+	// and the referrers of kept manifests are kept
+	keep := make(map[digest.Digest]struct{})
+	var markKept func(dgst digest.Digest)
+	markKept = func(dgst digest.Digest) {
+		manifest, ok := manifests[dgst]
+		if _, marked := keep[dgst]; marked || !ok {
+			return
+		}
+		keep[dgst] = struct{}{}
+		for _, desc := range manifest.References() {
+			markKept(desc.Digest)
+		}
+	}
+	for _, tag := range kept {
+		markKept(tag.digest)
+	}
+	for marked := -1; marked != len(keep); {
+		marked = len(keep)
+		for dgst, manifest := range manifests {
+			if _, ok := keep[dgst]; ok {
+				continue
+			}
+			_, payload, err := manifest.Payload()
+			if err != nil {
+				return deleted, err
+			}
+			if subject, ok := manifestSubject(payload); ok {
+				if _, ok := keep[subject]; ok {
+					markKept(dgst)
+				}
+			}
+		}
+	}
+
+	var untagged []digest.Digest
+	for dgst := range manifests {
+		if _, ok := keep[dgst]; ok {
+			continue
+		}
+		revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: repoName, revision: dgst})
+		if err != nil {
+			return deleted, err
+		}
+		modTime, err := linkedAt(ctx, storageDriver, revisionPath, linkIndexOf(registry, repoName, linkIndexRevisions), dgst)
+		if err != nil {
+			return deleted, fmt.Errorf("failed to retrieve manifest %s of %s: %v", dgst, repoName, err)
+		}
+		if now.Sub(modTime) >= policy.UntaggedOlderThan {
+			untagged = append(untagged, dgst)
+		}
+	}
+	sort.Slice(untagged, func(i, j int) bool { return untagged[i] < untagged[j] })
+
+	remainingTags := make([]string, 0, len(kept))
+	for _, tag := range kept {
+		remainingTags = append(remainingTags, tag.name)
+	}
+	vacuum := NewVacuum(ctx, storageDriver)
+	for _, dgst := range untagged {
+		if !opts.DryRun {
+			// The manifest may have been tagged since the tags were
+			// listed
+			tags, err := tagService.Lookup(ctx, distribution.Descriptor{Digest: dgst})
+			if err != nil {
+				return deleted, fmt.Errorf("failed to retrieve tags for digest %v: %v", dgst, err)
+			}
+			if len(tags) > 0 {
+				continue
+			}
+			if err := vacuum.RemoveManifest(repoName, dgst, remainingTags); err != nil {
+				return deleted, fmt.Errorf("failed to delete manifest %s of %s: %v", dgst, repoName, err)
+			}
+			notify(func(l RetentionListener) error { return l.ManifestDeleted(named, dgst) })
+		}
+		deleted = append(deleted, repoName+"@"+dgst.String())
+	}
+	return deleted, nil
+}
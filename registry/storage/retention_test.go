@@ -0,0 +1,123 @@
+package storage
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/opencontainers/go-digest"
+)
+
+type retentionRecorder struct {
+	deleted []string
+}
+
+func (r *retentionRecorder) TagDeleted(repo reference.Named, tag string) error {
+	r.deleted = append(r.deleted, repo.Name()+":"+tag)
+	return nil
+}
+
+func (r *retentionRecorder) ManifestDeleted(repo reference.Named, dgst digest.Digest) error {
+	r.deleted = append(r.deleted, repo.Name()+"@"+dgst.String())
+	return nil
+}
+
+func TestApplyRetention(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	registry := createRegistry(t, inmemoryDriver)
+
+	repo := makeRepository(t, registry, "team/app")
+	var images []digest.Digest
+	for _, tag := range []string{"v1", "v2", "v3"} {
+		dgst := uploadRandomSchema2Image(t, repo).manifestDigest
+		if err := repo.Tags(ctx).Tag(ctx, tag, distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+		images = append(images, dgst)
+		time.Sleep(10 * time.Millisecond)
+	}
+	// latest is not subject to the tag patterns, and keeps v1's image
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: images[0]}); err != nil {
+		t.Fatal(err)
+	}
+	untagged := uploadRandomSchema2Image(t, repo).manifestDigest
+
+	other := makeRepository(t, registry, "other")
+	otherUntagged := uploadRandomSchema2Image(t, other).manifestDigest
+
+	policies := []RetentionPolicy{{
+		Repository:        "team/*",
+		Tags:              []string{"v*"},
+		KeepLast:          1,
+		UntaggedOlderThan: time.Nanosecond,
+	}}
+	// The tags are deleted from the most recently updated one
+	expected := []string{
+		"team/app:v2",
+		"team/app:v1",
+	}
+	for _, dgst := range []digest.Digest{images[1], untagged} {
+		expected = append(expected, "team/app@"+dgst.String())
+	}
+	sort.Strings(expected[2:])
+
+	deleted, err := ApplyRetention(ctx, inmemoryDriver, registry, RetentionOpts{Policies: policies, DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deleted, expected) {
+		t.Fatalf("expected a dry run to select %v, got %v", expected, deleted)
+	}
+	if tags, _ := repo.Tags(ctx).All(ctx); len(tags) != 4 {
+		t.Fatalf("expected a dry run not to delete tags, got %v", tags)
+	}
+
+	listener := &retentionRecorder{}
+	deleted, err = ApplyRetention(ctx, inmemoryDriver, registry, RetentionOpts{Policies: policies, Listener: listener})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(deleted, expected) || !reflect.DeepEqual(listener.deleted, expected) {
+		t.Fatalf("expected %v to be deleted and notified, got %v and %v", expected, deleted, listener.deleted)
+	}
+
+	tags, err := repo.Tags(ctx).All(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sort.Strings(tags)
+	if !reflect.DeepEqual(tags, []string{"latest", "v3"}) {
+		t.Errorf("unexpected remaining tags %v", tags)
+	}
+	manifests := allManifests(t, makeManifestService(t, repo))
+	if len(manifests) != 2 {
+		t.Errorf("expected the images of latest and v3 to be kept, got %v", manifests)
+	}
+	for _, dgst := range []digest.Digest{images[0], images[2]} {
+		if _, ok := manifests[dgst]; !ok {
+			t.Errorf("expected tagged manifest %s to be kept", dgst)
+		}
+	}
+	if _, ok := allManifests(t, makeManifestService(t, other))[otherUntagged]; !ok {
+		t.Error("expected the repositories no policy matches to be left alone")
+	}
+}
+
+func TestRetentionPolicyValidation(t *testing.T) {
+	for _, policy := range []RetentionPolicy{
+		{},
+		{Repository: "["},
+		{Repository: "app", Tags: []string{"["}},
+		{Repository: "app", KeepLast: -1},
+	} {
+		if err := ValidateRetentionPolicies([]RetentionPolicy{policy}); err == nil {
+			t.Errorf("expected an error with policy %+v", policy)
+		}
+	}
+}
@@ -0,0 +1,221 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// defaultStatsTop is the default number of largest layers of the statistics.
+const defaultStatsTop = 10
+
+// StatsOpts contains the options of CollectStats.
+type StatsOpts struct {
+	// Top is the number of largest layers reported. Defaults to 10.
+	Top int
+	// Concurrency is the number of parallel walks of the storage, and of
+	// repositories enumerated at the same time.
+	Concurrency int
+}
+
+// Stats holds the space used by the blobs of the registry, both as stored
+// and as seen by the repositories.
+type Stats struct {
+	// Blobs is the number of blobs stored
+	Blobs int `json:"blobs"`
+	// UniqueBytes is the size of the blobs stored
+	UniqueBytes int64 `json:"unique_bytes"`
+	// LogicalBytes is the sum of the sizes of the blobs linked by each
+	// repository, which is the space the repositories would use without
+	// sharing their blobs
+	LogicalBytes int64 `json:"logical_bytes"`
+	// DedupRatio is LogicalBytes over UniqueBytes
+	DedupRatio float64 `json:"dedup_ratio"`
+	// LargestLayers are the largest layers, largest first
+	LargestLayers []StatsLayer `json:"largest_layers"`
+	// Repositories are the footprints of the repositories, by name
+	Repositories []StatsRepository `json:"repositories"`
+}
+
+// StatsLayer is a layer and the number of repositories linking it.
+type StatsLayer struct {
+	Digest       digest.Digest `json:"digest"`
+	Size         int64         `json:"size"`
+	Repositories int           `json:"repositories"`
+}
+
+// StatsRepository is the footprint of a repository: the blobs it links, as
+// layers or manifests, and the size of those linked by no other repository.
+type StatsRepository struct {
+	Name           string `json:"name"`
+	Blobs          int    `json:"blobs"`
+	Bytes          int64  `json:"bytes"`
+	ExclusiveBytes int64  `json:"exclusive_bytes"`
+}
+
+// CollectStats walks the storage and computes the space used by the blobs of
+// the registry, and by each repository.
+func CollectStats(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, opts StatsOpts) (*Stats, error) {
+	repositoryEnumerator, ok := registry.(distribution.RepositoryEnumerator)
+	if !ok {
+		return nil, fmt.Errorf("unable to convert Namespace to RepositoryEnumerator")
+	}
+	enumerateRepositories := repositoryEnumerator.Enumerate
+	if e, ok := registry.(parallelRepositoryEnumerator); ok && opts.Concurrency > 1 {
+		enumerateRepositories = func(ctx context.Context, ingester func(string) error) error {
+			return e.enumerateParallel(ctx, opts.Concurrency, ingester)
+		}
+	}
+	top := opts.Top
+	if top <= 0 {
+		top = defaultStatsTop
+	}
+	concurrency := opts.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	sizes := make(map[digest.Digest]int64)
+	blobsPath, err := pathFor(blobsPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+	err = driver.WalkParallel(ctx, storageDriver, blobsPath, concurrency, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "data" {
+			return nil
+		}
+		dgst, err := digestFromPath(fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		mu.Lock()
+		sizes[dgst] = fileInfo.Size()
+		mu.Unlock()
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return nil, fmt.Errorf("failed to walk the blobs: %v", err)
+	}
+
+	// linkedBy maps the blobs to the repositories linking them, and layers
+	// holds the blobs linked as layers
+	linkedBy := make(map[digest.Digest][]string)
+	layers := make(map[digest.Digest]struct{})
+	var repositories []string
+	err = enumerateRepositories(ctx, func(repoName string) error {
+		named, err := reference.WithName(repoName)
+		if err != nil {
+			return fmt.Errorf("failed to parse repo name %s: %v", repoName, err)
+		}
+		repository, err := registry.Repository(ctx, named)
+		if err != nil {
+			return fmt.Errorf("failed to construct repository: %v", err)
+		}
+
+		linked := make(map[digest.Digest]struct{})
+		var repoLayers []digest.Digest
+		blobEnumerator, ok := repository.Blobs(ctx).(distribution.BlobEnumerator)
+		if !ok {
+			return fmt.Errorf("unable to convert BlobStore into BlobEnumerator")
+		}
+		err = blobEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			linked[dgst] = struct{}{}
+			repoLayers = append(repoLayers, dgst)
+			return nil
+		})
+		if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			return fmt.Errorf("failed to enumerate the blobs of %s: %v", repoName, err)
+		}
+
+		manifestService, err := repository.Manifests(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to construct manifest service: %v", err)
+		}
+		manifestEnumerator, ok := manifestService.(distribution.ManifestEnumerator)
+		if !ok {
+			return fmt.Errorf("unable to convert ManifestService into ManifestEnumerator")
+		}
+		err = manifestEnumerator.Enumerate(ctx, func(dgst digest.Digest) error {
+			linked[dgst] = struct{}{}
+			return nil
+		})
+		if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+			return fmt.Errorf("failed to enumerate the manifests of %s: %v", repoName, err)
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		repositories = append(repositories, repoName)
+		for dgst := range linked {
+			linkedBy[dgst] = append(linkedBy[dgst], repoName)
+		}
+		for _, dgst := range repoLayers {
+			layers[dgst] = struct{}{}
+		}
+		return nil
+	})
+	// A registry without repositories has no repositories directory
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return nil, fmt.Errorf("failed to enumerate the repositories: %v", err)
+	}
+
+	stats := &Stats{
+		Blobs:         len(sizes),
+		LargestLayers: []StatsLayer{},
+		Repositories:  make([]StatsRepository, 0, len(repositories)),
+	}
+	for _, size := range sizes {
+		stats.UniqueBytes += size
+	}
+
+	footprints := make(map[string]*StatsRepository, len(repositories))
+	for _, name := range repositories {
+		footprints[name] = &StatsRepository{Name: name}
+	}
+	for dgst, repos := range linkedBy {
+		// Links to blobs which are not stored take no space
+		size, ok := sizes[dgst]
+		if !ok {
+			continue
+		}
+		for _, name := range repos {
+			footprint := footprints[name]
+			footprint.Blobs++
+			footprint.Bytes += size
+			if len(repos) == 1 {
+				footprint.ExclusiveBytes += size
+			}
+			stats.LogicalBytes += size
+		}
+	}
+	if stats.UniqueBytes > 0 {
+		stats.DedupRatio = float64(stats.LogicalBytes) / float64(stats.UniqueBytes)
+	}
+
+	for dgst := range layers {
+		if size, ok := sizes[dgst]; ok {
+			stats.LargestLayers = append(stats.LargestLayers, StatsLayer{Digest: dgst, Size: size, Repositories: len(linkedBy[dgst])})
+		}
+	}
+	sort.Slice(stats.LargestLayers, func(i, j int) bool {
+		a, b := stats.LargestLayers[i], stats.LargestLayers[j]
+		return a.Size > b.Size || a.Size == b.Size && a.Digest < b.Digest
+	})
+	if len(stats.LargestLayers) > top {
+		stats.LargestLayers = stats.LargestLayers[:top]
+	}
+
+	sort.Strings(repositories)
+	for _, name := range repositories {
+		stats.Repositories = append(stats.Repositories, *footprints[name])
+	}
+	return stats, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"io"
+	"testing"
+
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+	"github.com/distribution/distribution/v3/testutil"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestCollectStats(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+	registry := createRegistry(t, inmemoryDriver)
+
+	repoA := makeRepository(t, registry, "a")
+	imageA := uploadRandomSchema2Image(t, repoA)
+	repoB := makeRepository(t, registry, "b")
+	uploadRandomSchema2Image(t, repoB)
+
+	// b also links a layer of a
+	shared := getAnyKey(imageA.layers)
+	if _, err := imageA.layers[shared].Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err := testutil.UploadBlobs(repoB, map[digest.Digest]io.ReadSeeker{shared: imageA.layers[shared]}); err != nil {
+		t.Fatal(err)
+	}
+	sharedSize, err := blobSize(ctx, inmemoryDriver, shared)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, concurrency := range []int{1, 4} {
+		stats, err := CollectStats(ctx, inmemoryDriver, registry, StatsOpts{Top: 1, Concurrency: concurrency})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if stats.Blobs != len(allBlobs(t, registry)) {
+			t.Errorf("expected %d blobs, got %d", len(allBlobs(t, registry)), stats.Blobs)
+		}
+		if stats.LogicalBytes != stats.UniqueBytes+sharedSize {
+			t.Errorf("expected the shared layer to be counted twice, got %d logical and %d unique bytes", stats.LogicalBytes, stats.UniqueBytes)
+		}
+		if stats.DedupRatio <= 1 {
+			t.Errorf("unexpected dedup ratio %f", stats.DedupRatio)
+		}
+
+		if len(stats.Repositories) != 2 || stats.Repositories[0].Name != "a" || stats.Repositories[1].Name != "b" {
+			t.Fatalf("unexpected repositories %+v", stats.Repositories)
+		}
+		a, b := stats.Repositories[0], stats.Repositories[1]
+		if a.Bytes+b.Bytes != stats.LogicalBytes || a.ExclusiveBytes != a.Bytes-sharedSize || b.ExclusiveBytes != b.Bytes-sharedSize {
+			t.Errorf("unexpected repository footprints %+v", stats.Repositories)
+		}
+
+		if len(stats.LargestLayers) != 1 {
+			t.Fatalf("expected a single largest layer, got %v", stats.LargestLayers)
+		}
+		for dgst := range imageA.layers {
+			size, err := blobSize(ctx, inmemoryDriver, dgst)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if size > stats.LargestLayers[0].Size {
+				t.Errorf("layer %s is larger than the largest layer %v", dgst, stats.LargestLayers[0])
+			}
+		}
+	}
+}
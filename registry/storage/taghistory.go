@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// maxTagHistoryEntries is the number of changes kept in the history of a
+// tag. The oldest changes are removed beyond it.
+const maxTagHistoryEntries = 100
+
+var _ distribution.TagHistoryProvider = &tagStore{}
+
+// History returns the changes of the manifest the tag points to, from the
+// most recent one. Changes made before the history was recorded are not
+// known.
+func (ts *tagStore) History(ctx context.Context, tag string) ([]distribution.TagHistoryEntry, error) {
+	entries, err := ts.historyEntries(ctx, tag)
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		// Tell apart tags without history from unknown ones
+		if _, err := ts.Get(ctx, tag); err != nil {
+			return nil, err
+		}
+	}
+
+	history := make([]distribution.TagHistoryEntry, 0, len(entries))
+	for i := len(entries) - 1; i >= 0; i-- {
+		content, err := ts.blobStore.driver.GetContent(ctx, entries[i])
+		if err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); ok {
+				// pruned since listed
+				continue
+			}
+			return nil, err
+		}
+
+		var entry distribution.TagHistoryEntry
+		if err := json.Unmarshal(content, &entry); err != nil {
+			return nil, fmt.Errorf("invalid tag history entry %s: %v", entries[i], err)
+		}
+		history = append(history, entry)
+	}
+	return history, nil
+}
+
+// recordHistory appends the change of the manifest the tag points to to its
+// history, and prunes the oldest changes.
+func (ts *tagStore) recordHistory(ctx context.Context, tag string, previous, dgst digest.Digest) error {
+	now := time.Now().UTC()
+	entryPath, err := pathFor(tagHistoryEntryPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+		// Entries are listed in lexical order, so the time is zero padded
+		entry: fmt.Sprintf("%020d-%s", now.UnixNano(), dgst.Encoded()),
+	})
+	if err != nil {
+		return err
+	}
+
+	content, err := json.Marshal(distribution.TagHistoryEntry{
+		Digest:    dgst,
+		Previous:  previous,
+		Timestamp: now,
+	})
+	if err != nil {
+		return err
+	}
+	if err := ts.blobStore.driver.PutContent(ctx, entryPath, content); err != nil {
+		return err
+	}
+
+	entries, err := ts.historyEntries(ctx, tag)
+	if err != nil {
+		return err
+	}
+	for len(entries) > maxTagHistoryEntries {
+		if err := ts.blobStore.driver.Delete(ctx, entries[0]); err != nil {
+			if _, ok := err.(storagedriver.PathNotFoundError); !ok {
+				return err
+			}
+		}
+		entries = entries[1:]
+	}
+	return nil
+}
+
+// historyEntries returns the paths of the entries of the history of the tag,
+// from the oldest one.
+func (ts *tagStore) historyEntries(ctx context.Context, tag string) ([]string, error) {
+	historyPath, err := pathFor(tagHistoryPathSpec{
+		name: ts.repository.Named().Name(),
+		tag:  tag,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	entries, err := ts.blobStore.driver.List(ctx, historyPath)
+	if err != nil {
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// there is no guarantee for the order
+	sort.Slice(entries, func(i, j int) bool {
+		return path.Base(entries[i]) < path.Base(entries[j])
+	})
+	return entries, nil
+}
@@ -6,6 +6,7 @@ import (
 	"sort"
 
 	"github.com/distribution/distribution/v3"
+	dcontext "github.com/distribution/distribution/v3/context"
 	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
 	"github.com/opencontainers/go-digest"
 )
@@ -56,7 +57,8 @@ func (ts *tagStore) All(ctx context.Context) ([]string, error) {
 }
 
 // Tag tags the digest with the given tag, updating the the store to point at
-// the current tag. The digest must point to a manifest.
+// the current tag. The digest must point to a manifest. Changes of the
+// manifest the tag points to are recorded in its history.
 func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descriptor) error {
 	currentPath, err := pathFor(manifestTagCurrentPathSpec{
 		name: ts.repository.Named().Name(),
@@ -66,6 +68,11 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 		return err
 	}
 
+	var previous digest.Digest
+	if current, err := ts.Get(ctx, tag); err == nil {
+		previous = current.Digest
+	}
+
 	lbs := ts.linkedBlobStore(ctx, tag)
 
 	// Link into the index
@@ -74,7 +81,17 @@ func (ts *tagStore) Tag(ctx context.Context, tag string, desc distribution.Descr
 	}
 
 	// Overwrite the current link
-	return ts.blobStore.link(ctx, currentPath, desc.Digest)
+	if err := ts.blobStore.link(ctx, currentPath, desc.Digest); err != nil {
+		return err
+	}
+
+	if previous != desc.Digest {
+		if err := ts.recordHistory(ctx, tag, previous, desc.Digest); err != nil {
+			// The tag is changed regardless
+			dcontext.GetLogger(ctx).Errorf("error recording the history of tag %s: %v", tag, err)
+		}
+	}
+	return nil
 }
 
 // resolve the current revision for name and tag.
@@ -185,6 +202,7 @@ func (ts *tagStore) ManifestDigests(ctx context.Context, tag string) ([]digest.D
 			blobStore:  ts.blobStore,
 			repository: ts.repository,
 			linkPath:   manifestRevisionLinkPath,
+			index:      ts.repository.registry.linkIndex(ts.repository.Named().Name(), linkIndexRevisions),
 		},
 		repository: ts.repository,
 		ctx:        ctx,
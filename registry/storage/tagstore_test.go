@@ -119,6 +119,58 @@ func TestTagStoreUnTag(t *testing.T) {
 	}
 }
 
+func TestTagHistory(t *testing.T) {
+	env := testTagStore(t)
+	tags := env.ts
+	ctx := env.ctx
+	history, ok := tags.(distribution.TagHistoryProvider)
+	if !ok {
+		t.Fatal("tagStore does not implement TagHistoryProvider interface")
+	}
+
+	if _, err := history.History(ctx, "prod"); err == nil {
+		t.Fatal("expected error getting the history of an unknown tag")
+	}
+
+	d1 := digest.Digest("sha256:aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	d2 := digest.Digest("sha256:bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	for _, dgst := range []digest.Digest{d1, d2, d2, d1} {
+		if err := tags.Tag(ctx, "prod", distribution.Descriptor{Digest: dgst}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := history.History(ctx, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Tagging the current manifest again is not a change
+	expected := []distribution.TagHistoryEntry{
+		{Digest: d1, Previous: d2},
+		{Digest: d2, Previous: d1},
+		{Digest: d1},
+	}
+	if len(entries) != len(expected) {
+		t.Fatalf("unexpected history: %v", entries)
+	}
+	for i, entry := range entries {
+		if entry.Digest != expected[i].Digest || entry.Previous != expected[i].Previous || entry.Timestamp.IsZero() {
+			t.Fatalf("unexpected history entry %d: %v", i, entry)
+		}
+		if i > 0 && entry.Timestamp.After(entries[i-1].Timestamp) {
+			t.Fatalf("history is not ordered from the most recent change: %v", entries)
+		}
+	}
+
+	// The history is kept when the tag is deleted
+	if err := tags.Untag(ctx, "prod"); err != nil {
+		t.Fatal(err)
+	}
+	if entries, err := history.History(ctx, "prod"); err != nil || len(entries) != len(expected) {
+		t.Fatalf("unexpected history of a deleted tag: %v, %v", entries, err)
+	}
+}
+
 func TestTagStoreAll(t *testing.T) {
 	env := testTagStore(t)
 	tagStore := env.ts
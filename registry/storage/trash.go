@@ -0,0 +1,261 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path"
+	"sort"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest"
+	"github.com/distribution/distribution/v3/manifest/manifestlist"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/opencontainers/go-digest"
+)
+
+// ErrManifestNotTrashed is returned when restoring or purging a manifest
+// which is not in the trash.
+var ErrManifestNotTrashed = errors.New("manifest is not in the trash")
+
+// TrashedManifest is a manifest deleted from a repository while the trash is
+// enabled with EnableManifestTrash.
+type TrashedManifest struct {
+	Repository string        `json:"repository"`
+	Digest     digest.Digest `json:"digest"`
+	// Tags are the tags which pointed to the manifest when it was deleted
+	Tags      []string  `json:"tags,omitempty"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+// trash records the manifest in the trash with the tags pointing to it, and
+// unlinks it from the repository.
+func (ms *manifestStore) trash(ctx context.Context, dgst digest.Digest) error {
+	if !ms.repository.deleteEnabled {
+		return distribution.ErrUnsupported
+	}
+	if _, err := ms.blobStore.Stat(ctx, dgst); err != nil {
+		return err
+	}
+
+	name := ms.repository.Named().Name()
+	tags, err := ms.repository.Tags(ctx).Lookup(ctx, distribution.Descriptor{Digest: dgst})
+	if err != nil {
+		return err
+	}
+	p, err := json.Marshal(TrashedManifest{
+		Repository: name,
+		Digest:     dgst,
+		Tags:       tags,
+		DeletedAt:  time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+	recordPath, err := pathFor(trashEntryPathSpec{name: name, digest: dgst})
+	if err != nil {
+		return err
+	}
+
+	// The record is written first, so that the content of the manifest is
+	// kept by garbage collection as soon as it is unlinked
+	if err := ms.repository.driver.PutContent(ctx, recordPath, p); err != nil {
+		return err
+	}
+	if err := ms.blobStore.Delete(ctx, dgst); err != nil {
+		ms.repository.driver.Delete(ctx, path.Dir(recordPath))
+		return err
+	}
+	return nil
+}
+
+// ListTrash returns the manifests in the trash, the oldest deletions first.
+func ListTrash(ctx context.Context, storageDriver driver.StorageDriver) ([]TrashedManifest, error) {
+	trashPath, err := pathFor(trashPathSpec{})
+	if err != nil {
+		return nil, err
+	}
+
+	trashed := []TrashedManifest{}
+	err = storageDriver.Walk(ctx, trashPath, func(fileInfo driver.FileInfo) error {
+		if fileInfo.IsDir() || path.Base(fileInfo.Path()) != "record" {
+			return nil
+		}
+		record, err := readTrashRecord(ctx, storageDriver, fileInfo.Path())
+		if err != nil {
+			return err
+		}
+		trashed = append(trashed, record)
+		return nil
+	})
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return nil, err
+	}
+
+	sort.SliceStable(trashed, func(i, j int) bool {
+		return trashed[i].DeletedAt.Before(trashed[j].DeletedAt)
+	})
+	return trashed, nil
+}
+
+// RestoreManifest links the manifest in the trash back into its repository,
+// and points the tags which pointed to it when it was deleted back to it,
+// unless they were pushed again since. It returns the restored manifest,
+// with the tags restored.
+func RestoreManifest(ctx context.Context, storageDriver driver.StorageDriver, registry distribution.Namespace, repoName string, dgst digest.Digest) (TrashedManifest, error) {
+	recordPath, err := pathFor(trashEntryPathSpec{name: repoName, digest: dgst})
+	if err != nil {
+		return TrashedManifest{}, err
+	}
+	record, err := readTrashRecord(ctx, storageDriver, recordPath)
+	if err != nil {
+		return TrashedManifest{}, err
+	}
+
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return TrashedManifest{}, err
+	}
+	if _, err := storageDriver.Stat(ctx, blobPath); err != nil {
+		return TrashedManifest{}, fmt.Errorf("failed to restore manifest %s of %s: %v", dgst, repoName, err)
+	}
+	revisionPath, err := pathFor(manifestRevisionLinkPathSpec{name: repoName, revision: dgst})
+	if err != nil {
+		return TrashedManifest{}, err
+	}
+	if err := storageDriver.PutContent(ctx, revisionPath, []byte(dgst)); err != nil {
+		return TrashedManifest{}, fmt.Errorf("failed to restore manifest %s of %s: %v", dgst, repoName, err)
+	}
+	// the manifest may have been packed before it was trashed
+	index := &linkIndex{driver: storageDriver, name: repoName, kind: linkIndexRevisions}
+	if err := index.revive(ctx, dgst); err != nil {
+		return TrashedManifest{}, fmt.Errorf("failed to restore manifest %s of %s: %v", dgst, repoName, err)
+	}
+
+	named, err := reference.WithName(repoName)
+	if err != nil {
+		return TrashedManifest{}, err
+	}
+	repository, err := registry.Repository(ctx, named)
+	if err != nil {
+		return TrashedManifest{}, err
+	}
+	tagService := repository.Tags(ctx)
+	restored := record
+	restored.Tags = nil
+	for _, tag := range record.Tags {
+		desc, err := tagService.Get(ctx, tag)
+		if err == nil && desc.Digest != dgst {
+			// pushed again since the manifest was deleted
+			continue
+		} else if _, ok := err.(distribution.ErrTagUnknown); ok {
+			if err := tagService.Tag(ctx, tag, distribution.Descriptor{Digest: dgst}); err != nil {
+				return TrashedManifest{}, fmt.Errorf("failed to restore tag %s of %s: %v", tag, repoName, err)
+			}
+		} else if err != nil {
+			return TrashedManifest{}, err
+		}
+		restored.Tags = append(restored.Tags, tag)
+	}
+
+	if err := storageDriver.Delete(ctx, path.Dir(recordPath)); err != nil {
+		return TrashedManifest{}, err
+	}
+	return restored, nil
+}
+
+// PurgeTrash removes the manifest from the trash. Its content is deleted by
+// the next garbage collection.
+func PurgeTrash(ctx context.Context, storageDriver driver.StorageDriver, repoName string, dgst digest.Digest) error {
+	recordPath, err := pathFor(trashEntryPathSpec{name: repoName, digest: dgst})
+	if err != nil {
+		return err
+	}
+	if _, err := storageDriver.Stat(ctx, recordPath); err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return ErrManifestNotTrashed
+		}
+		return err
+	}
+	return storageDriver.Delete(ctx, path.Dir(recordPath))
+}
+
+// ExpireTrash purges the manifests deleted before the retention window, and
+// returns them.
+func ExpireTrash(ctx context.Context, storageDriver driver.StorageDriver, retention time.Duration) ([]TrashedManifest, error) {
+	trashed, err := ListTrash(ctx, storageDriver)
+	if err != nil {
+		return nil, err
+	}
+
+	var expired []TrashedManifest
+	now := time.Now()
+	for _, record := range trashed {
+		if now.Sub(record.DeletedAt) < retention {
+			break
+		}
+		if err := PurgeTrash(ctx, storageDriver, record.Repository, record.Digest); err != nil && err != ErrManifestNotTrashed {
+			return expired, err
+		}
+		expired = append(expired, record)
+	}
+	return expired, nil
+}
+
+func readTrashRecord(ctx context.Context, storageDriver driver.StorageDriver, recordPath string) (TrashedManifest, error) {
+	p, err := storageDriver.GetContent(ctx, recordPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return TrashedManifest{}, ErrManifestNotTrashed
+		}
+		return TrashedManifest{}, err
+	}
+	var record TrashedManifest
+	if err := json.Unmarshal(p, &record); err != nil {
+		return TrashedManifest{}, fmt.Errorf("invalid trash record %s: %v", recordPath, err)
+	}
+	return record, nil
+}
+
+// trashedReferences returns the blobs referenced by the manifest in the
+// trash, including those of the children of image indexes. Content which is
+// no longer stored is skipped.
+func trashedReferences(ctx context.Context, storageDriver driver.StorageDriver, dgst digest.Digest) ([]digest.Digest, error) {
+	blobPath, err := pathFor(blobDataPathSpec{digest: dgst})
+	if err != nil {
+		return nil, err
+	}
+	payload, err := storageDriver.GetContent(ctx, blobPath)
+	if err != nil {
+		if _, ok := err.(driver.PathNotFoundError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var versioned manifest.Versioned
+	if err := json.Unmarshal(payload, &versioned); err != nil {
+		return nil, err
+	}
+	m, _, err := distribution.UnmarshalManifest(versioned.MediaType, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	_, isIndex := m.(*manifestlist.DeserializedManifestList)
+	var references []digest.Digest
+	for _, desc := range m.References() {
+		references = append(references, desc.Digest)
+		if isIndex {
+			children, err := trashedReferences(ctx, storageDriver, desc.Digest)
+			if err != nil {
+				return nil, err
+			}
+			references = append(references, children...)
+		}
+	}
+	return references, nil
+}
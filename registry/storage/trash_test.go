@@ -0,0 +1,131 @@
+package storage
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/storage/driver/inmemory"
+)
+
+func TestManifestTrash(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableManifestTrash)
+	repo := makeRepository(t, registry, "komnenos")
+	manifests := makeManifestService(t, repo)
+
+	image1 := uploadRandomSchema2Image(t, repo)
+	image2 := uploadRandomSchema2Image(t, repo)
+	if err := repo.Tags(ctx).Tag(ctx, "latest", distribution.Descriptor{Digest: image1.manifestDigest}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := manifests.Delete(ctx, image1.manifestDigest); err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+	if err := manifests.Delete(ctx, image2.manifestDigest); err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+	if exists, err := manifests.Exists(ctx, image1.manifestDigest); err != nil || exists {
+		t.Fatalf("deleted manifest exists: %t, %v", exists, err)
+	}
+
+	trashed, err := ListTrash(ctx, inmemoryDriver)
+	if err != nil {
+		t.Fatalf("unexpected error listing the trash: %v", err)
+	}
+	if len(trashed) != 2 || trashed[0].Digest != image1.manifestDigest || trashed[1].Digest != image2.manifestDigest {
+		t.Fatalf("unexpected trash: %v", trashed)
+	}
+	if trashed[0].Repository != "komnenos" || !reflect.DeepEqual(trashed[0].Tags, []string{"latest"}) {
+		t.Fatalf("unexpected trashed manifest: %v", trashed[0])
+	}
+
+	// The content of the manifests in the trash is kept
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs := allBlobs(t, registry)
+	for _, im := range []image{image1, image2} {
+		if _, ok := blobs[im.manifestDigest]; !ok {
+			t.Fatalf("trashed manifest %s is missing", im.manifestDigest)
+		}
+		for layer := range im.layers {
+			if _, ok := blobs[layer]; !ok {
+				t.Fatalf("layer %s of trashed manifest is missing", layer)
+			}
+		}
+	}
+
+	// The handler of manifest deletions untags the manifest
+	if err := repo.Tags(ctx).Untag(ctx, "latest"); err != nil {
+		t.Fatal(err)
+	}
+	restored, err := RestoreManifest(ctx, inmemoryDriver, registry, "komnenos", image1.manifestDigest)
+	if err != nil {
+		t.Fatalf("unexpected error restoring manifest: %v", err)
+	}
+	if !reflect.DeepEqual(restored.Tags, []string{"latest"}) {
+		t.Fatalf("unexpected restored tags: %v", restored.Tags)
+	}
+	if _, err := manifests.Get(ctx, image1.manifestDigest); err != nil {
+		t.Fatalf("restored manifest is missing: %v", err)
+	}
+	if desc, err := repo.Tags(ctx).Get(ctx, "latest"); err != nil || desc.Digest != image1.manifestDigest {
+		t.Fatalf("restored tag points to %s: %v", desc.Digest, err)
+	}
+	if _, err := RestoreManifest(ctx, inmemoryDriver, registry, "komnenos", image1.manifestDigest); err != ErrManifestNotTrashed {
+		t.Fatalf("expected ErrManifestNotTrashed restoring twice, got %v", err)
+	}
+
+	if err := PurgeTrash(ctx, inmemoryDriver, "komnenos", image2.manifestDigest); err != nil {
+		t.Fatalf("unexpected error purging manifest: %v", err)
+	}
+	if err := PurgeTrash(ctx, inmemoryDriver, "komnenos", image2.manifestDigest); err != ErrManifestNotTrashed {
+		t.Fatalf("expected ErrManifestNotTrashed purging twice, got %v", err)
+	}
+	if err := MarkAndSweep(ctx, inmemoryDriver, registry, GCOpts{}); err != nil {
+		t.Fatalf("Failed mark and sweep: %v", err)
+	}
+	blobs = allBlobs(t, registry)
+	if _, ok := blobs[image2.manifestDigest]; ok {
+		t.Fatalf("purged manifest %s was not collected", image2.manifestDigest)
+	}
+	if _, ok := blobs[image1.manifestDigest]; !ok {
+		t.Fatalf("restored manifest %s was collected", image1.manifestDigest)
+	}
+}
+
+func TestExpireTrash(t *testing.T) {
+	ctx := context.Background()
+	inmemoryDriver := inmemory.New()
+
+	registry := createRegistry(t, inmemoryDriver, EnableManifestTrash)
+	repo := makeRepository(t, registry, "komnenos")
+	manifests := makeManifestService(t, repo)
+
+	image := uploadRandomSchema2Image(t, repo)
+	if err := manifests.Delete(ctx, image.manifestDigest); err != nil {
+		t.Fatalf("unexpected error deleting manifest: %v", err)
+	}
+
+	expired, err := ExpireTrash(ctx, inmemoryDriver, time.Hour)
+	if err != nil || len(expired) != 0 {
+		t.Fatalf("unexpected expired manifests within the retention: %v, %v", expired, err)
+	}
+	expired, err = ExpireTrash(ctx, inmemoryDriver, time.Nanosecond)
+	if err != nil {
+		t.Fatalf("unexpected error expiring the trash: %v", err)
+	}
+	if len(expired) != 1 || expired[0].Digest != image.manifestDigest {
+		t.Fatalf("unexpected expired manifests: %v", expired)
+	}
+	trashed, err := ListTrash(ctx, inmemoryDriver)
+	if err != nil || len(trashed) != 0 {
+		t.Fatalf("unexpected trash after expiry: %v, %v", trashed, err)
+	}
+}
@@ -81,7 +81,27 @@ func (v Vacuum) RemoveManifest(name string, dgst digest.Digest, tags []string) e
 		return err
 	}
 	dcontext.GetLogger(v.ctx).Infof("deleting manifest: %s", manifestPath)
-	return v.driver.Delete(v.ctx, manifestPath)
+	err = v.driver.Delete(v.ctx, manifestPath)
+	if _, ok := err.(driver.PathNotFoundError); err != nil && !ok {
+		return err
+	}
+
+	// the revision may be packed in the index of the repository
+	shardPath, shardErr := pathFor(linkIndexShardPathSpec{name: name, kind: linkIndexRevisions, shard: linkIndexShard(dgst)})
+	if shardErr != nil {
+		return shardErr
+	}
+	if _, shardErr = v.driver.Stat(v.ctx, shardPath); shardErr != nil {
+		if _, ok := shardErr.(driver.PathNotFoundError); ok {
+			return err
+		}
+		return shardErr
+	}
+	tombstonePath, err := pathFor(linkIndexTombstonePathSpec{name: name, kind: linkIndexRevisions, digest: dgst})
+	if err != nil {
+		return err
+	}
+	return v.driver.PutContent(v.ctx, tombstonePath, []byte(dgst))
 }
 
 // RemoveRepository removes a repository directory from the
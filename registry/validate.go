@@ -0,0 +1,173 @@
+package registry
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/distribution/distribution/v3/configuration"
+	"github.com/distribution/distribution/v3/registry/auth/public"
+	"github.com/distribution/distribution/v3/registry/handlers"
+	"github.com/distribution/distribution/v3/registry/proxy"
+	"github.com/distribution/distribution/v3/registry/ratelimit"
+	storagedriver "github.com/distribution/distribution/v3/registry/storage/driver"
+	"github.com/distribution/distribution/v3/registry/storage/driver/factory"
+	"github.com/distribution/distribution/v3/registry/storage/metadata/postgres"
+	"gopkg.in/yaml.v2"
+)
+
+// Severities of the problems found in a configuration.
+const (
+	severityError   = "error"
+	severityWarning = "warning"
+)
+
+// problem is a problem found in a configuration by `config validate`.
+type problem struct {
+	Severity string `json:"severity"`
+	// Field is the option of the configuration with the problem, if known
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (p problem) String() string {
+	if p.Field == "" {
+		return p.Severity + ": " + p.Message
+	}
+	return p.Severity + ": " + p.Field + ": " + p.Message
+}
+
+// validateConfiguration parses the configuration in as the registry does,
+// with the environment overrides, and checks the options the parser does
+// not, such as unknown keys and the parameters of the storage driver. With
+// probe, the storage driver is also queried.
+func validateConfiguration(ctx context.Context, in []byte, probe bool) []problem {
+	var problems []problem
+	report := func(severity, field string, err error) {
+		// some errors are already prefixed with the field
+		message := strings.TrimPrefix(err.Error(), field+": ")
+		problems = append(problems, problem{Severity: severity, Field: field, Message: message})
+	}
+
+	config, err := configuration.Parse(bytes.NewReader(in))
+	if err != nil {
+		report(severityError, "", err)
+		return problems
+	}
+
+	// unknown keys are ignored by the parser, so that typos go unnoticed
+	if err := yaml.UnmarshalStrict(in, new(configuration.Configuration)); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			report(severityError, "", err)
+		} else {
+			for _, message := range typeErr.Errors {
+				// the types of the sections are anonymous structs, too long
+				// to be of any help
+				if field, _, ok := strings.Cut(message, " not found in type "); ok {
+					// the keys suffixed with _file are resolved by the parser
+					if strings.HasSuffix(field, "_file") {
+						continue
+					}
+					message = field + " is unknown"
+				}
+				report(severityError, "", errors.New(message))
+			}
+		}
+	}
+
+	switch config.Log.Formatter {
+	case "", "text", "json", "logstash":
+	default:
+		report(severityError, "log.formatter", fmt.Errorf("unsupported logging formatter %q", config.Log.Formatter))
+	}
+
+	if config.HTTP.Secret == "" {
+		report(severityWarning, "http.secret", errors.New("not set, a random secret is generated which breaks uploads across several registries behind a load balancer"))
+	}
+	for _, file := range []struct{ field, path string }{
+		{"http.tls.certificate", config.HTTP.TLS.Certificate},
+		{"http.tls.key", config.HTTP.TLS.Key},
+	} {
+		if file.path == "" {
+			continue
+		}
+		if _, err := os.Stat(file.path); err != nil {
+			report(severityError, file.field, err)
+		}
+	}
+
+	if _, _, err := configureListeners(config, http.NotFoundHandler()); err != nil {
+		report(severityError, "http.listeners", err)
+	}
+	for _, l := range []struct {
+		field  string
+		config configuration.Listener
+	}{
+		{"http.listeners.metrics", config.HTTP.Listeners.Metrics},
+		{"http.listeners.admin", config.HTTP.Listeners.Admin},
+	} {
+		if l.config.Addr == "" {
+			continue
+		}
+		if _, err := listenerTLSConfig(l.config.TLS); err != nil {
+			report(severityError, l.field+".tls", err)
+		}
+	}
+
+	storageField := "storage." + config.Storage.Type()
+	driver, err := factory.Create(config.Storage.Type(), config.Storage.Parameters())
+	if err != nil {
+		report(severityError, storageField, err)
+	} else if probe {
+		_, err := driver.Stat(ctx, "/")
+		if _, ok := err.(storagedriver.PathNotFoundError); ok {
+			// the storage is reachable, but still empty
+			err = nil
+		}
+		if err != nil {
+			report(severityError, storageField, fmt.Errorf("probe failed: %v", err))
+		}
+	}
+
+	if _, err := handlers.LayoutOptions(config); err != nil {
+		report(severityError, "storage.layout", err)
+	}
+
+	switch config.Metadata.Database {
+	case "":
+	case "postgres":
+		if err := postgres.Validate(config.Metadata); err != nil {
+			report(severityError, "metadata", err)
+		}
+	default:
+		report(severityError, "metadata.database", fmt.Errorf("unsupported metadata database %q", config.Metadata.Database))
+	}
+
+	if config.Proxy.RemoteURL != "" || config.Proxy.EnableNamespaces {
+		for _, err := range proxy.Validate(config.Proxy) {
+			report(severityError, "proxy", err)
+		}
+		if !config.Proxy.EnableNamespaces && len(config.Proxy.NamespaceCredentials) > 0 {
+			report(severityWarning, "proxy.credentials", errors.New("ignored unless enablenamespaces is set"))
+		}
+	}
+
+	if len(config.Public.Repositories) > 0 {
+		if _, err := public.New(config.Public); err != nil {
+			report(severityError, "public", err)
+		}
+	}
+
+	if config.RateLimit.Requests != 0 || config.RateLimit.Uploads != 0 {
+		if _, err := ratelimit.New(config.RateLimit); err != nil {
+			report(severityError, "ratelimit", err)
+		}
+	}
+
+	return problems
+}
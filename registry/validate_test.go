@@ -0,0 +1,61 @@
+package registry
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestValidateConfiguration(t *testing.T) {
+	valid := []byte(`
+version: 0.1
+storage:
+  inmemory: {}
+http:
+  secret: secret
+proxy:
+  remoteurl: https://registry-1.docker.io
+`)
+	if problems := validateConfiguration(context.Background(), valid, true); len(problems) != 0 {
+		t.Fatalf("unexpected problems: %v", problems)
+	}
+
+	invalid := []byte(`
+version: 0.1
+log:
+  formatter: xml
+storage:
+  inmemory: {}
+http:
+  secrte: secret
+proxy:
+  enablenamespaces: true
+  credentials:
+    https://ghcr.io:
+      username: bob
+    ftp://quay.io:
+      username: bob
+      password: secret
+ratelimit:
+  requests: -1
+metadata:
+  database: postgres
+  dsn: postgres://registry@localhost/registry
+`)
+	expected := []problem{
+		{Severity: severityError, Message: "line 8: field secrte is unknown"},
+		{Severity: severityError, Field: "log.formatter", Message: `unsupported logging formatter "xml"`},
+		{Severity: severityWarning, Field: "http.secret", Message: "not set, a random secret is generated which breaks uploads across several registries behind a load balancer"},
+		{Severity: severityError, Field: "metadata", Message: `metadata database: no database/sql driver "postgres" is linked into the binary`},
+		{Severity: severityError, Field: "proxy", Message: `credentials: invalid upstream "ftp://quay.io": not an http or https URL`},
+		{Severity: severityError, Field: "proxy", Message: "credentials for https://ghcr.io: username and password must be set together"},
+		{Severity: severityError, Field: "ratelimit", Message: "requests, burst and uploads must not be negative"},
+	}
+	if problems := validateConfiguration(context.Background(), invalid, false); !reflect.DeepEqual(problems, expected) {
+		t.Fatalf("unexpected problems:\n%v\nexpected:\n%v", problems, expected)
+	}
+
+	if problems := validateConfiguration(context.Background(), []byte("version: 0.1\n"), false); len(problems) != 1 || problems[0].Message != "no storage configuration provided" {
+		t.Fatalf("unexpected problems of a configuration without storage: %v", problems)
+	}
+}
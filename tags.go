@@ -2,6 +2,7 @@ package distribution
 
 import (
 	"context"
+	"time"
 
 	"github.com/opencontainers/go-digest"
 )
@@ -35,3 +36,23 @@ type TagManifestsProvider interface {
 	// includes currently linked digest. There is no ordering guaranteed
 	ManifestDigests(ctx context.Context, tag string) ([]digest.Digest, error)
 }
+
+// TagHistoryProvider provides method to retrieve the changes of the manifest
+// a tag points to
+type TagHistoryProvider interface {
+	// History returns the changes of the manifest the tag points to, from the
+	// most recent one. The history is kept when the tag is deleted.
+	History(ctx context.Context, tag string) ([]TagHistoryEntry, error)
+}
+
+// TagHistoryEntry records a tag being pointed to a manifest.
+type TagHistoryEntry struct {
+	// Digest is the manifest the tag was pointed to
+	Digest digest.Digest `json:"digest"`
+
+	// Previous is the manifest the tag pointed to before, if any
+	Previous digest.Digest `json:"previous,omitempty"`
+
+	// Timestamp is when the tag was pointed to the manifest
+	Timestamp time.Time `json:"timestamp"`
+}
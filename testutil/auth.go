@@ -0,0 +1,19 @@
+package testutil
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+
+	dcontext "github.com/distribution/distribution/v3/context"
+	"github.com/distribution/distribution/v3/registry/auth"
+)
+
+// Authorize asks the access controller to authorize a request to the base
+// of the API for the access records. setCredentials sets the credentials of
+// the request, such as its Authorization header or its TLS state.
+func Authorize(ac auth.AccessController, setCredentials func(r *http.Request), accessRecords ...auth.Access) (context.Context, error) {
+	req := httptest.NewRequest(http.MethodGet, "http://registry.example.com/v2/", nil)
+	setCredentials(req)
+	return ac.Authorized(dcontext.WithRequest(context.Background(), req), accessRecords...)
+}
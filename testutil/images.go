@@ -0,0 +1,118 @@
+package testutil
+
+import (
+	"context"
+	"crypto/rand"
+	"strings"
+	"testing"
+
+	"github.com/distribution/distribution/v3"
+	"github.com/distribution/distribution/v3/manifest/ocischema"
+	"github.com/distribution/distribution/v3/reference"
+	"github.com/opencontainers/go-digest"
+	v1 "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Image is an image pushed to a repository by PushImage.
+type Image struct {
+	Manifest   distribution.Manifest
+	Descriptor distribution.Descriptor // describes the manifest
+	Config     distribution.Descriptor
+	Layers     []distribution.Descriptor
+}
+
+// Digests returns the digests of the manifest, the config and the layers of
+// the image.
+func (image Image) Digests() []digest.Digest {
+	digests := []digest.Digest{image.Descriptor.Digest, image.Config.Digest}
+	for _, layer := range image.Layers {
+		digests = append(digests, layer.Digest)
+	}
+	return digests
+}
+
+// Repository returns the named repository of the registry.
+func Repository(t testing.TB, registry distribution.Namespace, name string) distribution.Repository {
+	t.Helper()
+	named, err := reference.WithName(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	repo, err := registry.Repository(context.Background(), named)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return repo
+}
+
+// PushImage pushes an OCI image of two small random layers to the
+// repository, and tags it with the tags.
+func PushImage(t testing.TB, repo distribution.Repository, tags ...string) Image {
+	t.Helper()
+	return PushAnnotatedImage(t, repo, nil, tags...)
+}
+
+// PushAnnotatedImage pushes an OCI image of two small random layers with the
+// annotations to the repository, and tags it with the tags.
+func PushAnnotatedImage(t testing.TB, repo distribution.Repository, annotations map[string]string, tags ...string) Image {
+	t.Helper()
+	ctx := context.Background()
+	blobs := repo.Blobs(ctx)
+
+	// Small layers keep the in-memory storage fast
+	var layers []distribution.Descriptor
+	var diffIDs []string
+	for i := 0; i < 2; i++ {
+		content := make([]byte, 1024)
+		if _, err := rand.Read(content); err != nil {
+			t.Fatal(err)
+		}
+		layer, err := blobs.Put(ctx, v1.MediaTypeImageLayerGzip, content)
+		if err != nil {
+			t.Fatal(err)
+		}
+		layer.MediaType = v1.MediaTypeImageLayerGzip
+		layers = append(layers, layer)
+		diffIDs = append(diffIDs, `"`+layer.Digest.String()+`"`)
+	}
+
+	// the config of each image is its own, as it lists its layers
+	config := `{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[` + strings.Join(diffIDs, ",") + `]}}`
+	builder := ocischema.NewManifestBuilder(blobs, []byte(config), annotations)
+	for _, layer := range layers {
+		if err := builder.AppendReference(layer); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest, err := builder.Build(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	manifests, err := repo.Manifests(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dgst, err := manifests.Put(ctx, manifest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, payload, err := manifest.Payload()
+	if err != nil {
+		t.Fatal(err)
+	}
+	desc := distribution.Descriptor{MediaType: v1.MediaTypeImageManifest, Digest: dgst, Size: int64(len(payload))}
+	for _, tag := range tags {
+		if err := repo.Tags(ctx).Tag(ctx, tag, desc); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	references := manifest.References()
+	return Image{
+		Manifest:   manifest,
+		Descriptor: desc,
+		Config:     references[0],
+		Layers:     references[1:],
+	}
+}
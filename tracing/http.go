@@ -0,0 +1,77 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// StartRequest starts the span of a request served by the registry,
+// continuing the trace of its traceparent header. It returns a nil span if
+// no tracer is set.
+func StartRequest(ctx context.Context, r *http.Request) (context.Context, *Span) {
+	t := currentTracer()
+	if t == nil {
+		return ctx, nil
+	}
+	ctx = propagator.Extract(ctx, propagation.HeaderCarrier(r.Header))
+	return newSpan(ctx, t.tracer(), "HTTP "+r.Method, trace.SpanKindServer, []Attribute{
+		String("http.method", r.Method),
+		String("http.target", r.URL.RequestURI()),
+		String("http.user_agent", r.UserAgent()),
+		String("net.peer.addr", r.RemoteAddr),
+	})
+}
+
+// EndRequest ends the span of a request with the status of its response.
+func EndRequest(span *Span, status int) {
+	if span == nil {
+		return
+	}
+	span.SetAttributes(Int("http.status_code", int64(status)))
+	var err error
+	if status >= http.StatusInternalServerError {
+		err = fmt.Errorf("%d %s", status, http.StatusText(status))
+	}
+	span.End(err)
+}
+
+// Transport returns a round tripper tracing the requests sent with rt
+// within a span, and propagating their trace in their traceparent header.
+func Transport(rt http.RoundTripper) http.RoundTripper {
+	return &transport{rt: rt}
+}
+
+type transport struct {
+	rt http.RoundTripper
+}
+
+func (t *transport) RoundTrip(r *http.Request) (*http.Response, error) {
+	u := *r.URL
+	u.User = nil
+	ctx, span := start(r.Context(), "HTTP "+r.Method, trace.SpanKindClient, []Attribute{
+		String("http.method", r.Method),
+		String("http.url", u.String()),
+		String("net.peer.name", r.URL.Hostname()),
+	})
+	if span == nil {
+		return t.rt.RoundTrip(r)
+	}
+
+	r = r.Clone(ctx)
+	propagator.Inject(ctx, propagation.HeaderCarrier(r.Header))
+	resp, err := t.rt.RoundTrip(r)
+	if err != nil {
+		span.End(err)
+		return nil, err
+	}
+	span.SetAttributes(Int("http.status_code", int64(resp.StatusCode)))
+	if resp.StatusCode >= http.StatusInternalServerError {
+		err = fmt.Errorf("%s", resp.Status)
+	}
+	span.End(err)
+	return resp, nil
+}
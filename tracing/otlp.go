@@ -0,0 +1,135 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/distribution/distribution/v3/version"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+const defaultServiceName = "registry"
+
+// NewFromEnv returns the tracer configured by the standard environment
+// variables of OpenTelemetry, or nil if the SDK is disabled:
+//
+//   - OTEL_SDK_DISABLED disables tracing if true.
+//   - OTEL_SERVICE_NAME is the name of the service, "registry" by default, and
+//     OTEL_RESOURCE_ATTRIBUTES the other attributes of its resource.
+//   - OTEL_TRACES_SAMPLER and OTEL_TRACES_SAMPLER_ARG configure the sampler:
+//     always_on, always_off, traceidratio, or their parentbased_ variants,
+//     parentbased_always_on by default.
+//   - OTEL_TRACES_EXPORTER is otlp, the default, or none.
+//   - OTEL_EXPORTER_OTLP_ENDPOINT, OTEL_EXPORTER_OTLP_HEADERS,
+//     OTEL_EXPORTER_OTLP_TIMEOUT, OTEL_EXPORTER_OTLP_COMPRESSION,
+//     OTEL_EXPORTER_OTLP_CERTIFICATE, OTEL_EXPORTER_OTLP_CLIENT_CERTIFICATE
+//     and OTEL_EXPORTER_OTLP_CLIENT_KEY configure the exporter of the SDK, and
+//     their OTEL_EXPORTER_OTLP_TRACES_ variants override them. Only the
+//     http/protobuf protocol is supported.
+//   - OTEL_BSP_ variables configure the batches of the exported spans.
+func NewFromEnv() (*Tracer, error) {
+	if disabled, _ := strconv.ParseBool(os.Getenv("OTEL_SDK_DISABLED")); disabled {
+		return nil, nil
+	}
+
+	sampler, err := samplerFromEnv()
+	if err != nil {
+		return nil, err
+	}
+	res, err := resource.New(context.Background(),
+		resource.WithAttributes(
+			attribute.String("service.name", defaultServiceName),
+			attribute.String("service.version", version.Version),
+		),
+		resource.WithTelemetrySDK(),
+		// the variables override the defaults
+		resource.WithFromEnv(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: invalid resource: %v", err)
+	}
+	options := []sdktrace.TracerProviderOption{
+		sdktrace.WithSampler(sampler),
+		sdktrace.WithResource(res),
+	}
+
+	switch exporter := os.Getenv("OTEL_TRACES_EXPORTER"); exporter {
+	case "", "otlp":
+		e, err := otlpExporterFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		options = append(options, sdktrace.WithBatcher(e))
+	case "none":
+	default:
+		return nil, fmt.Errorf("tracing: unsupported OTEL_TRACES_EXPORTER %q", exporter)
+	}
+	return &Tracer{provider: sdktrace.NewTracerProvider(options...)}, nil
+}
+
+// samplerFromEnv returns the sampler of the variables, which the SDK would
+// otherwise replace by its default when they are invalid.
+func samplerFromEnv() (sdktrace.Sampler, error) {
+	name := os.Getenv("OTEL_TRACES_SAMPLER")
+	parentBased := name == "" || strings.HasPrefix(name, "parentbased_")
+	var root sdktrace.Sampler
+	switch strings.TrimPrefix(name, "parentbased_") {
+	case "", "always_on":
+		root = sdktrace.AlwaysSample()
+	case "always_off":
+		root = sdktrace.NeverSample()
+	case "traceidratio":
+		ratio := 1.0
+		if arg := os.Getenv("OTEL_TRACES_SAMPLER_ARG"); arg != "" {
+			var err error
+			ratio, err = strconv.ParseFloat(arg, 64)
+			if err != nil || ratio < 0 || ratio > 1 {
+				return nil, fmt.Errorf("tracing: invalid OTEL_TRACES_SAMPLER_ARG %q", arg)
+			}
+		}
+		root = sdktrace.TraceIDRatioBased(ratio)
+	default:
+		return nil, fmt.Errorf("tracing: unsupported OTEL_TRACES_SAMPLER %q", name)
+	}
+	if parentBased {
+		return sdktrace.ParentBased(root), nil
+	}
+	return root, nil
+}
+
+// getenv returns the value of the OTEL_EXPORTER_OTLP_TRACES_ variable, or
+// else of the OTEL_EXPORTER_OTLP_ variable.
+func getenv(name string) string {
+	if value := os.Getenv("OTEL_EXPORTER_OTLP_TRACES_" + name); value != "" {
+		return value
+	}
+	return os.Getenv("OTEL_EXPORTER_OTLP_" + name)
+}
+
+// otlpExporterFromEnv returns the OTLP/HTTP exporter of the SDK, which reads
+// the other variables.
+func otlpExporterFromEnv() (sdktrace.SpanExporter, error) {
+	switch protocol := getenv("PROTOCOL"); protocol {
+	case "", "http/protobuf":
+	default:
+		return nil, fmt.Errorf("tracing: unsupported OTEL_EXPORTER_OTLP_PROTOCOL %q", protocol)
+	}
+
+	var options []otlptracehttp.Option
+	if getenv("ENDPOINT") == "" {
+		// the default endpoint of OTLP/HTTP, http://localhost:4318, is not
+		// served with TLS
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	e, err := otlptracehttp.New(context.Background(), options...)
+	if err != nil {
+		return nil, fmt.Errorf("tracing: unable to create the exporter: %v", err)
+	}
+	return e, nil
+}
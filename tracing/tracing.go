@@ -0,0 +1,150 @@
+// Package tracing traces the requests served by the registry with
+// OpenTelemetry, and the storage driver operations and upstream calls they
+// make, exporting the spans to a collector with the OTLP/HTTP protocol.
+//
+// The traces of the clients are continued from the W3C traceparent headers
+// of their requests, and propagated to the upstreams of pull through caches.
+// The tracer and its exporter are configured with the standard OTEL_
+// environment variables, see NewFromEnv.
+//
+// Spans are only created when a tracer is set, and, but for the spans of the
+// requests, within a span: the background work of the registry is not
+// traced.
+package tracing
+
+import (
+	"context"
+	"sync/atomic"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// scopeName is the name of the instrumentation scope of the spans.
+const scopeName = "github.com/distribution/distribution/v3/tracing"
+
+// propagator propagates the traces in the W3C traceparent and tracestate
+// headers.
+var propagator = propagation.TraceContext{}
+
+// Attribute is an attribute of a span.
+type Attribute = attribute.KeyValue
+
+// String returns a string attribute.
+func String(key, value string) Attribute {
+	return attribute.String(key, value)
+}
+
+// Int returns an integer attribute.
+func Int(key string, value int64) Attribute {
+	return attribute.Int64(key, value)
+}
+
+// Span is an operation of a trace. Its methods do nothing on a nil span, so
+// that the callers don't have to check whether tracing is enabled.
+type Span struct {
+	span trace.Span
+}
+
+// SetName renames the span, such as once the route of a request is known.
+func (s *Span) SetName(name string) {
+	if s == nil {
+		return
+	}
+	s.span.SetName(name)
+}
+
+// SetAttributes adds attributes to the span.
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.span.SetAttributes(attributes...)
+}
+
+// End ends the span, which failed if err is not nil.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	if err != nil {
+		s.span.RecordError(err)
+		s.span.SetStatus(codes.Error, err.Error())
+	}
+	s.span.End()
+}
+
+// TraceID returns the ID of the trace of the span, in hex.
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.span.SpanContext().TraceID().String()
+}
+
+type spanKey struct{}
+
+// SpanFromContext returns the span of ctx, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// Start starts a span in the span of ctx. It returns a nil span if ctx has
+// no span.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	return start(ctx, name, trace.SpanKindInternal, attributes)
+}
+
+func start(ctx context.Context, name string, kind trace.SpanKind, attributes []Attribute) (context.Context, *Span) {
+	parent := SpanFromContext(ctx)
+	if parent == nil {
+		return ctx, nil
+	}
+	tracer := parent.span.TracerProvider().Tracer(scopeName)
+	return newSpan(ctx, tracer, name, kind, attributes)
+}
+
+// newSpan starts a span in the trace of the span of ctx, if any.
+func newSpan(ctx context.Context, tracer trace.Tracer, name string, kind trace.SpanKind, attributes []Attribute) (context.Context, *Span) {
+	ctx, span := tracer.Start(ctx, name, trace.WithSpanKind(kind), trace.WithAttributes(attributes...))
+	s := &Span{span: span}
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// Tracer creates the spans of the traces, sampled by its sampler, and
+// exports them.
+type Tracer struct {
+	provider *sdktrace.TracerProvider
+}
+
+// Shutdown exports the pending spans.
+func (t *Tracer) Shutdown(ctx context.Context) error {
+	if t == nil {
+		return nil
+	}
+	return t.provider.Shutdown(ctx)
+}
+
+func (t *Tracer) tracer() trace.Tracer {
+	return t.provider.Tracer(scopeName)
+}
+
+var global atomic.Value // of tracerHolder
+
+type tracerHolder struct {
+	tracer *Tracer
+}
+
+// SetTracer sets the tracer of the registry, disabling tracing if nil.
+func SetTracer(t *Tracer) {
+	global.Store(tracerHolder{tracer: t})
+}
+
+func currentTracer() *Tracer {
+	holder, _ := global.Load().(tracerHolder)
+	return holder.tracer
+}
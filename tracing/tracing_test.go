@@ -0,0 +1,185 @@
+package tracing
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	coltracepb "go.opentelemetry.io/proto/otlp/collector/trace/v1"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRequestSpans(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	SetTracer(&Tracer{provider: sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))})
+	defer SetTracer(nil)
+
+	var upstreamTraceparent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		upstreamTraceparent = r.Header.Get("traceparent")
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer upstream.Close()
+
+	r := httptest.NewRequest(http.MethodGet, "/v2/foo/blobs/sha256:abc", nil)
+	r.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	ctx, span := StartRequest(context.Background(), r)
+	if span.TraceID() != "0af7651916cd43dd8448eb211c80319c" {
+		t.Fatalf("unexpected trace of the request: %s", span.TraceID())
+	}
+
+	fetchCtx, fetch := Start(ctx, "proxy.FetchBlob", String("registry.digest", "sha256:abc"))
+	req, _ := http.NewRequestWithContext(fetchCtx, http.MethodGet, upstream.URL, nil)
+	resp, err := (&http.Client{Transport: Transport(http.DefaultTransport)}).Do(req)
+	if err != nil {
+		t.Fatalf("unexpected error fetching upstream: %v", err)
+	}
+	resp.Body.Close()
+	fetch.End(nil)
+	EndRequest(span, http.StatusOK)
+
+	spans := rec.Ended()
+	if len(spans) != 3 {
+		t.Fatalf("unexpected spans: %d", len(spans))
+	}
+	client, internal, server := spans[0], spans[1], spans[2]
+	if client.SpanKind() != trace.SpanKindClient || client.Parent().SpanID() != internal.SpanContext().SpanID() || client.Status().Code != codes.Error {
+		t.Fatalf("unexpected client span: %+v", client)
+	}
+	expected := "00-" + client.SpanContext().TraceID().String() + "-" + client.SpanContext().SpanID().String() + "-01"
+	if upstreamTraceparent != expected {
+		t.Fatalf("unexpected traceparent sent upstream: %q, expected %q", upstreamTraceparent, expected)
+	}
+	if internal.Parent().SpanID() != server.SpanContext().SpanID() || internal.SpanContext().TraceID() != server.SpanContext().TraceID() {
+		t.Fatalf("unexpected internal span: %+v", internal)
+	}
+	if server.SpanKind() != trace.SpanKindServer || server.Parent().SpanID().String() != "b7ad6b7169203331" || !server.Parent().IsRemote() || server.Status().Code == codes.Error {
+		t.Fatalf("unexpected server span: %+v", server)
+	}
+
+	// without span, nothing is traced
+	if _, span := Start(context.Background(), "background"); span != nil {
+		t.Fatal("unexpected span outside of a request")
+	}
+}
+
+func TestSamplerFromEnv(t *testing.T) {
+	for _, tc := range []struct {
+		sampler, arg string
+		expected     string
+	}{
+		{"", "", sdktrace.ParentBased(sdktrace.AlwaysSample()).Description()},
+		{"always_off", "", sdktrace.NeverSample().Description()},
+		{"traceidratio", "0.5", sdktrace.TraceIDRatioBased(0.5).Description()},
+		{"parentbased_traceidratio", "", sdktrace.ParentBased(sdktrace.TraceIDRatioBased(1)).Description()},
+	} {
+		t.Setenv("OTEL_TRACES_SAMPLER", tc.sampler)
+		t.Setenv("OTEL_TRACES_SAMPLER_ARG", tc.arg)
+		s, err := samplerFromEnv()
+		if err != nil {
+			t.Fatalf("unexpected error with sampler %q: %v", tc.sampler, err)
+		}
+		if s.Description() != tc.expected {
+			t.Errorf("unexpected sampler %s for %q, expected %s", s.Description(), tc.sampler, tc.expected)
+		}
+	}
+
+	t.Setenv("OTEL_TRACES_SAMPLER", "traceidratio")
+	t.Setenv("OTEL_TRACES_SAMPLER_ARG", "2")
+	if _, err := samplerFromEnv(); err == nil {
+		t.Fatal("expected a ratio above 1 to be rejected")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	requests := make(chan *coltracepb.ExportTraceServiceRequest, 1)
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("Authorization") != "Bearer secret" || r.Header.Get("Content-Type") != "application/x-protobuf" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := &coltracepb.ExportTraceServiceRequest{}
+		if err := proto.Unmarshal(body, req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- req
+	}))
+	defer collector.Close()
+
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", collector.URL+"/")
+	t.Setenv("OTEL_EXPORTER_OTLP_HEADERS", "Authorization=Bearer%20secret")
+	t.Setenv("OTEL_SERVICE_NAME", "mirror")
+	tracer, err := NewFromEnv()
+	if err != nil {
+		t.Fatalf("unexpected error creating tracer: %v", err)
+	}
+
+	SetTracer(tracer)
+	defer SetTracer(nil)
+	_, span := StartRequest(context.Background(), httptest.NewRequest(http.MethodGet, "/v2/foo/bar/manifests/latest", nil))
+	span.SetName("GET manifest")
+	span.SetAttributes(String("registry.repository", "foo/bar"))
+	EndRequest(span, http.StatusInternalServerError)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := tracer.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error shutting down: %v", err)
+	}
+
+	var req *coltracepb.ExportTraceServiceRequest
+	select {
+	case req = <-requests:
+	default:
+		t.Fatal("no spans exported")
+	}
+	var serviceName string
+	for _, attribute := range req.ResourceSpans[0].Resource.Attributes {
+		if attribute.Key == "service.name" {
+			serviceName = attribute.Value.GetStringValue()
+		}
+	}
+	if serviceName != "mirror" {
+		t.Fatalf("unexpected service name %q", serviceName)
+	}
+	exported := req.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(exported) != 1 {
+		t.Fatalf("unexpected spans: %+v", exported)
+	}
+	s := exported[0]
+	if trace.TraceID(*(*[16]byte)(s.TraceId)).String() != span.TraceID() || s.Name != "GET manifest" || len(s.ParentSpanId) != 0 || s.Status.GetMessage() != "500 Internal Server Error" {
+		t.Fatalf("unexpected span: %+v", s)
+	}
+}
+
+func TestNewFromEnvErrors(t *testing.T) {
+	for name, value := range map[string]string{
+		"OTEL_EXPORTER_OTLP_PROTOCOL": "grpc",
+		"OTEL_TRACES_SAMPLER":         "sometimes",
+		"OTEL_TRACES_EXPORTER":        "zipkin",
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Setenv(name, value)
+			if _, err := NewFromEnv(); err == nil {
+				t.Fatalf("expected an error with %s=%s", name, value)
+			}
+		})
+	}
+
+	t.Setenv("OTEL_SDK_DISABLED", "true")
+	if tracer, err := NewFromEnv(); tracer != nil || err != nil {
+		t.Fatalf("unexpected tracer when disabled: %v, %v", tracer, err)
+	}
+}